@@ -312,6 +312,7 @@ fn convert_limits(proto_limits: &Option<proto::ResourceLimits>) -> ResourceLimit
             } else {
                 30000
             }),
+            ..ResourceLimits::default()
         },
         None => ResourceLimits::default(),
     }
@@ -160,6 +160,7 @@ impl ConchServer {
             max_memory_bytes: params.max_memory_bytes.unwrap_or(64 * 1024 * 1024),
             max_output_bytes: 1024 * 1024, // 1MB output limit
             timeout: Duration::from_millis(params.timeout_ms.unwrap_or(30000)),
+            ..ResourceLimits::default()
         };
 
         let result = shell
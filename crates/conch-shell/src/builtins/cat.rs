@@ -43,7 +43,7 @@ impl builtins::SimpleCommand for CatCommand {
                         'E' => show_ends = true,
                         's' => squeeze_blank = true,
                         _ => {
-                            writeln!(context.stderr(), "cat: unknown option: -{}", c)?;
+                            diagnostic!(context, "cat", "unknown option: -{}", c)?;
                             return Ok(ExecutionResult::new(1));
                         }
                     }
@@ -75,7 +75,7 @@ impl builtins::SimpleCommand for CatCommand {
                 match std::fs::read(file) {
                     Ok(data) => data,
                     Err(e) => {
-                        writeln!(context.stderr(), "cat: {}: {}", file, e)?;
+                        diagnostic!(context, "cat", "{}: {}", file, e)?;
                         exit_code = 1;
                         continue;
                     }
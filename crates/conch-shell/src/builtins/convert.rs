@@ -0,0 +1,283 @@
+//! yaml2json, json2yaml and toml2json builtins - configuration formats
+//! to and from JSON
+//!
+//! Each reads a file or stdin and writes the converted document, so config
+//! files can be normalized to JSON for `jq` and written back as YAML. A YAML
+//! stream with several documents becomes one JSON value per document, and
+//! several JSON values become a YAML stream separated by `---`.
+
+use std::io::{Read, Write};
+
+use brush_core::{ExecutionContext, ExecutionResult, ShellExtensions, builtins, error};
+use serde::Deserialize;
+
+/// A conversion one of the builtins performs.
+#[derive(Clone, Copy)]
+enum Conversion {
+    YamlToJson,
+    JsonToYaml,
+    TomlToJson,
+}
+
+impl Conversion {
+    fn name(self) -> &'static str {
+        match self {
+            Conversion::YamlToJson => "yaml2json",
+            Conversion::JsonToYaml => "json2yaml",
+            Conversion::TomlToJson => "toml2json",
+        }
+    }
+
+    fn description(self) -> &'static str {
+        match self {
+            Conversion::YamlToJson => "convert YAML to JSON",
+            Conversion::JsonToYaml => "convert JSON to YAML",
+            Conversion::TomlToJson => "convert TOML to JSON",
+        }
+    }
+
+    /// Convert `input`, returning the output to write.
+    fn run(self, input: &str, compact: bool) -> Result<String, String> {
+        match self {
+            Conversion::YamlToJson => {
+                let mut out = String::new();
+                for document in serde_yaml::Deserializer::from_str(input) {
+                    let value = serde_json::Value::deserialize(document)
+                        .map_err(|e| format!("invalid YAML: {}", e))?;
+                    out.push_str(&to_json(&value, compact)?);
+                }
+                Ok(out)
+            }
+            Conversion::JsonToYaml => {
+                let mut documents = Vec::new();
+                for value in serde_json::Deserializer::from_str(input).into_iter() {
+                    let value: serde_json::Value =
+                        value.map_err(|e| format!("invalid JSON: {}", e))?;
+                    documents.push(serde_yaml::to_string(&value).map_err(|e| e.to_string())?);
+                }
+                Ok(documents.join("---\n"))
+            }
+            Conversion::TomlToJson => {
+                let table: toml::Table = input
+                    .parse()
+                    .map_err(|e: toml::de::Error| format!("invalid TOML: {}", e.message()))?;
+                to_json(&toml_to_json(toml::Value::Table(table)), compact)
+            }
+        }
+    }
+}
+
+/// Format `value` as a line of JSON, pretty-printed unless `compact`.
+fn to_json(value: &serde_json::Value, compact: bool) -> Result<String, String> {
+    let json = if compact {
+        serde_json::to_string(value)
+    } else {
+        serde_json::to_string_pretty(value)
+    };
+    json.map(|json| json + "\n").map_err(|e| e.to_string())
+}
+
+/// Convert a TOML value to JSON. Dates and times, which JSON lacks, become
+/// their TOML text, such as `"1979-05-27T07:32:00Z"`.
+fn toml_to_json(value: toml::Value) -> serde_json::Value {
+    match value {
+        toml::Value::String(s) => s.into(),
+        toml::Value::Integer(i) => i.into(),
+        toml::Value::Float(f) => f.into(),
+        toml::Value::Boolean(b) => b.into(),
+        toml::Value::Datetime(dt) => dt.to_string().into(),
+        toml::Value::Array(items) => items.into_iter().map(toml_to_json).collect(),
+        toml::Value::Table(table) => table
+            .into_iter()
+            .map(|(key, value)| (key, toml_to_json(value)))
+            .collect(),
+    }
+}
+
+/// Options shared by the conversion builtins.
+struct ConvertOpts {
+    compact: bool,
+    file: Option<String>,
+}
+
+impl ConvertOpts {
+    fn parse(args: &[String]) -> Result<Self, String> {
+        let mut opts = ConvertOpts {
+            compact: false,
+            file: None,
+        };
+        for arg in args {
+            match arg.as_str() {
+                "-c" | "--compact" => opts.compact = true,
+                s if s.starts_with('-') && s.len() > 1 => {
+                    return Err(format!("unknown option: {}", s));
+                }
+                _ if opts.file.is_none() => opts.file = Some(arg.clone()),
+                _ => return Err(format!("unexpected argument: {}", arg)),
+            }
+        }
+        Ok(opts)
+    }
+}
+
+fn get_content(
+    conversion: Conversion,
+    content_type: builtins::ContentType,
+) -> Result<String, brush_core::Error> {
+    let name = conversion.name();
+    match content_type {
+        builtins::ContentType::DetailedHelp => Ok(format!(
+            "{}; -c writes compact JSON.",
+            conversion.description()
+        )),
+        builtins::ContentType::ShortUsage => Ok(format!("{} [-c] [FILE]", name)),
+        builtins::ContentType::ShortDescription => {
+            Ok(format!("{} - {}", name, conversion.description()))
+        }
+        builtins::ContentType::ManPage => error::unimp("man page not yet implemented"),
+    }
+}
+
+fn execute<SE: ShellExtensions>(
+    conversion: Conversion,
+    mut context: ExecutionContext<'_, SE>,
+    args: Vec<String>,
+) -> Result<ExecutionResult, brush_core::Error> {
+    let name = conversion.name();
+    let opts = match ConvertOpts::parse(&args) {
+        Ok(o) => o,
+        Err(e) => {
+            diagnostic!(context, name, "{}", e)?;
+            return Ok(ExecutionResult::new(2));
+        }
+    };
+
+    let input = match opts.file.as_deref() {
+        None | Some("-") => {
+            let mut buf = Vec::new();
+            context.stdin().read_to_end(&mut buf)?;
+            buf
+        }
+        Some(path) => match std::fs::read(path) {
+            Ok(data) => data,
+            Err(e) => {
+                let category = crate::diagnostics::io_category(&e);
+                diagnostic!(context, name, category = category, "{}: {}", path, e)?;
+                return Ok(ExecutionResult::new(1));
+            }
+        },
+    };
+    let input = String::from_utf8_lossy(&input);
+
+    match conversion.run(&input, opts.compact) {
+        Ok(out) => {
+            context.stdout().write_all(out.as_bytes())?;
+            context.stdout().flush()?;
+            Ok(ExecutionResult::success())
+        }
+        Err(e) => {
+            diagnostic!(context, name, "{}", e)?;
+            Ok(ExecutionResult::new(1))
+        }
+    }
+}
+
+/// Define a builtin performing `$conversion`.
+macro_rules! conversion_command {
+    ($(#[$meta:meta])* $command:ident, $conversion:expr) => {
+        $(#[$meta])*
+        pub struct $command;
+
+        impl builtins::SimpleCommand for $command {
+            fn get_content(
+                _name: &str,
+                content_type: builtins::ContentType,
+                _options: &builtins::ContentOptions,
+            ) -> Result<String, brush_core::Error> {
+                get_content($conversion, content_type)
+            }
+
+            fn execute<SE: ShellExtensions, I: Iterator<Item = S>, S: AsRef<str>>(
+                context: ExecutionContext<'_, SE>,
+                args: I,
+            ) -> Result<ExecutionResult, brush_core::Error> {
+                let args = args.skip(1).map(|s| s.as_ref().to_string()).collect();
+                execute($conversion, context, args)
+            }
+        }
+    };
+}
+
+conversion_command!(
+    /// `yaml2json`: YAML documents to JSON values.
+    Yaml2JsonCommand,
+    Conversion::YamlToJson
+);
+conversion_command!(
+    /// `json2yaml`: JSON values to YAML documents.
+    Json2YamlCommand,
+    Conversion::JsonToYaml
+);
+conversion_command!(
+    /// `toml2json`: a TOML document to a JSON object.
+    Toml2JsonCommand,
+    Conversion::TomlToJson
+);
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_yaml_to_json() {
+        let out = Conversion::YamlToJson
+            .run("name: app\nports: [80, 443]\n---\n- true\n", true)
+            .unwrap();
+        assert_eq!(out, "{\"name\":\"app\",\"ports\":[80,443]}\n[true]\n");
+        assert!(
+            Conversion::YamlToJson
+                .run("a: [1\n", true)
+                .unwrap_err()
+                .starts_with("invalid YAML: ")
+        );
+    }
+
+    #[test]
+    fn test_json_to_yaml() {
+        let out = Conversion::JsonToYaml
+            .run("{\"name\":\"app\",\"ports\":[80]} [1]", false)
+            .unwrap();
+        assert_eq!(out, "name: app\nports:\n- 80\n---\n- 1\n");
+        assert!(Conversion::JsonToYaml.run("{", false).is_err());
+    }
+
+    #[test]
+    fn test_toml_to_json() {
+        let out = Conversion::TomlToJson
+            .run(
+                "title = \"x\"\nwhen = 1979-05-27T07:32:00Z\n[server]\nports = [80, 443]\n",
+                true,
+            )
+            .unwrap();
+        assert_eq!(
+            out,
+            "{\"server\":{\"ports\":[80,443]},\"title\":\"x\",\"when\":\"1979-05-27T07:32:00Z\"}\n"
+        );
+        assert!(
+            Conversion::TomlToJson
+                .run("a = ", true)
+                .unwrap_err()
+                .starts_with("invalid TOML: ")
+        );
+    }
+
+    #[test]
+    fn test_convert_opts() {
+        let args = |args: &[&str]| args.iter().map(|s| s.to_string()).collect::<Vec<_>>();
+        let opts = ConvertOpts::parse(&args(&["-c", "config.yaml"])).unwrap();
+        assert!(opts.compact);
+        assert_eq!(opts.file.as_deref(), Some("config.yaml"));
+        assert!(ConvertOpts::parse(&args(&["-x"])).is_err());
+        assert!(ConvertOpts::parse(&args(&["a", "b"])).is_err());
+    }
+}
@@ -42,7 +42,7 @@ impl builtins::SimpleCommand for CpCommand {
                             // Common options we ignore for simplicity
                         }
                         _ => {
-                            writeln!(context.stderr(), "cp: unknown option: -{}", c)?;
+                            diagnostic!(context, "cp", "unknown option: -{}", c)?;
                             return Ok(ExecutionResult::new(1));
                         }
                     }
@@ -53,7 +53,7 @@ impl builtins::SimpleCommand for CpCommand {
         }
 
         if paths.len() < 2 {
-            writeln!(context.stderr(), "cp: missing destination file operand")?;
+            diagnostic!(context, "cp", "missing destination file operand")?;
             return Ok(ExecutionResult::new(1));
         }
 
@@ -63,7 +63,7 @@ impl builtins::SimpleCommand for CpCommand {
 
         // If multiple sources, dest must be a directory
         if paths.len() > 1 && !dest_path.is_dir() {
-            writeln!(context.stderr(), "cp: target '{}' is not a directory", dest)?;
+            diagnostic!(context, "cp", "target '{}' is not a directory", dest)?;
             return Ok(ExecutionResult::new(1));
         }
 
@@ -73,9 +73,10 @@ impl builtins::SimpleCommand for CpCommand {
             let src_path = std::path::Path::new(source);
 
             if !src_path.exists() {
-                writeln!(
-                    context.stderr(),
-                    "cp: cannot stat '{}': No such file or directory",
+                diagnostic!(
+                    context,
+                    "cp",
+                    "cannot stat '{}': No such file or directory",
                     source
                 )?;
                 exit_code = 1;
@@ -92,9 +93,10 @@ impl builtins::SimpleCommand for CpCommand {
                 if recursive {
                     copy_dir_all(src_path, &actual_dest)
                 } else {
-                    writeln!(
-                        context.stderr(),
-                        "cp: -r not specified; omitting directory '{}'",
+                    diagnostic!(
+                        context,
+                        "cp",
+                        "-r not specified; omitting directory '{}'",
                         source
                     )?;
                     exit_code = 1;
@@ -105,7 +107,7 @@ impl builtins::SimpleCommand for CpCommand {
             };
 
             if let Err(e) = result {
-                writeln!(context.stderr(), "cp: cannot copy '{}': {}", source, e)?;
+                diagnostic!(context, "cp", "cannot copy '{}': {}", source, e)?;
                 exit_code = 1;
             }
         }
@@ -0,0 +1,481 @@
+//! csv builtin - select, filter and convert delimited tabular data
+//!
+//! Reads CSV (RFC 4180 quoting) or TSV with a header row, and writes the
+//! same format back out, or NDJSON with `--to-json`. `--from-json` goes the
+//! other way.
+
+use std::io::{Read, Write};
+
+use brush_core::{ExecutionContext, ExecutionResult, ShellExtensions, builtins, error};
+
+pub struct CsvCommand;
+
+impl builtins::SimpleCommand for CsvCommand {
+    fn get_content(
+        _name: &str,
+        content_type: builtins::ContentType,
+        _options: &builtins::ContentOptions,
+    ) -> Result<String, brush_core::Error> {
+        match content_type {
+            builtins::ContentType::DetailedHelp => Ok(
+                "Select columns (-c), filter rows (-w) and convert CSV/TSV to and from JSON."
+                    .into(),
+            ),
+            builtins::ContentType::ShortUsage => Ok("csv [OPTIONS] [FILE]".into()),
+            builtins::ContentType::ShortDescription => {
+                Ok("csv - select, filter and convert tabular data".into())
+            }
+            builtins::ContentType::ManPage => error::unimp("man page not yet implemented"),
+        }
+    }
+
+    fn execute<SE: ShellExtensions, I: Iterator<Item = S>, S: AsRef<str>>(
+        mut context: ExecutionContext<'_, SE>,
+        args: I,
+    ) -> Result<ExecutionResult, brush_core::Error> {
+        let args: Vec<String> = args.skip(1).map(|s| s.as_ref().to_string()).collect();
+
+        let opts = match CsvOpts::parse(&args) {
+            Ok(o) => o,
+            Err(e) => {
+                diagnostic!(context, "csv", "{}", e)?;
+                return Ok(ExecutionResult::new(2));
+            }
+        };
+
+        let input = match opts.file.as_deref() {
+            None | Some("-") => {
+                let mut buf = Vec::new();
+                context.stdin().read_to_end(&mut buf)?;
+                buf
+            }
+            Some(path) => match std::fs::read(path) {
+                Ok(data) => data,
+                Err(e) => {
+                    diagnostic!(context, "csv", "{}: {}", path, e)?;
+                    return Ok(ExecutionResult::new(1));
+                }
+            },
+        };
+        let input = String::from_utf8_lossy(&input);
+
+        let table = if opts.from_json {
+            table_from_json(&input)
+        } else {
+            parse_table(&input, opts.delimiter, opts.header)
+        };
+        let output = table.and_then(|t| render(t, &opts));
+
+        match output {
+            Ok(out) => {
+                context.stdout().write_all(out.as_bytes())?;
+                context.stdout().flush()?;
+                Ok(ExecutionResult::success())
+            }
+            Err(e) => {
+                diagnostic!(context, "csv", "{}", e)?;
+                Ok(ExecutionResult::new(1))
+            }
+        }
+    }
+}
+
+/// A header and its rows.
+#[derive(Debug, PartialEq)]
+struct Table {
+    header: Vec<String>,
+    rows: Vec<Vec<String>>,
+}
+
+/// A row filter from `--where`.
+#[derive(Debug)]
+enum Condition {
+    Eq(String, String),
+    Ne(String, String),
+    Matches(String, regex_lite::Regex),
+}
+
+impl Condition {
+    fn parse(expr: &str) -> Result<Self, String> {
+        if let Some((col, val)) = expr.split_once("!=") {
+            return Ok(Condition::Ne(col.to_string(), val.to_string()));
+        }
+        if let Some((col, pattern)) = expr.split_once('~') {
+            let regex = regex_lite::Regex::new(pattern)
+                .map_err(|e| format!("invalid regex in --where {:?}: {}", expr, e))?;
+            return Ok(Condition::Matches(col.to_string(), regex));
+        }
+        if let Some((col, val)) = expr.split_once('=') {
+            return Ok(Condition::Eq(col.to_string(), val.to_string()));
+        }
+        Err(format!(
+            "invalid --where {:?}: want COL=VALUE, COL!=VALUE or COL~REGEX",
+            expr
+        ))
+    }
+
+    fn column(&self) -> &str {
+        match self {
+            Condition::Eq(col, _) | Condition::Ne(col, _) | Condition::Matches(col, _) => col,
+        }
+    }
+
+    fn matches(&self, value: &str) -> bool {
+        match self {
+            Condition::Eq(_, want) => value == want,
+            Condition::Ne(_, want) => value != want,
+            Condition::Matches(_, regex) => regex.is_match(value),
+        }
+    }
+}
+
+/// Parse delimited text into a table. Without a header row the columns are
+/// named by their 1-based index.
+fn parse_table(input: &str, delimiter: char, header: bool) -> Result<Table, String> {
+    let mut records = parse_records(input, delimiter)?;
+    let header = if header && !records.is_empty() {
+        records.remove(0)
+    } else {
+        let width = records.iter().map(Vec::len).max().unwrap_or(0);
+        (1..=width).map(|i| i.to_string()).collect()
+    };
+    Ok(Table {
+        header,
+        rows: records,
+    })
+}
+
+/// Split delimited text into records, honouring double-quoted fields that
+/// may contain delimiters, newlines and doubled quotes. Blank lines are
+/// skipped.
+fn parse_records(input: &str, delimiter: char) -> Result<Vec<Vec<String>>, String> {
+    let mut records = Vec::new();
+    let mut record = Vec::new();
+    let mut field = String::new();
+    let mut in_quotes = false;
+    let mut field_started = false;
+    let mut chars = input.chars().peekable();
+
+    while let Some(c) = chars.next() {
+        if in_quotes {
+            match c {
+                '"' if chars.peek() == Some(&'"') => {
+                    chars.next();
+                    field.push('"');
+                }
+                '"' => in_quotes = false,
+                _ => field.push(c),
+            }
+            continue;
+        }
+        match c {
+            '"' if field.is_empty() => {
+                in_quotes = true;
+                field_started = true;
+            }
+            '\r' if chars.peek() == Some(&'\n') => {}
+            '\n' => {
+                if field_started || !field.is_empty() || !record.is_empty() {
+                    record.push(std::mem::take(&mut field));
+                    records.push(std::mem::take(&mut record));
+                }
+                field_started = false;
+            }
+            c if c == delimiter => {
+                record.push(std::mem::take(&mut field));
+                field_started = true;
+            }
+            _ => {
+                field.push(c);
+                field_started = true;
+            }
+        }
+    }
+    if in_quotes {
+        return Err("unterminated quoted field".into());
+    }
+    if field_started || !field.is_empty() || !record.is_empty() {
+        record.push(field);
+        records.push(record);
+    }
+    Ok(records)
+}
+
+/// Build a table from JSON objects given one per line or as a single array.
+/// Columns follow the first object's keys, then any new keys from later
+/// objects.
+fn table_from_json(input: &str) -> Result<Table, String> {
+    let mut values = Vec::new();
+    for value in serde_json::Deserializer::from_str(input).into_iter::<serde_json::Value>() {
+        match value.map_err(|e| format!("invalid JSON: {}", e))? {
+            serde_json::Value::Array(items) => values.extend(items),
+            other => values.push(other),
+        }
+    }
+
+    let mut header: Vec<String> = Vec::new();
+    let mut objects = Vec::with_capacity(values.len());
+    for value in values {
+        let serde_json::Value::Object(object) = value else {
+            return Err(format!("expected a JSON object, got {}", value));
+        };
+        for key in object.keys() {
+            if !header.contains(key) {
+                header.push(key.clone());
+            }
+        }
+        objects.push(object);
+    }
+
+    let rows = objects
+        .iter()
+        .map(|object| {
+            header
+                .iter()
+                .map(|key| match object.get(key) {
+                    None | Some(serde_json::Value::Null) => String::new(),
+                    Some(serde_json::Value::String(s)) => s.clone(),
+                    Some(other) => other.to_string(),
+                })
+                .collect()
+        })
+        .collect();
+    Ok(Table { header, rows })
+}
+
+/// Resolve a column reference (a header name or a 1-based index) to its
+/// position.
+fn column_index(header: &[String], column: &str) -> Result<usize, String> {
+    if let Some(i) = header.iter().position(|h| h == column) {
+        return Ok(i);
+    }
+    match column.parse::<usize>() {
+        Ok(n) if n >= 1 && n <= header.len() => Ok(n - 1),
+        _ => Err(format!("no such column: {}", column)),
+    }
+}
+
+/// Apply the filters and column selection, then format the output.
+fn render(table: Table, opts: &CsvOpts) -> Result<String, String> {
+    let conditions = opts
+        .conditions
+        .iter()
+        .map(|c| Ok((column_index(&table.header, c.column())?, c)))
+        .collect::<Result<Vec<_>, String>>()?;
+    let columns = match &opts.columns {
+        Some(list) => list
+            .iter()
+            .map(|c| column_index(&table.header, c))
+            .collect::<Result<Vec<_>, _>>()?,
+        None => (0..table.header.len()).collect(),
+    };
+
+    let project = |row: &[String]| -> Vec<String> {
+        columns
+            .iter()
+            .map(|&i| row.get(i).cloned().unwrap_or_default())
+            .collect()
+    };
+    let header = project(&table.header);
+    let rows = table.rows.iter().filter(|row| {
+        conditions
+            .iter()
+            .all(|(i, c)| c.matches(row.get(*i).map_or("", String::as_str)))
+    });
+
+    let mut out = String::new();
+    if opts.to_json {
+        for row in rows {
+            let object: serde_json::Map<String, serde_json::Value> = header
+                .iter()
+                .cloned()
+                .zip(project(row).into_iter().map(serde_json::Value::String))
+                .collect();
+            out.push_str(&serde_json::Value::Object(object).to_string());
+            out.push('\n');
+        }
+        return Ok(out);
+    }
+
+    let delimiter = if opts.from_json { ',' } else { opts.delimiter };
+    if opts.header || opts.from_json {
+        write_record(&mut out, &header, delimiter);
+    }
+    for row in rows {
+        write_record(&mut out, &project(row), delimiter);
+    }
+    Ok(out)
+}
+
+/// Append one record, quoting fields that need it.
+fn write_record(out: &mut String, fields: &[String], delimiter: char) {
+    for (i, field) in fields.iter().enumerate() {
+        if i > 0 {
+            out.push(delimiter);
+        }
+        if field.contains(delimiter) || field.contains(['"', '\n', '\r']) {
+            out.push('"');
+            out.push_str(&field.replace('"', "\"\""));
+            out.push('"');
+        } else {
+            out.push_str(field);
+        }
+    }
+    out.push('\n');
+}
+
+#[derive(Debug)]
+struct CsvOpts {
+    delimiter: char,
+    header: bool,
+    columns: Option<Vec<String>>,
+    conditions: Vec<Condition>,
+    to_json: bool,
+    from_json: bool,
+    file: Option<String>,
+}
+
+impl CsvOpts {
+    fn parse(args: &[String]) -> Result<Self, String> {
+        let mut opts = CsvOpts {
+            delimiter: ',',
+            header: true,
+            columns: None,
+            conditions: Vec::new(),
+            to_json: false,
+            from_json: false,
+            file: None,
+        };
+
+        let mut iter = args.iter();
+        while let Some(arg) = iter.next() {
+            let mut value = |name: &str| {
+                iter.next()
+                    .cloned()
+                    .ok_or_else(|| format!("option {} requires an argument", name))
+            };
+            match arg.as_str() {
+                "-d" | "--delimiter" => opts.delimiter = parse_delimiter(&value(arg)?)?,
+                "-t" | "--tsv" => opts.delimiter = '\t',
+                "-H" | "--no-header" => opts.header = false,
+                "-c" | "--columns" => {
+                    opts.columns = Some(value(arg)?.split(',').map(str::to_string).collect())
+                }
+                "-w" | "--where" => opts.conditions.push(Condition::parse(&value(arg)?)?),
+                "--to-json" => opts.to_json = true,
+                "--from-json" => opts.from_json = true,
+                s if s.starts_with('-') && s.len() > 1 => {
+                    return Err(format!("unknown option: {}", s));
+                }
+                _ if opts.file.is_none() => opts.file = Some(arg.clone()),
+                _ => return Err(format!("unexpected argument: {}", arg)),
+            }
+        }
+
+        if opts.to_json && opts.from_json {
+            return Err("--to-json and --from-json are mutually exclusive".into());
+        }
+        Ok(opts)
+    }
+}
+
+fn parse_delimiter(spec: &str) -> Result<char, String> {
+    match spec {
+        "tab" | "\\t" | "\t" => Ok('\t'),
+        _ => {
+            let mut chars = spec.chars();
+            match (chars.next(), chars.next()) {
+                (Some(c), None) if c != '"' && c != '\n' => Ok(c),
+                _ => Err(format!("invalid delimiter: {:?}", spec)),
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn opts(args: &[&str]) -> CsvOpts {
+        let args: Vec<String> = args.iter().map(|s| s.to_string()).collect();
+        CsvOpts::parse(&args).expect("parse failed")
+    }
+
+    #[test]
+    fn test_parse_records_quoting() {
+        let records = parse_records("a,\"b,c\",\"say \"\"hi\"\"\"\r\n\n1,\"x\ny\",\n", ',')
+            .expect("parse failed");
+        assert_eq!(
+            records,
+            vec![vec!["a", "b,c", "say \"hi\""], vec!["1", "x\ny", ""]]
+        );
+    }
+
+    #[test]
+    fn test_parse_records_unterminated() {
+        assert!(parse_records("a,\"b\n", ',').is_err());
+    }
+
+    #[test]
+    fn test_select_and_filter() {
+        let table = parse_table(
+            "name,age,city\nann,31,Oslo\nbob,25,Rome\ncy,40,Oslo\n",
+            ',',
+            true,
+        )
+        .expect("parse failed");
+        let out = render(table, &opts(&["-c", "name,2", "-w", "city=Oslo"])).expect("render");
+        assert_eq!(out, "name,age\nann,31\ncy,40\n");
+    }
+
+    #[test]
+    fn test_where_operators() {
+        let input = "name,age\nann,31\nbob,25\ncy,40\n";
+        let table = parse_table(input, ',', true).expect("parse failed");
+        let out = render(table, &opts(&["-w", "name!=bob", "-w", "age~^[34]"])).expect("render");
+        assert_eq!(out, "name,age\nann,31\ncy,40\n");
+
+        let table = parse_table(input, ',', true).expect("parse failed");
+        assert!(render(table, &opts(&["-w", "missing=1"])).is_err());
+    }
+
+    #[test]
+    fn test_tsv_no_header() {
+        let table = parse_table("a\tb\nc\td\n", '\t', false).expect("parse failed");
+        assert_eq!(table.header, vec!["1", "2"]);
+        let out = render(table, &opts(&["-t", "-H", "-c", "2"])).expect("render");
+        assert_eq!(out, "b\nd\n");
+    }
+
+    #[test]
+    fn test_to_json() {
+        let table = parse_table("name,note\nann,\"a, b\"\n", ',', true).expect("parse failed");
+        let out = render(table, &opts(&["--to-json"])).expect("render");
+        assert_eq!(out, "{\"name\":\"ann\",\"note\":\"a, b\"}\n");
+    }
+
+    #[test]
+    fn test_from_json() {
+        let table = table_from_json("{\"a\":1,\"b\":\"x,y\"}\n[{\"a\":2,\"c\":null}]")
+            .expect("parse failed");
+        let out = render(table, &opts(&["--from-json"])).expect("render");
+        assert_eq!(out, "a,b,c\n1,\"x,y\",\n2,,\n");
+
+        assert!(table_from_json("[1, 2]").is_err());
+    }
+
+    #[test]
+    fn test_parse_opts_errors() {
+        for args in [
+            vec!["-d"],
+            vec!["-d", "ab"],
+            vec!["--bogus"],
+            vec!["-w", "noop"],
+            vec!["--to-json", "--from-json"],
+            vec!["a.csv", "b.csv"],
+        ] {
+            let args: Vec<String> = args.iter().map(|s| s.to_string()).collect();
+            assert!(CsvOpts::parse(&args).is_err(), "{:?} parsed", args);
+        }
+    }
+}
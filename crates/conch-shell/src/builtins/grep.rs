@@ -4,6 +4,8 @@ use std::io::{BufRead, Read, Write};
 
 use brush_core::{ExecutionContext, ExecutionResult, ShellExtensions, builtins, error};
 
+use super::{Deadline, RegexLimits};
+
 pub struct GrepCommand;
 
 impl builtins::SimpleCommand for GrepCommand {
@@ -31,19 +33,25 @@ impl builtins::SimpleCommand for GrepCommand {
         let opts = match GrepOpts::parse(&args) {
             Ok(o) => o,
             Err(e) => {
-                writeln!(context.stderr(), "grep: {}", e)?;
+                diagnostic!(context, "grep", "{}", e)?;
                 return Ok(ExecutionResult::new(2));
             }
         };
 
-        let regex = match regex_lite::Regex::new(&opts.pattern) {
+        let regex = match RegexLimits::from_host().compile(&opts.pattern) {
             Ok(r) => r,
             Err(e) => {
-                writeln!(context.stderr(), "grep: invalid regex: {}", e)?;
+                let category = if e.starts_with("pattern too complex") {
+                    crate::diagnostics::PATTERN_TOO_COMPLEX
+                } else {
+                    crate::diagnostics::COMMAND_FAILED
+                };
+                diagnostic!(context, "grep", category = category, "{}", e)?;
                 return Ok(ExecutionResult::new(2));
             }
         };
 
+        let deadline = Deadline::start();
         let mut matched = false;
         let mut match_count = 0;
 
@@ -52,7 +60,18 @@ impl builtins::SimpleCommand for GrepCommand {
             let mut stdin = context.stdin();
             let mut buf = Vec::new();
             stdin.read_to_end(&mut buf)?;
-            let result = grep_reader(&buf, &regex, None, &opts, &mut context, &mut match_count)?;
+            let Some(result) = grep_reader(
+                &buf,
+                &regex,
+                None,
+                &opts,
+                &deadline,
+                &mut context,
+                &mut match_count,
+            )?
+            else {
+                return deadline.exceeded(&mut context, "grep");
+            };
             matched |= result;
         } else {
             let show_filename = opts.files.len() > 1 || opts.with_filename;
@@ -65,14 +84,18 @@ impl builtins::SimpleCommand for GrepCommand {
                         } else {
                             None
                         };
-                        let result = grep_reader(
+                        let Some(result) = grep_reader(
                             &contents,
                             &regex,
                             filename,
                             &opts,
+                            &deadline,
                             &mut context,
                             &mut match_count,
-                        )?;
+                        )?
+                        else {
+                            return deadline.exceeded(&mut context, "grep");
+                        };
                         matched |= result;
 
                         if opts.files_only && result {
@@ -81,7 +104,7 @@ impl builtins::SimpleCommand for GrepCommand {
                     }
                     Err(e) => {
                         if !opts.silent {
-                            writeln!(context.stderr(), "grep: {}: {}", file_pattern, e)?;
+                            diagnostic!(context, "grep", "{}: {}", file_pattern, e)?;
                         }
                     }
                 }
@@ -100,18 +123,24 @@ impl builtins::SimpleCommand for GrepCommand {
     }
 }
 
+/// Search `input`, returning whether any line matched, or `None` if
+/// `deadline` passed first.
 fn grep_reader<SE: ShellExtensions>(
     input: &[u8],
     regex: &regex_lite::Regex,
     filename: Option<&str>,
     opts: &GrepOpts,
+    deadline: &Deadline,
     context: &mut ExecutionContext<'_, SE>,
     match_count: &mut usize,
-) -> Result<bool, brush_core::Error> {
+) -> Result<Option<bool>, brush_core::Error> {
     let mut matched = false;
     let mut line_number = 0;
 
     for line in input.lines() {
+        if deadline.expired() {
+            return Ok(None);
+        }
         line_number += 1;
 
         let line = match line {
@@ -150,7 +179,7 @@ fn grep_reader<SE: ShellExtensions>(
         }
     }
 
-    Ok(matched)
+    Ok(Some(matched))
 }
 
 struct GrepOpts {
@@ -84,7 +84,7 @@ impl builtins::SimpleCommand for HeadCommand {
                 match std::fs::read(file) {
                     Ok(data) => data,
                     Err(e) => {
-                        writeln!(context.stderr(), "head: {}: {}", file, e)?;
+                        diagnostic!(context, "head", "{}: {}", file, e)?;
                         exit_code = 1;
                         continue;
                     }
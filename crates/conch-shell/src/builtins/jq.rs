@@ -2,13 +2,29 @@
 //!
 //! Full jq implementation using the jaq library.
 
-use std::io::{Read, Write};
+use std::collections::VecDeque;
+use std::io::{BufRead, BufReader, Read, Write};
+use std::path::PathBuf;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
 
 use brush_core::{ExecutionContext, ExecutionResult, ShellExtensions, builtins, error};
+use jaq_core::load::parse::Def;
 use jaq_core::load::{Arena, File, Loader};
 use jaq_core::{Compiler, Ctx, Vars, data, unwrap_valr};
 use jaq_json::Val;
 
+use super::{Deadline, shell_var};
+
+/// Shell variable holding a JSON object whose members every `jq` the
+/// script runs binds as variables, as if passed with `--argjson`; the Go
+/// bindings set it from `JqOptions`. Arguments on the command line take
+/// precedence.
+const JQ_ARGS_VAR: &str = "CONCH_JQ_ARGS";
+
+/// Shell variable holding colon-separated directories searched for jq
+/// modules after those given with `-L`.
+const JQ_LIBRARY_PATH_VAR: &str = "CONCH_JQ_LIBRARY_PATH";
+
 pub struct JqCommand;
 
 impl builtins::SimpleCommand for JqCommand {
@@ -33,16 +49,18 @@ impl builtins::SimpleCommand for JqCommand {
     ) -> Result<ExecutionResult, brush_core::Error> {
         let args: Vec<String> = args.skip(1).map(|s| s.as_ref().to_string()).collect();
 
-        let opts = match JqOpts::parse(&args) {
+        let opts = match JqOpts::parse(&args).and_then(|opts| opts.with_env(&context)) {
             Ok(o) => o,
             Err(e) => {
-                writeln!(context.stderr(), "jq: {}", e)?;
+                diagnostic!(context, "jq", "{}", e)?;
                 return Ok(ExecutionResult::new(2));
             }
         };
 
-        // Read input
-        let input_bytes = if opts.files.is_empty() {
+        // Read input, unless it is streamed as the filter runs.
+        let input_bytes = if opts.stream {
+            Vec::new()
+        } else if opts.files.is_empty() {
             // Read from stdin
             let mut stdin = context.stdin();
             let mut buf = Vec::new();
@@ -52,26 +70,32 @@ impl builtins::SimpleCommand for JqCommand {
             match std::fs::read(&opts.files[0]) {
                 Ok(data) => data,
                 Err(e) => {
-                    writeln!(context.stderr(), "jq: {}: {}", opts.files[0], e)?;
+                    diagnostic!(context, "jq", "{}: {}", opts.files[0], e)?;
                     return Ok(ExecutionResult::new(1));
                 }
             }
         };
 
+        // The filter checks the time limit as it loops, so it must be
+        // running before the filter is compiled.
+        let deadline = Deadline::start();
+        let deadline_defs = deadline.remaining().map(deadline_defs).unwrap_or_default();
+
         // Compile the filter
         let program = File {
             code: opts.filter.as_str(),
             path: (),
         };
 
-        let loader = Loader::new(jaq_std::defs().chain(jaq_json::defs()));
+        let prelude = std_defs().chain(parse_defs(&deadline_defs));
+        let loader = Loader::new(prelude).with_std_read(&opts.library_path);
         let arena = Arena::default();
 
         let modules = match loader.load(&arena, program) {
             Ok(m) => m,
             Err(errs) => {
                 for err in errs {
-                    writeln!(context.stderr(), "jq: parse error: {:?}", err)?;
+                    diagnostic!(context, "jq", "parse error: {:?}", err)?;
                 }
                 return Ok(ExecutionResult::new(3));
             }
@@ -79,20 +103,40 @@ impl builtins::SimpleCommand for JqCommand {
 
         let filter = match Compiler::<_, data::JustLut<Val>>::default()
             .with_funs(jaq_std::funs().chain(jaq_json::funs()))
+            .with_global_vars(opts.args.iter().map(|(name, _)| name.as_str()))
             .compile(modules)
         {
             Ok(f) => f,
             Err(errs) => {
                 for err in errs {
-                    writeln!(context.stderr(), "jq: compile error: {:?}", err)?;
+                    diagnostic!(context, "jq", "compile error: {:?}", err)?;
                 }
                 return Ok(ExecutionResult::new(3));
             }
         };
 
+        // Stream events as the input is read, never holding all of it.
+        if opts.stream {
+            let input: Box<dyn Read> = if opts.files.is_empty() {
+                Box::new(context.stdin())
+            } else {
+                match std::fs::File::open(&opts.files[0]) {
+                    Ok(file) => Box::new(file),
+                    Err(e) => {
+                        diagnostic!(context, "jq", "{}: {}", opts.files[0], e)?;
+                        return Ok(ExecutionResult::new(1));
+                    }
+                }
+            };
+            return run_stream(&filter, input, &opts, &deadline, &mut context);
+        }
+
         // Handle null input
         if opts.null_input {
-            return run_filter(&filter, Val::Null, &opts, &mut context);
+            return match run_filter(&filter, Val::Null, &opts, &deadline, &mut context)? {
+                Some(result) => Ok(result),
+                None => deadline.exceeded(&mut context, "jq"),
+            };
         }
 
         // Handle raw input mode
@@ -100,7 +144,9 @@ impl builtins::SimpleCommand for JqCommand {
             let text = String::from_utf8_lossy(&input_bytes);
             for line in text.lines() {
                 let val = Val::from(line.to_string());
-                let result = run_filter(&filter, val, &opts, &mut context)?;
+                let Some(result) = run_filter(&filter, val, &opts, &deadline, &mut context)? else {
+                    return deadline.exceeded(&mut context, "jq");
+                };
                 if !result.is_success() {
                     return Ok(result);
                 }
@@ -114,10 +160,13 @@ impl builtins::SimpleCommand for JqCommand {
             match values {
                 Ok(vals) => {
                     let array: Val = vals.into_iter().collect();
-                    return run_filter(&filter, array, &opts, &mut context);
+                    return match run_filter(&filter, array, &opts, &deadline, &mut context)? {
+                        Some(result) => Ok(result),
+                        None => deadline.exceeded(&mut context, "jq"),
+                    };
                 }
                 Err(e) => {
-                    writeln!(context.stderr(), "jq: parse error: {}", e)?;
+                    diagnostic!(context, "jq", "parse error: {}", e)?;
                     return Ok(ExecutionResult::new(4));
                 }
             }
@@ -128,13 +177,17 @@ impl builtins::SimpleCommand for JqCommand {
         for result in jaq_json::read::parse_many(&input_bytes) {
             match result {
                 Ok(val) => {
-                    let exec_result = run_filter(&filter, val, &opts, &mut context)?;
+                    let Some(exec_result) =
+                        run_filter(&filter, val, &opts, &deadline, &mut context)?
+                    else {
+                        return deadline.exceeded(&mut context, "jq");
+                    };
                     if !exec_result.is_success() {
                         last_code = 1;
                     }
                 }
                 Err(e) => {
-                    writeln!(context.stderr(), "jq: parse error: {}", e)?;
+                    diagnostic!(context, "jq", "parse error: {}", e)?;
                     return Ok(ExecutionResult::new(4));
                 }
             }
@@ -147,33 +200,118 @@ impl builtins::SimpleCommand for JqCommand {
     }
 }
 
+/// The definitions of jq's standard library and jaq's JSON functions.
+fn std_defs<'s>() -> impl Iterator<Item = Def<&'s str>> {
+    jaq_std::defs()
+        .chain(jaq_json::defs())
+        .map(|def| -> Def<&'s str> { def })
+}
+
+/// Parse `defs`, jq definitions written by this builtin, not the script.
+fn parse_defs(defs: &str) -> Vec<Def<&str>> {
+    jaq_core::load::parse(defs, |p| p.defs()).unwrap_or_default()
+}
+
+/// Definitions replacing the standard loops with ones that fail once
+/// `remaining` has passed, for filters that run a long time without
+/// output, such as `last(range(1e10))`, which the deadline checked between
+/// outputs never sees. Loops a filter writes itself by recursion are only
+/// stopped by the script's own timeout.
+fn deadline_defs(remaining: Duration) -> String {
+    let at = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .unwrap_or_default()
+        + remaining;
+    format!(
+        r#"def __conch_tick: if now < {at} then . else error("time limit exceeded") end;
+def while(cond; update): def _while: __conch_tick | if cond then ., (update | _while) else empty end; _while;
+def until(cond; update): def _until: __conch_tick | if cond then . else (update | _until) end; _until;
+def repeat(f): def _repeat: __conch_tick | ., (f | _repeat); _repeat;
+def recurse(f): def _recurse: __conch_tick | ., (f | _recurse); _recurse;
+def recurse(f; cond): def _recurse: __conch_tick | ., (f | select(cond) | _recurse); _recurse;
+def range($upto): range(0; $upto);
+def range($from; $upto): $from | while(. < $upto; . + 1);
+def range($from; $upto; $by): $from | while(if $by > 0 then . < $upto elif $by < 0 then . > $upto else empty end; . + $by);
+"#,
+        at = at.as_secs_f64()
+    )
+}
+
+/// Run `filter` on `input`, returning its result, or `None` if `deadline`
+/// passed first.
 fn run_filter<SE: ShellExtensions>(
     filter: &jaq_core::Filter<data::JustLut<Val>>,
     input: Val,
     opts: &JqOpts,
+    deadline: &Deadline,
     context: &mut ExecutionContext<'_, SE>,
-) -> Result<ExecutionResult, brush_core::Error> {
-    let ctx = Ctx::<data::JustLut<Val>>::new(&filter.lut, Vars::new([]));
+) -> Result<Option<ExecutionResult>, brush_core::Error> {
+    let vars = Vars::new(opts.args.iter().map(|(_, val)| val.clone()));
+    let ctx = Ctx::<data::JustLut<Val>>::new(&filter.lut, vars);
 
     let mut had_output = false;
     for result in filter.id.run((ctx.clone(), input)).map(unwrap_valr) {
+        if deadline.expired() {
+            return Ok(None);
+        }
         match result {
             Ok(val) => {
                 had_output = true;
                 output_value(&val, opts, context)?;
             }
+            // The error of a loop that ran out of time.
+            Err(_) if deadline.expired() => return Ok(None),
             Err(e) => {
-                writeln!(context.stderr(), "jq: {:?}", e)?;
-                return Ok(ExecutionResult::new(5));
+                diagnostic!(context, "jq", "{:?}", e)?;
+                return Ok(Some(ExecutionResult::new(5)));
             }
         }
     }
 
     if !had_output && opts.exit_status {
-        return Ok(ExecutionResult::new(1));
+        return Ok(Some(ExecutionResult::new(1)));
     }
 
-    Ok(ExecutionResult::success())
+    Ok(Some(ExecutionResult::success()))
+}
+
+/// Run `filter` on each event of jq's `--stream` form of `input`, read as
+/// the events are needed so inputs larger than memory can be processed.
+fn run_stream<SE: ShellExtensions>(
+    filter: &jaq_core::Filter<data::JustLut<Val>>,
+    input: impl Read,
+    opts: &JqOpts,
+    deadline: &Deadline,
+    context: &mut ExecutionContext<'_, SE>,
+) -> Result<ExecutionResult, brush_core::Error> {
+    let mut events = StreamEvents::new(BufReader::new(input));
+    let mut last_code = 0;
+    loop {
+        let event = match events.next_event() {
+            Ok(Some(event)) => event,
+            Ok(None) => break,
+            Err(e) => {
+                diagnostic!(context, "jq", "parse error: {}", e)?;
+                return Ok(ExecutionResult::new(4));
+            }
+        };
+        let val = match parse_json(&event.to_string()) {
+            Ok(val) => val,
+            Err(e) => {
+                diagnostic!(context, "jq", "parse error: {}", e)?;
+                return Ok(ExecutionResult::new(4));
+            }
+        };
+        let Some(exec_result) = run_filter(filter, val, opts, deadline, context)? else {
+            return deadline.exceeded(context, "jq");
+        };
+        if !exec_result.is_success() {
+            last_code = 1;
+        }
+    }
+
+    context.stdout().flush()?;
+    Ok(ExecutionResult::new(last_code))
 }
 
 fn output_value<SE: ShellExtensions>(
@@ -217,6 +355,11 @@ struct JqOpts {
     slurp: bool,
     null_input: bool,
     exit_status: bool,
+    stream: bool,
+    /// Variables from `--arg` and `--argjson`, named with their `$`.
+    args: Vec<(String, Val)>,
+    /// Directories from `-L` to search for modules.
+    library_path: Vec<PathBuf>,
 }
 
 impl JqOpts {
@@ -230,17 +373,41 @@ impl JqOpts {
             slurp: false,
             null_input: false,
             exit_status: false,
+            stream: false,
+            args: Vec::new(),
+            library_path: Vec::new(),
         };
 
         let mut positional = Vec::new();
-        for arg in args.iter() {
+        let mut args = args.iter();
+        while let Some(arg) = args.next() {
+            let mut value = |n: usize| {
+                let values: Vec<&String> = args.by_ref().take(n).collect();
+                if values.len() < n {
+                    return Err(format!("{} takes {} arguments", arg, n));
+                }
+                Ok(values)
+            };
             match arg.as_str() {
+                "--arg" => {
+                    let values = value(2)?;
+                    opts.bind(values[0], Val::from(values[1].clone()));
+                }
+                "--argjson" => {
+                    let values = value(2)?;
+                    let val = parse_json(values[1])
+                        .map_err(|e| format!("invalid JSON for --argjson {}: {}", values[0], e))?;
+                    opts.bind(values[0], val);
+                }
+                "-L" | "--library-path" => opts.library_path.push(value(1)?[0].into()),
+                s if s.starts_with("-L") => opts.library_path.push(s[2..].into()),
                 "-c" | "--compact-output" => opts.compact = true,
                 "-r" | "--raw-output" => opts.raw_output = true,
                 "-R" | "--raw-input" => opts.raw_input = true,
                 "-s" | "--slurp" => opts.slurp = true,
                 "-n" | "--null-input" => opts.null_input = true,
                 "-e" | "--exit-status" => opts.exit_status = true,
+                "--stream" => opts.stream = true,
                 s if s.starts_with('-') && s.len() > 1 && !s.starts_with("--") => {
                     // Handle combined short options like -cr
                     for c in s[1..].chars() {
@@ -264,6 +431,295 @@ impl JqOpts {
         }
         opts.files = positional;
 
+        if opts.stream && (opts.slurp || opts.raw_input || opts.null_input) {
+            return Err("--stream cannot be used with --slurp, --raw-input or --null-input".into());
+        }
+
         Ok(opts)
     }
+
+    /// Add the variables and module directories set for every `jq` in
+    /// `context` by `CONCH_JQ_ARGS` and `CONCH_JQ_LIBRARY_PATH`.
+    fn with_env<SE: ShellExtensions>(
+        mut self,
+        context: &ExecutionContext<'_, SE>,
+    ) -> Result<Self, String> {
+        if let Some(path) = shell_var::<String, SE>(context, JQ_LIBRARY_PATH_VAR) {
+            let dirs = path.split(':').filter(|dir| !dir.is_empty());
+            self.library_path.extend(dirs.map(PathBuf::from));
+        }
+        let Some(json) = shell_var::<String, SE>(context, JQ_ARGS_VAR) else {
+            return Ok(self);
+        };
+        let env_args: serde_json::Map<String, serde_json::Value> =
+            serde_json::from_str(&json).map_err(|e| format!("invalid {}: {}", JQ_ARGS_VAR, e))?;
+        for (name, value) in env_args {
+            let var = format!("${}", name);
+            if self.args.iter().any(|(bound, _)| *bound == var) {
+                continue;
+            }
+            let val = parse_json(&value.to_string())
+                .map_err(|e| format!("invalid {} value for {}: {}", JQ_ARGS_VAR, name, e))?;
+            self.args.push((var, val));
+        }
+        Ok(self)
+    }
+
+    /// Bind `$name` to `val`, replacing an earlier binding of the same name
+    /// as jq does.
+    fn bind(&mut self, name: &str, val: Val) {
+        let var = format!("${}", name);
+        self.args.retain(|(bound, _)| *bound != var);
+        self.args.push((var, val));
+    }
+}
+
+/// Parse `text` as a single JSON value.
+fn parse_json(text: &str) -> Result<Val, String> {
+    let mut vals = jaq_json::read::parse_many(text.as_bytes());
+    match (vals.next(), vals.next()) {
+        (Some(Ok(val)), None) => Ok(val),
+        (Some(Err(e)), _) => Err(e.to_string()),
+        _ => Err("expected a single JSON value".to_string()),
+    }
+}
+
+/// A container being read by [`StreamEvents`], with the index or key of the
+/// element being read in it.
+enum Frame {
+    Array(usize),
+    Object(String),
+}
+
+/// Reads JSON values as the events of jq's `--stream`: `[path, leaf]` for
+/// each scalar and empty container, and `[path]` after the last element of
+/// each other container, `path` being that element's. Only the current path
+/// is held in memory, however large the values.
+struct StreamEvents<R> {
+    input: R,
+    stack: Vec<Frame>,
+    pending: VecDeque<serde_json::Value>,
+}
+
+impl<R: BufRead> StreamEvents<R> {
+    fn new(input: R) -> Self {
+        Self {
+            input,
+            stack: Vec::new(),
+            pending: VecDeque::new(),
+        }
+    }
+
+    /// Read the next event, or `None` at the end of the input.
+    fn next_event(&mut self) -> Result<Option<serde_json::Value>, String> {
+        loop {
+            if let Some(event) = self.pending.pop_front() {
+                return Ok(Some(event));
+            }
+            if self.stack.is_empty() && self.peek_token()?.is_none() {
+                return Ok(None);
+            }
+            self.read_value()?;
+        }
+    }
+
+    /// The path of the element being read.
+    fn path(&self) -> serde_json::Value {
+        self.stack
+            .iter()
+            .map(|frame| match frame {
+                Frame::Array(index) => serde_json::Value::from(*index),
+                Frame::Object(key) => serde_json::Value::from(key.as_str()),
+            })
+            .collect()
+    }
+
+    /// Read the start of a value: a leaf, which is emitted, or the opening
+    /// of a non-empty container, which is entered.
+    fn read_value(&mut self) -> Result<(), String> {
+        match self.peek_token()? {
+            None => Err("unexpected end of input".into()),
+            Some(b'[') => {
+                self.input.consume(1);
+                if self.peek_token()? == Some(b']') {
+                    self.input.consume(1);
+                    return self.leaf(serde_json::Value::Array(Vec::new()));
+                }
+                self.stack.push(Frame::Array(0));
+                Ok(())
+            }
+            Some(b'{') => {
+                self.input.consume(1);
+                if self.peek_token()? == Some(b'}') {
+                    self.input.consume(1);
+                    return self.leaf(serde_json::Value::Object(serde_json::Map::new()));
+                }
+                let key = self.read_key()?;
+                self.stack.push(Frame::Object(key));
+                Ok(())
+            }
+            Some(_) => {
+                let value = self.read_scalar()?;
+                self.leaf(value)
+            }
+        }
+    }
+
+    /// Emit the leaf `value`, then read the separators after it, leaving
+    /// each container they close with its closing event.
+    fn leaf(&mut self, value: serde_json::Value) -> Result<(), String> {
+        self.pending
+            .push_back(serde_json::Value::Array(vec![self.path(), value]));
+        while let Some(top) = self.stack.len().checked_sub(1) {
+            let in_array = matches!(self.stack[top], Frame::Array(_));
+            match self.peek_token()? {
+                Some(b',') => {
+                    self.input.consume(1);
+                    if let Some(Frame::Array(index)) = self.stack.last_mut() {
+                        *index += 1;
+                    } else {
+                        let key = self.read_key()?;
+                        self.stack[top] = Frame::Object(key);
+                    }
+                    return Ok(());
+                }
+                Some(b']') if in_array => {}
+                Some(b'}') if !in_array => {}
+                Some(c) => return Err(format!("unexpected {:?}", c as char)),
+                None => return Err("unexpected end of input".into()),
+            }
+            self.input.consume(1);
+            self.pending
+                .push_back(serde_json::Value::Array(vec![self.path()]));
+            self.stack.pop();
+        }
+        Ok(())
+    }
+
+    /// Read an object key and the colon after it.
+    fn read_key(&mut self) -> Result<String, String> {
+        if self.peek_token()? != Some(b'"') {
+            return Err("expected an object key".into());
+        }
+        let key = match self.read_scalar()? {
+            serde_json::Value::String(key) => key,
+            _ => unreachable!("a value starting with a quote is a string"),
+        };
+        if self.peek_token()? != Some(b':') {
+            return Err(format!("expected ':' after key {:?}", key));
+        }
+        self.input.consume(1);
+        Ok(key)
+    }
+
+    /// Read a string, number, boolean or null.
+    fn read_scalar(&mut self) -> Result<serde_json::Value, String> {
+        let mut token = Vec::new();
+        if self.peek_byte()? == Some(b'"') {
+            self.input.consume(1);
+            token.push(b'"');
+            loop {
+                let Some(b) = self.peek_byte()? else {
+                    return Err("unterminated string".into());
+                };
+                self.input.consume(1);
+                token.push(b);
+                match b {
+                    b'"' => break,
+                    b'\\' => {
+                        let Some(escaped) = self.peek_byte()? else {
+                            return Err("unterminated string".into());
+                        };
+                        self.input.consume(1);
+                        token.push(escaped);
+                    }
+                    _ => {}
+                }
+            }
+        } else {
+            while let Some(b) = self.peek_byte()? {
+                if b.is_ascii_whitespace() || b",:[]{}\"".contains(&b) {
+                    break;
+                }
+                self.input.consume(1);
+                token.push(b);
+            }
+        }
+        serde_json::from_slice(&token).map_err(|e| e.to_string())
+    }
+
+    /// Skip whitespace and return the next byte without consuming it.
+    fn peek_token(&mut self) -> Result<Option<u8>, String> {
+        while let Some(b) = self.peek_byte()? {
+            if !b.is_ascii_whitespace() {
+                return Ok(Some(b));
+            }
+            self.input.consume(1);
+        }
+        Ok(None)
+    }
+
+    fn peek_byte(&mut self) -> Result<Option<u8>, String> {
+        let buf = self.input.fill_buf().map_err(|e| e.to_string())?;
+        Ok(buf.first().copied())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_deadline_defs() {
+        let defs = deadline_defs(Duration::from_secs(1));
+        assert_eq!(parse_defs(&defs).len(), 9, "{defs}");
+    }
+
+    fn events(input: &str) -> Result<Vec<String>, String> {
+        let mut reader = StreamEvents::new(input.as_bytes());
+        let mut events = Vec::new();
+        while let Some(event) = reader.next_event()? {
+            events.push(event.to_string());
+        }
+        Ok(events)
+    }
+
+    #[test]
+    fn test_stream_events() {
+        assert_eq!(
+            events(r#"{"a": [1, {"b": null}], "c": "x,]"}"#).unwrap(),
+            vec![
+                r#"[["a",0],1]"#,
+                r#"[["a",1,"b"],null]"#,
+                r#"[["a",1,"b"]]"#,
+                r#"[["a",1]]"#,
+                r#"[["c"],"x,]"]"#,
+                r#"[["c"]]"#,
+            ]
+        );
+    }
+
+    #[test]
+    fn test_stream_events_top_level() {
+        assert_eq!(
+            events("3 [] {\"k\\\"\":{}} [true]\n").unwrap(),
+            vec![
+                "[[],3]",
+                "[[],[]]",
+                r#"[["k\""],{}]"#,
+                r#"[["k\""]]"#,
+                "[[0],true]",
+                "[[0]]",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_stream_events_errors() {
+        assert!(events("[1, 2").is_err());
+        assert!(events("[1}").is_err());
+        assert!(events(r#"{"a" 1}"#).is_err());
+        assert!(events(r#"["abc"#).is_err());
+        assert!(events("[nul]").is_err());
+    }
 }
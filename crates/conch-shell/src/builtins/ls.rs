@@ -44,7 +44,7 @@ impl builtins::SimpleCommand for LsCommand {
                             // Common options we ignore for simplicity
                         }
                         _ => {
-                            writeln!(context.stderr(), "ls: unknown option: -{}", c)?;
+                            diagnostic!(context, "ls", "unknown option: -{}", c)?;
                             return Ok(ExecutionResult::new(1));
                         }
                     }
@@ -65,9 +65,10 @@ impl builtins::SimpleCommand for LsCommand {
             let path = std::path::Path::new(path_str);
 
             if !path.exists() {
-                writeln!(
-                    context.stderr(),
-                    "ls: cannot access '{}': No such file or directory",
+                diagnostic!(
+                    context,
+                    "ls",
+                    "cannot access '{}': No such file or directory",
                     path_str
                 )?;
                 exit_code = 1;
@@ -95,12 +96,7 @@ impl builtins::SimpleCommand for LsCommand {
             let entries = match std::fs::read_dir(path) {
                 Ok(entries) => entries,
                 Err(e) => {
-                    writeln!(
-                        context.stderr(),
-                        "ls: cannot open directory '{}': {}",
-                        path_str,
-                        e
-                    )?;
+                    diagnostic!(context, "ls", "cannot open directory '{}': {}", path_str, e)?;
                     exit_code = 1;
                     continue;
                 }
@@ -45,11 +45,11 @@ impl builtins::SimpleCommand for MkdirCommand {
                         'v' => {} // Ignore verbose flag
                         'm' => {
                             // Mode - skip for now, would need next arg
-                            writeln!(context.stderr(), "mkdir: -m option not supported")?;
+                            diagnostic!(context, "mkdir", "-m option not supported")?;
                             return Ok(ExecutionResult::new(1));
                         }
                         _ => {
-                            writeln!(context.stderr(), "mkdir: unknown option: -{}", c)?;
+                            diagnostic!(context, "mkdir", "unknown option: -{}", c)?;
                             return Ok(ExecutionResult::new(1));
                         }
                     }
@@ -60,7 +60,7 @@ impl builtins::SimpleCommand for MkdirCommand {
         }
 
         if dirs.is_empty() {
-            writeln!(context.stderr(), "mkdir: missing operand")?;
+            diagnostic!(context, "mkdir", "missing operand")?;
             return Ok(ExecutionResult::new(1));
         }
 
@@ -80,12 +80,7 @@ impl builtins::SimpleCommand for MkdirCommand {
                 if parents && e.kind() == std::io::ErrorKind::AlreadyExists && path.is_dir() {
                     continue;
                 }
-                writeln!(
-                    context.stderr(),
-                    "mkdir: cannot create directory '{}': {}",
-                    dir,
-                    e
-                )?;
+                diagnostic!(context, "mkdir", "cannot create directory '{}': {}", dir, e)?;
                 exit_code = 1;
             }
         }
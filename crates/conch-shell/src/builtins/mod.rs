@@ -1,8 +1,10 @@
 //! Custom builtins for conch-shell
 //!
-//! conch-shell always ships a few non-coreutils builtins (`grep`, `jq`,
-//! `tool`). The coreutils (cat, head, tail, ls, wc, cp, mv, rm, mkdir, touch,
-//! …) are normally provided by spawning the uutils `coreutils` component (built
+//! conch-shell always ships a few non-coreutils builtins (`csv`, `grep`,
+//! `jq`, `tool`, and the `yaml2json`, `json2yaml` and `toml2json`
+//! converters), plus a bash-compatible `printf` that replaces brush's.
+//! The coreutils (cat, head, tail, ls, wc, cp, mv, rm, mkdir, touch, …)
+//! are normally provided by spawning the uutils `coreutils` component (built
 //! via `clis/coreutils.toml`, registered under each util name) — a single
 //! battle-tested implementation rather than these hand-rolled ones. See #86.
 //!
@@ -13,12 +15,40 @@
 //! are acknowledged PoC-quality stopgaps; the real fix is a jco spawn shim that
 //! runs the same uutils component in the browser (tracked separately).
 
+/// Write a builtin's error to its stderr as `conch: <builtin>: <message>`,
+/// the form every conch builtin uses, and report it to the host on the
+/// line the builtin was run from; see [`crate::diagnostics`]. The category
+/// is taken from the format string unless given before it.
+macro_rules! diagnostic {
+    ($context:expr, $builtin:expr, category = $category:expr, $fmt:literal $(, $arg:expr)* $(,)?) => {{
+        let message = format!($fmt $(, $arg)*);
+        let line = $crate::diagnostics::current_line(&*$context.shell);
+        $crate::diagnostics::report($category, $builtin, &message, line, 0);
+        writeln!($context.stderr(), "conch: {}: {}", $builtin, message)
+    }};
+    ($context:expr, $builtin:expr, $fmt:literal $(, $arg:expr)* $(,)?) => {
+        diagnostic!(
+            $context,
+            $builtin,
+            category = $crate::diagnostics::category_of($fmt),
+            $fmt
+            $(, $arg)*
+        )
+    };
+}
+
+mod convert;
+mod csv;
 mod grep;
 mod jq;
+mod printf;
 mod tool;
 
+pub use convert::{Json2YamlCommand, Toml2JsonCommand, Yaml2JsonCommand};
+pub use csv::CsvCommand;
 pub use grep::GrepCommand;
 pub use jq::JqCommand;
+pub use printf::PrintfCommand;
 pub use tool::ToolCommand;
 
 // Hand-rolled coreutils: lite build only (no subprocess spawning available).
@@ -65,16 +95,85 @@ pub use touch::TouchCommand;
 pub use wc::WcCommand;
 
 use std::collections::HashMap;
+use std::time::{Duration, Instant};
+
+use brush_core::{ExecutionContext, ExecutionResult, builtins, extensions::ShellExtensions};
+
+/// When a builtin must stop, so a pathological regex or filter fails on its
+/// own instead of using up the whole script's time before later stages
+/// report anything. The host sets the limit, out of the script's reach.
+struct Deadline {
+    at: Option<Instant>,
+    limit: Duration,
+}
+
+impl Deadline {
+    /// Start the time limit the host sets for builtins.
+    fn start() -> Self {
+        #[cfg(feature = "subprocess")]
+        let limit = Duration::from_millis(crate::conch::shell::limits::builtin_timeout_ms());
+        #[cfg(not(feature = "subprocess"))]
+        let limit = Duration::ZERO;
+        Self {
+            at: (!limit.is_zero()).then(|| Instant::now() + limit),
+            limit,
+        }
+    }
+
+    /// The time left before the limit, or `None` if there is no limit.
+    fn remaining(&self) -> Option<Duration> {
+        self.at
+            .map(|at| at.saturating_duration_since(Instant::now()))
+    }
 
-use brush_core::{builtins, extensions::ShellExtensions};
+    /// Whether the time limit has passed.
+    fn expired(&self) -> bool {
+        self.at.is_some_and(|at| Instant::now() >= at)
+    }
+
+    /// Report that `builtin` ran out of time, returning the status it exits
+    /// with, 124 as for `timeout`.
+    fn exceeded<SE: ShellExtensions>(
+        &self,
+        context: &mut ExecutionContext<'_, SE>,
+        builtin: &str,
+    ) -> Result<ExecutionResult, brush_core::Error> {
+        use std::io::Write;
+
+        diagnostic!(
+            context,
+            builtin,
+            "time limit of {}s exceeded",
+            self.limit.as_secs_f64()
+        )?;
+        Ok(ExecutionResult::new(124))
+    }
+}
 
 /// Register all conch builtins with the shell.
 pub fn register_builtins<SE: ShellExtensions>(
     builtins: &mut HashMap<String, builtins::Registration<SE>>,
 ) {
+    builtins.insert("csv".into(), builtins::simple_builtin::<CsvCommand, SE>());
     builtins.insert("grep".into(), builtins::simple_builtin::<GrepCommand, SE>());
     builtins.insert("jq".into(), builtins::simple_builtin::<JqCommand, SE>());
+    builtins.insert(
+        "json2yaml".into(),
+        builtins::simple_builtin::<Json2YamlCommand, SE>(),
+    );
+    builtins.insert(
+        "printf".into(),
+        builtins::simple_builtin::<PrintfCommand, SE>(),
+    );
+    builtins.insert(
+        "toml2json".into(),
+        builtins::simple_builtin::<Toml2JsonCommand, SE>(),
+    );
     builtins.insert("tool".into(), builtins::simple_builtin::<ToolCommand, SE>());
+    builtins.insert(
+        "yaml2json".into(),
+        builtins::simple_builtin::<Yaml2JsonCommand, SE>(),
+    );
 
     // Lite build only: spawned uutils coreutils replace these when the
     // `subprocess` feature is enabled (host builds).
@@ -98,3 +197,127 @@ pub fn register_builtins<SE: ShellExtensions>(
         builtins.insert("wc".into(), builtins::simple_builtin::<WcCommand, SE>());
     }
 }
+
+/// Remove the conch builtins `allowed` does not name from `builtins`,
+/// including the `printf` that replaces brush's. The shell's other builtins
+/// stay, since scripts cannot run without them.
+pub fn restrict_builtins<SE: ShellExtensions>(
+    builtins: &mut HashMap<String, builtins::Registration<SE>>,
+    allowed: &[String],
+) {
+    let mut ours: HashMap<String, builtins::Registration<SE>> = HashMap::new();
+    register_builtins(&mut ours);
+    for name in ours.keys() {
+        if !allowed.contains(name) {
+            builtins.remove(name);
+        }
+    }
+}
+
+/// Limits on the regexes a builtin compiles, since patterns often come from
+/// untrusted input. The engine already matches in linear time, RE2-style,
+/// so these bound what compiling a pattern may cost: its length in bytes,
+/// the size in bytes of the compiled program, and how deeply groups and
+/// repetitions may nest. `None` means the engine's default. The host sets
+/// them, out of the script's reach.
+#[derive(Debug, Default)]
+struct RegexLimits {
+    max_pattern: Option<usize>,
+    size_limit: Option<usize>,
+    nest_limit: Option<u32>,
+}
+
+impl RegexLimits {
+    /// The limits the host sets for builtins.
+    fn from_host() -> Self {
+        #[cfg(feature = "subprocess")]
+        {
+            use crate::conch::shell::limits;
+
+            let bytes = |n: u64| usize::try_from(n).ok().filter(|n| *n > 0);
+            Self {
+                max_pattern: bytes(limits::regex_max_pattern_bytes()),
+                size_limit: bytes(limits::regex_max_compiled_bytes()),
+                nest_limit: Some(limits::regex_max_nesting()).filter(|n| *n > 0),
+            }
+        }
+        #[cfg(not(feature = "subprocess"))]
+        Self::default()
+    }
+
+    /// Compile `pattern` within the limits. A pattern that only fails
+    /// because of them is reported as "pattern too complex", with the
+    /// category the Go bindings map to `ErrPatternTooComplex`.
+    fn compile(&self, pattern: &str) -> Result<regex_lite::Regex, String> {
+        if let Some(max) = self.max_pattern.filter(|max| pattern.len() > *max) {
+            return Err(format!(
+                "pattern too complex: {} bytes exceeds the limit of {max}",
+                pattern.len()
+            ));
+        }
+        let mut builder = regex_lite::RegexBuilder::new(pattern);
+        if let Some(limit) = self.size_limit {
+            builder.size_limit(limit);
+        }
+        if let Some(limit) = self.nest_limit {
+            builder.nest_limit(limit);
+        }
+        builder.build().map_err(|e| {
+            let limited = self.size_limit.is_some() || self.nest_limit.is_some();
+            if limited && regex_lite::Regex::new(pattern).is_ok() {
+                format!("pattern too complex: {e}")
+            } else {
+                format!("invalid regex: {e}")
+            }
+        })
+    }
+}
+
+/// Parse the shell variable `name` of `context`, if it is set and parses.
+fn shell_var<T: std::str::FromStr, SE: ShellExtensions>(
+    context: &ExecutionContext<'_, SE>,
+    name: &str,
+) -> Option<T> {
+    let shell = &*context.shell;
+    shell
+        .env()
+        .get(name)
+        .and_then(|(_, var)| var.value().to_cow_str(shell).trim().parse().ok())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_regex_limits() {
+        let limits = RegexLimits::default();
+        assert!(limits.compile("a+b").is_ok());
+        let err = limits.compile("a(").unwrap_err();
+        assert!(err.starts_with("invalid regex: "), "{err}");
+
+        let limits = RegexLimits {
+            max_pattern: Some(4),
+            ..Default::default()
+        };
+        assert!(limits.compile("abcd").is_ok());
+        let err = limits.compile("abcde").unwrap_err();
+        assert!(err.starts_with("pattern too complex: 5 bytes"), "{err}");
+
+        let limits = RegexLimits {
+            nest_limit: Some(2),
+            ..Default::default()
+        };
+        let err = limits.compile("((((a))))").unwrap_err();
+        assert!(err.starts_with("pattern too complex: "), "{err}");
+
+        let limits = RegexLimits {
+            size_limit: Some(64),
+            ..Default::default()
+        };
+        let err = limits.compile("[a-z]{100}").unwrap_err();
+        assert!(err.starts_with("pattern too complex: "), "{err}");
+        let err = limits.compile("a(").unwrap_err();
+        assert!(err.starts_with("invalid regex: "), "{err}");
+    }
+}
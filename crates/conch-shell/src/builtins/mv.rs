@@ -42,7 +42,7 @@ impl builtins::SimpleCommand for MvCommand {
                             // Interactive, no-clobber, verbose - ignore
                         }
                         _ => {
-                            writeln!(context.stderr(), "mv: unknown option: -{}", c)?;
+                            diagnostic!(context, "mv", "unknown option: -{}", c)?;
                             return Ok(ExecutionResult::new(1));
                         }
                     }
@@ -53,7 +53,7 @@ impl builtins::SimpleCommand for MvCommand {
         }
 
         if paths.len() < 2 {
-            writeln!(context.stderr(), "mv: missing destination file operand")?;
+            diagnostic!(context, "mv", "missing destination file operand")?;
             return Ok(ExecutionResult::new(1));
         }
 
@@ -63,7 +63,7 @@ impl builtins::SimpleCommand for MvCommand {
 
         // If multiple sources, dest must be a directory
         if paths.len() > 1 && !dest_path.is_dir() {
-            writeln!(context.stderr(), "mv: target '{}' is not a directory", dest)?;
+            diagnostic!(context, "mv", "target '{}' is not a directory", dest)?;
             return Ok(ExecutionResult::new(1));
         }
 
@@ -73,9 +73,10 @@ impl builtins::SimpleCommand for MvCommand {
             let src_path = std::path::Path::new(source);
 
             if !src_path.exists() {
-                writeln!(
-                    context.stderr(),
-                    "mv: cannot stat '{}': No such file or directory",
+                diagnostic!(
+                    context,
+                    "mv",
+                    "cannot stat '{}': No such file or directory",
                     source
                 )?;
                 exit_code = 1;
@@ -98,24 +99,19 @@ impl builtins::SimpleCommand for MvCommand {
                 // rename might fail across filesystems, try copy+delete
                 if src_path.is_dir() {
                     if let Err(e2) = copy_and_remove_dir(src_path, &actual_dest) {
-                        writeln!(context.stderr(), "mv: cannot move '{}': {}", source, e2)?;
+                        diagnostic!(context, "mv", "cannot move '{}': {}", source, e2)?;
                         exit_code = 1;
                     }
                 } else {
                     match std::fs::copy(src_path, &actual_dest) {
                         Ok(_) => {
                             if let Err(e2) = std::fs::remove_file(src_path) {
-                                writeln!(
-                                    context.stderr(),
-                                    "mv: cannot remove '{}': {}",
-                                    source,
-                                    e2
-                                )?;
+                                diagnostic!(context, "mv", "cannot remove '{}': {}", source, e2)?;
                                 exit_code = 1;
                             }
                         }
                         Err(_) => {
-                            writeln!(context.stderr(), "mv: cannot move '{}': {}", source, e)?;
+                            diagnostic!(context, "mv", "cannot move '{}': {}", source, e)?;
                             exit_code = 1;
                         }
                     }
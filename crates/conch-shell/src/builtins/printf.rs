@@ -0,0 +1,937 @@
+//! printf builtin - bash-compatible formatted output
+//!
+//! Replaces brush's printf so generated scripts get bash's behaviour:
+//! backslash escapes in the format, `%b` and `%q`, flags, `*` widths and
+//! precisions, and reuse of the format while arguments remain. Missing
+//! arguments format as an empty string or zero. `-v VAR` assigns the output
+//! to a variable instead of printing it.
+
+use std::io::Write;
+
+use brush_core::env::{EnvironmentLookup, EnvironmentScope};
+use brush_core::variables::ShellValueLiteral;
+use brush_core::{ExecutionContext, ExecutionResult, ShellExtensions, builtins, error};
+
+pub struct PrintfCommand;
+
+impl builtins::SimpleCommand for PrintfCommand {
+    fn get_content(
+        _name: &str,
+        content_type: builtins::ContentType,
+        _options: &builtins::ContentOptions,
+    ) -> Result<String, brush_core::Error> {
+        match content_type {
+            builtins::ContentType::DetailedHelp => Ok(
+                "Format and print ARGUMENTS under control of FORMAT, reusing FORMAT while \
+                 arguments remain.\n\n\
+                 Supports %d %i %o %u %x %X %f %F %e %E %g %G %c %s, %b (expand escapes \
+                 in the argument) and %q (quote for reuse as shell input), with flags, \
+                 width and precision. -v VAR assigns the output to VAR."
+                    .into(),
+            ),
+            builtins::ContentType::ShortUsage => Ok("printf [-v var] format [arguments]".into()),
+            builtins::ContentType::ShortDescription => {
+                Ok("printf - format and print arguments".into())
+            }
+            builtins::ContentType::ManPage => error::unimp("man page not yet implemented"),
+        }
+    }
+
+    fn execute<SE: ShellExtensions, I: Iterator<Item = S>, S: AsRef<str>>(
+        mut context: ExecutionContext<'_, SE>,
+        args: I,
+    ) -> Result<ExecutionResult, brush_core::Error> {
+        let args: Vec<String> = args.skip(1).map(|s| s.as_ref().to_string()).collect();
+
+        let (var, format, args) = match parse_args(&args) {
+            Ok(parsed) => parsed,
+            Err(e) => {
+                diagnostic!(context, "printf", "{}", e)?;
+                diagnostic!(
+                    context,
+                    "printf",
+                    "usage: printf [-v var] format [arguments]"
+                )?;
+                return Ok(ExecutionResult::new(2));
+            }
+        };
+
+        let formatted = format_all(format, args);
+        for e in &formatted.errors {
+            diagnostic!(context, "printf", "{}", e)?;
+        }
+
+        match var {
+            Some(name) => {
+                let value = String::from_utf8_lossy(&formatted.output).into_owned();
+                if let Err(e) = context.shell.env_mut().update_or_add(
+                    name,
+                    ShellValueLiteral::Scalar(value),
+                    |_| Ok(()),
+                    EnvironmentLookup::Anywhere,
+                    EnvironmentScope::Global,
+                ) {
+                    diagnostic!(context, "printf", "{}: {}", name, e)?;
+                    return Ok(ExecutionResult::new(1));
+                }
+            }
+            None => {
+                context.stdout().write_all(&formatted.output)?;
+                context.stdout().flush()?;
+            }
+        }
+
+        if formatted.errors.is_empty() {
+            Ok(ExecutionResult::success())
+        } else {
+            Ok(ExecutionResult::new(1))
+        }
+    }
+}
+
+/// Split the command line into the `-v` variable, the format and its
+/// arguments.
+fn parse_args(args: &[String]) -> Result<(Option<&str>, &str, &[String]), String> {
+    let mut var = None;
+    let mut rest = args;
+    loop {
+        match rest.first().map(String::as_str) {
+            Some("-v") => {
+                let name = rest.get(1).ok_or("-v: option requires an argument")?;
+                if !is_valid_name(name) {
+                    return Err(format!("`{}': not a valid identifier", name));
+                }
+                var = Some(name.as_str());
+                rest = &rest[2..];
+            }
+            Some("--") => {
+                rest = &rest[1..];
+                break;
+            }
+            Some(opt) if opt.starts_with('-') && opt.len() > 1 => {
+                return Err(format!("{}: invalid option", opt));
+            }
+            _ => break,
+        }
+    }
+    let (format, args) = rest.split_first().ok_or("missing format")?;
+    Ok((var, format, args))
+}
+
+fn is_valid_name(name: &str) -> bool {
+    let mut chars = name.chars();
+    matches!(chars.next(), Some(c) if c.is_ascii_alphabetic() || c == '_')
+        && chars.all(|c| c.is_ascii_alphanumeric() || c == '_')
+}
+
+/// The output of a printf invocation and any diagnostics it produced.
+#[derive(Debug, Default, PartialEq)]
+struct Formatted {
+    output: Vec<u8>,
+    errors: Vec<String>,
+}
+
+/// Largest field width or precision honoured. Bash allows any `int`, but
+/// padding to one that large would exhaust the sandbox's memory.
+const MAX_COUNT: usize = 1 << 20;
+
+/// A field width or precision.
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum Count {
+    Fixed(usize),
+    /// `*`: taken from the next argument.
+    FromArg,
+}
+
+/// One `%` conversion.
+#[derive(Debug, Clone, PartialEq)]
+struct Spec {
+    left: bool,
+    plus: bool,
+    space: bool,
+    alt: bool,
+    zero: bool,
+    width: Option<Count>,
+    precision: Option<Count>,
+    conversion: char,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+enum Piece {
+    Literal(Vec<u8>),
+    Spec(Spec),
+}
+
+/// Parse a format into literal text and conversions. An invalid conversion
+/// ends the format; the pieces before it are still returned.
+fn parse_format(format: &str) -> (Vec<Piece>, Option<String>) {
+    let mut pieces = Vec::new();
+    let mut literal = Vec::new();
+    let bytes = format.as_bytes();
+    let mut i = 0;
+
+    while i < bytes.len() {
+        match bytes[i] {
+            b'\\' => {
+                let (used, expanded) = escape(&format[i + 1..], false);
+                literal.extend(expanded);
+                i += 1 + used;
+            }
+            b'%' if bytes.get(i + 1) == Some(&b'%') => {
+                literal.push(b'%');
+                i += 2;
+            }
+            b'%' => {
+                if !literal.is_empty() {
+                    pieces.push(Piece::Literal(std::mem::take(&mut literal)));
+                }
+                match parse_spec(&format[i + 1..]) {
+                    Ok((used, spec)) => {
+                        pieces.push(Piece::Spec(spec));
+                        i += 1 + used;
+                    }
+                    Err(e) => return (pieces, Some(e)),
+                }
+            }
+            b => {
+                literal.push(b);
+                i += 1;
+            }
+        }
+    }
+    if !literal.is_empty() {
+        pieces.push(Piece::Literal(literal));
+    }
+    (pieces, None)
+}
+
+/// Parse the conversion following a `%`, returning the bytes it used.
+fn parse_spec(s: &str) -> Result<(usize, Spec), String> {
+    let bytes = s.as_bytes();
+    let mut spec = Spec {
+        left: false,
+        plus: false,
+        space: false,
+        alt: false,
+        zero: false,
+        width: None,
+        precision: None,
+        conversion: ' ',
+    };
+    let mut i = 0;
+
+    while let Some(&b) = bytes.get(i) {
+        match b {
+            b'-' => spec.left = true,
+            b'+' => spec.plus = true,
+            b' ' => spec.space = true,
+            b'#' => spec.alt = true,
+            b'0' => spec.zero = true,
+            // Thousands grouping is a no-op outside locales that define it.
+            b'\'' => {}
+            _ => break,
+        }
+        i += 1;
+    }
+
+    let count = |i: &mut usize| -> Option<Count> {
+        if bytes.get(*i) == Some(&b'*') {
+            *i += 1;
+            return Some(Count::FromArg);
+        }
+        let start = *i;
+        while bytes.get(*i).is_some_and(u8::is_ascii_digit) {
+            *i += 1;
+        }
+        (*i > start).then(|| Count::Fixed(s[start..*i].parse().unwrap_or(usize::MAX)))
+    };
+    spec.width = count(&mut i);
+    if bytes.get(i) == Some(&b'.') {
+        i += 1;
+        spec.precision = Some(count(&mut i).unwrap_or(Count::Fixed(0)));
+    }
+
+    // Length modifiers are accepted and ignored: every integer is 64-bit.
+    while bytes
+        .get(i)
+        .is_some_and(|b| matches!(b, b'h' | b'l' | b'L' | b'j' | b'z' | b't'))
+    {
+        i += 1;
+    }
+
+    match s[i..].chars().next() {
+        Some(
+            c @ ('d' | 'i' | 'o' | 'u' | 'x' | 'X' | 'f' | 'F' | 'e' | 'E' | 'g' | 'G' | 'c' | 's'
+            | 'b' | 'q'),
+        ) => {
+            spec.conversion = c;
+            Ok((i + 1, spec))
+        }
+        Some(c) => Err(format!("`{}': invalid format character", c)),
+        None => Err(format!("`%{}': missing format character", s)),
+    }
+}
+
+/// Expand the backslash escape at the start of `s` (just after the
+/// backslash), returning how many bytes of `s` it used and its expansion.
+///
+/// The format string takes `\NNN` octal; `%b` arguments also take bash's
+/// `\0NNN` form.
+fn escape(s: &str, in_b: bool) -> (usize, Vec<u8>) {
+    let bytes = s.as_bytes();
+    let Some(&first) = bytes.first() else {
+        return (0, b"\\".to_vec());
+    };
+
+    let digits = |start: usize, max: usize, radix: u32| -> (usize, u32) {
+        let mut value = 0u32;
+        let mut n = 0;
+        while n < max {
+            match bytes
+                .get(start + n)
+                .and_then(|&b| (b as char).to_digit(radix))
+            {
+                Some(d) => value = value * radix + d,
+                None => break,
+            }
+            n += 1;
+        }
+        (n, value)
+    };
+    let char_bytes = |value: u32| -> Vec<u8> {
+        let c = char::from_u32(value).unwrap_or(char::REPLACEMENT_CHARACTER);
+        c.to_string().into_bytes()
+    };
+
+    let simple = match first {
+        b'a' => Some(0x07),
+        b'b' => Some(0x08),
+        b'e' | b'E' => Some(0x1b),
+        b'f' => Some(0x0c),
+        b'n' => Some(b'\n'),
+        b'r' => Some(b'\r'),
+        b't' => Some(b'\t'),
+        b'v' => Some(0x0b),
+        b'\\' => Some(b'\\'),
+        b'\'' | b'"' | b'?' if !in_b => Some(first),
+        _ => None,
+    };
+    if let Some(b) = simple {
+        return (1, vec![b]);
+    }
+
+    match first {
+        b'0' if in_b => {
+            let (n, value) = digits(1, 3, 8);
+            (1 + n, vec![value as u8])
+        }
+        b'0'..=b'7' => {
+            let (n, value) = digits(0, 3, 8);
+            (n, vec![value as u8])
+        }
+        b'x' => match digits(1, 2, 16) {
+            (0, _) => (1, b"\\x".to_vec()),
+            (n, value) => (1 + n, vec![value as u8]),
+        },
+        b'u' | b'U' => {
+            let max = if first == b'u' { 4 } else { 8 };
+            match digits(1, max, 16) {
+                (0, _) => (1, vec![b'\\', first]),
+                (n, value) => (1 + n, char_bytes(value)),
+            }
+        }
+        _ => {
+            let len = s.chars().next().map_or(1, char::len_utf8);
+            let mut out = vec![b'\\'];
+            out.extend_from_slice(&bytes[..len]);
+            (len, out)
+        }
+    }
+}
+
+/// Expand the escapes in a `%b` argument. Returns true as well if it
+/// contained `\c`, which ends all output.
+fn expand_b(arg: &str) -> (Vec<u8>, bool) {
+    let mut out = Vec::new();
+    let mut rest = arg;
+    while let Some(i) = rest.find('\\') {
+        out.extend_from_slice(rest[..i].as_bytes());
+        if rest[i + 1..].starts_with('c') {
+            return (out, true);
+        }
+        let (used, expanded) = escape(&rest[i + 1..], true);
+        out.extend(expanded);
+        rest = &rest[i + 1 + used..];
+    }
+    out.extend_from_slice(rest.as_bytes());
+    (out, false)
+}
+
+/// Quote `s` so the shell reads it back as a single word, as bash's `%q`
+/// does.
+fn quote(s: &str) -> String {
+    if s.is_empty() {
+        return "''".into();
+    }
+    if s.chars().any(char::is_control) {
+        let mut out = String::from("$'");
+        for c in s.chars() {
+            match c {
+                '\x07' => out.push_str("\\a"),
+                '\x08' => out.push_str("\\b"),
+                '\x1b' => out.push_str("\\E"),
+                '\x0c' => out.push_str("\\f"),
+                '\n' => out.push_str("\\n"),
+                '\r' => out.push_str("\\r"),
+                '\t' => out.push_str("\\t"),
+                '\x0b' => out.push_str("\\v"),
+                '\\' | '\'' => {
+                    out.push('\\');
+                    out.push(c);
+                }
+                c if c.is_control() => out.push_str(&format!("\\{:03o}", c as u32 & 0xff)),
+                c => out.push(c),
+            }
+        }
+        out.push('\'');
+        return out;
+    }
+
+    let mut out = String::with_capacity(s.len());
+    for (i, c) in s.chars().enumerate() {
+        let special = matches!(
+            c,
+            ' ' | '\''
+                | '"'
+                | '\\'
+                | '|'
+                | '&'
+                | ';'
+                | '('
+                | ')'
+                | '<'
+                | '>'
+                | '!'
+                | '{'
+                | '}'
+                | '*'
+                | '['
+                | ']'
+                | '?'
+                | '^'
+                | '$'
+                | '`'
+                | ','
+        ) || (i == 0 && matches!(c, '~' | '#'));
+        if special {
+            out.push('\\');
+        }
+        out.push(c);
+    }
+    out
+}
+
+/// Parse an integer argument the way bash does: decimal, `0x` hex, leading
+/// `0` octal, or `'c` for a character's code. Invalid input yields the
+/// value of its valid prefix and an error.
+fn parse_int(arg: &str) -> (i64, Option<String>) {
+    let s = arg.trim_start();
+    if s.is_empty() {
+        return (0, None);
+    }
+    if let Some(quoted) = s.strip_prefix('\'').or_else(|| s.strip_prefix('"')) {
+        return (quoted.chars().next().map_or(0, |c| c as i64), None);
+    }
+
+    let (negative, digits) = match s.as_bytes()[0] {
+        b'-' => (true, &s[1..]),
+        b'+' => (false, &s[1..]),
+        _ => (false, s),
+    };
+    let (radix, digits) = if let Some(hex) = digits
+        .strip_prefix("0x")
+        .or_else(|| digits.strip_prefix("0X"))
+    {
+        (16, hex)
+    } else if digits.len() > 1 && digits.starts_with('0') {
+        (8, &digits[1..])
+    } else {
+        (10, digits)
+    };
+
+    let end = digits
+        .find(|c: char| !c.is_digit(radix))
+        .unwrap_or(digits.len());
+    let mut error = None;
+    let magnitude = match i128::from_str_radix(&digits[..end], radix) {
+        Ok(v) => v,
+        Err(_) if end == 0 => 0,
+        Err(_) => {
+            error = Some(format!("{}: Numerical result out of range", arg));
+            i128::from(i64::MAX)
+        }
+    };
+    let value = if negative { -magnitude } else { magnitude };
+    let value = i64::try_from(value).unwrap_or_else(|_| {
+        error = Some(format!("{}: Numerical result out of range", arg));
+        if negative { i64::MIN } else { i64::MAX }
+    });
+
+    if end < digits.len() || (end == 0 && radix != 8) {
+        error = Some(format!("{}: invalid number", arg));
+    }
+    (value, error)
+}
+
+/// Parse a floating-point argument, accepting the integer forms too.
+fn parse_float(arg: &str) -> (f64, Option<String>) {
+    let s = arg.trim();
+    if s.is_empty() {
+        return (0.0, None);
+    }
+    if let Ok(v) = s.parse::<f64>() {
+        return (v, None);
+    }
+    let (v, error) = parse_int(arg);
+    (v as f64, error)
+}
+
+/// Expand `format` against `args`, reusing it while arguments remain.
+fn format_all(format: &str, args: &[String]) -> Formatted {
+    let (pieces, parse_error) = parse_format(format);
+    let mut formatter = Formatter {
+        args,
+        next: 0,
+        out: Formatted::default(),
+    };
+    let consumes = pieces.iter().any(|p| matches!(p, Piece::Spec(_)));
+
+    loop {
+        for piece in &pieces {
+            match piece {
+                Piece::Literal(text) => formatter.out.output.extend_from_slice(text),
+                Piece::Spec(spec) => {
+                    if formatter.convert(spec) {
+                        return formatter.out;
+                    }
+                }
+            }
+        }
+        if let Some(e) = &parse_error {
+            formatter.out.errors.push(e.clone());
+            return formatter.out;
+        }
+        if !consumes || formatter.next >= args.len() {
+            return formatter.out;
+        }
+    }
+}
+
+struct Formatter<'a> {
+    args: &'a [String],
+    next: usize,
+    out: Formatted,
+}
+
+impl Formatter<'_> {
+    fn next_arg(&mut self) -> &str {
+        let arg = self.args.get(self.next).map_or("", String::as_str);
+        self.next += 1;
+        arg
+    }
+
+    fn int_arg(&mut self) -> i64 {
+        let (value, error) = parse_int(self.next_arg());
+        self.out.errors.extend(error);
+        value
+    }
+
+    fn count(&mut self, count: Option<Count>) -> (Option<usize>, bool) {
+        let (n, negative) = match count {
+            None => return (None, false),
+            Some(Count::Fixed(n)) => (u64::try_from(n).unwrap_or(u64::MAX), false),
+            Some(Count::FromArg) => {
+                let n = self.int_arg();
+                (n.unsigned_abs(), n < 0)
+            }
+        };
+        match usize::try_from(n) {
+            Ok(n) if n <= MAX_COUNT => (Some(n), negative),
+            _ => {
+                self.out
+                    .errors
+                    .push(format!("{n}: Numerical result out of range"));
+                (Some(MAX_COUNT), negative)
+            }
+        }
+    }
+
+    /// Format one conversion. Returns true if output must stop (`\c` in a
+    /// `%b` argument).
+    fn convert(&mut self, spec: &Spec) -> bool {
+        let mut spec = spec.clone();
+        let (width, negative_width) = self.count(spec.width);
+        // A negative `*` width means left-justify; a negative `*`
+        // precision is as if none were given.
+        spec.left |= negative_width;
+        let (precision, negative_precision) = self.count(spec.precision);
+        let precision = precision.filter(|_| !negative_precision);
+        let width = width.unwrap_or(0);
+
+        let mut stop = false;
+        let (body, numeric) = match spec.conversion {
+            'd' | 'i' => (format_signed(self.int_arg(), &spec, precision), true),
+            'o' | 'u' | 'x' | 'X' => (format_unsigned(self.int_arg(), &spec, precision), true),
+            'f' | 'F' | 'e' | 'E' | 'g' | 'G' => {
+                let (value, error) = parse_float(self.next_arg());
+                self.out.errors.extend(error);
+                (format_float(value, &spec, precision), true)
+            }
+            'c' => (
+                self.next_arg()
+                    .chars()
+                    .next()
+                    .map(|c| c.to_string().into_bytes())
+                    .unwrap_or_default(),
+                false,
+            ),
+            's' => (truncate(self.next_arg().as_bytes(), precision), false),
+            'b' => {
+                let (expanded, stopped) = expand_b(self.next_arg());
+                stop = stopped;
+                (truncate(&expanded, precision), false)
+            }
+            'q' => (
+                truncate(quote(self.next_arg()).as_bytes(), precision),
+                false,
+            ),
+            _ => unreachable!("parse_spec only accepts known conversions"),
+        };
+
+        let zero = numeric && spec.zero && !spec.left;
+        pad(&mut self.out.output, &body, width, spec.left, zero);
+        stop
+    }
+}
+
+/// Keep the first `precision` characters of `s`.
+fn truncate(s: &[u8], precision: Option<usize>) -> Vec<u8> {
+    match (precision, std::str::from_utf8(s)) {
+        (None, _) => s.to_vec(),
+        (Some(p), Ok(text)) => text
+            .char_indices()
+            .nth(p)
+            .map_or(s, |(i, _)| &s[..i])
+            .to_vec(),
+        (Some(p), Err(_)) => s[..p.min(s.len())].to_vec(),
+    }
+}
+
+/// Append `body` padded to `width` characters. Zero padding goes after any
+/// sign or `0x` prefix.
+fn pad(out: &mut Vec<u8>, body: &[u8], width: usize, left: bool, zero: bool) {
+    let len = std::str::from_utf8(body).map_or(body.len(), |s| s.chars().count());
+    let fill = width.saturating_sub(len);
+    if fill == 0 {
+        out.extend_from_slice(body);
+    } else if left {
+        out.extend_from_slice(body);
+        out.extend(std::iter::repeat_n(b' ', fill));
+    } else if zero && body.iter().any(u8::is_ascii_digit) {
+        let mut prefix = 0;
+        if matches!(body.first(), Some(b'-' | b'+' | b' ')) {
+            prefix = 1;
+        }
+        if body[prefix..].starts_with(b"0x") || body[prefix..].starts_with(b"0X") {
+            prefix += 2;
+        }
+        out.extend_from_slice(&body[..prefix]);
+        out.extend(std::iter::repeat_n(b'0', fill));
+        out.extend_from_slice(&body[prefix..]);
+    } else {
+        out.extend(std::iter::repeat_n(b' ', fill));
+        out.extend_from_slice(body);
+    }
+}
+
+fn sign(negative: bool, spec: &Spec) -> &'static str {
+    if negative {
+        "-"
+    } else if spec.plus {
+        "+"
+    } else if spec.space {
+        " "
+    } else {
+        ""
+    }
+}
+
+/// Left-pad `digits` with zeros to `precision`; a zero precision prints
+/// nothing for zero.
+fn min_digits(digits: String, precision: Option<usize>) -> String {
+    match precision {
+        Some(0) if digits == "0" => String::new(),
+        Some(p) if digits.len() < p => format!("{}{}", "0".repeat(p - digits.len()), digits),
+        _ => digits,
+    }
+}
+
+fn format_signed(value: i64, spec: &Spec, precision: Option<usize>) -> Vec<u8> {
+    let digits = min_digits(value.unsigned_abs().to_string(), precision);
+    format!("{}{}", sign(value < 0, spec), digits).into_bytes()
+}
+
+fn format_unsigned(value: i64, spec: &Spec, precision: Option<usize>) -> Vec<u8> {
+    // Negative values wrap, as in C.
+    let value = value as u64;
+    let digits = match spec.conversion {
+        'o' => format!("{:o}", value),
+        'x' => format!("{:x}", value),
+        'X' => format!("{:X}", value),
+        _ => value.to_string(),
+    };
+    let mut digits = min_digits(digits, precision);
+    if spec.alt {
+        match spec.conversion {
+            'o' if !digits.starts_with('0') => digits.insert(0, '0'),
+            'x' if value != 0 => digits.insert_str(0, "0x"),
+            'X' if value != 0 => digits.insert_str(0, "0X"),
+            _ => {}
+        }
+    }
+    digits.into_bytes()
+}
+
+fn format_float(value: f64, spec: &Spec, precision: Option<usize>) -> Vec<u8> {
+    let upper = spec.conversion.is_ascii_uppercase();
+    let magnitude = value.abs();
+    let body = if magnitude.is_nan() {
+        "nan".to_string()
+    } else if magnitude.is_infinite() {
+        "inf".to_string()
+    } else {
+        let precision = precision.unwrap_or(6);
+        match spec.conversion {
+            'f' | 'F' => fixed(magnitude, precision, spec.alt),
+            'e' | 'E' => exponential(magnitude, precision, spec.alt),
+            _ => general(magnitude, precision, spec.alt),
+        }
+    };
+    let body = if upper { body.to_uppercase() } else { body };
+    let negative = value.is_sign_negative() && !value.is_nan();
+    format!("{}{}", sign(negative, spec), body).into_bytes()
+}
+
+fn fixed(value: f64, precision: usize, alt: bool) -> String {
+    let mut s = format!("{:.*}", precision, value);
+    if alt && precision == 0 {
+        s.push('.');
+    }
+    s
+}
+
+fn exponential(value: f64, precision: usize, alt: bool) -> String {
+    let s = format!("{:.*e}", precision, value);
+    let (mantissa, exponent) = s.split_once('e').unwrap_or((&s, "0"));
+    let exponent: i32 = exponent.parse().unwrap_or(0);
+    let dot = if alt && precision == 0 { "." } else { "" };
+    format!(
+        "{}{}e{}{:02}",
+        mantissa,
+        dot,
+        if exponent < 0 { '-' } else { '+' },
+        exponent.unsigned_abs()
+    )
+}
+
+/// `%g`: the shorter of `%e` and `%f` for the given significant digits,
+/// without trailing zeros unless `#` was given.
+fn general(value: f64, precision: usize, alt: bool) -> String {
+    let significant = precision.max(1);
+    let exponent = if value == 0.0 {
+        0
+    } else {
+        let s = format!("{:.*e}", significant - 1, value);
+        s.split_once('e')
+            .and_then(|(_, e)| e.parse::<i32>().ok())
+            .unwrap_or(0)
+    };
+
+    let s = if exponent < -4 || exponent >= significant as i32 {
+        exponential(value, significant - 1, alt)
+    } else {
+        fixed(value, (significant as i32 - 1 - exponent) as usize, alt)
+    };
+    if alt {
+        return s;
+    }
+    let (number, exponent) = match s.find('e') {
+        Some(i) => s.split_at(i),
+        None => (s.as_str(), ""),
+    };
+    let number = if number.contains('.') {
+        number.trim_end_matches('0').trim_end_matches('.')
+    } else {
+        number
+    };
+    format!("{}{}", number, exponent)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn printf(format: &str, args: &[&str]) -> String {
+        let args: Vec<String> = args.iter().map(|s| s.to_string()).collect();
+        let formatted = format_all(format, &args);
+        assert!(
+            formatted.errors.is_empty(),
+            "errors: {:?}",
+            formatted.errors
+        );
+        String::from_utf8(formatted.output).expect("invalid UTF-8")
+    }
+
+    #[test]
+    fn test_escapes_in_format() {
+        assert_eq!(printf("a\\tb\\n", &[]), "a\tb\n");
+        assert_eq!(printf("\\101\\x42\\u00e9\\\\\\q", &[]), "AB\u{e9}\\\\q");
+        assert_eq!(printf("100%%\\n", &[]), "100%\n");
+    }
+
+    #[test]
+    fn test_argument_cycling() {
+        assert_eq!(
+            printf("%s=%s\\n", &["a", "1", "b", "2", "c"]),
+            "a=1\nb=2\nc=\n"
+        );
+        assert_eq!(printf("x\\n", &["ignored", "args"]), "x\n");
+        assert_eq!(printf("%s,", &[]), ",");
+    }
+
+    #[test]
+    fn test_width_and_precision() {
+        assert_eq!(
+            printf("[%5s][%-5s][%.2s]", &["ab", "ab", "abc"]),
+            "[   ab][ab   ][ab]"
+        );
+        assert_eq!(printf("[%*s][%-*d]", &["4", "x", "3", "7"]), "[   x][7  ]");
+        assert_eq!(printf("[%*s]", &["-3", "x"]), "[x  ]");
+        assert_eq!(
+            printf("[%05d][%+d][% d][%.3d]", &["-42", "5", "5", "7"]),
+            "[-0042][+5][ 5][007]"
+        );
+        assert_eq!(printf("[%3s]", &["\u{e9}"]), "[  \u{e9}]");
+    }
+
+    #[test]
+    fn test_integers() {
+        assert_eq!(printf("%d %i", &["0x1f", "010"]), "31 8");
+        assert_eq!(printf("%d", &["'A"]), "65");
+        assert_eq!(
+            printf("%x %X %o %#x %#o", &["255", "255", "8", "255", "8"]),
+            "ff FF 10 0xff 010"
+        );
+        assert_eq!(printf("%u", &["-1"]), "18446744073709551615");
+        assert_eq!(printf("%#010x", &["255"]), "0x000000ff");
+        assert_eq!(printf("%d", &[]), "0");
+    }
+
+    #[test]
+    fn test_floats() {
+        assert_eq!(
+            printf("%f %.2f %.0f", &["1.5", "3.14159", "2.5"]),
+            "1.500000 3.14 2"
+        );
+        assert_eq!(
+            printf("%e %.2E", &["1234.5", "0.000123"]),
+            "1.234500e+03 1.23E-04"
+        );
+        assert_eq!(
+            printf("%g %g %g %g", &["100000", "1000000", "0.0001", "0.5"]),
+            "100000 1e+06 0.0001 0.5"
+        );
+        assert_eq!(
+            printf("%G %.3g", &["0.00001234", "3.14159"]),
+            "1.234E-05 3.14"
+        );
+        assert_eq!(printf("%08.3f", &["-3.14159"]), "-003.142");
+        assert_eq!(printf("%f", &["5"]), "5.000000");
+    }
+
+    #[test]
+    fn test_b_conversion() {
+        assert_eq!(printf("%b|%s", &["a\\nb", "a\\nb"]), "a\nb|a\\nb");
+        assert_eq!(printf("%b", &["\\0101\\101"]), "AA");
+        assert_eq!(printf("%b%s", &["stop\\chere", "never"]), "stop");
+    }
+
+    #[test]
+    fn test_q_conversion() {
+        assert_eq!(quote("plain-word_1.txt"), "plain-word_1.txt");
+        assert_eq!(quote(""), "''");
+        assert_eq!(quote("a b'c"), "a\\ b\\'c");
+        assert_eq!(quote("$HOME;~x"), "\\$HOME\\;~x");
+        assert_eq!(quote("~x"), "\\~x");
+        assert_eq!(quote("a\nb\x01"), "$'a\\nb\\001'");
+        assert_eq!(printf("%q %q", &["x y", "z"]), "x\\ y z");
+    }
+
+    #[test]
+    fn test_errors() {
+        let formatted = format_all("%d|%d\\n", &["12abc".into(), "x".into()]);
+        assert_eq!(formatted.output, b"12|0\n");
+        assert_eq!(
+            formatted.errors,
+            vec!["12abc: invalid number", "x: invalid number"]
+        );
+
+        let formatted = format_all("a%yb", &[]);
+        assert_eq!(formatted.output, b"a");
+        assert_eq!(formatted.errors, vec!["`y': invalid format character"]);
+    }
+
+    #[test]
+    fn test_huge_width_and_precision() {
+        for (format, args) in [
+            ("%*d", vec!["9223372036854775807", "1"]),
+            ("%*d", vec!["-9223372036854775808", "1"]),
+            ("%.*f", vec!["4294967296", "1"]),
+            ("%99999999999999999999s", vec!["x"]),
+        ] {
+            let args: Vec<String> = args.into_iter().map(String::from).collect();
+            let formatted = format_all(format, &args);
+            assert!(formatted.output.len() < 2 * MAX_COUNT, "{format}");
+            assert_eq!(
+                formatted.errors.len(),
+                1,
+                "{format}: {:?}",
+                formatted.errors
+            );
+            assert!(
+                formatted.errors[0].ends_with("Numerical result out of range"),
+                "{format}: {:?}",
+                formatted.errors
+            );
+        }
+    }
+
+    #[test]
+    fn test_parse_args() {
+        let args: Vec<String> = ["-v", "out", "--", "%s", "x"]
+            .iter()
+            .map(|s| s.to_string())
+            .collect();
+        let (var, format, rest) = parse_args(&args).expect("parse failed");
+        assert_eq!((var, format, rest.len()), (Some("out"), "%s", 1));
+
+        let bad = |args: &[&str]| {
+            let args: Vec<String> = args.iter().map(|s| s.to_string()).collect();
+            parse_args(&args).is_err()
+        };
+        assert!(bad(&[]));
+        assert!(bad(&["-v"]));
+        assert!(bad(&["-v", "a[1]", "x"]));
+        assert!(bad(&["-x", "fmt"]));
+    }
+}
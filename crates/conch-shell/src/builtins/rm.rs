@@ -68,7 +68,7 @@ impl builtins::SimpleCommand for RmCommand {
                             // Interactive, verbose, directory - ignore for simplicity
                         }
                         _ => {
-                            writeln!(context.stderr(), "rm: unknown option: -{}", c)?;
+                            diagnostic!(context, "rm", "unknown option: -{}", c)?;
                             return Ok(ExecutionResult::new(1));
                         }
                     }
@@ -80,7 +80,7 @@ impl builtins::SimpleCommand for RmCommand {
 
         if files.is_empty() {
             if !force {
-                writeln!(context.stderr(), "rm: missing operand")?;
+                diagnostic!(context, "rm", "missing operand")?;
                 return Ok(ExecutionResult::new(1));
             }
             return Ok(ExecutionResult::new(0));
@@ -93,9 +93,10 @@ impl builtins::SimpleCommand for RmCommand {
 
             if !path.exists() {
                 if !force {
-                    writeln!(
-                        context.stderr(),
-                        "rm: cannot remove '{}': No such file or directory",
+                    diagnostic!(
+                        context,
+                        "rm",
+                        "cannot remove '{}': No such file or directory",
                         file
                     )?;
                     exit_code = 1;
@@ -109,11 +110,7 @@ impl builtins::SimpleCommand for RmCommand {
                     // doesn't work properly with WASI preview2-shim VFS
                     remove_dir_recursive(path)
                 } else {
-                    writeln!(
-                        context.stderr(),
-                        "rm: cannot remove '{}': Is a directory",
-                        file
-                    )?;
+                    diagnostic!(context, "rm", "cannot remove '{}': Is a directory", file)?;
                     exit_code = 1;
                     continue;
                 }
@@ -124,7 +121,7 @@ impl builtins::SimpleCommand for RmCommand {
             if let Err(e) = result
                 && !force
             {
-                writeln!(context.stderr(), "rm: cannot remove '{}': {}", file, e)?;
+                diagnostic!(context, "rm", "cannot remove '{}': {}", file, e)?;
                 exit_code = 1;
             }
         }
@@ -99,7 +99,7 @@ impl builtins::SimpleCommand for TailCommand {
                 match std::fs::read(file) {
                     Ok(data) => data,
                     Err(e) => {
-                        writeln!(context.stderr(), "tail: {}: {}", file, e)?;
+                        diagnostic!(context, "tail", "{}: {}", file, e)?;
                         exit_code = 1;
                         continue;
                     }
@@ -93,7 +93,7 @@ impl builtins::SimpleCommand for ToolCommand {
         let request = match parse_tool_args(&args) {
             Ok(req) => req,
             Err(e) => {
-                writeln!(context.stderr(), "tool: {}", e)?;
+                diagnostic!(context, "tool", "{}", e)?;
                 return Ok(ExecutionResult::new(1));
             }
         };
@@ -135,7 +135,7 @@ impl builtins::SimpleCommand for ToolCommand {
                 Ok(ExecutionResult::new(0))
             } else {
                 // Write error to stderr
-                writeln!(context.stderr(), "tool: {}", result.output)?;
+                diagnostic!(context, "tool", "{}", result.output)?;
                 Ok(ExecutionResult::new(1))
             }
         }
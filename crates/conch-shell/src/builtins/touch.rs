@@ -46,7 +46,7 @@ impl builtins::SimpleCommand for TouchCommand {
                             // For simplicity, we ignore them
                         }
                         _ => {
-                            writeln!(context.stderr(), "touch: unknown option: -{}", c)?;
+                            diagnostic!(context, "touch", "unknown option: -{}", c)?;
                             return Ok(ExecutionResult::new(1));
                         }
                     }
@@ -57,7 +57,7 @@ impl builtins::SimpleCommand for TouchCommand {
         }
 
         if files.is_empty() {
-            writeln!(context.stderr(), "touch: missing file operand")?;
+            diagnostic!(context, "touch", "missing file operand")?;
             return Ok(ExecutionResult::new(1));
         }
 
@@ -71,13 +71,13 @@ impl builtins::SimpleCommand for TouchCommand {
                 // In WASM VFS, this is a no-op since we don't track real timestamps
                 // But we try to "touch" it by opening and closing
                 if let Err(e) = std::fs::OpenOptions::new().write(true).open(path) {
-                    writeln!(context.stderr(), "touch: cannot touch '{}': {}", file, e)?;
+                    diagnostic!(context, "touch", "cannot touch '{}': {}", file, e)?;
                     exit_code = 1;
                 }
             } else if !no_create {
                 // Create the file
                 if let Err(e) = std::fs::File::create(path) {
-                    writeln!(context.stderr(), "touch: cannot touch '{}': {}", file, e)?;
+                    diagnostic!(context, "touch", "cannot touch '{}': {}", file, e)?;
                     exit_code = 1;
                 }
             }
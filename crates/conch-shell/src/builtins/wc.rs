@@ -45,7 +45,7 @@ impl builtins::SimpleCommand for WcCommand {
                         'm' => show_chars = true,
                         'c' => show_bytes = true,
                         _ => {
-                            writeln!(context.stderr(), "wc: unknown option: -{}", c)?;
+                            diagnostic!(context, "wc", "unknown option: -{}", c)?;
                             return Ok(ExecutionResult::new(1));
                         }
                     }
@@ -84,7 +84,7 @@ impl builtins::SimpleCommand for WcCommand {
                 match std::fs::read(file) {
                     Ok(data) => data,
                     Err(e) => {
-                        writeln!(context.stderr(), "wc: {}: {}", file, e)?;
+                        diagnostic!(context, "wc", "{}: {}", file, e)?;
                         exit_code = 1;
                         continue;
                     }
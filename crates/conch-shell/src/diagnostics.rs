@@ -0,0 +1,114 @@
+//! Errors reported to the host as they happen.
+//!
+//! The shell and the conch builtins write their errors to stderr, where a
+//! script's own output can look just like them. They also report each one
+//! here, through the `conch:shell/diagnostics` import, so the host can
+//! describe a failure without parsing stderr. The lite build has no host to
+//! report to and only writes stderr.
+
+use brush_core::Shell;
+use brush_core::extensions::ShellExtensions;
+
+/// Categories of diagnostics, named as the Go bindings' `Category*`
+/// constants are.
+pub(crate) const SYNTAX: &str = "syntax";
+pub(crate) const COMMAND_NOT_FOUND: &str = "command-not-found";
+pub(crate) const NO_SUCH_FILE: &str = "no-such-file";
+pub(crate) const PERMISSION_DENIED: &str = "permission-denied";
+pub(crate) const COMMAND_FAILED: &str = "command-failed";
+pub(crate) const PATTERN_TOO_COMPLEX: &str = "pattern-too-complex";
+
+/// Report an error `command` ran into, or the shell if it is empty, at
+/// `line` and `column` of the script (0 if not known).
+pub(crate) fn report(category: &str, command: &str, message: &str, line: u32, column: u32) {
+    #[cfg(feature = "subprocess")]
+    crate::conch::shell::diagnostics::report(&crate::conch::shell::diagnostics::Diagnostic {
+        category: category.to_string(),
+        command: command.to_string(),
+        message: message.to_string(),
+        line,
+        column,
+    });
+    #[cfg(not(feature = "subprocess"))]
+    let _ = (category, command, message, line, column);
+}
+
+/// The line of the script `shell` is running, from `$LINENO`, or 0.
+pub(crate) fn current_line<SE: ShellExtensions>(shell: &Shell<SE>) -> u32 {
+    shell
+        .env()
+        .get("LINENO")
+        .and_then(|(_, var)| var.value().to_cow_str(shell).trim().parse().ok())
+        .unwrap_or(0)
+}
+
+/// The category of a builtin error written with the format string `fmt`.
+///
+/// Only the format string is looked at, never the arguments, since those
+/// often hold file names or patterns the script chose.
+pub(crate) fn category_of(fmt: &str) -> &'static str {
+    if fmt.contains("No such file or directory") {
+        NO_SUCH_FILE
+    } else if fmt.contains("Permission denied") {
+        PERMISSION_DENIED
+    } else {
+        COMMAND_FAILED
+    }
+}
+
+/// The category of a builtin error caused by the I/O error `err`.
+pub(crate) fn io_category(err: &std::io::Error) -> &'static str {
+    match err.kind() {
+        std::io::ErrorKind::NotFound => NO_SUCH_FILE,
+        std::io::ErrorKind::PermissionDenied => PERMISSION_DENIED,
+        _ => COMMAND_FAILED,
+    }
+}
+
+/// Report `err`, an error the shell itself ran into while running a
+/// script. Syntax errors are placed where the parser stopped, others on the
+/// line `shell` was running.
+pub(crate) fn report_shell_error<SE: ShellExtensions>(err: &brush_core::Error, shell: &Shell<SE>) {
+    let (category, command) = match err {
+        brush_core::Error::CommandNotFound(name) => (COMMAND_NOT_FOUND, name.as_str()),
+        brush_core::Error::ParseError(..) => (SYNTAX, ""),
+        _ => (COMMAND_FAILED, ""),
+    };
+    let (line, column) = match err {
+        brush_core::Error::ParseError(err, ..) => parse_position(err),
+        _ => (current_line(shell), 0),
+    };
+    report(category, command, &err.to_string(), line, column);
+}
+
+/// The line and column the parser stopped at for `err`, or 0 for those it
+/// does not say, as at the end of the input.
+fn parse_position(err: &brush_parser::ParseError) -> (u32, u32) {
+    let position = match err {
+        brush_parser::ParseError::ParsingNearToken(token) => Some(&token.location().start),
+        brush_parser::ParseError::Tokenizing { position, .. } => position.as_ref(),
+        _ => None,
+    };
+    position.map_or((0, 0), |p| {
+        (
+            u32::try_from(p.line).unwrap_or(0),
+            u32::try_from(p.column).unwrap_or(0),
+        )
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_category_of() {
+        assert_eq!(
+            category_of("cannot remove '{}': No such file or directory"),
+            NO_SUCH_FILE
+        );
+        assert_eq!(category_of("{}: Permission denied"), PERMISSION_DENIED);
+        // Arguments are not looked at.
+        assert_eq!(category_of("{}"), COMMAND_FAILED);
+    }
+}
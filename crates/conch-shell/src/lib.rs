@@ -8,12 +8,14 @@
 #![allow(missing_docs)] // WIT-generated code doesn't have docs
 
 use std::cell::RefCell;
+use std::sync::Arc;
 
 use brush_core::env::{EnvironmentLookup, EnvironmentScope};
 use brush_core::variables::ShellValueLiteral;
 use brush_core::{ExecutionParameters, Shell, SourceInfo};
 
 mod builtins;
+mod diagnostics;
 
 // Generate WIT bindings for the appropriate world.
 // The full sandbox includes subprocess spawning; the lite variant does not.
@@ -68,6 +70,44 @@ where
     })
 }
 
+/// How often a running script checks for a signal from the host.
+#[cfg(feature = "subprocess")]
+const SIGNAL_POLL_INTERVAL: std::time::Duration = std::time::Duration::from_millis(10);
+
+/// Wait for the host to deliver a signal, returning its number.
+#[cfg(feature = "subprocess")]
+async fn pending_signal() -> u8 {
+    loop {
+        if let Some(signal) = conch::shell::signals::take_pending() {
+            return signal;
+        }
+        tokio::time::sleep(SIGNAL_POLL_INTERVAL).await;
+    }
+}
+
+/// Formats the shell's errors, reporting each to the host and telling it
+/// when one was a function call past its depth limit so it can fail the
+/// execution on that limit.
+struct ErrorReporter;
+
+impl brush_core::ErrorFormatter for ErrorReporter {
+    fn format_error(&self, err: &brush_core::Error, shell: &Shell) -> String {
+        report_limit(err);
+        diagnostics::report_shell_error(err, shell);
+        format!("error: {err:#}\n")
+    }
+}
+
+/// Tell the host if `err` was a function call past its depth limit.
+fn report_limit(err: &brush_core::Error) {
+    #[cfg(feature = "subprocess")]
+    if matches!(err, brush_core::Error::MaxFunctionCallDepthExceeded) {
+        conch::shell::limits::call_depth_exceeded();
+    }
+    #[cfg(not(feature = "subprocess"))]
+    let _ = err;
+}
+
 /// A persistent shell instance that maintains state across executions.
 ///
 /// This struct holds the brush shell, allowing variables, functions, and
@@ -96,11 +136,26 @@ impl exports::conch::shell::shell::GuestInstance for ShellInstance {
         let mut shell_builtins =
             brush_builtins::default_builtins(brush_builtins::BuiltinSet::BashMode);
         builtins::register_builtins(&mut shell_builtins);
+        #[cfg(feature = "subprocess")]
+        if let Some(allowed) = conch::shell::limits::allowed_commands() {
+            builtins::restrict_builtins(&mut shell_builtins, &allowed);
+        }
+
+        // Without a limit from the host, recursion is bounded only by the
+        // wasm stack.
+        #[cfg(feature = "subprocess")]
+        let max_call_depth = usize::try_from(conch::shell::limits::max_call_depth())
+            .ok()
+            .filter(|depth| *depth > 0);
+        #[cfg(not(feature = "subprocess"))]
+        let max_call_depth = None;
 
         // Create the shell using the global runtime
         let shell = block_on(async {
             Shell::builder()
                 .builtins(shell_builtins)
+                .maybe_max_function_call_depth(max_call_depth)
+                .error_formatter(Arc::new(tokio::sync::Mutex::new(ErrorReporter)))
                 .build()
                 .await
                 .expect("failed to create shell")
@@ -149,10 +204,24 @@ impl exports::conch::shell::shell::GuestInstance for ShellInstance {
             let source_info = SourceInfo::default();
             let exec_params = ExecutionParameters::default();
 
-            shell
-                .run_string(&script, &source_info, &exec_params)
-                .await
-                .map_err(|e| format!("execution error: {}", e))
+            let run = shell.run_string(&script, &source_info, &exec_params);
+            // Stop the script the next time it waits, such as on a pipeline,
+            // once the host delivers a signal; the host runs its trap
+            // handlers next.
+            #[cfg(feature = "subprocess")]
+            let run = async {
+                tokio::select! {
+                    result = run => result,
+                    signal = pending_signal() => {
+                        Ok(brush_core::ExecutionResult::new(128 + signal))
+                    }
+                }
+            };
+            run.await.map_err(|e| {
+                report_limit(&e);
+                diagnostics::report_shell_error(&e, &*shell);
+                format!("execution error: {}", e)
+            })
         })?;
 
         // Our stdout/stderr are line-buffered (std::io::Stdout wraps a
@@ -355,6 +424,46 @@ mod tests {
         rt.block_on(execute_sequence_quiet(scripts))
     }
 
+    #[test]
+    fn test_restrict_builtins() {
+        let rt = tokio::runtime::Builder::new_current_thread()
+            .enable_all()
+            .build()
+            .expect("failed to create runtime");
+        let results = rt.block_on(async {
+            let mut shell_builtins =
+                brush_builtins::default_builtins(brush_builtins::BuiltinSet::BashMode);
+            crate::builtins::register_builtins(&mut shell_builtins);
+            crate::builtins::restrict_builtins(&mut shell_builtins, &["jq".to_string()]);
+
+            let mut shell = Shell::builder()
+                .builtins(shell_builtins)
+                .fds(HashMap::from([
+                    (1, openfiles::null().unwrap()),
+                    (2, openfiles::null().unwrap()),
+                ]))
+                .build()
+                .await
+                .expect("failed to create shell");
+
+            let mut results = Vec::new();
+            for script in ["type -t jq", "type -t tool", "type -t echo"] {
+                let result = shell
+                    .run_string(
+                        script,
+                        &SourceInfo::default(),
+                        &ExecutionParameters::default(),
+                    )
+                    .await
+                    .expect("execution error");
+                results.push(i32::from(u8::from(result.exit_code)));
+            }
+            results
+        });
+        // jq was allowed and echo is the shell's own; tool is gone.
+        assert_eq!(results, vec![0, 1, 0]);
+    }
+
     #[test]
     fn test_echo() {
         let result = execute_test("echo hello");
@@ -418,6 +527,42 @@ mod tests {
         ]);
         assert_eq!(results, Ok(vec![0, 0]));
     }
+
+    #[test]
+    fn test_indexed_array() {
+        let result = execute_test(
+            "a=(x y z); a+=(w); unset 'a[0]'; [[ ${#a[@]} == 3 && ${a[1]} == y && ${a[-1]} == w ]]",
+        );
+        assert_eq!(result, Ok(0));
+    }
+
+    #[test]
+    fn test_associative_array() {
+        let result = execute_test(
+            "declare -A m=([k]=v); m[j]=w; m[\"a b\"]=x; \
+             [[ ${m[k]}${m[j]}${m[a b]} == vwx && ${#m[@]} == 3 ]]",
+        );
+        assert_eq!(result, Ok(0));
+    }
+
+    #[test]
+    fn test_associative_array_persistence() {
+        let results = execute_sequence_test(&[
+            "declare -A counts",
+            "for w in a b a; do counts[$w]=$((${counts[$w]:-0} + 1)); done",
+            "[[ ${counts[a]} == 2 && ${counts[b]} == 1 ]]",
+        ]);
+        assert_eq!(results, Ok(vec![0, 0, 0]));
+    }
+
+    #[test]
+    fn test_mapfile() {
+        let result = execute_test(
+            "mapfile -t lines <<< $'one\\ntwo'; read -r -a words <<< 'x y'; \
+             [[ ${#lines[@]} == 2 && ${lines[1]} == two && ${words[1]} == y ]]",
+        );
+        assert_eq!(result, Ok(0));
+    }
 }
 
 #[cfg(test)]
@@ -41,6 +41,13 @@ async fn main() -> Result<(), Box<dyn std::error::Error>> {
         max_memory_bytes: 16 * 1024 * 1024, // 16 MB memory
         max_output_bytes: 4096,             // 4 KB output
         timeout: Duration::from_secs(5),    // 5 second wall clock
+        max_call_depth: 50,                 // 50 nested function calls
+        max_fs_bytes: 1024 * 1024,          // 1 MB of files
+        max_script_bytes: 64 * 1024,        // 64 KB script
+        builtin_timeout_ms: 500,            // 500 ms per grep or jq
+        max_regex_pattern_bytes: 1024,      // 1 KB grep patterns
+        max_regex_compiled_bytes: 0,        // the regex engine's defaults
+        max_regex_nesting: 0,
     };
 
     let result = conch
@@ -0,0 +1,287 @@
+//! Captured output for shell instances.
+//!
+//! An [`OutputCapture`] keeps the first `capacity` bytes the guest writes to
+//! stdout or stderr and counts the rest, so a result can say how much output
+//! the limit dropped. Writes past the limit succeed as far as the guest is
+//! concerned; a script producing too much output keeps running rather than
+//! failing on a closed stream. A capture can instead keep the last
+//! `capacity` bytes, for callers who care about how a script ended.
+
+use std::io;
+use std::pin::Pin;
+use std::sync::{Arc, Mutex};
+use std::task::{Context, Poll};
+
+use tokio::io::AsyncWrite;
+use wasmtime_wasi::cli::{AsyncStdoutStream, IsTerminal, StdoutStream};
+use wasmtime_wasi_io::streams::OutputStream;
+
+/// Maximum bytes the guest may hand to a captured stream per write.
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+const CAPTURE_WRITE_BUDGET: usize = 64 * 1024;
+
+/// A bounded in-memory output stream that remembers its full length.
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+#[derive(Debug, Clone)]
+pub(crate) struct OutputCapture {
+    inner: Arc<Mutex<Captured>>,
+}
+
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+#[derive(Debug)]
+struct Captured {
+    data: Vec<u8>,
+    capacity: usize,
+    /// Bytes written, including those past `capacity`.
+    total: usize,
+    /// Keep the last `capacity` bytes rather than the first. `data` then
+    /// grows to twice `capacity` before its front is dropped, so the copying
+    /// is amortized.
+    tail: bool,
+    /// Sees every write as it happens, including bytes past `capacity`.
+    tap: Option<OutputTap>,
+}
+
+/// A callback observing the writes to an [`OutputCapture`].
+#[derive(Clone)]
+pub(crate) struct OutputTap(pub(crate) Arc<dyn Fn(&[u8]) + Send + Sync>);
+
+impl std::fmt::Debug for OutputTap {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("OutputTap").finish_non_exhaustive()
+    }
+}
+
+/// A read position in an [`OutputCapture`].
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+#[derive(Debug, Default, Clone, Copy)]
+pub(crate) struct CaptureCursor {
+    total: usize,
+}
+
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+impl OutputCapture {
+    /// Create a capture keeping at most `capacity` bytes.
+    pub(crate) fn new(capacity: usize) -> Self {
+        Self {
+            inner: Arc::new(Mutex::new(Captured {
+                data: Vec::new(),
+                capacity,
+                total: 0,
+                tail: false,
+                tap: None,
+            })),
+        }
+    }
+
+    /// Keep the last `capacity` bytes written from now on rather than the
+    /// first.
+    pub(crate) fn keep_tail(&self) {
+        self.inner.lock().unwrap_or_else(|e| e.into_inner()).tail = true;
+    }
+
+    /// Call `tap` with every write from now on. Writes are passed in order,
+    /// under the capture's lock.
+    pub(crate) fn set_tap(&self, tap: OutputTap) {
+        self.inner.lock().unwrap_or_else(|e| e.into_inner()).tap = Some(tap);
+    }
+
+    /// Append `bytes`, dropping whatever does not fit.
+    pub(crate) fn write(&self, bytes: &[u8]) {
+        let mut captured = self.inner.lock().unwrap_or_else(|e| e.into_inner());
+        let capacity = captured.capacity;
+        if captured.tail {
+            captured
+                .data
+                .extend_from_slice(&bytes[bytes.len().saturating_sub(capacity)..]);
+            let len = captured.data.len();
+            if len > capacity.saturating_mul(2) {
+                captured.data.drain(..len - capacity);
+            }
+        } else {
+            let room = capacity.saturating_sub(captured.data.len());
+            let kept = bytes.len().min(room);
+            captured.data.extend_from_slice(&bytes[..kept]);
+        }
+        captured.total = captured.total.saturating_add(bytes.len());
+        if let Some(tap) = &captured.tap {
+            (tap.0)(bytes);
+        }
+    }
+
+    /// The bytes kept so far.
+    pub(crate) fn contents(&self) -> Vec<u8> {
+        self.inner
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .kept()
+            .to_vec()
+    }
+
+    /// The number of bytes written so far, including those dropped.
+    pub(crate) fn total_len(&self) -> usize {
+        self.inner.lock().unwrap_or_else(|e| e.into_inner()).total
+    }
+
+    /// The bytes kept since `cursor`, and how many were written in all
+    /// including those dropped; advances `cursor` past them.
+    pub(crate) fn read_from(&self, cursor: &mut CaptureCursor) -> (Vec<u8>, usize) {
+        let captured = self.inner.lock().unwrap_or_else(|e| e.into_inner());
+        let kept = captured.kept();
+        let start = captured.kept_start();
+        let from = cursor.total.max(start) - start;
+        let new = kept[from.min(kept.len())..].to_vec();
+        let total = captured.total - cursor.total;
+        cursor.total = captured.total;
+        (new, total)
+    }
+}
+
+impl Captured {
+    /// The bytes kept.
+    fn kept(&self) -> &[u8] {
+        if self.tail {
+            &self.data[self.data.len().saturating_sub(self.capacity)..]
+        } else {
+            &self.data
+        }
+    }
+
+    /// The position in the stream of the first byte kept.
+    fn kept_start(&self) -> usize {
+        if self.tail {
+            self.total - self.kept().len()
+        } else {
+            0
+        }
+    }
+}
+
+impl IsTerminal for OutputCapture {
+    fn is_terminal(&self) -> bool {
+        false
+    }
+}
+
+impl StdoutStream for OutputCapture {
+    fn p2_stream(&self) -> Box<dyn OutputStream> {
+        AsyncStdoutStream::new(CAPTURE_WRITE_BUDGET, CaptureWriter(self.clone())).p2_stream()
+    }
+
+    fn async_stream(&self) -> Box<dyn AsyncWrite + Send + Sync> {
+        Box::new(CaptureWriter(self.clone()))
+    }
+}
+
+/// Writes into an [`OutputCapture`]; never blocks or fails.
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+struct CaptureWriter(OutputCapture);
+
+impl AsyncWrite for CaptureWriter {
+    fn poll_write(
+        self: Pin<&mut Self>,
+        _cx: &mut Context<'_>,
+        buf: &[u8],
+    ) -> Poll<io::Result<usize>> {
+        self.0.write(buf);
+        Poll::Ready(Ok(buf.len()))
+    }
+
+    fn poll_flush(self: Pin<&mut Self>, _cx: &mut Context<'_>) -> Poll<io::Result<()>> {
+        Poll::Ready(Ok(()))
+    }
+
+    fn poll_shutdown(self: Pin<&mut Self>, _cx: &mut Context<'_>) -> Poll<io::Result<()>> {
+        Poll::Ready(Ok(()))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tokio::io::AsyncWriteExt;
+
+    #[test]
+    fn test_output_capture_limit() {
+        let capture = OutputCapture::new(8);
+        capture.write(b"hello");
+        assert_eq!(capture.contents(), b"hello");
+        assert_eq!(capture.total_len(), 5);
+
+        capture.write(b" world");
+        assert_eq!(capture.contents(), b"hello wo");
+        assert_eq!(capture.total_len(), 11);
+
+        capture.write(b"!");
+        assert_eq!(capture.contents(), b"hello wo");
+        assert_eq!(capture.total_len(), 12);
+    }
+
+    #[test]
+    fn test_output_capture_read_from() {
+        let capture = OutputCapture::new(6);
+        let mut cursor = CaptureCursor::default();
+        capture.write(b"abcd");
+        assert_eq!(capture.read_from(&mut cursor), (b"abcd".to_vec(), 4));
+
+        capture.write(b"efgh");
+        assert_eq!(capture.read_from(&mut cursor), (b"ef".to_vec(), 4));
+
+        capture.write(b"ij");
+        assert_eq!(capture.read_from(&mut cursor), (Vec::new(), 2));
+        assert_eq!(capture.read_from(&mut cursor), (Vec::new(), 0));
+    }
+
+    #[test]
+    fn test_output_capture_tail() {
+        let capture = OutputCapture::new(4);
+        capture.keep_tail();
+        let mut cursor = CaptureCursor::default();
+        capture.write(b"ab");
+        assert_eq!(capture.contents(), b"ab");
+        capture.write(b"cdef");
+        assert_eq!(capture.contents(), b"cdef");
+        assert_eq!(capture.read_from(&mut cursor), (b"cdef".to_vec(), 6));
+
+        for byte in b"ghijklmnop" {
+            capture.write(&[*byte]);
+        }
+        capture.write(b"q");
+        assert_eq!(capture.contents(), b"nopq");
+        assert_eq!(capture.total_len(), 17);
+        assert_eq!(capture.read_from(&mut cursor), (b"nopq".to_vec(), 11));
+
+        capture.write(b"rs");
+        assert_eq!(capture.read_from(&mut cursor), (b"rs".to_vec(), 2));
+        capture.write(b"0123456789");
+        assert_eq!(capture.contents(), b"6789");
+    }
+
+    #[test]
+    fn test_output_capture_tap() {
+        let capture = OutputCapture::new(4);
+        let seen = Arc::new(Mutex::new(Vec::new()));
+        let sink = seen.clone();
+        capture.write(b"before");
+        capture.set_tap(OutputTap(Arc::new(move |bytes: &[u8]| {
+            sink.lock().unwrap().push(bytes.to_vec());
+        })));
+        capture.write(b"ab");
+        capture.write(b"cdef");
+        assert_eq!(
+            *seen.lock().unwrap(),
+            vec![b"ab".to_vec(), b"cdef".to_vec()]
+        );
+        assert_eq!(capture.contents(), b"befo");
+    }
+
+    #[tokio::test]
+    async fn test_capture_writer_accepts_everything() {
+        let capture = OutputCapture::new(4);
+        let mut writer = CaptureWriter(capture.clone());
+        writer.write_all(b"abcdefgh").await.unwrap();
+        writer.flush().await.unwrap();
+        assert_eq!(capture.contents(), b"abcd");
+        assert_eq!(capture.total_len(), 8);
+    }
+}
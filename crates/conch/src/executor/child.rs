@@ -18,9 +18,13 @@ use eryx_vfs::{
     add_hybrid_vfs_to_linker,
 };
 use tokio::sync::oneshot;
+
+use super::lookup::{self, CheckedLookup};
+use super::registry::{CommandHandler, CommandRequest, ConnectHandler, ConnectRequest};
 use wasmtime::component::{Component, Linker, ResourceTable};
 use wasmtime::{Config, Engine, Store};
 use wasmtime_wasi::p2::pipe::{MemoryInputPipe, MemoryOutputPipe};
+use wasmtime_wasi::sockets::WasiSocketsView;
 use wasmtime_wasi::{WasiCtx, WasiCtxBuilder, WasiCtxView, WasiView};
 
 /// Filesystem template handed to a spawned child so it shares the shell's
@@ -89,10 +93,8 @@ struct ChildResult {
 /// Uses a batch approach: stdin data is accumulated, then the component
 /// runs to completion on `wait()`, producing stdout/stderr all at once.
 pub(crate) struct ChildProcess<S: VfsStorage + Clone + 'static> {
-    /// Engine for the child — separate from parent, with p3 async support.
-    engine: Arc<Engine>,
-    /// The component to run.
-    component: Component,
+    /// What runs when stdin closes.
+    target: ChildTarget,
     /// Command-line arguments.
     args: Vec<String>,
     /// Environment variables.
@@ -102,6 +104,8 @@ pub(crate) struct ChildProcess<S: VfsStorage + Clone + 'static> {
     /// Filesystem the child sees: the shell's VFS (shared storage + mounts)
     /// plus any real mounts (e.g. the `--sandbox-root` for certs).
     vfs: ChildVfs<S>,
+    /// Check of the connections a component makes; `None` allows all.
+    connect: Option<Arc<dyn ConnectHandler>>,
     /// Accumulated stdin data.
     pub stdin_buffer: Vec<u8>,
     /// Whether stdin has been closed (no more writes allowed).
@@ -114,6 +118,19 @@ pub(crate) struct ChildProcess<S: VfsStorage + Clone + 'static> {
     _thread_handle: Option<std::thread::JoinHandle<()>>,
 }
 
+/// What a [`ChildProcess`] runs.
+enum ChildTarget {
+    /// A WASI component from the registry.
+    Component {
+        /// Engine for the child — separate from parent, with p3 async support.
+        engine: Arc<Engine>,
+        /// The component to run.
+        component: Component,
+    },
+    /// The registry's host-side command-not-found handler.
+    Host(Arc<dyn CommandHandler>),
+}
+
 /// WASI state for the child component's Store.
 ///
 /// Holds both a [`WasiCtx`] (stdio/env/network, plus a real-fs preopen used by
@@ -123,6 +140,9 @@ struct ChildWasiState<S: VfsStorage + Clone + 'static> {
     wasi: WasiCtx,
     table: ResourceTable,
     hybrid: HybridVfsCtx<S>,
+    /// Handler checking the component's name lookups, and the command
+    /// making them; `None` leaves lookups to wasmtime-wasi.
+    lookup: Option<(Arc<dyn ConnectHandler>, Arc<str>)>,
 }
 
 impl<S: VfsStorage + Clone + 'static> WasiView for ChildWasiState<S> {
@@ -134,6 +154,19 @@ impl<S: VfsStorage + Clone + 'static> WasiView for ChildWasiState<S> {
     }
 }
 
+impl<S: VfsStorage + Clone + 'static> ChildWasiState<S> {
+    /// The view the checked name lookups get; only linked when `lookup` is
+    /// set.
+    fn checked_lookup(&mut self) -> CheckedLookup<'_> {
+        let (connect, command) = self.lookup.clone().expect("name lookups are checked");
+        CheckedLookup {
+            connect,
+            command,
+            sockets: self.sockets(),
+        }
+    }
+}
+
 impl<S: VfsStorage + Clone + 'static> HybridVfsView for ChildWasiState<S> {
     type Storage = S;
     fn hybrid_vfs(&mut self) -> HybridVfsState<'_, Self::Storage> {
@@ -194,6 +227,7 @@ pub(crate) fn spawn_child<S: VfsStorage + Clone + 'static>(
     env: &[(String, String)],
     cwd: &str,
     vfs: ChildVfs<S>,
+    connect: Option<Arc<dyn ConnectHandler>>,
 ) -> Result<ChildProcess<S>, String> {
     let engine = child_engine()?;
 
@@ -211,12 +245,15 @@ pub(crate) fn spawn_child<S: VfsStorage + Clone + 'static>(
     full_args.extend_from_slice(args);
 
     Ok(ChildProcess {
-        engine: Arc::new(engine.clone()),
-        component: child_component,
+        target: ChildTarget::Component {
+            engine: Arc::new(engine.clone()),
+            component: child_component,
+        },
         args: full_args,
         env: env.to_vec(),
         cwd: cwd.to_string(),
         vfs,
+        connect,
         stdin_buffer: Vec::new(),
         stdin_closed: false,
         result_rx: None,
@@ -225,6 +262,54 @@ pub(crate) fn spawn_child<S: VfsStorage + Clone + 'static>(
     })
 }
 
+/// Create a child process handle served by a host [`CommandHandler`].
+///
+/// Like a component child, the handler runs once stdin is closed, so it sees
+/// everything the shell piped in.
+pub(crate) fn spawn_host_child<S: VfsStorage + Clone + 'static>(
+    handler: Arc<dyn CommandHandler>,
+    cmd: &str,
+    args: &[String],
+    env: &[(String, String)],
+    cwd: &str,
+    vfs: ChildVfs<S>,
+) -> ChildProcess<S> {
+    let mut full_args = vec![cmd.to_string()];
+    full_args.extend_from_slice(args);
+
+    ChildProcess {
+        target: ChildTarget::Host(handler),
+        args: full_args,
+        env: env.to_vec(),
+        cwd: cwd.to_string(),
+        vfs,
+        connect: None,
+        stdin_buffer: Vec::new(),
+        stdin_closed: false,
+        result_rx: None,
+        result: None,
+        _thread_handle: None,
+    }
+}
+
+/// Run a host command handler, mapping "unhandled" to the shell's usual
+/// command-not-found failure.
+fn run_host_command(handler: &dyn CommandHandler, request: CommandRequest) -> ChildResult {
+    let name = request.name.clone();
+    match handler.run(request) {
+        Some(output) => ChildResult {
+            exit_code: output.exit_code,
+            stdout: output.stdout,
+            stderr: output.stderr,
+        },
+        None => ChildResult {
+            exit_code: 127,
+            stdout: Vec::new(),
+            stderr: format!("{name}: command not found\n").into_bytes(),
+        },
+    }
+}
+
 impl<S: VfsStorage + Clone + Send + Sync + 'static> ChildProcess<S> {
     /// Write data to the stdin buffer.
     pub fn write_stdin(&mut self, data: Vec<u8>) -> Result<u64, String> {
@@ -295,13 +380,31 @@ impl<S: VfsStorage + Clone + Send + Sync + 'static> ChildProcess<S> {
         let (tx, rx) = oneshot::channel();
         self.result_rx = Some(rx);
 
-        let engine = self.engine.clone();
-        let component = self.component.clone();
         let stdin_data = std::mem::take(&mut self.stdin_buffer);
         let args = self.args.clone();
         let env = self.env.clone();
         let cwd = self.cwd.clone();
         let vfs = self.vfs.clone();
+        let connect = self.connect.clone();
+
+        let (engine, component) = match &self.target {
+            ChildTarget::Component { engine, component } => (engine.clone(), component.clone()),
+            ChildTarget::Host(handler) => {
+                let handler = handler.clone();
+                let request = CommandRequest {
+                    name: args[0].clone(),
+                    args: args[1..].to_vec(),
+                    env,
+                    cwd,
+                    stdin: stdin_data,
+                };
+                let handle = std::thread::spawn(move || {
+                    let _ = tx.send(Ok(run_host_command(handler.as_ref(), request)));
+                });
+                self._thread_handle = Some(handle);
+                return;
+            }
+        };
 
         let handle = std::thread::spawn(move || {
             let rt = match tokio::runtime::Builder::new_current_thread()
@@ -323,6 +426,7 @@ impl<S: VfsStorage + Clone + Send + Sync + 'static> ChildProcess<S> {
                 &env,
                 &cwd,
                 &vfs,
+                connect,
             ));
             if let Err(ref e) = result {
                 eprintln!("[child] error: {e}");
@@ -346,9 +450,10 @@ async fn run_child_component<S: VfsStorage + Clone + 'static>(
     component: &Component,
     stdin_data: &[u8],
     args: &[String],
-    _env: &[(String, String)],
+    env: &[(String, String)],
     _cwd: &str,
     vfs: &ChildVfs<S>,
+    connect: Option<Arc<dyn ConnectHandler>>,
 ) -> Result<ChildResult, String> {
     let stdout_pipe = MemoryOutputPipe::new(1024 * 1024); // 1MB
     let stderr_pipe = MemoryOutputPipe::new(1024 * 1024);
@@ -365,9 +470,39 @@ async fn run_child_component<S: VfsStorage + Clone + 'static>(
             builder.arg(arg);
         }
         builder.inherit_env();
+        // Children inherit the host's environment rather than the shell's,
+        // except for the description of an emulated terminal.
+        for (key, value) in env {
+            if matches!(key.as_str(), "TERM" | "COLUMNS" | "LINES") {
+                builder.env(key, value);
+            }
+        }
         // Network access for commands that need it (e.g. gh, curl)
         builder.inherit_network();
+        // With a connect handler, the names are checked before they are
+        // resolved; see `lookup`.
         builder.allow_ip_name_lookup(true);
+        if let Some(connect) = &connect {
+            let connect = connect.clone();
+            let command = args[0].clone();
+            builder.socket_addr_check(move |addr, addr_use| {
+                use wasmtime_wasi::sockets::SocketAddrUse;
+
+                let request = |udp| ConnectRequest {
+                    command: command.clone(),
+                    addr,
+                    udp,
+                };
+                let allowed = match addr_use {
+                    SocketAddrUse::TcpConnect => connect.allow(request(false)),
+                    SocketAddrUse::UdpConnect | SocketAddrUse::UdpOutgoingDatagram => {
+                        connect.allow(request(true))
+                    }
+                    SocketAddrUse::TcpBind | SocketAddrUse::UdpBind => true,
+                };
+                Box::pin(async move { allowed })
+            });
+        }
         builder.env("SSL_CERT_FILE", "/etc/ssl/certs/ca-certificates.crt");
 
         // Real-fs preopens for the p3 path (p2 uses the hybrid VFS below, which
@@ -401,6 +536,9 @@ async fn run_child_component<S: VfsStorage + Clone + 'static>(
             wasi: builder.build(),
             table: ResourceTable::new(),
             hybrid: vfs.build_ctx(),
+            lookup: connect
+                .clone()
+                .map(|connect| (connect, Arc::from(args[0].as_str()))),
         }
     };
 
@@ -425,6 +563,7 @@ async fn run_with_linker<S: VfsStorage + Clone + 'static>(
     state: ChildWasiState<S>,
     component: &Component,
 ) -> Result<i32, String> {
+    let checked_lookup = state.lookup.is_some();
     let mut store = Store::new(engine, state);
     let mut linker = Linker::<ChildWasiState<S>>::new(engine);
 
@@ -438,6 +577,12 @@ async fn run_with_linker<S: VfsStorage + Clone + 'static>(
     linker.allow_shadowing(true);
     add_hybrid_vfs_to_linker(&mut linker)
         .map_err(|e| format!("failed to add hybrid VFS to child linker: {e}"))?;
+    // Shadow name lookups too when a handler checks them, so names are
+    // checked before they leave the host.
+    if checked_lookup {
+        lookup::add_to_linker(&mut linker, ChildWasiState::checked_lookup)
+            .map_err(|e| format!("failed to add name lookups to child linker: {e}"))?;
+    }
     linker.allow_shadowing(false);
 
     // Instantiate the component once
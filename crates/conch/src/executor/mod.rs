@@ -9,20 +9,32 @@
 //! state (variables, functions, aliases) across multiple `execute` calls.
 //! Each instance has its own isolated filesystem and WASM memory.
 
+mod capture;
 #[cfg(feature = "embedded-shell")]
 mod child;
 mod component;
+#[cfg(feature = "embedded-shell")]
+mod lookup;
+mod prompt;
 mod registry;
+mod terminal;
 
-pub use component::{ComponentShellExecutor, ToolHandler, ToolRequest, ToolResult};
+pub use component::{
+    ComponentShellExecutor, SHELL_INTERFACE_VERSION, ToolHandler, ToolRequest, ToolResult,
+};
 #[cfg(feature = "embedded-coreutils")]
 pub use registry::with_embedded_coreutils;
-pub use registry::{ComponentRegistry, SharedRegistry};
+pub use registry::{
+    CommandHandler, CommandOutput, CommandRequest, ComponentRegistry, ConnectHandler,
+    ConnectRequest, LookupRequest, SharedRegistry,
+};
 
 #[cfg(feature = "embedded-shell")]
 pub use child::ChildVfs;
 #[cfg(feature = "embedded-shell")]
-pub use component::ShellInstance;
+pub use component::{InstanceIo, ShellInstance, StreamingIo};
+pub use prompt::PromptHandler;
+pub use terminal::{TERMINAL_TYPE, TerminalSize};
 
 /// Enable wasmtime's on-disk compilation cache on the given config.
 ///
@@ -0,0 +1,200 @@
+//! Interactive input for shell instances.
+//!
+//! A shell instance with a [`PromptHandler`] has no stdin of its own: when
+//! the script reads stdin (`read`, `cat`, a `while read` loop) and nothing is
+//! buffered, the host asks the handler for the next line. The prompt passed
+//! to the handler is whatever the script wrote to stderr since its last
+//! newline, which is where `read -p` puts it, so
+//!
+//! ```bash
+//! read -p "Name: " name
+//! ```
+//!
+//! calls the handler with `"Name: "`. Stdin reports itself as a terminal, as
+//! it would in an interactive shell.
+
+use std::collections::VecDeque;
+use std::future::Future;
+use std::io;
+use std::pin::Pin;
+use std::sync::Arc;
+use std::task::{Context, Poll, ready};
+
+use tokio::io::{AsyncRead, ReadBuf};
+use tokio::task::JoinHandle;
+use wasmtime_wasi::cli::{AsyncStdinStream, IsTerminal, StdinStream};
+use wasmtime_wasi_io::streams::InputStream;
+
+use super::capture::OutputCapture;
+
+/// Host-side source of input lines for scripts that read stdin.
+///
+/// Called on a blocking thread while the script waits, so it may block.
+pub trait PromptHandler: Send + Sync {
+    /// Return the next line of input, with or without its trailing newline,
+    /// or `None` to signal end of input.
+    fn prompt(&self, prompt: &str) -> Option<String>;
+}
+
+/// Blanket implementation for closures.
+impl<F> PromptHandler for F
+where
+    F: Fn(&str) -> Option<String> + Send + Sync,
+{
+    fn prompt(&self, prompt: &str) -> Option<String> {
+        self(prompt)
+    }
+}
+
+/// Reads stdin by asking a [`PromptHandler`] for one line at a time.
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+struct PromptReader {
+    handler: Arc<dyn PromptHandler>,
+    /// The guest's stderr, which holds the text of the pending prompt.
+    stderr: OutputCapture,
+    /// Length of stderr when the handler was last asked, so a prompt is
+    /// never reported twice.
+    stderr_seen: usize,
+    /// The unread rest of the current line.
+    pending: VecDeque<u8>,
+    /// The handler call in flight, if any.
+    asking: Option<JoinHandle<Option<String>>>,
+    eof: bool,
+}
+
+impl PromptReader {
+    fn new(handler: Arc<dyn PromptHandler>, stderr: OutputCapture) -> Self {
+        Self {
+            handler,
+            stderr,
+            stderr_seen: 0,
+            pending: VecDeque::new(),
+            asking: None,
+            eof: false,
+        }
+    }
+
+    /// The text written to stderr since the last prompt and after the last
+    /// newline.
+    fn take_prompt(&mut self) -> String {
+        let contents = self.stderr.contents();
+        let start = self.stderr_seen.min(contents.len());
+        self.stderr_seen = contents.len();
+        let unseen = &contents[start..];
+        let line = match unseen.iter().rposition(|&b| b == b'\n') {
+            Some(i) => &unseen[i + 1..],
+            None => unseen,
+        };
+        String::from_utf8_lossy(line).into_owned()
+    }
+}
+
+impl AsyncRead for PromptReader {
+    fn poll_read(
+        mut self: Pin<&mut Self>,
+        cx: &mut Context<'_>,
+        buf: &mut ReadBuf<'_>,
+    ) -> Poll<io::Result<()>> {
+        loop {
+            if !self.pending.is_empty() {
+                let n = buf.remaining().min(self.pending.len());
+                let chunk: Vec<u8> = self.pending.drain(..n).collect();
+                buf.put_slice(&chunk);
+                return Poll::Ready(Ok(()));
+            }
+            if self.eof {
+                return Poll::Ready(Ok(()));
+            }
+            if self.asking.is_none() {
+                let prompt = self.take_prompt();
+                let handler = self.handler.clone();
+                self.asking = Some(tokio::task::spawn_blocking(move || handler.prompt(&prompt)));
+            }
+            let asking = self.asking.as_mut().expect("handler call in flight");
+            let answer = ready!(Pin::new(asking).poll(cx));
+            self.asking = None;
+            match answer {
+                Ok(Some(mut line)) => {
+                    if !line.ends_with('\n') {
+                        line.push('\n');
+                    }
+                    self.pending.extend(line.into_bytes());
+                }
+                // A handler that panicked ends the input like one that
+                // declined to answer.
+                Ok(None) | Err(_) => self.eof = true,
+            }
+        }
+    }
+}
+
+/// Stdin served by a [`PromptHandler`], reporting itself as a terminal.
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+pub(crate) struct PromptStdin(AsyncStdinStream);
+
+impl PromptStdin {
+    /// Serve stdin from `handler`, taking prompts from `stderr`.
+    #[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+    pub(crate) fn new(handler: Arc<dyn PromptHandler>, stderr: OutputCapture) -> Self {
+        Self(AsyncStdinStream::new(PromptReader::new(handler, stderr)))
+    }
+}
+
+impl IsTerminal for PromptStdin {
+    fn is_terminal(&self) -> bool {
+        true
+    }
+}
+
+impl StdinStream for PromptStdin {
+    fn p2_stream(&self) -> Box<dyn InputStream> {
+        self.0.p2_stream()
+    }
+
+    fn async_stream(&self) -> Box<dyn AsyncRead + Send + Sync> {
+        self.0.async_stream()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::Mutex;
+    use tokio::io::AsyncReadExt;
+
+    #[tokio::test]
+    async fn test_prompt_reader_lines() {
+        let asked = Arc::new(Mutex::new(Vec::new()));
+        let answers = Mutex::new(vec!["Ada".to_string(), "42\n".to_string()].into_iter());
+        let handler = {
+            let asked = asked.clone();
+            move |prompt: &str| {
+                asked.lock().unwrap().push(prompt.to_string());
+                answers.lock().unwrap().next()
+            }
+        };
+
+        let mut reader = PromptReader::new(Arc::new(handler), OutputCapture::new(1024));
+
+        let mut out = String::new();
+        reader.read_to_string(&mut out).await.unwrap();
+        assert_eq!(out, "Ada\n42\n");
+        assert_eq!(asked.lock().unwrap().len(), 3);
+    }
+
+    #[test]
+    fn test_take_prompt() {
+        let stderr = OutputCapture::new(1024);
+        let mut reader = PromptReader::new(
+            Arc::new(|_: &str| -> Option<String> { None }),
+            stderr.clone(),
+        );
+
+        stderr.write(b"warning\nName: ");
+        assert_eq!(reader.take_prompt(), "Name: ");
+        assert_eq!(reader.take_prompt(), "");
+
+        stderr.write(b"Age: ");
+        assert_eq!(reader.take_prompt(), "Age: ");
+    }
+}
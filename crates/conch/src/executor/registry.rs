@@ -4,7 +4,8 @@
 //! to WASM component bytes. When the shell encounters an unknown command,
 //! it looks up the name here to find a component to instantiate.
 
-use std::collections::HashMap;
+use std::collections::{HashMap, HashSet};
+use std::net::SocketAddr;
 use std::path::{Path, PathBuf};
 use std::sync::Arc;
 
@@ -19,6 +20,81 @@ pub enum RegistryEntry {
     CWasm(Arc<Vec<u8>>),
 }
 
+/// A command invocation passed to a [`CommandHandler`].
+#[derive(Clone, Debug)]
+pub struct CommandRequest {
+    /// The command name (`argv[0]`).
+    pub name: String,
+    /// Arguments after the command name.
+    pub args: Vec<String>,
+    /// Environment variables exported by the shell.
+    pub env: Vec<(String, String)>,
+    /// The shell's working directory.
+    pub cwd: String,
+    /// Everything the shell wrote to the command's stdin.
+    pub stdin: Vec<u8>,
+}
+
+/// The result of a command run by a [`CommandHandler`].
+#[derive(Clone, Debug, Default)]
+pub struct CommandOutput {
+    /// Exit code reported to the shell.
+    pub exit_code: i32,
+    /// Bytes written to stdout.
+    pub stdout: Vec<u8>,
+    /// Bytes written to stderr.
+    pub stderr: Vec<u8>,
+}
+
+/// Host-side handler for commands with no registered component.
+///
+/// Called on a dedicated thread once the shell has closed the command's
+/// stdin, so it may block.
+pub trait CommandHandler: Send + Sync {
+    /// Run the command, or return `None` to leave it unhandled, in which case
+    /// the shell sees the usual "command not found" failure.
+    fn run(&self, request: CommandRequest) -> Option<CommandOutput>;
+}
+
+/// An outgoing connection a spawned component attempts, passed to a
+/// [`ConnectHandler`].
+#[derive(Clone, Debug)]
+pub struct ConnectRequest {
+    /// The command making the connection (`argv[0]`).
+    pub command: String,
+    /// The address it connects or sends to.
+    pub addr: SocketAddr,
+    /// Whether the socket is UDP rather than TCP.
+    pub udp: bool,
+}
+
+/// A host name a spawned component asks to resolve, passed to a
+/// [`ConnectHandler`].
+#[derive(Clone, Debug)]
+pub struct LookupRequest {
+    /// The command resolving the name (`argv[0]`).
+    pub command: String,
+    /// The name to resolve.
+    pub name: String,
+}
+
+/// Host-side check of the connections spawned components make.
+///
+/// Called on the component's thread before each name lookup, TCP connect
+/// and UDP connect or outgoing datagram, so it may block. Name lookups
+/// resolve on the host, so checking them keeps a component from sending
+/// data out in the names it looks up. Binding local sockets is not checked.
+pub trait ConnectHandler: Send + Sync {
+    /// Whether the connection may go ahead.
+    fn allow(&self, request: ConnectRequest) -> bool;
+
+    /// Whether the name may be resolved. Names are allowed by default.
+    fn allow_lookup(&self, request: LookupRequest) -> bool {
+        let _ = request;
+        true
+    }
+}
+
 /// A registry mapping command names to WASI component bytes.
 ///
 /// Components are stored as raw WASM bytes and compiled on demand for
@@ -44,12 +120,22 @@ pub struct ComponentRegistry {
     default_sandbox_root: Option<PathBuf>,
     /// Per-command sandbox root overrides, taking precedence over the default.
     command_sandbox_roots: HashMap<String, PathBuf>,
+    /// Fallback for commands that have no registered component.
+    command_not_found: Option<Arc<dyn CommandHandler>>,
+    /// Check of the connections spawned components make; `None` allows all.
+    connect: Option<Arc<dyn ConnectHandler>>,
+    /// The only commands and conch builtins scripts may run; `None` allows
+    /// all.
+    allowed: Option<HashSet<String>>,
 }
 
 impl std::fmt::Debug for ComponentRegistry {
     fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
         f.debug_struct("ComponentRegistry")
             .field("count", &self.entries.len())
+            .field("command_not_found", &self.command_not_found.is_some())
+            .field("connect", &self.connect.is_some())
+            .field("allowed", &self.allowed)
             .finish()
     }
 }
@@ -115,6 +201,51 @@ impl ComponentRegistry {
             .map(PathBuf::as_path)
     }
 
+    /// Set the handler consulted when a command has no registered component.
+    pub fn set_command_not_found_handler(&mut self, handler: Arc<dyn CommandHandler>) {
+        self.command_not_found = Some(handler);
+    }
+
+    /// The handler consulted when a command has no registered component.
+    pub fn command_not_found_handler(&self) -> Option<&Arc<dyn CommandHandler>> {
+        self.command_not_found.as_ref()
+    }
+
+    /// Set the handler checking each connection spawned components make.
+    pub fn set_connect_handler(&mut self, handler: Arc<dyn ConnectHandler>) {
+        self.connect = Some(handler);
+    }
+
+    /// The handler checking each connection spawned components make.
+    pub fn connect_handler(&self) -> Option<&Arc<dyn ConnectHandler>> {
+        self.connect.as_ref()
+    }
+
+    /// Allow scripts to run only the commands named in `names`, whether
+    /// registered components, host commands or conch builtins such as `jq`
+    /// and `tool`. Others fail as not found. The shell's own builtins, such as
+    /// `echo` and `cd`, are always available.
+    pub fn set_allowed_commands<I, N>(&mut self, names: I)
+    where
+        I: IntoIterator<Item = N>,
+        N: Into<String>,
+    {
+        self.allowed = Some(names.into_iter().map(Into::into).collect());
+    }
+
+    /// The commands set with [`set_allowed_commands`](Self::set_allowed_commands),
+    /// or `None` if all are allowed.
+    pub fn allowed_commands(&self) -> Option<&HashSet<String>> {
+        self.allowed.as_ref()
+    }
+
+    /// Whether scripts may run the command `name`.
+    pub fn allows(&self, name: &str) -> bool {
+        self.allowed
+            .as_ref()
+            .is_none_or(|allowed| allowed.contains(name))
+    }
+
     /// Get the number of registered components.
     pub fn len(&self) -> usize {
         self.entries.len()
@@ -196,6 +327,73 @@ mod tests {
         );
     }
 
+    #[test]
+    fn command_not_found_handler_is_stored() {
+        struct Echo;
+        impl CommandHandler for Echo {
+            fn run(&self, request: CommandRequest) -> Option<CommandOutput> {
+                Some(CommandOutput {
+                    stdout: request.name.into_bytes(),
+                    ..Default::default()
+                })
+            }
+        }
+
+        let mut registry = ComponentRegistry::new();
+        assert!(registry.command_not_found_handler().is_none());
+
+        registry.set_command_not_found_handler(Arc::new(Echo));
+        let handler = registry.command_not_found_handler().unwrap();
+        let output = handler
+            .run(CommandRequest {
+                name: "deploy".into(),
+                args: vec![],
+                env: vec![],
+                cwd: "/".into(),
+                stdin: vec![],
+            })
+            .unwrap();
+        assert_eq!(output.stdout, b"deploy");
+    }
+
+    #[test]
+    fn allowed_commands_default_to_all() {
+        let mut registry = ComponentRegistry::new();
+        assert!(registry.allows("curl"));
+
+        registry.set_allowed_commands(["cat", "jq"]);
+        assert!(registry.allows("cat"));
+        assert!(registry.allows("jq"));
+        assert!(!registry.allows("curl"));
+    }
+
+    #[test]
+    fn connect_handler_is_stored() {
+        struct LoopbackOnly;
+        impl ConnectHandler for LoopbackOnly {
+            fn allow(&self, request: ConnectRequest) -> bool {
+                request.addr.ip().is_loopback()
+            }
+        }
+
+        let mut registry = ComponentRegistry::new();
+        assert!(registry.connect_handler().is_none());
+
+        registry.set_connect_handler(Arc::new(LoopbackOnly));
+        let handler = registry.connect_handler().unwrap();
+        let request = |addr: &str| ConnectRequest {
+            command: "curl".into(),
+            addr: addr.parse().unwrap(),
+            udp: false,
+        };
+        assert!(handler.allow(request("127.0.0.1:8080")));
+        assert!(!handler.allow(request("93.184.216.34:443")));
+        assert!(handler.allow_lookup(LookupRequest {
+            command: "curl".into(),
+            name: "example.com".into(),
+        }));
+    }
+
     #[test]
     fn command_override_works_without_a_default() {
         let mut registry = ComponentRegistry::new();
@@ -0,0 +1,104 @@
+//! Terminal emulation for shell instances.
+//!
+//! A shell instance attached to a [`TerminalSize`] reports its stdout and
+//! stderr as a terminal, so programs that check `isatty` (colour output,
+//! progress bars, `test -t 1`) behave as they would interactively. Output is
+//! still captured; no escape sequences are interpreted.
+//!
+//! The size is exported to the shell as `COLUMNS` and `LINES`, alongside
+//! `TERM`. WASI has no way to notify a running guest of a resize, so
+//! [`TerminalSize::resize`] takes effect for commands the shell spawns
+//! afterwards, which receive the current size in their environment.
+
+use std::sync::atomic::{AtomicU32, Ordering};
+
+use tokio::io::AsyncWrite;
+use wasmtime_wasi::cli::{IsTerminal, StdoutStream};
+use wasmtime_wasi_io::streams::OutputStream;
+
+use super::capture::OutputCapture;
+
+/// Value of `TERM` inside an emulated terminal.
+pub const TERMINAL_TYPE: &str = "xterm-256color";
+
+/// The dimensions of an emulated terminal, shared between the host and a
+/// running shell so the host can resize it.
+#[derive(Debug)]
+pub struct TerminalSize {
+    /// Rows in the high 16 bits, columns in the low 16, so both change
+    /// together.
+    packed: AtomicU32,
+}
+
+impl TerminalSize {
+    /// Create a terminal of `rows` by `cols` characters.
+    pub fn new(rows: u16, cols: u16) -> Self {
+        Self {
+            packed: AtomicU32::new(pack(rows, cols)),
+        }
+    }
+
+    /// Change the size seen by commands started from now on.
+    pub fn resize(&self, rows: u16, cols: u16) {
+        self.packed.store(pack(rows, cols), Ordering::Release);
+    }
+
+    /// The current size as `(rows, cols)`.
+    pub fn get(&self) -> (u16, u16) {
+        let packed = self.packed.load(Ordering::Acquire);
+        ((packed >> 16) as u16, packed as u16)
+    }
+
+    /// The environment variables describing this terminal.
+    pub fn env(&self) -> [(String, String); 3] {
+        let (rows, cols) = self.get();
+        [
+            ("TERM".to_string(), TERMINAL_TYPE.to_string()),
+            ("COLUMNS".to_string(), cols.to_string()),
+            ("LINES".to_string(), rows.to_string()),
+        ]
+    }
+}
+
+fn pack(rows: u16, cols: u16) -> u32 {
+    ((rows as u32) << 16) | cols as u32
+}
+
+/// A captured output pipe that claims to be a terminal.
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+#[derive(Debug, Clone)]
+pub(crate) struct TerminalPipe(pub OutputCapture);
+
+impl IsTerminal for TerminalPipe {
+    fn is_terminal(&self) -> bool {
+        true
+    }
+}
+
+impl StdoutStream for TerminalPipe {
+    fn p2_stream(&self) -> Box<dyn OutputStream> {
+        self.0.p2_stream()
+    }
+
+    fn async_stream(&self) -> Box<dyn AsyncWrite + Send + Sync> {
+        self.0.async_stream()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_terminal_size_resize() {
+        let size = TerminalSize::new(24, 80);
+        assert_eq!(size.get(), (24, 80));
+
+        size.resize(50, 200);
+        assert_eq!(size.get(), (50, 200));
+
+        let env = size.env();
+        assert!(env.contains(&("COLUMNS".to_string(), "200".to_string())));
+        assert!(env.contains(&("LINES".to_string(), "50".to_string())));
+    }
+}
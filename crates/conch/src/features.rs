@@ -0,0 +1,201 @@
+//! Shell language features supported by the sandbox.
+//!
+//! The embedded shell is bash-compatible but not bash: some constructs
+//! depend on things WASI does not provide. [`SHELL_FEATURES`] lists the
+//! constructs callers commonly ask about, so a script validator can reject
+//! what the sandbox cannot run before executing anything.
+//!
+//! Each entry carries a probe script and the stdout it produces when the
+//! feature works; the integration tests run every probe so the table cannot
+//! drift from the shell's actual behaviour.
+
+use serde::Serialize;
+
+/// A shell language feature and whether the sandbox supports it.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+pub struct ShellFeature {
+    /// Stable identifier, e.g. `"process-substitution"`.
+    pub name: &'static str,
+    /// The construct, with an example.
+    pub description: &'static str,
+    /// Whether scripts using the feature run as they would under bash.
+    pub supported: bool,
+    /// Known differences from bash, or why the feature is unsupported.
+    pub caveats: &'static str,
+    /// A script exercising the feature.
+    pub probe: &'static str,
+    /// The probe's stdout when the feature works.
+    pub expected: &'static str,
+}
+
+/// Every feature the sandbox reports on, in a stable order.
+pub const SHELL_FEATURES: &[ShellFeature] = &[
+    ShellFeature {
+        name: "pipelines",
+        description: "Pipelines between commands: cmd1 | cmd2",
+        supported: true,
+        caveats: "",
+        probe: "printf 'a\\nb\\n' | grep b",
+        expected: "b\n",
+    },
+    ShellFeature {
+        name: "command-substitution",
+        description: "Command substitution: $(cmd) and `cmd`",
+        supported: true,
+        caveats: "",
+        probe: "echo \"$(echo a) `echo b`\"",
+        expected: "a b\n",
+    },
+    ShellFeature {
+        name: "arithmetic",
+        description: "Arithmetic expansion and commands: $((x + 1)), ((x++))",
+        supported: true,
+        caveats: "",
+        probe: "x=2; ((x++)); echo $((x * 2 + 1))",
+        expected: "7\n",
+    },
+    ShellFeature {
+        name: "parameter-expansion",
+        description: "Parameter expansion operators: ${v:-default}, ${v%%pat}, ${v/a/b}, ${#v}",
+        supported: true,
+        caveats: "",
+        probe: "v=hello.tar.gz; echo \"${v%%.*} ${v#*.} ${v/l/L} ${#v} ${u:-def}\"",
+        expected: "hello tar.gz heLlo.tar.gz 12 def\n",
+    },
+    ShellFeature {
+        name: "brace-expansion",
+        description: "Brace expansion: a{b,c} and {1..3}",
+        supported: true,
+        caveats: "",
+        probe: "echo a{b,c}d {1..3}",
+        expected: "abd acd 1 2 3\n",
+    },
+    ShellFeature {
+        name: "heredocs",
+        description: "Here-documents: cmd <<EOF ... EOF, including <<-",
+        supported: true,
+        caveats: "",
+        probe: "x=1\nwhile read -r l; do echo \"$l\"; done <<EOF\nvalue $x\nEOF",
+        expected: "value 1\n",
+    },
+    ShellFeature {
+        name: "here-strings",
+        description: "Here-strings: cmd <<< \"text\"",
+        supported: true,
+        caveats: "",
+        probe: "read -r v <<< \"hi there\"; echo \"$v\"",
+        expected: "hi there\n",
+    },
+    ShellFeature {
+        name: "extended-test",
+        description: "The [[ ... ]] conditional with pattern matching and &&/||",
+        supported: true,
+        caveats: "",
+        probe: "[[ foo == f* && 3 -gt 2 ]] && echo yes",
+        expected: "yes\n",
+    },
+    ShellFeature {
+        name: "functions",
+        description: "Shell functions with local variables",
+        supported: true,
+        caveats: "Calls may nest at most max_call_depth deep (100 by \
+                  default); bash has no limit unless FUNCNEST is set.",
+        probe: "f() { local x=$1; echo \"<$x>\"; }; f hi",
+        expected: "<hi>\n",
+    },
+    ShellFeature {
+        name: "subshells",
+        description: "Subshells: ( cmds ), isolating variable changes",
+        supported: true,
+        caveats: "",
+        probe: "x=1; (x=2); echo $x",
+        expected: "1\n",
+    },
+    ShellFeature {
+        name: "indexed-arrays",
+        description: "Indexed arrays: a=(x y), ${a[1]}, ${#a[@]}, a+=(z)",
+        supported: true,
+        caveats: "Arrays cannot be exported: commands spawned by the shell \
+                  do not see them, as in bash.",
+        probe: "a=(x y z); a+=(w); echo \"${#a[@]} ${a[1]} ${a[*]}\"",
+        expected: "4 y x y z w\n",
+    },
+    ShellFeature {
+        name: "associative-arrays",
+        description: "Associative arrays: declare -A m; m[key]=value",
+        supported: true,
+        caveats: "Must be declared with declare -A (local -A in functions) \
+                  before use, as in bash. Keys are not listed in bash's \
+                  order; sort \"${!m[@]}\" when order matters.",
+        probe: "declare -A m=([k]=v); m[j]=w; echo \"${m[k]}${m[j]} ${#m[@]}\"",
+        expected: "vw 2\n",
+    },
+    ShellFeature {
+        name: "mapfile",
+        description: "Reading lines into an array: mapfile -t a, readarray, read -a",
+        supported: true,
+        caveats: "",
+        probe: "mapfile -t l <<< $'a\\nb'; read -r -a w <<< 'x y'; echo \"${#l[@]} ${l[1]} ${w[1]}\"",
+        expected: "2 b y\n",
+    },
+    ShellFeature {
+        name: "traps",
+        description: "Cleanup handlers: trap 'cmd' EXIT, INT and TERM",
+        supported: true,
+        caveats: "Handlers run only for one-shot executions, not between \
+                  calls on a persistent shell. INT runs when the caller \
+                  cancels, TERM on a timeout or deadline; both are followed \
+                  by EXIT. The script takes the signal the next time it \
+                  waits, such as on a pipeline, and those handlers then \
+                  run in its shell with at most 5 seconds; a script that \
+                  does not wait within 100ms, such as a busy loop, is \
+                  stopped without them.",
+        probe: "trap 'echo bye' EXIT; trap -p EXIT | grep -q bye && echo set; trap - EXIT",
+        expected: "set\n",
+    },
+    ShellFeature {
+        name: "process-substitution",
+        description: "Process substitution: <(cmd) and >(cmd)",
+        supported: false,
+        caveats: "Needs /dev/fd, which WASI does not provide; \
+                  pipe into the command or use a temporary file instead.",
+        probe: "while read -r l; do echo \"$l\"; done < <(echo hi)",
+        expected: "hi\n",
+    },
+    ShellFeature {
+        name: "coprocesses",
+        description: "Coprocesses: coproc cmd",
+        supported: false,
+        caveats: "Not implemented by the shell.",
+        probe: "coproc { echo hi; }; read -r l <&\"${COPROC[0]}\"; echo \"$l\"",
+        expected: "hi\n",
+    },
+];
+
+/// Look up a feature by name.
+pub fn shell_feature(name: &str) -> Option<&'static ShellFeature> {
+    SHELL_FEATURES.iter().find(|f| f.name == name)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_shell_features_unique() {
+        for (i, feature) in SHELL_FEATURES.iter().enumerate() {
+            assert!(
+                SHELL_FEATURES[..i].iter().all(|f| f.name != feature.name),
+                "duplicate feature {}",
+                feature.name
+            );
+            assert!(
+                feature.supported || !feature.caveats.is_empty(),
+                "unsupported feature {} must explain why",
+                feature.name
+            );
+        }
+        assert_eq!(shell_feature("heredocs").map(|f| f.supported), Some(true));
+        assert!(shell_feature("nope").is_none());
+    }
+}
@@ -15,16 +15,57 @@
 //! }
 //! fmt.Println(string(result.Stdout))
 //! ```
+//!
+//! # Errors
+//!
+//! Exports that can fail return null or a non-zero status and leave a
+//! message for `conch_last_error()`, which is per thread. Each of them also
+//! has a `<name>_err` variant taking a caller-owned buffer and its length as
+//! two extra arguments, into which it writes the message of a failure. Callers
+//! whose threads are shared, such as Go, use these to get the message of
+//! their own call without a second one.
+//!
+//! # Ownership
+//!
+//! Every pointer this module returns is either borrowed or owned by the
+//! caller, and each function's docs say which:
+//!
+//! - Static strings (`conch_version()`, `conch_supported_features()`, ...)
+//!   and the embedded component bytes live as long as the library. Never
+//!   free them.
+//! - `conch_last_error()` borrows thread-local storage, valid until the next
+//!   call on the same thread. Callers that cannot guarantee that, such as Go,
+//!   whose goroutines share OS threads, should use `conch_last_error_copy()`
+//!   instead and free its result with `conch_string_free()`.
+//! - `conch_interrupt_var()` borrows from the interrupt, valid until
+//!   `conch_interrupt_free()`, and `conch_command_execution_id()` and
+//!   `conch_prompt_execution_id()` from the handle passed to the callback,
+//!   valid until it returns.
+//! - Results are owned by the caller and freed with `conch_result_free()`,
+//!   or `conch_result_free_checked()` to learn of a pointer freed twice.
+//! - Executors are freed with `conch_executor_free()`, interrupts with
+//!   `conch_interrupt_free()` and terminals with `conch_terminal_free()`.
 
 use std::cell::RefCell;
-use std::ffi::{CStr, CString, c_char};
+use std::collections::{BTreeMap, HashSet};
+use std::ffi::{CStr, CString, c_char, c_void};
 use std::ptr;
-use std::sync::Arc;
+use std::sync::atomic::{AtomicBool, AtomicU8, AtomicU64, AtomicUsize, Ordering};
+use std::sync::{Arc, LazyLock, Mutex, OnceLock};
 
 use eryx_vfs::{ArcStorage, DirPerms, FilePerms, HybridVfsCtx, InMemoryStorage};
 
-use crate::executor::ComponentShellExecutor;
+use crate::executor::{
+    CommandHandler, CommandOutput, CommandRequest, ComponentShellExecutor, ConnectHandler,
+    ConnectRequest, LookupRequest, PromptHandler, TerminalSize,
+};
+#[cfg(feature = "embedded-shell")]
+use crate::executor::{InstanceIo, StreamingIo};
 use crate::limits::ResourceLimits;
+use crate::quota::TmpConfig;
+#[cfg(feature = "embedded-shell")]
+use crate::quota::{QuotaStorage, TMP_DIR};
+use crate::stats::ExecutorGauge;
 
 thread_local! {
     static LAST_ERROR: RefCell<Option<CString>> = const { RefCell::new(None) };
@@ -52,12 +93,817 @@ pub struct ConchResult {
     pub stderr_len: usize,
     /// Non-zero if output was truncated due to limits.
     pub truncated: u8,
+    /// Trap handlers that ran as the execution ended, as a mask of
+    /// `CONCH_TRAP_*` bits.
+    pub traps: u8,
+    /// Streams whose data is compressed with the codec set by
+    /// `conch_interrupt_set_compression()`, as a mask of `CONCH_COMPRESSED_*`
+    /// bits. Their lengths are then of the compressed bytes.
+    pub compressed: u8,
+    /// Bytes the script wrote to stdout, including any dropped by the output
+    /// limit; more than `stdout_len` when stdout was truncated.
+    pub stdout_total_len: usize,
+    /// Bytes the script wrote to stderr, including any dropped by the output
+    /// limit; more than `stderr_len` when stderr was truncated.
+    pub stderr_total_len: usize,
+    /// Nanoseconds spent compiling the components of commands the script
+    /// spawned.
+    pub compile_ns: u64,
+    /// Nanoseconds spent creating the shell instance and its filesystem.
+    pub instantiate_ns: u64,
+    /// Nanoseconds spent running the script, its prelude and trap handlers,
+    /// less `compile_ns`.
+    pub execute_ns: u64,
+    /// Nanoseconds spent copying the output into this struct.
+    pub marshal_ns: u64,
+    /// Errors the shell and its builtins reported, in order (owned, freed
+    /// with the result), or null if there were none. Unlike stderr, a
+    /// script cannot write these itself.
+    pub diagnostics: *mut ConchDiagnostic,
+    /// Number of entries in `diagnostics`.
+    pub diagnostics_len: usize,
+    /// `CONCH_LIMIT_*` bits of the limits the execution ran into without
+    /// failing, such as `CONCH_LIMIT_FS`. Unlike stderr, a script cannot
+    /// set these itself.
+    pub exceeded: u32,
+}
+
+/// An error the shell or one of its builtins reported; see
+/// `ConchResult::diagnostics`.
+#[repr(C)]
+#[derive(Debug)]
+pub struct ConchDiagnostic {
+    /// What kind of error it was, such as "command-not-found", as a
+    /// null-terminated string.
+    pub category: *mut c_char,
+    /// The builtin that reported it or the command that was not found, or
+    /// "" for other errors of the shell.
+    pub command: *mut c_char,
+    /// The error without the shell and command prefixes.
+    pub message: *mut c_char,
+    /// The line of the script the error happened on, counting from 1, or 0
+    /// if it is not known.
+    pub line: u32,
+    /// The column of the error, counting from 1, or 0 if it is not known.
+    /// Only syntax errors have one.
+    pub column: u32,
+}
+
+/// `ConchResult::traps` bit set when the script's EXIT trap ran.
+pub const CONCH_TRAP_EXIT: u8 = 1;
+/// `ConchResult::traps` bit set when the script's INT trap ran.
+pub const CONCH_TRAP_INT: u8 = 2;
+/// `ConchResult::traps` bit set when the script's TERM trap ran.
+pub const CONCH_TRAP_TERM: u8 = 4;
+
+/// `conch_interrupt_set_capture()` mode keeping the first bytes of each
+/// stream up to the output limit, the default.
+pub const CONCH_CAPTURE_HEAD: u8 = 0;
+/// `conch_interrupt_set_capture()` mode keeping the last bytes of each
+/// stream up to the output limit.
+pub const CONCH_CAPTURE_TAIL: u8 = 1;
+
+/// `conch_interrupt_set_compression()` codec leaving the output as written,
+/// the default.
+pub const CONCH_COMPRESS_NONE: u8 = 0;
+/// `conch_interrupt_set_compression()` codec compressing the output as a
+/// gzip stream.
+pub const CONCH_COMPRESS_GZIP: u8 = 1;
+/// `conch_interrupt_set_compression()` codec compressing the output as a
+/// zstd frame.
+pub const CONCH_COMPRESS_ZSTD: u8 = 2;
+
+/// `ConchResult::compressed` bit set when `stdout_data` is compressed.
+pub const CONCH_COMPRESSED_STDOUT: u8 = 1;
+/// `ConchResult::compressed` bit set when `stderr_data` is compressed.
+pub const CONCH_COMPRESSED_STDERR: u8 = 2;
+
+/// `ConchConnectCallback` kind of a TCP connect.
+pub const CONCH_CONNECT_TCP: u8 = 0;
+/// `ConchConnectCallback` kind of a UDP connect or outgoing datagram.
+pub const CONCH_CONNECT_UDP: u8 = 1;
+/// `ConchConnectCallback` kind of a name lookup.
+pub const CONCH_CONNECT_LOOKUP: u8 = 2;
+
+/// `ConchLimits::exceeded` bit set when shell functions nested deeper than
+/// `max_call_depth`.
+pub const CONCH_LIMIT_CALL_DEPTH: u32 = 1;
+/// `ConchLimits::exceeded` bit set when the execution ran past `timeout_ms`.
+pub const CONCH_LIMIT_TIMEOUT: u32 = 2;
+/// `ConchResult::exceeded` bit set when a write was refused for going past
+/// `max_fs_bytes` or the cap on `/tmp`. The script sees "no space left on
+/// device" and may still succeed.
+pub const CONCH_LIMIT_FS: u32 = 4;
+/// `ConchLimits::exceeded` bit set when the script was longer than
+/// `max_script_bytes` and was not run.
+pub const CONCH_LIMIT_SCRIPT: u32 = 8;
+
+/// Resource limits for the `conch_execute*_v2()` exports, which also report
+/// the limit a failed execution exceeded.
+///
+/// Limits are added as fields at the end, so the exports can take them
+/// without changing their signatures: callers set `size` to the size of the
+/// struct they were built against, and fields past it are treated as 0.
+#[repr(C)]
+#[derive(Debug, Clone, Copy)]
+pub struct ConchLimits {
+    /// `sizeof(ConchLimits)` as the caller knows it.
+    pub size: usize,
+    /// Set by the library to the `CONCH_LIMIT_*` bits of the limits a failed
+    /// execution exceeded, or 0.
+    pub exceeded: u32,
+    /// CPU time the script may use, in milliseconds.
+    pub max_cpu_ms: u64,
+    /// Memory the shell may use, in bytes.
+    pub max_memory_bytes: u64,
+    /// Output kept from each of stdout and stderr, in bytes.
+    pub max_output_bytes: u64,
+    /// Wall-clock time the execution may take, in milliseconds.
+    pub timeout_ms: u64,
+    /// Maximum shell function call depth, with 0 meaning no limit beyond the
+    /// wasm stack. Exceeding either sets `CONCH_LIMIT_CALL_DEPTH`.
+    pub max_call_depth: u32,
+    /// Bytes the files the script writes may hold at once, with 0 meaning no
+    /// limit. Writes beyond it fail inside the script with "no space left on
+    /// device".
+    pub max_fs_bytes: u64,
+    /// Longest script the library runs, in bytes, with 0 meaning no limit.
+    /// Longer scripts fail without running and set `CONCH_LIMIT_SCRIPT`.
+    pub max_script_bytes: u64,
+    /// Longest one `grep` or `jq` may run, in milliseconds, with 0 meaning
+    /// no limit. One that runs longer fails with status 124.
+    pub builtin_timeout_ms: u64,
+    /// Longest regex pattern `grep` compiles, in bytes, with 0 meaning no
+    /// limit.
+    pub max_regex_pattern_bytes: u64,
+    /// Largest compiled regex program, in bytes, with 0 meaning the
+    /// engine's default.
+    pub max_regex_compiled_bytes: u64,
+    /// How deeply a regex's groups and repetitions may nest, with 0 meaning
+    /// the engine's default.
+    pub max_regex_nesting: u32,
+}
+
+/// Size of the first `ConchLimits`, whose fields every caller sets.
+const CONCH_LIMITS_MIN_SIZE: usize =
+    std::mem::offset_of!(ConchLimits, max_call_depth) + std::mem::size_of::<u32>();
+
+impl ConchLimits {
+    /// The limits at `limits`, with `exceeded` cleared, or `None` with the
+    /// last error set if they cannot be read.
+    ///
+    /// # Safety
+    /// - `limits` must be null or valid for `size` bytes.
+    unsafe fn resolve(limits: *mut ConchLimits) -> Option<ResourceLimits> {
+        if limits.is_null() {
+            set_last_error("limits is null");
+            return None;
+        }
+        let size = unsafe { (*limits).size };
+        if size < CONCH_LIMITS_MIN_SIZE {
+            set_last_error(&format!("ConchLimits size {size} is too small"));
+            return None;
+        }
+        let limits = unsafe { &mut *limits };
+        limits.exceeded = 0;
+        let max_fs_bytes = if size
+            >= std::mem::offset_of!(ConchLimits, max_fs_bytes) + std::mem::size_of::<u64>()
+        {
+            limits.max_fs_bytes
+        } else {
+            0
+        };
+        let max_script_bytes = if size
+            >= std::mem::offset_of!(ConchLimits, max_script_bytes) + std::mem::size_of::<u64>()
+        {
+            limits.max_script_bytes
+        } else {
+            0
+        };
+        let builtin_timeout_ms = if size
+            >= std::mem::offset_of!(ConchLimits, builtin_timeout_ms) + std::mem::size_of::<u64>()
+        {
+            limits.builtin_timeout_ms
+        } else {
+            0
+        };
+        // The regex limits were added together.
+        let (max_regex_pattern_bytes, max_regex_compiled_bytes, max_regex_nesting) = if size
+            >= std::mem::offset_of!(ConchLimits, max_regex_nesting) + std::mem::size_of::<u32>()
+        {
+            (
+                limits.max_regex_pattern_bytes,
+                limits.max_regex_compiled_bytes,
+                limits.max_regex_nesting,
+            )
+        } else {
+            (0, 0, 0)
+        };
+        Some(ResourceLimits {
+            max_cpu_ms: limits.max_cpu_ms,
+            max_memory_bytes: limits.max_memory_bytes,
+            max_output_bytes: limits.max_output_bytes,
+            timeout: std::time::Duration::from_millis(limits.timeout_ms),
+            max_call_depth: limits.max_call_depth,
+            max_fs_bytes,
+            max_script_bytes,
+            builtin_timeout_ms,
+            max_regex_pattern_bytes,
+            max_regex_compiled_bytes,
+            max_regex_nesting,
+        })
+    }
+}
+
+/// Set the last error for an execution that failed with `e`, and the limit
+/// it exceeded, if any, in `exceeded`. Returns null for the export to return.
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+fn execution_failed(
+    e: crate::runtime::RuntimeError,
+    exceeded: Option<&mut u32>,
+) -> *mut ConchResult {
+    if let Some(exceeded) = exceeded {
+        *exceeded = match e {
+            crate::runtime::RuntimeError::DepthExceeded => CONCH_LIMIT_CALL_DEPTH,
+            crate::runtime::RuntimeError::Timeout => CONCH_LIMIT_TIMEOUT,
+            crate::runtime::RuntimeError::ScriptTooLarge { .. } => CONCH_LIMIT_SCRIPT,
+            _ => 0,
+        };
+    }
+    set_last_error(&format!("execution failed: {}", e));
+    ptr::null_mut()
 }
 
+/// Trap names with their `CONCH_TRAP_*` bit and signal number.
+const TRAP_SIGNALS: [(&str, u8, u8); 3] = [
+    ("EXIT", CONCH_TRAP_EXIT, 0),
+    ("INT", CONCH_TRAP_INT, SIGINT),
+    ("TERM", CONCH_TRAP_TERM, SIGTERM),
+];
+
+/// The signal a triggered execution is stopped with.
+const SIGINT: u8 = 2;
+
+/// The signal an expired execution, or one that timed out, is stopped with.
+const SIGTERM: u8 = 15;
+
 /// Opaque handle to a shell executor.
 #[derive(Debug)]
 pub struct ConchExecutor {
     executor: ComponentShellExecutor,
+    /// Shell functions defined via `conch_executor_define_function()`,
+    /// keyed by name.
+    functions: Mutex<BTreeMap<String, String>>,
+    /// Script set via `conch_executor_set_init_script()`.
+    init_script: Mutex<String>,
+    /// Bumped whenever the init script, a function or a seeded file changes,
+    /// so the prelude state is evaluated again.
+    #[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+    prelude_generation: AtomicU64,
+    /// The prelude state as of a generation; see [`PreludeState`].
+    #[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+    prelude_state: tokio::sync::Mutex<Option<(u64, PreludeState)>>,
+    /// Handler set via `conch_executor_set_command_handler()`.
+    #[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+    command_handler: Mutex<Option<Arc<FfiCommandHandler>>>,
+    /// Handler set via `conch_executor_set_prompt_handler()`.
+    #[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+    prompt_handler: Mutex<Option<Arc<FfiPromptHandler>>>,
+    /// Handler set via `conch_executor_set_connect_handler()`.
+    #[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+    connect_handler: Mutex<Option<Arc<FfiConnectHandler>>>,
+    /// Files seeded via `conch_executor_add_file()`, keyed by absolute path.
+    #[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+    files: Mutex<BTreeMap<String, Arc<Vec<u8>>>>,
+    /// `/tmp` settings set via `conch_executor_set_tmp()`.
+    #[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+    tmp: Mutex<TmpConfig>,
+    /// Labels set via `conch_executor_set_labels()`, recorded on the span of
+    /// each execution.
+    #[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+    labels: Mutex<String>,
+    /// Commands set via `conch_executor_set_allowed_commands()`, or `None`
+    /// if all are allowed.
+    #[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+    allowed_commands: Mutex<Option<Vec<String>>>,
+    /// Counts the executor in `conch_stats()` until it is freed.
+    _gauge: ExecutorGauge,
+}
+
+impl ConchExecutor {
+    fn new(executor: ComponentShellExecutor) -> Self {
+        Self {
+            _gauge: ExecutorGauge::new(executor.compiled_size()),
+            executor,
+            functions: Mutex::new(BTreeMap::new()),
+            init_script: Mutex::new(String::new()),
+            prelude_generation: AtomicU64::new(0),
+            prelude_state: tokio::sync::Mutex::new(None),
+            command_handler: Mutex::new(None),
+            prompt_handler: Mutex::new(None),
+            connect_handler: Mutex::new(None),
+            files: Mutex::new(BTreeMap::new()),
+            tmp: Mutex::new(TmpConfig::default()),
+            labels: Mutex::new(String::new()),
+            allowed_commands: Mutex::new(None),
+        }
+    }
+
+    /// Shell source for the init script followed by every registered
+    /// function, or an empty string.
+    #[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+    fn prelude(&self) -> String {
+        let mut prelude = self
+            .init_script
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .clone();
+        if !prelude.is_empty() {
+            prelude.push('\n');
+        }
+        let functions = self.functions.lock().unwrap_or_else(|e| e.into_inner());
+        for (name, body) in functions.iter() {
+            prelude.push_str(&format!("{name}() {{\n{body}\n}}\n"));
+        }
+        prelude
+    }
+
+    /// Note that the prelude or the files it may read changed.
+    fn invalidate_prelude(&self) {
+        self.prelude_generation.fetch_add(1, Ordering::AcqRel);
+    }
+}
+
+/// What evaluating an executor's init script and registered functions left
+/// behind.
+///
+/// They are evaluated once, in an instance of their own, and the state they
+/// leave is restored into the fresh shell of each execution, so their side
+/// effects happen once and none of them runs with the caller's limits or
+/// I/O.
+#[derive(Debug, Clone)]
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+enum PreludeState {
+    /// Shell source declaring the variables, functions, aliases and options
+    /// they set; empty if there are none.
+    Ready(Arc<str>),
+    /// They exited non-zero; each execution's result is this one.
+    Failed(crate::runtime::ExecutionResult),
+}
+
+#[cfg(feature = "embedded-shell")]
+impl ConchExecutor {
+    /// Write the seeded files into `storage`, returning the top-level
+    /// directories that hold them so they can be mounted.
+    async fn seed_files<S: eryx_vfs::VfsStorage>(
+        &self,
+        storage: &S,
+    ) -> Result<Vec<String>, crate::runtime::RuntimeError> {
+        let files = self.files.lock().unwrap_or_else(|e| e.into_inner()).clone();
+        let mut roots = Vec::new();
+        for (path, data) in &files {
+            let mut dir = String::new();
+            let components: Vec<&str> = path.split('/').filter(|c| !c.is_empty()).collect();
+            if let Some((_, parents)) = components.split_last() {
+                for component in parents {
+                    dir.push('/');
+                    dir.push_str(component);
+                    // Already-existing directories are fine; a real failure
+                    // surfaces from the write below.
+                    let _ = storage.mkdir(&dir).await;
+                }
+            }
+            storage
+                .write(path, data)
+                .await
+                .map_err(|e| crate::runtime::RuntimeError::Vfs(format!("{path}: {e}")))?;
+
+            let root = format!("/{}", components.first().copied().unwrap_or_default());
+            if !roots.contains(&root) {
+                roots.push(root);
+            }
+        }
+        Ok(roots)
+    }
+}
+
+/// Whether `name` is usable as a shell function name.
+fn is_valid_function_name(name: &str) -> bool {
+    let mut chars = name.chars();
+    matches!(chars.next(), Some(c) if c.is_ascii_alphabetic() || c == '_')
+        && chars.all(|c| c.is_ascii_alphanumeric() || c == '_' || c == '-')
+}
+
+/// Callback servicing commands that have no registered component.
+///
+/// Receives the caller's `user_data`, the command name, `nargs` argument
+/// strings, the command's stdin and an output handle. Returns non-zero if it
+/// handled the command, after filling `out` with `conch_command_output_set()`;
+/// zero leaves the command unhandled ("command not found").
+pub type ConchCommandCallback = unsafe extern "C" fn(
+    user_data: *mut c_void,
+    name: *const c_char,
+    args: *const *const c_char,
+    nargs: usize,
+    stdin: *const u8,
+    stdin_len: usize,
+    out: *mut ConchCommandOutput,
+) -> i32;
+
+/// Output handle filled in by a `ConchCommandCallback`.
+#[derive(Debug, Default)]
+pub struct ConchCommandOutput {
+    output: CommandOutput,
+    /// ID of the execution running the command, read with
+    /// `conch_command_execution_id()`.
+    execution_id: Option<CString>,
+    /// Address of the execution's interrupt, or 0, read with
+    /// `conch_command_interrupt()`.
+    interrupt: usize,
+}
+
+/// Adapts a C callback to [`CommandHandler`].
+#[derive(Clone, Debug)]
+struct FfiCommandHandler {
+    callback: ConchCommandCallback,
+    user_data: *mut c_void,
+    /// ID of the execution whose commands this handler services, set per
+    /// instance by [`new_instance`]. It comes from the interrupt, not the
+    /// script's environment, so a script cannot claim another execution's.
+    execution_id: Option<CString>,
+    /// Address of that execution's interrupt, or 0.
+    interrupt: usize,
+}
+
+// SAFETY: `conch_executor_set_command_handler()` requires the callback to be
+// callable from any thread with its `user_data`.
+unsafe impl Send for FfiCommandHandler {}
+unsafe impl Sync for FfiCommandHandler {}
+
+impl CommandHandler for FfiCommandHandler {
+    fn run(&self, request: CommandRequest) -> Option<CommandOutput> {
+        // Interior NULs cannot cross the C boundary; such a command is
+        // left unhandled.
+        let name = CString::new(request.name).ok()?;
+        let args = request
+            .args
+            .into_iter()
+            .map(CString::new)
+            .collect::<Result<Vec<_>, _>>()
+            .ok()?;
+        let arg_ptrs: Vec<*const c_char> = args.iter().map(|a| a.as_ptr()).collect();
+
+        let mut out = ConchCommandOutput {
+            execution_id: self.execution_id.clone(),
+            interrupt: self.interrupt,
+            ..Default::default()
+        };
+        let handled = unsafe {
+            (self.callback)(
+                self.user_data,
+                name.as_ptr(),
+                arg_ptrs.as_ptr(),
+                arg_ptrs.len(),
+                request.stdin.as_ptr(),
+                request.stdin.len(),
+                &mut out,
+            )
+        };
+        (handled != 0).then_some(out.output)
+    }
+}
+
+/// Callback supplying input to a script that reads stdin.
+///
+/// Receives the caller's `user_data`, the pending prompt (the text the script
+/// wrote to stderr since its last newline, such as `read -p`'s) and an answer
+/// handle. Returns non-zero after filling `answer` with
+/// `conch_prompt_answer_set()`; zero ends the script's input.
+pub type ConchPromptCallback = unsafe extern "C" fn(
+    user_data: *mut c_void,
+    prompt: *const c_char,
+    answer: *mut ConchPromptAnswer,
+) -> i32;
+
+/// Answer handle filled in by a `ConchPromptCallback`.
+#[derive(Debug, Default)]
+pub struct ConchPromptAnswer {
+    line: String,
+    /// ID of the execution reading stdin, read with
+    /// `conch_prompt_execution_id()`.
+    execution_id: Option<CString>,
+    /// Address of the execution's interrupt, or 0, read with
+    /// `conch_prompt_interrupt()`.
+    interrupt: usize,
+}
+
+/// Callback deciding whether a spawned command may open a connection or
+/// resolve a name.
+///
+/// `execution_id` is the ID given with `conch_interrupt_set_id()`, or null.
+/// `command` is the name of the command connecting. `kind` is one of the
+/// `CONCH_CONNECT_*` constants: for `CONCH_CONNECT_TCP` and
+/// `CONCH_CONNECT_UDP`, `addr` is the resolved address as `ip:port`; for
+/// `CONCH_CONNECT_LOOKUP` it is the name to resolve. Return non-zero to
+/// allow the connection or lookup; it fails with "permission denied"
+/// otherwise. All strings are valid only for the duration of the call.
+pub type ConchConnectCallback = unsafe extern "C" fn(
+    user_data: *mut c_void,
+    execution_id: *const c_char,
+    command: *const c_char,
+    addr: *const c_char,
+    kind: u8,
+) -> i32;
+
+/// Adapts a C callback to [`ConnectHandler`].
+#[derive(Clone, Debug)]
+struct FfiConnectHandler {
+    callback: ConchConnectCallback,
+    user_data: *mut c_void,
+    /// ID of the execution whose connections this handler checks, set per
+    /// instance by [`new_instance`].
+    execution_id: Option<CString>,
+}
+
+// SAFETY: `conch_executor_set_connect_handler()` requires the callback to be
+// callable from any thread with its `user_data`.
+unsafe impl Send for FfiConnectHandler {}
+unsafe impl Sync for FfiConnectHandler {}
+
+impl FfiConnectHandler {
+    /// Ask the callback about `addr`, a `kind` of connection or lookup.
+    fn ask(&self, command: String, addr: String, kind: u8) -> bool {
+        // A command name or host name with an interior NUL cannot cross the
+        // C boundary; it is denied.
+        let (Ok(command), Ok(addr)) = (CString::new(command), CString::new(addr)) else {
+            return false;
+        };
+        let execution_id = self
+            .execution_id
+            .as_ref()
+            .map_or(std::ptr::null(), |id| id.as_ptr());
+        let allowed = unsafe {
+            (self.callback)(
+                self.user_data,
+                execution_id,
+                command.as_ptr(),
+                addr.as_ptr(),
+                kind,
+            )
+        };
+        allowed != 0
+    }
+}
+
+impl ConnectHandler for FfiConnectHandler {
+    fn allow(&self, request: ConnectRequest) -> bool {
+        let kind = if request.udp {
+            CONCH_CONNECT_UDP
+        } else {
+            CONCH_CONNECT_TCP
+        };
+        self.ask(request.command, request.addr.to_string(), kind)
+    }
+
+    fn allow_lookup(&self, request: LookupRequest) -> bool {
+        self.ask(request.command, request.name, CONCH_CONNECT_LOOKUP)
+    }
+}
+
+/// Adapts a C callback to [`PromptHandler`].
+#[derive(Clone, Debug)]
+struct FfiPromptHandler {
+    callback: ConchPromptCallback,
+    user_data: *mut c_void,
+    /// ID of the execution this handler answers, set per instance by
+    /// [`run_script`].
+    execution_id: Option<CString>,
+    /// Address of that execution's interrupt, or 0.
+    interrupt: usize,
+}
+
+// SAFETY: `conch_executor_set_prompt_handler()` requires the callback to be
+// callable from any thread with its `user_data`.
+unsafe impl Send for FfiPromptHandler {}
+unsafe impl Sync for FfiPromptHandler {}
+
+impl PromptHandler for FfiPromptHandler {
+    fn prompt(&self, prompt: &str) -> Option<String> {
+        let prompt = CString::new(prompt.replace('\0', "")).ok()?;
+        let mut answer = ConchPromptAnswer {
+            execution_id: self.execution_id.clone(),
+            interrupt: self.interrupt,
+            ..Default::default()
+        };
+        let answered = unsafe { (self.callback)(self.user_data, prompt.as_ptr(), &mut answer) };
+        (answered != 0).then_some(answer.line)
+    }
+}
+
+/// Callback receiving output as a script writes it, set with
+/// `conch_interrupt_set_output()`. `stream` is 1 for stdout and 2 for
+/// stderr, and `offset` is the position of `data` in that stream. `data` is
+/// only valid for the duration of the call.
+pub type ConchOutputCallback = unsafe extern "C" fn(
+    user_data: *mut c_void,
+    stream: u8,
+    data: *const u8,
+    len: usize,
+    offset: u64,
+);
+
+/// Forwards an execution's output to a C callback, tracking each stream's
+/// offset across the instances the execution uses.
+#[derive(Debug)]
+struct FfiOutputHandler {
+    callback: ConchOutputCallback,
+    user_data: *mut c_void,
+    /// Bytes passed so far for stdout and stderr.
+    offsets: [AtomicU64; 2],
+}
+
+// SAFETY: `conch_interrupt_set_output()` requires the callback to be
+// callable from any thread with its `user_data`.
+unsafe impl Send for FfiOutputHandler {}
+unsafe impl Sync for FfiOutputHandler {}
+
+impl FfiOutputHandler {
+    #[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+    fn write(&self, stream: u8, data: &[u8]) {
+        let Some(offset) = self.offsets.get(usize::from(stream).wrapping_sub(1)) else {
+            return;
+        };
+        let at = offset.fetch_add(data.len() as u64, Ordering::Relaxed);
+        unsafe { (self.callback)(self.user_data, stream, data.as_ptr(), data.len(), at) };
+    }
+
+    /// Pass everything `instance` writes from now on to the callback.
+    #[cfg(feature = "embedded-shell")]
+    fn tap(self: &Arc<Self>, instance: &mut crate::executor::ShellInstance<ArcStorage>) {
+        let output = self.clone();
+        instance.tap_output(Arc::new(move |stream, data| output.write(stream, data)));
+    }
+}
+
+/// Opaque handle to an emulated terminal's size, shared with an in-flight
+/// execution so it can be resized.
+#[derive(Debug)]
+pub struct ConchTerminal {
+    size: Arc<TerminalSize>,
+}
+
+/// Opaque handle to an interrupt flag shared with an in-flight execution.
+#[derive(Debug, Default)]
+pub struct ConchInterrupt {
+    flag: Arc<AtomicBool>,
+    /// `SIGINT` once triggered, `SIGTERM` once expired; zero if the
+    /// execution stopped on its own timeout, which also means TERM.
+    signal: Arc<AtomicU8>,
+    /// Output of the trap handlers run after the execution was stopped.
+    trapped: Mutex<Option<crate::runtime::ExecutionResult>>,
+    /// The caller's ID for the execution, set by `conch_interrupt_set_id()`.
+    id: OnceLock<String>,
+    /// Calls between the guest and the host so far, read by
+    /// `conch_interrupt_activity()`.
+    activity: Arc<AtomicU64>,
+    /// Receives the output as it is written, set by
+    /// `conch_interrupt_set_output()`.
+    output: OnceLock<Arc<FfiOutputHandler>>,
+    /// Which output the limit keeps, set by `conch_interrupt_set_capture()`.
+    capture: AtomicU8,
+    /// The codec compressing the output, and the smallest stream it
+    /// compresses, set by `conch_interrupt_set_compression()`.
+    compression: AtomicU8,
+    compress_min: AtomicUsize,
+    /// Variables named by `conch_interrupt_capture_var()`, with their values
+    /// once the script has finished; `None` if unset.
+    vars: Mutex<Vec<(String, Option<CString>)>>,
+    /// Variables set by `conch_interrupt_set_var()` before the script runs.
+    assign: Mutex<Vec<(String, String)>>,
+}
+
+impl ConchInterrupt {
+    /// Whether the execution keeps the end of its output.
+    fn keeps_tail(&self) -> bool {
+        self.capture.load(Ordering::Acquire) == CONCH_CAPTURE_TAIL
+    }
+
+    /// Compress the streams of `result` as set by
+    /// `conch_interrupt_set_compression()`, returning the
+    /// `CONCH_COMPRESSED_*` bits of those compressed. A stream that would not
+    /// shrink, or fails to compress, is left as it is.
+    fn compress_output(&self, result: &mut crate::runtime::ExecutionResult) -> u8 {
+        let codec = self.compression.load(Ordering::Acquire);
+        if codec == CONCH_COMPRESS_NONE {
+            return 0;
+        }
+        let min = self.compress_min.load(Ordering::Acquire).max(1);
+        let mut compressed = 0;
+        for (stream, bit) in [
+            (&mut result.stdout, CONCH_COMPRESSED_STDOUT),
+            (&mut result.stderr, CONCH_COMPRESSED_STDERR),
+        ] {
+            if stream.len() < min {
+                continue;
+            }
+            match compress(stream, codec) {
+                Ok(packed) if packed.len() < stream.len() => {
+                    *stream = packed;
+                    compressed |= bit;
+                }
+                Ok(_) => {}
+                Err(e) => tracing::warn!("failed to compress output: {e}"),
+            }
+        }
+        compressed
+    }
+
+    /// Set the variables from `conch_interrupt_set_var()` in `instance`.
+    #[cfg(feature = "embedded-shell")]
+    async fn assign_vars(
+        &self,
+        instance: &mut crate::executor::ShellInstance<ArcStorage>,
+    ) -> Result<(), crate::runtime::RuntimeError> {
+        let assign = self
+            .assign
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .clone();
+        for (name, value) in &assign {
+            instance.set_var(name, value).await?;
+        }
+        Ok(())
+    }
+
+    /// Read the variables to capture from `instance`.
+    #[cfg(feature = "embedded-shell")]
+    async fn capture_vars(
+        &self,
+        instance: &mut crate::executor::ShellInstance<ArcStorage>,
+    ) -> Result<(), crate::runtime::RuntimeError> {
+        let names: Vec<String> = self
+            .vars
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .iter()
+            .map(|(name, _)| name.clone())
+            .collect();
+        let mut values = Vec::with_capacity(names.len());
+        for name in &names {
+            let value = instance.get_var(name).await?;
+            values.push(value.and_then(|v| CString::new(v).ok()));
+        }
+        let mut vars = self.vars.lock().unwrap_or_else(|e| e.into_inner());
+        for ((_, value), captured) in vars.iter_mut().zip(values) {
+            *value = captured;
+        }
+        Ok(())
+    }
+
+    /// Stop the execution, running the trap for `signal` before teardown.
+    fn stop(&self, signal: u8) {
+        let _ = self
+            .signal
+            .compare_exchange(0, signal, Ordering::AcqRel, Ordering::Acquire);
+        self.flag.store(true, Ordering::Release);
+    }
+
+    /// Script exporting the execution ID to the shell, or an empty string
+    /// if none was set.
+    #[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+    fn id_prelude(&self) -> String {
+        match self.id.get() {
+            Some(id) => format!("export {EXECUTION_ID_VAR}={}\n", shell_quote(id)),
+            None => String::new(),
+        }
+    }
+}
+
+/// Compress `data` with `codec`, a `CONCH_COMPRESS_*` codec other than
+/// `CONCH_COMPRESS_NONE`.
+fn compress(data: &[u8], codec: u8) -> std::io::Result<Vec<u8>> {
+    use std::io::Write;
+
+    match codec {
+        CONCH_COMPRESS_GZIP => {
+            let mut encoder =
+                flate2::write::GzEncoder::new(Vec::new(), flate2::Compression::fast());
+            encoder.write_all(data)?;
+            encoder.finish()
+        }
+        _ => zstd::bulk::compress(data, zstd::DEFAULT_COMPRESSION_LEVEL),
+    }
+}
+
+/// Environment variable holding the caller's execution ID, so scripts, the
+/// commands they run and trap handlers can tag their output with it.
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+const EXECUTION_ID_VAR: &str = "CONCH_EXECUTION_ID";
+
+/// Quote `s` as a single shell word.
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+fn shell_quote(s: &str) -> String {
+    format!("'{}'", s.replace('\'', "'\\''"))
 }
 
 // ============================================================================
@@ -78,6 +924,93 @@ pub extern "C" fn conch_last_error() -> *const c_char {
     })
 }
 
+/// Copy the last error message (thread-local).
+///
+/// Returns a new null-terminated C string, or null if no error. Unlike
+/// `conch_last_error()`, the copy stays valid whatever runs on the thread
+/// afterwards.
+///
+/// The caller owns the string and must free it with `conch_string_free()`.
+#[unsafe(no_mangle)]
+pub extern "C" fn conch_last_error_copy() -> *mut c_char {
+    LAST_ERROR.with(|e| {
+        e.borrow()
+            .as_ref()
+            .map_or(ptr::null_mut(), |s| s.clone().into_raw())
+    })
+}
+
+/// Run `f`, an export reporting failure with `set_last_error()`, and copy the
+/// message of a failure into `err_buf`, truncated to fit `err_len` bytes
+/// including its null terminator. `err_buf` holds an empty string after a
+/// success.
+///
+/// The message is taken on the thread that failed, so callers whose threads
+/// are shared, such as Go, get their own call's message.
+///
+/// # Safety
+/// - `err_buf` must be null or valid for writes of `err_len` bytes.
+unsafe fn with_error_buffer<T>(err_buf: *mut c_char, err_len: usize, f: impl FnOnce() -> T) -> T {
+    LAST_ERROR.with(|e| *e.borrow_mut() = None);
+    let ret = f();
+    if err_buf.is_null() || err_len == 0 {
+        return ret;
+    }
+    LAST_ERROR.with(|e| {
+        let msg = e.borrow();
+        let bytes = msg.as_ref().map_or(&[][..], |s| s.as_bytes());
+        let mut n = bytes.len().min(err_len - 1);
+        // Truncate on a character boundary.
+        while n < bytes.len() && n > 0 && bytes[n] & 0xc0 == 0x80 {
+            n -= 1;
+        }
+        unsafe {
+            ptr::copy_nonoverlapping(bytes.as_ptr(), err_buf.cast::<u8>(), n);
+            *err_buf.add(n) = 0;
+        }
+    });
+    ret
+}
+
+/// Define `<name>_err` variants of exports that fail by setting the last
+/// error: each takes two more arguments, `err_buf` and `err_len`, and writes
+/// the failure message there; see `with_error_buffer()`.
+macro_rules! error_buffer_variants {
+    ($($name:ident => $inner:ident($($arg:ident: $ty:ty),*) -> $ret:ty;)*) => {
+        $(
+            #[doc = concat!("Like `", stringify!($inner), "()`, writing the message of a failure into")]
+            /// `err_buf` rather than leaving it for `conch_last_error()`.
+            ///
+            /// # Safety
+            #[doc = concat!("- As for `", stringify!($inner), "()`.")]
+            /// - `err_buf` must be null or valid for writes of `err_len` bytes.
+            #[unsafe(no_mangle)]
+            #[allow(unused_unsafe)]
+            pub unsafe extern "C" fn $name(
+                $($arg: $ty,)*
+                err_buf: *mut c_char,
+                err_len: usize,
+            ) -> $ret {
+                unsafe { with_error_buffer(err_buf, err_len, || unsafe { $inner($($arg),*) }) }
+            }
+        )*
+    };
+}
+
+/// Free a string returned by `conch_last_error_copy()` or
+/// `conch_parse_script()`.
+///
+/// # Safety
+/// - `s` must be a pointer returned by `conch_last_error_copy()` or
+///   `conch_parse_script()`, or null.
+/// - The pointer must not be used after this call.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_string_free(s: *mut c_char) {
+    if !s.is_null() {
+        drop(unsafe { CString::from_raw(s) });
+    }
+}
+
 // ============================================================================
 // Feature detection
 // ============================================================================
@@ -97,6 +1030,145 @@ pub extern "C" fn conch_has_embedded_shell() -> u8 {
     }
 }
 
+// ============================================================================
+// Versions
+// ============================================================================
+
+/// Null-terminated library version, shared by `conch_version()`.
+static VERSION: &str = concat!(env!("CARGO_PKG_VERSION"), "\0");
+
+/// Null-terminated shell interface version, shared by
+/// `conch_shell_interface_version()`.
+static SHELL_INTERFACE_VERSION: LazyLock<CString> =
+    LazyLock::new(|| CString::new(crate::executor::SHELL_INTERFACE_VERSION).unwrap_or_default());
+
+/// Get the library version (the `conch` crate version).
+///
+/// Returns a pointer to a static null-terminated string. Do not free it.
+#[unsafe(no_mangle)]
+pub extern "C" fn conch_version() -> *const c_char {
+    VERSION.as_ptr() as *const c_char
+}
+
+/// Get the `conch:shell` WIT interface version this library speaks.
+///
+/// Shell components must export `conch:shell/shell@<version>` to be loadable.
+/// Returns a pointer to a static null-terminated string. Do not free it.
+#[unsafe(no_mangle)]
+pub extern "C" fn conch_shell_interface_version() -> *const c_char {
+    SHELL_INTERFACE_VERSION.as_ptr()
+}
+
+/// Null-terminated JSON array of [`crate::SHELL_FEATURES`], shared by
+/// `conch_supported_features()`.
+static SUPPORTED_FEATURES: LazyLock<CString> = LazyLock::new(|| {
+    let json = serde_json::to_string(crate::SHELL_FEATURES).unwrap_or_default();
+    CString::new(json).unwrap_or_default()
+});
+
+/// Get the shell language features the sandbox supports.
+///
+/// Returns a pointer to a static null-terminated JSON array of objects with
+/// `name`, `description`, `supported`, `caveats`, `probe` and `expected`
+/// fields. Do not free it.
+#[unsafe(no_mangle)]
+pub extern "C" fn conch_supported_features() -> *const c_char {
+    SUPPORTED_FEATURES.as_ptr()
+}
+
+// ============================================================================
+// Statistics
+// ============================================================================
+
+/// Process-wide resource counters, filled in by `conch_stats()`.
+#[repr(C)]
+#[derive(Debug, Default)]
+pub struct ConchStats {
+    /// Executors created and not yet freed.
+    pub live_executors: u64,
+    /// Linear memory currently allocated by running executions, in bytes.
+    pub wasm_memory_bytes: u64,
+    /// Compiled machine code held for live executors' components, in bytes.
+    pub compiled_code_bytes: u64,
+    /// Executions started since the library was loaded.
+    pub executions: u64,
+}
+
+/// Report the library's resource use for capacity planning.
+///
+/// The counters cover every executor in the process and are read one at a
+/// time, so they may be slightly out of step while executions run.
+///
+/// Returns 0 on success, or -1 on failure.
+/// On failure, call `conch_last_error()` to get the error message.
+///
+/// # Safety
+/// - `out` must be a valid pointer to a `ConchStats`.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_stats(out: *mut ConchStats) -> i32 {
+    if out.is_null() {
+        set_last_error("out is null");
+        return -1;
+    }
+
+    let stats = crate::runtime_stats();
+    unsafe {
+        *out = ConchStats {
+            live_executors: stats.live_executors as u64,
+            wasm_memory_bytes: stats.wasm_memory_bytes as u64,
+            compiled_code_bytes: stats.compiled_code_bytes as u64,
+            executions: stats.executions,
+        };
+    }
+    0
+}
+
+// ============================================================================
+// Parsing
+// ============================================================================
+
+/// Parse `script` without running it and return its syntax tree as JSON.
+///
+/// Each node is an object with `kind`, `named`, `start` and `end` fields,
+/// the last two byte offsets into `script`, and its children in a
+/// `children` array, left out when there are none; see
+/// [`crate::SyntaxNode`].
+///
+/// Returns a new null-terminated string, or null if `script` is not valid
+/// shell. On failure, call `conch_last_error()` to get the error message.
+/// The caller owns the string and must free it with `conch_string_free()`.
+///
+/// # Safety
+/// - `script` must be a valid null-terminated C string.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_parse_script(script: *const c_char) -> *mut c_char {
+    if script.is_null() {
+        set_last_error("script is null");
+        return ptr::null_mut();
+    }
+    let script = match unsafe { CStr::from_ptr(script) }.to_str() {
+        Ok(s) => s,
+        Err(e) => {
+            set_last_error(&format!("invalid UTF-8 in script: {}", e));
+            return ptr::null_mut();
+        }
+    };
+    let tree = match crate::parse_script(script) {
+        Ok(tree) => tree,
+        Err(e) => {
+            set_last_error(&e.to_string());
+            return ptr::null_mut();
+        }
+    };
+    match serde_json::to_string(&tree).map(CString::new) {
+        Ok(Ok(json)) => json.into_raw(),
+        _ => {
+            set_last_error("failed to encode the syntax tree");
+            ptr::null_mut()
+        }
+    }
+}
+
 // ============================================================================
 // Executor lifecycle
 // ============================================================================
@@ -113,7 +1185,7 @@ pub extern "C" fn conch_has_embedded_shell() -> u8 {
 #[unsafe(no_mangle)]
 pub extern "C" fn conch_executor_new_embedded() -> *mut ConchExecutor {
     match ComponentShellExecutor::embedded() {
-        Ok(executor) => Box::into_raw(Box::new(ConchExecutor { executor })),
+        Ok(executor) => Box::into_raw(Box::new(ConchExecutor::new(executor))),
         Err(e) => {
             set_last_error(&format!("failed to create executor: {}", e));
             ptr::null_mut()
@@ -153,7 +1225,7 @@ pub unsafe extern "C" fn conch_executor_new(path: *const c_char) -> *mut ConchEx
     };
 
     match ComponentShellExecutor::from_file(path_str) {
-        Ok(executor) => Box::into_raw(Box::new(ConchExecutor { executor })),
+        Ok(executor) => Box::into_raw(Box::new(ConchExecutor::new(executor))),
         Err(e) => {
             set_last_error(&format!("failed to load component: {}", e));
             ptr::null_mut()
@@ -182,7 +1254,7 @@ pub unsafe extern "C" fn conch_executor_new_from_bytes(
     let slice = unsafe { std::slice::from_raw_parts(bytes, len) };
 
     match ComponentShellExecutor::from_bytes(slice) {
-        Ok(executor) => Box::into_raw(Box::new(ConchExecutor { executor })),
+        Ok(executor) => Box::into_raw(Box::new(ConchExecutor::new(executor))),
         Err(e) => {
             set_last_error(&format!("failed to load component: {}", e));
             ptr::null_mut()
@@ -202,107 +1274,2231 @@ pub unsafe extern "C" fn conch_executor_free(executor: *mut ConchExecutor) {
     }
 }
 
-// ============================================================================
-// Execution helpers
-// ============================================================================
+/// Define a shell function available to every subsequent execution.
+///
+/// `body` becomes the body of `name() { ... }` and is defined in each fresh
+/// shell instance before the script runs, along with the state left by the
+/// init script (see `conch_executor_set_init_script()`), so it can use `$@`
+/// and `return` as usual. Defining an existing name replaces it; an empty `body` removes
+/// the function.
+///
+/// Returns 0 on success, or -1 on failure.
+/// On failure, call `conch_last_error()` to get the error message.
+///
+/// # Safety
+/// - `executor` must be a valid pointer from `conch_executor_new*()`.
+/// - `name` and `body` must be valid null-terminated C strings.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_executor_define_function(
+    executor: *mut ConchExecutor,
+    name: *const c_char,
+    body: *const c_char,
+) -> i32 {
+    if executor.is_null() {
+        set_last_error("executor is null");
+        return -1;
+    }
 
-/// Helper to execute a script and convert the result to ConchResult.
-#[cfg(feature = "embedded-shell")]
-async fn execute_script_internal(
-    executor: &ComponentShellExecutor,
-    script: &str,
-    limits: &ResourceLimits,
-) -> Result<crate::runtime::ExecutionResult, crate::runtime::RuntimeError> {
-    // Create a minimal VFS context with a /tmp directory
-    let storage = ArcStorage::new(Arc::new(InMemoryStorage::new()));
-    let mut hybrid_ctx = HybridVfsCtx::new(storage.clone());
-    hybrid_ctx.add_vfs_preopen("/tmp", DirPerms::all(), FilePerms::all());
+    if name.is_null() || body.is_null() {
+        set_last_error("name or body is null");
+        return -1;
+    }
 
-    // Children share the same VFS storage + mounts.
-    let child_vfs = crate::executor::ChildVfs {
-        storage,
-        vfs_mounts: vec![("/tmp".to_string(), DirPerms::all(), FilePerms::all())],
-        real_mounts: vec![],
+    let executor = unsafe { &*executor };
+
+    let (name, body) = match (
+        unsafe { CStr::from_ptr(name) }.to_str(),
+        unsafe { CStr::from_ptr(body) }.to_str(),
+    ) {
+        (Ok(name), Ok(body)) => (name, body),
+        (Err(e), _) | (_, Err(e)) => {
+            set_last_error(&format!("invalid UTF-8 in function: {}", e));
+            return -1;
+        }
+    };
+
+    if !is_valid_function_name(name) {
+        set_last_error(&format!("invalid function name: {:?}", name));
+        return -1;
+    }
+
+    let mut functions = executor.functions.lock().unwrap_or_else(|e| e.into_inner());
+    if body.is_empty() {
+        functions.remove(name);
+    } else {
+        functions.insert(name.to_string(), body.to_string());
+    }
+    executor.invalidate_prelude();
+    0
+}
+
+/// Set a script setting up the shell of every subsequent execution.
+///
+/// Use it for setup such as aliases, functions and `set -euo pipefail`.
+/// The script runs once, followed by the functions from
+/// `conch_executor_define_function()`, in a shell instance of its own with
+/// the seeded files, empty stdin and default limits. The variables,
+/// functions, aliases, options, traps and working directory it leaves are
+/// then restored into the fresh shell of each execution before the caller's
+/// script; files it writes are not. It runs again at the next execution after
+/// it, a function or a seeded file changes. If it exits non-zero, each
+/// execution's result is the init script's. An empty `script` clears it.
+///
+/// Returns 0 on success, or -1 on failure.
+/// On failure, call `conch_last_error()` to get the error message.
+///
+/// # Safety
+/// - `executor` must be a valid pointer from `conch_executor_new*()`.
+/// - `script` must be a valid null-terminated C string.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_executor_set_init_script(
+    executor: *mut ConchExecutor,
+    script: *const c_char,
+) -> i32 {
+    if executor.is_null() {
+        set_last_error("executor is null");
+        return -1;
+    }
+
+    if script.is_null() {
+        set_last_error("script is null");
+        return -1;
+    }
+
+    let executor = unsafe { &*executor };
+
+    let script_str = match unsafe { CStr::from_ptr(script) }.to_str() {
+        Ok(s) => s,
+        Err(e) => {
+            set_last_error(&format!("invalid UTF-8 in script: {}", e));
+            return -1;
+        }
+    };
+
+    *executor
+        .init_script
+        .lock()
+        .unwrap_or_else(|e| e.into_inner()) = script_str.to_string();
+    executor.invalidate_prelude();
+    0
+}
+
+/// Set the callback servicing commands that have no registered component.
+///
+/// The callback runs on a native thread once the shell has closed the
+/// command's stdin; see `ConchCommandCallback`. Passing a null `callback`
+/// removes the handler.
+///
+/// Returns 0 on success, or -1 on failure.
+/// On failure, call `conch_last_error()` to get the error message.
+///
+/// # Safety
+/// - `executor` must be a valid pointer from `conch_executor_new*()`.
+/// - `callback` must be safe to call from any thread with `user_data` until
+///   the handler is replaced or the executor is freed.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_executor_set_command_handler(
+    executor: *mut ConchExecutor,
+    callback: Option<ConchCommandCallback>,
+    user_data: *mut c_void,
+) -> i32 {
+    if executor.is_null() {
+        set_last_error("executor is null");
+        return -1;
+    }
+
+    let executor = unsafe { &*executor };
+
+    *executor
+        .command_handler
+        .lock()
+        .unwrap_or_else(|e| e.into_inner()) = callback.map(|callback| {
+        Arc::new(FfiCommandHandler {
+            callback,
+            user_data,
+            execution_id: None,
+            interrupt: 0,
+        })
+    });
+    0
+}
+
+/// Set the callback deciding whether spawned commands may open network
+/// connections.
+///
+/// The callback runs on a native thread for every name lookup, TCP connect
+/// and UDP connect or send by a spawned component; see
+/// `ConchConnectCallback`. Without a handler every connection is allowed. Passing a null `callback`
+/// removes the handler.
+///
+/// Returns 0 on success, or -1 on failure.
+/// On failure, call `conch_last_error()` to get the error message.
+///
+/// # Safety
+/// - `executor` must be a valid pointer from `conch_executor_new*()`.
+/// - `callback` must be safe to call from any thread with `user_data` until
+///   the handler is replaced or the executor is freed.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_executor_set_connect_handler(
+    executor: *mut ConchExecutor,
+    callback: Option<ConchConnectCallback>,
+    user_data: *mut c_void,
+) -> i32 {
+    if executor.is_null() {
+        set_last_error("executor is null");
+        return -1;
+    }
+
+    let executor = unsafe { &*executor };
+
+    *executor
+        .connect_handler
+        .lock()
+        .unwrap_or_else(|e| e.into_inner()) = callback.map(|callback| {
+        Arc::new(FfiConnectHandler {
+            callback,
+            user_data,
+            execution_id: None,
+        })
+    });
+    0
+}
+
+/// Record the result of a command from inside a `ConchCommandCallback`.
+///
+/// The data is copied, so the caller's buffers only need to live for the
+/// duration of this call.
+///
+/// # Safety
+/// - `out` must be the handle passed to the running callback.
+/// - `stdout` and `stderr` must be valid pointers to `stdout_len` and
+///   `stderr_len` bytes, or null if the length is 0.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_command_output_set(
+    out: *mut ConchCommandOutput,
+    exit_code: i32,
+    stdout: *const u8,
+    stdout_len: usize,
+    stderr: *const u8,
+    stderr_len: usize,
+) {
+    if out.is_null() {
+        return;
+    }
+
+    let copy = |data: *const u8, len: usize| {
+        if data.is_null() || len == 0 {
+            Vec::new()
+        } else {
+            unsafe { std::slice::from_raw_parts(data, len) }.to_vec()
+        }
+    };
+
+    unsafe { &mut *out }.output = CommandOutput {
+        exit_code,
+        stdout: copy(stdout, stdout_len),
+        stderr: copy(stderr, stderr_len),
+    };
+}
+
+/// Get the ID of the execution running the command, from inside a
+/// `ConchCommandCallback`, so the caller can find state it keeps for that
+/// execution.
+///
+/// Returns null if the execution was given no ID (see
+/// `conch_interrupt_set_id()`) or `out` is null.
+///
+/// # Safety
+/// - `out` must be the handle passed to the running callback, or null.
+/// - The returned string is owned by `out` and valid until the callback
+///   returns.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_command_execution_id(
+    out: *const ConchCommandOutput,
+) -> *const c_char {
+    if out.is_null() {
+        return ptr::null();
+    }
+    unsafe { &*out }
+        .execution_id
+        .as_ref()
+        .map_or(ptr::null(), |id| id.as_ptr())
+}
+
+/// Get the ID of the execution reading stdin, from inside a
+/// `ConchPromptCallback`, so the caller can find state it keeps for that
+/// execution, such as whether it was cancelled.
+///
+/// Returns null if the execution was given no ID (see
+/// `conch_interrupt_set_id()`) or `answer` is null.
+///
+/// # Safety
+/// - `answer` must be the handle passed to the running callback, or null.
+/// - The returned string is owned by `answer` and valid until the callback
+///   returns.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_prompt_execution_id(
+    answer: *const ConchPromptAnswer,
+) -> *const c_char {
+    if answer.is_null() {
+        return ptr::null();
+    }
+    unsafe { &*answer }
+        .execution_id
+        .as_ref()
+        .map_or(ptr::null(), |id| id.as_ptr())
+}
+
+/// Get the interrupt of the execution running the command, from inside a
+/// `ConchCommandCallback`. Unlike its ID, which the caller chooses and
+/// executions may share, it tells executions in progress apart.
+///
+/// Returns null if the execution was given no interrupt or `out` is null.
+/// The interrupt is only for comparing; it belongs to the execution.
+///
+/// # Safety
+/// - `out` must be the handle passed to the running callback, or null.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_command_interrupt(
+    out: *const ConchCommandOutput,
+) -> *const ConchInterrupt {
+    if out.is_null() {
+        return ptr::null();
+    }
+    unsafe { &*out }.interrupt as *const ConchInterrupt
+}
+
+/// Get the interrupt of the execution reading stdin, from inside a
+/// `ConchPromptCallback`; see `conch_command_interrupt()`.
+///
+/// # Safety
+/// - `answer` must be the handle passed to the running callback, or null.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_prompt_interrupt(
+    answer: *const ConchPromptAnswer,
+) -> *const ConchInterrupt {
+    if answer.is_null() {
+        return ptr::null();
+    }
+    unsafe { &*answer }.interrupt as *const ConchInterrupt
+}
+
+/// Set the callback supplying input to scripts that read stdin.
+///
+/// Executions that are not given stdin of their own ask the callback for a
+/// line each time the script reads with no input buffered, instead of seeing
+/// end of file; see `ConchPromptCallback`. Their stdin reports as a
+/// terminal. Passing a null `callback` removes the handler.
+///
+/// Returns 0 on success, or -1 on failure.
+/// On failure, call `conch_last_error()` to get the error message.
+///
+/// # Safety
+/// - `executor` must be a valid pointer from `conch_executor_new*()`.
+/// - `callback` must be safe to call from any thread with `user_data` until
+///   the handler is replaced or the executor is freed.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_executor_set_prompt_handler(
+    executor: *mut ConchExecutor,
+    callback: Option<ConchPromptCallback>,
+    user_data: *mut c_void,
+) -> i32 {
+    if executor.is_null() {
+        set_last_error("executor is null");
+        return -1;
+    }
+
+    let executor = unsafe { &*executor };
+
+    *executor
+        .prompt_handler
+        .lock()
+        .unwrap_or_else(|e| e.into_inner()) = callback.map(|callback| {
+        Arc::new(FfiPromptHandler {
+            callback,
+            user_data,
+            execution_id: None,
+            interrupt: 0,
+        })
+    });
+    0
+}
+
+/// Record the line answering a prompt from inside a `ConchPromptCallback`.
+///
+/// The data is copied, so the caller's buffer only needs to live for the
+/// duration of this call. A trailing newline is added if missing; invalid
+/// UTF-8 is replaced.
+///
+/// # Safety
+/// - `answer` must be the handle passed to the running callback.
+/// - `data` must be a valid pointer to `len` bytes, or null if `len` is 0.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_prompt_answer_set(
+    answer: *mut ConchPromptAnswer,
+    data: *const u8,
+    len: usize,
+) {
+    if answer.is_null() {
+        return;
+    }
+
+    let line = if data.is_null() || len == 0 {
+        String::new()
+    } else {
+        String::from_utf8_lossy(unsafe { std::slice::from_raw_parts(data, len) }).into_owned()
+    };
+    unsafe { &mut *answer }.line = line;
+}
+
+/// Seed a file into the virtual filesystem of every subsequent execution.
+///
+/// `path` must be absolute and not `/` itself. Its top-level directory is
+/// mounted read-write; writes made by a script are discarded when the
+/// execution ends, like everything else in its fresh filesystem. Adding an
+/// existing path replaces it.
+///
+/// Returns 0 on success, or -1 on failure.
+/// On failure, call `conch_last_error()` to get the error message.
+///
+/// # Safety
+/// - `executor` must be a valid pointer from `conch_executor_new*()`.
+/// - `path` must be a valid null-terminated C string.
+/// - `data` must be a valid pointer to `len` bytes, or null if `len` is 0.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_executor_add_file(
+    executor: *mut ConchExecutor,
+    path: *const c_char,
+    data: *const u8,
+    len: usize,
+) -> i32 {
+    if executor.is_null() {
+        set_last_error("executor is null");
+        return -1;
+    }
+
+    if path.is_null() {
+        set_last_error("path is null");
+        return -1;
+    }
+
+    if data.is_null() && len != 0 {
+        set_last_error("data is null");
+        return -1;
+    }
+
+    let executor = unsafe { &*executor };
+
+    let path_str = match unsafe { CStr::from_ptr(path) }.to_str() {
+        Ok(s) => s,
+        Err(e) => {
+            set_last_error(&format!("invalid UTF-8 in path: {}", e));
+            return -1;
+        }
+    };
+
+    if !path_str.starts_with('/') || path_str.trim_matches('/').is_empty() {
+        set_last_error(&format!("invalid file path: {:?}", path_str));
+        return -1;
+    }
+
+    let contents = if len == 0 {
+        Vec::new()
+    } else {
+        unsafe { std::slice::from_raw_parts(data, len) }.to_vec()
+    };
+
+    executor
+        .files
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .insert(path_str.to_string(), Arc::new(contents));
+    executor.invalidate_prelude();
+    0
+}
+
+/// Configure the `/tmp` of each execution.
+///
+/// Every execution starts with an empty `/tmp` that is discarded when it
+/// ends. `max_bytes` caps the bytes its files may hold at once; a write
+/// beyond it fails with "no space left on device". 0 removes the cap. Files
+/// seeded with `conch_executor_add_file()` do not count towards it.
+///
+/// If `retain_dir` is not null, the files each execution leaves in `/tmp` are
+/// copied to a subdirectory of that host directory named after the execution
+/// ID set with `conch_interrupt_set_id()`, or `execution-<pid>-<n>` without
+/// one, for debugging. Pass null to stop retaining.
+///
+/// Returns 0 on success, or -1 on failure.
+/// On failure, call `conch_last_error()` to get the error message.
+///
+/// # Safety
+/// - `executor` must be a valid pointer from `conch_executor_new*()`.
+/// - `retain_dir` must be null or a valid null-terminated C string.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_executor_set_tmp(
+    executor: *mut ConchExecutor,
+    max_bytes: u64,
+    retain_dir: *const c_char,
+) -> i32 {
+    if executor.is_null() {
+        set_last_error("executor is null");
+        return -1;
+    }
+
+    let executor = unsafe { &*executor };
+
+    let retain_dir = if retain_dir.is_null() {
+        None
+    } else {
+        match unsafe { CStr::from_ptr(retain_dir) }.to_str() {
+            Ok("") => {
+                set_last_error("retain_dir is empty");
+                return -1;
+            }
+            Ok(s) => Some(std::path::PathBuf::from(s)),
+            Err(e) => {
+                set_last_error(&format!("invalid UTF-8 in retain_dir: {}", e));
+                return -1;
+            }
+        }
+    };
+
+    *executor.tmp.lock().unwrap_or_else(|e| e.into_inner()) = TmpConfig {
+        max_bytes,
+        retain_dir,
+    };
+    0
+}
+
+/// Set the labels recorded on the tracing span of each execution.
+///
+/// `labels` is free-form text, such as `tenant="acme" team="search"`, that
+/// lets the executor's logs be told apart from those of other executors in
+/// the process. An empty string removes them.
+///
+/// Returns 0 on success, or -1 on failure.
+/// On failure, call `conch_last_error()` to get the error message.
+///
+/// # Safety
+/// - `executor` must be a valid pointer from `conch_executor_new*()`.
+/// - `labels` must be a valid null-terminated C string.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_executor_set_labels(
+    executor: *mut ConchExecutor,
+    labels: *const c_char,
+) -> i32 {
+    if executor.is_null() {
+        set_last_error("executor is null");
+        return -1;
+    }
+    if labels.is_null() {
+        set_last_error("labels is null");
+        return -1;
+    }
+
+    let executor = unsafe { &*executor };
+    let labels = match unsafe { CStr::from_ptr(labels) }.to_str() {
+        Ok(s) => s.to_string(),
+        Err(e) => {
+            set_last_error(&format!("invalid UTF-8 in labels: {}", e));
+            return -1;
+        }
+    };
+
+    *executor.labels.lock().unwrap_or_else(|e| e.into_inner()) = labels;
+    0
+}
+
+/// Allow scripts to run only the `len` commands in `names`.
+///
+/// The list covers commands spawned as components, commands serviced by the
+/// command handler and conch's own builtins, such as `jq` and `tool`; others
+/// fail as not found, including in the init script. The shell's builtins,
+/// such as `echo` and `cd`, are always available. Passing a null `names`
+/// allows every command again.
+///
+/// Returns 0 on success, or -1 on failure.
+/// On failure, call `conch_last_error()` to get the error message.
+///
+/// # Safety
+/// - `executor` must be a valid pointer from `conch_executor_new*()`.
+/// - `names` must be null or point to `len` valid null-terminated C strings.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_executor_set_allowed_commands(
+    executor: *mut ConchExecutor,
+    names: *const *const c_char,
+    len: usize,
+) -> i32 {
+    if executor.is_null() {
+        set_last_error("executor is null");
+        return -1;
+    }
+
+    let executor = unsafe { &*executor };
+    let allowed = if names.is_null() {
+        None
+    } else {
+        let mut allowed = Vec::with_capacity(len);
+        for &name in unsafe { std::slice::from_raw_parts(names, len) } {
+            if name.is_null() {
+                set_last_error("command name is null");
+                return -1;
+            }
+            match unsafe { CStr::from_ptr(name) }.to_str() {
+                Ok(name) => allowed.push(name.to_string()),
+                Err(e) => {
+                    set_last_error(&format!("invalid UTF-8 in command name: {}", e));
+                    return -1;
+                }
+            }
+        }
+        Some(allowed)
+    };
+
+    *executor
+        .allowed_commands
+        .lock()
+        .unwrap_or_else(|e| e.into_inner()) = allowed;
+    executor.invalidate_prelude();
+    0
+}
+
+// ============================================================================
+// Execution helpers
+// ============================================================================
+
+/// Helper to execute a script and convert the result to ConchResult.
+///
+/// The executor's init script and registered functions run in the fresh
+/// instance before `script`. If they fail, that result is returned instead
+/// so the caller sees the shell's diagnostics.
+///
+/// The script's EXIT trap runs once it completes. If `interrupt` stops it,
+/// its INT or TERM trap and then its EXIT trap run in a second instance on
+/// the same VFS, and their output is left in `interrupt` for
+/// `conch_interrupt_take_result()`.
+///
+/// Everything the execution logs is recorded in a `conch_execute` span
+/// carrying the ID set with `conch_interrupt_set_id()`, if any.
+///
+/// The execution gets a fresh filesystem holding the seeded files and an
+/// empty `/tmp`. What the script writes to it is capped by
+/// `limits.max_fs_bytes`, and `/tmp` is capped and retained as set with
+/// `conch_executor_set_tmp()`.
+#[cfg(feature = "embedded-shell")]
+async fn execute_script_internal(
+    conch: &ConchExecutor,
+    script: &str,
+    limits: &ResourceLimits,
+    io: InstanceIo,
+    interrupt: Option<&ConchInterrupt>,
+) -> Result<crate::runtime::ExecutionResult, crate::runtime::RuntimeError> {
+    use tracing::Instrument;
+
+    limits.check_script(script)?;
+    crate::stats::record_execution();
+    let span = tracing::debug_span!(
+        "conch_execute",
+        execution_id = tracing::field::Empty,
+        labels = tracing::field::Empty
+    );
+    if let Some(id) = interrupt.and_then(|i| i.id.get()) {
+        span.record("execution_id", id.as_str());
+    }
+    {
+        let labels = conch.labels.lock().unwrap_or_else(|e| e.into_inner());
+        if !labels.is_empty() {
+            span.record("labels", labels.as_str());
+        }
+    }
+    let config = conch.tmp.lock().unwrap_or_else(|e| e.into_inner()).clone();
+    async {
+        // Seed before wrapping, so seeded files do not count towards the cap.
+        let seeding = std::time::Instant::now();
+        let inner = Arc::new(InMemoryStorage::new());
+        let roots = conch.seed_files(&*inner).await?;
+        let seeded = seeding.elapsed();
+        let fs = Arc::new(QuotaStorage::new(inner, "/", limits.max_fs_bytes));
+        let tmp = Arc::new(QuotaStorage::new(fs, TMP_DIR, config.max_bytes));
+        let storage = ArcStorage::new(tmp.clone());
+        let mut result = run_script(conch, script, limits, io, interrupt, &storage, roots).await;
+        if let Ok(result) = &mut result {
+            result.timings.instantiate += seeded;
+            result.fs_exceeded = fs.exceeded() || tmp.exceeded();
+        }
+        if let Some(dir) = &config.retain_dir {
+            let dir = dir.join(retained_tmp_name(interrupt));
+            if let Err(e) = tmp.retain(&dir).await {
+                tracing::warn!(dir = %dir.display(), error = %e, "failed to retain /tmp");
+            }
+        }
+        result
+    }
+    .instrument(span)
+    .await
+}
+
+/// Name of the directory receiving a retained `/tmp`: the execution ID made
+/// safe for a file name, or a name unique to this execution without one.
+#[cfg(feature = "embedded-shell")]
+fn retained_tmp_name(interrupt: Option<&ConchInterrupt>) -> String {
+    static NEXT: std::sync::atomic::AtomicU64 = std::sync::atomic::AtomicU64::new(1);
+
+    let id = interrupt.and_then(|i| i.id.get());
+    match id.map(|id| sanitize_file_name(id)) {
+        Some(name) if name != "." && name != ".." && !name.is_empty() => name,
+        _ => format!(
+            "execution-{}-{}",
+            std::process::id(),
+            NEXT.fetch_add(1, Ordering::Relaxed)
+        ),
+    }
+}
+
+/// Replace everything but ASCII letters, digits, `.`, `_` and `-` in `s`.
+#[cfg(feature = "embedded-shell")]
+fn sanitize_file_name(s: &str) -> String {
+    s.chars()
+        .map(|c| match c {
+            'a'..='z' | 'A'..='Z' | '0'..='9' | '.' | '_' | '-' => c,
+            _ => '_',
+        })
+        .collect()
+}
+
+/// The body of [`execute_script_internal`].
+#[cfg(feature = "embedded-shell")]
+async fn run_script(
+    conch: &ConchExecutor,
+    script: &str,
+    limits: &ResourceLimits,
+    io: InstanceIo,
+    interrupt: Option<&ConchInterrupt>,
+    storage: &ArcStorage,
+    roots: Vec<String>,
+) -> Result<crate::runtime::ExecutionResult, crate::runtime::RuntimeError> {
+    let vfs_mounts = vfs_mounts(roots);
+
+    let execution_id = interrupt.and_then(|interrupt| interrupt.id.get().map(String::as_str));
+
+    // Scripts given no stdin of their own read from the caller's prompt
+    // handler, if one is set.
+    let io = match io {
+        InstanceIo::Captured => match conch
+            .prompt_handler
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .clone()
+        {
+            Some(handler) => InstanceIo::Prompt(Arc::new(FfiPromptHandler {
+                execution_id: execution_id.and_then(|id| CString::new(id).ok()),
+                interrupt: interrupt.map_or(0, |interrupt| ptr::from_ref(interrupt) as usize),
+                ..(*handler).clone()
+            })),
+            None => InstanceIo::Captured,
+        },
+        io => io,
+    };
+
+    let started = std::time::Instant::now();
+    let mut instance = new_instance(conch, limits, storage, &vfs_mounts, interrupt, io).await?;
+    let instantiate = started.elapsed();
+    if let Some(interrupt) = interrupt {
+        instance.count_activity(interrupt.activity.clone());
+        if interrupt.keeps_tail() {
+            instance.keep_output_tail();
+        }
+    }
+    // The script, prelude and traps each time themselves; report them as one.
+    let timings = |instance: &crate::executor::ShellInstance<ArcStorage>| {
+        let compile = instance.compile_time();
+        crate::runtime::ExecutionTimings {
+            compile,
+            instantiate,
+            execute: (started.elapsed() - instantiate).saturating_sub(compile),
+        }
+    };
+
+    // Restore the state of the init script and registered functions for the
+    // script below.
+    let setup = interrupt.map_or_else(String::new, ConchInterrupt::id_prelude);
+    if let Some(mut failed) = restore_prelude(conch, &mut instance, &setup).await? {
+        failed.timings = timings(&instance);
+        return Ok(failed);
+    }
+    if let Some(interrupt) = interrupt {
+        interrupt.assign_vars(&mut instance).await?;
+    }
+    if let Some(output) = interrupt.and_then(|i| i.output.get()) {
+        output.tap(&mut instance);
+    }
+
+    // Execute the script
+    let result = match interrupt {
+        Some(interrupt) => {
+            instance
+                .execute_interruptible(
+                    script,
+                    limits,
+                    interrupt.flag.clone(),
+                    interrupt.signal.clone(),
+                )
+                .await
+        }
+        None => instance.execute(script, limits).await,
+    };
+
+    match (result, interrupt) {
+        (Ok(mut result), _) => {
+            let source = "eval \"$(command trap -p)\"";
+            if let Some(exit) =
+                run_trap(&mut instance, "EXIT", result.exit_code, source, limits).await?
+            {
+                append_output(&mut result, exit);
+                result.traps.push("EXIT".to_string());
+                if interrupt.is_some_and(ConchInterrupt::keeps_tail) {
+                    keep_output_tail(&mut result, limits.max_output_bytes as usize);
+                }
+            }
+            if let Some(interrupt) = interrupt {
+                interrupt.capture_vars(&mut instance).await?;
+            }
+            result.timings = timings(&instance);
+            Ok(result)
+        }
+        (Err(crate::runtime::RuntimeError::Timeout), Some(interrupt)) => {
+            // A script that took its signal stopped cleanly and can run its
+            // handlers, with a short budget of their own; one that was
+            // trapped cannot be re-entered.
+            if let Some(signal) = instance.taken_signal() {
+                let limits = ResourceLimits {
+                    timeout: limits.timeout.min(TRAP_TIMEOUT),
+                    max_cpu_ms: limits.max_cpu_ms.min(TRAP_TIMEOUT.as_millis() as u64),
+                    ..limits.clone()
+                };
+                let trapped = run_signal_traps(&mut instance, interrupt, signal, &limits).await;
+                if let Ok(Some(trapped)) = trapped {
+                    *interrupt.trapped.lock().unwrap_or_else(|e| e.into_inner()) = Some(trapped);
+                }
+            }
+            Err(crate::runtime::RuntimeError::Timeout)
+        }
+        (Err(e), _) => Err(e),
+    }
+}
+
+/// The mounts of an execution: `/tmp`, plus each top-level directory in
+/// `roots` holding seeded files.
+#[cfg(feature = "embedded-shell")]
+fn vfs_mounts(roots: Vec<String>) -> Vec<(String, DirPerms, FilePerms)> {
+    let mut vfs_mounts = vec![("/tmp".to_string(), DirPerms::all(), FilePerms::all())];
+    for root in roots {
+        if root != "/tmp" {
+            vfs_mounts.push((root, DirPerms::all(), FilePerms::all()));
+        }
+    }
+    vfs_mounts
+}
+
+/// Create a shell instance mounting `vfs_mounts` of `storage`, with the
+/// embedded coreutils and the executor's command handler, which is told the
+/// commands belong to the execution holding `interrupt`.
+#[cfg(feature = "embedded-shell")]
+async fn new_instance(
+    conch: &ConchExecutor,
+    limits: &ResourceLimits,
+    storage: &ArcStorage,
+    vfs_mounts: &[(String, DirPerms, FilePerms)],
+    interrupt: Option<&ConchInterrupt>,
+    io: InstanceIo,
+) -> Result<crate::executor::ShellInstance<ArcStorage>, crate::runtime::RuntimeError> {
+    let execution_id = interrupt.and_then(|interrupt| interrupt.id.get().map(String::as_str));
+    let mut hybrid_ctx = HybridVfsCtx::new(storage.clone());
+    for (path, dir_perms, file_perms) in vfs_mounts {
+        hybrid_ctx.add_vfs_preopen(path, *dir_perms, *file_perms);
+    }
+
+    // Children share the same VFS storage + mounts.
+    let child_vfs = crate::executor::ChildVfs {
+        storage: storage.clone(),
+        vfs_mounts: vfs_mounts.to_vec(),
+        real_mounts: vec![],
+    };
+
+    // Ship the embedded coreutils (cat/head/ls/…) so the FFI runtime can spawn
+    // them out of the box, mirroring the `Shell` builder. No-op without the
+    // `embedded-coreutils` feature (registry stays None).
+    let registry: Option<crate::executor::ComponentRegistry> = None;
+    #[cfg(feature = "embedded-coreutils")]
+    let registry = crate::executor::with_embedded_coreutils(registry);
+
+    // Route unknown commands to the caller's handler, if one is set.
+    let handler = conch
+        .command_handler
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .clone();
+    let registry = match handler {
+        Some(handler) => {
+            let handler = Arc::new(FfiCommandHandler {
+                execution_id: execution_id.and_then(|id| CString::new(id).ok()),
+                interrupt: interrupt.map_or(0, |interrupt| ptr::from_ref(interrupt) as usize),
+                ..(*handler).clone()
+            });
+            let mut registry = registry.unwrap_or_default();
+            registry.set_command_not_found_handler(handler);
+            Some(registry)
+        }
+        None => registry,
+    };
+
+    // Check spawned commands' connections with the caller's handler.
+    let connect = conch
+        .connect_handler
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .clone();
+    let registry = match connect {
+        Some(connect) => {
+            let connect = Arc::new(FfiConnectHandler {
+                execution_id: execution_id.and_then(|id| CString::new(id).ok()),
+                ..(*connect).clone()
+            });
+            let mut registry = registry.unwrap_or_default();
+            registry.set_connect_handler(connect);
+            Some(registry)
+        }
+        None => registry,
+    };
+
+    // Refuse the commands the caller did not allow.
+    let allowed = conch
+        .allowed_commands
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .clone();
+    let registry = match allowed {
+        Some(allowed) => {
+            let mut registry = registry.unwrap_or_default();
+            registry.set_allowed_commands(allowed);
+            Some(registry)
+        }
+        None => registry,
+    };
+
+    conch
+        .executor
+        .create_instance_with_io(
+            limits,
+            hybrid_ctx,
+            None,
+            registry.map(Arc::new),
+            child_vfs,
+            io,
+        )
+        .await
+}
+
+// ============================================================================
+// Prelude
+// ============================================================================
+
+/// Records the shell's variables and options before the prelude runs, for
+/// [`PRELUDE_SNAPSHOT`] to compare against.
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+const PRELUDE_BASELINE: &str = r#"declare -A __conch_base
+for __conch_name in $(compgen -v); do
+    __conch_base[$__conch_name]=$(declare -p "$__conch_name" 2>/dev/null)
+done
+__conch_shopt=$(shopt -p)
+__conch_set=$(set +o)
+__conch_dir=$PWD
+__conch_umask=$(umask)
+"#;
+
+/// Prints shell source restoring what the prelude changed since
+/// [`PRELUDE_BASELINE`]. Shell options come first, since they change how
+/// the functions parse, and `set` options last, so errexit cannot stop the
+/// restore part way.
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+const PRELUDE_SNAPSHOT: &str = r#"__conch_now=$(shopt -p)
+[ "$__conch_now" = "$__conch_shopt" ] || printf '%s\n' "$__conch_now"
+for __conch_name in $(compgen -v); do
+    case $__conch_name in
+    __conch_* | BASH* | EPOCH* | FUNCNAME | LINENO | OLDPWD | PIPESTATUS | PWD | RANDOM | SECONDS | SRANDOM | _) continue ;;
+    esac
+    __conch_decl=$(declare -p "$__conch_name" 2>/dev/null) || continue
+    [ "${__conch_base[$__conch_name]-}" = "$__conch_decl" ] || printf '%s\n' "$__conch_decl"
+done
+for __conch_name in "${!__conch_base[@]}"; do
+    case $__conch_name in
+    __conch_* | BASH* | EPOCH* | FUNCNAME | LINENO | OLDPWD | PIPESTATUS | PWD | RANDOM | SECONDS | SRANDOM | _) continue ;;
+    esac
+    declare -p "$__conch_name" >/dev/null 2>&1 || printf 'unset %s\n' "$__conch_name"
+done
+declare -f
+alias -p
+[ "$PWD" = "$__conch_dir" ] || printf 'cd -- %q\n' "$PWD"
+[ "$(umask)" = "$__conch_umask" ] || printf 'umask %s\n' "$(umask)"
+trap -p
+__conch_now=$(set +o)
+[ "$__conch_now" = "$__conch_set" ] || printf '%s\n' "$__conch_now"
+"#;
+
+#[cfg(feature = "embedded-shell")]
+impl ConchExecutor {
+    /// The state the init script and registered functions leave, evaluating
+    /// them if they changed since it was last taken.
+    async fn prelude_state(&self) -> Result<PreludeState, crate::runtime::RuntimeError> {
+        let mut cached = self.prelude_state.lock().await;
+        let generation = self.prelude_generation.load(Ordering::Acquire);
+        if let Some((taken, state)) = &*cached
+            && *taken == generation
+        {
+            return Ok(state.clone());
+        }
+        let state = self.evaluate_prelude().await?;
+        *cached = Some((generation, state.clone()));
+        Ok(state)
+    }
+
+    /// Run the init script and define the registered functions in an
+    /// instance of their own, and take the state they leave.
+    async fn evaluate_prelude(&self) -> Result<PreludeState, crate::runtime::RuntimeError> {
+        let prelude = self.prelude();
+        if prelude.is_empty() {
+            return Ok(PreludeState::Ready(Arc::from("")));
+        }
+
+        let limits = ResourceLimits::default();
+        let inner = Arc::new(InMemoryStorage::new());
+        let roots = self.seed_files(&*inner).await?;
+        let storage = ArcStorage::new(inner);
+        let mut instance = new_instance(
+            self,
+            &limits,
+            &storage,
+            &vfs_mounts(roots),
+            None,
+            InstanceIo::Captured,
+        )
+        .await?;
+
+        instance.prepare(PRELUDE_BASELINE).await?;
+        let result = instance.execute(&prelude, &limits).await?;
+        if result.exit_code != 0 {
+            return Ok(PreludeState::Failed(result));
+        }
+        let snapshot = instance.prepare(PRELUDE_SNAPSHOT).await?;
+        if snapshot.exit_code != 0 || snapshot.truncated {
+            return Err(crate::runtime::RuntimeError::Wasm(format!(
+                "failed to take the state of the init script: {}",
+                String::from_utf8_lossy(&snapshot.stderr).trim()
+            )));
+        }
+        Ok(PreludeState::Ready(
+            String::from_utf8_lossy(&snapshot.stdout).into(),
+        ))
+    }
+}
+
+/// Restore the state of `conch`'s init script and registered functions into
+/// `instance`, then run `setup`. Returns the result to report in place of
+/// the script's if either fails.
+#[cfg(feature = "embedded-shell")]
+async fn restore_prelude(
+    conch: &ConchExecutor,
+    instance: &mut crate::executor::ShellInstance<ArcStorage>,
+    setup: &str,
+) -> Result<Option<crate::runtime::ExecutionResult>, crate::runtime::RuntimeError> {
+    let mut script = match conch.prelude_state().await? {
+        PreludeState::Ready(state) => state.to_string(),
+        PreludeState::Failed(result) => return Ok(Some(result)),
+    };
+    script.push_str(setup);
+    if script.is_empty() {
+        return Ok(None);
+    }
+    let result = instance.prepare(&script).await?;
+    Ok((result.exit_code != 0).then_some(result))
+}
+
+// ============================================================================
+// Traps
+// ============================================================================
+
+/// Wall-clock budget for the trap handlers of a stopped execution.
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+const TRAP_TIMEOUT: std::time::Duration = std::time::Duration::from_secs(5);
+
+/// Shell source that sets `$__conch_trap` to the handler for `signal`,
+/// evaluating `source` (which replays `trap -p` output) with `trap` shadowed.
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+fn trap_lookup(signal: &str, number: u8, source: &str) -> String {
+    format!(
+        r#"__conch_trap=
+trap() {{
+    [ "$1" = -- ] && shift
+    __conch_handler=$1
+    shift
+    for __conch_signal in "$@"; do
+        case ${{__conch_signal#SIG}} in
+        {signal} | {number}) __conch_trap=$__conch_handler ;;
+        esac
+    done
+}}
+{source}
+unset -f trap
+unset __conch_handler __conch_signal
+"#
+    )
+}
+
+/// The `CONCH_TRAP_*` mask for the named traps.
+fn trap_mask(traps: &[String]) -> u8 {
+    TRAP_SIGNALS
+        .iter()
+        .filter(|(name, _, _)| traps.iter().any(|t| t == name))
+        .fold(0, |mask, (_, bit, _)| mask | bit)
+}
+
+/// Run the handler `source` sets for the `signal` trap in `instance`, if
+/// any, with `$?` set to `status`.
+#[cfg(feature = "embedded-shell")]
+async fn run_trap(
+    instance: &mut crate::executor::ShellInstance<ArcStorage>,
+    signal: &str,
+    status: i32,
+    source: &str,
+    limits: &ResourceLimits,
+) -> Result<Option<crate::runtime::ExecutionResult>, crate::runtime::RuntimeError> {
+    let number = TRAP_SIGNALS
+        .iter()
+        .find(|(name, _, _)| *name == signal)
+        .map_or(0, |(_, _, number)| *number);
+    instance
+        .execute(&trap_lookup(signal, number, source), limits)
+        .await?;
+    match instance.get_var("__conch_trap").await? {
+        Some(handler) if !handler.is_empty() => {
+            // The left of `||` is exempt from errexit, so a failing status
+            // reaches the handler even under set -e.
+            let script = match status {
+                0 => "eval \"$__conch_trap\"".to_string(),
+                _ => format!("(exit {status}) || eval \"$__conch_trap\""),
+            };
+            Ok(Some(instance.execute(&script, limits).await?))
+        }
+        _ => Ok(None),
+    }
+}
+
+/// Run the handlers the script stopped by `signal` set for it and EXIT, in
+/// the instance it ran in. Returns `None` if neither was set.
+#[cfg(feature = "embedded-shell")]
+async fn run_signal_traps(
+    instance: &mut crate::executor::ShellInstance<ArcStorage>,
+    interrupt: &ConchInterrupt,
+    signal: u8,
+    limits: &ResourceLimits,
+) -> Result<Option<crate::runtime::ExecutionResult>, crate::runtime::RuntimeError> {
+    // As in bash, the script's status is 128 plus the signal number.
+    let (name, _, number) = TRAP_SIGNALS
+        .iter()
+        .find(|(_, _, number)| *number == signal)
+        .copied()
+        .unwrap_or(TRAP_SIGNALS[2]);
+    let status = 128 + i32::from(number);
+    let source = "eval \"$(command trap -p)\"";
+
+    let mut result: Option<crate::runtime::ExecutionResult> = None;
+    for trap in [name, "EXIT"] {
+        if let Some(ran) = run_trap(instance, trap, status, source, limits).await? {
+            let result = result.get_or_insert_with(|| crate::runtime::ExecutionResult {
+                exit_code: status,
+                stdout: Vec::new(),
+                stdout_total_len: 0,
+                stderr: Vec::new(),
+                stderr_total_len: 0,
+                truncated: false,
+                fs_exceeded: false,
+                traps: Vec::new(),
+                diagnostics: Vec::new(),
+                stats: crate::runtime::ExecutionStats::default(),
+                timings: crate::runtime::ExecutionTimings::default(),
+            });
+            append_output(result, ran);
+            result.traps.push(trap.to_string());
+        }
+    }
+    if let Some(result) = &mut result
+        && interrupt.keeps_tail()
+    {
+        keep_output_tail(result, limits.max_output_bytes as usize);
+    }
+    Ok(result)
+}
+
+/// Append the output of trap handlers `ran` to `result`.
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+fn append_output(
+    result: &mut crate::runtime::ExecutionResult,
+    ran: crate::runtime::ExecutionResult,
+) {
+    result.stdout.extend(ran.stdout);
+    result.stdout_total_len += ran.stdout_total_len;
+    result.stderr.extend(ran.stderr);
+    result.stderr_total_len += ran.stderr_total_len;
+    result.truncated |= ran.truncated;
+    result.diagnostics.extend(ran.diagnostics);
+}
+
+/// Drop all but the last `max` bytes of each stream of `result`, after
+/// output from several runs was appended to it.
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+fn keep_output_tail(result: &mut crate::runtime::ExecutionResult, max: usize) {
+    for stream in [&mut result.stdout, &mut result.stderr] {
+        let excess = stream.len().saturating_sub(max);
+        if excess > 0 {
+            stream.drain(..excess);
+            result.truncated = true;
+        }
+    }
+}
+
+/// Convert an ExecutionResult to a ConchResult pointer, compressing its
+/// output if `interrupt` asks for that.
+fn result_to_conch_result(
+    mut exec_result: crate::runtime::ExecutionResult,
+    interrupt: Option<&ConchInterrupt>,
+) -> *mut ConchResult {
+    let started = std::time::Instant::now();
+    let compressed = interrupt.map_or(0, |i| i.compress_output(&mut exec_result));
+    let stdout_len = exec_result.stdout.len();
+    let stderr_len = exec_result.stderr.len();
+
+    let stdout_data = stream_data(exec_result.stdout);
+    let stderr_data = stream_data(exec_result.stderr);
+
+    let (diagnostics, diagnostics_len) = diagnostics_data(exec_result.diagnostics);
+
+    let result = Box::into_raw(Box::new(ConchResult {
+        exit_code: exec_result.exit_code,
+        stdout_data,
+        stdout_len,
+        stderr_data,
+        stderr_len,
+        truncated: if exec_result.truncated { 1 } else { 0 },
+        traps: trap_mask(&exec_result.traps),
+        compressed,
+        stdout_total_len: exec_result.stdout_total_len,
+        stderr_total_len: exec_result.stderr_total_len,
+        compile_ns: nanos(exec_result.timings.compile),
+        instantiate_ns: nanos(exec_result.timings.instantiate),
+        execute_ns: nanos(exec_result.timings.execute),
+        marshal_ns: nanos(started.elapsed()),
+        diagnostics,
+        diagnostics_len,
+        exceeded: if exec_result.fs_exceeded {
+            CONCH_LIMIT_FS
+        } else {
+            0
+        },
+    }));
+    live_results().insert(result as usize);
+    result
+}
+
+/// Hand over `data` as a result stream's data pointer, null-terminated and
+/// left for `conch_result_free()` to free. Returns null if `data` is empty.
+fn stream_data(mut data: Vec<u8>) -> *mut c_char {
+    if data.is_empty() {
+        return ptr::null_mut();
+    }
+    data.push(0); // Add null terminator
+    let ptr = data.as_mut_ptr() as *mut c_char;
+    std::mem::forget(data);
+    ptr
+}
+
+/// Hand over `diagnostics` as the result's array of them, left for
+/// `conch_result_free()` to free. Returns null, without allocating, if
+/// there are none.
+fn diagnostics_data(diagnostics: Vec<crate::runtime::Diagnostic>) -> (*mut ConchDiagnostic, usize) {
+    if diagnostics.is_empty() {
+        return (ptr::null_mut(), 0);
+    }
+    let c_string = |s: String| {
+        CString::new(s.replace('\0', ""))
+            .unwrap_or_default()
+            .into_raw()
+    };
+    let data: Box<[ConchDiagnostic]> = diagnostics
+        .into_iter()
+        .map(|d| ConchDiagnostic {
+            category: c_string(d.category),
+            command: c_string(d.command),
+            message: c_string(d.message),
+            line: d.line,
+            column: d.column,
+        })
+        .collect();
+    let len = data.len();
+    (Box::into_raw(data) as *mut ConchDiagnostic, len)
+}
+
+/// Addresses of the `ConchResult`s handed out and not yet freed, so
+/// `conch_result_free()` can refuse a pointer freed twice or allocated
+/// elsewhere instead of corrupting the heap.
+static LIVE_RESULTS: LazyLock<Mutex<HashSet<usize>>> = LazyLock::new(Default::default);
+
+fn live_results() -> std::sync::MutexGuard<'static, HashSet<usize>> {
+    LIVE_RESULTS.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+/// `d` in whole nanoseconds, saturating at `u64::MAX`.
+fn nanos(d: std::time::Duration) -> u64 {
+    u64::try_from(d.as_nanos()).unwrap_or(u64::MAX)
+}
+
+// ============================================================================
+// Execution
+// ============================================================================
+
+/// Execute a shell script.
+///
+/// Each call creates a fresh shell instance, so state (variables, functions)
+/// does not persist between calls.
+///
+/// Returns a pointer to a `ConchResult` on success, or null on failure.
+/// On failure, call `conch_last_error()` to get the error message.
+/// The result must be freed with `conch_result_free()`.
+///
+/// # Safety
+/// - `executor` must be a valid pointer from `conch_executor_new*()`.
+/// - `script` must be a valid null-terminated C string.
+#[cfg(feature = "embedded-shell")]
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_execute(
+    executor: *mut ConchExecutor,
+    script: *const c_char,
+) -> *mut ConchResult {
+    if executor.is_null() {
+        set_last_error("executor is null");
+        return ptr::null_mut();
+    }
+
+    if script.is_null() {
+        set_last_error("script is null");
+        return ptr::null_mut();
+    }
+
+    let executor = unsafe { &*executor };
+
+    let script_str = match unsafe { CStr::from_ptr(script) }.to_str() {
+        Ok(s) => s,
+        Err(e) => {
+            set_last_error(&format!("invalid UTF-8 in script: {}", e));
+            return ptr::null_mut();
+        }
+    };
+
+    let limits = ResourceLimits::default();
+
+    // Create a tokio runtime to run the async executor
+    let rt = match tokio::runtime::Runtime::new() {
+        Ok(rt) => rt,
+        Err(e) => {
+            set_last_error(&format!("failed to create runtime: {}", e));
+            return ptr::null_mut();
+        }
+    };
+
+    match rt.block_on(execute_script_internal(
+        executor,
+        script_str,
+        &limits,
+        InstanceIo::Captured,
+        None,
+    )) {
+        Ok(exec_result) => result_to_conch_result(exec_result, None),
+        Err(e) => {
+            set_last_error(&format!("execution failed: {}", e));
+            ptr::null_mut()
+        }
+    }
+}
+
+/// Stub for when embedded-shell feature is disabled.
+#[cfg(not(feature = "embedded-shell"))]
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_execute(
+    _executor: *mut ConchExecutor,
+    _script: *const c_char,
+) -> *mut ConchResult {
+    set_last_error("embedded-shell feature not enabled");
+    ptr::null_mut()
+}
+
+/// Run `script` as `conch_execute_with_limits()` does, recording the limit
+/// a failed execution exceeded in `exceeded`.
+#[cfg(feature = "embedded-shell")]
+unsafe fn execute_with_limits(
+    executor: *mut ConchExecutor,
+    script: *const c_char,
+    limits: ResourceLimits,
+    exceeded: Option<&mut u32>,
+) -> *mut ConchResult {
+    if executor.is_null() {
+        set_last_error("executor is null");
+        return ptr::null_mut();
+    }
+
+    if script.is_null() {
+        set_last_error("script is null");
+        return ptr::null_mut();
+    }
+
+    let executor = unsafe { &*executor };
+
+    let script_str = match unsafe { CStr::from_ptr(script) }.to_str() {
+        Ok(s) => s,
+        Err(e) => {
+            set_last_error(&format!("invalid UTF-8 in script: {}", e));
+            return ptr::null_mut();
+        }
+    };
+
+    // Create a tokio runtime to run the async executor
+    let rt = match tokio::runtime::Runtime::new() {
+        Ok(rt) => rt,
+        Err(e) => {
+            set_last_error(&format!("failed to create runtime: {}", e));
+            return ptr::null_mut();
+        }
+    };
+
+    match rt.block_on(execute_script_internal(
+        executor,
+        script_str,
+        &limits,
+        InstanceIo::Captured,
+        None,
+    )) {
+        Ok(exec_result) => result_to_conch_result(exec_result, None),
+        Err(e) => execution_failed(e, exceeded),
+    }
+}
+
+/// Stub for when embedded-shell feature is disabled.
+#[cfg(not(feature = "embedded-shell"))]
+unsafe fn execute_with_limits(
+    _executor: *mut ConchExecutor,
+    _script: *const c_char,
+    _limits: ResourceLimits,
+    _exceeded: Option<&mut u32>,
+) -> *mut ConchResult {
+    set_last_error("embedded-shell feature not enabled");
+    ptr::null_mut()
+}
+
+/// Execute a shell script with custom resource limits.
+///
+/// Each call creates a fresh shell instance, so state (variables, functions)
+/// does not persist between calls.
+///
+/// Returns a pointer to a `ConchResult` on success, or null on failure.
+/// On failure, call `conch_last_error()` to get the error message.
+/// The result must be freed with `conch_result_free()`.
+///
+/// # Safety
+/// - `executor` must be a valid pointer from `conch_executor_new*()`.
+/// - `script` must be a valid null-terminated C string.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_execute_with_limits(
+    executor: *mut ConchExecutor,
+    script: *const c_char,
+    max_cpu_ms: u64,
+    max_memory_bytes: u64,
+    max_output_bytes: u64,
+    timeout_ms: u64,
+) -> *mut ConchResult {
+    let limits = ResourceLimits {
+        max_cpu_ms,
+        max_memory_bytes,
+        max_output_bytes,
+        timeout: std::time::Duration::from_millis(timeout_ms),
+        max_call_depth: 0,
+        max_fs_bytes: 0,
+        max_script_bytes: 0,
+        builtin_timeout_ms: 0,
+        max_regex_pattern_bytes: 0,
+        max_regex_compiled_bytes: 0,
+        max_regex_nesting: 0,
+    };
+    unsafe { execute_with_limits(executor, script, limits, None) }
+}
+
+/// Like `conch_execute_with_limits()`, with its limits in `limits`, which also
+/// reports the limit a failed execution exceeded.
+///
+/// # Safety
+/// - As for `conch_execute_with_limits()`.
+/// - `limits` must be a valid pointer to a `ConchLimits` of at least its
+///   `size`.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_execute_with_limits_v2(
+    executor: *mut ConchExecutor,
+    script: *const c_char,
+    limits: *mut ConchLimits,
+) -> *mut ConchResult {
+    let Some(resolved) = (unsafe { ConchLimits::resolve(limits) }) else {
+        return ptr::null_mut();
+    };
+    let exceeded = unsafe { &mut (*limits).exceeded };
+    unsafe { execute_with_limits(executor, script, resolved, Some(exceeded)) }
+}
+
+// ============================================================================
+// Interruption
+// ============================================================================
+
+/// Create a new interrupt handle for `conch_execute_interruptible()`.
+///
+/// # Safety
+/// - The returned pointer must be freed with `conch_interrupt_free()`.
+#[unsafe(no_mangle)]
+pub extern "C" fn conch_interrupt_new() -> *mut ConchInterrupt {
+    Box::into_raw(Box::new(ConchInterrupt::default()))
+}
+
+/// Request that the execution holding `interrupt` stops.
+///
+/// The script stops the next time it waits, and its INT trap, then its EXIT
+/// trap, run in the same shell before teardown; see
+/// `conch_interrupt_take_result()`. A script that does not wait, such as a
+/// busy loop, is stopped shortly after without them. The guest only observes
+/// the request at its epoch checks, so callers must keep calling
+/// `conch_executor_tick()` until the execution returns.
+///
+/// # Safety
+/// - `interrupt` must be a pointer from `conch_interrupt_new()`, or null.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_interrupt_trigger(interrupt: *mut ConchInterrupt) {
+    if !interrupt.is_null() {
+        unsafe { &*interrupt }.stop(SIGINT);
+    }
+}
+
+/// Stop the execution holding `interrupt` because a caller deadline passed.
+///
+/// Behaves like `conch_interrupt_trigger()`, except that the script's TERM
+/// trap runs instead of INT, as it does when the execution's own timeout
+/// elapses.
+///
+/// # Safety
+/// - `interrupt` must be a pointer from `conch_interrupt_new()`, or null.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_interrupt_expire(interrupt: *mut ConchInterrupt) {
+    if !interrupt.is_null() {
+        unsafe { &*interrupt }.stop(SIGTERM);
+    }
+}
+
+/// Take the output of the trap handlers run after the execution holding
+/// `interrupt` was stopped.
+///
+/// Returns null if the execution was not stopped, was stopped before it
+/// could run its handlers, or set no trap for the signal or EXIT. `ConchResult::traps` reports which handlers ran and
+/// `exit_code` is the status the stopped script was given (130 for INT, 143
+/// for TERM). The result must be freed with `conch_result_free()`.
+///
+/// # Safety
+/// - `interrupt` must be a pointer from `conch_interrupt_new()`, or null.
+/// - The execution using it must have returned.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_interrupt_take_result(
+    interrupt: *mut ConchInterrupt,
+) -> *mut ConchResult {
+    if interrupt.is_null() {
+        return ptr::null_mut();
+    }
+    let trapped = unsafe { &*interrupt }
+        .trapped
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .take();
+    match trapped {
+        Some(result) => result_to_conch_result(result, Some(unsafe { &*interrupt })),
+        None => ptr::null_mut(),
+    }
+}
+
+/// Free an interrupt handle.
+///
+/// # Safety
+/// - `interrupt` must be a pointer from `conch_interrupt_new()`, or null.
+/// - The execution using it must have returned.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_interrupt_free(interrupt: *mut ConchInterrupt) {
+    if !interrupt.is_null() {
+        unsafe { drop(Box::from_raw(interrupt)) };
+    }
+}
+
+/// Set the caller's ID for the execution holding `interrupt`.
+///
+/// The script, the commands it runs and its trap handlers see the ID as
+/// `$CONCH_EXECUTION_ID`, and the execution's log events are recorded in a
+/// `conch_execute` span with an `execution_id` field. Must be called before
+/// the execution starts; the ID can only be set once.
+///
+/// Returns 0 on success, -1 on error (check `conch_last_error()`).
+///
+/// # Safety
+/// - `interrupt` must be a pointer from `conch_interrupt_new()`.
+/// - `id` must be a valid null-terminated C string.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_interrupt_set_id(
+    interrupt: *mut ConchInterrupt,
+    id: *const c_char,
+) -> i32 {
+    if interrupt.is_null() {
+        set_last_error("interrupt is null");
+        return -1;
+    }
+    if id.is_null() {
+        set_last_error("id is null");
+        return -1;
+    }
+
+    let id_str = match unsafe { CStr::from_ptr(id) }.to_str() {
+        Ok(s) => s,
+        Err(e) => {
+            set_last_error(&format!("invalid UTF-8 in id: {}", e));
+            return -1;
+        }
+    };
+
+    if unsafe { &*interrupt }.id.set(id_str.to_string()).is_err() {
+        set_last_error("execution id already set");
+        return -1;
+    }
+    0
+}
+
+/// Pass the output of the execution holding `interrupt` to `callback` as the
+/// script writes it, stdout and stderr interleaved in the order they were
+/// written.
+///
+/// Each chunk is passed with its stream and its offset in that stream,
+/// before the output limit applies, so `callback` also sees output the
+/// result drops. Output of trap handlers run after the execution is stopped
+/// continues at the same offsets. Streamed stdout is not passed. The init
+/// script and registered functions are not part of the output. Must be
+/// called before the execution starts, and only once.
+///
+/// Returns 0 on success, -1 on error (check `conch_last_error()`).
+///
+/// # Safety
+/// - `interrupt` must be a pointer from `conch_interrupt_new()`.
+/// - `callback` must be callable from any thread with `user_data` until the
+///   execution returns.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_interrupt_set_output(
+    interrupt: *mut ConchInterrupt,
+    callback: Option<ConchOutputCallback>,
+    user_data: *mut c_void,
+) -> i32 {
+    if interrupt.is_null() {
+        set_last_error("interrupt is null");
+        return -1;
+    }
+    let Some(callback) = callback else {
+        set_last_error("callback is null");
+        return -1;
+    };
+
+    let handler = FfiOutputHandler {
+        callback,
+        user_data,
+        offsets: Default::default(),
+    };
+    if unsafe { &*interrupt }
+        .output
+        .set(Arc::new(handler))
+        .is_err()
+    {
+        set_last_error("output callback already set");
+        return -1;
+    }
+    0
+}
+
+/// Choose which output of the execution holding `interrupt` is kept when it
+/// writes more than the output limit: `CONCH_CAPTURE_HEAD`, the default,
+/// keeps the first bytes of each stream and `CONCH_CAPTURE_TAIL` the last,
+/// which is usually what explains a failure. Either way the result's total
+/// lengths count everything written. Must be called before the execution
+/// starts.
+///
+/// Returns 0 on success, -1 on error (check `conch_last_error()`).
+///
+/// # Safety
+/// `interrupt` must be a pointer from `conch_interrupt_new()`.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_interrupt_set_capture(
+    interrupt: *mut ConchInterrupt,
+    mode: u8,
+) -> i32 {
+    if interrupt.is_null() {
+        set_last_error("interrupt is null");
+        return -1;
+    }
+    if mode != CONCH_CAPTURE_HEAD && mode != CONCH_CAPTURE_TAIL {
+        set_last_error(&format!("unknown capture mode {mode}"));
+        return -1;
+    }
+    unsafe { &*interrupt }
+        .capture
+        .store(mode, Ordering::Release);
+    0
+}
+
+/// Compress the output of the execution holding `interrupt` before it is
+/// copied into the result, which cuts the copy and the memory held for
+/// large, repetitive output such as logs. Each stream of at least `min_len`
+/// bytes is compressed with `codec`, `CONCH_COMPRESS_GZIP` or
+/// `CONCH_COMPRESS_ZSTD`, unless that would not shrink it, and marked in the
+/// result's `compressed` mask; the result's total lengths still count the
+/// bytes written. `CONCH_COMPRESS_NONE`, the default, compresses nothing.
+/// Must be called before the execution starts.
+///
+/// Returns 0 on success, -1 on error (check `conch_last_error()`).
+///
+/// # Safety
+/// `interrupt` must be a pointer from `conch_interrupt_new()`.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_interrupt_set_compression(
+    interrupt: *mut ConchInterrupt,
+    codec: u8,
+    min_len: usize,
+) -> i32 {
+    if interrupt.is_null() {
+        set_last_error("interrupt is null");
+        return -1;
+    }
+    if !matches!(
+        codec,
+        CONCH_COMPRESS_NONE | CONCH_COMPRESS_GZIP | CONCH_COMPRESS_ZSTD
+    ) {
+        set_last_error(&format!("unknown compression codec {codec}"));
+        return -1;
+    }
+    let interrupt = unsafe { &*interrupt };
+    interrupt.compress_min.store(min_len, Ordering::Release);
+    interrupt.compression.store(codec, Ordering::Release);
+    0
+}
+
+/// Capture the value of the variable `name` when the execution holding
+/// `interrupt` finishes, after its EXIT trap, to be read with
+/// `conch_interrupt_var()`. Nothing is captured if the execution is stopped.
+/// Must be called before the execution starts.
+///
+/// Returns 0 on success, -1 on error (check `conch_last_error()`).
+///
+/// # Safety
+/// - `interrupt` must be a pointer from `conch_interrupt_new()`.
+/// - `name` must be a valid null-terminated C string.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_interrupt_capture_var(
+    interrupt: *mut ConchInterrupt,
+    name: *const c_char,
+) -> i32 {
+    if interrupt.is_null() || name.is_null() {
+        set_last_error("interrupt or name is null");
+        return -1;
+    }
+    let name = match unsafe { CStr::from_ptr(name) }.to_str() {
+        Ok(s) => s,
+        Err(e) => {
+            set_last_error(&format!("invalid UTF-8 in variable name: {}", e));
+            return -1;
+        }
+    };
+    if !is_var_name(name) {
+        set_last_error(&format!("invalid variable name {name:?}"));
+        return -1;
+    }
+
+    let mut vars = unsafe { &*interrupt }
+        .vars
+        .lock()
+        .unwrap_or_else(|e| e.into_inner());
+    if !vars.iter().any(|(n, _)| n == name) {
+        vars.push((name.to_string(), None));
+    }
+    0
+}
+
+/// Set the variable `name` to `value` in the execution holding `interrupt`,
+/// after the init script and registered functions and before the script.
+/// The variable is not exported. Must be called before the execution starts.
+///
+/// Returns 0 on success, -1 on error (check `conch_last_error()`).
+///
+/// # Safety
+/// - `interrupt` must be a pointer from `conch_interrupt_new()`.
+/// - `name` and `value` must be valid null-terminated C strings.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_interrupt_set_var(
+    interrupt: *mut ConchInterrupt,
+    name: *const c_char,
+    value: *const c_char,
+) -> i32 {
+    if interrupt.is_null() || name.is_null() || value.is_null() {
+        set_last_error("interrupt, name or value is null");
+        return -1;
+    }
+    let (name, value) = match (
+        unsafe { CStr::from_ptr(name) }.to_str(),
+        unsafe { CStr::from_ptr(value) }.to_str(),
+    ) {
+        (Ok(name), Ok(value)) => (name, value),
+        (Err(e), _) | (_, Err(e)) => {
+            set_last_error(&format!("invalid UTF-8 in variable: {}", e));
+            return -1;
+        }
+    };
+    if !is_var_name(name) {
+        set_last_error(&format!("invalid variable name {name:?}"));
+        return -1;
+    }
+
+    let mut assign = unsafe { &*interrupt }
+        .assign
+        .lock()
+        .unwrap_or_else(|e| e.into_inner());
+    match assign.iter_mut().find(|(n, _)| n == name) {
+        Some((_, v)) => *v = value.to_string(),
+        None => assign.push((name.to_string(), value.to_string())),
+    }
+    0
+}
+
+/// Whether `name` is a valid shell variable name.
+fn is_var_name(name: &str) -> bool {
+    name.starts_with(|c: char| c.is_ascii_alphabetic() || c == '_')
+        && name.chars().all(|c| c.is_ascii_alphanumeric() || c == '_')
+}
+
+/// Return the value captured for `name` by the execution holding
+/// `interrupt`, or null if it was unset, not captured, or the execution has
+/// not finished.
+///
+/// # Safety
+/// - `interrupt` must be a pointer from `conch_interrupt_new()`.
+/// - `name` must be a valid null-terminated C string.
+/// - The returned string is owned by `interrupt` and valid until it is freed.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_interrupt_var(
+    interrupt: *mut ConchInterrupt,
+    name: *const c_char,
+) -> *const c_char {
+    if interrupt.is_null() || name.is_null() {
+        return ptr::null();
+    }
+    let name = unsafe { CStr::from_ptr(name) };
+    let vars = unsafe { &*interrupt }
+        .vars
+        .lock()
+        .unwrap_or_else(|e| e.into_inner());
+    vars.iter()
+        .find(|(n, _)| n.as_bytes() == name.to_bytes())
+        .and_then(|(_, value)| value.as_ref())
+        .map_or(ptr::null(), |value| value.as_ptr())
+}
+
+/// Report how active the execution holding `interrupt` is.
+///
+/// Returns the number of calls between the script and the host so far:
+/// writing output, touching the filesystem, running commands. A caller can
+/// sample it to notice an execution that has stopped making progress without
+/// timing out. Returns 0 before the execution starts or if `interrupt` is
+/// null.
+///
+/// # Safety
+/// - `interrupt` must be a pointer from `conch_interrupt_new()`, or null.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_interrupt_activity(interrupt: *mut ConchInterrupt) -> u64 {
+    if interrupt.is_null() {
+        return 0;
+    }
+    unsafe { &*interrupt }.activity.load(Ordering::Relaxed)
+}
+
+/// Advance the executor's epoch by one tick.
+///
+/// Safe to call from any thread while executions are in flight; every
+/// execution on this executor re-checks its interrupt flag on the next tick.
+///
+/// # Safety
+/// - `executor` must be a valid pointer from `conch_executor_new*()`, or null.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_executor_tick(executor: *mut ConchExecutor) {
+    if !executor.is_null() {
+        unsafe { &*executor }.executor.increment_epoch();
+    }
+}
+
+/// Run `script` as `conch_execute_interruptible()` does, recording the limit
+/// a failed execution exceeded in `exceeded`.
+#[cfg(feature = "embedded-shell")]
+unsafe fn execute_interruptible(
+    executor: *mut ConchExecutor,
+    script: *const c_char,
+    limits: ResourceLimits,
+    exceeded: Option<&mut u32>,
+    interrupt: *mut ConchInterrupt,
+) -> *mut ConchResult {
+    if executor.is_null() {
+        set_last_error("executor is null");
+        return ptr::null_mut();
+    }
+
+    if script.is_null() {
+        set_last_error("script is null");
+        return ptr::null_mut();
+    }
+
+    if interrupt.is_null() {
+        set_last_error("interrupt is null");
+        return ptr::null_mut();
+    }
+
+    let executor = unsafe { &*executor };
+    let interrupt = unsafe { &*interrupt };
+
+    let script_str = match unsafe { CStr::from_ptr(script) }.to_str() {
+        Ok(s) => s,
+        Err(e) => {
+            set_last_error(&format!("invalid UTF-8 in script: {}", e));
+            return ptr::null_mut();
+        }
+    };
+
+    let rt = match tokio::runtime::Runtime::new() {
+        Ok(rt) => rt,
+        Err(e) => {
+            set_last_error(&format!("failed to create runtime: {}", e));
+            return ptr::null_mut();
+        }
+    };
+
+    match rt.block_on(execute_script_internal(
+        executor,
+        script_str,
+        &limits,
+        InstanceIo::Captured,
+        Some(interrupt),
+    )) {
+        Ok(exec_result) => result_to_conch_result(exec_result, Some(interrupt)),
+        Err(e) => execution_failed(e, exceeded),
+    }
+}
+
+/// Stub for when embedded-shell feature is disabled.
+#[cfg(not(feature = "embedded-shell"))]
+unsafe fn execute_interruptible(
+    _executor: *mut ConchExecutor,
+    _script: *const c_char,
+    _limits: ResourceLimits,
+    _exceeded: Option<&mut u32>,
+    _interrupt: *mut ConchInterrupt,
+) -> *mut ConchResult {
+    set_last_error("embedded-shell feature not enabled");
+    ptr::null_mut()
+}
+
+/// Execute a shell script that can be interrupted via `interrupt`.
+///
+/// Behaves like `conch_execute_with_limits()`, except that the execution stops
+/// once `conch_interrupt_trigger()` has been called on `interrupt` and the
+/// epoch has been advanced with `conch_executor_tick()`. An interrupted
+/// execution returns null with a "timeout exceeded" error; the output of any
+/// trap handlers it ran is available from `conch_interrupt_take_result()`.
+///
+/// # Safety
+/// - `executor` must be a valid pointer from `conch_executor_new*()`.
+/// - `script` must be a valid null-terminated C string.
+/// - `interrupt` must be a valid pointer from `conch_interrupt_new()` that
+///   outlives this call.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_execute_interruptible(
+    executor: *mut ConchExecutor,
+    script: *const c_char,
+    max_cpu_ms: u64,
+    max_memory_bytes: u64,
+    max_output_bytes: u64,
+    timeout_ms: u64,
+    interrupt: *mut ConchInterrupt,
+) -> *mut ConchResult {
+    let limits = ResourceLimits {
+        max_cpu_ms,
+        max_memory_bytes,
+        max_output_bytes,
+        timeout: std::time::Duration::from_millis(timeout_ms),
+        max_call_depth: 0,
+        max_fs_bytes: 0,
+        max_script_bytes: 0,
+        builtin_timeout_ms: 0,
+        max_regex_pattern_bytes: 0,
+        max_regex_compiled_bytes: 0,
+        max_regex_nesting: 0,
+    };
+    unsafe { execute_interruptible(executor, script, limits, None, interrupt) }
+}
+
+/// Like `conch_execute_interruptible()`, with its limits in `limits`, which also
+/// reports the limit a failed execution exceeded.
+///
+/// # Safety
+/// - As for `conch_execute_interruptible()`.
+/// - `limits` must be a valid pointer to a `ConchLimits` of at least its
+///   `size`.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_execute_interruptible_v2(
+    executor: *mut ConchExecutor,
+    script: *const c_char,
+    limits: *mut ConchLimits,
+    interrupt: *mut ConchInterrupt,
+) -> *mut ConchResult {
+    let Some(resolved) = (unsafe { ConchLimits::resolve(limits) }) else {
+        return ptr::null_mut();
+    };
+    let exceeded = unsafe { &mut (*limits).exceeded };
+    unsafe { execute_interruptible(executor, script, resolved, Some(exceeded), interrupt) }
+}
+
+// ============================================================================
+// Stdin
+// ============================================================================
+
+/// Run `script` as `conch_execute_with_stdin()` does, recording the limit
+/// a failed execution exceeded in `exceeded`.
+#[cfg(feature = "embedded-shell")]
+#[allow(clippy::too_many_arguments)]
+unsafe fn execute_with_stdin(
+    executor: *mut ConchExecutor,
+    script: *const c_char,
+    stdin: *const u8,
+    stdin_len: usize,
+    limits: ResourceLimits,
+    exceeded: Option<&mut u32>,
+    interrupt: *mut ConchInterrupt,
+) -> *mut ConchResult {
+    if executor.is_null() {
+        set_last_error("executor is null");
+        return ptr::null_mut();
+    }
+
+    if script.is_null() {
+        set_last_error("script is null");
+        return ptr::null_mut();
+    }
+
+    if stdin.is_null() && stdin_len != 0 {
+        set_last_error("stdin is null");
+        return ptr::null_mut();
+    }
+
+    let executor = unsafe { &*executor };
+
+    let script_str = match unsafe { CStr::from_ptr(script) }.to_str() {
+        Ok(s) => s,
+        Err(e) => {
+            set_last_error(&format!("invalid UTF-8 in script: {}", e));
+            return ptr::null_mut();
+        }
     };
 
-    // Ship the embedded coreutils (cat/head/ls/…) so the FFI runtime can spawn
-    // them out of the box, mirroring the `Shell` builder. No-op without the
-    // `embedded-coreutils` feature (registry stays None).
-    let registry: Option<crate::executor::ComponentRegistry> = None;
-    #[cfg(feature = "embedded-coreutils")]
-    let registry = crate::executor::with_embedded_coreutils(registry);
-
-    // Create a temporary shell instance
-    let mut instance = if let Some(registry) = registry {
-        executor
-            .create_instance_with_registry(limits, hybrid_ctx, None, Arc::new(registry), child_vfs)
-            .await?
+    let stdin_data = if stdin_len == 0 {
+        Vec::new()
     } else {
-        executor
-            .create_instance(limits, hybrid_ctx, None, child_vfs)
-            .await?
+        unsafe { std::slice::from_raw_parts(stdin, stdin_len) }.to_vec()
     };
 
-    // Execute the script
-    instance.execute(script, limits).await
+    let interrupt = unsafe { interrupt.as_ref() };
+
+    let rt = match tokio::runtime::Runtime::new() {
+        Ok(rt) => rt,
+        Err(e) => {
+            set_last_error(&format!("failed to create runtime: {}", e));
+            return ptr::null_mut();
+        }
+    };
+
+    match rt.block_on(execute_script_internal(
+        executor,
+        script_str,
+        &limits,
+        InstanceIo::Stdin(stdin_data),
+        interrupt,
+    )) {
+        Ok(exec_result) => result_to_conch_result(exec_result, interrupt),
+        Err(e) => execution_failed(e, exceeded),
+    }
 }
 
-/// Convert an ExecutionResult to a ConchResult pointer.
-fn result_to_conch_result(exec_result: crate::runtime::ExecutionResult) -> *mut ConchResult {
-    let stdout_len = exec_result.stdout.len();
-    let stderr_len = exec_result.stderr.len();
+/// Stub for when embedded-shell feature is disabled.
+#[cfg(not(feature = "embedded-shell"))]
+#[allow(clippy::too_many_arguments)]
+unsafe fn execute_with_stdin(
+    _executor: *mut ConchExecutor,
+    _script: *const c_char,
+    _stdin: *const u8,
+    _stdin_len: usize,
+    _limits: ResourceLimits,
+    _exceeded: Option<&mut u32>,
+    _interrupt: *mut ConchInterrupt,
+) -> *mut ConchResult {
+    set_last_error("embedded-shell feature not enabled");
+    ptr::null_mut()
+}
 
-    let stdout_data = if exec_result.stdout.is_empty() {
-        ptr::null_mut()
-    } else {
-        let mut stdout = exec_result.stdout;
-        stdout.push(0); // Add null terminator
-        let ptr = stdout.as_mut_ptr() as *mut c_char;
-        std::mem::forget(stdout);
-        ptr
+/// Execute a shell script with `stdin_len` bytes at `stdin` as its stdin.
+///
+/// Behaves like `conch_execute_with_limits()`. The stdin bytes are copied into
+/// the guest's input pipe before execution starts, so the caller's buffer is
+/// only borrowed for the duration of this call. `interrupt` may be null; if
+/// set it behaves as in `conch_execute_interruptible()`.
+///
+/// # Safety
+/// - `executor` must be a valid pointer from `conch_executor_new*()`.
+/// - `script` must be a valid null-terminated C string.
+/// - `stdin` must be a valid pointer to `stdin_len` bytes, or null if
+///   `stdin_len` is 0.
+/// - `interrupt` must be null or a valid pointer from `conch_interrupt_new()`
+///   that outlives this call.
+#[unsafe(no_mangle)]
+#[allow(clippy::too_many_arguments)]
+pub unsafe extern "C" fn conch_execute_with_stdin(
+    executor: *mut ConchExecutor,
+    script: *const c_char,
+    stdin: *const u8,
+    stdin_len: usize,
+    max_cpu_ms: u64,
+    max_memory_bytes: u64,
+    max_output_bytes: u64,
+    timeout_ms: u64,
+    interrupt: *mut ConchInterrupt,
+) -> *mut ConchResult {
+    let limits = ResourceLimits {
+        max_cpu_ms,
+        max_memory_bytes,
+        max_output_bytes,
+        timeout: std::time::Duration::from_millis(timeout_ms),
+        max_call_depth: 0,
+        max_fs_bytes: 0,
+        max_script_bytes: 0,
+        builtin_timeout_ms: 0,
+        max_regex_pattern_bytes: 0,
+        max_regex_compiled_bytes: 0,
+        max_regex_nesting: 0,
     };
+    unsafe { execute_with_stdin(executor, script, stdin, stdin_len, limits, None, interrupt) }
+}
 
-    let stderr_data = if exec_result.stderr.is_empty() {
-        ptr::null_mut()
-    } else {
-        let mut stderr = exec_result.stderr;
-        stderr.push(0); // Add null terminator
-        let ptr = stderr.as_mut_ptr() as *mut c_char;
-        std::mem::forget(stderr);
-        ptr
+/// Like `conch_execute_with_stdin()`, with its limits in `limits`, which also
+/// reports the limit a failed execution exceeded.
+///
+/// # Safety
+/// - As for `conch_execute_with_stdin()`.
+/// - `limits` must be a valid pointer to a `ConchLimits` of at least its
+///   `size`.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_execute_with_stdin_v2(
+    executor: *mut ConchExecutor,
+    script: *const c_char,
+    stdin: *const u8,
+    stdin_len: usize,
+    limits: *mut ConchLimits,
+    interrupt: *mut ConchInterrupt,
+) -> *mut ConchResult {
+    let Some(resolved) = (unsafe { ConchLimits::resolve(limits) }) else {
+        return ptr::null_mut();
     };
-
-    Box::into_raw(Box::new(ConchResult {
-        exit_code: exec_result.exit_code,
-        stdout_data,
-        stdout_len,
-        stderr_data,
-        stderr_len,
-        truncated: if exec_result.truncated { 1 } else { 0 },
-    }))
+    let exceeded = unsafe { &mut (*limits).exceeded };
+    unsafe {
+        execute_with_stdin(
+            executor,
+            script,
+            stdin,
+            stdin_len,
+            resolved,
+            Some(exceeded),
+            interrupt,
+        )
+    }
 }
 
 // ============================================================================
-// Execution
+// Terminal emulation
 // ============================================================================
 
-/// Execute a shell script.
+/// Create a terminal handle of `rows` by `cols` characters for
+/// `conch_execute_with_terminal()`.
 ///
-/// Each call creates a fresh shell instance, so state (variables, functions)
-/// does not persist between calls.
+/// # Safety
+/// - The returned pointer must be freed with `conch_terminal_free()`.
+#[unsafe(no_mangle)]
+pub extern "C" fn conch_terminal_new(rows: u16, cols: u16) -> *mut ConchTerminal {
+    Box::into_raw(Box::new(ConchTerminal {
+        size: Arc::new(TerminalSize::new(rows, cols)),
+    }))
+}
+
+/// Resize a terminal, including while an execution is using it.
 ///
-/// Returns a pointer to a `ConchResult` on success, or null on failure.
-/// On failure, call `conch_last_error()` to get the error message.
-/// The result must be freed with `conch_result_free()`.
+/// A running shell cannot be notified of the change; commands it starts
+/// afterwards see the new size in `COLUMNS` and `LINES`.
 ///
 /// # Safety
-/// - `executor` must be a valid pointer from `conch_executor_new*()`.
-/// - `script` must be a valid null-terminated C string.
-#[cfg(feature = "embedded-shell")]
+/// - `terminal` must be a pointer from `conch_terminal_new()`, or null.
 #[unsafe(no_mangle)]
-pub unsafe extern "C" fn conch_execute(
+pub unsafe extern "C" fn conch_terminal_resize(terminal: *mut ConchTerminal, rows: u16, cols: u16) {
+    if !terminal.is_null() {
+        unsafe { &*terminal }.size.resize(rows, cols);
+    }
+}
+
+/// Free a terminal handle.
+///
+/// # Safety
+/// - `terminal` must be a pointer from `conch_terminal_new()`, or null.
+/// - The execution using it must have returned.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_terminal_free(terminal: *mut ConchTerminal) {
+    if !terminal.is_null() {
+        unsafe { drop(Box::from_raw(terminal)) };
+    }
+}
+
+/// Run `script` as `conch_execute_with_terminal()` does, recording the limit
+/// a failed execution exceeded in `exceeded`.
+#[cfg(feature = "embedded-shell")]
+#[allow(clippy::too_many_arguments)]
+unsafe fn execute_with_terminal(
     executor: *mut ConchExecutor,
     script: *const c_char,
+    stdin: *const u8,
+    stdin_len: usize,
+    terminal: *mut ConchTerminal,
+    limits: ResourceLimits,
+    exceeded: Option<&mut u32>,
+    interrupt: *mut ConchInterrupt,
 ) -> *mut ConchResult {
     if executor.is_null() {
         set_last_error("executor is null");
@@ -314,6 +3510,16 @@ pub unsafe extern "C" fn conch_execute(
         return ptr::null_mut();
     }
 
+    if stdin.is_null() && stdin_len != 0 {
+        set_last_error("stdin is null");
+        return ptr::null_mut();
+    }
+
+    if terminal.is_null() {
+        set_last_error("terminal is null");
+        return ptr::null_mut();
+    }
+
     let executor = unsafe { &*executor };
 
     let script_str = match unsafe { CStr::from_ptr(script) }.to_str() {
@@ -324,9 +3530,14 @@ pub unsafe extern "C" fn conch_execute(
         }
     };
 
-    let limits = ResourceLimits::default();
+    let stdin_data = if stdin_len == 0 {
+        Vec::new()
+    } else {
+        unsafe { std::slice::from_raw_parts(stdin, stdin_len) }.to_vec()
+    };
+
+    let interrupt = unsafe { interrupt.as_ref() };
 
-    // Create a tokio runtime to run the async executor
     let rt = match tokio::runtime::Runtime::new() {
         Ok(rt) => rt,
         Err(e) => {
@@ -335,51 +3546,212 @@ pub unsafe extern "C" fn conch_execute(
         }
     };
 
+    let io = InstanceIo::Terminal {
+        stdin: stdin_data,
+        size: unsafe { &*terminal }.size.clone(),
+    };
     match rt.block_on(execute_script_internal(
-        &executor.executor,
-        script_str,
-        &limits,
+        executor, script_str, &limits, io, interrupt,
     )) {
-        Ok(exec_result) => result_to_conch_result(exec_result),
-        Err(e) => {
-            set_last_error(&format!("execution failed: {}", e));
-            ptr::null_mut()
-        }
+        Ok(exec_result) => result_to_conch_result(exec_result, interrupt),
+        Err(e) => execution_failed(e, exceeded),
     }
 }
 
 /// Stub for when embedded-shell feature is disabled.
 #[cfg(not(feature = "embedded-shell"))]
-#[unsafe(no_mangle)]
-pub unsafe extern "C" fn conch_execute(
+#[allow(clippy::too_many_arguments)]
+unsafe fn execute_with_terminal(
     _executor: *mut ConchExecutor,
     _script: *const c_char,
+    _stdin: *const u8,
+    _stdin_len: usize,
+    _terminal: *mut ConchTerminal,
+    _limits: ResourceLimits,
+    _exceeded: Option<&mut u32>,
+    _interrupt: *mut ConchInterrupt,
 ) -> *mut ConchResult {
     set_last_error("embedded-shell feature not enabled");
     ptr::null_mut()
 }
 
-/// Execute a shell script with custom resource limits.
+/// Execute a shell script as if attached to `terminal`.
 ///
-/// Each call creates a fresh shell instance, so state (variables, functions)
-/// does not persist between calls.
-///
-/// Returns a pointer to a `ConchResult` on success, or null on failure.
-/// On failure, call `conch_last_error()` to get the error message.
-/// The result must be freed with `conch_result_free()`.
+/// Behaves like `conch_execute_with_stdin()`, except that stdout and stderr
+/// report themselves as a terminal, so `test -t 1` succeeds and programs
+/// that check `isatty` produce their interactive output, and the shell's
+/// environment has `TERM`, `COLUMNS` and `LINES` set. Output is still
+/// captured into the result.
 ///
 /// # Safety
 /// - `executor` must be a valid pointer from `conch_executor_new*()`.
 /// - `script` must be a valid null-terminated C string.
-#[cfg(feature = "embedded-shell")]
+/// - `stdin` must be a valid pointer to `stdin_len` bytes, or null if
+///   `stdin_len` is 0.
+/// - `terminal` must be a valid pointer from `conch_terminal_new()` that
+///   outlives this call.
+/// - `interrupt` must be null or a valid pointer from `conch_interrupt_new()`
+///   that outlives this call.
 #[unsafe(no_mangle)]
-pub unsafe extern "C" fn conch_execute_with_limits(
+#[allow(clippy::too_many_arguments)]
+pub unsafe extern "C" fn conch_execute_with_terminal(
     executor: *mut ConchExecutor,
     script: *const c_char,
+    stdin: *const u8,
+    stdin_len: usize,
+    terminal: *mut ConchTerminal,
     max_cpu_ms: u64,
     max_memory_bytes: u64,
     max_output_bytes: u64,
     timeout_ms: u64,
+    interrupt: *mut ConchInterrupt,
+) -> *mut ConchResult {
+    let limits = ResourceLimits {
+        max_cpu_ms,
+        max_memory_bytes,
+        max_output_bytes,
+        timeout: std::time::Duration::from_millis(timeout_ms),
+        max_call_depth: 0,
+        max_fs_bytes: 0,
+        max_script_bytes: 0,
+        builtin_timeout_ms: 0,
+        max_regex_pattern_bytes: 0,
+        max_regex_compiled_bytes: 0,
+        max_regex_nesting: 0,
+    };
+    unsafe {
+        execute_with_terminal(
+            executor, script, stdin, stdin_len, terminal, limits, None, interrupt,
+        )
+    }
+}
+
+/// Like `conch_execute_with_terminal()`, with its limits in `limits`, which also
+/// reports the limit a failed execution exceeded.
+///
+/// # Safety
+/// - As for `conch_execute_with_terminal()`.
+/// - `limits` must be a valid pointer to a `ConchLimits` of at least its
+///   `size`.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_execute_with_terminal_v2(
+    executor: *mut ConchExecutor,
+    script: *const c_char,
+    stdin: *const u8,
+    stdin_len: usize,
+    terminal: *mut ConchTerminal,
+    limits: *mut ConchLimits,
+    interrupt: *mut ConchInterrupt,
+) -> *mut ConchResult {
+    let Some(resolved) = (unsafe { ConchLimits::resolve(limits) }) else {
+        return ptr::null_mut();
+    };
+    let exceeded = unsafe { &mut (*limits).exceeded };
+    unsafe {
+        execute_with_terminal(
+            executor,
+            script,
+            stdin,
+            stdin_len,
+            terminal,
+            resolved,
+            Some(exceeded),
+            interrupt,
+        )
+    }
+}
+
+// ============================================================================
+// Streaming execution
+// ============================================================================
+
+/// Size of the buffers between the stream callbacks and the guest. A full
+/// buffer blocks the writer, which is what gives streaming its backpressure.
+#[cfg(feature = "embedded-shell")]
+const STREAM_BUFFER_SIZE: usize = 64 * 1024;
+
+/// Callback supplying a streamed script's stdin.
+///
+/// Fills up to `cap` bytes at `buf` and returns how many it wrote, blocking
+/// until input is available. Returns 0 at end of input and a negative value
+/// on error, which also ends the input.
+pub type ConchReadCallback =
+    unsafe extern "C" fn(user_data: *mut c_void, buf: *mut u8, cap: usize) -> isize;
+
+/// Callback receiving a streamed script's stdout as it is written.
+///
+/// May block to slow the script down. Returns 0 on success; any other value
+/// closes stdout, so the script's further writes fail.
+pub type ConchWriteCallback =
+    unsafe extern "C" fn(user_data: *mut c_void, data: *const u8, len: usize) -> i32;
+
+/// The stream callbacks and their shared `user_data`.
+#[cfg(feature = "embedded-shell")]
+#[derive(Clone, Copy)]
+struct FfiStreams {
+    read: ConchReadCallback,
+    write: ConchWriteCallback,
+    user_data: *mut c_void,
+}
+
+// SAFETY: `conch_execute_streaming()` requires the callbacks to be callable
+// from any thread with their `user_data`.
+#[cfg(feature = "embedded-shell")]
+unsafe impl Send for FfiStreams {}
+
+#[cfg(feature = "embedded-shell")]
+impl FfiStreams {
+    /// Copy the read callback's data into `stdin` until end of input or
+    /// until the guest side is dropped.
+    fn pump_stdin(self, mut stdin: tokio::io::DuplexStream) {
+        use tokio::io::AsyncWriteExt;
+
+        let mut buf = vec![0u8; STREAM_BUFFER_SIZE];
+        loop {
+            let n = unsafe { (self.read)(self.user_data, buf.as_mut_ptr(), buf.len()) };
+            if n <= 0 {
+                break;
+            }
+            let n = (n as usize).min(buf.len());
+            if futures::executor::block_on(stdin.write_all(&buf[..n])).is_err() {
+                break;
+            }
+        }
+        // Dropping `stdin` signals EOF to the guest.
+    }
+
+    /// Hand everything the guest writes to `stdout` to the write callback,
+    /// until the guest side is dropped or the callback refuses more.
+    fn pump_stdout(self, mut stdout: tokio::io::DuplexStream) {
+        use tokio::io::AsyncReadExt;
+
+        let mut buf = vec![0u8; STREAM_BUFFER_SIZE];
+        loop {
+            let n = match futures::executor::block_on(stdout.read(&mut buf)) {
+                Ok(0) | Err(_) => break,
+                Ok(n) => n,
+            };
+            if unsafe { (self.write)(self.user_data, buf.as_ptr(), n) } != 0 {
+                break;
+            }
+        }
+        // Dropping `stdout` makes the guest's further writes fail.
+    }
+}
+
+/// Run `script` as `conch_execute_streaming()` does, recording the limit
+/// a failed execution exceeded in `exceeded`.
+#[cfg(feature = "embedded-shell")]
+#[allow(clippy::too_many_arguments)]
+unsafe fn execute_streaming(
+    executor: *mut ConchExecutor,
+    script: *const c_char,
+    read: Option<ConchReadCallback>,
+    write: Option<ConchWriteCallback>,
+    user_data: *mut c_void,
+    limits: ResourceLimits,
+    exceeded: Option<&mut u32>,
+    interrupt: *mut ConchInterrupt,
 ) -> *mut ConchResult {
     if executor.is_null() {
         set_last_error("executor is null");
@@ -391,6 +3763,11 @@ pub unsafe extern "C" fn conch_execute_with_limits(
         return ptr::null_mut();
     }
 
+    let (Some(read), Some(write)) = (read, write) else {
+        set_last_error("stream callback is null");
+        return ptr::null_mut();
+    };
+
     let executor = unsafe { &*executor };
 
     let script_str = match unsafe { CStr::from_ptr(script) }.to_str() {
@@ -401,14 +3778,8 @@ pub unsafe extern "C" fn conch_execute_with_limits(
         }
     };
 
-    let limits = ResourceLimits {
-        max_cpu_ms,
-        max_memory_bytes,
-        max_output_bytes,
-        timeout: std::time::Duration::from_millis(timeout_ms),
-    };
+    let interrupt = unsafe { interrupt.as_ref() };
 
-    // Create a tokio runtime to run the async executor
     let rt = match tokio::runtime::Runtime::new() {
         Ok(rt) => rt,
         Err(e) => {
@@ -417,47 +3788,229 @@ pub unsafe extern "C" fn conch_execute_with_limits(
         }
     };
 
-    match rt.block_on(execute_script_internal(
-        &executor.executor,
-        script_str,
-        &limits,
-    )) {
-        Ok(exec_result) => result_to_conch_result(exec_result),
-        Err(e) => {
-            set_last_error(&format!("execution failed: {}", e));
-            ptr::null_mut()
-        }
+    let streams = FfiStreams {
+        read,
+        write,
+        user_data,
+    };
+    let (stdin_host, stdin_guest) = tokio::io::duplex(STREAM_BUFFER_SIZE);
+    let (stdout_guest, stdout_host) = tokio::io::duplex(STREAM_BUFFER_SIZE);
+
+    // The stdin pump is detached: it may be blocked in `read` for input the
+    // script will never consume.
+    std::thread::spawn(move || streams.pump_stdin(stdin_host));
+    let stdout_pump = std::thread::spawn(move || streams.pump_stdout(stdout_host));
+
+    let io = InstanceIo::Streaming(StreamingIo {
+        stdin: Box::new(stdin_guest),
+        stdout: Box::new(stdout_guest),
+    });
+    // The instance, and with it the guest's end of stdout, is dropped when
+    // this returns, letting the stdout pump drain and finish.
+    let result = rt.block_on(execute_script_internal(
+        executor, script_str, &limits, io, interrupt,
+    ));
+    let _ = stdout_pump.join();
+
+    match result {
+        Ok(exec_result) => result_to_conch_result(exec_result, interrupt),
+        Err(e) => execution_failed(e, exceeded),
     }
 }
 
 /// Stub for when embedded-shell feature is disabled.
 #[cfg(not(feature = "embedded-shell"))]
-#[unsafe(no_mangle)]
-pub unsafe extern "C" fn conch_execute_with_limits(
+#[allow(clippy::too_many_arguments)]
+unsafe fn execute_streaming(
     _executor: *mut ConchExecutor,
     _script: *const c_char,
-    _max_cpu_ms: u64,
-    _max_memory_bytes: u64,
-    _max_output_bytes: u64,
-    _timeout_ms: u64,
+    _read: Option<ConchReadCallback>,
+    _write: Option<ConchWriteCallback>,
+    _user_data: *mut c_void,
+    _limits: ResourceLimits,
+    _exceeded: Option<&mut u32>,
+    _interrupt: *mut ConchInterrupt,
 ) -> *mut ConchResult {
     set_last_error("embedded-shell feature not enabled");
     ptr::null_mut()
 }
 
+/// Execute a shell script with its stdin and stdout streamed through
+/// callbacks.
+///
+/// `read` is called for stdin as the script consumes it and `write` with
+/// stdout as the script produces it, each on a thread of its own, so a
+/// script can process input incrementally. A slow `write` blocks the script
+/// once the internal buffer fills. `max_output_bytes` applies to stderr
+/// only; the returned result's stdout is always empty.
+///
+/// Every call to `write` has returned before this function returns. `read`
+/// may still be running, or be called once more, afterwards; it should
+/// return 0 once the caller is no longer interested in the execution.
+/// `interrupt` may be null; if set it behaves as in
+/// `conch_execute_interruptible()`.
+///
+/// # Safety
+/// - `executor` must be a valid pointer from `conch_executor_new*()`.
+/// - `script` must be a valid null-terminated C string.
+/// - `read` and `write` must be callable from any thread with `user_data`,
+///   and `read` must remain callable after this function returns as
+///   described above.
+/// - `interrupt` must be null or a valid pointer from `conch_interrupt_new()`
+///   that outlives this call.
+#[unsafe(no_mangle)]
+#[allow(clippy::too_many_arguments)]
+pub unsafe extern "C" fn conch_execute_streaming(
+    executor: *mut ConchExecutor,
+    script: *const c_char,
+    read: Option<ConchReadCallback>,
+    write: Option<ConchWriteCallback>,
+    user_data: *mut c_void,
+    max_cpu_ms: u64,
+    max_memory_bytes: u64,
+    max_output_bytes: u64,
+    timeout_ms: u64,
+    interrupt: *mut ConchInterrupt,
+) -> *mut ConchResult {
+    let limits = ResourceLimits {
+        max_cpu_ms,
+        max_memory_bytes,
+        max_output_bytes,
+        timeout: std::time::Duration::from_millis(timeout_ms),
+        max_call_depth: 0,
+        max_fs_bytes: 0,
+        max_script_bytes: 0,
+        builtin_timeout_ms: 0,
+        max_regex_pattern_bytes: 0,
+        max_regex_compiled_bytes: 0,
+        max_regex_nesting: 0,
+    };
+    unsafe {
+        execute_streaming(
+            executor, script, read, write, user_data, limits, None, interrupt,
+        )
+    }
+}
+
+/// Like `conch_execute_streaming()`, with its limits in `limits`, which also
+/// reports the limit a failed execution exceeded.
+///
+/// # Safety
+/// - As for `conch_execute_streaming()`.
+/// - `limits` must be a valid pointer to a `ConchLimits` of at least its
+///   `size`.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_execute_streaming_v2(
+    executor: *mut ConchExecutor,
+    script: *const c_char,
+    read: Option<ConchReadCallback>,
+    write: Option<ConchWriteCallback>,
+    user_data: *mut c_void,
+    limits: *mut ConchLimits,
+    interrupt: *mut ConchInterrupt,
+) -> *mut ConchResult {
+    let Some(resolved) = (unsafe { ConchLimits::resolve(limits) }) else {
+        return ptr::null_mut();
+    };
+    let exceeded = unsafe { &mut (*limits).exceeded };
+    unsafe {
+        execute_streaming(
+            executor,
+            script,
+            read,
+            write,
+            user_data,
+            resolved,
+            Some(exceeded),
+            interrupt,
+        )
+    }
+}
+
 // ============================================================================
 // Result handling
 // ============================================================================
 
+/// Layout of [`ConchResult`] reported by `conch_result_layout()`: its size,
+/// then the offset of each field in declaration order, then the size of
+/// the [`ConchDiagnostic`]s it points to.
+const RESULT_LAYOUT: [usize; 19] = [
+    std::mem::size_of::<ConchResult>(),
+    std::mem::offset_of!(ConchResult, exit_code),
+    std::mem::offset_of!(ConchResult, stdout_data),
+    std::mem::offset_of!(ConchResult, stdout_len),
+    std::mem::offset_of!(ConchResult, stderr_data),
+    std::mem::offset_of!(ConchResult, stderr_len),
+    std::mem::offset_of!(ConchResult, truncated),
+    std::mem::offset_of!(ConchResult, traps),
+    std::mem::offset_of!(ConchResult, compressed),
+    std::mem::offset_of!(ConchResult, stdout_total_len),
+    std::mem::offset_of!(ConchResult, stderr_total_len),
+    std::mem::offset_of!(ConchResult, compile_ns),
+    std::mem::offset_of!(ConchResult, instantiate_ns),
+    std::mem::offset_of!(ConchResult, execute_ns),
+    std::mem::offset_of!(ConchResult, marshal_ns),
+    std::mem::offset_of!(ConchResult, diagnostics),
+    std::mem::offset_of!(ConchResult, diagnostics_len),
+    std::mem::offset_of!(ConchResult, exceeded),
+    std::mem::size_of::<ConchDiagnostic>(),
+];
+
+/// Describe the memory layout of `ConchResult`, so bindings that mirror the
+/// struct can check their copy when they load the library.
+///
+/// Writes the size of `ConchResult`, the byte offset of each of its fields
+/// in declaration order and the size of `ConchDiagnostic` to `out`,
+/// stopping after `len` entries.
+/// Returns the number of entries in the full layout, which is larger than
+/// `len` if it did not fit.
+///
+/// # Safety
+/// - `out` must be valid for writing `len` `usize`s, or null if `len` is 0.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_result_layout(out: *mut usize, len: usize) -> usize {
+    let n = len.min(RESULT_LAYOUT.len());
+    if n > 0 && !out.is_null() {
+        unsafe { ptr::copy_nonoverlapping(RESULT_LAYOUT.as_ptr(), out, n) };
+    }
+    RESULT_LAYOUT.len()
+}
+
 /// Free a `ConchResult` returned by `conch_execute*()`.
 ///
+/// A pointer that is not a live result, because it was already freed or
+/// never came from this library, is left alone and sets the last error; use
+/// `conch_result_free_checked()` to detect that.
+///
 /// # Safety
 /// - `result` must be a pointer returned by `conch_execute*()`, or null.
 /// - The pointer must not be used after this call.
 #[unsafe(no_mangle)]
 pub unsafe extern "C" fn conch_result_free(result: *mut ConchResult) {
+    unsafe { conch_result_free_checked(result) };
+}
+
+/// Free a `ConchResult` returned by `conch_execute*()`, like
+/// `conch_result_free()`.
+///
+/// Returns 0 on success or if `result` is null, or -1 without freeing
+/// anything if `result` is not a live result: freed already, or not
+/// allocated by this library. The last error says which pointer.
+///
+/// # Safety
+/// - `result` must be null or any pointer; only live results are
+///   dereferenced.
+/// - A freed pointer must not be used after this call.
+#[unsafe(no_mangle)]
+pub unsafe extern "C" fn conch_result_free_checked(result: *mut ConchResult) -> i32 {
     if result.is_null() {
-        return;
+        return 0;
+    }
+    if !live_results().remove(&(result as usize)) {
+        set_last_error(&format!(
+            "{result:p} is not a live ConchResult: freed already, or not from conch_execute*()"
+        ));
+        return -1;
     }
 
     let result = unsafe { Box::from_raw(result) };
@@ -484,7 +4037,22 @@ pub unsafe extern "C" fn conch_result_free(result: *mut ConchResult) {
         }
     }
 
+    if !result.diagnostics.is_null() {
+        let diagnostics = unsafe {
+            Box::from_raw(ptr::slice_from_raw_parts_mut(
+                result.diagnostics,
+                result.diagnostics_len,
+            ))
+        };
+        for d in diagnostics.iter() {
+            for s in [d.category, d.command, d.message] {
+                drop(unsafe { CString::from_raw(s) });
+            }
+        }
+    }
+
     // Box will be dropped here, freeing the ConchResult struct
+    0
 }
 
 // ============================================================================
@@ -519,3 +4087,163 @@ pub unsafe extern "C" fn conch_embedded_component_bytes(len: *mut usize) -> *con
     }
     ptr::null()
 }
+
+// ============================================================================
+// Error-buffer variants
+// ============================================================================
+
+error_buffer_variants! {
+    conch_executor_new_err => conch_executor_new(path: *const c_char) -> *mut ConchExecutor;
+    conch_executor_new_from_bytes_err => conch_executor_new_from_bytes(
+        bytes: *const u8, len: usize
+    ) -> *mut ConchExecutor;
+    conch_executor_new_embedded_err => conch_executor_new_embedded() -> *mut ConchExecutor;
+    conch_executor_define_function_err => conch_executor_define_function(
+        executor: *mut ConchExecutor, name: *const c_char, body: *const c_char
+    ) -> i32;
+    conch_executor_set_init_script_err => conch_executor_set_init_script(
+        executor: *mut ConchExecutor, script: *const c_char
+    ) -> i32;
+    conch_executor_add_file_err => conch_executor_add_file(
+        executor: *mut ConchExecutor, path: *const c_char, data: *const u8, len: usize
+    ) -> i32;
+    conch_executor_set_tmp_err => conch_executor_set_tmp(
+        executor: *mut ConchExecutor, max_bytes: u64, retain_dir: *const c_char
+    ) -> i32;
+    conch_executor_set_labels_err => conch_executor_set_labels(
+        executor: *mut ConchExecutor, labels: *const c_char
+    ) -> i32;
+    conch_executor_set_allowed_commands_err => conch_executor_set_allowed_commands(
+        executor: *mut ConchExecutor, names: *const *const c_char, len: usize
+    ) -> i32;
+    conch_executor_set_command_handler_err => conch_executor_set_command_handler(
+        executor: *mut ConchExecutor,
+        callback: Option<ConchCommandCallback>,
+        user_data: *mut c_void
+    ) -> i32;
+    conch_executor_set_prompt_handler_err => conch_executor_set_prompt_handler(
+        executor: *mut ConchExecutor,
+        callback: Option<ConchPromptCallback>,
+        user_data: *mut c_void
+    ) -> i32;
+    conch_executor_set_connect_handler_err => conch_executor_set_connect_handler(
+        executor: *mut ConchExecutor,
+        callback: Option<ConchConnectCallback>,
+        user_data: *mut c_void
+    ) -> i32;
+    conch_stats_err => conch_stats(out: *mut ConchStats) -> i32;
+    conch_parse_script_err => conch_parse_script(script: *const c_char) -> *mut c_char;
+    conch_interrupt_set_id_err => conch_interrupt_set_id(
+        interrupt: *mut ConchInterrupt, id: *const c_char
+    ) -> i32;
+    conch_interrupt_set_output_err => conch_interrupt_set_output(
+        interrupt: *mut ConchInterrupt,
+        callback: Option<ConchOutputCallback>,
+        user_data: *mut c_void
+    ) -> i32;
+    conch_interrupt_set_capture_err => conch_interrupt_set_capture(
+        interrupt: *mut ConchInterrupt, mode: u8
+    ) -> i32;
+    conch_interrupt_set_compression_err => conch_interrupt_set_compression(
+        interrupt: *mut ConchInterrupt, codec: u8, min_len: usize
+    ) -> i32;
+    conch_interrupt_capture_var_err => conch_interrupt_capture_var(
+        interrupt: *mut ConchInterrupt, name: *const c_char
+    ) -> i32;
+    conch_interrupt_set_var_err => conch_interrupt_set_var(
+        interrupt: *mut ConchInterrupt, name: *const c_char, value: *const c_char
+    ) -> i32;
+    conch_execute_err => conch_execute(
+        executor: *mut ConchExecutor, script: *const c_char
+    ) -> *mut ConchResult;
+    conch_execute_with_limits_err => conch_execute_with_limits(
+        executor: *mut ConchExecutor,
+        script: *const c_char,
+        max_cpu_ms: u64,
+        max_memory_bytes: u64,
+        max_output_bytes: u64,
+        timeout_ms: u64
+    ) -> *mut ConchResult;
+    conch_execute_interruptible_err => conch_execute_interruptible(
+        executor: *mut ConchExecutor,
+        script: *const c_char,
+        max_cpu_ms: u64,
+        max_memory_bytes: u64,
+        max_output_bytes: u64,
+        timeout_ms: u64,
+        interrupt: *mut ConchInterrupt
+    ) -> *mut ConchResult;
+    conch_execute_with_stdin_err => conch_execute_with_stdin(
+        executor: *mut ConchExecutor,
+        script: *const c_char,
+        stdin: *const u8,
+        stdin_len: usize,
+        max_cpu_ms: u64,
+        max_memory_bytes: u64,
+        max_output_bytes: u64,
+        timeout_ms: u64,
+        interrupt: *mut ConchInterrupt
+    ) -> *mut ConchResult;
+    conch_execute_with_terminal_err => conch_execute_with_terminal(
+        executor: *mut ConchExecutor,
+        script: *const c_char,
+        stdin: *const u8,
+        stdin_len: usize,
+        terminal: *mut ConchTerminal,
+        max_cpu_ms: u64,
+        max_memory_bytes: u64,
+        max_output_bytes: u64,
+        timeout_ms: u64,
+        interrupt: *mut ConchInterrupt
+    ) -> *mut ConchResult;
+    conch_execute_streaming_err => conch_execute_streaming(
+        executor: *mut ConchExecutor,
+        script: *const c_char,
+        read: Option<ConchReadCallback>,
+        write: Option<ConchWriteCallback>,
+        user_data: *mut c_void,
+        max_cpu_ms: u64,
+        max_memory_bytes: u64,
+        max_output_bytes: u64,
+        timeout_ms: u64,
+        interrupt: *mut ConchInterrupt
+    ) -> *mut ConchResult;
+    conch_execute_with_limits_v2_err => conch_execute_with_limits_v2(
+        executor: *mut ConchExecutor,
+        script: *const c_char,
+        limits: *mut ConchLimits
+    ) -> *mut ConchResult;
+    conch_execute_interruptible_v2_err => conch_execute_interruptible_v2(
+        executor: *mut ConchExecutor,
+        script: *const c_char,
+        limits: *mut ConchLimits,
+        interrupt: *mut ConchInterrupt
+    ) -> *mut ConchResult;
+    conch_execute_with_stdin_v2_err => conch_execute_with_stdin_v2(
+        executor: *mut ConchExecutor,
+        script: *const c_char,
+        stdin: *const u8,
+        stdin_len: usize,
+        limits: *mut ConchLimits,
+        interrupt: *mut ConchInterrupt
+    ) -> *mut ConchResult;
+    conch_execute_with_terminal_v2_err => conch_execute_with_terminal_v2(
+        executor: *mut ConchExecutor,
+        script: *const c_char,
+        stdin: *const u8,
+        stdin_len: usize,
+        terminal: *mut ConchTerminal,
+        limits: *mut ConchLimits,
+        interrupt: *mut ConchInterrupt
+    ) -> *mut ConchResult;
+    conch_execute_streaming_v2_err => conch_execute_streaming_v2(
+        executor: *mut ConchExecutor,
+        script: *const c_char,
+        read: Option<ConchReadCallback>,
+        write: Option<ConchWriteCallback>,
+        user_data: *mut c_void,
+        limits: *mut ConchLimits,
+        interrupt: *mut ConchInterrupt
+    ) -> *mut ConchResult;
+    conch_result_free_checked_err => conch_result_free_checked(result: *mut ConchResult) -> i32;
+}
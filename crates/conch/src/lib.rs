@@ -38,10 +38,15 @@
 
 pub mod agent;
 mod executor;
+mod features;
 mod limits;
 pub mod policy;
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+mod quota;
 mod runtime;
 mod shell;
+mod stats;
+mod syntax;
 
 #[cfg(test)]
 mod tests;
@@ -54,8 +59,14 @@ pub use shell::{DynVfsStorage, Mount, Shell, ShellBuilder};
 // Executor (for advanced usage)
 pub use executor::ComponentShellExecutor;
 
+// Terminal emulation (isatty, COLUMNS/LINES) for shell instances.
+pub use executor::{TERMINAL_TYPE, TerminalSize};
+
+// Interactive input for scripts that read stdin.
+pub use executor::PromptHandler;
+
 #[cfg(feature = "embedded-shell")]
-pub use executor::ShellInstance;
+pub use executor::{InstanceIo, ShellInstance, StreamingIo};
 
 // Filesystem template for spawned children (share the shell's VFS).
 #[cfg(feature = "embedded-shell")]
@@ -65,13 +76,25 @@ pub use executor::ChildVfs;
 pub use executor::{ToolHandler, ToolRequest, ToolResult};
 
 // Component registry for subprocess spawning
-pub use executor::{ComponentRegistry, SharedRegistry};
+pub use executor::{
+    CommandHandler, CommandOutput, CommandRequest, ComponentRegistry, ConnectHandler,
+    ConnectRequest, LookupRequest, SharedRegistry,
+};
+
+// Shell language feature support
+pub use features::{SHELL_FEATURES, ShellFeature, shell_feature};
 
 // Resource limits
 pub use limits::ResourceLimits;
 
 // Runtime types
-pub use runtime::{Conch, ExecutionResult, ExecutionStats, RuntimeError};
+pub use runtime::{Conch, Diagnostic, ExecutionResult, ExecutionStats, RuntimeError};
+
+// Process-wide resource counters
+pub use stats::{RuntimeStats, runtime_stats};
+
+// Parsing scripts without running them
+pub use syntax::{SyntaxError, SyntaxNode, parse_script};
 
 // Re-export eryx-vfs types for VFS storage
 pub use eryx_vfs::{ArcStorage, DirPerms, FilePerms, InMemoryStorage, VfsStorage};
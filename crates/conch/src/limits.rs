@@ -16,6 +16,47 @@ pub struct ResourceLimits {
     /// Wall-clock timeout
     #[serde(with = "duration_ms")]
     pub timeout: Duration,
+    /// Maximum shell function call depth, or 0 for no limit beyond the wasm
+    /// stack. Exceeding either fails with [`RuntimeError::DepthExceeded`].
+    ///
+    /// [`RuntimeError::DepthExceeded`]: crate::RuntimeError::DepthExceeded
+    #[serde(default = "default_max_call_depth")]
+    pub max_call_depth: u32,
+    /// Maximum bytes the files a script writes to its virtual filesystem
+    /// may hold at once, or 0 for no limit. Writes beyond it fail with "no
+    /// space left on device". Files seeded before the execution, and real
+    /// directories mounted into it, do not count.
+    #[serde(default)]
+    pub max_fs_bytes: u64,
+    /// Longest script to run, in bytes, or 0 for no limit. Longer scripts
+    /// fail with [`RuntimeError::ScriptTooLarge`] without running.
+    ///
+    /// [`RuntimeError::ScriptTooLarge`]: crate::RuntimeError::ScriptTooLarge
+    #[serde(default)]
+    pub max_script_bytes: u64,
+    /// Longest one `grep` or `jq` may run, in milliseconds, or 0 for no
+    /// limit, so a pathological pattern or filter fails on its own with
+    /// status 124 rather than using up the whole script's time. The shell
+    /// asks the host for it, so scripts cannot change it.
+    #[serde(default)]
+    pub builtin_timeout_ms: u64,
+    /// Longest regex pattern `grep` compiles, in bytes, or 0 for no limit.
+    /// Like the other regex limits, it bounds what compiling a pattern from
+    /// untrusted input may cost; matching is already linear in the input.
+    #[serde(default)]
+    pub max_regex_pattern_bytes: u64,
+    /// Largest compiled regex program, in bytes, or 0 for the engine's
+    /// default. Counted repetitions such as `[a-z]{1000}` multiply it.
+    #[serde(default)]
+    pub max_regex_compiled_bytes: u64,
+    /// How deeply a regex's groups and repetitions may nest, or 0 for the
+    /// engine's default.
+    #[serde(default)]
+    pub max_regex_nesting: u32,
+}
+
+fn default_max_call_depth() -> u32 {
+    100
 }
 
 impl Default for ResourceLimits {
@@ -25,10 +66,31 @@ impl Default for ResourceLimits {
             max_memory_bytes: 64 * 1024 * 1024, // 64 MB
             max_output_bytes: 1024 * 1024,      // 1 MB output
             timeout: Duration::from_secs(30),   // 30 second wall clock
+            max_call_depth: default_max_call_depth(),
+            max_fs_bytes: 0,
+            max_script_bytes: 0,
+            builtin_timeout_ms: 0,
+            max_regex_pattern_bytes: 0,
+            max_regex_compiled_bytes: 0,
+            max_regex_nesting: 0,
         }
     }
 }
 
+impl ResourceLimits {
+    /// Check `script` against `max_script_bytes`.
+    pub(crate) fn check_script(&self, script: &str) -> Result<(), crate::RuntimeError> {
+        let len = script.len();
+        if self.max_script_bytes > 0 && len as u64 > self.max_script_bytes {
+            return Err(crate::RuntimeError::ScriptTooLarge {
+                len,
+                max: self.max_script_bytes,
+            });
+        }
+        Ok(())
+    }
+}
+
 /// Helper for serializing Duration as milliseconds
 mod duration_ms {
     use std::time::Duration;
@@ -128,6 +190,11 @@ mod tests {
         assert_eq!(limits.max_memory_bytes, 64 * 1024 * 1024);
         assert_eq!(limits.max_output_bytes, 1024 * 1024);
         assert_eq!(limits.timeout, Duration::from_secs(30));
+        assert_eq!(limits.max_call_depth, 100);
+        assert_eq!(limits.max_fs_bytes, 0);
+        assert_eq!(limits.max_script_bytes, 0);
+        assert_eq!(limits.builtin_timeout_ms, 0);
+        assert_eq!(limits.max_regex_pattern_bytes, 0);
     }
 
     #[test]
@@ -137,6 +204,13 @@ mod tests {
             max_memory_bytes: 128 * 1024 * 1024,
             max_output_bytes: 2 * 1024 * 1024,
             timeout: Duration::from_secs(60),
+            max_call_depth: 50,
+            max_fs_bytes: 1 << 20,
+            max_script_bytes: 4096,
+            builtin_timeout_ms: 250,
+            max_regex_pattern_bytes: 256,
+            max_regex_compiled_bytes: 1 << 16,
+            max_regex_nesting: 8,
         };
 
         let json = serde_json::to_string(&limits).unwrap();
@@ -146,6 +220,25 @@ mod tests {
         assert_eq!(deserialized.max_memory_bytes, 128 * 1024 * 1024);
         assert_eq!(deserialized.max_output_bytes, 2 * 1024 * 1024);
         assert_eq!(deserialized.timeout, Duration::from_secs(60));
+        assert_eq!(deserialized.max_call_depth, 50);
+        assert_eq!(deserialized.max_fs_bytes, 1 << 20);
+        assert_eq!(deserialized.max_script_bytes, 4096);
+        assert_eq!(deserialized.builtin_timeout_ms, 250);
+        assert_eq!(deserialized.max_regex_pattern_bytes, 256);
+        assert_eq!(deserialized.max_regex_compiled_bytes, 1 << 16);
+        assert_eq!(deserialized.max_regex_nesting, 8);
+
+        // Limits serialized before max_call_depth and max_fs_bytes existed
+        // get the defaults.
+        let old: ResourceLimits = serde_json::from_str(
+            r#"{"max_cpu_ms":1,"max_memory_bytes":2,"max_output_bytes":3,"timeout":4}"#,
+        )
+        .unwrap();
+        assert_eq!(old.max_call_depth, 100);
+        assert_eq!(old.max_fs_bytes, 0);
+        assert_eq!(old.max_script_bytes, 0);
+        assert_eq!(old.builtin_timeout_ms, 0);
+        assert_eq!(old.max_regex_nesting, 0);
     }
 
     #[test]
@@ -155,6 +248,13 @@ mod tests {
             max_memory_bytes: 1024,
             max_output_bytes: 512,
             timeout: Duration::from_millis(5000),
+            max_call_depth: 100,
+            max_fs_bytes: 0,
+            max_script_bytes: 0,
+            builtin_timeout_ms: 0,
+            max_regex_pattern_bytes: 0,
+            max_regex_compiled_bytes: 0,
+            max_regex_nesting: 0,
         };
 
         let json = serde_json::to_string(&limits).unwrap();
@@ -0,0 +1,440 @@
+//! Size quotas on execution filesystems.
+//!
+//! Every FFI execution runs on fresh in-memory storage, so its `/tmp` starts
+//! empty and is discarded when the execution ends. [`QuotaStorage`] wraps
+//! that storage to cap how much a script may keep in it, overall with
+//! [`ResourceLimits::max_fs_bytes`] and in `/tmp` with [`TmpConfig`], and to
+//! copy what it left in `/tmp` to a host directory when the caller wants to
+//! inspect it.
+//!
+//! [`ResourceLimits::max_fs_bytes`]: crate::ResourceLimits::max_fs_bytes
+
+use std::collections::BTreeMap;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::{Arc, Mutex};
+
+use async_trait::async_trait;
+use eryx_vfs::{DirEntry, Metadata, VfsError, VfsResult, VfsStorage};
+
+/// The guest directory [`TmpConfig`] applies to.
+pub(crate) const TMP_DIR: &str = "/tmp";
+
+/// Settings for the `/tmp` of each execution.
+#[derive(Debug, Clone, Default)]
+pub(crate) struct TmpConfig {
+    /// Most bytes the files in `/tmp` may hold at once, or 0 for no cap.
+    pub(crate) max_bytes: u64,
+    /// Host directory receiving a copy of each execution's `/tmp`, if any.
+    pub(crate) retain_dir: Option<PathBuf>,
+}
+
+/// Storage enforcing a size cap on the files below one directory.
+///
+/// Sizes are tracked for files written through the wrapper; files seeded
+/// into the inner storage beforehand do not count until they are renamed
+/// into the scope from outside it. Writes and such renames are checked
+/// against the cap.
+pub(crate) struct QuotaStorage {
+    inner: Arc<dyn VfsStorage>,
+    /// The directory whose files count, `/` for all of them.
+    scope: &'static str,
+    max_bytes: u64,
+    usage: Mutex<Usage>,
+    /// Set once a write or rename is refused for going past `max_bytes`.
+    exceeded: AtomicBool,
+}
+
+#[derive(Debug, Default)]
+struct Usage {
+    /// Size of every file written below the scope, keyed by path.
+    files: BTreeMap<String, u64>,
+    /// Sum of `files`.
+    total: u64,
+}
+
+impl Usage {
+    /// Record `path` as `size` bytes, failing if that breaks `max_bytes`.
+    /// Returns the previous size so a failed write can be undone.
+    fn resize(
+        &mut self,
+        scope: &str,
+        path: &str,
+        size: u64,
+        max_bytes: u64,
+    ) -> VfsResult<Option<u64>> {
+        let old = self.files.get(path).copied();
+        let total = self.total - old.unwrap_or(0) + size;
+        if max_bytes > 0 && total > max_bytes {
+            return Err(no_space(scope, max_bytes));
+        }
+        self.files.insert(path.to_string(), size);
+        self.total = total;
+        Ok(old)
+    }
+
+    /// Put back the size `resize` replaced.
+    fn restore(&mut self, path: &str, old: Option<u64>) {
+        let current = match old {
+            Some(size) => self.files.insert(path.to_string(), size),
+            None => self.files.remove(path),
+        };
+        self.total = self.total - current.unwrap_or(0) + old.unwrap_or(0);
+    }
+
+    /// The entries for `path` and, if it is a directory, everything below
+    /// it.
+    fn tree(&self, path: &str) -> Vec<(String, u64)> {
+        let prefix = format!("{}/", path.trim_end_matches('/'));
+        self.files
+            .iter()
+            .filter(|(p, _)| p.as_str() == path || p.starts_with(&prefix))
+            .map(|(p, size)| (p.clone(), *size))
+            .collect()
+    }
+
+    /// Forget `path` and, if it is a directory, everything below it,
+    /// returning the removed entries.
+    fn remove_tree(&mut self, path: &str) -> Vec<(String, u64)> {
+        let removed = self.tree(path);
+        for (p, size) in &removed {
+            self.files.remove(p);
+            self.total -= size;
+        }
+        removed
+    }
+}
+
+/// The error for a write that would take the files below `scope` past
+/// `max_bytes`.
+fn no_space(scope: &str, max_bytes: u64) -> VfsError {
+    let scope = if scope == "/" {
+        "the filesystem"
+    } else {
+        scope
+    };
+    VfsError::Storage(format!(
+        "no space left on device: {scope} is limited to {max_bytes} bytes"
+    ))
+}
+
+/// Whether `path` is `scope` or below it.
+fn in_scope(scope: &str, path: &str) -> bool {
+    path.strip_prefix(scope.trim_end_matches('/'))
+        .is_some_and(|rest| rest.is_empty() || rest.starts_with('/'))
+}
+
+impl QuotaStorage {
+    /// Wrap `inner`, capping the files below `scope` at `max_bytes`, or not
+    /// at all if 0.
+    pub(crate) fn new(inner: Arc<dyn VfsStorage>, scope: &'static str, max_bytes: u64) -> Self {
+        Self {
+            inner,
+            scope,
+            max_bytes,
+            usage: Mutex::new(Usage::default()),
+            exceeded: AtomicBool::new(false),
+        }
+    }
+
+    /// Whether a write or rename was refused for going past the cap.
+    pub(crate) fn exceeded(&self) -> bool {
+        self.exceeded.load(Ordering::Relaxed)
+    }
+
+    /// The error refusing a write past the cap, noting that it was hit.
+    fn refuse(&self) -> VfsError {
+        self.exceeded.store(true, Ordering::Relaxed);
+        no_space(self.scope, self.max_bytes)
+    }
+
+    fn usage(&self) -> std::sync::MutexGuard<'_, Usage> {
+        self.usage.lock().unwrap_or_else(|e| e.into_inner())
+    }
+
+    /// Reserve room for `path` to become `size` bytes, run `write`, and undo
+    /// the reservation if it fails.
+    async fn sized_write<F>(
+        &self,
+        path: &str,
+        size: impl FnOnce(u64) -> u64,
+        write: F,
+    ) -> VfsResult<()>
+    where
+        F: std::future::Future<Output = VfsResult<()>>,
+    {
+        if !in_scope(self.scope, path) {
+            return write.await;
+        }
+        let old = {
+            let mut usage = self.usage();
+            let current = usage.files.get(path).copied().unwrap_or(0);
+            usage
+                .resize(self.scope, path, size(current), self.max_bytes)
+                .map_err(|_| self.refuse())?
+        };
+        let result = write.await;
+        if result.is_err() {
+            self.usage().restore(path, old);
+        }
+        result
+    }
+
+    /// The sizes of the file at `path` or of every file below it, read from
+    /// the inner storage.
+    async fn inner_sizes(&self, path: &str) -> VfsResult<Vec<(String, u64)>> {
+        let mut sizes = Vec::new();
+        let mut pending = vec![path.to_string()];
+        while let Some(path) = pending.pop() {
+            match self.inner.list(&path).await {
+                Ok(entries) => {
+                    let dir = path.trim_end_matches('/');
+                    pending.extend(entries.into_iter().map(|e| format!("{dir}/{}", e.name)));
+                }
+                Err(_) => {
+                    let size = self.inner.read(&path).await?.len() as u64;
+                    sizes.push((path, size));
+                }
+            }
+        }
+        Ok(sizes)
+    }
+
+    /// Copy every file written below the scope to `dir`, keeping its path
+    /// relative to the scope.
+    pub(crate) async fn retain(&self, dir: &Path) -> std::io::Result<()> {
+        let paths: Vec<String> = self.usage().files.keys().cloned().collect();
+        std::fs::create_dir_all(dir)?;
+        for path in paths {
+            let Ok(data) = self.inner.read(&path).await else {
+                continue;
+            };
+            let relative = path
+                .trim_start_matches(self.scope.trim_end_matches('/'))
+                .trim_start_matches('/');
+            let dest = dir.join(relative);
+            if let Some(parent) = dest.parent() {
+                std::fs::create_dir_all(parent)?;
+            }
+            std::fs::write(dest, data)?;
+        }
+        Ok(())
+    }
+}
+
+impl std::fmt::Debug for QuotaStorage {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("QuotaStorage")
+            .field("scope", &self.scope)
+            .field("max_bytes", &self.max_bytes)
+            .finish_non_exhaustive()
+    }
+}
+
+#[async_trait]
+impl VfsStorage for QuotaStorage {
+    async fn read(&self, path: &str) -> VfsResult<Vec<u8>> {
+        self.inner.read(path).await
+    }
+
+    async fn read_at(&self, path: &str, offset: u64, len: u64) -> VfsResult<Vec<u8>> {
+        self.inner.read_at(path, offset, len).await
+    }
+
+    async fn write(&self, path: &str, data: &[u8]) -> VfsResult<()> {
+        let size = data.len() as u64;
+        self.sized_write(path, |_| size, self.inner.write(path, data))
+            .await
+    }
+
+    async fn write_at(&self, path: &str, offset: u64, data: &[u8]) -> VfsResult<()> {
+        let end = offset.saturating_add(data.len() as u64);
+        self.sized_write(
+            path,
+            |current| current.max(end),
+            self.inner.write_at(path, offset, data),
+        )
+        .await
+    }
+
+    async fn set_size(&self, path: &str, size: u64) -> VfsResult<()> {
+        self.sized_write(path, |_| size, self.inner.set_size(path, size))
+            .await
+    }
+
+    async fn delete(&self, path: &str) -> VfsResult<()> {
+        self.inner.delete(path).await?;
+        self.usage().remove_tree(path);
+        Ok(())
+    }
+
+    async fn exists(&self, path: &str) -> VfsResult<bool> {
+        self.inner.exists(path).await
+    }
+
+    async fn list(&self, path: &str) -> VfsResult<Vec<DirEntry>> {
+        self.inner.list(path).await
+    }
+
+    async fn stat(&self, path: &str) -> VfsResult<Metadata> {
+        self.inner.stat(path).await
+    }
+
+    async fn mkdir(&self, path: &str) -> VfsResult<()> {
+        self.inner.mkdir(path).await
+    }
+
+    async fn rmdir(&self, path: &str) -> VfsResult<()> {
+        self.inner.rmdir(path).await?;
+        self.usage().remove_tree(path);
+        Ok(())
+    }
+
+    async fn rename(&self, from: &str, to: &str) -> VfsResult<()> {
+        // Files moved into the scope from outside it were never counted, so
+        // they are charged now as if written there. A rename within the
+        // scope carries the sizes along: it does not allocate anything.
+        let arriving = if in_scope(self.scope, to) && !in_scope(self.scope, from) {
+            self.inner_sizes(from).await?
+        } else {
+            Vec::new()
+        };
+        if self.max_bytes > 0 && !arriving.is_empty() {
+            let usage = self.usage();
+            let replaced: u64 = usage.tree(to).iter().map(|(_, size)| size).sum();
+            let added: u64 = arriving.iter().map(|(_, size)| size).sum();
+            if usage.total - replaced + added > self.max_bytes {
+                return Err(self.refuse());
+            }
+        }
+
+        self.inner.rename(from, to).await?;
+        let mut usage = self.usage();
+        let mut moved = usage.remove_tree(from);
+        moved.extend(arriving);
+        usage.remove_tree(to);
+        for (path, size) in moved {
+            let dest = format!("{to}{}", &path[from.len()..]);
+            if in_scope(self.scope, &dest) {
+                let _ = usage.resize(self.scope, &dest, size, 0);
+            }
+        }
+        Ok(())
+    }
+
+    fn mkdir_sync(&self, path: &str) -> VfsResult<()> {
+        self.inner.mkdir_sync(path)
+    }
+}
+
+#[cfg(test)]
+#[allow(clippy::expect_used, clippy::unwrap_used)]
+mod tests {
+    use super::*;
+    use eryx_vfs::InMemoryStorage;
+
+    async fn setup(scope: &'static str, max_bytes: u64) -> QuotaStorage {
+        let inner = Arc::new(InMemoryStorage::new());
+        inner.mkdir("/tmp").await.unwrap();
+        inner.mkdir("/work").await.unwrap();
+        QuotaStorage::new(inner, scope, max_bytes)
+    }
+
+    #[test]
+    fn test_in_scope() {
+        assert!(in_scope(TMP_DIR, "/tmp"));
+        assert!(in_scope(TMP_DIR, "/tmp/a/b"));
+        assert!(!in_scope(TMP_DIR, "/tmpfoo"));
+        assert!(!in_scope(TMP_DIR, "/work/tmp"));
+        assert!(in_scope("/", "/work/tmp"));
+    }
+
+    #[tokio::test]
+    async fn test_tmp_cap() {
+        let storage = setup(TMP_DIR, 10).await;
+        storage.write("/tmp/a", b"123456").await.unwrap();
+        assert!(storage.write("/tmp/b", b"12345").await.is_err());
+        assert!(!storage.exists("/tmp/b").await.unwrap());
+
+        // Rewriting a file only counts its new size.
+        storage.write("/tmp/a", b"12").await.unwrap();
+        storage.write("/tmp/b", b"12345").await.unwrap();
+        assert!(storage.write_at("/tmp/b", 5, b"1234").await.is_err());
+        storage.write_at("/tmp/b", 5, b"123").await.unwrap();
+
+        // Deleting frees the space; other directories are not capped.
+        storage.delete("/tmp/b").await.unwrap();
+        storage.set_size("/tmp/a", 10).await.unwrap();
+        storage.write("/work/big", &[0; 64]).await.unwrap();
+        assert_eq!(storage.usage().total, 10);
+    }
+
+    #[tokio::test]
+    async fn test_fs_cap() {
+        let storage = setup("/", 8).await;
+        storage.write("/tmp/a", b"1234").await.unwrap();
+        storage.write("/work/b", b"1234").await.unwrap();
+        assert!(!storage.exceeded());
+        let err = storage.write("/work/c", b"1").await.unwrap_err();
+        assert!(err.to_string().contains("no space left on device"));
+        assert!(storage.exceeded());
+
+        storage.rename("/work/b", "/work/c").await.unwrap();
+        assert!(storage.write("/work/d", b"1").await.is_err());
+        storage.delete("/tmp/a").await.unwrap();
+        storage.write("/work/d", b"1").await.unwrap();
+    }
+
+    #[tokio::test]
+    async fn test_tmp_rename() {
+        let storage = setup(TMP_DIR, 0).await;
+        storage.write("/tmp/a", b"1234").await.unwrap();
+        storage.rename("/tmp/a", "/work/a").await.unwrap();
+        assert_eq!(storage.usage().total, 0);
+
+        storage.write("/tmp/b", b"12").await.unwrap();
+        storage.write("/tmp/c", b"123").await.unwrap();
+        storage.rename("/tmp/b", "/tmp/c").await.unwrap();
+        let usage = storage.usage();
+        assert_eq!(usage.files.get("/tmp/c"), Some(&2));
+        assert_eq!(usage.total, 2);
+    }
+
+    #[tokio::test]
+    async fn test_tmp_rename_into_scope() {
+        let storage = setup(TMP_DIR, 10).await;
+        storage.write("/work/big", &[0; 64]).await.unwrap();
+        let err = storage.rename("/work/big", "/tmp/big").await.unwrap_err();
+        assert!(err.to_string().contains("no space left on device"));
+        assert!(storage.exists("/work/big").await.unwrap());
+        assert!(!storage.exists("/tmp/big").await.unwrap());
+
+        storage.mkdir("/work/d").await.unwrap();
+        storage.write("/work/d/a", b"1234").await.unwrap();
+        storage.write("/work/d/b", b"12").await.unwrap();
+        storage.rename("/work/d", "/tmp/d").await.unwrap();
+        assert_eq!(storage.usage().total, 6);
+        assert_eq!(storage.usage().files.get("/tmp/d/a"), Some(&4));
+
+        storage.write("/work/c", b"12345").await.unwrap();
+        assert!(storage.rename("/work/c", "/tmp/c").await.is_err());
+        // Replacing a counted file frees its size first.
+        storage.rename("/work/c", "/tmp/d/a").await.unwrap();
+        assert_eq!(storage.usage().total, 7);
+    }
+
+    #[tokio::test]
+    async fn test_tmp_retain() {
+        let storage = setup(TMP_DIR, 0).await;
+        storage.mkdir("/tmp/d").await.unwrap();
+        storage.write("/tmp/d/f", b"kept").await.unwrap();
+        storage.write("/tmp/gone", b"x").await.unwrap();
+        storage.delete("/tmp/gone").await.unwrap();
+
+        let dir = std::env::temp_dir().join(format!("conch-tmp-retain-{}", std::process::id()));
+        storage.retain(&dir).await.unwrap();
+        assert_eq!(std::fs::read(dir.join("d/f")).unwrap(), b"kept");
+        assert!(!dir.join("gone").exists());
+        std::fs::remove_dir_all(&dir).unwrap();
+    }
+}
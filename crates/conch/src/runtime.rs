@@ -2,6 +2,7 @@
 
 use std::fmt;
 use std::sync::Arc;
+use std::time::Duration;
 
 use eryx_vfs::{ArcStorage, DirPerms, FilePerms, HybridVfsCtx, InMemoryStorage};
 use serde::{Deserialize, Serialize};
@@ -23,6 +24,17 @@ pub enum RuntimeError {
     /// Memory limit exceeded
     #[error("memory limit exceeded")]
     MemoryLimit,
+    /// Shell function calls nested past the call depth limit
+    #[error("function call depth exceeded")]
+    DepthExceeded,
+    /// The script was longer than the script size limit and was not run
+    #[error("script too large: {len} bytes exceeds the limit of {max}")]
+    ScriptTooLarge {
+        /// Length of the script in bytes
+        len: usize,
+        /// The limit it exceeded
+        max: u64,
+    },
     /// Concurrency semaphore error
     #[error("semaphore error")]
     Semaphore,
@@ -45,6 +57,38 @@ pub struct ExecutionStats {
     pub wall_time_ms: u64,
 }
 
+/// Where the wall time of an execution went.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct ExecutionTimings {
+    /// Compiling the components of commands the script spawned. The shell
+    /// itself is compiled once, when its executor is created.
+    pub compile: Duration,
+    /// Creating the shell instance and its filesystem. Zero for calls on an
+    /// existing instance.
+    pub instantiate: Duration,
+    /// Running the script, less `compile`.
+    pub execute: Duration,
+}
+
+/// An error the shell or one of its builtins reported while running a
+/// script, as it was written to stderr.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Diagnostic {
+    /// What kind of error it was, such as `"command-not-found"`.
+    pub category: String,
+    /// The builtin that reported it or the command that was not found, or
+    /// empty for other errors of the shell.
+    pub command: String,
+    /// The error without the shell and command prefixes.
+    pub message: String,
+    /// The line of the script the error happened on, counting from 1, or 0
+    /// if it is not known.
+    pub line: u32,
+    /// The column of the error, counting from 1, or 0 if it is not known.
+    /// Only syntax errors have one.
+    pub column: u32,
+}
+
 /// Result of shell execution
 #[derive(Debug, Clone)]
 pub struct ExecutionResult {
@@ -52,12 +96,30 @@ pub struct ExecutionResult {
     pub exit_code: i32,
     /// Standard output
     pub stdout: Vec<u8>,
+    /// Bytes the script wrote to stdout, including any dropped by the output
+    /// limit
+    pub stdout_total_len: usize,
     /// Standard error
     pub stderr: Vec<u8>,
+    /// Bytes the script wrote to stderr, including any dropped by the output
+    /// limit
+    pub stderr_total_len: usize,
     /// Whether output was truncated due to limits
     pub truncated: bool,
+    /// Whether a write failed because the files it would leave went past
+    /// `max_fs_bytes` or the cap on `/tmp`. The script sees "no space left
+    /// on device" and may carry on.
+    pub fs_exceeded: bool,
+    /// Trap handlers that ran as the execution ended, in order, e.g.
+    /// `["INT", "EXIT"]`. Only the one-shot FFI executions run traps.
+    pub traps: Vec<String>,
+    /// Errors the shell and its builtins reported, in order. Unlike stderr,
+    /// a script cannot write these itself.
+    pub diagnostics: Vec<Diagnostic>,
     /// Execution statistics
     pub stats: ExecutionStats,
+    /// Where the execution's wall time went
+    pub timings: ExecutionTimings,
 }
 
 /// Shell execution engine
@@ -131,14 +193,21 @@ impl Conch {
         script: &str,
         limits: ResourceLimits,
     ) -> Result<ExecutionResult, RuntimeError> {
+        limits.check_script(script)?;
         let _permit = self
             .semaphore
             .acquire()
             .await
             .map_err(|_| RuntimeError::Semaphore)?;
 
-        // Create a minimal VFS context with a /tmp directory
-        let storage = ArcStorage::new(Arc::new(InMemoryStorage::new()));
+        // Create a minimal VFS context with a /tmp directory, holding at most
+        // max_fs_bytes of files.
+        let quota = Arc::new(crate::quota::QuotaStorage::new(
+            Arc::new(InMemoryStorage::new()),
+            "/",
+            limits.max_fs_bytes,
+        ));
+        let storage = ArcStorage::new(quota.clone());
         let mut hybrid_ctx = HybridVfsCtx::new(storage.clone());
         hybrid_ctx.add_vfs_preopen("/tmp", DirPerms::all(), FilePerms::all());
 
@@ -157,6 +226,7 @@ impl Conch {
         let registry = crate::executor::with_embedded_coreutils(registry);
 
         // Create a temporary shell instance
+        let started = std::time::Instant::now();
         let mut instance = if let Some(registry) = registry {
             self.executor
                 .create_instance_with_registry(
@@ -173,8 +243,13 @@ impl Conch {
                 .await?
         };
 
+        let instantiate = started.elapsed();
+
         // Execute the script
-        instance.execute(script, &limits).await
+        let mut result = instance.execute(script, &limits).await?;
+        result.timings.instantiate = instantiate;
+        result.fs_exceeded = quota.exceeded();
+        Ok(result)
     }
 
     /// Execute a shell script (stub for when embedded-shell is disabled).
@@ -222,6 +297,22 @@ mod tests {
         assert_eq!(result.exit_code, 0);
     }
 
+    #[tokio::test]
+    async fn test_execution_timings() {
+        let Some(shell) = get_test_shell() else {
+            return;
+        };
+
+        let result = shell
+            .execute("echo hello", ResourceLimits::default())
+            .await
+            .unwrap();
+
+        assert!(result.timings.instantiate > Duration::ZERO);
+        assert!(result.timings.execute > Duration::ZERO);
+        assert_eq!(result.timings.compile, Duration::ZERO);
+    }
+
     #[tokio::test]
     async fn test_simple_execution() {
         let Some(shell) = get_test_shell() else {
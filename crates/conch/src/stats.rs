@@ -0,0 +1,119 @@
+//! Process-wide resource counters.
+//!
+//! Executors created through the C FFI, the wasm memory of running shell
+//! instances, and the compiled code held for their components are tallied
+//! here so callers can plan capacity from measurements instead of guesses.
+//! [`runtime_stats`] takes a snapshot; `conch_stats()` exposes it over FFI.
+
+use std::sync::atomic::{AtomicU64, AtomicUsize, Ordering};
+
+static LIVE_EXECUTORS: AtomicUsize = AtomicUsize::new(0);
+static COMPILED_CODE_BYTES: AtomicUsize = AtomicUsize::new(0);
+static WASM_MEMORY_BYTES: AtomicUsize = AtomicUsize::new(0);
+static EXECUTIONS: AtomicU64 = AtomicU64::new(0);
+
+/// A snapshot of the process-wide resource counters.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct RuntimeStats {
+    /// FFI executors created and not yet freed.
+    pub live_executors: usize,
+    /// Linear memory currently allocated by running shell instances.
+    pub wasm_memory_bytes: usize,
+    /// Compiled machine code held for the components of live executors.
+    pub compiled_code_bytes: usize,
+    /// Executions started through the FFI since the library was loaded.
+    pub executions: u64,
+}
+
+/// Take a snapshot of the resource counters.
+///
+/// Each counter is read separately, so a snapshot taken while executors are
+/// created or executions run may mix values from slightly different moments.
+pub fn runtime_stats() -> RuntimeStats {
+    RuntimeStats {
+        live_executors: LIVE_EXECUTORS.load(Ordering::Relaxed),
+        wasm_memory_bytes: WASM_MEMORY_BYTES.load(Ordering::Relaxed),
+        compiled_code_bytes: COMPILED_CODE_BYTES.load(Ordering::Relaxed),
+        executions: EXECUTIONS.load(Ordering::Relaxed),
+    }
+}
+
+/// Count an execution.
+#[cfg_attr(not(feature = "embedded-shell"), allow(dead_code))]
+pub(crate) fn record_execution() {
+    EXECUTIONS.fetch_add(1, Ordering::Relaxed);
+}
+
+/// Counts a live executor and its compiled code until dropped.
+#[derive(Debug)]
+pub(crate) struct ExecutorGauge {
+    compiled_code_bytes: usize,
+}
+
+impl ExecutorGauge {
+    /// Count an executor whose component compiled to `compiled_code_bytes`.
+    pub(crate) fn new(compiled_code_bytes: usize) -> Self {
+        LIVE_EXECUTORS.fetch_add(1, Ordering::Relaxed);
+        COMPILED_CODE_BYTES.fetch_add(compiled_code_bytes, Ordering::Relaxed);
+        Self {
+            compiled_code_bytes,
+        }
+    }
+}
+
+impl Drop for ExecutorGauge {
+    fn drop(&mut self) {
+        LIVE_EXECUTORS.fetch_sub(1, Ordering::Relaxed);
+        COMPILED_CODE_BYTES.fetch_sub(self.compiled_code_bytes, Ordering::Relaxed);
+    }
+}
+
+/// Counts the linear memory of one store until dropped.
+#[derive(Debug, Default)]
+pub(crate) struct MemoryGauge {
+    bytes: usize,
+}
+
+impl MemoryGauge {
+    /// Record that the store's memory grew from `current` to `desired` bytes.
+    pub(crate) fn grow(&mut self, current: usize, desired: usize) {
+        let added = desired.saturating_sub(current);
+        self.bytes += added;
+        WASM_MEMORY_BYTES.fetch_add(added, Ordering::Relaxed);
+    }
+}
+
+impl Drop for MemoryGauge {
+    fn drop(&mut self) {
+        WASM_MEMORY_BYTES.fetch_sub(self.bytes, Ordering::Relaxed);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    // The counters are process-wide and other tests create executors
+    // concurrently, so these check the gauges' own bookkeeping.
+
+    #[test]
+    fn test_memory_gauge_tracks_growth() {
+        let mut gauge = MemoryGauge::default();
+        gauge.grow(0, 65536);
+        gauge.grow(65536, 131072);
+        gauge.grow(131072, 131072);
+        assert_eq!(gauge.bytes, 131072);
+    }
+
+    #[test]
+    fn test_executor_gauge_counts_until_dropped() {
+        let before = runtime_stats().executions;
+        record_execution();
+        assert!(runtime_stats().executions > before);
+
+        let gauge = ExecutorGauge::new(1024);
+        assert_eq!(gauge.compiled_code_bytes, 1024);
+        assert!(runtime_stats().live_executors >= 1);
+        drop(gauge);
+    }
+}
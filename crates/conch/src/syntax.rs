@@ -0,0 +1,138 @@
+//! Parsing scripts without running them.
+//!
+//! The shell parses each command as it reaches it, so there is no syntax
+//! tree to look at before a script runs. Callers that key, estimate or split
+//! scripts beforehand parse them here instead, with tree-sitter's bash
+//! grammar, rather than each lexing shell on their own.
+
+use serde::Serialize;
+
+/// How deeply nodes may nest before [`parse_script`] gives up, keeping
+/// conversion and its callers off the end of the stack.
+const MAX_DEPTH: usize = 512;
+
+/// A node of a script's syntax tree.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub struct SyntaxNode {
+    /// The grammar's name for the node, such as `pipeline`, `word` or `|`.
+    pub kind: &'static str,
+    /// Whether the grammar names the node, as it does commands and words
+    /// but not punctuation or keywords.
+    pub named: bool,
+    /// Byte offset of the node's first byte in the script.
+    pub start: usize,
+    /// Byte offset just past the node's last byte.
+    pub end: usize,
+    /// The node's children, in order.
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub children: Vec<SyntaxNode>,
+}
+
+/// Why a script could not be parsed.
+#[derive(Debug, Clone, PartialEq, Eq, thiserror::Error)]
+pub enum SyntaxError {
+    /// The script is not valid shell.
+    #[error("line {line}: syntax error")]
+    Invalid {
+        /// The line, counting from one, of the first error.
+        line: usize,
+    },
+    /// The script nests deeper than the parser follows.
+    #[error("script nests more than {MAX_DEPTH} levels deep")]
+    TooDeep,
+    /// The parser could not be set up.
+    #[error("parser unavailable: {0}")]
+    Unavailable(String),
+}
+
+/// Parse `script` into its syntax tree, failing if it is not valid shell.
+pub fn parse_script(script: &str) -> Result<SyntaxNode, SyntaxError> {
+    let mut parser = tree_sitter::Parser::new();
+    parser
+        .set_language(&tree_sitter_bash::LANGUAGE.into())
+        .map_err(|e| SyntaxError::Unavailable(e.to_string()))?;
+    let tree = parser
+        .parse(script, None)
+        .ok_or_else(|| SyntaxError::Unavailable("parse cancelled".to_string()))?;
+    let root = tree.root_node();
+    if root.has_error() {
+        let line = first_error(root, 0).map_or(1, |node| node.start_position().row + 1);
+        return Err(SyntaxError::Invalid { line });
+    }
+    convert(root, 0)
+}
+
+/// Copy `node` and its descendants out of the tree.
+fn convert(node: tree_sitter::Node<'_>, depth: usize) -> Result<SyntaxNode, SyntaxError> {
+    if depth > MAX_DEPTH {
+        return Err(SyntaxError::TooDeep);
+    }
+    let mut cursor = node.walk();
+    let children = node
+        .children(&mut cursor)
+        .map(|child| convert(child, depth + 1))
+        .collect::<Result<_, _>>()?;
+    Ok(SyntaxNode {
+        kind: node.kind(),
+        named: node.is_named(),
+        start: node.start_byte(),
+        end: node.end_byte(),
+        children,
+    })
+}
+
+/// The first node at or under `node` that is an error or was made up to
+/// stand in for missing input.
+fn first_error(node: tree_sitter::Node<'_>, depth: usize) -> Option<tree_sitter::Node<'_>> {
+    if node.is_error() || node.is_missing() {
+        return Some(node);
+    }
+    if depth > MAX_DEPTH || !node.has_error() {
+        return None;
+    }
+    let mut cursor = node.walk();
+    node.children(&mut cursor)
+        .find_map(|child| first_error(child, depth + 1))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_script() {
+        let script = "cat /data/x | grep -c 'a b'";
+        let tree = parse_script(script).unwrap();
+        assert_eq!(tree.kind, "program");
+        let pipeline = &tree.children[0];
+        assert_eq!(pipeline.kind, "pipeline");
+        let stages: Vec<_> = pipeline
+            .children
+            .iter()
+            .map(|node| &script[node.start..node.end])
+            .collect();
+        assert_eq!(stages, ["cat /data/x", "|", "grep -c 'a b'"]);
+        assert!(!pipeline.children[1].named);
+    }
+
+    #[test]
+    fn test_arithmetic_is_not_a_heredoc() {
+        let tree = parse_script("((x = 1 << 2))\necho $x").unwrap();
+        let json = serde_json::to_string(&tree).unwrap();
+        assert!(!json.contains("heredoc"), "{json}");
+    }
+
+    #[test]
+    fn test_parse_script_errors() {
+        assert!(matches!(
+            parse_script("echo ok\nif true; then\n"),
+            Err(SyntaxError::Invalid { .. })
+        ));
+        assert!(matches!(
+            parse_script("echo 'unterminated"),
+            Err(SyntaxError::Invalid { line: 1 })
+        ));
+        let nested = format!("echo {}x{}", "$(echo ".repeat(300), ")".repeat(300));
+        assert_eq!(parse_script(&nested), Err(SyntaxError::TooDeep));
+    }
+}
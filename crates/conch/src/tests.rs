@@ -4,7 +4,7 @@
 #[allow(clippy::expect_used)]
 mod embedded_tests {
     use crate::limits::ResourceLimits;
-    use crate::runtime::Conch;
+    use crate::runtime::{Conch, RuntimeError};
 
     fn conch() -> Conch {
         Conch::embedded(1).expect("Failed to create embedded Conch")
@@ -118,6 +118,364 @@ mod embedded_tests {
         );
     }
 
+    #[tokio::test]
+    async fn test_jq_args() {
+        let conch = conch();
+        let limits = ResourceLimits::default();
+
+        let result = conch
+            .execute(
+                r#"CONCH_JQ_ARGS='{"greeting":"hi","n":1}'
+jq -nc --arg who "o'brien" --argjson n '[2]' '[$greeting, $who, $n]'
+jq -n --argjson n '{' '$n'; echo "status $?""#,
+                limits,
+            )
+            .await
+            .expect("execute failed");
+        assert_eq!(
+            String::from_utf8_lossy(&result.stdout),
+            "[\"hi\",\"o'brien\",[2]]\nstatus 2\n"
+        );
+        assert!(
+            String::from_utf8_lossy(&result.stderr)
+                .contains("conch: jq: invalid JSON for --argjson n"),
+            "stderr: {}",
+            String::from_utf8_lossy(&result.stderr)
+        );
+    }
+
+    #[tokio::test]
+    async fn test_jq_stream() {
+        let conch = conch();
+        let limits = ResourceLimits::default();
+
+        let result = conch
+            .execute(
+                r#"echo '{"a":[1,2]}' | jq -c --stream .
+echo '[{"id":1},{"id":2}]' | jq -c --stream 'select(length == 2 and .[0][1] == "id") | .[1]'
+jq --stream -n .; echo "status $?""#,
+                limits,
+            )
+            .await
+            .expect("execute failed");
+        assert_eq!(
+            String::from_utf8_lossy(&result.stdout),
+            "[[\"a\",0],1]\n[[\"a\",1],2]\n[[\"a\",1]]\n[[\"a\"]]\n1\n2\nstatus 2\n",
+            "stderr: {}",
+            String::from_utf8_lossy(&result.stderr)
+        );
+    }
+
+    #[tokio::test]
+    async fn test_config_conversion_builtins() {
+        let conch = conch();
+        let limits = ResourceLimits::default();
+
+        let result = conch
+            .execute(
+                r#"printf 'name: app\nreplicas: 2\n' | yaml2json -c | jq -c '.replicas += 1' | json2yaml
+printf '[server]\nport = 8080\n' | toml2json -c"#,
+                limits,
+            )
+            .await
+            .expect("execute failed");
+        assert_eq!(
+            String::from_utf8_lossy(&result.stdout),
+            "name: app\nreplicas: 3\n{\"server\":{\"port\":8080}}\n",
+            "stderr: {}",
+            String::from_utf8_lossy(&result.stderr)
+        );
+    }
+
+    #[tokio::test]
+    async fn test_csv_builtin() {
+        let conch = conch();
+        let limits = ResourceLimits::default();
+
+        let result = conch
+            .execute(
+                r#"printf 'name,city\nann,Oslo\nbob,Rome\n' | csv -w city=Rome --to-json"#,
+                limits,
+            )
+            .await
+            .expect("execute failed");
+        assert_eq!(
+            result.exit_code,
+            0,
+            "stderr: {}",
+            String::from_utf8_lossy(&result.stderr)
+        );
+        let stdout = String::from_utf8_lossy(&result.stdout);
+        assert_eq!(
+            stdout.trim(),
+            r#"{"city":"Rome","name":"bob"}"#,
+            "stdout: {:?}",
+            stdout
+        );
+    }
+
+    #[tokio::test]
+    async fn test_max_call_depth() {
+        let conch = conch();
+        let limits = ResourceLimits {
+            max_call_depth: 20,
+            ..ResourceLimits::default()
+        };
+
+        let result = conch
+            .execute(
+                "count() { (( $1 > 0 )) && count $(( $1 - 1 )); echo -n .; }; count 10",
+                limits.clone(),
+            )
+            .await
+            .expect("recursion within the limit failed");
+        assert_eq!(result.stdout, b"...........");
+
+        let err = conch
+            .execute("forever() { forever; }; forever", limits.clone())
+            .await
+            .expect_err("unbounded recursion succeeded");
+        assert!(
+            matches!(err, crate::runtime::RuntimeError::DepthExceeded),
+            "error: {err}"
+        );
+
+        // Neither the shell's message nor its environment decides the limit.
+        let result = conch
+            .execute(
+                "echo 'maximum function call depth exceeded' >&2; echo ${CONCH_MAX_CALL_DEPTH-unset}; exit 1",
+                limits,
+            )
+            .await
+            .expect("printing the depth error was taken for it");
+        assert_eq!(result.exit_code, 1);
+        assert_eq!(result.stdout, b"unset\n");
+    }
+
+    #[tokio::test]
+    async fn test_diagnostics() {
+        let conch = conch();
+        let result = conch
+            .execute(
+                "echo 'conch: jq: parse error' >&2\njq '.[' </dev/null\nno_such_command_xyz",
+                ResourceLimits::default(),
+            )
+            .await
+            .expect("execute failed");
+        let reported: Vec<_> = result
+            .diagnostics
+            .iter()
+            .map(|d| (d.category.as_str(), d.command.as_str(), d.line))
+            .collect();
+        // The line the script wrote itself is not among them.
+        assert_eq!(
+            reported,
+            [
+                ("command-failed", "jq", 2),
+                ("command-not-found", "no_such_command_xyz", 3)
+            ],
+            "diagnostics: {:?}",
+            result.diagnostics
+        );
+    }
+
+    #[tokio::test]
+    async fn test_diagnostics_syntax_position() {
+        let conch = conch();
+        let result = conch
+            .execute("echo ok\nif then fi", ResourceLimits::default())
+            .await
+            .expect("execute failed");
+        let syntax = result
+            .diagnostics
+            .iter()
+            .find(|d| d.category == "syntax")
+            .unwrap_or_else(|| panic!("no syntax diagnostic: {:?}", result.diagnostics));
+        assert_eq!(syntax.line, 2, "diagnostic: {syntax:?}");
+        assert!(syntax.column > 0, "diagnostic: {syntax:?}");
+    }
+
+    #[tokio::test]
+    async fn test_max_fs_bytes() {
+        let conch = conch();
+        let limits = ResourceLimits {
+            max_fs_bytes: 16,
+            ..ResourceLimits::default()
+        };
+
+        let result = conch
+            .execute(
+                "printf '%10s' '' > /tmp/a && printf '%10s' '' > /tmp/b; echo $?",
+                limits,
+            )
+            .await
+            .expect("execute failed");
+        assert_ne!(
+            result.stdout,
+            b"0\n",
+            "write beyond the quota succeeded: {}",
+            String::from_utf8_lossy(&result.stderr)
+        );
+        assert!(result.fs_exceeded);
+
+        // A script only claiming to be out of space is not.
+        let result = conch
+            .execute(
+                "echo 'no space left on device' >&2",
+                ResourceLimits::default(),
+            )
+            .await
+            .expect("execute failed");
+        assert!(!result.fs_exceeded);
+    }
+
+    #[tokio::test]
+    async fn test_max_script_bytes() {
+        let conch = conch();
+        let limits = ResourceLimits {
+            max_script_bytes: 16,
+            ..ResourceLimits::default()
+        };
+
+        let result = conch.execute("echo ok", limits.clone()).await;
+        assert_eq!(result.expect("execute failed").stdout, b"ok\n");
+        let err = conch
+            .execute("echo this script is too long", limits)
+            .await
+            .expect_err("long script ran");
+        assert!(
+            matches!(err, RuntimeError::ScriptTooLarge { len: 28, max: 16 }),
+            "{err}"
+        );
+    }
+
+    #[tokio::test]
+    async fn test_output_truncation_totals() {
+        let conch = conch();
+        let limits = ResourceLimits {
+            max_output_bytes: 100,
+            ..ResourceLimits::default()
+        };
+
+        let result = conch
+            .execute("printf '%1000s' ''; echo oops >&2", limits)
+            .await
+            .expect("execute failed");
+        assert_eq!(result.exit_code, 0);
+        assert!(result.truncated);
+        assert_eq!(result.stdout.len(), 100);
+        assert_eq!(result.stdout_total_len, 1000);
+        assert_eq!(result.stderr, b"oops\n");
+        assert_eq!(result.stderr_total_len, 5);
+    }
+
+    #[tokio::test]
+    async fn test_printf_builtin() {
+        let conch = conch();
+        let limits = ResourceLimits::default();
+
+        let result = conch
+            .execute(
+                r#"printf '%-4s|%03d\n' a 7 bb 42; printf -v q '%q' 'it is'; printf '%s %b\n' "$q" 'x\ty'"#,
+                limits,
+            )
+            .await
+            .expect("execute failed");
+        assert_eq!(
+            result.exit_code,
+            0,
+            "stderr: {}",
+            String::from_utf8_lossy(&result.stderr)
+        );
+        assert_eq!(
+            String::from_utf8_lossy(&result.stdout),
+            "a   |007\nbb  |042\nit\\ is x\ty\n"
+        );
+    }
+
+    #[tokio::test]
+    async fn test_builtin_timeout() {
+        let conch = conch();
+        let limits = ResourceLimits {
+            builtin_timeout_ms: 200,
+            ..ResourceLimits::default()
+        };
+
+        // Filters that loop without output are stopped too, and the script
+        // cannot lift the limit.
+        let result = conch
+            .execute(
+                r#"printf '%s\n' a b c | grep b; echo "grep $?"
+jq -n 'last(range(1e10))'; echo "jq $?"
+CONCH_BUILTIN_TIMEOUT=0
+jq -n 'reduce range(1e10) as $x (0; .+1)'; echo "jq $?""#,
+                limits,
+            )
+            .await
+            .expect("execute failed");
+        assert_eq!(
+            String::from_utf8_lossy(&result.stdout),
+            "b\ngrep 0\njq 124\njq 124\n"
+        );
+        let stderr = String::from_utf8_lossy(&result.stderr);
+        assert!(
+            stderr.contains("conch: jq: time limit of 0.2s exceeded"),
+            "stderr: {}",
+            stderr
+        );
+    }
+
+    #[tokio::test]
+    async fn test_regex_limits() {
+        let conch = conch();
+        let limits = ResourceLimits {
+            max_regex_pattern_bytes: 8,
+            ..ResourceLimits::default()
+        };
+
+        let result = conch
+            .execute(
+                r#"echo abc | grep 'a[b-z]*c'; echo "grep $?"
+CONCH_REGEX_MAX_PATTERN=0
+echo abc | grep 'abcdefghij'; echo "grep $?""#,
+                limits,
+            )
+            .await
+            .expect("execute failed");
+        assert_eq!(
+            String::from_utf8_lossy(&result.stdout),
+            "abc\ngrep 0\ngrep 2\n"
+        );
+        let reported: Vec<_> = result
+            .diagnostics
+            .iter()
+            .map(|d| (d.category.as_str(), d.command.as_str()))
+            .collect();
+        assert_eq!(reported, [("pattern-too-complex", "grep")]);
+    }
+
+    #[tokio::test]
+    async fn test_shell_features_probes() {
+        let conch = conch();
+
+        for feature in crate::SHELL_FEATURES {
+            let result = conch
+                .execute(feature.probe, ResourceLimits::default())
+                .await
+                .expect("execute failed");
+            let works = result.exit_code == 0 && result.stdout == feature.expected.as_bytes();
+            assert_eq!(
+                works,
+                feature.supported,
+                "feature {}: exit {}, stdout {:?}, stderr {:?}",
+                feature.name,
+                result.exit_code,
+                String::from_utf8_lossy(&result.stdout),
+                String::from_utf8_lossy(&result.stderr)
+            );
+        }
+    }
+
     #[tokio::test]
     async fn test_head_lines() {
         let conch = conch();
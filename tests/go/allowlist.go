@@ -0,0 +1,85 @@
+package conch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"unsafe"
+)
+
+// ErrScriptNotAllowed is returned when Config.AllowedScriptHashes does not
+// include the script being executed.
+var ErrScriptNotAllowed = errors.New("script is not in the allowed script hashes")
+
+// ScriptHash returns the hex SHA-256 of script, as listed in
+// Config.AllowedScriptHashes.
+func ScriptHash(script string) string {
+	sum := sha256.Sum256([]byte(script))
+	return hex.EncodeToString(sum[:])
+}
+
+// scriptAllowlist builds the set of hashes, lowercased, rejecting any that is
+// not a hex SHA-256.
+func scriptAllowlist(hashes []string) (map[string]struct{}, error) {
+	set := make(map[string]struct{}, len(hashes))
+	for _, h := range hashes {
+		h = strings.ToLower(h)
+		if b, err := hex.DecodeString(h); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid script hash %q: want %d hex characters", h, 2*sha256.Size)
+		}
+		set[h] = struct{}{}
+	}
+	return set, nil
+}
+
+// checkAllowed rejects script unless the executor has no allowlist or the
+// allowlist includes it.
+func (e *Executor) checkAllowed(script string) error {
+	if e.allowed == nil {
+		return nil
+	}
+	if _, ok := e.allowed[ScriptHash(script)]; !ok {
+		return ErrScriptNotAllowed
+	}
+	return nil
+}
+
+// setAllowedCommands restricts the commands scripts may run to names; see
+// Config.AllowedCommands. It is called by New, before the executor is
+// shared.
+func (e *Executor) setAllowedCommands(names []string) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.handle == 0 {
+		return errors.New("executor is closed")
+	}
+	if err := e.lib.allowedCommands.load(); err != nil {
+		return err
+	}
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	// The list must not be null, or the library would allow every command.
+	ptrs := make([]uintptr, len(names), len(names)+1)
+	for i, name := range names {
+		s, err := cString(name)
+		if err != nil {
+			return fmt.Errorf("command %q: %w", name, err)
+		}
+		defer freeString(s)
+		ptrs[i] = pinBytes(&pinner, s.b)
+	}
+	list := ptrs[:cap(ptrs)]
+	pinner.Pin(&list[0])
+
+	ebuf := newErrorBuffer()
+	if e.lib.executorSetAllowedCommands(e.handle, uintptr(unsafe.Pointer(&list[0])), uintptr(len(names)), ebuf.ptr(), errorBufferSize) != 0 {
+		return fmt.Errorf("failed to set allowed commands: %s", ebuf)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package conch
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestScriptHash(t *testing.T) {
+	// printf 'echo hi' | sha256sum
+	const want = "56a79f3b115448072387c2480044bfa2cf8f90e4f5fddd8c943b4e051b81f80b"
+	if got := ScriptHash("echo hi"); got != want {
+		t.Errorf("ScriptHash() = %q, want %q", got, want)
+	}
+}
+
+func TestScriptAllowlist(t *testing.T) {
+	h := ScriptHash("echo hi")
+	set, err := scriptAllowlist([]string{strings.ToUpper(h)})
+	if err != nil {
+		t.Fatalf("scriptAllowlist() error: %v", err)
+	}
+	e := &Executor{allowed: set}
+	if err := e.checkAllowed("echo hi"); err != nil {
+		t.Errorf("checkAllowed(listed) error: %v", err)
+	}
+	if err := e.checkAllowed("echo hi "); !errors.Is(err, ErrScriptNotAllowed) {
+		t.Errorf("checkAllowed(unlisted) error = %v, want ErrScriptNotAllowed", err)
+	}
+	if err := (&Executor{}).checkAllowed("anything"); err != nil {
+		t.Errorf("checkAllowed() without allowlist error: %v", err)
+	}
+
+	for _, bad := range []string{"", "abc", h[:62], h[:63] + "g"} {
+		if _, err := scriptAllowlist([]string{bad}); err == nil {
+			t.Errorf("scriptAllowlist(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestAllowedScriptHashes(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded(), WithAllowedScriptHashes(ScriptHash("echo ok")))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	if _, err := exec.Execute("echo ok"); err != nil {
+		t.Errorf("Execute(allowed) error: %v", err)
+	}
+	if _, err := exec.Execute("echo adhoc"); !errors.Is(err, ErrScriptNotAllowed) {
+		t.Errorf("Execute(adhoc) error = %v, want ErrScriptNotAllowed", err)
+	}
+}
+
+func TestAllowedCommands(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	var handled []string
+	exec, err := New(
+		WithEmbedded(),
+		WithAllowedCommands("jq", "greet"),
+		WithCommandNotFound(func(name string, args []string) (bool, CommandResult) {
+			handled = append(handled, name)
+			return true, CommandResult{Stdout: []byte(name + "\n")}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.Execute(`echo '{"a":1}' | jq -c .a; greet; other; echo "other=$?"; tool list; echo "tool=$?"`)
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if got, want := string(result.Stdout), "1\ngreet\nother=127\ntool=127\n"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+	if len(handled) != 1 || handled[0] != "greet" {
+		t.Errorf("handled = %v, want only greet", handled)
+	}
+}
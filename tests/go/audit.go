@@ -0,0 +1,376 @@
+package conch
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEventType is the kind of an AuditEvent.
+type AuditEventType string
+
+const (
+	// AuditExecutionStarted is recorded when an execution is requested,
+	// before the policy is consulted.
+	AuditExecutionStarted AuditEventType = "execution.started"
+	// AuditCommand is recorded for every command serviced outside the
+	// sandbox, by host commands or OnCommandNotFound, after it ran.
+	AuditCommand AuditEventType = "command"
+	// AuditConnect is recorded for every connection and name lookup a
+	// spawned command attempts while a NetworkPolicy is set, allowed or
+	// not.
+	AuditConnect AuditEventType = "connect"
+	// AuditExecutionFinished is recorded when an execution returns,
+	// successfully or not.
+	AuditExecutionFinished AuditEventType = "execution.finished"
+)
+
+// Limits reported in AuditEvent.Limits.
+const (
+	AuditLimitTimeout   = "timeout"
+	AuditLimitOutput    = "output"
+	AuditLimitCallDepth = "call-depth"
+	AuditLimitFS        = "fs"
+)
+
+// AuditEvent is one entry of an executor's audit trail.
+type AuditEvent struct {
+	Time time.Time      `json:"time"`
+	Type AuditEventType `json:"type"`
+	// ExecutionID is the execution's ID, as in Result.ID. Executions
+	// without one, such as Execute, get one generated for the trail, which
+	// their command events do not carry.
+	ExecutionID string `json:"execution_id,omitempty"`
+	// ScriptSHA256 is the hex SHA-256 of the script, identifying it
+	// without recording its contents.
+	ScriptSHA256 string `json:"script_sha256,omitempty"`
+	// Command and Args are the command of an AuditCommand event.
+	Command string   `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	// Network, Address and Denied describe the connection of an
+	// AuditConnect event: "tcp" or "udp" and the "ip:port" it was to, or
+	// "dns" and the name looked up, and whether the NetworkPolicy refused
+	// it.
+	Network string `json:"network,omitempty"`
+	Address string `json:"address,omitempty"`
+	Denied  bool   `json:"denied,omitempty"`
+	// Summary is the outcome of a command or execution, unless it failed
+	// without producing a result.
+	Summary *AuditSummary `json:"summary,omitempty"`
+	// Limits lists the resource limits the execution hit, such as
+	// AuditLimitTimeout.
+	Limits []string `json:"limits,omitempty"`
+	// Error is the error the execution returned.
+	Error string `json:"error,omitempty"`
+	// Labels are the executor's labels; see WithLabels.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Sources lists the libraries sourced ahead of the script by
+	// Session.Source, in order, on the AuditExecutionStarted event.
+	Sources []AuditSource `json:"sources,omitempty"`
+}
+
+// AuditSource is a library sourced into an execution, identifying the
+// functions it defines so they can be attributed to it.
+type AuditSource struct {
+	// Name is the library's name as given to Session.Source.
+	Name string `json:"name"`
+	// SHA256 is the hex SHA-256 of the library's source.
+	SHA256 string `json:"sha256"`
+	// Functions are the functions the library defines; see
+	// Session.Source.
+	Functions []string `json:"functions,omitempty"`
+}
+
+// AuditSummary is the outcome recorded for a command or execution.
+type AuditSummary struct {
+	ExitCode    int   `json:"exit_code"`
+	StdoutBytes int   `json:"stdout_bytes"`
+	StderrBytes int   `json:"stderr_bytes"`
+	Truncated   bool  `json:"truncated,omitempty"`
+	DurationMs  int64 `json:"duration_ms"`
+}
+
+// AuditSink receives an executor's audit events as they happen. Commands
+// are recorded from native threads while their execution runs, so Record
+// must be safe for concurrent use. It should not block for long: it runs
+// on the execution's path.
+//
+// The bindings only see what crosses into Go: executions, their outcome and
+// the commands serviced outside the sandbox, and the connections and name
+// lookups of spawned commands while a NetworkPolicy is set. File access inside the virtual
+// filesystem is not reported.
+type AuditSink interface {
+	Record(event AuditEvent)
+}
+
+// executionAudit records one execution in the executor's stats and, if it
+// has an audit sink, its audit trail.
+type executionAudit struct {
+	exec   *Executor
+	sink   AuditSink
+	id     string
+	hash   string
+	labels map[string]string
+	start  time.Time
+}
+
+// startAudit counts script, with sources sourced ahead of it, as started
+// under id and records it, generating an ID if it is empty and there is a
+// sink. It returns the audit to finish once the execution returns.
+func (e *Executor) startAudit(id, script string, sources []sourcedScript) *executionAudit {
+	e.countStarted()
+	a := &executionAudit{exec: e, sink: e.audit, start: time.Now()}
+	if a.sink == nil {
+		return a
+	}
+	if id == "" {
+		id = newExecutionID()
+	}
+	a.id, a.hash, a.labels = id, ScriptHash(script), e.labels
+	event := AuditEvent{
+		Time:         a.start,
+		Type:         AuditExecutionStarted,
+		ExecutionID:  id,
+		ScriptSHA256: a.hash,
+		Labels:       copyLabels(a.labels),
+	}
+	for _, src := range sources {
+		event.Sources = append(event.Sources, AuditSource{
+			Name:      src.name,
+			SHA256:    src.hash,
+			Functions: append([]string(nil), src.functions...),
+		})
+	}
+	a.sink.Record(event)
+	return a
+}
+
+// finish counts and records the outcome of the execution.
+func (a *executionAudit) finish(result *Result, err error) {
+	a.exec.countFinished(err)
+	if a.sink == nil {
+		return
+	}
+	now := time.Now()
+	event := AuditEvent{
+		Time:         now,
+		Type:         AuditExecutionFinished,
+		ExecutionID:  a.id,
+		ScriptSHA256: a.hash,
+		Labels:       copyLabels(a.labels),
+		Limits:       limitsHit(result, err),
+	}
+	var trapErr *TrapError
+	if result == nil && errors.As(err, &trapErr) {
+		result = trapErr.Result
+	}
+	if result != nil {
+		event.Summary = &AuditSummary{
+			ExitCode:    result.ExitCode,
+			StdoutBytes: result.StdoutTotalLen,
+			StderrBytes: result.StderrTotalLen,
+			Truncated:   result.Truncated,
+			DurationMs:  now.Sub(a.start).Milliseconds(),
+		}
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	a.sink.Record(event)
+}
+
+// limitsHit returns the resource limits an execution returning result and
+// err ran into.
+func limitsHit(result *Result, err error) []string {
+	var limits []string
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrDepthExceeded):
+			limits = append(limits, AuditLimitCallDepth)
+		case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+			limits = append(limits, AuditLimitTimeout)
+		}
+	}
+	if result != nil {
+		if result.Truncated {
+			limits = append(limits, AuditLimitOutput)
+		}
+		if result.FSExceeded {
+			limits = append(limits, AuditLimitFS)
+		}
+	}
+	return limits
+}
+
+// auditCommandHandler returns a commandHandler recording every command
+// next handles to sink, carrying labels.
+func auditCommandHandler(sink AuditSink, labels map[string]string, next commandHandler) commandHandler {
+	return func(ctx context.Context, name string, args []string, stdin []byte) (bool, CommandResult) {
+		start := time.Now()
+		handled, result := next(ctx, name, args, stdin)
+		if handled {
+			now := time.Now()
+			sink.Record(AuditEvent{
+				Time:        now,
+				Type:        AuditCommand,
+				ExecutionID: ExecutionIDFromContext(ctx),
+				Labels:      copyLabels(labels),
+				Command:     name,
+				Args:        args,
+				Summary: &AuditSummary{
+					ExitCode:    result.ExitCode,
+					StdoutBytes: len(result.Stdout),
+					StderrBytes: len(result.Stderr),
+					DurationMs:  now.Sub(start).Milliseconds(),
+				},
+			})
+		}
+		return handled, result
+	}
+}
+
+// auditConnectHandler returns a connectHandler recording every connection
+// next is asked about to sink, carrying labels.
+func auditConnectHandler(sink AuditSink, labels map[string]string, next connectHandler) connectHandler {
+	return func(c connectRequest) bool {
+		allowed := next(c)
+		sink.Record(AuditEvent{
+			Time:        time.Now(),
+			Type:        AuditConnect,
+			ExecutionID: c.executionID,
+			Labels:      copyLabels(labels),
+			Command:     c.command,
+			Network:     c.network,
+			Address:     c.address(),
+			Denied:      !allowed,
+		})
+		return allowed
+	}
+}
+
+// JSONLinesSink is an AuditSink writing one JSON object per line. Each line
+// carries a "prev_hash" field, the hex SHA-256 of the line before it (empty
+// for the first), so editing, removing or reordering lines breaks the chain
+// that VerifyJSONLines checks.
+type JSONLinesSink struct {
+	mu   sync.Mutex
+	w    io.Writer
+	c    io.Closer
+	prev string
+	err  error
+}
+
+// auditLine is an AuditEvent as written by JSONLinesSink.
+type auditLine struct {
+	AuditEvent
+	PrevHash string `json:"prev_hash"`
+}
+
+// NewJSONLinesSink returns a sink writing to w, starting a new chain.
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{w: w}
+}
+
+// OpenJSONLinesSink opens the file at path for appending, creating it if
+// needed, and continues the chain of the lines already in it. Close the sink
+// to close the file.
+func OpenJSONLinesSink(path string) (*JSONLinesSink, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := lastLineHash(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return &JSONLinesSink{w: f, c: f, prev: prev}, nil
+}
+
+// lastLineHash returns the hash of the last line in r, or "" if it is empty.
+func lastLineHash(r io.Reader) (string, error) {
+	var prev string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		prev = lineHash(scanner.Bytes())
+	}
+	return prev, scanner.Err()
+}
+
+// lineHash returns the hex SHA-256 of line, without its newline.
+func lineHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// Record writes event. After a write fails, events are dropped and the
+// error is reported by Err and Close.
+func (s *JSONLinesSink) Record(event AuditEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	line, err := json.Marshal(auditLine{AuditEvent: event, PrevHash: s.prev})
+	if err != nil {
+		s.err = err
+		return
+	}
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		s.err = err
+		return
+	}
+	s.prev = lineHash(line)
+}
+
+// Err returns the first error writing the trail, if any.
+func (s *JSONLinesSink) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close closes the file opened by OpenJSONLinesSink and returns the first
+// error writing the trail.
+func (s *JSONLinesSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.c != nil {
+		if err := s.c.Close(); err != nil && s.err == nil {
+			s.err = err
+		}
+		s.c = nil
+	}
+	return s.err
+}
+
+// VerifyJSONLines checks the hash chain of a trail written by
+// JSONLinesSink, returning an error naming the first line that does not
+// follow from the one before it.
+func VerifyJSONLines(r io.Reader) error {
+	var prev string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for n := 1; scanner.Scan(); n++ {
+		var line struct {
+			PrevHash *string `json:"prev_hash"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return fmt.Errorf("audit line %d: %w", n, err)
+		}
+		if line.PrevHash == nil || *line.PrevHash != prev {
+			return fmt.Errorf("audit line %d: hash chain broken", n)
+		}
+		prev = lineHash(scanner.Bytes())
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,202 @@
+package conch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// auditRecorder is an AuditSink keeping every event.
+type auditRecorder struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (r *auditRecorder) Record(event AuditEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *auditRecorder) get() []AuditEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]AuditEvent(nil), r.events...)
+}
+
+func TestJSONLinesSinkChain(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewJSONLinesSink(&buf)
+	for i := 0; i < 3; i++ {
+		sink.Record(AuditEvent{Type: AuditCommand, Command: fmt.Sprint("cmd", i)})
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	trail := buf.String()
+	if n := strings.Count(trail, "\n"); n != 3 {
+		t.Fatalf("trail has %d lines, want 3:\n%s", n, trail)
+	}
+	if err := VerifyJSONLines(strings.NewReader(trail)); err != nil {
+		t.Fatalf("VerifyJSONLines() error: %v", err)
+	}
+
+	tampered := strings.Replace(trail, "cmd1", "cmdX", 1)
+	if err := VerifyJSONLines(strings.NewReader(tampered)); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("VerifyJSONLines(edited) error = %v, want line 3", err)
+	}
+	lines := strings.SplitAfter(trail, "\n")
+	removed := lines[0] + lines[2]
+	if err := VerifyJSONLines(strings.NewReader(removed)); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("VerifyJSONLines(removed) error = %v, want line 2", err)
+	}
+}
+
+func TestOpenJSONLinesSinkContinuesChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	for i := 0; i < 2; i++ {
+		sink, err := OpenJSONLinesSink(path)
+		if err != nil {
+			t.Fatalf("OpenJSONLinesSink() error: %v", err)
+		}
+		sink.Record(AuditEvent{Type: AuditExecutionStarted, ExecutionID: fmt.Sprint(i)})
+		if err := sink.Close(); err != nil {
+			t.Fatalf("Close() error: %v", err)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := VerifyJSONLines(f); err != nil {
+		t.Errorf("VerifyJSONLines() error: %v", err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestJSONLinesSinkWriteError(t *testing.T) {
+	sink := NewJSONLinesSink(failingWriter{})
+	sink.Record(AuditEvent{Type: AuditCommand})
+	if err := sink.Err(); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("Err() = %v, want disk full", err)
+	}
+}
+
+func TestAuditCommandHandler(t *testing.T) {
+	rec := &auditRecorder{}
+	h := auditCommandHandler(rec, map[string]string{"team": "search"}, func(_ context.Context, name string, args []string, _ []byte) (bool, CommandResult) {
+		if name != "known" {
+			return false, CommandResult{}
+		}
+		return true, CommandResult{ExitCode: 3, Stdout: []byte("out")}
+	})
+
+	ctx := context.WithValue(context.Background(), executionIDKey{}, "exec-1")
+	h(ctx, "unknown", nil, nil)
+	if handled, result := h(ctx, "known", []string{"a"}, nil); !handled || result.ExitCode != 3 {
+		t.Errorf("handler = %v, %+v", handled, result)
+	}
+	events := rec.get()
+	if len(events) != 1 {
+		t.Fatalf("recorded %d events, want 1", len(events))
+	}
+	e := events[0]
+	if e.Type != AuditCommand || e.Command != "known" || !reflect.DeepEqual(e.Args, []string{"a"}) || e.ExecutionID != "exec-1" ||
+		e.Labels["team"] != "search" {
+		t.Errorf("event = %+v", e)
+	}
+	if e.Summary == nil || e.Summary.ExitCode != 3 || e.Summary.StdoutBytes != 3 {
+		t.Errorf("summary = %+v", e.Summary)
+	}
+}
+
+func TestLimitsHit(t *testing.T) {
+	tests := []struct {
+		result *Result
+		err    error
+		want   []string
+	}{
+		{&Result{}, nil, nil},
+		{nil, ErrDepthExceeded, []string{AuditLimitCallDepth}},
+		{nil, fmt.Errorf("execution interrupted: %w", context.DeadlineExceeded), []string{AuditLimitTimeout}},
+		{nil, fmt.Errorf("execution failed: %w", ErrTimeout), []string{AuditLimitTimeout}},
+		{&Result{Truncated: true, FSExceeded: true}, nil, []string{AuditLimitOutput, AuditLimitFS}},
+		// What a script writes or exits with claims nothing.
+		{&Result{Stderr: []byte("cp: no space left on device")}, nil, nil},
+		{nil, errors.New("execution failed: WASM error: timeout in guest"), nil},
+	}
+	for _, tt := range tests {
+		if got := limitsHit(tt.result, tt.err); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("limitsHit(%+v, %v) = %q, want %q", tt.result, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestExecutionAudit(t *testing.T) {
+	rec := &auditRecorder{}
+	e := &Executor{audit: rec, labels: map[string]string{"tenant": "acme"}}
+	a := e.startAudit("", "echo hi", nil)
+	a.finish(&Result{ExitCode: 1, StdoutTotalLen: 3}, nil)
+
+	events := rec.get()
+	if len(events) != 2 {
+		t.Fatalf("recorded %d events, want 2", len(events))
+	}
+	start, end := events[0], events[1]
+	if start.Type != AuditExecutionStarted || end.Type != AuditExecutionFinished {
+		t.Errorf("types = %s, %s", start.Type, end.Type)
+	}
+	if start.ExecutionID == "" || start.ExecutionID != end.ExecutionID {
+		t.Errorf("execution IDs = %q, %q", start.ExecutionID, end.ExecutionID)
+	}
+	if start.Labels["tenant"] != "acme" || end.Labels["tenant"] != "acme" {
+		t.Errorf("labels = %v, %v", start.Labels, end.Labels)
+	}
+	if start.ScriptSHA256 != ScriptHash("echo hi") {
+		t.Errorf("ScriptSHA256 = %q", start.ScriptSHA256)
+	}
+	if end.Summary == nil || end.Summary.ExitCode != 1 || end.Summary.StdoutBytes != 3 {
+		t.Errorf("summary = %+v", end.Summary)
+	}
+
+	// Without a sink nothing is recorded and finish is a no-op.
+	(&Executor{}).startAudit("", "echo hi", nil).finish(nil, nil)
+}
+
+func TestAuditSinkRecordsExecution(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	rec := &auditRecorder{}
+	exec, err := New(WithEmbedded(), WithAuditSink(rec))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.ExecuteContext(context.Background(), "echo hello")
+	if err != nil {
+		t.Fatalf("ExecuteContext() error: %v", err)
+	}
+	events := rec.get()
+	if len(events) != 2 {
+		t.Fatalf("recorded %d events, want 2: %+v", len(events), events)
+	}
+	end := events[1]
+	if end.ExecutionID != result.ID {
+		t.Errorf("ExecutionID = %q, want %q", end.ExecutionID, result.ID)
+	}
+	if end.Summary == nil || end.Summary.StdoutBytes != len("hello\n") {
+		t.Errorf("summary = %+v", end.Summary)
+	}
+}
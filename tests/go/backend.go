@@ -0,0 +1,492 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// ShellExecutor is implemented by everything that runs shell scripts:
+// Executor itself and the wrappers layered on top of it. Code that only needs
+// to run scripts should accept a ShellExecutor rather than a concrete type.
+type ShellExecutor interface {
+	// Execute runs a script with default resource limits.
+	Execute(script string) (*Result, error)
+	// ExecuteContext runs a script, stopping it when ctx is done.
+	ExecuteContext(ctx context.Context, script string) (*Result, error)
+	// ExecuteWithStdin runs a script with stdin as its standard input.
+	ExecuteWithStdin(script string, stdin []byte) (*Result, error)
+	// Close releases the underlying resources.
+	Close() error
+}
+
+var _ ShellExecutor = (*Executor)(nil)
+
+// ErrNoBackend is returned by NewDefault when neither the embedded shell nor
+// a shell component file is available.
+var ErrNoBackend = errors.New("no shell backend available")
+
+// componentName is the file name of the shell WASM component.
+const componentName = "conch_shell.wasm"
+
+// ComponentPathEnv names the environment variable listing extra locations to
+// search for the shell component, separated by os.PathListSeparator.
+const ComponentPathEnv = "CONCH_COMPONENT_PATH"
+
+var (
+	searchPathsMu        sync.RWMutex
+	componentSearchPaths []string
+)
+
+// SetComponentSearchPaths sets the locations searched first for the shell
+// component, replacing any previously set. Each entry may be a component file
+// or a directory containing conch_shell.wasm. Pass nil to clear.
+func SetComponentSearchPaths(paths []string) {
+	searchPathsMu.Lock()
+	defer searchPathsMu.Unlock()
+	componentSearchPaths = append([]string(nil), paths...)
+}
+
+// ComponentSearchPaths returns the candidate component files in the order
+// they are searched:
+//
+//  1. entries set with SetComponentSearchPaths
+//  2. entries in $CONCH_COMPONENT_PATH
+//  3. the directory containing the running executable
+//  4. the repository's target/ build outputs (for development)
+//
+// Directory entries are expanded to the conch_shell.wasm inside them.
+func ComponentSearchPaths() []string {
+	searchPathsMu.RLock()
+	configured := append([]string(nil), componentSearchPaths...)
+	searchPathsMu.RUnlock()
+
+	if env := os.Getenv(ComponentPathEnv); env != "" {
+		configured = append(configured, filepath.SplitList(env)...)
+	}
+	if exe, err := os.Executable(); err == nil {
+		configured = append(configured, filepath.Dir(exe))
+	}
+
+	var searchPaths []string
+	for _, path := range configured {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			path = filepath.Join(path, componentName)
+		}
+		searchPaths = append(searchPaths, path)
+	}
+
+	// Get the directory of this source file for relative paths
+	_, thisFile, _, _ := runtime.Caller(0)
+	testDir := filepath.Dir(thisFile)
+	repoRoot := filepath.Join(testDir, "..", "..")
+
+	return append(searchPaths,
+		filepath.Join(repoRoot, "target", "wasm32-wasip2", "release", componentName),
+		filepath.Join(repoRoot, "target", "wasm32-wasip2", "release-wasm", componentName),
+		filepath.Join(repoRoot, "target", "wasm32-wasip2", "debug", componentName),
+	)
+}
+
+// findComponent searches ComponentSearchPaths for the shell WASM component.
+func findComponent() (string, error) {
+	searchPaths := ComponentSearchPaths()
+	for _, path := range searchPaths {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("component %s not found in search paths: %v", componentName, searchPaths)
+}
+
+// Backend identifies where an executor's shell component came from.
+type Backend int
+
+const (
+	// BackendAuto lets New pick the first backend that works; see Config.
+	BackendAuto Backend = iota
+	// BackendEmbedded uses the shell compiled into the library
+	// (embedded-shell feature).
+	BackendEmbedded
+	// BackendBytes loads the shell component from Config.ComponentBytes.
+	BackendBytes
+	// BackendFile loads the shell component from Config.ComponentPath, or
+	// from the component search paths if no path is given.
+	BackendFile
+)
+
+// String returns the backend name.
+func (b Backend) String() string {
+	switch b {
+	case BackendAuto:
+		return "auto"
+	case BackendEmbedded:
+		return "embedded"
+	case BackendBytes:
+		return "bytes"
+	case BackendFile:
+		return "file"
+	default:
+		return fmt.Sprintf("Backend(%d)", int(b))
+	}
+}
+
+// Config selects the backend used by New.
+//
+// With BackendAuto, backends are tried in this order, skipping any that are
+// not configured or not available:
+//
+//  1. BackendBytes, if ComponentBytes is set
+//  2. BackendFile, if ComponentPath is set
+//  3. BackendEmbedded, if the library was built with the embedded shell
+//  4. BackendFile, using the component search paths
+//
+// With any other Backend only that backend is tried, unless Fallback is set,
+// in which case the Auto order is used for the remaining backends after it
+// fails.
+type Config struct {
+	// Backend is the backend to use. Defaults to BackendAuto.
+	Backend Backend
+	// Fallback continues down the Auto order if Backend fails.
+	Fallback bool
+	// ComponentPath is the shell component file for BackendFile.
+	ComponentPath string
+	// ComponentBytes is the shell component for BackendBytes.
+	ComponentBytes []byte
+	// InitScript sets up the shell of every execution: aliases, functions,
+	// set -euo pipefail and similar. It runs once, with empty stdin and
+	// default limits, and the variables, functions, options and directory it
+	// leaves are restored before each caller's script; files it writes are
+	// not kept. New runs it and returns an error wrapping ErrInitScript if it
+	// fails.
+	InitScript string
+	// OnCommandNotFound, if set, services commands the sandbox has no
+	// implementation for, instead of the shell's generic failure.
+	OnCommandNotFound CommandNotFoundFunc
+	// OnCommandNotFoundContext is OnCommandNotFound for handlers that need
+	// the execution's context, such as for request-scoped values. At most
+	// one of the two may be set.
+	OnCommandNotFoundContext CommandNotFoundContextFunc
+	// HostCommands, if set, runs the allowlisted commands as host
+	// subprocesses instead of inside the sandbox, killing them if the
+	// execution's context is done or they run past its limits. Commands it
+	// does not list still go to OnCommandNotFound.
+	HostCommands *HostCommandConfig
+	// HTTPFixtures, if set, answers curl from canned responses instead of
+	// the network. Commands other than curl go to HostCommands and
+	// OnCommandNotFound as usual.
+	HTTPFixtures *HTTPFixtures
+	// HostHTTP, if set, sends curl's requests from the host, through its
+	// proxy and trusting its certificate authorities, instead of the
+	// sandbox's networking. Its connections are checked against the
+	// NetworkPolicy and recorded to the AuditSink. HTTPFixtures takes
+	// precedence.
+	HostHTTP *HostHTTPConfig
+	// OnPrompt, if set, supplies input to scripts that read stdin when the
+	// execution was given none, so read and similar block on the callback
+	// instead of seeing end of file. Their stdin reports as a terminal.
+	OnPrompt PromptFunc
+	// OnPromptContext is OnPrompt for handlers that need the execution's
+	// context, such as to stop waiting for input when it is cancelled. At
+	// most one of the two may be set.
+	OnPromptContext PromptContextFunc
+	// NetworkPolicy, if set, decides which connections commands spawned by
+	// scripts may open and which names they may resolve, limits the
+	// requests sent through HostHTTP, and records every attempt to the
+	// AuditSink.
+	NetworkPolicy *NetworkPolicy
+	// Limits, if set, replaces DefaultLimits for executions not given their
+	// own; see Executor.Limits.
+	Limits *ResourceLimits
+	// Mounts are seeded into the virtual filesystem of every execution.
+	Mounts []Mount
+	// Tmp, if not zero, configures the /tmp of every execution; see
+	// Executor.SetTmp.
+	Tmp TmpConfig
+	// Policy, if set, is asked before every script and every command that
+	// leaves the sandbox runs. Executions it denies fail with an error
+	// wrapping ErrPolicyDenied; denied commands fail inside the script. The
+	// InitScript is trusted and not evaluated.
+	Policy PolicyEvaluator
+	// AuditSink, if set, records every execution and every command that
+	// leaves the sandbox; see AuditSink. The InitScript is not recorded,
+	// though commands it runs are.
+	AuditSink AuditSink
+	// AllowedScriptHashes, if not nil, lists the hex SHA-256 (see
+	// ScriptHash) of the only scripts that may execute; any other fails with
+	// ErrScriptNotAllowed before it starts. An empty list allows nothing.
+	// The InitScript is trusted and not checked. For decisions a fixed list
+	// cannot express, use Policy.
+	AllowedScriptHashes []string
+	// AllowedCommands, if not nil, lists the only commands scripts may run:
+	// the sandbox's coreutils, conch builtins such as jq and tool, host
+	// commands and those for OnCommandNotFound. Others fail as not found,
+	// including in the InitScript. The shell's own builtins, such as echo
+	// and cd, are always available. An empty list allows none of the rest.
+	AllowedCommands []string
+	// DedicatedThread runs the executor's executions, including the
+	// InitScript, on an OS thread of its own, locked with
+	// runtime.LockOSThread, so native thread-local state stays consistent
+	// between calls. Executions on the executor are then serialized, and
+	// each pays a hand-off to the thread of a few microseconds, small next
+	// to instantiating the shell; see BenchmarkDedicatedThread.
+	DedicatedThread bool
+	// SubmitWorkers is the number of scripts started with Submit that run
+	// at once; further ones wait. Defaults to GOMAXPROCS.
+	SubmitWorkers int
+	// OnProgress, if set, receives the progress scripts report by running
+	// ProgressCommand. Progress reports bypass Policy and AuditSink.
+	OnProgress ProgressFunc
+	// Watchdog, if set, flags executions that stop making progress; see
+	// Watchdog.
+	Watchdog *Watchdog
+	// Labels tag the executor, such as with the tenant or team it serves,
+	// so observability can be sliced by them: they are recorded on the
+	// native library's log of every execution, on every AuditEvent, and in
+	// Executor.Stats. Names are letters, digits, '_', '.', '-' and '/'.
+	Labels map[string]string
+}
+
+// backendAttempt is one step of the backend fallback chain.
+type backendAttempt struct {
+	backend Backend
+	create  func() (*Executor, error)
+}
+
+// attempts returns the backend chain for cfg in the order it should be tried.
+func (cfg Config) attempts() []backendAttempt {
+	bytes := backendAttempt{BackendBytes, func() (*Executor, error) {
+		return NewExecutorFromBytes(cfg.ComponentBytes)
+	}}
+	file := backendAttempt{BackendFile, func() (*Executor, error) {
+		return NewExecutor(cfg.ComponentPath)
+	}}
+	embedded := backendAttempt{BackendEmbedded, NewExecutorEmbedded}
+	search := backendAttempt{BackendFile, func() (*Executor, error) {
+		path, err := findComponent()
+		if err != nil {
+			return nil, err
+		}
+		return NewExecutor(path)
+	}}
+
+	var auto []backendAttempt
+	if len(cfg.ComponentBytes) > 0 {
+		auto = append(auto, bytes)
+	}
+	if cfg.ComponentPath != "" {
+		auto = append(auto, file)
+	}
+	auto = append(auto, embedded, search)
+
+	var first backendAttempt
+	switch cfg.Backend {
+	case BackendAuto:
+		return auto
+	case BackendEmbedded:
+		first = embedded
+	case BackendBytes:
+		first = bytes
+	case BackendFile:
+		first = file
+		if cfg.ComponentPath == "" {
+			first = search
+		}
+	default:
+		return nil
+	}
+
+	if !cfg.Fallback {
+		return []backendAttempt{first}
+	}
+	chain := []backendAttempt{first}
+	for _, a := range auto {
+		if a.backend != first.backend {
+			chain = append(chain, a)
+		}
+	}
+	return chain
+}
+
+// New creates an executor configured by opts, which are applied in order to
+// an empty Config, trying backends in the order documented on Config. Use
+// Executor.Backend to see which one was chosen. New is the preferred
+// constructor; the NewExecutor variants each cover a single backend.
+//
+// If every backend fails, the returned error wraps ErrNoBackend and the
+// individual failures. An InitScript failure is returned as is, without
+// trying further backends.
+func New(opts ...Option) (*Executor, error) {
+	if err := Init(); err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	for _, opt := range opts {
+		opt.apply(&cfg)
+	}
+
+	attempts := cfg.attempts()
+	if len(attempts) == 0 {
+		return nil, fmt.Errorf("unknown backend %v", cfg.Backend)
+	}
+
+	var errs []error
+	for _, a := range attempts {
+		exec, err := a.create()
+		if err == nil {
+			if err := cfg.configure(exec); err != nil {
+				exec.Close()
+				return nil, err
+			}
+			return exec, nil
+		}
+		errs = append(errs, fmt.Errorf("%v: %w", a.backend, err))
+	}
+	return nil, fmt.Errorf("%w: %w", ErrNoBackend, errors.Join(errs...))
+}
+
+// configure applies the per-executor settings in cfg to exec.
+func (cfg Config) configure(exec *Executor) error {
+	if cfg.DedicatedThread {
+		exec.thread = newOSThread()
+	}
+	exec.submitWorkers = cfg.SubmitWorkers
+	if cfg.Watchdog != nil {
+		if cfg.Watchdog.Idle <= 0 {
+			return errors.New("watchdog idle period must be positive")
+		}
+		if err := exec.lib.watchdog.load(); err != nil {
+			return err
+		}
+		w := *cfg.Watchdog
+		exec.watchdog = &w
+	}
+	allowed, err := scriptAllowlist(cfg.AllowedScriptHashes)
+	if err != nil {
+		return err
+	}
+	if cfg.Limits != nil {
+		limits := *cfg.Limits
+		exec.limits = &limits
+	}
+	if len(cfg.Labels) > 0 {
+		if err := exec.setLabels(cfg.Labels); err != nil {
+			return err
+		}
+	}
+	for _, m := range cfg.Mounts {
+		if err := m.seed(exec); err != nil {
+			return fmt.Errorf("failed to mount %s: %w", m.Dir, err)
+		}
+	}
+	notFound := cfg.OnCommandNotFoundContext
+	if fn := cfg.OnCommandNotFound; fn != nil {
+		if notFound != nil {
+			return errors.New("both OnCommandNotFound and OnCommandNotFoundContext are set")
+		}
+		notFound = func(_ context.Context, name string, args []string) (bool, CommandResult) {
+			return fn(name, args)
+		}
+	}
+	var h commandHandler
+	switch {
+	case cfg.HostCommands != nil:
+		if err := cfg.HostCommands.validate(); err != nil {
+			return err
+		}
+		h = cfg.HostCommands.handler(notFound)
+	case notFound != nil:
+		h = func(ctx context.Context, name string, args []string, _ []byte) (bool, CommandResult) {
+			return notFound(ctx, name, args)
+		}
+	}
+	if cfg.HostHTTP != nil {
+		connect, err := hostConnectHandler(cfg.NetworkPolicy)
+		if err != nil {
+			return err
+		}
+		if cfg.AuditSink != nil {
+			connect = auditConnectHandler(cfg.AuditSink, exec.labels, connect)
+		}
+		if h, err = cfg.HostHTTP.handler(h, cfg.NetworkPolicy, connect); err != nil {
+			return err
+		}
+	}
+	if cfg.HTTPFixtures != nil {
+		h = cfg.HTTPFixtures.handler(h)
+	}
+	if h != nil && cfg.Policy != nil {
+		h = policyCommandHandler(cfg.Policy, cfg.HostCommands, h)
+	}
+	if h != nil && cfg.AuditSink != nil {
+		h = auditCommandHandler(cfg.AuditSink, exec.labels, h)
+	}
+	if cfg.OnProgress != nil {
+		h = progressCommandHandler(cfg.OnProgress, h)
+	}
+	if h != nil {
+		if err := exec.setCommandHandler(h); err != nil {
+			return err
+		}
+	}
+	prompt := cfg.OnPromptContext
+	if fn := cfg.OnPrompt; fn != nil {
+		if prompt != nil {
+			return errors.New("both OnPrompt and OnPromptContext are set")
+		}
+		prompt = func(_ context.Context, p string) (string, error) { return fn(p) }
+	}
+	if prompt != nil {
+		if err := exec.setPromptHandler(prompt); err != nil {
+			return err
+		}
+	}
+	if cfg.NetworkPolicy != nil {
+		c, err := cfg.NetworkPolicy.handler()
+		if err != nil {
+			return err
+		}
+		if cfg.AuditSink != nil {
+			c = auditConnectHandler(cfg.AuditSink, exec.labels, c)
+		}
+		if err := exec.setConnectHandler(c); err != nil {
+			return err
+		}
+	}
+	if cfg.AllowedCommands != nil {
+		if err := exec.setAllowedCommands(cfg.AllowedCommands); err != nil {
+			return err
+		}
+	}
+	if cfg.Tmp != (TmpConfig{}) {
+		if err := exec.SetTmp(cfg.Tmp); err != nil {
+			return err
+		}
+	}
+	if cfg.InitScript != "" {
+		if err := exec.runInitScript(cfg.InitScript); err != nil {
+			return err
+		}
+	}
+	exec.policy = cfg.Policy
+	exec.audit = cfg.AuditSink
+	if cfg.AllowedScriptHashes != nil {
+		exec.allowed = allowed
+	}
+	return nil
+}
+
+// NewDefault creates an executor using the best available backend. It is
+// equivalent to New().
+func NewDefault() (*Executor, error) {
+	return New()
+}
@@ -0,0 +1,251 @@
+package conch
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNewDefault(t *testing.T) {
+	if !IsAvailable() {
+		t.Skip("Skipping: conch library not available")
+	}
+
+	exec, err := NewDefault()
+	if errors.Is(err, ErrNoBackend) {
+		t.Skipf("Skipping: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("NewDefault() error = %v", err)
+	}
+	defer exec.Close()
+
+	var shell ShellExecutor = exec
+	result, err := shell.Execute("echo default")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if got := strings.TrimSpace(string(result.Stdout)); got != "default" {
+		t.Errorf("Stdout = %q, want %q", got, "default")
+	}
+}
+
+func TestFindComponentReportsSearchPaths(t *testing.T) {
+	path, err := findComponent()
+	if err == nil {
+		t.Logf("Component found at: %s", path)
+		return
+	}
+	if !strings.Contains(err.Error(), componentName) {
+		t.Errorf("findComponent() error = %v, want it to name %s", err, componentName)
+	}
+}
+
+func attemptBackends(attempts []backendAttempt) []Backend {
+	var backends []Backend
+	for _, a := range attempts {
+		backends = append(backends, a.backend)
+	}
+	return backends
+}
+
+func TestConfigAttempts(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want []Backend
+	}{
+		{"auto bare", Config{}, []Backend{BackendEmbedded, BackendFile}},
+		{"auto bytes", Config{ComponentBytes: []byte{0}}, []Backend{BackendBytes, BackendEmbedded, BackendFile}},
+		{"auto path", Config{ComponentPath: "x.wasm"}, []Backend{BackendFile, BackendEmbedded, BackendFile}},
+		{"embedded only", Config{Backend: BackendEmbedded}, []Backend{BackendEmbedded}},
+		{"file search", Config{Backend: BackendFile}, []Backend{BackendFile}},
+		{"embedded fallback", Config{Backend: BackendEmbedded, Fallback: true}, []Backend{BackendEmbedded, BackendFile}},
+		{"bytes fallback", Config{Backend: BackendBytes, Fallback: true, ComponentBytes: []byte{0}}, []Backend{BackendBytes, BackendEmbedded, BackendFile}},
+		{"unknown", Config{Backend: Backend(99)}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := attemptBackends(tt.cfg.attempts())
+			if len(got) != len(tt.want) {
+				t.Fatalf("attempts() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("attempts() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBackendString(t *testing.T) {
+	if got := BackendEmbedded.String(); got != "embedded" {
+		t.Errorf("BackendEmbedded.String() = %q", got)
+	}
+	if got := Backend(42).String(); got != "Backend(42)" {
+		t.Errorf("Backend(42).String() = %q", got)
+	}
+}
+
+func TestNewReportsBackend(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(Config{Backend: BackendEmbedded})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer exec.Close()
+
+	if exec.Backend() != BackendEmbedded {
+		t.Errorf("Backend() = %v, want %v", exec.Backend(), BackendEmbedded)
+	}
+}
+
+func TestNewFallback(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(Config{
+		Backend:       BackendFile,
+		ComponentPath: "/nonexistent/conch_shell.wasm",
+		Fallback:      true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer exec.Close()
+
+	if exec.Backend() != BackendEmbedded {
+		t.Errorf("Backend() = %v, want fallback to %v", exec.Backend(), BackendEmbedded)
+	}
+}
+
+func TestComponentSearchPathsOrder(t *testing.T) {
+	dir := t.TempDir()
+	envDir := t.TempDir()
+	explicit := filepath.Join(t.TempDir(), "custom.wasm")
+
+	SetComponentSearchPaths([]string{explicit, dir})
+	defer SetComponentSearchPaths(nil)
+	t.Setenv(ComponentPathEnv, envDir)
+
+	paths := ComponentSearchPaths()
+	want := []string{
+		explicit,
+		filepath.Join(dir, componentName),
+		filepath.Join(envDir, componentName),
+	}
+	if len(paths) < len(want) {
+		t.Fatalf("ComponentSearchPaths() = %v, want prefix %v", paths, want)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("ComponentSearchPaths()[%d] = %q, want %q", i, paths[i], want[i])
+		}
+	}
+}
+
+func TestFindComponentFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	component := filepath.Join(dir, componentName)
+	if err := os.WriteFile(component, []byte("\x00asm"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(ComponentPathEnv, dir)
+
+	path, err := findComponent()
+	if err != nil {
+		t.Fatalf("findComponent() error = %v", err)
+	}
+	if path != component {
+		t.Errorf("findComponent() = %q, want %q", path, component)
+	}
+}
+
+func TestFindComponentSkipsMissingExplicitFile(t *testing.T) {
+	dir := t.TempDir()
+	component := filepath.Join(dir, componentName)
+	if err := os.WriteFile(component, []byte("\x00asm"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	SetComponentSearchPaths([]string{filepath.Join(dir, "missing.wasm"), dir})
+	defer SetComponentSearchPaths(nil)
+
+	path, err := findComponent()
+	if err != nil {
+		t.Fatalf("findComponent() error = %v", err)
+	}
+	if path != component {
+		t.Errorf("findComponent() = %q, want %q", path, component)
+	}
+}
+
+func TestNewInitScript(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(Config{
+		Backend:    BackendEmbedded,
+		InitScript: "GREETING=hello\ngreet() { echo \"$GREETING, $1\"; }",
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.Execute("greet world")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if got := strings.TrimSpace(string(result.Stdout)); got != "hello, world" {
+		t.Errorf("stdout = %q, want %q", got, "hello, world")
+	}
+}
+
+func TestNewInitScriptRunsOnce(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	var runs atomic.Int32
+	exec, err := New(Config{
+		Backend:    BackendEmbedded,
+		InitScript: "setup-hook\nset -u\ncd /tmp",
+		OnCommandNotFound: func(name string, args []string) (bool, CommandResult) {
+			if name != "setup-hook" {
+				return false, CommandResult{}
+			}
+			runs.Add(1)
+			return true, CommandResult{}
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	for i := 0; i < 3; i++ {
+		result, err := exec.Execute("pwd; echo ${undefined}")
+		if err != nil {
+			t.Fatalf("Execute() error: %v", err)
+		}
+		if got := string(result.Stdout); got != "/tmp\n" || result.ExitCode == 0 {
+			t.Errorf("stdout = %q, exit code %d; want the directory and set -u restored",
+				got, result.ExitCode)
+		}
+	}
+	if n := runs.Load(); n != 1 {
+		t.Errorf("init script ran %d times, want 1", n)
+	}
+}
+
+func TestNewInitScriptFailure(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	_, err := New(Config{Backend: BackendEmbedded, InitScript: "exit 3"})
+	if !errors.Is(err, ErrInitScript) {
+		t.Errorf("New() error = %v, want ErrInitScript", err)
+	}
+}
@@ -0,0 +1,278 @@
+package conch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BenchConfig describes a benchmark run by Bench.
+type BenchConfig struct {
+	// Script is the script executed repeatedly.
+	Script string
+	// Backends are the backends to measure, each in turn. Defaults to
+	// BackendAuto.
+	Backends []Backend
+	// PoolSizes are the numbers of executors to run concurrently, each
+	// executing the script in a loop. Defaults to 1.
+	PoolSizes []int
+	// Duration is how long each backend and pool size is measured for.
+	// Defaults to 5 seconds.
+	Duration time.Duration
+	// Warmup is how long each executor runs the script before measuring
+	// starts, so compilation and first-use costs are left out. Zero skips
+	// the warmup.
+	Warmup time.Duration
+	// Options configure every executor; the backend is set after them.
+	Options []Option
+}
+
+// BenchResult is the measurement of one backend and pool size.
+type BenchResult struct {
+	Backend  string `json:"backend"`
+	PoolSize int    `json:"pool_size"`
+	// Executions counts the executions that completed, including failed
+	// ones; Errors counts those that returned an error.
+	Executions int `json:"executions"`
+	Errors     int `json:"errors"`
+	// Elapsed is the wall time measured.
+	Elapsed time.Duration `json:"elapsed_ns"`
+	// PerSecond is the throughput across the pool.
+	PerSecond float64 `json:"per_second"`
+	// P50 and P99 are the latencies of single executions.
+	P50 time.Duration `json:"p50_ns"`
+	P99 time.Duration `json:"p99_ns"`
+	// CompiledCodeBytes and PeakWasmMemoryBytes are read from Stats while
+	// the pool is open, and are zero if the library cannot report them.
+	CompiledCodeBytes   uint64 `json:"compiled_code_bytes"`
+	PeakWasmMemoryBytes uint64 `json:"peak_wasm_memory_bytes"`
+}
+
+// BenchReport holds the results of Bench, one per backend and pool size.
+type BenchReport struct {
+	Script  string        `json:"script"`
+	Results []BenchResult `json:"results"`
+}
+
+// Bench measures the throughput, latency and memory of executing
+// cfg.Script across the configured backends and pool sizes, for capacity
+// planning. The cases run one after another, each on new executors. It
+// stops at the first executor that cannot be created, or when ctx is done.
+func Bench(ctx context.Context, cfg BenchConfig) (*BenchReport, error) {
+	backends := cfg.Backends
+	if len(backends) == 0 {
+		backends = []Backend{BackendAuto}
+	}
+	sizes := cfg.PoolSizes
+	if len(sizes) == 0 {
+		sizes = []int{1}
+	}
+	for _, n := range sizes {
+		if n < 1 {
+			return nil, fmt.Errorf("invalid pool size %d", n)
+		}
+	}
+
+	report := &BenchReport{Script: cfg.Script}
+	for _, b := range backends {
+		b := b
+		opts := append(cfg.Options[:len(cfg.Options):len(cfg.Options)],
+			optionFunc(func(c *Config) { c.Backend = b }))
+		newExec := func() (ShellExecutor, error) { return New(opts...) }
+		for _, n := range sizes {
+			r, err := benchCase(ctx, newExec, cfg, n)
+			if err != nil {
+				return report, fmt.Errorf("%v backend, pool size %d: %w", b, n, err)
+			}
+			r.Backend = b.String()
+			report.Results = append(report.Results, r)
+		}
+	}
+	return report, nil
+}
+
+// benchCase measures n executors from newExec running cfg.Script
+// concurrently.
+func benchCase(ctx context.Context, newExec func() (ShellExecutor, error), cfg BenchConfig, n int) (BenchResult, error) {
+	execs := make([]ShellExecutor, 0, n)
+	defer func() {
+		for _, exec := range execs {
+			exec.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		exec, err := newExec()
+		if err != nil {
+			return BenchResult{}, err
+		}
+		execs = append(execs, exec)
+	}
+
+	if cfg.Warmup > 0 {
+		benchLoop(ctx, execs, cfg.Script, cfg.Warmup)
+	}
+
+	mem := startMemorySampler()
+	start := time.Now()
+	latencies, errs := benchLoop(ctx, execs, cfg.Script, cfg.benchDuration())
+	elapsed := time.Since(start)
+	compiled, peak := mem.stop()
+	if err := ctx.Err(); err != nil {
+		return BenchResult{}, err
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return BenchResult{
+		PoolSize:            n,
+		Executions:          len(latencies),
+		Errors:              errs,
+		Elapsed:             elapsed,
+		PerSecond:           float64(len(latencies)) / elapsed.Seconds(),
+		P50:                 percentile(latencies, 50),
+		P99:                 percentile(latencies, 99),
+		CompiledCodeBytes:   compiled,
+		PeakWasmMemoryBytes: peak,
+	}, nil
+}
+
+func (cfg BenchConfig) benchDuration() time.Duration {
+	if cfg.Duration > 0 {
+		return cfg.Duration
+	}
+	return 5 * time.Second
+}
+
+// benchLoop runs script on every executor in a loop for d, returning the
+// latency of each execution and the number that failed.
+func benchLoop(ctx context.Context, execs []ShellExecutor, script string, d time.Duration) ([]time.Duration, int) {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		errs      int
+		wg        sync.WaitGroup
+	)
+	for _, exec := range execs {
+		wg.Add(1)
+		go func(exec ShellExecutor) {
+			defer wg.Done()
+			var local []time.Duration
+			var failed int
+			for ctx.Err() == nil {
+				start := time.Now()
+				_, err := exec.ExecuteContext(ctx, script)
+				if err != nil && ctx.Err() != nil {
+					break // cut short by the deadline, not a real failure
+				}
+				local = append(local, time.Since(start))
+				if err != nil {
+					failed++
+				}
+			}
+			mu.Lock()
+			latencies = append(latencies, local...)
+			errs += failed
+			mu.Unlock()
+		}(exec)
+	}
+	wg.Wait()
+	return latencies, errs
+}
+
+// percentile returns the p-th percentile of the sorted durations, by the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// memorySampler polls Stats in the background for the peak memory use.
+type memorySampler struct {
+	stopc    chan struct{}
+	done     chan struct{}
+	compiled uint64
+	peak     uint64
+}
+
+func startMemorySampler() *memorySampler {
+	m := &memorySampler{stopc: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			m.sample()
+			select {
+			case <-m.stopc:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return m
+}
+
+func (m *memorySampler) sample() {
+	s, err := Stats()
+	if err != nil {
+		return
+	}
+	m.compiled = max(m.compiled, s.CompileCacheBytes)
+	m.peak = max(m.peak, s.WasmMemoryBytes)
+}
+
+// stop ends sampling and returns the compiled code and peak memory seen.
+func (m *memorySampler) stop() (compiled, peak uint64) {
+	close(m.stopc)
+	<-m.done
+	return m.compiled, m.peak
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *BenchReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteMarkdown writes the report as a Markdown table.
+func (r *BenchReport) WriteMarkdown(w io.Writer) error {
+	var errs []error
+	printf := func(format string, args ...any) {
+		_, err := fmt.Fprintf(w, format, args...)
+		errs = append(errs, err)
+	}
+	printf("| backend | pool | executions | errors | exec/s | p50 | p99 | compiled | peak memory |\n")
+	printf("|---|---:|---:|---:|---:|---:|---:|---:|---:|\n")
+	for _, res := range r.Results {
+		printf("| %s | %d | %d | %d | %.1f | %v | %v | %s | %s |\n",
+			res.Backend, res.PoolSize, res.Executions, res.Errors, res.PerSecond,
+			res.P50.Round(time.Microsecond), res.P99.Round(time.Microsecond),
+			formatBytes(res.CompiledCodeBytes), formatBytes(res.PeakWasmMemoryBytes))
+	}
+	return errors.Join(errs...)
+}
+
+// formatBytes renders n in binary units, such as "1.5 MiB".
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
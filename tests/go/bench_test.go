@@ -0,0 +1,124 @@
+package conch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBenchCase(t *testing.T) {
+	var execs []*pooledTestExecutor
+	newExec := func() (ShellExecutor, error) {
+		exec := &pooledTestExecutor{id: len(execs)}
+		execs = append(execs, exec)
+		return exec, nil
+	}
+	r, err := benchCase(context.Background(), newExec, BenchConfig{Duration: 20 * time.Millisecond}, 3)
+	if err != nil {
+		t.Fatalf("benchCase() error: %v", err)
+	}
+	if r.PoolSize != 3 || r.Executions == 0 || r.Errors != 0 || r.PerSecond <= 0 {
+		t.Errorf("benchCase() = %+v", r)
+	}
+	if r.P50 > r.P99 {
+		t.Errorf("p50 %v above p99 %v", r.P50, r.P99)
+	}
+	if len(execs) != 3 {
+		t.Fatalf("created %d executors, want 3", len(execs))
+	}
+	for _, exec := range execs {
+		if !exec.closed.Load() {
+			t.Errorf("executor %d not closed", exec.id)
+		}
+	}
+}
+
+func TestBenchCaseCreateError(t *testing.T) {
+	created := 0
+	newExec := func() (ShellExecutor, error) {
+		if created == 2 {
+			return nil, errors.New("boom")
+		}
+		created++
+		return &pooledTestExecutor{}, nil
+	}
+	if _, err := benchCase(context.Background(), newExec, BenchConfig{Duration: time.Millisecond}, 4); err == nil {
+		t.Error("benchCase() succeeded despite a failing executor")
+	}
+}
+
+func TestBenchInvalidPoolSize(t *testing.T) {
+	if _, err := Bench(context.Background(), BenchConfig{PoolSizes: []int{0}}); err == nil {
+		t.Error("Bench() accepted pool size 0")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var d []time.Duration
+	for i := 1; i <= 100; i++ {
+		d = append(d, time.Duration(i))
+	}
+	for _, tt := range []struct{ p, want int }{{50, 50}, {99, 99}, {100, 100}, {0, 1}} {
+		if got := percentile(d, tt.p); got != time.Duration(tt.want) {
+			t.Errorf("percentile(%d) = %d, want %d", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(d[:1], 99); got != 1 {
+		t.Errorf("percentile of one = %d", got)
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile of none = %d", got)
+	}
+}
+
+func TestBenchReportWrite(t *testing.T) {
+	r := &BenchReport{Script: "true", Results: []BenchResult{{
+		Backend: "embedded", PoolSize: 4, Executions: 1000, PerSecond: 500,
+		P50: 1500 * time.Microsecond, P99: 3 * time.Millisecond, CompiledCodeBytes: 3 << 20,
+	}}}
+
+	var md bytes.Buffer
+	if err := r.WriteMarkdown(&md); err != nil {
+		t.Fatal(err)
+	}
+	if want := "| embedded | 4 | 1000 | 0 | 500.0 | 1.5ms | 3ms | 3.0 MiB | 0 B |"; !strings.Contains(md.String(), want) {
+		t.Errorf("WriteMarkdown() = %q, want a row %q", md.String(), want)
+	}
+
+	var js bytes.Buffer
+	if err := r.WriteJSON(&js); err != nil {
+		t.Fatal(err)
+	}
+	var got BenchReport
+	if err := json.Unmarshal(js.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Results[0] != r.Results[0] {
+		t.Errorf("JSON round trip = %+v, want %+v", got.Results[0], r.Results[0])
+	}
+}
+
+func TestBenchEmbedded(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+	report, err := Bench(context.Background(), BenchConfig{
+		Script:    "echo hi",
+		Backends:  []Backend{BackendEmbedded},
+		PoolSizes: []int{1, 2},
+		Duration:  100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Bench() error: %v", err)
+	}
+	if len(report.Results) != 2 {
+		t.Fatalf("got %d results, want 2", len(report.Results))
+	}
+	for _, r := range report.Results {
+		if r.Executions == 0 || r.Errors != 0 {
+			t.Errorf("result %+v", r)
+		}
+	}
+}
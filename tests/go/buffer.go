@@ -0,0 +1,22 @@
+package conch
+
+import (
+	"context"
+	"errors"
+)
+
+// ExecuteIntoWithOptions runs script with the given options, stopping it
+// when ctx is done, and writes the outcome into result as ExecuteInto does,
+// reusing result.Stdout and result.Stderr rather than allocating new ones.
+func (e *Executor) ExecuteIntoWithOptions(ctx context.Context, script string, opts ExecOptions, result *Result) error {
+	if result == nil {
+		return errors.New("result is nil")
+	}
+	limits := e.Limits()
+	if opts.Limits != nil {
+		limits = *opts.Limits
+	}
+	opts.into = result
+	_, err := e.run(ctx, script, opts, limits)
+	return err
+}
@@ -0,0 +1,35 @@
+package conch
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestExecuteIntoWithOptions(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	result := Result{Stdout: make([]byte, 0, 4096)}
+	backing := unsafe.SliceData(result.Stdout)
+	for i := 0; i < 2; i++ {
+		if err := exec.ExecuteIntoWithOptions(context.Background(), "printf '%0100d' 0; echo oops >&2", ExecOptions{}, &result); err != nil {
+			t.Fatalf("ExecuteIntoWithOptions() error: %v", err)
+		}
+		if string(result.Stdout) != strings.Repeat("0", 100) || string(result.Stderr) != "oops\n" {
+			t.Fatalf("Stdout = %q, Stderr = %q", result.Stdout, result.Stderr)
+		}
+		if unsafe.SliceData(result.Stdout) != backing {
+			t.Error("ExecuteIntoWithOptions() reallocated Stdout despite sufficient capacity")
+		}
+		if result.ID == "" {
+			t.Error("ExecuteIntoWithOptions() left ID empty")
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package conch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// maxPooledBuffer is the largest marshaling buffer kept for reuse. Larger
+// buffers are left to the garbage collector so one huge script doesn't pin
+// memory in the pool indefinitely.
+const maxPooledBuffer = 64 * 1024
+
+// cBuffer is a NUL-terminated buffer handed to the native side.
+//
+// Buffers come from cString and must be returned with freeString once the FFI
+// call that received them has returned.
+type cBuffer struct {
+	b []byte
+}
+
+var cBufferPool = sync.Pool{
+	New: func() any { return new(cBuffer) },
+}
+
+// ErrNULByte is returned when a script, or any other string passed to the
+// native side, contains a NUL byte. The native side would read it as the end
+// of the string and silently run only what precedes it.
+var ErrNULByte = errors.New("contains a NUL byte")
+
+// cString converts a Go string to a null-terminated C string. It returns an
+// error wrapping ErrNULByte if s contains one.
+//
+// The returned buffer is Go memory: pin it with pinBytes for the duration of
+// the FFI call that receives it.
+func cString(s string) (*cBuffer, error) {
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		return nil, fmt.Errorf("string %w at offset %d", ErrNULByte, i)
+	}
+	buf := cBufferPool.Get().(*cBuffer)
+	if cap(buf.b) < len(s)+1 {
+		buf.b = make([]byte, len(s)+1)
+	}
+	buf.b = buf.b[:len(s)+1]
+	copy(buf.b, s)
+	buf.b[len(s)] = 0
+	return buf, nil
+}
+
+// freeString returns a buffer from cString to the pool.
+func freeString(buf *cBuffer) {
+	if cap(buf.b) > maxPooledBuffer {
+		return
+	}
+	cBufferPool.Put(buf)
+}
+
+// errorBufferSize is the room given to native calls for the message of a
+// failure. Longer messages are truncated.
+const errorBufferSize = 1024
+
+// errorBuffer receives the message of a failed call to an "_err" export,
+// which takes the buffer's address and errorBufferSize as its last two
+// arguments. The message comes back with the failing call, so another
+// goroutine's call on the same OS thread cannot replace it.
+type errorBuffer []byte
+
+// newErrorBuffer returns an empty errorBuffer.
+func newErrorBuffer() errorBuffer {
+	return make(errorBuffer, errorBufferSize)
+}
+
+// ptr returns the address of b for the FFI call.
+func (b errorBuffer) ptr() *byte {
+	return &b[0]
+}
+
+// String returns the message, or "" if the call did not fail.
+func (b errorBuffer) String() string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return string(b[:i])
+	}
+	return string(b)
+}
+
+// writeErrorBuffer writes msg into the n-byte error buffer at buf as the
+// native side would: NUL-terminated and, if too long, truncated on a
+// character boundary.
+func writeErrorBuffer(buf *byte, n uintptr, msg string) {
+	if buf == nil || n == 0 {
+		return
+	}
+	b := unsafe.Slice(buf, n)
+	end := min(len(msg), len(b)-1)
+	for end < len(msg) && end > 0 && !utf8.RuneStart(msg[end]) {
+		end--
+	}
+	copy(b, msg[:end])
+	b[end] = 0
+}
@@ -0,0 +1,113 @@
+package conch
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCStringTerminated(t *testing.T) {
+	buf, err := cString("echo hi")
+	if err != nil {
+		t.Fatalf("cString() error = %v", err)
+	}
+	defer freeString(buf)
+
+	if !bytes.Equal(buf.b, []byte("echo hi\x00")) {
+		t.Errorf("cString() = %q, want NUL-terminated script", buf.b)
+	}
+}
+
+func TestCStringNUL(t *testing.T) {
+	buf, err := cString("echo safe\x00; rm -rf /data")
+	if !errors.Is(err, ErrNULByte) {
+		t.Fatalf("cString() error = %v, want ErrNULByte", err)
+	}
+	if buf != nil {
+		t.Error("cString() returned a buffer with its error")
+	}
+	if err.Error() != "string contains a NUL byte at offset 9" {
+		t.Errorf("error = %q", err)
+	}
+}
+
+func TestExecuteNUL(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error = %v", err)
+	}
+	defer exec.Close()
+
+	if _, err := exec.Execute("echo a\x00echo b"); !errors.Is(err, ErrNULByte) {
+		t.Errorf("Execute() error = %v, want ErrNULByte", err)
+	}
+	if _, err := exec.ExecuteWithStdin("cat\x00", []byte("x")); !errors.Is(err, ErrNULByte) {
+		t.Errorf("ExecuteWithStdin() error = %v, want ErrNULByte", err)
+	}
+}
+
+func TestCStringReuseShrinks(t *testing.T) {
+	buf, _ := cString("a much longer script than the next one")
+	freeString(buf)
+
+	buf, _ = cString("ls")
+	defer freeString(buf)
+
+	if !bytes.Equal(buf.b, []byte("ls\x00")) {
+		t.Errorf("cString() = %q, want %q", buf.b, "ls\x00")
+	}
+}
+
+func TestCStringPooledAllocs(t *testing.T) {
+	script := "echo hello | grep hello"
+	// Warm the pool.
+	buf, _ := cString(script)
+	freeString(buf)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ := cString(script)
+		freeString(buf)
+	})
+	if allocs > 0 {
+		t.Errorf("cString/freeString allocs = %v, want 0", allocs)
+	}
+}
+
+func TestFreeStringDropsLargeBuffers(t *testing.T) {
+	buf, _ := cString(strings.Repeat("x", maxPooledBuffer+1))
+	// Must not panic and must not be retained; nothing observable beyond that.
+	freeString(buf)
+}
+
+func BenchmarkCString(b *testing.B) {
+	script := strings.Repeat("echo hello; ", 16)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _ := cString(script)
+		freeString(buf)
+	}
+}
+
+func TestErrorBuffer(t *testing.T) {
+	ebuf := newErrorBuffer()
+	if ebuf.String() != "" {
+		t.Errorf("new buffer holds %q", ebuf)
+	}
+	writeErrorBuffer(ebuf.ptr(), errorBufferSize, "executor is null")
+	if ebuf.String() != "executor is null" {
+		t.Errorf("String() = %q", ebuf)
+	}
+
+	// Long messages are cut on a character boundary, leaving room for the
+	// terminator.
+	small := make(errorBuffer, 6)
+	writeErrorBuffer(small.ptr(), uintptr(len(small)), "abcdé!")
+	if small.String() != "abcd" {
+		t.Errorf("truncated String() = %q, want abcd", small)
+	}
+	writeErrorBuffer(nil, 10, "ignored")
+}
@@ -0,0 +1,13 @@
+package conch
+
+import "time"
+
+// builtinTimeoutMs converts ExecOptions.BuiltinTimeout to the milliseconds
+// ConchLimits takes, rounding a positive timeout up so that it still sets a
+// limit.
+func builtinTimeoutMs(timeout time.Duration) uint64 {
+	if timeout <= 0 {
+		return 0
+	}
+	return uint64((timeout + time.Millisecond - 1) / time.Millisecond)
+}
@@ -0,0 +1,46 @@
+package conch
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuiltinTimeoutMs(t *testing.T) {
+	for _, tt := range []struct {
+		timeout time.Duration
+		want    uint64
+	}{
+		{0, 0},
+		{-time.Second, 0},
+		{time.Microsecond, 1},
+		{1500 * time.Millisecond, 1500},
+		{1500*time.Millisecond + 1, 1501},
+	} {
+		if got := builtinTimeoutMs(tt.timeout); got != tt.want {
+			t.Errorf("builtinTimeoutMs(%v) = %d, want %d", tt.timeout, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteBuiltinTimeout(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	// The filter loops without output, and unsetting the variable the limit
+	// used to be read from does not lift it.
+	script := "unset CONCH_BUILTIN_TIMEOUT; jq -n 'last(range(1e10))'"
+	result, err := exec.ExecuteWithOptions(context.Background(), script, ExecOptions{BuiltinTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+	if result.ExitCode != ExitCodeBuiltinTimeout || !strings.Contains(string(result.Stderr), "conch: jq: time limit") {
+		t.Errorf("ExitCode = %d, Stderr = %q", result.ExitCode, result.Stderr)
+	}
+}
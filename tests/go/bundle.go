@@ -0,0 +1,206 @@
+package conch
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// BundleManifestName is the path of the manifest inside a bundle.
+const BundleManifestName = "manifest.json"
+
+// DefaultBundleMount is where a bundle's files appear in the sandbox unless
+// its manifest says otherwise.
+const DefaultBundleMount = "/bundle"
+
+// maxBundleSize caps the total size of the files in a bundle.
+const maxBundleSize = 256 << 20
+
+// BundleManifest describes a bundle. It is stored as JSON at
+// BundleManifestName:
+//
+//	{
+//	  "name": "deploy-pack",
+//	  "version": "1.2.0",
+//	  "mount": "/bundle",
+//	  "entrypoints": {"deploy": "scripts/deploy.sh"}
+//	}
+type BundleManifest struct {
+	// Name and Version identify the bundle; they are informational.
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// Mount is the absolute sandbox directory holding the bundle's files.
+	// Defaults to DefaultBundleMount.
+	Mount string `json:"mount,omitempty"`
+	// Entrypoints maps a name to a script file, relative to the bundle
+	// root.
+	Entrypoints map[string]string `json:"entrypoints"`
+}
+
+// Bundle is a manifest plus the scripts and data files it ships, loaded with
+// LoadBundle.
+//
+// A bundle is a tar archive, optionally gzip-compressed, with the manifest
+// at its root. Every other regular file is provisioned into the sandbox
+// under the manifest's mount directory, keeping its relative path.
+type Bundle struct {
+	Manifest BundleManifest
+	// Files maps bundle-relative paths to contents, excluding the manifest.
+	Files map[string][]byte
+}
+
+// LoadBundle reads a bundle from r and validates its manifest.
+func LoadBundle(r io.Reader) (*Bundle, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		r = br
+	}
+
+	b := &Bundle{Files: map[string][]byte{}}
+	var manifest []byte
+	var total int64
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle: %w", err)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+		case tar.TypeDir:
+			continue
+		default:
+			return nil, fmt.Errorf("invalid bundle: %s: unsupported entry type", hdr.Name)
+		}
+
+		name, err := bundlePath(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		total += hdr.Size
+		if total > maxBundleSize {
+			return nil, fmt.Errorf("invalid bundle: larger than %d bytes", maxBundleSize)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle: %s: %w", name, err)
+		}
+
+		if name == BundleManifestName {
+			manifest = data
+		} else {
+			b.Files[name] = data
+		}
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("invalid bundle: missing %s", BundleManifestName)
+	}
+	if err := json.Unmarshal(manifest, &b.Manifest); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %s: %w", BundleManifestName, err)
+	}
+	if err := b.validate(); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	return b, nil
+}
+
+// bundlePath cleans a tar entry name, rejecting anything that would escape
+// the bundle root.
+func bundlePath(name string) (string, error) {
+	clean := path.Clean(strings.TrimPrefix(name, "./"))
+	if path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("invalid bundle: %s: path escapes bundle root", name)
+	}
+	return clean, nil
+}
+
+func (b *Bundle) validate() error {
+	if b.Manifest.Mount == "" {
+		b.Manifest.Mount = DefaultBundleMount
+	}
+	if mount := path.Clean(b.Manifest.Mount); !path.IsAbs(mount) || mount == "/" {
+		return fmt.Errorf("mount %q must be an absolute directory below /", b.Manifest.Mount)
+	}
+	b.Manifest.Mount = path.Clean(b.Manifest.Mount)
+
+	for name, script := range b.Manifest.Entrypoints {
+		if _, ok := b.Files[path.Clean(script)]; !ok {
+			return fmt.Errorf("entrypoint %s: %s not in bundle", name, script)
+		}
+	}
+	return nil
+}
+
+// Entrypoints returns the bundle's entrypoint names, sorted.
+func (b *Bundle) Entrypoints() []string {
+	names := make([]string, 0, len(b.Manifest.Entrypoints))
+	for name := range b.Manifest.Entrypoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Provision seeds the bundle's files into e's sandbox under the manifest's
+// mount directory.
+func (b *Bundle) Provision(e *Executor) error {
+	for name, data := range b.Files {
+		if err := e.AddFile(path.Join(b.Manifest.Mount, name), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Script returns a shell script running the named entrypoint with args as
+// its positional parameters, from the bundle's mount directory.
+func (b *Bundle) Script(name string, args ...string) (string, error) {
+	file, ok := b.Manifest.Entrypoints[name]
+	if !ok {
+		return "", fmt.Errorf("bundle %s has no entrypoint %q", b.Manifest.Name, name)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "cd %s || exit\n", shellQuote(b.Manifest.Mount))
+	sb.WriteString("set --")
+	for _, arg := range args {
+		sb.WriteByte(' ')
+		sb.WriteString(shellQuote(arg))
+	}
+	fmt.Fprintf(&sb, "\n. %s\n", shellQuote(path.Join(b.Manifest.Mount, path.Clean(file))))
+	return sb.String(), nil
+}
+
+// Execute runs the named entrypoint on e, which must have been provisioned
+// with b.
+func (b *Bundle) Execute(e *Executor, name string, args ...string) (*Result, error) {
+	script, err := b.Script(name, args...)
+	if err != nil {
+		return nil, err
+	}
+	return e.Execute(script)
+}
+
+// shellQuote quotes s as a single shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
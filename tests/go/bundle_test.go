@@ -0,0 +1,148 @@
+package conch
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// makeBundle builds a tar archive from name/contents pairs.
+func makeBundle(t *testing.T, compress bool, files ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var zw *gzip.Writer
+	tw := tar.NewWriter(&buf)
+	if compress {
+		zw = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(zw)
+	}
+	for i := 0; i < len(files); i += 2 {
+		hdr := &tar.Header{Name: files[i], Mode: 0o644, Size: int64(len(files[i+1])), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+const testManifest = `{"name": "pack", "version": "1.0.0", "entrypoints": {"deploy": "scripts/deploy.sh", "check": "./check.sh"}}`
+
+func TestLoadBundle(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		data := makeBundle(t, compress,
+			"manifest.json", testManifest,
+			"./scripts/deploy.sh", `echo "deploying $1"`,
+			"check.sh", "true",
+			"data/targets.txt", "web\n",
+		)
+
+		b, err := LoadBundle(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("LoadBundle(compress=%v) error: %v", compress, err)
+		}
+		if b.Manifest.Name != "pack" || b.Manifest.Mount != DefaultBundleMount {
+			t.Errorf("Manifest = %+v", b.Manifest)
+		}
+		if got, want := b.Entrypoints(), []string{"check", "deploy"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Entrypoints() = %v, want %v", got, want)
+		}
+		if got := string(b.Files["data/targets.txt"]); got != "web\n" {
+			t.Errorf("Files[data/targets.txt] = %q", got)
+		}
+		if _, ok := b.Files[BundleManifestName]; ok {
+			t.Error("manifest included in Files")
+		}
+	}
+}
+
+func TestLoadBundleInvalid(t *testing.T) {
+	tests := map[string][]string{
+		"missing manifest":   {"scripts/a.sh", "true"},
+		"bad manifest":       {"manifest.json", "{"},
+		"missing entrypoint": {"manifest.json", testManifest},
+		"escaping path":      {"manifest.json", `{"entrypoints": {}}`, "../evil.sh", "true"},
+		"absolute path":      {"manifest.json", `{"entrypoints": {}}`, "/etc/passwd", "x"},
+		"root mount":         {"manifest.json", `{"mount": "/", "entrypoints": {}}`},
+		"relative mount":     {"manifest.json", `{"mount": "bundle", "entrypoints": {}}`},
+	}
+	for name, files := range tests {
+		if _, err := LoadBundle(bytes.NewReader(makeBundle(t, false, files...))); err == nil {
+			t.Errorf("%s: LoadBundle() succeeded, want error", name)
+		}
+	}
+}
+
+func TestBundleScript(t *testing.T) {
+	b := &Bundle{Manifest: BundleManifest{
+		Name:        "pack",
+		Mount:       "/opt/pack",
+		Entrypoints: map[string]string{"deploy": "scripts/deploy.sh"},
+	}}
+
+	script, err := b.Script("deploy", "web", "it's prod")
+	if err != nil {
+		t.Fatalf("Script() error: %v", err)
+	}
+	want := "cd '/opt/pack' || exit\nset -- 'web' 'it'\\''s prod'\n. '/opt/pack/scripts/deploy.sh'\n"
+	if script != want {
+		t.Errorf("Script() = %q, want %q", script, want)
+	}
+
+	if _, err := b.Script("missing"); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("Script(missing) error = %v", err)
+	}
+}
+
+func TestAddFileInvalidPath(t *testing.T) {
+	var exec Executor
+	for _, name := range []string{"", "relative/file", "/", "/.."} {
+		if err := exec.AddFile(name, nil); err == nil {
+			t.Errorf("AddFile(%q) succeeded, want error", name)
+		}
+	}
+}
+
+func TestBundleExecute(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	b, err := LoadBundle(bytes.NewReader(makeBundle(t, true,
+		"manifest.json", testManifest,
+		"scripts/deploy.sh", `echo "deploying $1 to $(cat data/targets.txt)"`,
+		"check.sh", "true",
+		"data/targets.txt", "web",
+	)))
+	if err != nil {
+		t.Fatalf("LoadBundle() error: %v", err)
+	}
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	if err := b.Provision(exec); err != nil {
+		t.Fatalf("Provision() error: %v", err)
+	}
+	result, err := b.Execute(exec, "deploy", "v2")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if got := strings.TrimSpace(string(result.Stdout)); got != "deploying v2 to web" {
+		t.Errorf("stdout = %q, stderr = %q", got, result.Stderr)
+	}
+}
@@ -0,0 +1,115 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// Go callbacks, such as command handlers, prompt handlers and output
+// functions, run on native threads while the execution that called them
+// waits for their answer. Two things must not escape them:
+//
+//   - A panic, which would unwind through the native library's frames. Every
+//     callback entry point recovers it; what the execution sees instead
+//     depends on the callback.
+//   - A call back into the same executor. The execution holds the executor
+//     until the callback returns, so an execution started from the callback,
+//     or from a goroutine it waits on, can wait on itself: behind a Close
+//     waiting for the execution, or on the executor's own thread. The
+//     contexts hooks are given are marked with their executor, and
+//     executions started with one on that executor fail with
+//     ErrReentrantCall. Other callers, including other executions'
+//     callbacks, are not affected.
+
+// ErrReentrantCall is returned when an execution is started on an executor
+// with the context one of its hooks was given, or a context derived from
+// it. Hooks needing a shell should use another executor, or a Pool. Calls
+// without the hook's context, and Close, are not recognised, so hooks must
+// not close their own executor.
+var ErrReentrantCall = errors.New("executor called while one of its callbacks is running")
+
+// CallbackPanicError is returned by an execution whose OutputFunc panicked.
+// The execution is stopped and its output after the panic is dropped.
+type CallbackPanicError struct {
+	// Callback names the callback, such as "OnOutput".
+	Callback string
+	// Value is the value passed to panic.
+	Value any
+	// Stack is the callback goroutine's stack when it panicked.
+	Stack []byte
+}
+
+func (e *CallbackPanicError) Error() string {
+	return fmt.Sprintf("%s callback panicked: %v", e.Callback, e.Value)
+}
+
+// protect runs fn and returns the panic it raised, if any, as a
+// *CallbackPanicError naming callback.
+func protect(callback string, fn func()) (perr *CallbackPanicError) {
+	defer func() {
+		if r := recover(); r != nil {
+			perr = &CallbackPanicError{Callback: callback, Value: r, Stack: debug.Stack()}
+		}
+	}()
+	fn()
+	return nil
+}
+
+// callbackKey is the context key of the callbackMark of a hook's context.
+type callbackKey struct{}
+
+// callbackMark marks the context of one of owner's hooks. parent is the
+// mark of the hook whose context started the execution, if any, so a chain
+// of executions started from hooks is refused by each of its executors.
+type callbackMark struct {
+	owner  *Executor
+	parent *callbackMark
+}
+
+// withCallback marks ctx as the context of one of e's hooks.
+func withCallback(ctx context.Context, e *Executor) context.Context {
+	parent, _ := ctx.Value(callbackKey{}).(*callbackMark)
+	return context.WithValue(ctx, callbackKey{}, &callbackMark{owner: e, parent: parent})
+}
+
+// checkReentrant returns ErrReentrantCall if ctx is the context of one of
+// e's hooks, or derived from one.
+func (e *Executor) checkReentrant(ctx context.Context) error {
+	mark, _ := ctx.Value(callbackKey{}).(*callbackMark)
+	for ; mark != nil; mark = mark.parent {
+		if mark.owner == e {
+			return ErrReentrantCall
+		}
+	}
+	return nil
+}
+
+// guardOutput wraps fn, the OutputFunc of the execution holding interrupt.
+// If fn panics, the execution is stopped, later output is dropped, and
+// panicked returns the panic.
+func guardOutput(l *library, interrupt uintptr, fn OutputFunc) (guarded OutputFunc, panicked func() *CallbackPanicError) {
+	var mu sync.Mutex
+	var perr *CallbackPanicError
+	panicked = func() *CallbackPanicError {
+		mu.Lock()
+		defer mu.Unlock()
+		return perr
+	}
+	guarded = func(stream Stream, chunk []byte, offset int64) {
+		if panicked() != nil {
+			return
+		}
+		if p := protect("OnOutput", func() { fn(stream, chunk, offset) }); p != nil {
+			mu.Lock()
+			if perr == nil {
+				perr = p
+			}
+			mu.Unlock()
+			l.interruptTrigger(interrupt)
+		}
+	}
+	return guarded, panicked
+}
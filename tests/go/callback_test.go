@@ -0,0 +1,139 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestProtect(t *testing.T) {
+	if perr := protect("test", func() {}); perr != nil {
+		t.Errorf("protect() = %v, want nil", perr)
+	}
+
+	perr := protect("test", func() { panic("boom") })
+	if perr == nil {
+		t.Fatal("protect() = nil, want the panic")
+	}
+	if perr.Value != "boom" || perr.Error() != "test callback panicked: boom" {
+		t.Errorf("protect() = %+v", perr)
+	}
+	if !strings.Contains(string(perr.Stack), "TestProtect") {
+		t.Errorf("Stack does not include the panicking function:\n%s", perr.Stack)
+	}
+}
+
+func TestCheckReentrant(t *testing.T) {
+	var e, other Executor
+	ctx := context.Background()
+	if err := e.checkReentrant(ctx); err != nil {
+		t.Errorf("checkReentrant() = %v", err)
+	}
+
+	hook := withCallback(ctx, &e)
+	if err := e.checkReentrant(hook); !errors.Is(err, ErrReentrantCall) {
+		t.Errorf("checkReentrant() with a hook's context = %v, want ErrReentrantCall", err)
+	}
+	derived, cancel := context.WithCancel(hook)
+	defer cancel()
+	if err := e.checkReentrant(derived); !errors.Is(err, ErrReentrantCall) {
+		t.Errorf("checkReentrant() with a derived context = %v, want ErrReentrantCall", err)
+	}
+	if _, err := e.ExecuteContext(hook, "echo hi"); !errors.Is(err, ErrReentrantCall) {
+		t.Errorf("ExecuteContext() with a hook's context = %v, want ErrReentrantCall", err)
+	}
+
+	// Other executors, and callers with their own contexts, are not
+	// affected; a hook of other's execution started from e's hook is.
+	if err := other.checkReentrant(hook); err != nil {
+		t.Errorf("other.checkReentrant() = %v", err)
+	}
+	if err := e.checkReentrant(ctx); err != nil {
+		t.Errorf("checkReentrant() while a hook runs = %v", err)
+	}
+	if err := e.checkReentrant(withCallback(hook, &other)); !errors.Is(err, ErrReentrantCall) {
+		t.Errorf("checkReentrant() through other's hook = %v, want ErrReentrantCall", err)
+	}
+}
+
+func TestReentrantCommandHandler(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	var exec *Executor
+	exec, err := New(WithEmbedded(), WithCommandNotFoundContext(func(ctx context.Context, name string, args []string) (bool, CommandResult) {
+		_, err := exec.ExecuteContext(ctx, "echo nested")
+		return true, CommandResult{Stdout: []byte(err.Error() + "\n")}
+	}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.Execute("nested")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if got := string(result.Stdout); got != ErrReentrantCall.Error()+"\n" {
+		t.Errorf("Stdout = %q, want ErrReentrantCall", got)
+	}
+}
+
+func TestConcurrentExecuteDuringCallback(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	exec, err := New(WithEmbedded(), WithCommandNotFound(func(string, []string) (bool, CommandResult) {
+		close(entered)
+		<-release
+		return true, CommandResult{}
+	}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer exec.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := exec.Execute("wait_for_it")
+		done <- err
+	}()
+	<-entered
+	// Another caller is not refused while the first execution's hook runs.
+	result, err := exec.Execute("echo other")
+	close(release)
+	if err != nil || string(result.Stdout) != "other\n" {
+		t.Errorf("Execute() during another execution's callback = %+v, %v", result, err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Execute() with the callback = %v", err)
+	}
+}
+
+func TestOutputPanic(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error = %v", err)
+	}
+	defer exec.Close()
+
+	calls := 0
+	_, err = exec.ExecuteWithOptions(context.Background(), "while true; do echo tick; done", ExecOptions{
+		OnOutput: func(Stream, []byte, int64) {
+			calls++
+			panic("sink gone")
+		},
+	})
+	var perr *CallbackPanicError
+	if !errors.As(err, &perr) {
+		t.Fatalf("ExecuteWithOptions() error = %v, want *CallbackPanicError", err)
+	}
+	if perr.Callback != "OnOutput" || perr.Value != "sink gone" {
+		t.Errorf("error = %+v", perr)
+	}
+	if calls != 1 {
+		t.Errorf("OnOutput called %d times, want once", calls)
+	}
+}
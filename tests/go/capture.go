@@ -0,0 +1,395 @@
+package conch
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+const (
+	// defaultSampleLines is SampleOptions.Lines when unset.
+	defaultSampleLines = 1000
+	// defaultPreviewLines is HeadTailOptions.Head and Tail when unset in
+	// line mode.
+	defaultPreviewLines = 20
+)
+
+// SampleOptions configures CaptureSample.
+type SampleOptions struct {
+	// Lines is the most lines kept of each stream. Defaults to 1000. With
+	// MaxOutputBytes set, each kept line is cut to MaxOutputBytes / Lines
+	// bytes, so the sample stays within the limit.
+	Lines int
+	// Every keeps every Every-th line, starting with the first. Whenever
+	// that would keep more than Lines lines, the step doubles and every
+	// other kept line is dropped, so the sample stays evenly spread over
+	// the whole stream. Defaults to 1.
+	Every int
+	// Random keeps a uniform random sample of Lines lines instead, in the
+	// order they were written.
+	Random bool
+}
+
+// HeadTailOptions configures CaptureHeadTail.
+type HeadTailOptions struct {
+	// Head and Tail are how much of the start and end of each stream are
+	// kept: bytes, or lines if Lines is set. In bytes they default to half
+	// of MaxOutputBytes each, or 4096 without a limit; in lines, to 20.
+	Head, Tail int
+	// Lines counts Head and Tail in lines rather than bytes, so no line is
+	// cut in two. With MaxOutputBytes set, each kept line is cut to
+	// MaxOutputBytes / (Head + Tail) bytes.
+	Lines bool
+}
+
+// CaptureReport describes what a capture mode run in Go, such as
+// CaptureSample or CaptureHeadTail, kept of each stream; see Result.Capture.
+type CaptureReport struct {
+	Stdout, Stderr StreamReport
+}
+
+// StreamReport describes what was kept of one stream.
+type StreamReport struct {
+	// Lines counts the lines written, including a last line without a
+	// newline.
+	Lines int
+	// KeptLines counts the lines in the Result.
+	KeptLines int
+	// Every is the final step of an evenly spaced sample: the Result holds
+	// lines 1, 1+Every, 1+2*Every and so on. It is zero for other modes.
+	Every int
+	// OmittedBytes and OmittedLines are what CaptureHeadTail replaced with
+	// its gap marker. They are zero for other modes.
+	OmittedBytes, OmittedLines int
+}
+
+// outputCollector keeps a script's output for a capture mode run in Go,
+// fed by the output callback while the native side keeps nothing.
+type outputCollector struct {
+	mu             sync.Mutex
+	stdout, stderr streamCollector
+}
+
+// streamCollector keeps part of one stream.
+type streamCollector interface {
+	write(p []byte)
+	// finish returns what was kept, whether anything was dropped, and the
+	// stream's report.
+	finish() ([]byte, bool, StreamReport)
+}
+
+// newOutputCollector returns a collector for opts.Capture, or nil if the
+// mode is handled natively. maxBytes is the output limit of each stream.
+func newOutputCollector(opts ExecOptions, maxBytes uint64) *outputCollector {
+	var newStream func() streamCollector
+	switch opts.Capture {
+	case CaptureSample:
+		newStream = func() streamCollector { return newLineSampler(opts.Sample, maxBytes) }
+	case CaptureHeadTail:
+		if opts.HeadTail.Lines {
+			newStream = func() streamCollector { return newLinePreview(opts.HeadTail, maxBytes) }
+		} else {
+			newStream = func() streamCollector { return newBytePreview(opts.HeadTail, maxBytes) }
+		}
+	default:
+		return nil
+	}
+	return &outputCollector{stdout: newStream(), stderr: newStream()}
+}
+
+// tap returns an OutputFunc feeding the collector, then next if it is set.
+func (c *outputCollector) tap(next OutputFunc) OutputFunc {
+	return func(stream Stream, chunk []byte, offset int64) {
+		c.mu.Lock()
+		if stream == Stdout {
+			c.stdout.write(chunk)
+		} else {
+			c.stderr.write(chunk)
+		}
+		c.mu.Unlock()
+		if next != nil {
+			next(stream, chunk, offset)
+		}
+	}
+}
+
+// fill replaces result's output with what the collector kept.
+func (c *outputCollector) fill(result *Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var report CaptureReport
+	var stdoutCut, stderrCut bool
+	result.Stdout, stdoutCut, report.Stdout = c.stdout.finish()
+	result.Stderr, stderrCut, report.Stderr = c.stderr.finish()
+	result.Truncated = stdoutCut || stderrCut
+	result.Capture = &report
+}
+
+// lineSplitter splits a stream into lines, cutting each to maxLine bytes
+// unless it is 0.
+type lineSplitter struct {
+	maxLine int
+	partial []byte // the line being written
+	size    int    // the length of the line being written, before any cut
+	lines   int
+}
+
+// lineFunc receives a line of a stream, and its length before it was cut
+// to the splitter's maxLine.
+type lineFunc func(line []byte, size int)
+
+// write passes each line p completes to emit.
+func (l *lineSplitter) write(p []byte, emit lineFunc) {
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		line := p
+		if i >= 0 {
+			line = p[:i+1]
+		}
+		p = p[len(line):]
+		l.size += len(line)
+		if l.maxLine > 0 && len(l.partial)+len(line) > l.maxLine {
+			line = line[:max(l.maxLine-len(l.partial), 0)]
+		}
+		l.partial = append(l.partial, line...)
+		if i >= 0 {
+			l.end(emit)
+		}
+	}
+}
+
+// flush passes a last line without a newline to emit.
+func (l *lineSplitter) flush(emit lineFunc) {
+	if l.size > 0 {
+		l.end(emit)
+	}
+}
+
+func (l *lineSplitter) end(emit lineFunc) {
+	line, size := l.partial, l.size
+	l.partial, l.size = nil, 0
+	l.lines++
+	emit(line, size)
+}
+
+// lineSampler keeps a sample of a stream's lines.
+type lineSampler struct {
+	opts  SampleOptions
+	split lineSplitter
+
+	cut   bool // a kept line was cut
+	every int
+	kept  [][]byte
+}
+
+func newLineSampler(opts SampleOptions, maxBytes uint64) *lineSampler {
+	if opts.Lines <= 0 {
+		opts.Lines = defaultSampleLines
+	}
+	s := &lineSampler{opts: opts, every: max(opts.Every, 1)}
+	if maxBytes > 0 {
+		s.split.maxLine = max(int(maxBytes/uint64(opts.Lines)), 1)
+	}
+	return s
+}
+
+func (s *lineSampler) write(p []byte) {
+	s.split.write(p, s.offer)
+}
+
+// offer offers a line written to the sample.
+func (s *lineSampler) offer(line []byte, size int) {
+	n, cut := s.split.lines-1, size > len(line)
+	if s.opts.Random {
+		if len(s.kept) < s.opts.Lines {
+			s.keep(line, cut)
+		} else if j := rand.Intn(s.split.lines); j < s.opts.Lines {
+			// Replace a random kept line, keeping the others in order.
+			copy(s.kept[j:], s.kept[j+1:])
+			s.kept = s.kept[:len(s.kept)-1]
+			s.keep(line, cut)
+		}
+		return
+	}
+	if n%s.every != 0 {
+		return
+	}
+	if len(s.kept) == s.opts.Lines {
+		// Keep lines 0, 2*every, 4*every, ...: every other one kept.
+		j := 0
+		for i := 0; i < len(s.kept); i += 2 {
+			s.kept[j] = s.kept[i]
+			j++
+		}
+		clear(s.kept[j:])
+		s.kept = s.kept[:j]
+		s.every *= 2
+		if n%s.every != 0 {
+			return
+		}
+	}
+	s.keep(line, cut)
+}
+
+func (s *lineSampler) keep(line []byte, cut bool) {
+	s.kept = append(s.kept, line)
+	s.cut = s.cut || cut
+}
+
+func (s *lineSampler) finish() ([]byte, bool, StreamReport) {
+	s.split.flush(s.offer)
+	out := bytes.Join(s.kept, nil)
+	report := StreamReport{Lines: s.split.lines, KeptLines: len(s.kept)}
+	if !s.opts.Random {
+		report.Every = s.every
+	}
+	return out, s.cut || len(s.kept) < s.split.lines, report
+}
+
+// gapMarker returns the line CaptureHeadTail puts in place of what it
+// omitted.
+func gapMarker(omittedBytes, omittedLines int) []byte {
+	return []byte(fmt.Sprintf("[... %d bytes, %d lines omitted ...]\n", omittedBytes, omittedLines))
+}
+
+// bytePreview keeps the first and last bytes of a stream.
+type bytePreview struct {
+	head, tail []byte
+	headMax    int
+	tailMax    int
+	total      int
+	lines      int
+	last       byte // the last byte written
+	// tailStart is the number of bytes written before tail's first byte.
+	tailStart   int
+	linesBefore int // lines ended before tail's first byte
+}
+
+func newBytePreview(opts HeadTailOptions, maxBytes uint64) *bytePreview {
+	half := 4096
+	if maxBytes > 0 {
+		half = max(int(maxBytes/2), 1)
+	}
+	p := &bytePreview{headMax: opts.Head, tailMax: opts.Tail}
+	if p.headMax <= 0 {
+		p.headMax = half
+	}
+	if p.tailMax <= 0 {
+		p.tailMax = half
+	}
+	p.tailStart = p.headMax
+	return p
+}
+
+func (p *bytePreview) write(b []byte) {
+	p.total += len(b)
+	p.lines += bytes.Count(b, []byte{'\n'})
+	if len(b) > 0 {
+		p.last = b[len(b)-1]
+	}
+	if n := min(p.headMax-len(p.head), len(b)); n > 0 {
+		p.head = append(p.head, b[:n]...)
+		b = b[n:]
+	}
+	p.tail = append(p.tail, b...)
+	if drop := len(p.tail) - p.tailMax; drop > 0 && len(p.tail) >= 2*p.tailMax {
+		p.drop(drop)
+	}
+}
+
+// drop removes the first n bytes of tail.
+func (p *bytePreview) drop(n int) {
+	p.linesBefore += bytes.Count(p.tail[:n], []byte{'\n'})
+	p.tailStart += n
+	p.tail = append(p.tail[:0], p.tail[n:]...)
+}
+
+func (p *bytePreview) finish() ([]byte, bool, StreamReport) {
+	if drop := len(p.tail) - p.tailMax; drop > 0 {
+		p.drop(drop)
+	}
+	lines := p.lines
+	if p.total > 0 && p.last != '\n' {
+		lines++
+	}
+	report := StreamReport{Lines: lines}
+	omitted := p.total - len(p.head) - len(p.tail)
+	if omitted == 0 {
+		out := append(p.head, p.tail...)
+		report.KeptLines = lines
+		return out, false, report
+	}
+
+	out := append([]byte(nil), p.head...)
+	if len(out) > 0 && out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
+	headLines := bytes.Count(p.head, []byte{'\n'})
+	report.OmittedBytes = omitted
+	report.OmittedLines = p.linesBefore - headLines
+	out = append(out, gapMarker(report.OmittedBytes, report.OmittedLines)...)
+	out = append(out, p.tail...)
+	report.KeptLines = lines - report.OmittedLines
+	return out, true, report
+}
+
+// linePreview keeps the first and last lines of a stream.
+type linePreview struct {
+	split      lineSplitter
+	headMax    int
+	tailMax    int
+	head, tail [][]byte
+	cut        bool // a kept line was cut
+	// omitted is the size of the lines dropped from the tail, and tailSizes
+	// the sizes of those kept there.
+	omitted   int
+	tailSizes []int
+}
+
+func newLinePreview(opts HeadTailOptions, maxBytes uint64) *linePreview {
+	p := &linePreview{headMax: opts.Head, tailMax: opts.Tail}
+	if p.headMax <= 0 {
+		p.headMax = defaultPreviewLines
+	}
+	if p.tailMax <= 0 {
+		p.tailMax = defaultPreviewLines
+	}
+	if maxBytes > 0 {
+		p.split.maxLine = max(int(maxBytes/uint64(p.headMax+p.tailMax)), 1)
+	}
+	return p
+}
+
+func (p *linePreview) write(b []byte) {
+	p.split.write(b, p.add)
+}
+
+// add keeps line in the head, or in the tail in place of the oldest.
+func (p *linePreview) add(line []byte, size int) {
+	p.cut = p.cut || size > len(line)
+	if len(p.head) < p.headMax {
+		p.head = append(p.head, line)
+		return
+	}
+	if len(p.tail) == p.tailMax {
+		p.omitted += p.tailSizes[0]
+		p.tail[0] = nil
+		p.tail, p.tailSizes = p.tail[1:], p.tailSizes[1:]
+	}
+	p.tail = append(p.tail, line)
+	p.tailSizes = append(p.tailSizes, size)
+}
+
+func (p *linePreview) finish() ([]byte, bool, StreamReport) {
+	p.split.flush(p.add)
+	kept := len(p.head) + len(p.tail)
+	report := StreamReport{Lines: p.split.lines, KeptLines: kept}
+	out := bytes.Join(p.head, nil)
+	if omitted := p.split.lines - kept; omitted > 0 {
+		report.OmittedBytes, report.OmittedLines = p.omitted, omitted
+		out = append(out, gapMarker(p.omitted, omitted)...)
+	}
+	out = append(out, bytes.Join(p.tail, nil)...)
+	return out, p.cut || kept < p.split.lines, report
+}
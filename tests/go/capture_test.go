@@ -0,0 +1,156 @@
+package conch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// lines returns n numbered lines, "0\n" to "n-1\n".
+func lines(n int) []byte {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintln(&sb, i)
+	}
+	return []byte(sb.String())
+}
+
+func TestLineSamplerEvery(t *testing.T) {
+	s := newLineSampler(SampleOptions{Lines: 4, Every: 2}, 0)
+	out := lines(20)
+	// Chunks split lines, as the output callback may.
+	s.write(out[:5])
+	s.write(out[5:])
+	got, cut, report := s.finish()
+	if string(got) != "0\n8\n16\n" {
+		t.Errorf("sample = %q", got)
+	}
+	if !cut || report != (StreamReport{Lines: 20, KeptLines: 3, Every: 8}) {
+		t.Errorf("cut = %v, report = %+v", cut, report)
+	}
+}
+
+func TestLineSamplerKeepsAll(t *testing.T) {
+	s := newLineSampler(SampleOptions{}, 0)
+	s.write([]byte("a\nb\nno newline"))
+	got, cut, report := s.finish()
+	if string(got) != "a\nb\nno newline" || cut || report.Lines != 3 || report.Every != 1 {
+		t.Errorf("sample = %q, cut = %v, report = %+v", got, cut, report)
+	}
+}
+
+func TestLineSamplerRandom(t *testing.T) {
+	s := newLineSampler(SampleOptions{Lines: 10, Random: true}, 0)
+	s.write(lines(1000))
+	got, _, report := s.finish()
+	if report.Lines != 1000 || report.KeptLines != 10 || report.Every != 0 {
+		t.Errorf("report = %+v", report)
+	}
+	prev := -1
+	for _, line := range strings.Fields(string(got)) {
+		var n int
+		fmt.Sscan(line, &n)
+		if n <= prev {
+			t.Errorf("sample %q out of order", got)
+		}
+		prev = n
+	}
+}
+
+func TestLineSamplerCutsLongLines(t *testing.T) {
+	s := newLineSampler(SampleOptions{Lines: 2}, 8)
+	s.write([]byte("short\n" + strings.Repeat("x", 100) + "\n"))
+	got, cut, _ := s.finish()
+	if string(got) != "shor"+"xxxx" || !cut {
+		t.Errorf("sample = %q, cut = %v", got, cut)
+	}
+}
+
+func TestBytePreview(t *testing.T) {
+	p := newBytePreview(HeadTailOptions{Head: 4, Tail: 4}, 0)
+	out := lines(20) // 50 bytes
+	for i := 0; i < len(out); i += 3 {
+		p.write(out[i:min(i+3, len(out))])
+	}
+	got, cut, report := p.finish()
+	if want := "0\n1\n[... 42 bytes, 14 lines omitted ...]\n\n19\n"; string(got) != want {
+		t.Errorf("preview = %q, want %q", got, want)
+	}
+	if !cut || report != (StreamReport{Lines: 20, KeptLines: 6, OmittedBytes: 42, OmittedLines: 14}) {
+		t.Errorf("cut = %v, report = %+v", cut, report)
+	}
+}
+
+func TestBytePreviewKeepsAll(t *testing.T) {
+	p := newBytePreview(HeadTailOptions{}, 16)
+	p.write([]byte("a\nb\nc"))
+	got, cut, report := p.finish()
+	if string(got) != "a\nb\nc" || cut || report != (StreamReport{Lines: 3, KeptLines: 3}) {
+		t.Errorf("preview = %q, cut = %v, report = %+v", got, cut, report)
+	}
+}
+
+func TestLinePreview(t *testing.T) {
+	p := newLinePreview(HeadTailOptions{Head: 2, Tail: 3, Lines: true}, 0)
+	out := lines(20)
+	p.write(out[:7])
+	p.write(out[7:])
+	got, cut, report := p.finish()
+	if want := "0\n1\n[... 37 bytes, 15 lines omitted ...]\n17\n18\n19\n"; string(got) != want {
+		t.Errorf("preview = %q, want %q", got, want)
+	}
+	if !cut || report != (StreamReport{Lines: 20, KeptLines: 5, OmittedBytes: 37, OmittedLines: 15}) {
+		t.Errorf("cut = %v, report = %+v", cut, report)
+	}
+
+	p = newLinePreview(HeadTailOptions{Lines: true}, 0)
+	p.write(lines(5))
+	if got, cut, _ := p.finish(); string(got) != string(lines(5)) || cut {
+		t.Errorf("preview = %q, cut = %v", got, cut)
+	}
+}
+
+func TestCaptureSample(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.ExecuteWithOptions(context.Background(), "i=0; while [ $i -lt 100 ]; do echo $i; i=$((i+1)); done",
+		ExecOptions{Capture: CaptureSample, Sample: SampleOptions{Lines: 10}})
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+	if result.Capture == nil || result.Capture.Stdout.Lines != 100 || result.Capture.Stdout.KeptLines > 10 {
+		t.Fatalf("Capture = %+v", result.Capture)
+	}
+	if !strings.HasPrefix(string(result.Stdout), "0\n") || !result.Truncated || result.StdoutTotalLen != len(lines(100)) {
+		t.Errorf("Stdout = %q, Truncated = %v, StdoutTotalLen = %d", result.Stdout, result.Truncated, result.StdoutTotalLen)
+	}
+}
+
+func TestCaptureHeadTail(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.ExecuteWithOptions(context.Background(), "i=0; while [ $i -lt 100 ]; do echo $i; i=$((i+1)); done",
+		ExecOptions{Capture: CaptureHeadTail, HeadTail: HeadTailOptions{Head: 2, Tail: 2, Lines: true}})
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+	if want := "0\n1\n[... 280 bytes, 96 lines omitted ...]\n98\n99\n"; string(result.Stdout) != want {
+		t.Errorf("Stdout = %q, want %q", result.Stdout, want)
+	}
+	if !result.Truncated || result.Capture == nil || result.Capture.Stdout.OmittedLines != 96 {
+		t.Errorf("Truncated = %v, Capture = %+v", result.Truncated, result.Capture)
+	}
+}
@@ -0,0 +1,117 @@
+// Command conch-embed generates the go:embed boilerplate for bundling the
+// conch shell component into an application.
+//
+// Typical use, from the package that should embed the component:
+//
+//	//go:generate go run github.com/sd2k/conch/tests/go/cmd/conch-embed -pkg main
+//
+// This writes conch_embed.go declaring an embed.FS holding conch_shell.wasm and
+// a newConchExecutor function built on conch.NewExecutorFromFS. With -copy, the
+// component is also copied next to the generated file from the first location
+// in conch.ComponentSearchPaths that has it.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+// options controls the generated file.
+type options struct {
+	Package   string
+	Component string
+	Var       string
+	Func      string
+}
+
+var sourceTemplate = template.Must(template.New("embed").Parse(`// Code generated by conch-embed. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"embed"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+//go:embed {{.Component}}
+var {{.Var}} embed.FS
+
+// {{.Func}} creates a conch executor from the embedded shell component.
+func {{.Func}}() (*conch.Executor, error) {
+	return conch.NewExecutorFromFS({{.Var}}, {{printf "%q" .Component}})
+}
+`))
+
+// generate renders the embed boilerplate for opts.
+func generate(opts options) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := sourceTemplate.Execute(&buf, opts); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+// copyComponent copies the first component found on the search paths to dst.
+func copyComponent(dst string) error {
+	for _, path := range conch.ComponentSearchPaths() {
+		src, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		defer src.Close()
+
+		out, err := os.Create(dst)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, src); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+	return fmt.Errorf("component not found in search paths: %v", conch.ComponentSearchPaths())
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.Package, "pkg", os.Getenv("GOPACKAGE"), "package name of the generated file")
+	flag.StringVar(&opts.Component, "component", "conch_shell.wasm", "component file to embed, relative to the output directory")
+	flag.StringVar(&opts.Var, "var", "conchComponentFS", "name of the generated embed.FS variable")
+	flag.StringVar(&opts.Func, "func", "newConchExecutor", "name of the generated constructor")
+	output := flag.String("o", "conch_embed.go", "output file")
+	copyFlag := flag.Bool("copy", false, "copy the component next to the output file")
+	flag.Parse()
+
+	if opts.Package == "" {
+		fmt.Fprintln(os.Stderr, "conch-embed: -pkg is required outside go generate")
+		os.Exit(2)
+	}
+
+	src, err := generate(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "conch-embed: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*output, src, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "conch-embed: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *copyFlag {
+		dst := filepath.Join(filepath.Dir(*output), opts.Component)
+		if err := copyComponent(dst); err != nil {
+			fmt.Fprintf(os.Stderr, "conch-embed: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	src, err := generate(options{
+		Package:   "app",
+		Component: "assets/shell.wasm",
+		Var:       "shellFS",
+		Func:      "newShell",
+	})
+	if err != nil {
+		t.Fatalf("generate() error = %v", err)
+	}
+
+	for _, want := range []string{
+		"package app",
+		"//go:embed assets/shell.wasm",
+		"var shellFS embed.FS",
+		"func newShell() (*conch.Executor, error)",
+		`conch.NewExecutorFromFS(shellFS, "assets/shell.wasm")`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated source missing %q:\n%s", want, src)
+		}
+	}
+}
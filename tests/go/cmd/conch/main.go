@@ -0,0 +1,196 @@
+// Command conch runs tools built on the conch Go bindings.
+//
+// Usage:
+//
+//	conch bench [flags] script.sh
+//	conch serve [flags]
+//
+// The bench subcommand measures executions per second, p50/p99 latency and
+// memory for a script across backends and pool sizes, and prints a JSON or
+// Markdown report for capacity planning:
+//
+//	conch bench -backends embedded,file -pool 1,4,16 -duration 10s -format markdown script.sh
+//
+// The serve subcommand runs scripts for clients over HTTP. With -ws, each
+// WebSocket connection to /ws runs one script, streaming its output and
+// taking its stdin as it goes, for browser terminals; see
+// conch.WebSocketHandler for the protocol. With -http, scripts POSTed to
+// /execute run and reply with their result, or stream it as server-sent
+// events to clients that accept text/event-stream; see
+// conch.ExecuteHandler. Neither authenticates clients: anyone who can
+// reach the address can run scripts, so keep it on localhost or put it
+// behind an authenticating proxy:
+//
+//	conch serve -addr localhost:8080 -ws -http
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the subcommand in args and returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: conch bench [flags] script | conch serve [flags]")
+		return 2
+	}
+	switch args[0] {
+	case "bench":
+		return bench(args[1:], stdout, stderr)
+	case "serve":
+		return serve(args[1:], stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "conch: unknown command %q\n", args[0])
+		return 2
+	}
+}
+
+// bench implements the bench subcommand.
+func bench(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	backends := fs.String("backends", "auto", "comma-separated backends to measure: auto, embedded, bytes, file")
+	pools := fs.String("pool", "1", "comma-separated numbers of concurrent executors")
+	duration := fs.Duration("duration", 0, "time to measure each backend and pool size (default 5s)")
+	warmup := fs.Duration("warmup", 0, "time to run the script before measuring")
+	component := fs.String("component", "", "shell component for the file and bytes backends")
+	format := fs.String("format", "markdown", "report format: markdown or json")
+	output := fs.String("o", "", "write the report to this file instead of stdout")
+	inline := fs.String("e", "", "script to run, instead of a file")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: conch bench [flags] [script]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := benchConfig(fs.Args(), *inline, *backends, *pools, *component)
+	if err != nil {
+		fmt.Fprintf(stderr, "conch bench: %v\n", err)
+		return 2
+	}
+	cfg.Duration = *duration
+	cfg.Warmup = *warmup
+	write := (*conch.BenchReport).WriteMarkdown
+	switch *format {
+	case "markdown":
+	case "json":
+		write = (*conch.BenchReport).WriteJSON
+	default:
+		fmt.Fprintf(stderr, "conch bench: unknown format %q\n", *format)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report, err := conch.Bench(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "conch bench: %v\n", err)
+		return 1
+	}
+
+	w := stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(stderr, "conch bench: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := write(report, w); err != nil {
+		fmt.Fprintf(stderr, "conch bench: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// benchConfig builds the benchmark from the command line.
+func benchConfig(args []string, inline, backends, pools, component string) (conch.BenchConfig, error) {
+	var cfg conch.BenchConfig
+	switch {
+	case inline != "" && len(args) == 0:
+		cfg.Script = inline
+	case inline == "" && len(args) == 1:
+		script, err := os.ReadFile(args[0])
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Script = string(script)
+	default:
+		return cfg, errors.New("give one script file, or a script with -e")
+	}
+
+	var err error
+	if cfg.Backends, err = parseBackends(backends); err != nil {
+		return cfg, err
+	}
+	if cfg.PoolSizes, err = parsePoolSizes(pools); err != nil {
+		return cfg, err
+	}
+	if component != "" {
+		cfg.Options = append(cfg.Options, conch.WithComponentPath(component))
+	}
+	for _, b := range cfg.Backends {
+		if b != conch.BackendBytes {
+			continue
+		}
+		if component == "" {
+			return cfg, errors.New("the bytes backend needs -component")
+		}
+		data, err := os.ReadFile(component)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Options = append(cfg.Options, conch.WithBytes(data))
+		break
+	}
+	return cfg, nil
+}
+
+// parseBackends parses a comma-separated list of backend names.
+func parseBackends(s string) ([]conch.Backend, error) {
+	var backends []conch.Backend
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for _, b := range []conch.Backend{conch.BackendAuto, conch.BackendEmbedded, conch.BackendBytes, conch.BackendFile} {
+			if strings.TrimSpace(name) == b.String() {
+				backends = append(backends, b)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown backend %q", name)
+		}
+	}
+	return backends, nil
+}
+
+// parsePoolSizes parses a comma-separated list of positive pool sizes.
+func parsePoolSizes(s string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid pool size %q", field)
+		}
+		sizes = append(sizes, n)
+	}
+	return sizes, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+func TestParseBackends(t *testing.T) {
+	got, err := parseBackends("embedded, file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []conch.Backend{conch.BackendEmbedded, conch.BackendFile}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parseBackends() = %v, want %v", got, want)
+	}
+	if _, err := parseBackends("embedded,wasm"); err == nil {
+		t.Error("parseBackends() accepted an unknown backend")
+	}
+}
+
+func TestParsePoolSizes(t *testing.T) {
+	got, err := parsePoolSizes("1,4, 16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 4, 16}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePoolSizes() = %v, want %v", got, want)
+	}
+	for _, bad := range []string{"0", "-1", "x", "1,,2"} {
+		if _, err := parsePoolSizes(bad); err == nil {
+			t.Errorf("parsePoolSizes(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestBenchConfig(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "script.sh")
+	if err := os.WriteFile(script, []byte("echo hi\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := benchConfig([]string{script}, "", "auto", "2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Script != "echo hi\n" || !reflect.DeepEqual(cfg.PoolSizes, []int{2}) {
+		t.Errorf("benchConfig() = %+v", cfg)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		args     []string
+		inline   string
+		backends string
+	}{
+		{"no script", nil, "", "auto"},
+		{"file and inline", []string{script}, "true", "auto"},
+		{"bytes without component", nil, "true", "bytes"},
+	} {
+		if _, err := benchConfig(tt.args, tt.inline, tt.backends, "1", ""); err == nil {
+			t.Errorf("%s: benchConfig() succeeded", tt.name)
+		}
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	var stderr bytes.Buffer
+	if code := run(nil, &stderr, &stderr); code != 2 {
+		t.Errorf("run() = %d, want 2", code)
+	}
+	if code := run([]string{"frobnicate"}, &stderr, &stderr); code != 2 || !strings.Contains(stderr.String(), "unknown command") {
+		t.Errorf("run(frobnicate) = %d, %q", code, stderr.String())
+	}
+	stderr.Reset()
+	if code := run([]string{"bench", "-e", "true", "-format", "xml"}, &stderr, &stderr); code != 2 || !strings.Contains(stderr.String(), "unknown format") {
+		t.Errorf("run(bench -format xml) = %d, %q", code, stderr.String())
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+// shutdownTimeout bounds how long serve waits for executions in flight
+// once interrupted.
+const shutdownTimeout = 10 * time.Second
+
+// serve implements the serve subcommand.
+func serve(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	ws := fs.Bool("ws", false, "run scripts sent over WebSocket connections to /ws")
+	httpExec := fs.Bool("http", false, "run scripts POSTed to /execute, streaming server-sent events to clients that accept them")
+	size := fs.Int("pool", 4, "scripts to run at once, each on an executor of its own")
+	backend := fs.String("backend", "auto", "backend: auto, embedded or file")
+	component := fs.String("component", "", "shell component for the file backend")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: conch serve [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 || (!*ws && !*httpExec) {
+		fmt.Fprintln(stderr, "conch serve: nothing to serve; give -ws or -http")
+		return 2
+	}
+	if *size < 1 {
+		fmt.Fprintln(stderr, "conch serve: -pool must be at least 1")
+		return 2
+	}
+	opts, err := serveOptions(*backend, *component)
+	if err != nil {
+		fmt.Fprintf(stderr, "conch serve: %v\n", err)
+		return 2
+	}
+
+	// Each request gets an executor of its own, so one client's callbacks
+	// never hold up another's script.
+	pool := conch.NewPool(func() (conch.ShellExecutor, error) {
+		return conch.New(opts...)
+	}, conch.PoolOptions{MaxActive: *size})
+	// Fail at start, not on the first request, if no executor can be made.
+	exec, err := pool.Get()
+	if err != nil {
+		pool.Close()
+		fmt.Fprintf(stderr, "conch serve: %v\n", err)
+		return 1
+	}
+	pool.Put(exec)
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		pool.Close()
+		fmt.Fprintf(stderr, "conch serve: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "listening on %s\n", ln.Addr())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	code := 0
+	if err := serveHTTP(ctx, ln, serveMux(pool, *ws, *httpExec)); err != nil {
+		fmt.Fprintf(stderr, "conch serve: %v\n", err)
+		code = 1
+	}
+	if err := pool.Close(); err != nil {
+		fmt.Fprintf(stderr, "conch serve: closing executors: %v\n", err)
+		code = 1
+	}
+	return code
+}
+
+// serveOptions returns the options creating the executor for backend.
+func serveOptions(backend, component string) ([]conch.Option, error) {
+	backends, err := parseBackends(backend)
+	if err != nil {
+		return nil, err
+	}
+	if len(backends) != 1 {
+		return nil, errors.New("give one backend")
+	}
+	switch b := backends[0]; b {
+	case conch.BackendAuto:
+		if component != "" {
+			return []conch.Option{conch.WithComponentPath(component)}, nil
+		}
+		return nil, nil
+	case conch.BackendEmbedded:
+		return []conch.Option{conch.WithEmbedded()}, nil
+	case conch.BackendFile:
+		if component == "" {
+			return nil, errors.New("the file backend needs -component")
+		}
+		return []conch.Option{conch.WithComponentPath(component)}, nil
+	default:
+		return nil, fmt.Errorf("backend %s cannot be served", b)
+	}
+}
+
+// serveMux routes the endpoints asked for to executors from pool: /ws for
+// WebSocket connections and /execute for HTTP requests.
+func serveMux(pool *conch.Pool, ws, httpExec bool) *http.ServeMux {
+	mux := http.NewServeMux()
+	if ws {
+		mux.Handle("/ws", &conch.WebSocketHandler{Pool: pool})
+	}
+	if httpExec {
+		mux.Handle("/execute", &conch.ExecuteHandler{Pool: pool})
+	}
+	return mux
+}
+
+// serveHTTP serves h on ln until ctx is done, then waits up to
+// shutdownTimeout for requests in flight. WebSocket connections are
+// hijacked, which Shutdown does not wait for, so the requests' contexts
+// are cancelled next, stopping their scripts, and serveHTTP waits up to
+// shutdownTimeout again for every handler to return.
+func serveHTTP(ctx context.Context, ln net.Listener, h http.Handler) error {
+	base, stopRequests := context.WithCancel(context.Background())
+	defer stopRequests()
+	var handlers sync.WaitGroup
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers.Add(1)
+			defer handlers.Done()
+			h.ServeHTTP(w, r)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return base },
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		err = srv.Shutdown(shutdownCtx)
+		cancel()
+	}
+
+	stopRequests()
+	done := make(chan struct{})
+	go func() {
+		handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		return errors.Join(err, errors.New("requests still running after shutdown"))
+	}
+	return err
+}
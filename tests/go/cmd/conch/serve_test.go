@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeUsage(t *testing.T) {
+	var stderr bytes.Buffer
+	if code := run([]string{"serve"}, &stderr, &stderr); code != 2 || !strings.Contains(stderr.String(), "give -ws or -http") {
+		t.Errorf("run(serve) = %d, %q", code, stderr.String())
+	}
+	stderr.Reset()
+	if code := run([]string{"serve", "-ws", "-backend", "file"}, &stderr, &stderr); code != 2 || !strings.Contains(stderr.String(), "-component") {
+		t.Errorf("run(serve -backend file) = %d, %q", code, stderr.String())
+	}
+}
+
+func TestServeOptions(t *testing.T) {
+	for _, tt := range []struct {
+		backend, component string
+		want               int
+		ok                 bool
+	}{
+		{"auto", "", 0, true},
+		{"auto", "shell.wasm", 1, true},
+		{"embedded", "", 1, true},
+		{"file", "shell.wasm", 1, true},
+		{"file", "", 0, false},
+		{"bytes", "shell.wasm", 0, false},
+		{"auto,embedded", "", 0, false},
+	} {
+		opts, err := serveOptions(tt.backend, tt.component)
+		if (err == nil) != tt.ok || len(opts) != tt.want {
+			t.Errorf("serveOptions(%q, %q) = %d options, %v", tt.backend, tt.component, len(opts), err)
+		}
+	}
+}
+
+func TestServeMux(t *testing.T) {
+	mux := serveMux(nil, false, true)
+	for path, want := range map[string]int{"/execute": http.StatusMethodNotAllowed, "/ws": http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, w.Code, want)
+		}
+	}
+}
+
+func TestServeHTTPShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serveHTTP(ctx, ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+	}()
+
+	resp, err := http.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("serveHTTP() error after shutdown: %v", err)
+	}
+}
+
+func TestServeHTTPShutdownHijacked(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	hijacked := make(chan struct{})
+	stopped := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- serveHTTP(ctx, ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			close(hijacked)
+			<-r.Context().Done()
+			close(stopped)
+		}))
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	<-hijacked
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("serveHTTP() error after shutdown: %v", err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("serveHTTP() returned before the hijacked handler")
+	}
+}
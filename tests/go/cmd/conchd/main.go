@@ -0,0 +1,195 @@
+// Command conchd serves a warm pool of conch executors on a unix socket,
+// so short-lived processes on a host share it instead of each paying to
+// create an executor. Clients connect with conch.DialDaemon and use the
+// returned client as a conch.ShellExecutor.
+//
+// Usage:
+//
+//	conchd -socket /run/conchd.sock -pool 8
+//
+// The socket is created with mode 0600 unless -mode says otherwise: any
+// process that can connect can run scripts. A stale socket left by a
+// previous run is replaced.
+//
+// On SIGINT or SIGTERM, conchd stops accepting connections and waits up
+// to -drain-timeout for the executions in flight before stopping them. A
+// second signal stops it at once.
+//
+// Under systemd, conchd listens on the sockets passed by socket activation
+// instead of -socket, whose permissions are then the .socket unit's
+// SocketMode, and tells systemd it is ready once its pool is warm, so it
+// can run as a Type=notify service.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the daemon with the flags in args and returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("conchd", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	socket := fs.String("socket", defaultSocket(), "path of the unix socket to listen on")
+	mode := fs.String("mode", "0600", "permissions of the socket, in octal")
+	size := fs.Int("pool", 4, "executors to keep warm and run at once")
+	idle := fs.Duration("idle-timeout", 0, "close executors idle for this long, beyond the warm ones (default never)")
+	component := fs.String("component", "", "shell component file, instead of the embedded shell or the search paths")
+	drain := fs.Duration("drain-timeout", 30*time.Second, "how long to wait for executions in flight when stopping")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	perm, err := strconv.ParseUint(*mode, 8, 32)
+	if err != nil || fs.NArg() != 0 || *size < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	var opts []conch.Option
+	if *component != "" {
+		opts = append(opts, conch.WithComponentPath(*component))
+	}
+	pool := conch.NewPool(func() (conch.ShellExecutor, error) {
+		return conch.New(opts...)
+	}, conch.PoolOptions{MaxActive: *size, IdleTimeout: *idle})
+	defer pool.Close()
+	if err := warm(pool, *size); err != nil {
+		fmt.Fprintf(stderr, "conchd: %v\n", err)
+		return 1
+	}
+
+	lns, err := activationListeners()
+	if err != nil {
+		fmt.Fprintf(stderr, "conchd: %v\n", err)
+		return 1
+	}
+	if lns == nil {
+		ln, err := listen(*socket, os.FileMode(perm))
+		if err != nil {
+			fmt.Fprintf(stderr, "conchd: %v\n", err)
+			return 1
+		}
+		lns = []net.Listener{ln}
+	}
+	for _, ln := range lns {
+		fmt.Fprintf(stdout, "listening on %s\n", ln.Addr())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Once stopping, a second signal gets its default handling.
+	context.AfterFunc(ctx, stop)
+	if err := sdNotify("READY=1"); err != nil {
+		fmt.Fprintf(stderr, "conchd: %v\n", err)
+	}
+	if err := serve(ctx, &conch.DaemonServer{Pool: pool}, lns, *drain); err != nil {
+		fmt.Fprintf(stderr, "conchd: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// defaultSocket is conchd.sock in $XDG_RUNTIME_DIR, or the temporary
+// directory.
+func defaultSocket() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "conchd.sock")
+}
+
+// warm creates n executors in pool up front, so the first clients do not
+// wait for them and a broken setup fails at start.
+func warm(pool *conch.Pool, n int) error {
+	execs := make([]conch.ShellExecutor, 0, n)
+	defer func() {
+		for _, exec := range execs {
+			pool.Put(exec)
+		}
+	}()
+	for i := 0; i < n; i++ {
+		exec, err := pool.Get()
+		if err != nil {
+			return err
+		}
+		execs = append(execs, exec)
+	}
+	return nil
+}
+
+// listen listens on the unix socket at path with permissions perm,
+// replacing a stale socket nothing is listening on.
+func listen(path string, perm os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another daemon is listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	// The socket is created with the umask's permissions; mask them all
+	// until it has perm, so no one can connect in between.
+	umask := syscall.Umask(0o777)
+	ln, err := net.Listen("unix", path)
+	syscall.Umask(umask)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// serve runs s on lns until ctx is done, then shuts it down, waiting up
+// to drain for the executions in flight. If a listener fails, s is closed
+// and the error returned.
+func serve(ctx context.Context, s *conch.DaemonServer, lns []net.Listener, drain time.Duration) error {
+	errc := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func(ln net.Listener) { errc <- s.Serve(ln) }(ln)
+	}
+
+	var err error
+	select {
+	case err = <-errc:
+		s.Close()
+	case <-ctx.Done():
+		sdNotify("STOPPING=1")
+		drainCtx, cancel := context.WithTimeout(context.Background(), drain)
+		defer cancel()
+		if s.Shutdown(drainCtx) != nil {
+			err = fmt.Errorf("stopped executions still running after %v", drain)
+		}
+		if serveErr := <-errc; !errors.Is(serveErr, conch.ErrDaemonClosed) {
+			err = serveErr
+		}
+	}
+	for i := 1; i < len(lns); i++ {
+		<-errc
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+func TestRunUsage(t *testing.T) {
+	for _, args := range [][]string{{"-mode", "rw"}, {"-pool", "0"}, {"extra"}} {
+		var stderr bytes.Buffer
+		if code := run(args, &stderr, &stderr); code != 2 {
+			t.Errorf("run(%q) = %d, want 2", args, code)
+		}
+	}
+}
+
+func TestListen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "conchd.sock")
+
+	ln, err := listen(path, 0o600)
+	if err != nil {
+		t.Skipf("Skipping: cannot listen on a unix socket: %v", err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode = %v, %v", fi.Mode(), err)
+	}
+	if _, err := listen(path, 0o600); err == nil {
+		t.Error("listen() took over a socket in use")
+	}
+
+	// Closing a unix listener removes its socket; a crashed daemon leaves
+	// it behind.
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln, err = listen(path, 0o660)
+	if err != nil {
+		t.Fatalf("listen() over a stale socket error: %v", err)
+	}
+	ln.Close()
+
+	file := filepath.Join(dir, "file")
+	os.WriteFile(file, nil, 0o600)
+	if _, err := listen(file, 0o600); err == nil {
+		t.Error("listen() replaced a regular file")
+	}
+}
+
+func TestServeStops(t *testing.T) {
+	ln, err := listen(filepath.Join(t.TempDir(), "conchd.sock"), 0o600)
+	if err != nil {
+		t.Skipf("Skipping: cannot listen on a unix socket: %v", err)
+	}
+	pool := conch.NewPool(func() (conch.ShellExecutor, error) { return nil, os.ErrNotExist }, conch.PoolOptions{})
+	defer pool.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := serve(ctx, &conch.DaemonServer{Pool: pool}, []net.Listener{ln}, time.Second); err != nil {
+		t.Errorf("serve() = %v, want nil once stopped", err)
+	}
+}
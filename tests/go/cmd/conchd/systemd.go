@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation.
+const listenFDsStart = 3
+
+// activationListeners returns the listeners passed by systemd socket
+// activation, or nil if conchd was not socket activated. It unsets the
+// activation variables, so they do not leak to anything conchd starts.
+func activationListeners() ([]net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid == "" || fds == "" {
+		return nil, nil
+	}
+	// The variables are meant for the process systemd started, not one
+	// that inherited them.
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	files := make([]*os.File, n)
+	for i := range files {
+		fd := listenFDsStart + i
+		files[i] = os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+	}
+	return fileListeners(files)
+}
+
+// fileListeners returns listeners for the sockets in files, closing the
+// files: each listener holds a copy of its descriptor.
+func fileListeners(files []*os.File) ([]net.Listener, error) {
+	lns := make([]net.Listener, 0, len(files))
+	var err error
+	for _, f := range files {
+		if err == nil {
+			var ln net.Listener
+			if ln, err = net.FileListener(f); err == nil {
+				lns = append(lns, ln)
+			} else {
+				err = fmt.Errorf("socket %s passed by systemd: %w", f.Name(), err)
+			}
+		}
+		f.Close()
+	}
+	if err != nil {
+		for _, ln := range lns {
+			ln.Close()
+		}
+		return nil, err
+	}
+	return lns, nil
+}
+
+// sdNotify sends state, such as READY=1, to the service manager at
+// $NOTIFY_SOCKET. It does nothing when that is unset, as it is outside a
+// Type=notify service.
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	// A leading @ names an abstract socket, which package net handles.
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestActivationListenersUnset(t *testing.T) {
+	for _, env := range []struct{ pid, fds string }{
+		{"", ""},
+		{"1", "1"}, // for another process
+	} {
+		t.Setenv("LISTEN_PID", env.pid)
+		t.Setenv("LISTEN_FDS", env.fds)
+		if lns, err := activationListeners(); lns != nil || err != nil {
+			t.Errorf("activationListeners() with %+v = %v, %v", env, lns, err)
+		}
+		if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+			t.Error("activationListeners() left LISTEN_FDS set")
+		}
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "none")
+	if _, err := activationListeners(); err == nil {
+		t.Error("activationListeners() accepted an invalid LISTEN_FDS")
+	}
+}
+
+func TestFileListeners(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conchd.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("Skipping: cannot listen on a unix socket: %v", err)
+	}
+	f, err := ln.(*net.UnixListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lns, err := fileListeners([]*os.File{f})
+	if err != nil || len(lns) != 1 {
+		t.Fatalf("fileListeners() = %v, %v", lns, err)
+	}
+	defer lns[0].Close()
+	defer ln.Close()
+
+	go func() {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+		}
+	}()
+	if conn, err := lns[0].Accept(); err != nil {
+		t.Errorf("Accept() on a passed socket error: %v", err)
+	} else {
+		conn.Close()
+	}
+
+	regular, err := os.CreateTemp(t.TempDir(), "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileListeners([]*os.File{regular}); err == nil {
+		t.Error("fileListeners() accepted a regular file")
+	}
+}
+
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("sdNotify() without a socket = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("Skipping: cannot listen on a unix datagram socket: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatalf("sdNotify() = %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Errorf("notification = %q, %v", buf[:n], err)
+	}
+}
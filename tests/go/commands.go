@@ -0,0 +1,160 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// CommandResult is the outcome of a command serviced from Go.
+type CommandResult struct {
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+}
+
+// CommandNotFoundFunc services a command the sandbox has no implementation
+// for. It returns handled=false to let the shell report "command not found"
+// as usual.
+type CommandNotFoundFunc func(name string, args []string) (handled bool, result CommandResult)
+
+// CommandNotFoundContextFunc is a CommandNotFoundFunc that is also given the
+// context of the execution running the command: the one passed to
+// ExecuteContext and similar, with its values, cancellation and deadline,
+// carrying the execution's ID for ExecutionIDFromContext. Commands of
+// executions started without a context, such as by Execute, get
+// context.Background().
+type CommandNotFoundContextFunc func(ctx context.Context, name string, args []string) (handled bool, result CommandResult)
+
+// commandHandler services an unknown command, including its stdin, for the
+// execution whose context is ctx.
+type commandHandler func(ctx context.Context, name string, args []string, stdin []byte) (bool, CommandResult)
+
+// commandEntry is a registered handler and the executor it serves, and
+// that executor's library.
+type commandEntry struct {
+	h     commandHandler
+	lib   *library
+	owner *Executor
+}
+
+var (
+	// commandCallback is the single C entry point for every executor's
+	// handler; purego callbacks are never freed, so it is created once.
+	commandCallbackOnce sync.Once
+	commandCallback     uintptr
+
+	// commandHandlers maps the user_data passed to the native library to
+	// the handler it stands for.
+	commandHandlersMu sync.RWMutex
+	commandHandlers   = map[uintptr]commandEntry{}
+	nextCommandID     uintptr
+)
+
+// setCommandHandler routes commands with no registered component to h, or
+// removes the handler if h is nil. It runs on a native thread once the shell
+// closes the command's stdin, and may be called concurrently.
+func (e *Executor) setCommandHandler(h commandHandler) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.handle == 0 {
+		return errors.New("executor is closed")
+	}
+	if err := e.lib.commands.load(); err != nil {
+		return err
+	}
+
+	var id, callback uintptr
+	if h != nil {
+		commandCallbackOnce.Do(func() {
+			commandCallback = purego.NewCallback(runCommandCallback)
+		})
+		callback = commandCallback
+
+		commandHandlersMu.Lock()
+		nextCommandID++
+		id = nextCommandID
+		commandHandlers[id] = commandEntry{h: h, lib: e.lib, owner: e}
+		commandHandlersMu.Unlock()
+	}
+
+	ebuf := newErrorBuffer()
+	if e.lib.executorSetCommandHandler(e.handle, callback, id, ebuf.ptr(), errorBufferSize) != 0 {
+		if id != 0 {
+			releaseCommandHandler(id)
+		}
+		return fmt.Errorf("failed to set command handler: %s", ebuf)
+	}
+
+	releaseCommandHandler(e.commandID)
+	e.commandID = id
+	return nil
+}
+
+// releaseCommandHandler forgets the handler registered under id.
+func releaseCommandHandler(id uintptr) {
+	if id == 0 {
+		return
+	}
+	commandHandlersMu.Lock()
+	delete(commandHandlers, id)
+	commandHandlersMu.Unlock()
+}
+
+// runCommandCallback implements ConchCommandCallback.
+func runCommandCallback(id, namePtr, argsPtr, nargs, stdinPtr, stdinLen, out uintptr) uintptr {
+	commandHandlersMu.RLock()
+	entry := commandHandlers[id]
+	commandHandlersMu.RUnlock()
+	if entry.h == nil {
+		return 0
+	}
+
+	ctx := context.Background()
+	if entry.lib != nil && entry.lib.commandContext.load() == nil {
+		var interrupt uintptr
+		if entry.lib.hookInterrupts.load() == nil {
+			interrupt = entry.lib.commandInterrupt(out)
+		}
+		ctx = executionContext(interrupt, goString(entry.lib.commandExecutionID(out)))
+	}
+	ctx = withCallback(ctx, entry.owner)
+
+	args := make([]string, nargs)
+	if nargs > 0 {
+		for i, p := range unsafe.Slice((*uintptr)(unsafe.Pointer(argsPtr)), nargs) {
+			args[i] = goString(p)
+		}
+	}
+
+	handled, result := callCommandHandler(ctx, entry.h, goString(namePtr), args, goBytes(stdinPtr, int(stdinLen)))
+	if !handled {
+		return 0
+	}
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	entry.lib.commandOutputSet(out, int32(result.ExitCode),
+		pinBytes(&pinner, result.Stdout), uintptr(len(result.Stdout)),
+		pinBytes(&pinner, result.Stderr), uintptr(len(result.Stderr)))
+	return 1
+}
+
+// callCommandHandler runs h, turning a panic into a failed command so it
+// cannot unwind through the native library.
+func callCommandHandler(ctx context.Context, h commandHandler, name string, args []string, stdin []byte) (handled bool, result CommandResult) {
+	if perr := protect("command", func() { handled, result = h(ctx, name, args, stdin) }); perr != nil {
+		return true, CommandResult{
+			ExitCode: 1,
+			Stderr:   []byte(fmt.Sprintf("%s: handler panicked: %v\n", name, perr.Value)),
+		}
+	}
+	return handled, result
+}
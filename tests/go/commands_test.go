@@ -0,0 +1,105 @@
+package conch
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestCallCommandHandlerRecoversPanic(t *testing.T) {
+	handled, result := callCommandHandler(context.Background(), func(context.Context, string, []string, []byte) (bool, CommandResult) {
+		panic("boom")
+	}, "deploy", nil, nil)
+
+	if !handled {
+		t.Error("handled = false, want true")
+	}
+	if result.ExitCode != 1 {
+		t.Errorf("ExitCode = %d, want 1", result.ExitCode)
+	}
+	if !strings.Contains(string(result.Stderr), "boom") {
+		t.Errorf("Stderr = %q, want panic message", result.Stderr)
+	}
+}
+
+func TestRunCommandCallbackUnknownHandler(t *testing.T) {
+	if got := runCommandCallback(^uintptr(0), 0, 0, 0, 0, 0, 0); got != 0 {
+		t.Errorf("runCommandCallback() = %d, want 0", got)
+	}
+}
+
+func TestRunCommandCallbackDecodesRequest(t *testing.T) {
+	var gotName string
+	var gotArgs []string
+	var gotStdin []byte
+	id := registerTestCommandHandler(t, func(_ context.Context, name string, args []string, stdin []byte) (bool, CommandResult) {
+		gotName, gotArgs, gotStdin = name, args, stdin
+		return false, CommandResult{}
+	})
+
+	name := nativeBytes(t, []byte("deploy\x00"))
+	argv := []uintptr{nativeBytes(t, []byte("--env\x00")), nativeBytes(t, []byte("prod\x00"))}
+	args := nativeBytes(t, unsafe.Slice((*byte)(unsafe.Pointer(&argv[0])), len(argv)*int(unsafe.Sizeof(argv[0]))))
+	stdin := nativeBytes(t, []byte("payload"))
+
+	if got := runCommandCallback(id, name, args, uintptr(len(argv)), stdin, 7, 0); got != 0 {
+		t.Errorf("runCommandCallback() = %d, want 0 for unhandled command", got)
+	}
+	if gotName != "deploy" {
+		t.Errorf("name = %q, want %q", gotName, "deploy")
+	}
+	if want := []string{"--env", "prod"}; !reflect.DeepEqual(gotArgs, want) {
+		t.Errorf("args = %q, want %q", gotArgs, want)
+	}
+	if string(gotStdin) != "payload" {
+		t.Errorf("stdin = %q, want %q", gotStdin, "payload")
+	}
+}
+
+// registerTestCommandHandler registers h without a native executor.
+func registerTestCommandHandler(t *testing.T, h commandHandler) uintptr {
+	t.Helper()
+	commandHandlersMu.Lock()
+	nextCommandID++
+	id := nextCommandID
+	commandHandlers[id] = commandEntry{h: h}
+	commandHandlersMu.Unlock()
+	t.Cleanup(func() { releaseCommandHandler(id) })
+	return id
+}
+
+func TestOnCommandNotFound(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(Config{
+		Backend: BackendEmbedded,
+		OnCommandNotFound: func(name string, args []string) (bool, CommandResult) {
+			if name != "deploy" {
+				return false, CommandResult{}
+			}
+			return true, CommandResult{Stdout: []byte("deploying " + strings.Join(args, " ") + "\n")}
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.Execute("deploy web prod")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if got := string(result.Stdout); got != "deploying web prod\n" {
+		t.Errorf("stdout = %q, want %q", got, "deploying web prod\n")
+	}
+
+	result, err = exec.Execute("nosuchcommand")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.ExitCode != 127 {
+		t.Errorf("unhandled command exit code = %d, want 127", result.ExitCode)
+	}
+}
@@ -0,0 +1,59 @@
+package conch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"unsafe"
+)
+
+// compressGzip is the CONCH_COMPRESS_GZIP codec of ffi.rs, the one these
+// bindings ask for because the standard library decodes it.
+const compressGzip = 1
+
+// Stream bits in ConchResult.Compressed, matching CONCH_COMPRESSED_* in
+// ffi.rs.
+const (
+	compressedStdout = 1 << iota
+	compressedStderr
+)
+
+// setCompression has the library gzip the streams of the execution that
+// will hold interrupt, an interrupt created by l, once they reach minLen
+// bytes.
+func setCompression(l *library, interrupt uintptr, minLen int) error {
+	ebuf := newErrorBuffer()
+	if l.interruptSetCompression(interrupt, compressGzip, uintptr(minLen), ebuf.ptr(), errorBufferSize) != 0 {
+		return fmt.Errorf("failed to set output compression: %s", ebuf)
+	}
+	return nil
+}
+
+// outputBytes appends the stream of cResult chosen by bit, a
+// ConchResult.Compressed bit, to dst, decompressing it if the library
+// compressed it and keep is false. A nil dst stays nil for an empty stream.
+//
+// The library only compresses with gzip when asked, so data it cannot
+// decode means the result is corrupt, and outputBytes returns an error
+// rather than output that is silently wrong.
+func outputBytes(dst []byte, cResult *ConchResult, bit uint8, keep bool) ([]byte, error) {
+	ptr, length := cResult.StdoutData, int(cResult.StdoutLen)
+	if bit == compressedStderr {
+		ptr, length = cResult.StderrData, int(cResult.StderrLen)
+	}
+	if keep || cResult.Compressed&bit == 0 {
+		if dst == nil {
+			return goBytes(ptr, length), nil
+		}
+		return appendBytes(dst, ptr, length), nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(unsafe.Slice((*byte)(unsafe.Pointer(ptr)), length)))
+	if err == nil {
+		buf := bytes.NewBuffer(dst)
+		if _, err = buf.ReadFrom(zr); err == nil {
+			return buf.Bytes(), nil
+		}
+	}
+	return dst, fmt.Errorf("cannot decompress output: %w", err)
+}
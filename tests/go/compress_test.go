@@ -0,0 +1,121 @@
+package conch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestTakeResultDecompresses(t *testing.T) {
+	stdout := []byte(strings.Repeat("log line\n", 1000))
+	c := fakeConchResult(t, gzipped(t, stdout), []byte("plain"))
+	c.Compressed = compressedStdout
+
+	result := &Result{Stdout: make([]byte, 0, 16)}
+	if err := fillResult(c, result, false); err != nil {
+		t.Fatalf("fillResult() error: %v", err)
+	}
+	if !bytes.Equal(result.Stdout, stdout) || string(result.Stderr) != "plain" || result.StdoutCompressed {
+		t.Errorf("fillResult() = %d bytes/%q, compressed %v, want %d bytes/plain", len(result.Stdout), result.Stderr, result.StdoutCompressed, len(stdout))
+	}
+
+	if got, err := outputBytes(nil, c, compressedStdout, false); err != nil || !bytes.Equal(got, stdout) {
+		t.Errorf("outputBytes() = %d bytes, %v, want %d", len(got), err, len(stdout))
+	}
+	if got, err := outputBytes(nil, fakeConchResult(t, nil, nil), compressedStderr, false); err != nil || got != nil {
+		t.Errorf("outputBytes() of an empty stream = %q, %v, want nil", got, err)
+	}
+}
+
+func TestFillResultKeepCompressed(t *testing.T) {
+	compressed := gzipped(t, []byte(strings.Repeat("log line\n", 1000)))
+	c := fakeConchResult(t, compressed, []byte("plain"))
+	c.Compressed = compressedStdout
+
+	result := &Result{}
+	if err := fillResult(c, result, true); err != nil {
+		t.Fatalf("fillResult() error: %v", err)
+	}
+	if !bytes.Equal(result.Stdout, compressed) || !result.StdoutCompressed {
+		t.Errorf("fillResult() Stdout = %d bytes, compressed %v, want the %d gzip bytes", len(result.Stdout), result.StdoutCompressed, len(compressed))
+	}
+	if string(result.Stderr) != "plain" || result.StderrCompressed {
+		t.Errorf("fillResult() Stderr = %q, compressed %v, want plain", result.Stderr, result.StderrCompressed)
+	}
+}
+
+func TestOutputBytesCorrupt(t *testing.T) {
+	c := fakeConchResult(t, []byte("not gzip"), nil)
+	c.Compressed = compressedStdout
+	if _, err := outputBytes(nil, c, compressedStdout, false); err == nil {
+		t.Error("outputBytes() of corrupt data succeeded")
+	}
+	if err := fillResult(c, &Result{}, false); err == nil {
+		t.Error("fillResult() of corrupt data succeeded")
+	}
+}
+
+func TestExecuteCompressOver(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	script := "i=0; while [ $i -lt 200 ]; do echo 'the same line again'; i=$((i+1)); done; echo short >&2"
+	result, err := exec.ExecuteWithOptions(context.Background(), script, ExecOptions{CompressOver: 64})
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+	if want := strings.Repeat("the same line again\n", 200); string(result.Stdout) != want {
+		t.Errorf("Stdout = %d bytes, want %d", len(result.Stdout), len(want))
+	}
+	if string(result.Stderr) != "short\n" || result.StdoutTotalLen != len(result.Stdout) {
+		t.Errorf("Stderr = %q, StdoutTotalLen = %d", result.Stderr, result.StdoutTotalLen)
+	}
+}
+
+func TestExecuteKeepCompressed(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	script := "i=0; while [ $i -lt 200 ]; do echo 'the same line again'; i=$((i+1)); done"
+	result, err := exec.ExecuteWithOptions(context.Background(), script, ExecOptions{CompressOver: 64, KeepCompressed: true})
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+	if !result.StdoutCompressed {
+		t.Fatal("StdoutCompressed = false, want true")
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(result.Stdout))
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error: %v", err)
+	}
+	got, err := io.ReadAll(zr)
+	if want := strings.Repeat("the same line again\n", 200); err != nil || string(got) != want {
+		t.Errorf("decompressed Stdout = %d bytes, %v, want %d", len(got), err, len(want))
+	}
+}
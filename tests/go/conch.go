@@ -5,12 +5,17 @@
 package conch
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/ebitengine/purego"
@@ -26,6 +31,9 @@ import (
 //	    pub stderr_data: *mut c_char,
 //	    pub stderr_len: usize,
 //	    pub truncated: u8,
+//	    pub traps: u8,
+//	    pub stdout_total_len: usize,
+//	    pub stderr_total_len: usize,
 //	}
 type ConchResult struct {
 	ExitCode   int32
@@ -35,7 +43,26 @@ type ConchResult struct {
 	StderrData uintptr // *c_char
 	StderrLen  uintptr // size_t
 	Truncated  uint8
-	_pad1      [7]byte // padding to align struct
+	Traps      uint8   // CONCH_TRAP_* mask
+	Compressed uint8   // CONCH_COMPRESSED_* mask
+	_pad1      [5]byte // padding to align pointer
+	// StdoutTotalLen and StderrTotalLen count bytes written, including any
+	// dropped by the output limit.
+	StdoutTotalLen uintptr // size_t
+	StderrTotalLen uintptr // size_t
+	// CompileNs, InstantiateNs, ExecuteNs and MarshalNs time the phases of
+	// the execution in nanoseconds; see Timings.
+	CompileNs     uint64
+	InstantiateNs uint64
+	ExecuteNs     uint64
+	MarshalNs     uint64
+	// Diagnostics points to DiagnosticsLen ConchDiagnostics, or is 0 if the
+	// script reported none; see Result.Diagnostics.
+	Diagnostics    uintptr // *ConchDiagnostic
+	DiagnosticsLen uintptr // size_t
+	// Exceeded is the CONCH_LIMIT_* mask of the limits the execution ran
+	// into without failing; see Result.FSExceeded.
+	Exceeded uint32
 }
 
 // Result is the Go-friendly version of ConchResult
@@ -44,23 +71,54 @@ type Result struct {
 	Stdout    []byte
 	Stderr    []byte
 	Truncated bool
+	// FSExceeded reports that a write failed because it would have taken
+	// the script's files past ResourceLimits.MaxFSBytes or TmpConfig.MaxBytes.
+	// The script saw "no space left on device" and may have carried on.
+	FSExceeded bool
+	// StdoutTotalLen and StderrTotalLen are the number of bytes the script
+	// wrote to each stream. When Truncated is set, the difference from
+	// len(Stdout) or len(Stderr) is how much the output limit dropped.
+	StdoutTotalLen int
+	StderrTotalLen int
+	// StdoutCompressed and StderrCompressed report that Stdout or Stderr
+	// holds gzip data rather than the stream itself. They are only set
+	// with ExecOptions.KeepCompressed.
+	StdoutCompressed bool
+	StderrCompressed bool
+	// Traps lists the script's trap handlers that ran as it ended, in
+	// order, such as "EXIT".
+	Traps []string
+	// Error describes why the script failed: the last of its diagnostics.
+	// It is nil when the script exited 0 or the shell and its builtins
+	// reported no error, as when a command other than a builtin failed.
+	Error *Diagnostic
+	// ID identifies the execution: ExecOptions.ID, or one generated for
+	// executions that take a context. The script sees it as
+	// $CONCH_EXECUTION_ID. It is empty for Execute, ExecuteWithLimits and
+	// ExecuteInto.
+	ID string
+	// Timings breaks down where the execution's wall time went.
+	Timings Timings
+	// Vars holds the final values of the variables named in
+	// ExecOptions.CaptureVars that were set. It is nil if none were asked
+	// for, and not filled in if the script was stopped.
+	Vars map[string]string
+	// Capture describes what the capture mode kept of the output, for
+	// modes that report it, such as CaptureSample. It is nil otherwise.
+	Capture *CaptureReport
+
+	// diagnostics are the errors the shell and its builtins reported.
+	diagnostics []Diagnostic
 }
 
 var (
 	libOnce sync.Once
-	lib     uintptr
 	libErr  error
 
-	// Function pointers
-	conchLastError            func() uintptr
-	conchResultFree           func(uintptr)
-	conchHasEmbeddedShell     func() uint8
-	conchExecutorNewEmbedded  func() uintptr
-	conchExecutorNew          func(uintptr) uintptr
-	conchExecutorNewFromBytes func(uintptr, uintptr) uintptr
-	conchExecutorFree         func(uintptr)
-	conchExecute              func(uintptr, uintptr) uintptr
-	conchExecuteWithLimits    func(uintptr, uintptr, uint64, uint64, uint64, uint64) uintptr
+	// libMu guards current, the library new executors are created from.
+	// Reload replaces it.
+	libMu   sync.RWMutex
+	current *library
 )
 
 // libName returns the platform-specific library name
@@ -113,7 +171,12 @@ func findLibrary() (string, error) {
 	return "", fmt.Errorf("library %s not found in search paths: %v", name, searchPaths)
 }
 
-// Init initializes the conch library. It is safe to call multiple times.
+// Init loads the conch library and registers its core exports. It is safe
+// to call multiple times. Exports needed only by optional features are
+// registered when the feature is first used; a library without them fails
+// just that feature, with ErrUnsupportedByLibrary. A library whose result
+// struct does not match ConchResult, or that does not report its layout, is
+// rejected with ErrResultLayout.
 func Init() error {
 	libOnce.Do(func() {
 		libPath, err := findLibrary()
@@ -122,44 +185,42 @@ func Init() error {
 			return
 		}
 
-		lib, err = purego.Dlopen(libPath, purego.RTLD_NOW|purego.RTLD_GLOBAL)
+		l, err := openLibrary(libPath, purego.RTLD_NOW|purego.RTLD_GLOBAL)
 		if err != nil {
-			libErr = fmt.Errorf("failed to load library %s: %w", libPath, err)
+			libErr = err
 			return
 		}
 
-		// Register functions
-		purego.RegisterLibFunc(&conchLastError, lib, "conch_last_error")
-		purego.RegisterLibFunc(&conchResultFree, lib, "conch_result_free")
-		purego.RegisterLibFunc(&conchHasEmbeddedShell, lib, "conch_has_embedded_shell")
-		purego.RegisterLibFunc(&conchExecutorNew, lib, "conch_executor_new")
-		purego.RegisterLibFunc(&conchExecutorNewFromBytes, lib, "conch_executor_new_from_bytes")
-		purego.RegisterLibFunc(&conchExecutorFree, lib, "conch_executor_free")
-		purego.RegisterLibFunc(&conchExecute, lib, "conch_execute")
-		purego.RegisterLibFunc(&conchExecuteWithLimits, lib, "conch_execute_with_limits")
-
-		// Only register embedded executor if available
-		if conchHasEmbeddedShell() == 1 {
-			purego.RegisterLibFunc(&conchExecutorNewEmbedded, lib, "conch_executor_new_embedded")
-		}
+		libMu.Lock()
+		current = l
+		libMu.Unlock()
 	})
 
 	return libErr
 }
 
-// LastError returns the last error message from the conch library.
-// Returns an empty string if no error is set.
-func LastError() string {
+// acquireLibrary loads the library if needed and returns it with a
+// reference held, so a concurrent Reload cannot close it. The caller must
+// release it.
+func acquireLibrary() (*library, error) {
 	if err := Init(); err != nil {
-		return ""
+		return nil, err
 	}
+	libMu.RLock()
+	defer libMu.RUnlock()
+	current.acquire()
+	return current, nil
+}
 
-	ptr := conchLastError()
-	if ptr == 0 {
+// LastError returns the last error message from the conch library.
+// Returns an empty string if no error is set.
+func LastError() string {
+	l, err := acquireLibrary()
+	if err != nil {
 		return ""
 	}
-
-	return goString(ptr)
+	defer l.release()
+	return l.lastErrorMessage()
 }
 
 // goString converts a C string pointer to a Go string
@@ -202,24 +263,33 @@ func goBytes(ptr uintptr, length int) []byte {
 	}
 
 	bytes := make([]byte, length)
-	for i := 0; i < length; i++ {
-		bytes[i] = *(*byte)(unsafe.Pointer(ptr + uintptr(i)))
-	}
+	copy(bytes, unsafe.Slice((*byte)(unsafe.Pointer(ptr)), length))
 
 	return bytes
 }
 
+// appendBytes appends length bytes at ptr to dst.
+func appendBytes(dst []byte, ptr uintptr, length int) []byte {
+	if ptr == 0 || length == 0 {
+		return dst
+	}
+	return append(dst, unsafe.Slice((*byte)(unsafe.Pointer(ptr)), length)...)
+}
+
 // IsAvailable checks if the conch library is available
 func IsAvailable() bool {
 	return Init() == nil
 }
 
-// LibraryPath returns the path to the loaded library, or an error if not loaded
+// LibraryPath returns the path to the loaded library, or an error if not
+// loaded. After Reload it is the path of the library new executors use.
 func LibraryPath() (string, error) {
 	if err := Init(); err != nil {
 		return "", err
 	}
-	return findLibrary()
+	libMu.RLock()
+	defer libMu.RUnlock()
+	return current.path, nil
 }
 
 // ErrLibraryNotFound is returned when the conch library cannot be found
@@ -229,12 +299,47 @@ var ErrLibraryNotFound = errors.New("conch library not found")
 // but the library was not built with the embedded-shell feature
 var ErrNoEmbeddedShell = errors.New("library was not built with embedded-shell feature")
 
+// ErrDepthExceeded is returned when shell functions nest deeper than
+// ResourceLimits.MaxCallDepth, or recurse until the wasm stack runs out.
+var ErrDepthExceeded = errors.New("function call depth exceeded")
+
+// ErrTimeout is wrapped by the error of an execution that ran past
+// ResourceLimits.TimeoutMs.
+var ErrTimeout = errors.New("timeout exceeded")
+
+// ErrScriptTooLarge is returned when a script is longer than
+// ResourceLimits.MaxScriptBytes.
+var ErrScriptTooLarge = errors.New("script too large")
+
+// checkScriptSize returns an error wrapping ErrScriptTooLarge if script is
+// longer than limits allow.
+func checkScriptSize(script string, limits ResourceLimits) error {
+	if limits.MaxScriptBytes > 0 && uint64(len(script)) > limits.MaxScriptBytes {
+		return fmt.Errorf("%w: %d bytes exceeds the %d byte limit", ErrScriptTooLarge, len(script), limits.MaxScriptBytes)
+	}
+	return nil
+}
+
+// failedError wraps msg, the native error of a failed execution, as a Go
+// error, for libraries that do not report the limit it exceeded.
+func failedError(msg string) error {
+	if strings.Contains(msg, ErrDepthExceeded.Error()) {
+		return fmt.Errorf("execution failed: %w", ErrDepthExceeded)
+	}
+	if strings.Contains(msg, ErrTimeout.Error()) {
+		return fmt.Errorf("execution failed: %w", ErrTimeout)
+	}
+	return fmt.Errorf("execution failed: %s", msg)
+}
+
 // HasEmbeddedShell returns true if the library was built with the embedded shell module.
 func HasEmbeddedShell() bool {
-	if err := Init(); err != nil {
+	l, err := acquireLibrary()
+	if err != nil {
 		return false
 	}
-	return conchHasEmbeddedShell() == 1
+	defer l.release()
+	return l.hasEmbeddedShell() == 1
 }
 
 // ResourceLimits configures execution limits for shell scripts
@@ -247,6 +352,22 @@ type ResourceLimits struct {
 	MaxOutputBytes uint64
 	// TimeoutMs is the wall-clock timeout in milliseconds
 	TimeoutMs uint64
+	// MaxCallDepth is the maximum shell function call depth, or 0 for no
+	// limit beyond the wasm stack. Exceeding either fails the execution with
+	// ErrDepthExceeded. Libraries without the conch_execute_*_v2 exports
+	// ignore it.
+	MaxCallDepth uint32
+	// MaxFSBytes caps the bytes the files a script writes to its virtual
+	// filesystem may hold at once, or 0 for no limit. Writes beyond it fail
+	// inside the script with "no space left on device". Files seeded with
+	// AddFile or WithMount do not count. Libraries without the
+	// conch_execute_*_v2 exports ignore it.
+	MaxFSBytes uint64
+	// MaxScriptBytes caps the length of a script, or 0 for no limit. Longer
+	// scripts are rejected with ErrScriptTooLarge before reaching the
+	// native side, which enforces it too. DefaultLimits sets no limit;
+	// ProfileStrict and the other profiles do.
+	MaxScriptBytes uint64
 }
 
 // DefaultLimits returns sensible default resource limits
@@ -256,19 +377,85 @@ func DefaultLimits() ResourceLimits {
 		MaxMemoryBytes: 64 * 1024 * 1024, // 64 MB
 		MaxOutputBytes: 1024 * 1024,      // 1 MB output
 		TimeoutMs:      30000,            // 30 second timeout
+		MaxCallDepth:   100,              // 100 nested function calls
 	}
 }
 
-// Executor wraps a ConchExecutor handle
+// Executor wraps a ConchExecutor handle.
+//
+// An Executor is safe for concurrent use: every execution runs in a fresh
+// native shell instance, and Close waits for in-flight executions to return
+// before freeing the handle.
 type Executor struct {
-	handle uintptr
+	mu      sync.RWMutex
+	handle  uintptr
+	backend Backend
+	// lib is the library the executor was created from, held until Close.
+	lib *library
+
+	componentVersion string
+	// commandID identifies the executor's command handler, if any.
+	commandID uintptr
+	// promptID identifies the executor's prompt handler, if any.
+	promptID uintptr
+	// connectID identifies the executor's connect handler, if any.
+	connectID uintptr
+	// limits replaces DefaultLimits when set; see Limits.
+	limits *ResourceLimits
+	// policy, if set, is consulted before every execution.
+	policy PolicyEvaluator
+	// audit, if set, records every execution.
+	audit AuditSink
+	// allowed, if set, holds the hashes of the only scripts that may run.
+	allowed map[string]struct{}
+	// thread, if set, is the OS thread executions run on.
+	thread *osThread
+	// submitWorkers bounds the scripts Submit runs at once; slots, created
+	// on first use, holds one token per running script.
+	submitWorkers int
+	submitOnce    sync.Once
+	slots         chan struct{}
+	// watchdog, if set, watches interruptible executions for lack of
+	// progress.
+	watchdog *Watchdog
+	// labels are set with WithLabels; see Labels.
+	labels map[string]string
+	// counts are the executor's execution counters; see Stats.
+	countsMu sync.Mutex
+	counts   executorCounts
+	// generation changes whenever a file or function is added, so cached
+	// outputs can tell they may be stale; see IncrementalPipeline.
+	generation atomic.Uint64
+}
+
+// Limits returns the resource limits used by executions that are not given
+// their own: the limits set with WithLimits, or DefaultLimits.
+func (e *Executor) Limits() ResourceLimits {
+	if e.limits != nil {
+		return *e.limits
+	}
+	return DefaultLimits()
 }
 
 // NewExecutor creates a new shell executor from a WASM module file path.
-func NewExecutor(modulePath string) (*Executor, error) {
-	if err := Init(); err != nil {
+func NewExecutor(modulePath string) (_ *Executor, err error) {
+	l, err := acquireLibrary()
+	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err != nil {
+			l.release()
+		}
+	}()
+
+	// An unreadable file is left for the native loader to report.
+	var version string
+	if data, err := os.ReadFile(modulePath); err == nil {
+		if version, err = checkComponentVersion(l, data); err != nil {
+			return nil, err
+		}
+	}
 
 	cPath, err := cString(modulePath)
 	if err != nil {
@@ -276,119 +463,320 @@ func NewExecutor(modulePath string) (*Executor, error) {
 	}
 	defer freeString(cPath)
 
-	handle := conchExecutorNew(cPath)
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	ebuf := newErrorBuffer()
+	handle := l.executorNew(pinBytes(&pinner, cPath.b), ebuf.ptr(), errorBufferSize)
 	if handle == 0 {
-		return nil, fmt.Errorf("failed to create executor: %s", LastError())
+		return nil, fmt.Errorf("failed to create executor: %s", ebuf)
 	}
 
-	return &Executor{handle: handle}, nil
+	return &Executor{handle: handle, backend: BackendFile, lib: l, componentVersion: version}, nil
 }
 
 // NewExecutorFromBytes creates a new shell executor from WASM module bytes.
-func NewExecutorFromBytes(data []byte) (*Executor, error) {
-	if err := Init(); err != nil {
+func NewExecutorFromBytes(data []byte) (_ *Executor, err error) {
+	l, err := acquireLibrary()
+	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err != nil {
+			l.release()
+		}
+	}()
 
 	if len(data) == 0 {
 		return nil, errors.New("module data is empty")
 	}
 
-	handle := conchExecutorNewFromBytes(uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)))
+	version, err := checkComponentVersion(l, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	ebuf := newErrorBuffer()
+	handle := l.executorNewFromBytes(pinBytes(&pinner, data), uintptr(len(data)), ebuf.ptr(), errorBufferSize)
 	if handle == 0 {
-		return nil, fmt.Errorf("failed to create executor: %s", LastError())
+		return nil, fmt.Errorf("failed to create executor: %s", ebuf)
 	}
 
-	return &Executor{handle: handle}, nil
+	return &Executor{handle: handle, backend: BackendBytes, lib: l, componentVersion: version}, nil
+}
+
+// NewExecutorFromFS creates a new shell executor from the WASM component at
+// path in fsys. It is intended for components bundled with go:embed:
+//
+//	//go:embed conch_shell.wasm
+//	var componentFS embed.FS
+//
+//	exec, err := conch.NewExecutorFromFS(componentFS, "conch_shell.wasm")
+//
+// The cmd/conch-embed generator writes this boilerplate for you.
+func NewExecutorFromFS(fsys fs.FS, path string) (*Executor, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read component: %w", err)
+	}
+	return NewExecutorFromBytes(data)
 }
 
 // NewExecutorEmbedded creates a new shell executor using the embedded WASM module.
 // Returns an error if the library was not built with the embedded-shell feature.
-func NewExecutorEmbedded() (*Executor, error) {
-	if err := Init(); err != nil {
+func NewExecutorEmbedded() (_ *Executor, err error) {
+	l, err := acquireLibrary()
+	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err != nil {
+			l.release()
+		}
+	}()
 
-	if !HasEmbeddedShell() {
+	if l.hasEmbeddedShell() != 1 {
 		return nil, ErrNoEmbeddedShell
 	}
+	if err := l.embedded.load(); err != nil {
+		return nil, err
+	}
 
-	handle := conchExecutorNewEmbedded()
+	ebuf := newErrorBuffer()
+	handle := l.executorNewEmbedded(ebuf.ptr(), errorBufferSize)
 	if handle == 0 {
-		return nil, fmt.Errorf("failed to create executor: %s", LastError())
+		return nil, fmt.Errorf("failed to create executor: %s", ebuf)
 	}
 
-	return &Executor{handle: handle}, nil
+	return &Executor{
+		handle:           handle,
+		backend:          BackendEmbedded,
+		lib:              l,
+		componentVersion: componentVersion(embeddedComponentBytes(l)),
+	}, nil
+}
+
+// Backend reports which backend the executor was created from.
+func (e *Executor) Backend() Backend {
+	return e.backend
 }
 
-// Close frees the executor resources.
-func (e *Executor) Close() {
-	if e.handle != 0 {
-		conchExecutorFree(e.handle)
-		e.handle = 0
+// Close frees the executor resources. It waits for executions in progress,
+// so one of the executor's own callbacks must not call it: it would wait
+// for itself.
+func (e *Executor) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.handle == 0 {
+		return nil
+	}
+	e.lib.executorFree(e.handle)
+	e.handle = 0
+	if e.thread != nil {
+		e.thread.stop()
+		e.thread = nil
 	}
+	releaseCommandHandler(e.commandID)
+	e.commandID = 0
+	releasePromptHandler(e.promptID)
+	e.promptID = 0
+	releaseConnectHandler(e.connectID)
+	e.connectID = 0
+	e.lib.release()
+	return nil
 }
 
-// Execute runs a shell script with default resource limits and returns the result.
+// Execute runs a shell script with the executor's limits (see Limits) and
+// returns the result.
 func (e *Executor) Execute(script string) (*Result, error) {
-	return e.ExecuteWithLimits(script, DefaultLimits())
+	return e.ExecuteWithLimits(script, e.Limits())
 }
 
 // ExecuteWithLimits runs a shell script with custom resource limits.
-func (e *Executor) ExecuteWithLimits(script string, limits ResourceLimits) (*Result, error) {
+func (e *Executor) ExecuteWithLimits(script string, limits ResourceLimits) (result *Result, err error) {
+	audit := e.startAudit("", script, nil)
+	defer func() { audit.finish(result, err) }()
+
+	resultPtr, prepare, err := e.execute(script, limits)
+	if err != nil {
+		return nil, err
+	}
+	result, err = takeResult(e.lib, resultPtr, false)
+	if err != nil {
+		return nil, err
+	}
+	result.Timings.Prepare = prepare
+	return result, nil
+}
+
+// ExecuteInto runs a shell script with default resource limits, writing the
+// outcome into result.
+//
+// result.Stdout and result.Stderr are reused (and grown as needed) rather than
+// reallocated, so a service that executes many scripts with one Result avoids
+// a pair of allocations per call. The previous contents are overwritten; copy
+// anything that must outlive the next call.
+func (e *Executor) ExecuteInto(script string, result *Result) error {
+	return e.ExecuteIntoWithLimits(script, e.Limits(), result)
+}
+
+// ExecuteIntoWithLimits is ExecuteInto with custom resource limits.
+func (e *Executor) ExecuteIntoWithLimits(script string, limits ResourceLimits, result *Result) error {
+	if result == nil {
+		return errors.New("result is nil")
+	}
+	audit := e.startAudit("", script, nil)
+	resultPtr, prepare, err := e.execute(script, limits)
+	if err != nil {
+		audit.finish(nil, err)
+		return err
+	}
+	if err := takeResultInto(e.lib, resultPtr, result, false); err != nil {
+		audit.finish(nil, err)
+		return err
+	}
+	result.Timings.Prepare = prepare
+	audit.finish(result, nil)
+	return nil
+}
+
+// execute runs script and returns the unconverted ConchResult pointer and
+// the time spent preparing the script (Timings.Prepare).
+func (e *Executor) execute(script string, limits ResourceLimits) (uintptr, time.Duration, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
 	if e.handle == 0 {
-		return nil, errors.New("executor is closed")
+		return 0, 0, errors.New("executor is closed")
+	}
+	if err := checkScriptSize(script, limits); err != nil {
+		return 0, 0, err
+	}
+	if err := validateUTF8(script); err != nil {
+		return 0, 0, err
+	}
+	start := time.Now()
+	if err := e.checkAllowed(script); err != nil {
+		return 0, 0, err
+	}
+	if err := e.checkPolicy(context.Background(), script); err != nil {
+		return 0, 0, err
 	}
 
 	cScript, err := cString(script)
 	if err != nil {
-		return nil, err
+		return 0, 0, err
 	}
 	defer freeString(cScript)
+	prepare := time.Since(start)
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	scriptPtr := pinBytes(&pinner, cScript.b)
+
+	ebuf := newErrorBuffer()
+	native := nativeLimits(limits)
 
 	var resultPtr uintptr
-	if limits == DefaultLimits() {
-		// Use the simpler execute function for default limits
-		resultPtr = conchExecute(e.handle, cScript)
-	} else {
-		resultPtr = conchExecuteWithLimits(
-			e.handle,
-			cScript,
-			limits.MaxCPUMs,
-			limits.MaxMemoryBytes,
-			limits.MaxOutputBytes,
-			limits.TimeoutMs,
-		)
-	}
+	e.onThread(func() {
+		if limits == DefaultLimits() && e.lib.limitsV2.load() != nil {
+			// Libraries without the v2 exports report no exceeded limits,
+			// so use the simpler execute function for default limits
+			resultPtr = e.lib.execute(e.handle, scriptPtr, ebuf.ptr(), errorBufferSize)
+		} else {
+			resultPtr = e.lib.executeWithLimitsCompat(
+				e.handle,
+				scriptPtr,
+				native,
+				ebuf.ptr(),
+				errorBufferSize,
+			)
+		}
+	})
 
 	if resultPtr == 0 {
-		return nil, fmt.Errorf("execution failed: %s", LastError())
+		return 0, 0, native.failedError(e.lib, ebuf.String())
 	}
 
-	// Convert to Go result
-	cResult := (*ConchResult)(unsafe.Pointer(resultPtr))
-	result := &Result{
-		ExitCode:  int(cResult.ExitCode),
-		Stdout:    goBytes(cResult.StdoutData, int(cResult.StdoutLen)),
-		Stderr:    goBytes(cResult.StderrData, int(cResult.StderrLen)),
-		Truncated: cResult.Truncated != 0,
-	}
+	return resultPtr, prepare, nil
+}
 
-	// Free the C result
-	conchResultFree(resultPtr)
+// ExecuteWithStdin runs a shell script with default resource limits, feeding
+// stdin to the script's standard input.
+//
+// stdin is only borrowed for the duration of the call; see StdinZeroCopy.
+func (e *Executor) ExecuteWithStdin(script string, stdin []byte) (*Result, error) {
+	return e.ExecuteContextWithStdin(context.Background(), script, stdin, e.Limits())
+}
+
+// ExecuteContextWithStdin runs a shell script with custom resource limits,
+// feeding stdin to the script's standard input and stopping it when ctx is
+// done.
+func (e *Executor) ExecuteContextWithStdin(ctx context.Context, script string, stdin []byte, limits ResourceLimits) (*Result, error) {
+	if stdin == nil {
+		stdin = []byte{}
+	}
+	return e.run(ctx, script, ExecOptions{Stdin: stdin}, limits)
+}
 
+// takeResult copies a ConchResult allocated by l into a Go Result and frees
+// the C result. keep leaves compressed streams compressed; see
+// ExecOptions.KeepCompressed.
+func takeResult(l *library, resultPtr uintptr, keep bool) (*Result, error) {
+	result := &Result{}
+	if err := takeResultInto(l, resultPtr, result, keep); err != nil {
+		return nil, err
+	}
 	return result, nil
 }
 
-// cString converts a Go string to a null-terminated C string
-func cString(s string) (uintptr, error) {
-	b := make([]byte, len(s)+1)
-	copy(b, s)
-	b[len(s)] = 0
-	return uintptr(unsafe.Pointer(&b[0])), nil
+// takeResultInto copies a ConchResult allocated by l into an existing Result,
+// reusing its buffers, and frees the C result.
+func takeResultInto(l *library, resultPtr uintptr, result *Result, keep bool) error {
+	start := time.Now()
+	err := fillResult((*ConchResult)(unsafe.Pointer(resultPtr)), result, keep)
+	l.freeResult(resultPtr)
+	result.Timings.Marshal += time.Since(start)
+	return err
+}
+
+// freeResult frees a ConchResult allocated by l. Freeing one twice is a bug
+// in these bindings: libraries that can detect it refuse to free the
+// pointer, and freeResult panics rather than carry on with a result that
+// another caller may still be reading.
+func (l *library) freeResult(resultPtr uintptr) {
+	if l.resultCheck.load() != nil {
+		l.resultFree(resultPtr)
+		return
+	}
+	ebuf := newErrorBuffer()
+	if l.resultFreeChecked(resultPtr, ebuf.ptr(), errorBufferSize) != 0 {
+		panic("conch: " + ebuf.String())
+	}
 }
 
-// freeString is a no-op since we use Go-allocated memory
-func freeString(ptr uintptr) {
-	// Go GC handles this
+// fillResult copies cResult into result, reusing result's byte slices. It
+// fails if a compressed stream cannot be decompressed.
+func fillResult(cResult *ConchResult, result *Result, keep bool) error {
+	var errOut, errErr error
+	result.ExitCode = int(cResult.ExitCode)
+	result.Stdout, errOut = outputBytes(result.Stdout[:0], cResult, compressedStdout, keep)
+	result.Stderr, errErr = outputBytes(result.Stderr[:0], cResult, compressedStderr, keep)
+	result.StdoutCompressed = keep && cResult.Compressed&compressedStdout != 0
+	result.StderrCompressed = keep && cResult.Compressed&compressedStderr != 0
+	result.Truncated = cResult.Truncated != 0
+	result.FSExceeded = cResult.Exceeded&limitFS != 0
+	result.StdoutTotalLen = int(cResult.StdoutTotalLen)
+	result.StderrTotalLen = int(cResult.StderrTotalLen)
+	result.Traps = trapNames(cResult.Traps)
+	result.diagnostics = appendDiagnostics(result.diagnostics[:0], cResult)
+	result.Error = diagnose(result.ExitCode, result.diagnostics)
+	result.ID = ""
+	result.Timings = nativeTimings(cResult)
+	result.Vars = nil
+	return errors.Join(errOut, errErr)
 }
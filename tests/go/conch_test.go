@@ -1,8 +1,16 @@
 package conch
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 	"unsafe"
 )
 
@@ -55,27 +63,28 @@ func TestLastErrorReturnsString(t *testing.T) {
 	}
 }
 
-// TestConchResultLayout verifies the struct layout matches Rust
+// TestConchResultLayout verifies the struct layout matches Rust, field by
+// field, as the library reports it.
 func TestConchResultLayout(t *testing.T) {
-	if !IsAvailable() {
+	l, err := acquireLibrary()
+	if err != nil {
 		t.Skip("Skipping: conch library not available")
 	}
+	defer l.release()
 
-	// Verify struct size is reasonable for the expected layout
-	size := unsafe.Sizeof(ConchResult{})
-
-	// On 64-bit systems:
-	// - int32 (4) + pad (4) = 8
-	// - uintptr (8) = 8
-	// - uintptr (8) = 8
-	// - uintptr (8) = 8
-	// - uintptr (8) = 8
-	// - uint8 (1) + pad (7) = 8
-	// Total = 48 bytes
-	expectedSize := uintptr(48)
-
-	if size != expectedSize {
-		t.Errorf("ConchResult size = %d, expected %d", size, expectedSize)
+	if err := l.layout.load(); err != nil {
+		t.Fatalf("library does not report its layout: %v", err)
+	}
+	var native [32]uintptr
+	n := l.resultLayout(uintptr(unsafe.Pointer(&native[0])), uintptr(len(native)))
+	if n > uintptr(len(native)) {
+		t.Fatalf("library reports %d layout entries", n)
+	}
+	if err := compareResultLayout(native[:n]); err != nil {
+		t.Error(err)
+	}
+	if size := unsafe.Sizeof(ConchResult{}); native[0] != size {
+		t.Errorf("ConchResult size = %d, library size = %d", size, native[0])
 	}
 }
 
@@ -375,6 +384,183 @@ func TestDefaultLimits(t *testing.T) {
 	if limits.TimeoutMs != 30000 {
 		t.Errorf("TimeoutMs = %d, want 30000", limits.TimeoutMs)
 	}
+	if limits.MaxCallDepth != 100 {
+		t.Errorf("MaxCallDepth = %d, want 100", limits.MaxCallDepth)
+	}
+	if limits.MaxScriptBytes != 0 {
+		t.Errorf("MaxScriptBytes = %d, want 0", limits.MaxScriptBytes)
+	}
+}
+
+func TestFailedError(t *testing.T) {
+	err := failedError("execution failed: function call depth exceeded")
+	if !errors.Is(err, ErrDepthExceeded) {
+		t.Errorf("failedError(depth) = %v, want ErrDepthExceeded", err)
+	}
+	err = failedError("execution failed: timeout exceeded")
+	if !errors.Is(err, ErrTimeout) || errors.Is(err, ErrDepthExceeded) {
+		t.Errorf("failedError(timeout) = %v, want ErrTimeout", err)
+	}
+	err = failedError("execution failed: trap")
+	if errors.Is(err, ErrTimeout) || errors.Is(err, ErrDepthExceeded) {
+		t.Errorf("failedError(trap) = %v", err)
+	}
+}
+
+func TestFreeResultTwice(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error = %v", err)
+	}
+	defer exec.Close()
+	l := exec.lib
+	if err := l.resultCheck.load(); err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+
+	resultPtr, _, err := exec.execute("echo hi", DefaultLimits())
+	if err != nil {
+		t.Fatalf("execute() error = %v", err)
+	}
+	l.freeResult(resultPtr)
+	if got := l.resultFreeChecked(resultPtr, nil, 0); got != -1 {
+		t.Errorf("second free = %d, want -1", got)
+	}
+	var foreign ConchResult
+	if got := l.resultFreeChecked(uintptr(unsafe.Pointer(&foreign)), nil, 0); got != -1 {
+		t.Errorf("free of a foreign pointer = %d, want -1", got)
+	}
+	if got := l.resultFreeChecked(0, nil, 0); got != 0 {
+		t.Errorf("free of null = %d, want 0", got)
+	}
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "not a live ConchResult") {
+			t.Errorf("freeResult() of a freed result recovered %v, want a panic", r)
+		}
+	}()
+	l.freeResult(resultPtr)
+}
+
+func TestMaxCallDepth(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error = %v", err)
+	}
+	defer exec.Close()
+
+	limits := DefaultLimits()
+	limits.MaxCallDepth = 20
+
+	result, err := exec.ExecuteWithLimits(`count() { (( $1 > 0 )) && count $(( $1 - 1 )); echo -n .; }; count 10`, limits)
+	if err != nil {
+		t.Fatalf("ExecuteWithLimits(within limit) error = %v", err)
+	}
+	if got := string(result.Stdout); got != "..........." {
+		t.Errorf("Stdout = %q, want 11 dots", got)
+	}
+
+	_, err = exec.ExecuteWithLimits("forever() { forever; }; forever", limits)
+	if !errors.Is(err, ErrDepthExceeded) {
+		t.Errorf("ExecuteWithLimits(unbounded) error = %v, want ErrDepthExceeded", err)
+	}
+
+	// Printing the shell's message is not taken for the limit.
+	result, err = exec.ExecuteWithLimits("echo 'maximum function call depth exceeded' >&2; exit 1", limits)
+	if err != nil {
+		t.Fatalf("ExecuteWithLimits(spoofed) error = %v", err)
+	}
+	if result.ExitCode != 1 {
+		t.Errorf("ExitCode = %d, want 1", result.ExitCode)
+	}
+
+	// Without a limit the wasm stack bounds the recursion, failing the same way.
+	limits.MaxCallDepth = 0
+	_, err = exec.ExecuteContextWithLimits(context.Background(), "forever() { forever; }; forever", limits)
+	if !errors.Is(err, ErrDepthExceeded) {
+		t.Errorf("ExecuteContextWithLimits(no limit) error = %v, want ErrDepthExceeded", err)
+	}
+}
+
+func TestMaxFSBytes(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error = %v", err)
+	}
+	defer exec.Close()
+	if err := exec.AddFile("/data/seed", make([]byte, 64)); err != nil {
+		t.Fatalf("AddFile() error = %v", err)
+	}
+
+	limits := DefaultLimits()
+	limits.MaxFSBytes = 16
+
+	result, err := exec.ExecuteWithLimits("printf '%10s' '' > /tmp/a && echo ok", limits)
+	if err != nil {
+		t.Fatalf("ExecuteWithLimits(within limit) error = %v", err)
+	}
+	if string(result.Stdout) != "ok\n" {
+		t.Errorf("write within the limit: stdout = %q, stderr = %q", result.Stdout, result.Stderr)
+	}
+
+	result, err = exec.ExecuteWithLimits("printf '%10s' '' > /tmp/a && printf '%10s' '' > /data/b && echo ok", limits)
+	if err != nil {
+		t.Fatalf("ExecuteWithLimits(beyond limit) error = %v", err)
+	}
+	if result.ExitCode == 0 || strings.Contains(string(result.Stdout), "ok") {
+		t.Errorf("write beyond the limit succeeded: exit %d, stdout = %q", result.ExitCode, result.Stdout)
+	}
+}
+
+func TestCheckScriptSize(t *testing.T) {
+	limits := ResourceLimits{MaxScriptBytes: 4}
+	if err := checkScriptSize("echo", limits); err != nil {
+		t.Errorf("checkScriptSize(at limit) error = %v", err)
+	}
+	err := checkScriptSize("echo!", limits)
+	if !errors.Is(err, ErrScriptTooLarge) {
+		t.Errorf("checkScriptSize(over limit) error = %v, want ErrScriptTooLarge", err)
+	}
+	if err.Error() != "script too large: 5 bytes exceeds the 4 byte limit" {
+		t.Errorf("error = %q", err)
+	}
+	if err := checkScriptSize(strings.Repeat("x", 1<<20), ResourceLimits{}); err != nil {
+		t.Errorf("checkScriptSize(no limit) error = %v", err)
+	}
+}
+
+func TestMaxScriptBytes(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error = %v", err)
+	}
+	defer exec.Close()
+
+	limits := DefaultLimits()
+	limits.MaxScriptBytes = 16
+	script := "echo " + strings.Repeat("x", 16)
+
+	if _, err := exec.ExecuteWithLimits(script, limits); !errors.Is(err, ErrScriptTooLarge) {
+		t.Errorf("ExecuteWithLimits() error = %v, want ErrScriptTooLarge", err)
+	}
+	if _, err := exec.ExecuteContextWithLimits(context.Background(), script, limits); !errors.Is(err, ErrScriptTooLarge) {
+		t.Errorf("ExecuteContextWithLimits() error = %v, want ErrScriptTooLarge", err)
+	}
+	result, err := exec.ExecuteWithLimits("echo ok", limits)
+	if err != nil {
+		t.Fatalf("ExecuteWithLimits(within limit) error = %v", err)
+	}
+	if string(result.Stdout) != "ok\n" {
+		t.Errorf("Stdout = %q, want ok", result.Stdout)
+	}
 }
 
 func BenchmarkExecuteEcho(b *testing.B) {
@@ -692,3 +878,136 @@ func TestVariablesNotShared(t *testing.T) {
 		t.Errorf("Second execution: Stdout = %q, want %q (variable should not persist)", stdout2, "unset")
 	}
 }
+
+// ==================== Context Tests ====================
+
+func TestExecuteContext(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error = %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.ExecuteContext(context.Background(), "echo hello")
+	if err != nil {
+		t.Fatalf("ExecuteContext() error = %v", err)
+	}
+
+	stdout := strings.TrimSpace(string(result.Stdout))
+	if stdout != "hello" {
+		t.Errorf("Stdout = %q, want %q", stdout, "hello")
+	}
+}
+
+func TestExecuteContextAlreadyCancelled(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error = %v", err)
+	}
+	defer exec.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = exec.ExecuteContext(ctx, "echo hello")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ExecuteContext() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestExecuteContextDeadline(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error = %v", err)
+	}
+	defer exec.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = exec.ExecuteContext(ctx, "while true; do :; done")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ExecuteContext() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ExecuteContext() took %v to honour a 200ms deadline", elapsed)
+	}
+}
+
+func TestExecuteContextMaxCPU(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error = %v", err)
+	}
+	defer exec.Close()
+
+	limits := DefaultLimits()
+	limits.MaxCPUMs = 200
+
+	start := time.Now()
+	_, err = exec.ExecuteContextWithLimits(context.Background(), "while true; do :; done", limits)
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("ExecuteContextWithLimits() error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ExecuteContextWithLimits() took %v to honour a 200ms CPU limit", elapsed)
+	}
+}
+
+func TestExecutorCloseWaitsForExecution(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error = %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := exec.Execute("for i in 1 2 3 4 5; do echo $i; done")
+		done <- err
+	}()
+	exec.Close()
+
+	// Either the execution finished before Close, or it observed the closed
+	// executor; it must never run against a freed handle.
+	if err := <-done; err != nil && !strings.Contains(err.Error(), "closed") {
+		t.Errorf("Execute() racing Close() error = %v", err)
+	}
+}
+
+func TestNewExecutorFromFSMissing(t *testing.T) {
+	if !IsAvailable() {
+		t.Skip("Skipping: conch library not available")
+	}
+
+	_, err := NewExecutorFromFS(fstest.MapFS{}, "conch_shell.wasm")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("NewExecutorFromFS() error = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestNewExecutorFromFS(t *testing.T) {
+	if !IsAvailable() {
+		t.Skip("Skipping: conch library not available")
+	}
+	path, err := findComponent()
+	if err != nil {
+		t.Skipf("Skipping: %v", err)
+	}
+
+	exec, err := NewExecutorFromFS(os.DirFS(filepath.Dir(path)), filepath.Base(path))
+	if err != nil {
+		t.Fatalf("NewExecutorFromFS() error = %v", err)
+	}
+	defer exec.Close()
+}
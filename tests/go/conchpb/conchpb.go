@@ -0,0 +1,303 @@
+// Package conchpb holds the protobuf messages in result.proto, for carrying
+// conch requests and results between services.
+//
+// The types are maintained by hand to match result.proto and encode the
+// standard proto3 wire format, so this package needs no protobuf runtime.
+// Messages it produces can be decoded by any generated protobuf code for the
+// same schema, and vice versa. Keep both files in sync when the schema
+// changes.
+package conchpb
+
+import (
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+// ExecRequest is a request to run a script.
+type ExecRequest struct {
+	// ID is chosen by the caller and echoed in the matching ExecResponse.
+	ID string
+	// Script is the shell script to execute.
+	Script string
+	// Stdin is the script's standard input.
+	Stdin []byte
+	// Limits, if set, overrides the executor's default resource limits.
+	Limits *ResourceLimits
+}
+
+// Marshal encodes r in protobuf wire format.
+func (r *ExecRequest) Marshal() ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, r.ID)
+	b = appendString(b, 2, r.Script)
+	b = appendBytes(b, 3, r.Stdin)
+	if r.Limits != nil {
+		limits, _ := r.Limits.Marshal()
+		b = appendMessage(b, 4, limits)
+	}
+	return b, nil
+}
+
+// Unmarshal decodes r from protobuf wire format, ignoring unknown fields.
+func (r *ExecRequest) Unmarshal(b []byte) error {
+	*r = ExecRequest{}
+	d := decoder{b: b}
+	for {
+		ok, err := d.next()
+		if !ok || err != nil {
+			return err
+		}
+		switch d.field {
+		case 1:
+			if err := d.expect(wireBytes); err != nil {
+				return err
+			}
+			r.ID = string(d.bytes)
+		case 2:
+			if err := d.expect(wireBytes); err != nil {
+				return err
+			}
+			r.Script = string(d.bytes)
+		case 3:
+			if err := d.expect(wireBytes); err != nil {
+				return err
+			}
+			r.Stdin = append([]byte(nil), d.bytes...)
+		case 4:
+			if err := d.expect(wireBytes); err != nil {
+				return err
+			}
+			r.Limits = new(ResourceLimits)
+			if err := r.Limits.Unmarshal(d.bytes); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ResourceLimits are the resource limits for one execution.
+type ResourceLimits struct {
+	MaxCPUMs       uint64
+	MaxMemoryBytes uint64
+	MaxOutputBytes uint64
+	TimeoutMs      uint64
+	MaxCallDepth   uint32
+	MaxFSBytes     uint64
+	MaxScriptBytes uint64
+}
+
+// LimitsFrom converts conch limits to their protobuf form.
+func LimitsFrom(l conch.ResourceLimits) *ResourceLimits {
+	return &ResourceLimits{
+		MaxCPUMs:       l.MaxCPUMs,
+		MaxMemoryBytes: l.MaxMemoryBytes,
+		MaxOutputBytes: l.MaxOutputBytes,
+		TimeoutMs:      l.TimeoutMs,
+		MaxCallDepth:   l.MaxCallDepth,
+		MaxFSBytes:     l.MaxFSBytes,
+		MaxScriptBytes: l.MaxScriptBytes,
+	}
+}
+
+// ToLimits converts l to conch limits.
+func (l *ResourceLimits) ToLimits() conch.ResourceLimits {
+	return conch.ResourceLimits{
+		MaxCPUMs:       l.MaxCPUMs,
+		MaxMemoryBytes: l.MaxMemoryBytes,
+		MaxOutputBytes: l.MaxOutputBytes,
+		TimeoutMs:      l.TimeoutMs,
+		MaxCallDepth:   l.MaxCallDepth,
+		MaxFSBytes:     l.MaxFSBytes,
+		MaxScriptBytes: l.MaxScriptBytes,
+	}
+}
+
+// Marshal encodes l in protobuf wire format.
+func (l *ResourceLimits) Marshal() ([]byte, error) {
+	var b []byte
+	b = appendUint(b, 1, l.MaxCPUMs)
+	b = appendUint(b, 2, l.MaxMemoryBytes)
+	b = appendUint(b, 3, l.MaxOutputBytes)
+	b = appendUint(b, 4, l.TimeoutMs)
+	b = appendUint(b, 5, uint64(l.MaxCallDepth))
+	b = appendUint(b, 6, l.MaxFSBytes)
+	b = appendUint(b, 7, l.MaxScriptBytes)
+	return b, nil
+}
+
+// Unmarshal decodes l from protobuf wire format, ignoring unknown fields.
+func (l *ResourceLimits) Unmarshal(b []byte) error {
+	*l = ResourceLimits{}
+	d := decoder{b: b}
+	for {
+		ok, err := d.next()
+		if !ok || err != nil {
+			return err
+		}
+		var dst *uint64
+		switch d.field {
+		case 1:
+			dst = &l.MaxCPUMs
+		case 2:
+			dst = &l.MaxMemoryBytes
+		case 3:
+			dst = &l.MaxOutputBytes
+		case 4:
+			dst = &l.TimeoutMs
+		case 5:
+			if err := d.expect(wireVarint); err != nil {
+				return err
+			}
+			l.MaxCallDepth = uint32(d.varint)
+			continue
+		case 6:
+			dst = &l.MaxFSBytes
+		case 7:
+			dst = &l.MaxScriptBytes
+		default:
+			continue
+		}
+		if err := d.expect(wireVarint); err != nil {
+			return err
+		}
+		*dst = d.varint
+	}
+}
+
+// Result is the outcome of a script.
+type Result struct {
+	ExitCode  int32
+	Stdout    []byte
+	Stderr    []byte
+	Truncated bool
+}
+
+// ResultFrom converts a conch result to its protobuf form.
+func ResultFrom(r *conch.Result) *Result {
+	return &Result{
+		ExitCode:  int32(r.ExitCode),
+		Stdout:    r.Stdout,
+		Stderr:    r.Stderr,
+		Truncated: r.Truncated,
+	}
+}
+
+// ToResult converts r to a conch result.
+func (r *Result) ToResult() *conch.Result {
+	return &conch.Result{
+		ExitCode:  int(r.ExitCode),
+		Stdout:    r.Stdout,
+		Stderr:    r.Stderr,
+		Truncated: r.Truncated,
+	}
+}
+
+// Marshal encodes r in protobuf wire format.
+func (r *Result) Marshal() ([]byte, error) {
+	var b []byte
+	b = appendInt32(b, 1, r.ExitCode)
+	b = appendBytes(b, 2, r.Stdout)
+	b = appendBytes(b, 3, r.Stderr)
+	b = appendBool(b, 4, r.Truncated)
+	return b, nil
+}
+
+// Unmarshal decodes r from protobuf wire format, ignoring unknown fields.
+func (r *Result) Unmarshal(b []byte) error {
+	*r = Result{}
+	d := decoder{b: b}
+	for {
+		ok, err := d.next()
+		if !ok || err != nil {
+			return err
+		}
+		switch d.field {
+		case 1:
+			if err := d.expect(wireVarint); err != nil {
+				return err
+			}
+			r.ExitCode = int32(d.varint)
+		case 2:
+			if err := d.expect(wireBytes); err != nil {
+				return err
+			}
+			r.Stdout = append([]byte(nil), d.bytes...)
+		case 3:
+			if err := d.expect(wireBytes); err != nil {
+				return err
+			}
+			r.Stderr = append([]byte(nil), d.bytes...)
+		case 4:
+			if err := d.expect(wireVarint); err != nil {
+				return err
+			}
+			r.Truncated = d.varint != 0
+		}
+	}
+}
+
+// ExecResponse is the response to an ExecRequest.
+type ExecResponse struct {
+	// ID is the request's ID.
+	ID string
+	// Result is set if the script ran.
+	Result *Result
+	// Error is non-empty if the sandbox itself failed, as opposed to the
+	// script exiting non-zero.
+	Error string
+}
+
+// ResponseFrom builds the response to the request with the given id from
+// the return values of an execution.
+func ResponseFrom(id string, result *conch.Result, err error) *ExecResponse {
+	resp := &ExecResponse{ID: id}
+	if err != nil {
+		resp.Error = err.Error()
+	} else if result != nil {
+		resp.Result = ResultFrom(result)
+	}
+	return resp
+}
+
+// Marshal encodes r in protobuf wire format.
+func (r *ExecResponse) Marshal() ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, r.ID)
+	if r.Result != nil {
+		result, _ := r.Result.Marshal()
+		b = appendMessage(b, 2, result)
+	}
+	b = appendString(b, 3, r.Error)
+	return b, nil
+}
+
+// Unmarshal decodes r from protobuf wire format, ignoring unknown fields.
+func (r *ExecResponse) Unmarshal(b []byte) error {
+	*r = ExecResponse{}
+	d := decoder{b: b}
+	for {
+		ok, err := d.next()
+		if !ok || err != nil {
+			return err
+		}
+		switch d.field {
+		case 1:
+			if err := d.expect(wireBytes); err != nil {
+				return err
+			}
+			r.ID = string(d.bytes)
+		case 2:
+			if err := d.expect(wireBytes); err != nil {
+				return err
+			}
+			r.Result = new(Result)
+			if err := r.Result.Unmarshal(d.bytes); err != nil {
+				return err
+			}
+		case 3:
+			if err := d.expect(wireBytes); err != nil {
+				return err
+			}
+			r.Error = string(d.bytes)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package conchpb
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+func TestResultEncoding(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Result
+		want []byte
+	}{
+		{"empty", Result{}, nil},
+		{"exit and stdout", Result{ExitCode: 1, Stdout: []byte("hi")}, []byte{0x08, 0x01, 0x12, 0x02, 'h', 'i'}},
+		{"truncated", Result{Truncated: true}, []byte{0x20, 0x01}},
+		{
+			"negative exit",
+			Result{ExitCode: -1},
+			[]byte{0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.in.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("Marshal = % x, want % x", got, tt.want)
+			}
+			var back Result
+			if err := back.Unmarshal(got); err != nil {
+				t.Fatal(err)
+			}
+			if back.ExitCode != tt.in.ExitCode || !bytes.Equal(back.Stdout, tt.in.Stdout) || back.Truncated != tt.in.Truncated {
+				t.Errorf("round trip = %+v, want %+v", back, tt.in)
+			}
+		})
+	}
+}
+
+func TestExecRequestRoundTrip(t *testing.T) {
+	limits := conch.DefaultLimits()
+	limits.MaxFSBytes = 1 << 20
+	in := ExecRequest{
+		ID:     "req-1",
+		Script: "cat | wc -l",
+		Stdin:  []byte("a\nb\n"),
+		Limits: LimitsFrom(limits),
+	}
+	data, err := in.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out ExecRequest
+	if err := out.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip = %+v, want %+v", out, in)
+	}
+	if out.Limits.ToLimits() != limits {
+		t.Errorf("limits = %+v, want %+v", out.Limits.ToLimits(), limits)
+	}
+}
+
+func TestExecRequestEmptyLimits(t *testing.T) {
+	// A present but all-default limits message must survive the trip.
+	in := ExecRequest{Limits: &ResourceLimits{}}
+	data, _ := in.Marshal()
+	if !bytes.Equal(data, []byte{0x22, 0x00}) {
+		t.Fatalf("Marshal = % x", data)
+	}
+	var out ExecRequest
+	if err := out.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if out.Limits == nil {
+		t.Error("Limits = nil after round trip")
+	}
+}
+
+func TestExecResponseFrom(t *testing.T) {
+	resp := ResponseFrom("a", &conch.Result{ExitCode: 2, Stderr: []byte("oops")}, nil)
+	data, _ := resp.Marshal()
+	var out ExecResponse
+	if err := out.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if out.ID != "a" || out.Error != "" || out.Result == nil {
+		t.Fatalf("response = %+v", out)
+	}
+	if got := out.Result.ToResult(); got.ExitCode != 2 || string(got.Stderr) != "oops" {
+		t.Errorf("result = %+v", got)
+	}
+
+	resp = ResponseFrom("b", nil, errors.New("sandbox crashed"))
+	data, _ = resp.Marshal()
+	if err := out.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if out.ID != "b" || out.Error != "sandbox crashed" || out.Result != nil {
+		t.Errorf("response = %+v", out)
+	}
+}
+
+func TestUnmarshalSkipsUnknownFields(t *testing.T) {
+	data := []byte{
+		0x08, 0x03, // exit_code = 3
+		0x78, 0x2a, // field 15, varint
+		0x81, 0x01, 1, 2, 3, 4, 5, 6, 7, 8, // field 16, fixed64
+		0x8a, 0x01, 0x01, 'x', // field 17, bytes
+		0x8d, 0x01, 1, 2, 3, 4, // field 17, fixed32
+		0x12, 0x01, 'o', // stdout = "o"
+	}
+	var r Result
+	if err := r.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if r.ExitCode != 3 || string(r.Stdout) != "o" {
+		t.Errorf("Unmarshal = %+v", r)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"truncated varint": {0x08, 0xff},
+		"truncated bytes":  {0x12, 0x05, 'a'},
+		"wrong wire type":  {0x0a, 0x00},
+		"field zero":       {0x00, 0x00},
+		"group wire type":  {0x0b},
+	} {
+		var r Result
+		if err := r.Unmarshal(data); err == nil {
+			t.Errorf("%s: Unmarshal succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package conchpb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("conchpb: truncated message")
+
+func appendTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+// appendUint appends a varint field, omitting the zero value.
+func appendUint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.AppendUvarint(appendTag(b, field, wireVarint), v)
+}
+
+// appendInt32 appends an int32 field; negative values are sign-extended to
+// ten bytes as the protobuf spec requires.
+func appendInt32(b []byte, field int, v int32) []byte {
+	return appendUint(b, field, uint64(int64(v)))
+}
+
+func appendBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendUint(b, field, 1)
+}
+
+// appendBytes appends a length-delimited field, omitting empty values.
+func appendBytes(b []byte, field int, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(appendTag(b, field, wireBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendString(b []byte, field int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = binary.AppendUvarint(appendTag(b, field, wireBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendMessage appends an embedded message field. Unlike scalars, an empty
+// but present message is still written.
+func appendMessage(b []byte, field int, msg []byte) []byte {
+	b = binary.AppendUvarint(appendTag(b, field, wireBytes), uint64(len(msg)))
+	return append(b, msg...)
+}
+
+// decoder walks the fields of one message.
+type decoder struct {
+	b []byte
+	// Set by next.
+	field, wireType int
+	varint          uint64
+	bytes           []byte
+}
+
+// next reads the next field, reporting false at the end of the message.
+// Fields of every wire type are consumed, so callers can ignore unknown ones.
+func (d *decoder) next() (bool, error) {
+	if len(d.b) == 0 {
+		return false, nil
+	}
+	tag, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		return false, errTruncated
+	}
+	d.b = d.b[n:]
+	if tag>>3 == 0 || tag>>3 > math.MaxInt32 {
+		return false, fmt.Errorf("conchpb: invalid field number %d", tag>>3)
+	}
+	d.field, d.wireType = int(tag>>3), int(tag&7)
+
+	switch d.wireType {
+	case wireVarint:
+		v, n := binary.Uvarint(d.b)
+		if n <= 0 {
+			return false, errTruncated
+		}
+		d.varint, d.b = v, d.b[n:]
+	case wireBytes:
+		l, n := binary.Uvarint(d.b)
+		if n <= 0 || l > uint64(len(d.b)-n) {
+			return false, errTruncated
+		}
+		d.bytes, d.b = d.b[n:n+int(l)], d.b[n+int(l):]
+	case wireFixed64:
+		if len(d.b) < 8 {
+			return false, errTruncated
+		}
+		d.b = d.b[8:]
+	case wireFixed32:
+		if len(d.b) < 4 {
+			return false, errTruncated
+		}
+		d.b = d.b[4:]
+	default:
+		return false, fmt.Errorf("conchpb: unsupported wire type %d", d.wireType)
+	}
+	return true, nil
+}
+
+// expect checks the current field has the wire type its schema declares.
+func (d *decoder) expect(wireType int) error {
+	if d.wireType != wireType {
+		return fmt.Errorf("conchpb: field %d has wire type %d, want %d", d.field, d.wireType, wireType)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+// Package conchtest helps test code that runs scripts with conch.
+package conchtest
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+// Fault is a failure a ChaosExecutor can inject.
+type Fault int
+
+const (
+	// FaultTimeout fails the call without running the script, as if it
+	// timed out.
+	FaultTimeout Fault = iota
+	// FaultTruncate runs the script and cuts its stdout in half, marking
+	// the result truncated as the output limit would.
+	FaultTruncate
+	// FaultTrap runs the script and reports it stopped by a timeout after
+	// its TERM and EXIT traps ran, as a *conch.TrapError.
+	FaultTrap
+	// FaultPartialOutput runs the script and silently drops the second half
+	// of its stdout, as a stream cut short would.
+	FaultPartialOutput
+)
+
+var faultNames = [...]string{"timeout", "truncate", "trap", "partial-output"}
+
+func (f Fault) String() string {
+	if f < 0 || int(f) >= len(faultNames) {
+		return "unknown"
+	}
+	return faultNames[f]
+}
+
+// ErrInjectedTimeout is the error returned for FaultTimeout and wrapped by
+// the one for FaultTrap. Like the library's own timeouts, it wraps
+// conch.ErrTimeout.
+var ErrInjectedTimeout = fmt.Errorf("execution failed: injected fault: %w", conch.ErrTimeout)
+
+// Chaos configures a ChaosExecutor.
+type Chaos struct {
+	// Rate is the probability, from 0 to 1, that a call gets a fault.
+	Rate float64
+	// Faults are the faults to choose from, uniformly. Defaults to all of
+	// them.
+	Faults []Fault
+	// Seed seeds the choices, so a failing test can be replayed.
+	Seed int64
+}
+
+// ChaosExecutor is a conch.ShellExecutor injecting faults into the results
+// of the executor it wraps, so error handling can be exercised without
+// crafting hostile scripts. It is meant for tests.
+type ChaosExecutor struct {
+	exec   conch.ShellExecutor
+	faults []Fault
+	rate   float64
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	injected map[Fault]int
+}
+
+var _ conch.ShellExecutor = (*ChaosExecutor)(nil)
+
+// NewChaos returns a ChaosExecutor wrapping exec.
+func NewChaos(exec conch.ShellExecutor, c Chaos) *ChaosExecutor {
+	faults := c.Faults
+	if len(faults) == 0 {
+		faults = []Fault{FaultTimeout, FaultTruncate, FaultTrap, FaultPartialOutput}
+	}
+	return &ChaosExecutor{
+		exec:     exec,
+		faults:   faults,
+		rate:     c.Rate,
+		rand:     rand.New(rand.NewSource(c.Seed)),
+		injected: map[Fault]int{},
+	}
+}
+
+// Injected returns how many times each fault has been injected.
+func (c *ChaosExecutor) Injected() map[Fault]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	injected := make(map[Fault]int, len(c.injected))
+	for f, n := range c.injected {
+		injected[f] = n
+	}
+	return injected
+}
+
+// Execute implements conch.ShellExecutor.
+func (c *ChaosExecutor) Execute(script string) (*conch.Result, error) {
+	return c.run(func() (*conch.Result, error) { return c.exec.Execute(script) })
+}
+
+// ExecuteContext implements conch.ShellExecutor.
+func (c *ChaosExecutor) ExecuteContext(ctx context.Context, script string) (*conch.Result, error) {
+	return c.run(func() (*conch.Result, error) { return c.exec.ExecuteContext(ctx, script) })
+}
+
+// ExecuteWithStdin implements conch.ShellExecutor.
+func (c *ChaosExecutor) ExecuteWithStdin(script string, stdin []byte) (*conch.Result, error) {
+	return c.run(func() (*conch.Result, error) { return c.exec.ExecuteWithStdin(script, stdin) })
+}
+
+// Close closes the wrapped executor.
+func (c *ChaosExecutor) Close() error {
+	return c.exec.Close()
+}
+
+// pick decides whether the next call gets a fault, and which.
+func (c *ChaosExecutor) pick() (Fault, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rand.Float64() >= c.rate {
+		return 0, false
+	}
+	f := c.faults[c.rand.Intn(len(c.faults))]
+	c.injected[f]++
+	return f, true
+}
+
+// run calls execute, injecting a fault into its outcome if one is picked.
+func (c *ChaosExecutor) run(execute func() (*conch.Result, error)) (*conch.Result, error) {
+	fault, ok := c.pick()
+	if !ok {
+		return execute()
+	}
+	if fault == FaultTimeout {
+		return nil, ErrInjectedTimeout
+	}
+	result, err := execute()
+	if err != nil {
+		return result, err
+	}
+	switch fault {
+	case FaultTruncate:
+		result.Stdout = result.Stdout[:len(result.Stdout)/2]
+		result.Truncated = true
+	case FaultTrap:
+		return nil, &conch.TrapError{
+			Err: ErrInjectedTimeout,
+			Result: &conch.Result{
+				ExitCode:       conch.ExitCodeSignalBase + 15, // SIGTERM
+				Stdout:         result.Stdout,
+				Stderr:         result.Stderr,
+				StdoutTotalLen: result.StdoutTotalLen,
+				StderrTotalLen: result.StderrTotalLen,
+				Traps:          []string{"TERM", "EXIT"},
+				ID:             result.ID,
+			},
+		}
+	case FaultPartialOutput:
+		result.Stdout = result.Stdout[:len(result.Stdout)/2]
+		result.StdoutTotalLen = len(result.Stdout)
+	}
+	return result, nil
+}
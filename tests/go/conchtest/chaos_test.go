@@ -0,0 +1,105 @@
+package conchtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+// echoExecutor is a ShellExecutor whose scripts print "0123456789".
+type echoExecutor struct{ closed bool }
+
+func (e *echoExecutor) Execute(string) (*conch.Result, error) {
+	return &conch.Result{Stdout: []byte("0123456789"), StdoutTotalLen: 10}, nil
+}
+
+func (e *echoExecutor) ExecuteContext(context.Context, string) (*conch.Result, error) {
+	return e.Execute("")
+}
+
+func (e *echoExecutor) ExecuteWithStdin(string, []byte) (*conch.Result, error) {
+	return e.Execute("")
+}
+
+func (e *echoExecutor) Close() error {
+	e.closed = true
+	return nil
+}
+
+func TestChaosFaults(t *testing.T) {
+	tests := []struct {
+		fault Fault
+		check func(*conch.Result, error) bool
+	}{
+		{FaultTimeout, func(r *conch.Result, err error) bool {
+			return r == nil && errors.Is(err, ErrInjectedTimeout) && errors.Is(err, conch.ErrTimeout)
+		}},
+		{FaultTruncate, func(r *conch.Result, err error) bool {
+			return err == nil && r.Truncated && string(r.Stdout) == "01234" && r.StdoutTotalLen == 10
+		}},
+		{FaultTrap, func(r *conch.Result, err error) bool {
+			var trapErr *conch.TrapError
+			return r == nil && errors.As(err, &trapErr) && trapErr.Result.ExitCode == 143 &&
+				errors.Is(err, ErrInjectedTimeout)
+		}},
+		{FaultPartialOutput, func(r *conch.Result, err error) bool {
+			return err == nil && !r.Truncated && string(r.Stdout) == "01234" && r.StdoutTotalLen == 5
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fault.String(), func(t *testing.T) {
+			c := NewChaos(&echoExecutor{}, Chaos{Rate: 1, Faults: []Fault{tt.fault}})
+			r, err := c.Execute("echo")
+			if !tt.check(r, err) {
+				t.Errorf("Execute() = %+v, %v", r, err)
+			}
+			if got := c.Injected()[tt.fault]; got != 1 {
+				t.Errorf("Injected()[%v] = %d, want 1", tt.fault, got)
+			}
+		})
+	}
+}
+
+func TestChaosRate(t *testing.T) {
+	c := NewChaos(&echoExecutor{}, Chaos{Rate: 0})
+	for i := 0; i < 100; i++ {
+		if _, err := c.ExecuteContext(context.Background(), "echo"); err != nil {
+			t.Fatalf("Rate 0 injected a fault: %v", err)
+		}
+	}
+
+	count := func(seed int64) int {
+		c := NewChaos(&echoExecutor{}, Chaos{Rate: 0.3, Seed: seed})
+		for i := 0; i < 1000; i++ {
+			_, _ = c.ExecuteWithStdin("cat", nil)
+		}
+		total := 0
+		for _, n := range c.Injected() {
+			total += n
+		}
+		return total
+	}
+	n := count(42)
+	if n < 200 || n > 400 {
+		t.Errorf("Rate 0.3 injected %d faults in 1000 calls", n)
+	}
+	if count(42) != n {
+		t.Error("the same seed injected a different number of faults")
+	}
+}
+
+func TestChaosClose(t *testing.T) {
+	exec := &echoExecutor{}
+	NewChaos(exec, Chaos{}).Close()
+	if !exec.closed {
+		t.Error("Close() did not close the wrapped executor")
+	}
+}
+
+func TestFaultString(t *testing.T) {
+	if FaultPartialOutput.String() != "partial-output" || Fault(99).String() != "unknown" {
+		t.Errorf("String() = %q, %q", FaultPartialOutput, Fault(99))
+	}
+}
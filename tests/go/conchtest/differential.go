@@ -0,0 +1,206 @@
+package conchtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+// Differential runs scripts both in conch and in bash on the host and
+// compares the outcomes, to measure how much of a script library behaves
+// the same in the sandbox.
+//
+// Bash runs the scripts for real, with the caller's privileges: only compare
+// scripts you would run on the host anyway.
+type Differential struct {
+	// Bash is the bash to compare against. Defaults to "bash" on PATH.
+	Bash string
+	// Dir is bash's working directory. Defaults to a new temporary
+	// directory per script, removed afterwards.
+	Dir string
+	// Timeout bounds each run on either side. Defaults to 30 seconds.
+	Timeout time.Duration
+	// CompareStderr also compares stderr. Off by default, since error
+	// messages are worded differently even when behaviour matches.
+	CompareStderr bool
+}
+
+// Outcome is how one side ran a script.
+type Outcome struct {
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+	// Err is set if the script could not be run to completion.
+	Err error
+}
+
+// Comparison is the result of running one script on both sides.
+type Comparison struct {
+	Script string
+	Conch  Outcome
+	Bash   Outcome
+	// Mismatches names what differed: "error", "exit", "stdout" or
+	// "stderr".
+	Mismatches []string
+}
+
+// Compatible reports whether both sides behaved the same.
+func (c Comparison) Compatible() bool {
+	return len(c.Mismatches) == 0
+}
+
+// String summarizes the comparison.
+func (c Comparison) String() string {
+	if c.Compatible() {
+		return "compatible"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "differs in %s", strings.Join(c.Mismatches, ", "))
+	for _, m := range c.Mismatches {
+		switch m {
+		case "error":
+			fmt.Fprintf(&b, "\n  conch error: %v\n  bash error: %v", c.Conch.Err, c.Bash.Err)
+		case "exit":
+			fmt.Fprintf(&b, "\n  exit: conch %d, bash %d", c.Conch.ExitCode, c.Bash.ExitCode)
+		case "stdout":
+			fmt.Fprintf(&b, "\n  stdout (-bash +conch):\n%s", lineDiff(c.Bash.Stdout, c.Conch.Stdout))
+		case "stderr":
+			fmt.Fprintf(&b, "\n  stderr (-bash +conch):\n%s", lineDiff(c.Bash.Stderr, c.Conch.Stderr))
+		}
+	}
+	return b.String()
+}
+
+// Report summarizes a batch of comparisons.
+type Report struct {
+	Comparisons []Comparison
+	// Compatible counts the comparisons where both sides matched.
+	Compatible int
+}
+
+// Rate returns the fraction of compatible scripts, from 0 to 1.
+func (r Report) Rate() float64 {
+	if len(r.Comparisons) == 0 {
+		return 0
+	}
+	return float64(r.Compatible) / float64(len(r.Comparisons))
+}
+
+// Compare runs script with exec and with bash and compares them. It returns
+// an error only if bash itself cannot be started.
+func (d Differential) Compare(ctx context.Context, exec conch.ShellExecutor, script string) (Comparison, error) {
+	bash, err := d.runBash(ctx, script)
+	if err != nil {
+		return Comparison{}, err
+	}
+	c := Comparison{Script: script, Conch: d.runConch(ctx, exec, script), Bash: bash}
+	switch {
+	case (c.Conch.Err == nil) != (c.Bash.Err == nil):
+		c.Mismatches = append(c.Mismatches, "error")
+	case c.Conch.Err != nil:
+		return c, nil
+	}
+	if c.Conch.ExitCode != c.Bash.ExitCode {
+		c.Mismatches = append(c.Mismatches, "exit")
+	}
+	if !bytes.Equal(c.Conch.Stdout, c.Bash.Stdout) {
+		c.Mismatches = append(c.Mismatches, "stdout")
+	}
+	if d.CompareStderr && !bytes.Equal(c.Conch.Stderr, c.Bash.Stderr) {
+		c.Mismatches = append(c.Mismatches, "stderr")
+	}
+	return c, nil
+}
+
+// CompareAll compares every script in turn, stopping at the first error.
+func (d Differential) CompareAll(ctx context.Context, exec conch.ShellExecutor, scripts []string) (Report, error) {
+	var r Report
+	for _, script := range scripts {
+		c, err := d.Compare(ctx, exec, script)
+		if err != nil {
+			return r, err
+		}
+		r.Comparisons = append(r.Comparisons, c)
+		if c.Compatible() {
+			r.Compatible++
+		}
+	}
+	return r, nil
+}
+
+func (d Differential) timeout() time.Duration {
+	if d.Timeout > 0 {
+		return d.Timeout
+	}
+	return 30 * time.Second
+}
+
+func (d Differential) runConch(ctx context.Context, exec conch.ShellExecutor, script string) Outcome {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout())
+	defer cancel()
+	result, err := exec.ExecuteContext(ctx, script)
+	if err != nil {
+		return Outcome{Err: err}
+	}
+	return Outcome{ExitCode: result.ExitCode, Stdout: result.Stdout, Stderr: result.Stderr}
+}
+
+// runBash runs script with bash, returning an error if bash cannot start.
+func (d Differential) runBash(ctx context.Context, script string) (Outcome, error) {
+	bash := d.Bash
+	if bash == "" {
+		bash = "bash"
+	}
+	path, err := exec.LookPath(bash)
+	if err != nil {
+		return Outcome{}, fmt.Errorf("bash not available: %w", err)
+	}
+	dir := d.Dir
+	if dir == "" {
+		dir, err = os.MkdirTemp("", "conch-differential-")
+		if err != nil {
+			return Outcome{}, err
+		}
+		defer os.RemoveAll(dir)
+	}
+
+	f, err := os.CreateTemp("", "conch-differential-*.sh")
+	if err != nil {
+		return Outcome{}, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(script)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Outcome{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeout())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, f.Name())
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr) && ctx.Err() == nil:
+		return Outcome{ExitCode: exitErr.ExitCode(), Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}, nil
+	case ctx.Err() != nil:
+		return Outcome{Err: ctx.Err()}, nil
+	case err != nil:
+		return Outcome{}, err
+	}
+	return Outcome{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}, nil
+}
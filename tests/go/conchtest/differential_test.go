@@ -0,0 +1,88 @@
+package conchtest
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+// fixedExecutor is a ShellExecutor returning the same result for every
+// script.
+type fixedExecutor struct {
+	echoExecutor
+	result conch.Result
+}
+
+func (f *fixedExecutor) ExecuteContext(context.Context, string) (*conch.Result, error) {
+	r := f.result
+	return &r, nil
+}
+
+func TestDifferential(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("Skipping: bash not available")
+	}
+	ctx := context.Background()
+	var d Differential
+
+	same := &fixedExecutor{result: conch.Result{ExitCode: 3, Stdout: []byte("hi\n"), Stderr: []byte("conch: oops\n")}}
+	c, err := d.Compare(ctx, same, "echo hi; echo oops >&2; exit 3")
+	if err != nil {
+		t.Fatalf("Compare() error: %v", err)
+	}
+	if !c.Compatible() {
+		t.Errorf("Compare() = %s, want compatible", c)
+	}
+
+	d.CompareStderr = true
+	c, err = d.Compare(ctx, same, "echo hello; exit 0")
+	if err != nil {
+		t.Fatalf("Compare() error: %v", err)
+	}
+	if got := strings.Join(c.Mismatches, ","); got != "exit,stdout,stderr" {
+		t.Errorf("Mismatches = %q, want exit,stdout,stderr", got)
+	}
+	if s := c.String(); !strings.Contains(s, "-hello") || !strings.Contains(s, "+hi") {
+		t.Errorf("String() = %q", s)
+	}
+
+	r, err := Differential{}.CompareAll(ctx, same, []string{"echo hi; exit 3", "echo no"})
+	if err != nil {
+		t.Fatalf("CompareAll() error: %v", err)
+	}
+	if r.Compatible != 1 || r.Rate() != 0.5 {
+		t.Errorf("CompareAll() = %d compatible, rate %v", r.Compatible, r.Rate())
+	}
+}
+
+func TestDifferentialNoBash(t *testing.T) {
+	d := Differential{Bash: "/nonexistent/bash"}
+	if _, err := d.Compare(context.Background(), &fixedExecutor{}, "true"); err == nil {
+		t.Error("Compare() without bash succeeded")
+	}
+}
+
+func TestDifferentialEmbedded(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("Skipping: bash not available")
+	}
+	if !conch.IsAvailable() || !conch.HasEmbeddedShell() {
+		t.Skip("Skipping: embedded shell not available")
+	}
+	e, err := conch.New(conch.WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer e.Close()
+
+	c, err := Differential{}.Compare(context.Background(), e, "x=3; echo $((x * 2)); for i in a b; do echo $i; done")
+	if err != nil {
+		t.Fatalf("Compare() error: %v", err)
+	}
+	if !c.Compatible() {
+		t.Errorf("Compare() = %s", c)
+	}
+}
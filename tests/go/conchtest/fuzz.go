@@ -0,0 +1,91 @@
+package conchtest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+// FuzzSeeds is the seed corpus of FuzzExecuteScript: scripts aimed at the
+// marshaling between Go and the native library rather than at the shell.
+var FuzzSeeds = []string{
+	"",
+	"echo hello",
+	"echo 'unterminated",
+	"echo \"$(echo \"$(echo nested)\")\"",
+	"printf '%s\\n' héllo wörld 日本語 🐚",
+	"echo \u202eevil\u202c; echo \ufeffbom",
+	"echo a\x00b",
+	"\x00",
+	"echo \xff\xfe invalid utf-8",
+	"cat <<EOF\nheredoc\nEOF",
+	"printf '\\0\\0\\0' | cat",
+	"x=$(printf '%*s' 100000 ''); echo ${#x}",
+	"yes | head -c 1000000",
+	"exit 255",
+	"kill -9 $$",
+	strings.Repeat(":\n", 50000),
+	strings.Repeat("(", 1000) + strings.Repeat(")", 1000),
+	strings.Repeat("a", 1<<20),
+}
+
+// FuzzTimeout bounds each execution of FuzzExecuteScript, so inputs that
+// loop forever do not stall the fuzzer.
+var FuzzTimeout = 2 * time.Second
+
+// FuzzExecuteScript fuzzes exec with arbitrary scripts, starting from
+// FuzzSeeds. Run it from a fuzz target:
+//
+//	func FuzzExecute(f *testing.F) {
+//		exec, err := conch.New()
+//		if err != nil {
+//			f.Skip(err)
+//		}
+//		defer exec.Close()
+//		conchtest.FuzzExecuteScript(f, exec)
+//	}
+//
+// then go test -fuzz=FuzzExecute. Scripts may fail; the fuzzer looks for
+// crashes, results that contradict themselves, and executors left unusable.
+func FuzzExecuteScript(f *testing.F, exec conch.ShellExecutor) {
+	for _, seed := range FuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, script string) {
+		ctx, cancel := context.WithTimeout(context.Background(), FuzzTimeout)
+		defer cancel()
+		result, err := exec.ExecuteContext(ctx, script)
+		CheckResult(t, result, err)
+
+		after, err := exec.Execute("echo ok")
+		if err != nil || after == nil || string(after.Stdout) != "ok\n" {
+			t.Fatalf("executor unusable after %q: %+v, %v", script, after, err)
+		}
+	})
+}
+
+// CheckResult fails t if result and err, returned by one execution,
+// contradict each other or themselves.
+func CheckResult(t testing.TB, result *conch.Result, err error) {
+	t.Helper()
+	if err != nil {
+		return
+	}
+	if result == nil {
+		t.Fatal("nil result without an error")
+	}
+	if result.ExitCode < 0 || result.ExitCode > 255 {
+		t.Errorf("exit code %d out of range", result.ExitCode)
+	}
+	if len(result.Stdout) > result.StdoutTotalLen || len(result.Stderr) > result.StderrTotalLen {
+		t.Errorf("kept more output than written: stdout %d of %d, stderr %d of %d",
+			len(result.Stdout), result.StdoutTotalLen, len(result.Stderr), result.StderrTotalLen)
+	}
+	if !result.Truncated && (len(result.Stdout) != result.StdoutTotalLen || len(result.Stderr) != result.StderrTotalLen) {
+		t.Errorf("output dropped without Truncated: stdout %d of %d, stderr %d of %d",
+			len(result.Stdout), result.StdoutTotalLen, len(result.Stderr), result.StderrTotalLen)
+	}
+}
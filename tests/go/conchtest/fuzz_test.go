@@ -0,0 +1,67 @@
+package conchtest
+
+import (
+	"testing"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+func FuzzEmbeddedExecute(f *testing.F) {
+	if !conch.IsAvailable() || !conch.HasEmbeddedShell() {
+		f.Skip("Skipping: embedded shell not available")
+	}
+	exec, err := conch.New(conch.WithEmbedded())
+	if err != nil {
+		f.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+	FuzzExecuteScript(f, exec)
+}
+
+func TestCheckResult(t *testing.T) {
+	good := []*conch.Result{
+		{},
+		{ExitCode: 255, Stdout: []byte("ab"), StdoutTotalLen: 2},
+		{Stdout: []byte("ab"), StdoutTotalLen: 10, Truncated: true},
+	}
+	for _, r := range good {
+		if failed(func(t testing.TB) { CheckResult(t, r, nil) }) {
+			t.Errorf("CheckResult(%+v) failed", r)
+		}
+	}
+	bad := []*conch.Result{
+		nil,
+		{ExitCode: 256},
+		{Stdout: []byte("abc"), StdoutTotalLen: 2, Truncated: true},
+		{Stderr: []byte("ab"), StderrTotalLen: 10},
+	}
+	for _, r := range bad {
+		if !failed(func(t testing.TB) { CheckResult(t, r, nil) }) {
+			t.Errorf("CheckResult(%+v) passed", r)
+		}
+	}
+}
+
+// recorder is a testing.TB recording whether it failed.
+type recorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recorder) Helper()               {}
+func (r *recorder) Errorf(string, ...any) { r.failed = true }
+func (r *recorder) Fatal(...any)          { r.failed = true; panic(r) }
+func (r *recorder) Fatalf(string, ...any) { r.failed = true; panic(r) }
+
+// failed reports whether check failed the TB it was given.
+func failed(check func(testing.TB)) (failed bool) {
+	r := &recorder{}
+	defer func() {
+		if v := recover(); v != nil && v != any(r) {
+			panic(v)
+		}
+		failed = r.failed
+	}()
+	check(r)
+	return r.failed
+}
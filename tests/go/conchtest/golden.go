@@ -0,0 +1,110 @@
+package conchtest
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+// update rewrites golden files instead of comparing against them.
+var update = flag.Bool("conchtest.update", false, "rewrite conchtest golden files")
+
+// Golden runs the script at scriptPath on a new executor created with opts
+// and compares its exit code, stdout and stderr with scriptPath+".golden".
+// Run the tests with -conchtest.update to write the golden files from the
+// current behaviour.
+//
+// A golden file holds the three sections in order:
+//
+//	-- exit --
+//	0
+//	-- stdout --
+//	hello
+//	-- stderr --
+func Golden(t testing.TB, scriptPath string, opts ...conch.Option) {
+	t.Helper()
+	exec, err := conch.New(opts...)
+	if err != nil {
+		t.Fatalf("creating executor: %v", err)
+	}
+	defer exec.Close()
+	GoldenExec(t, exec, scriptPath)
+}
+
+// GoldenExec is Golden running the script on exec.
+func GoldenExec(t testing.TB, exec conch.ShellExecutor, scriptPath string) {
+	t.Helper()
+	script, err := os.ReadFile(scriptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := exec.Execute(string(script))
+	if err != nil {
+		t.Fatalf("executing %s: %v", scriptPath, err)
+	}
+	got := formatGolden(result)
+
+	goldenPath := scriptPath + ".golden"
+	if *update {
+		if err := os.WriteFile(goldenPath, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(goldenPath)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("%s does not exist; run with -conchtest.update to create it", goldenPath)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s: output differs from %s (-want +got):\n%s", scriptPath, goldenPath, lineDiff(want, got))
+	}
+}
+
+// formatGolden renders result as a golden file.
+func formatGolden(result *conch.Result) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "-- exit --\n%s\n", strconv.Itoa(result.ExitCode))
+	for _, s := range []struct {
+		name string
+		data []byte
+	}{{"stdout", result.Stdout}, {"stderr", result.Stderr}} {
+		fmt.Fprintf(&b, "-- %s --\n", s.name)
+		b.Write(s.data)
+		if len(s.data) > 0 && s.data[len(s.data)-1] != '\n' {
+			b.WriteString("\n-- no newline at end --\n")
+		}
+	}
+	return b.Bytes()
+}
+
+// lineDiff lists the lines of want and got from the first that differs,
+// prefixed with - and +.
+func lineDiff(want, got []byte) string {
+	w := bytes.SplitAfter(want, []byte("\n"))
+	g := bytes.SplitAfter(got, []byte("\n"))
+	i := 0
+	for i < len(w) && i < len(g) && bytes.Equal(w[i], g[i]) {
+		i++
+	}
+	var b bytes.Buffer
+	for _, line := range w[i:] {
+		if len(line) > 0 {
+			fmt.Fprintf(&b, "-%s", line)
+		}
+	}
+	for _, line := range g[i:] {
+		if len(line) > 0 {
+			fmt.Fprintf(&b, "+%s", line)
+		}
+	}
+	return b.String()
+}
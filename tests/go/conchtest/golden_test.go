@@ -0,0 +1,57 @@
+package conchtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+// catExecutor is a ShellExecutor whose scripts print themselves and exit 3.
+type catExecutor struct{ echoExecutor }
+
+func (catExecutor) Execute(script string) (*conch.Result, error) {
+	return &conch.Result{ExitCode: 3, Stdout: []byte(script), Stderr: []byte("warn\n")}, nil
+}
+
+func TestGoldenExec(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "hello.sh")
+	if err := os.WriteFile(script, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if !failed(func(tb testing.TB) { GoldenExec(tb, &catExecutor{}, script) }) {
+		t.Error("GoldenExec passed without a golden file")
+	}
+
+	*update = true
+	GoldenExec(t, &catExecutor{}, script)
+	*update = false
+	golden, err := os.ReadFile(script + ".golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "-- exit --\n3\n-- stdout --\nhello\n-- no newline at end --\n-- stderr --\nwarn\n"
+	if string(golden) != want {
+		t.Errorf("golden file = %q, want %q", golden, want)
+	}
+	if failed(func(tb testing.TB) { GoldenExec(tb, &catExecutor{}, script) }) {
+		t.Error("GoldenExec failed against the file it wrote")
+	}
+
+	if err := os.WriteFile(script, []byte("hello, world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !failed(func(tb testing.TB) { GoldenExec(tb, &catExecutor{}, script) }) {
+		t.Error("GoldenExec passed with changed output")
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	got := lineDiff([]byte("a\nb\nc\n"), []byte("a\nx\nc\n"))
+	if got != "-b\n-c\n+x\n+c\n" {
+		t.Errorf("lineDiff() = %q", got)
+	}
+}
@@ -0,0 +1,156 @@
+package conch
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// ContentType classifies output by its format; see DetectContentType.
+type ContentType int
+
+const (
+	// ContentEmpty is output with nothing but whitespace.
+	ContentEmpty ContentType = iota
+	// ContentText is UTF-8 text matching none of the structured formats.
+	ContentText
+	// ContentJSON is a single JSON object or array, possibly spread over
+	// several lines.
+	ContentJSON
+	// ContentNDJSON is newline-delimited JSON: more than one line, each a
+	// JSON object or array.
+	ContentNDJSON
+	// ContentCSV is comma-separated values: more than one record, all with
+	// the same number of fields, at least two.
+	ContentCSV
+	// ContentBinary is output with NUL bytes or invalid UTF-8.
+	ContentBinary
+)
+
+// String returns the content type name.
+func (c ContentType) String() string {
+	switch c {
+	case ContentEmpty:
+		return "empty"
+	case ContentText:
+		return "text"
+	case ContentJSON:
+		return "json"
+	case ContentNDJSON:
+		return "ndjson"
+	case ContentCSV:
+		return "csv"
+	case ContentBinary:
+		return "binary"
+	default:
+		return fmt.Sprintf("ContentType(%d)", int(c))
+	}
+}
+
+// MIMEType returns the media type to serve content of type c with.
+// ContentEmpty and ContentText are both plain text.
+func (c ContentType) MIMEType() string {
+	switch c {
+	case ContentJSON:
+		return "application/json"
+	case ContentNDJSON:
+		return "application/x-ndjson"
+	case ContentCSV:
+		return "text/csv; charset=utf-8"
+	case ContentBinary:
+		return "application/octet-stream"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// DetectContentType classifies data, checking the formats from the most
+// specific: JSON, then NDJSON, then CSV, then text. Leading and trailing
+// whitespace is ignored, as is a rune cut off at the end of data, as
+// happens when output is truncated. Truncated JSON and NDJSON usually fail
+// to parse and are classified as text.
+func DetectContentType(data []byte) ContentType {
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) == 0:
+		return ContentEmpty
+	case isBinary(data):
+		return ContentBinary
+	case isJSONDocument(data):
+		return ContentJSON
+	case isNDJSON(data):
+		return ContentNDJSON
+	case isCSV(data):
+		return ContentCSV
+	default:
+		return ContentText
+	}
+}
+
+// DetectContentType classifies the result's stdout; see the function of
+// the same name.
+func (r *Result) DetectContentType() ContentType {
+	return DetectContentType(r.Stdout)
+}
+
+// isBinary reports whether data has a NUL byte or invalid UTF-8 other than
+// a rune cut off at its end.
+func isBinary(data []byte) bool {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return true
+	}
+	// A cut rune is at most utf8.UTFMax-1 bytes long.
+	for i := 0; i < utf8.UTFMax-1 && i < len(data); i++ {
+		start := len(data) - 1 - i
+		if utf8.RuneStart(data[start]) {
+			if !utf8.FullRune(data[start:]) {
+				data = data[:start]
+			}
+			break
+		}
+	}
+	return !utf8.Valid(data)
+}
+
+// isJSONDocument reports whether data is a JSON object or array. Bare
+// strings and numbers read as text.
+func isJSONDocument(data []byte) bool {
+	return (data[0] == '{' || data[0] == '[') && json.Valid(data)
+}
+
+// isNDJSON reports whether data has more than one line, ignoring blank
+// ones, and every line is a JSON object or array.
+func isNDJSON(data []byte) bool {
+	lines := 0
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if !isJSONDocument(line) {
+			return false
+		}
+		lines++
+	}
+	return lines > 1
+}
+
+// isCSV reports whether data parses as more than one comma-separated
+// record, each with the same number of fields, at least two.
+func isCSV(data []byte) bool {
+	r := csv.NewReader(bytes.NewReader(data))
+	records := 0
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return records > 1
+		}
+		if err != nil || len(record) < 2 {
+			return false
+		}
+		records++
+	}
+}
@@ -0,0 +1,57 @@
+package conch
+
+import "testing"
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want ContentType
+	}{
+		{"empty", "", ContentEmpty},
+		{"whitespace", " \n\t\n", ContentEmpty},
+		{"text", "hello world\n", ContentText},
+		{"number", "42\n", ContentText},
+		{"object", `{"a": 1}` + "\n", ContentJSON},
+		{"pretty array", "[\n  1,\n  2\n]\n", ContentJSON},
+		{"truncated json", `{"a": [1, 2`, ContentText},
+		{"ndjson", "{\"a\":1}\n{\"a\":2}\n\n[3]\n", ContentNDJSON},
+		{"one ndjson line", "{\"a\":1}\n", ContentJSON},
+		{"mixed lines", "{\"a\":1}\nplain\n", ContentText},
+		{"csv", "id,name\n1,\"Smith, J\"\n2,Doe\n", ContentCSV},
+		{"ragged csv", "id,name\n1\n", ContentText},
+		{"one column", "id\n1\n2\n", ContentText},
+		{"one record", "a,b\n", ContentText},
+		{"nul", "abc\x00def", ContentBinary},
+		{"invalid utf-8", "abc\xffdef", ContentBinary},
+		{"cut rune", "caf\xc3", ContentText},
+		{"cut rune in the middle", "caf\xc3 ok", ContentBinary},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectContentType([]byte(tt.data)); got != tt.want {
+				t.Errorf("DetectContentType(%q) = %v, want %v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResultDetectContentType(t *testing.T) {
+	r := &Result{Stdout: []byte("[1,2]"), Stderr: []byte("warning\n")}
+	if got := r.DetectContentType(); got != ContentJSON {
+		t.Errorf("DetectContentType() = %v, want json", got)
+	}
+}
+
+func TestContentTypeStrings(t *testing.T) {
+	for c, want := range map[ContentType]string{ContentEmpty: "empty", ContentNDJSON: "ndjson", ContentBinary: "binary", 9: "ContentType(9)"} {
+		if got := c.String(); got != want {
+			t.Errorf("String() = %q, want %q", got, want)
+		}
+	}
+	for c, want := range map[ContentType]string{ContentJSON: "application/json", ContentCSV: "text/csv; charset=utf-8", ContentEmpty: "text/plain; charset=utf-8"} {
+		if got := c.MIMEType(); got != want {
+			t.Errorf("%v.MIMEType() = %q, want %q", c, got, want)
+		}
+	}
+}
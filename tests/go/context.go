@@ -0,0 +1,286 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// EpochTickInterval is how often a cancelled execution is re-ticked while it
+// winds down.
+//
+// Cancellation sets a native interrupt flag and advances the executor's epoch.
+// Guest code observes the flag at its next epoch check (function entry or
+// loop back-edge), which for shell scripts is effectively immediate. Host
+// calls made by the guest, such as waiting on a spawned coreutils component,
+// are not preempted; the ticker keeps advancing the epoch every
+// EpochTickInterval so the interrupt lands within one interval of the guest
+// re-entering wasm code.
+var EpochTickInterval = 10 * time.Millisecond
+
+// ExecuteContext runs a shell script with default resource limits, stopping
+// it when ctx is cancelled or its deadline passes.
+func (e *Executor) ExecuteContext(ctx context.Context, script string) (*Result, error) {
+	return e.ExecuteContextWithLimits(ctx, script, e.Limits())
+}
+
+// ExecuteContextWithLimits runs a shell script with custom resource limits,
+// stopping it when ctx is cancelled or its deadline passes.
+//
+// If ctx has a deadline earlier than limits.TimeoutMs, the deadline wins. When
+// the execution is stopped because of ctx, the returned error wraps ctx.Err().
+// If the script set traps, they run before teardown and the error is a
+// *TrapError carrying their output.
+func (e *Executor) ExecuteContextWithLimits(ctx context.Context, script string, limits ResourceLimits) (*Result, error) {
+	return e.run(ctx, script, ExecOptions{}, limits)
+}
+
+// run executes script through the interruptible entry points with opts,
+// whose Limits the caller has already resolved into limits.
+func (e *Executor) run(ctx context.Context, script string, opts ExecOptions, limits ResourceLimits) (result *Result, err error) {
+	id, stdin, tty, onOutput := opts.ID, opts.Stdin, opts.TTY, opts.OnOutput
+	if id == "" {
+		id = newExecutionID()
+	}
+	// Modes kept in Go see every byte through the output callback, while
+	// the native side keeps none.
+	collector := newOutputCollector(opts, limits.MaxOutputBytes)
+	if collector != nil {
+		onOutput = collector.tap(onOutput)
+		limits.MaxOutputBytes = 0
+	}
+	audit := e.startAudit(id, script, opts.sources)
+	defer func() { audit.finish(result, err) }()
+
+	if opts.Vars, err = withJqOptions(opts.Vars, opts.Jq); err != nil {
+		return nil, err
+	}
+
+	if err := e.checkReentrant(ctx); err != nil {
+		return nil, err
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.handle == 0 {
+		return nil, errors.New("executor is closed")
+	}
+	l := e.lib
+	err = l.interrupt.load()
+	switch {
+	case err != nil:
+	case tty != nil:
+		err = l.terminal.load()
+	case stdin != nil:
+		err = l.stdin.load()
+	}
+	if err == nil && onOutput != nil {
+		err = l.output.load()
+	}
+	if err == nil && opts.Capture != CaptureHead && collector == nil {
+		err = l.capture.load()
+	}
+	if err == nil && opts.CompressOver > 0 {
+		err = l.compression.load()
+	}
+	if err == nil && len(opts.CaptureVars) > 0 {
+		err = l.vars.load()
+	}
+	if err == nil && len(opts.Vars) > 0 {
+		err = l.assign.load()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("execution interrupted: %w", err)
+	}
+	if err := checkScriptSize(script, limits); err != nil {
+		return nil, err
+	}
+	if err := validateUTF8(script); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	if err := e.checkAllowed(script); err != nil {
+		return nil, err
+	}
+	if err := e.checkPolicy(ctx, script); err != nil {
+		return nil, err
+	}
+	script = withSources(opts.sources, script)
+
+	cScript, err := cString(script)
+	if err != nil {
+		return nil, err
+	}
+	defer freeString(cScript)
+	prepare := time.Since(start)
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	scriptPtr := pinBytes(&pinner, cScript.b)
+
+	interrupt := l.interruptNew()
+	defer l.interruptFree(interrupt)
+
+	if err := setExecutionID(l, interrupt, id); err != nil {
+		return nil, err
+	}
+	defer trackExecution(ctx, interrupt, id)()
+	outputPanic := func() *CallbackPanicError { return nil }
+	if onOutput != nil {
+		var guarded OutputFunc
+		guarded, outputPanic = guardOutput(l, interrupt, onOutput)
+		release, err := setOutput(l, interrupt, guarded)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+	if opts.Capture != CaptureHead && collector == nil {
+		if err := setCapture(l, interrupt, opts.Capture); err != nil {
+			return nil, err
+		}
+	}
+	if opts.CompressOver > 0 {
+		if err := setCompression(l, interrupt, opts.CompressOver); err != nil {
+			return nil, err
+		}
+	}
+	if err := captureVars(l, interrupt, opts.CaptureVars); err != nil {
+		return nil, err
+	}
+	if err := setVars(l, interrupt, opts.Vars); err != nil {
+		return nil, err
+	}
+
+	ctx, stopWatchdog := e.startWatchdog(ctx, l, interrupt, id)
+	defer stopWatchdog()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go watchContext(ctx, l, e.handle, interrupt, done, stopped)
+
+	var terminal uintptr
+	if tty != nil {
+		terminal = tty.attach(l)
+		defer tty.detach(terminal)
+	}
+
+	ebuf := newErrorBuffer()
+	native := nativeLimits(limits)
+	native.builtinTimeoutMs = builtinTimeoutMs(opts.BuiltinTimeout)
+	native.setRegexLimits(opts.RegexLimits)
+
+	var resultPtr uintptr
+	e.onThread(func() {
+		switch {
+		case tty != nil:
+			resultPtr = l.executeWithTerminalCompat(
+				e.handle,
+				scriptPtr,
+				pinBytes(&pinner, stdin),
+				uintptr(len(stdin)),
+				terminal,
+				native,
+				interrupt,
+				ebuf.ptr(),
+				errorBufferSize,
+			)
+		case stdin == nil:
+			resultPtr = l.executeInterruptibleCompat(
+				e.handle,
+				scriptPtr,
+				native,
+				interrupt,
+				ebuf.ptr(),
+				errorBufferSize,
+			)
+		default:
+			resultPtr = l.executeWithStdinCompat(
+				e.handle,
+				scriptPtr,
+				pinBytes(&pinner, stdin),
+				uintptr(len(stdin)),
+				native,
+				interrupt,
+				ebuf.ptr(),
+				errorBufferSize,
+			)
+		}
+	})
+	close(done)
+	<-stopped
+
+	if perr := outputPanic(); perr != nil {
+		if resultPtr != 0 {
+			l.freeResult(resultPtr)
+		}
+		return nil, perr
+	}
+	if resultPtr == 0 {
+		// The output of trap handlers goes in a Result of its own rather
+		// than opts.into.
+		return nil, stoppedError(ctx, l, interrupt, id, native.failedError(l, ebuf.String()))
+	}
+
+	if opts.into != nil {
+		err = takeResultInto(l, resultPtr, opts.into, opts.KeepCompressed)
+		result = opts.into
+	} else {
+		result, err = takeResult(l, resultPtr, opts.KeepCompressed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.ID = id
+	result.Timings.Prepare = prepare
+	if collector != nil {
+		collector.fill(result)
+	}
+	if len(opts.CaptureVars) > 0 {
+		result.Vars = readVars(l, interrupt, opts.CaptureVars)
+	}
+	return result, nil
+}
+
+// watchContext triggers interrupt once ctx is done and keeps ticking the
+// epoch of the executor behind handle, both from l, until the execution
+// returns (done is closed).
+func watchContext(ctx context.Context, l *library, handle, interrupt uintptr, done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	if ctx.Done() == nil {
+		<-done
+		return
+	}
+
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	// A passed deadline is a timeout, running the script's TERM trap rather
+	// than INT.
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		l.interruptExpire(interrupt)
+	} else {
+		l.interruptTrigger(interrupt)
+	}
+	l.executorTick(handle)
+
+	ticker := time.NewTicker(EpochTickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			l.executorTick(handle)
+		}
+	}
+}
@@ -0,0 +1,516 @@
+package conch
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultMaxFrameBytes is the frame size limit of the daemon protocol when
+// DaemonServer.MaxFrameBytes or DaemonClient.MaxFrameBytes is unset.
+const defaultMaxFrameBytes = 64 << 20
+
+// shutdownPollInterval is how often DaemonServer.Shutdown checks whether
+// the executions in flight are done.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// ErrDaemonClosed is returned by DaemonServer.Serve once the server is
+// closed or shut down.
+var ErrDaemonClosed = errors.New("daemon closed")
+
+// The daemon protocol runs over a stream connection, usually a unix
+// socket. Each message is a frame: a 4-byte big-endian length, then that
+// many bytes of JSON. The client sends a daemonRequest and reads back a
+// daemonResponse, one execution at a time; a connection carries any number
+// of executions in turn. Closing the connection stops the execution in
+// flight.
+
+// daemonRequest is an execution asked of a DaemonServer.
+type daemonRequest struct {
+	Script   string            `json:"script"`
+	Stdin    []byte            `json:"stdin,omitempty"`
+	ID       string            `json:"id,omitempty"`
+	Vars     map[string]string `json:"vars,omitempty"`
+	Priority Priority          `json:"priority,omitempty"`
+	QuotaKey string            `json:"quota_key,omitempty"`
+}
+
+// daemonResponse is the outcome of a daemonRequest. Result is nil if the
+// execution failed without one. Kinds names the daemonErrorKinds the
+// error matches, so the client can rebuild them.
+type daemonResponse struct {
+	Result *daemonResult `json:"result,omitempty"`
+	Error  string        `json:"error,omitempty"`
+	Kinds  []string      `json:"kinds,omitempty"`
+}
+
+// daemonErrorKinds are the errors a DaemonClient reports so that
+// errors.Is matches them, by their name in the protocol.
+var daemonErrorKinds = map[string]error{
+	"bad_signature":       ErrBadSignature,
+	"canceled":            context.Canceled,
+	"deadline_exceeded":   context.DeadlineExceeded,
+	"depth_exceeded":      ErrDepthExceeded,
+	"execution_stuck":     ErrExecutionStuck,
+	"init_script":         ErrInitScript,
+	"invalid_utf8":        ErrInvalidUTF8,
+	"line_too_long":       ErrLineTooLong,
+	"no_backend":          ErrNoBackend,
+	"pattern_too_complex": ErrPatternTooComplex,
+	"policy_denied":       ErrPolicyDenied,
+	"pool_closed":         ErrPoolClosed,
+	"queue_closed":        ErrQueueClosed,
+	"quota_exceeded":      ErrQuotaExceeded,
+	"script_not_allowed":  ErrScriptNotAllowed,
+	"script_too_large":    ErrScriptTooLarge,
+	"timeout":             ErrTimeout,
+	"version_mismatch":    ErrVersionMismatch,
+}
+
+// daemonError is an error sent back by a DaemonServer: its message, and
+// the daemonErrorKinds it matched on the server.
+type daemonError struct {
+	msg   string
+	kinds []error
+}
+
+func (e *daemonError) Error() string { return e.msg }
+
+func (e *daemonError) Unwrap() []error { return e.kinds }
+
+// daemonResult is the part of a Result sent back by a DaemonServer.
+type daemonResult struct {
+	ID             string       `json:"id,omitempty"`
+	ExitCode       int          `json:"exit_code"`
+	Stdout         []byte       `json:"stdout,omitempty"`
+	Stderr         []byte       `json:"stderr,omitempty"`
+	Truncated      bool         `json:"truncated,omitempty"`
+	FSExceeded     bool         `json:"fs_exceeded,omitempty"`
+	StdoutTotalLen int          `json:"stdout_total_len,omitempty"`
+	StderrTotalLen int          `json:"stderr_total_len,omitempty"`
+	Diagnostics    []Diagnostic `json:"diagnostics,omitempty"`
+}
+
+// writeFrame writes v to w as one frame of the daemon protocol.
+func writeFrame(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(data)), uint32(len(data)))
+	_, err = w.Write(append(frame, data...))
+	return err
+}
+
+// readFrame reads one frame of the daemon protocol from r into v, failing
+// if it is longer than limit bytes.
+func readFrame(r io.Reader, limit int, v any) error {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if uint64(n) > uint64(limit) {
+		return fmt.Errorf("frame of %d bytes exceeds the limit of %d", n, limit)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// DaemonServer serves executions from a Pool over the daemon protocol, so
+// short-lived processes on a host can share one warm pool through a
+// DaemonClient instead of each creating an executor. cmd/conchd runs one on
+// a unix socket.
+//
+// Clients are trusted as far as the socket's permissions let them in:
+// they choose the script, its stdin, variables, priority and quota key.
+// Resource limits are the pool's executors' own.
+type DaemonServer struct {
+	// Pool runs the executions.
+	Pool *Pool
+	// MaxFrameBytes limits the size of a request. 0 means 64 MiB.
+	MaxFrameBytes int
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]bool // true while running an execution
+	closed    bool
+}
+
+// Serve accepts connections on ln and serves each in its own goroutine
+// until ln fails or the server is closed, when it returns ErrDaemonClosed.
+func (s *DaemonServer) Serve(ln net.Listener) error {
+	if !s.track(ln, nil) {
+		ln.Close()
+		return ErrDaemonClosed
+	}
+	defer s.untrack(ln, nil)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrDaemonClosed
+			}
+			return err
+		}
+		if !s.track(nil, conn) {
+			conn.Close()
+			return ErrDaemonClosed
+		}
+		go func() {
+			defer s.untrack(nil, conn)
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// Shutdown stops the listeners and closes idle connections, then waits
+// for the executions in flight to finish, closing each connection once
+// its response is sent. If ctx is done first, Shutdown closes the server
+// as Close does and returns ctx's error.
+func (s *DaemonServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for conn, active := range s.conns {
+		if !active {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		n := len(s.conns)
+		s.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops the listeners and closes every connection, stopping the
+// executions in flight.
+func (s *DaemonServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// track records ln or conn as open, unless the server is closed.
+func (s *DaemonServer) track(ln net.Listener, conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if ln != nil {
+		if s.listeners == nil {
+			s.listeners = map[net.Listener]struct{}{}
+		}
+		s.listeners[ln] = struct{}{}
+	}
+	if conn != nil {
+		if s.conns == nil {
+			s.conns = map[net.Conn]bool{}
+		}
+		s.conns[conn] = false
+	}
+	return true
+}
+
+// untrack forgets ln or conn, closing it.
+func (s *DaemonServer) untrack(ln net.Listener, conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ln != nil {
+		delete(s.listeners, ln)
+		ln.Close()
+	}
+	if conn != nil {
+		delete(s.conns, conn)
+		conn.Close()
+	}
+}
+
+// setActive marks conn as running an execution or idle, failing once the
+// server is closed or shutting down, when conn should stop.
+func (s *DaemonServer) setActive(conn net.Conn, active bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = active
+	return true
+}
+
+// isClosed reports whether Close or Shutdown was called.
+func (s *DaemonServer) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// serveConn runs the executions requested on conn, in turn, until it is
+// closed. A request is read ahead while the previous one runs, so a
+// client going away is noticed and stops its execution.
+func (s *DaemonServer) serveConn(conn net.Conn) {
+	limit := s.MaxFrameBytes
+	if limit <= 0 {
+		limit = defaultMaxFrameBytes
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	requests := make(chan daemonRequest)
+	go func() {
+		defer cancel()
+		defer close(requests)
+		r := bufio.NewReader(conn)
+		for {
+			var req daemonRequest
+			if err := readFrame(r, limit, &req); err != nil {
+				return
+			}
+			select {
+			case requests <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for req := range requests {
+		if !s.setActive(conn, true) {
+			return
+		}
+		result, err := s.Pool.ExecuteWithOptions(ctx, req.Script, ExecOptions{
+			Stdin:    req.Stdin,
+			ID:       req.ID,
+			Vars:     req.Vars,
+			Priority: req.Priority,
+			QuotaKey: req.QuotaKey,
+		})
+		if err := writeFrame(conn, newDaemonResponse(result, err)); err != nil || !s.setActive(conn, false) {
+			return
+		}
+	}
+}
+
+// newDaemonResponse encodes the outcome of an execution.
+func newDaemonResponse(result *Result, err error) daemonResponse {
+	var resp daemonResponse
+	if result != nil {
+		resp.Result = &daemonResult{
+			ID:             result.ID,
+			ExitCode:       result.ExitCode,
+			Stdout:         result.Stdout,
+			Stderr:         result.Stderr,
+			Truncated:      result.Truncated,
+			FSExceeded:     result.FSExceeded,
+			StdoutTotalLen: result.StdoutTotalLen,
+			StderrTotalLen: result.StderrTotalLen,
+			Diagnostics:    result.diagnostics,
+		}
+	}
+	if err != nil {
+		resp.Error = err.Error()
+		for kind, target := range daemonErrorKinds {
+			if errors.Is(err, target) {
+				resp.Kinds = append(resp.Kinds, kind)
+			}
+		}
+		sort.Strings(resp.Kinds)
+	}
+	return resp
+}
+
+// DaemonClient runs scripts on a DaemonServer. It is a ShellExecutor, so
+// it can stand in for a local executor.
+//
+// A DaemonClient is safe for concurrent use, but runs one execution at a
+// time over its connection; open several clients for concurrency. A
+// connection broken by a cancelled context is replaced on the next call.
+type DaemonClient struct {
+	// MaxFrameBytes limits the size of a response, which carries the
+	// output of the execution. 0 means 64 MiB; raise it for daemons whose
+	// executors allow more output. Set it before the first execution.
+	MaxFrameBytes int
+
+	network, addr string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	r      *bufio.Reader
+	closed bool
+}
+
+// DialDaemon connects to the DaemonServer listening on the unix socket at
+// path.
+func DialDaemon(path string) (*DaemonClient, error) {
+	c := &DaemonClient{network: "unix", addr: path}
+	if err := c.dial(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// dial connects c. c.mu must be held, or c not yet shared.
+func (c *DaemonClient) dial() error {
+	conn, err := net.Dial(c.network, c.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to conch daemon: %w", err)
+	}
+	c.conn, c.r = conn, bufio.NewReader(conn)
+	return nil
+}
+
+// Execute implements ShellExecutor with ExecuteWithOptions.
+func (c *DaemonClient) Execute(script string) (*Result, error) {
+	return c.ExecuteWithOptions(context.Background(), script, ExecOptions{})
+}
+
+// ExecuteContext implements ShellExecutor with ExecuteWithOptions.
+func (c *DaemonClient) ExecuteContext(ctx context.Context, script string) (*Result, error) {
+	return c.ExecuteWithOptions(ctx, script, ExecOptions{})
+}
+
+// ExecuteWithStdin implements ShellExecutor with ExecuteWithOptions.
+func (c *DaemonClient) ExecuteWithStdin(script string, stdin []byte) (*Result, error) {
+	return c.ExecuteWithOptions(context.Background(), script, ExecOptions{Stdin: stdin})
+}
+
+// ExecuteWithOptions runs script on the daemon, stopping it when ctx is
+// done. Only opts.Stdin, ID, Vars, Priority and QuotaKey are sent; the
+// daemon's executors apply their own limits. Errors of the execution come
+// back with their message; errors.Is matches the package's sentinel errors
+// and the context errors they wrapped on the daemon, but errors.As matches
+// nothing.
+func (c *DaemonClient) ExecuteWithOptions(ctx context.Context, script string, opts ExecOptions) (*Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, errors.New("daemon client is closed")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("execution interrupted: %w", err)
+	}
+	if c.conn == nil {
+		if err := c.dial(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Closing the connection is how the daemon learns to stop.
+	conn := c.conn
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	resp, err := c.roundTrip(daemonRequest{
+		Script:   script,
+		Stdin:    opts.Stdin,
+		ID:       opts.ID,
+		Vars:     opts.Vars,
+		Priority: opts.Priority,
+		QuotaKey: opts.QuotaKey,
+	})
+	if !stop() || err != nil {
+		conn.Close()
+		c.conn = nil
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("execution interrupted: %w", ctxErr)
+		}
+		if err == nil {
+			err = errors.New("connection closed")
+		}
+		return nil, fmt.Errorf("conch daemon: %w", err)
+	}
+
+	var result *Result
+	if r := resp.Result; r != nil {
+		result = &Result{
+			ID:             r.ID,
+			ExitCode:       r.ExitCode,
+			Stdout:         r.Stdout,
+			Stderr:         r.Stderr,
+			Truncated:      r.Truncated,
+			FSExceeded:     r.FSExceeded,
+			StdoutTotalLen: r.StdoutTotalLen,
+			StderrTotalLen: r.StderrTotalLen,
+			diagnostics:    r.Diagnostics,
+		}
+		result.Error = diagnose(result.ExitCode, result.diagnostics)
+	}
+	if resp.Error != "" {
+		return result, newDaemonError(resp)
+	}
+	return result, nil
+}
+
+// newDaemonError rebuilds the error of resp. Kinds this client does not
+// know are ignored.
+func newDaemonError(resp daemonResponse) error {
+	e := &daemonError{msg: resp.Error}
+	for _, kind := range resp.Kinds {
+		if target, ok := daemonErrorKinds[kind]; ok {
+			e.kinds = append(e.kinds, target)
+		}
+	}
+	return e
+}
+
+// roundTrip sends req and reads its response.
+func (c *DaemonClient) roundTrip(req daemonRequest) (daemonResponse, error) {
+	var resp daemonResponse
+	if err := writeFrame(c.conn, req); err != nil {
+		return resp, err
+	}
+	limit := c.MaxFrameBytes
+	if limit <= 0 {
+		limit = defaultMaxFrameBytes
+	}
+	err := readFrame(c.r, limit, &resp)
+	return resp, err
+}
+
+// Close closes the connection to the daemon.
+func (c *DaemonClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+var _ ShellExecutor = (*DaemonClient)(nil)
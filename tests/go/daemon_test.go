@@ -0,0 +1,282 @@
+package conch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// daemonTestExecutor echoes its stdin, or else its script, blocks on a
+// script of "block" until its context is done, takes a while over a
+// script of "slow" and fails a script of "quota" with ErrQuotaExceeded.
+type daemonTestExecutor struct {
+	stopped *atomic.Int32
+}
+
+func (d daemonTestExecutor) Execute(script string) (*Result, error) {
+	return d.ExecuteWithOptions(context.Background(), script, ExecOptions{})
+}
+
+func (d daemonTestExecutor) ExecuteContext(ctx context.Context, script string) (*Result, error) {
+	return d.ExecuteWithOptions(ctx, script, ExecOptions{})
+}
+
+func (d daemonTestExecutor) ExecuteWithStdin(script string, stdin []byte) (*Result, error) {
+	return d.ExecuteWithOptions(context.Background(), script, ExecOptions{Stdin: stdin})
+}
+
+func (d daemonTestExecutor) ExecuteWithOptions(ctx context.Context, script string, opts ExecOptions) (*Result, error) {
+	switch script {
+	case "block":
+		<-ctx.Done()
+		d.stopped.Add(1)
+		return nil, ctx.Err()
+	case "slow":
+		time.Sleep(50 * time.Millisecond)
+	case "quota":
+		return nil, fmt.Errorf("tenant a: %w", ErrQuotaExceeded)
+	case "fail":
+		diags := []Diagnostic{{Command: "fail", Category: CategoryNoSuchFile, Message: "no such file or directory"}}
+		return &Result{ID: opts.ID, ExitCode: 1, Stderr: []byte("fail: no such file or directory\n"), diagnostics: diags}, errors.New("boom")
+	}
+	out := opts.Stdin
+	if out == nil {
+		out = []byte(script + " " + opts.Vars["NAME"])
+	}
+	return &Result{ID: opts.ID, Stdout: out, StdoutTotalLen: len(out)}, nil
+}
+
+func (d daemonTestExecutor) Close() error { return nil }
+
+// startTestDaemon serves a pool of daemonTestExecutors on a unix socket,
+// returning its path and the count of executions stopped.
+func startTestDaemon(t *testing.T) (*DaemonServer, string, *atomic.Int32) {
+	t.Helper()
+	stopped := &atomic.Int32{}
+	pool := NewPool(func() (ShellExecutor, error) { return daemonTestExecutor{stopped: stopped}, nil }, PoolOptions{})
+	t.Cleanup(func() { pool.Close() })
+
+	path := filepath.Join(t.TempDir(), "conchd.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("Skipping: cannot listen on a unix socket: %v", err)
+	}
+	s := &DaemonServer{Pool: pool}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ln) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; !errors.Is(err, ErrDaemonClosed) {
+			t.Errorf("Serve() = %v, want ErrDaemonClosed", err)
+		}
+	})
+	return s, path, stopped
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeFrame(&buf, daemonRequest{Script: "echo hi", Stdin: []byte{0xff}}); err != nil {
+		t.Fatal(err)
+	}
+	var req daemonRequest
+	if err := readFrame(bytes.NewReader(buf.Bytes()), 1024, &req); err != nil || req.Script != "echo hi" || !bytes.Equal(req.Stdin, []byte{0xff}) {
+		t.Errorf("readFrame() = %+v, %v", req, err)
+	}
+	if err := readFrame(bytes.NewReader(buf.Bytes()), 8, &req); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("readFrame() over the limit error = %v", err)
+	}
+}
+
+func TestDaemonClientExecute(t *testing.T) {
+	_, path, _ := startTestDaemon(t)
+	c, err := DialDaemon(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	result, err := c.ExecuteWithOptions(context.Background(), "greet", ExecOptions{ID: "req-1", Vars: map[string]string{"NAME": "bob"}})
+	if err != nil || result.ID != "req-1" || string(result.Stdout) != "greet bob" {
+		t.Fatalf("ExecuteWithOptions() = %+v, %v", result, err)
+	}
+	if result, err := c.ExecuteWithStdin("cat", []byte("in\x00put")); err != nil || string(result.Stdout) != "in\x00put" {
+		t.Errorf("ExecuteWithStdin() = %+v, %v", result, err)
+	}
+	result, err = c.Execute("fail")
+	if err == nil || err.Error() != "boom" || result == nil || result.ExitCode != 1 || result.Error == nil || result.Error.Category != CategoryNoSuchFile {
+		t.Errorf("Execute(fail) = %+v, %v", result, err)
+	}
+	if errors.Is(err, ErrQuotaExceeded) {
+		t.Error("Execute(fail) error matches ErrQuotaExceeded")
+	}
+	_, err = c.Execute("quota")
+	if !errors.Is(err, ErrQuotaExceeded) || errors.Is(err, ErrTimeout) || err.Error() != "tenant a: quota exceeded" {
+		t.Errorf("Execute(quota) error = %v", err)
+	}
+}
+
+func TestDaemonClientMaxFrameBytes(t *testing.T) {
+	_, path, _ := startTestDaemon(t)
+	c, err := DialDaemon(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.MaxFrameBytes = 16
+	if _, err := c.ExecuteWithStdin("cat", bytes.Repeat([]byte("x"), 64)); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("ExecuteWithStdin() over the limit error = %v", err)
+	}
+	c.MaxFrameBytes = 1024
+	if result, err := c.ExecuteWithStdin("cat", []byte("in")); err != nil || string(result.Stdout) != "in" {
+		t.Errorf("ExecuteWithStdin() after raising the limit = %+v, %v", result, err)
+	}
+}
+
+func TestDaemonClientCancel(t *testing.T) {
+	_, path, stopped := startTestDaemon(t)
+	c, err := DialDaemon(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.ExecuteContext(ctx, "block"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ExecuteContext() error = %v, want DeadlineExceeded", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for stopped.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if stopped.Load() != 1 {
+		t.Error("the daemon did not stop the execution of a client that went away")
+	}
+
+	// The broken connection is replaced.
+	if result, err := c.Execute("again"); err != nil || string(result.Stdout) != "again " {
+		t.Errorf("Execute() after cancel = %+v, %v", result, err)
+	}
+}
+
+func TestDaemonServerClose(t *testing.T) {
+	s, path, _ := startTestDaemon(t)
+	c, err := DialDaemon(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Execute("block")
+		errc <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.Close()
+	if err := <-errc; err == nil {
+		t.Error("Execute() succeeded on a closed daemon")
+	}
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "late.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Serve(ln); !errors.Is(err, ErrDaemonClosed) {
+		t.Errorf("Serve() after Close = %v", err)
+	}
+	if _, err := ln.Accept(); err == nil {
+		t.Error("Serve() after Close left its listener open")
+	}
+}
+
+func TestDaemonServerShutdown(t *testing.T) {
+	s, path, _ := startTestDaemon(t)
+	busy, err := DialDaemon(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	idle, err := DialDaemon(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	type outcome struct {
+		result *Result
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := busy.Execute("slow")
+		done <- outcome{result, err}
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	if o := <-done; o.err != nil || string(o.result.Stdout) != "slow " {
+		t.Errorf("Execute() in flight during Shutdown = %+v, %v", o.result, o.err)
+	}
+	if _, err := idle.Execute("true"); err == nil {
+		t.Error("Execute() succeeded after Shutdown")
+	}
+}
+
+func TestDaemonServerShutdownTimeout(t *testing.T) {
+	s, path, stopped := startTestDaemon(t)
+	c, err := DialDaemon(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Execute("block")
+		errc <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() = %v, want DeadlineExceeded", err)
+	}
+	if err := <-errc; err == nil {
+		t.Error("Execute() succeeded after Shutdown gave up")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for stopped.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if stopped.Load() != 1 {
+		t.Error("Shutdown did not stop the execution once ctx was done")
+	}
+}
+
+func TestDaemonClientClosed(t *testing.T) {
+	_, path, _ := startTestDaemon(t)
+	c, err := DialDaemon(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if _, err := c.Execute("true"); err == nil {
+		t.Error("Execute() succeeded on a closed client")
+	}
+	if _, err := DialDaemon(filepath.Join(t.TempDir(), "missing.sock")); err == nil {
+		t.Error("DialDaemon() succeeded without a daemon")
+	}
+}
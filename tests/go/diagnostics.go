@@ -0,0 +1,188 @@
+package conch
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// Diagnostic categories reported in Diagnostic.Category.
+const (
+	CategorySyntax            = "syntax"
+	CategoryCommandNotFound   = "command-not-found"
+	CategoryNoSuchFile        = "no-such-file"
+	CategoryPermission        = "permission-denied"
+	CategoryUnboundVariable   = "unbound-variable"
+	CategoryBadSubstitution   = "bad-substitution"
+	CategoryCommandFailed     = "command-failed"
+	CategoryPatternTooComplex = "pattern-too-complex"
+)
+
+// Diagnostic describes an error the shell or one of its builtins ran into.
+//
+// Diagnostics in a Result are reported by the library as the errors are
+// written to stderr, so a script cannot fake them by writing text that
+// looks like one. ParseDiagnostics recovers them from stderr instead, on a
+// best-effort basis.
+type Diagnostic struct {
+	// Line and Column locate the error in the script, counting from 1, or
+	// are 0 when it is not known. The library reports the line of every
+	// error it can place, but a column only for syntax errors;
+	// ParseDiagnostics takes both from messages that say.
+	Line   int
+	Column int
+	// Command is the builtin that reported the error or the command that
+	// was not found, if any.
+	Command string
+	// Category classifies the error as one of the Category constants.
+	Category string
+	// Message is the error text without the shell and command prefixes.
+	Message string
+
+	// reported is set for diagnostics the library reported, rather than
+	// ones ParseDiagnostics read from text a script could have written.
+	reported bool
+}
+
+var (
+	diagShellPrefix = regexp.MustCompile(`^(?:brush|conch|bash|sh): `)
+	diagLinePrefix  = regexp.MustCompile(`^line (\d+): `)
+	diagLine        = regexp.MustCompile(`\bline (\d+)`)
+	diagColumn      = regexp.MustCompile(`\bcol(?:umn)? (\d+)`)
+)
+
+// diagCategories maps message fragments to categories, most specific first.
+var diagCategories = []struct {
+	fragment string
+	category string
+}{
+	{"syntax error", CategorySyntax},
+	{"command not found", CategoryCommandNotFound},
+	{"unbound variable", CategoryUnboundVariable},
+	{"bad substitution", CategoryBadSubstitution},
+	{"No such file or directory", CategoryNoSuchFile},
+	{"Permission denied", CategoryPermission},
+}
+
+// ParseDiagnostics returns a Diagnostic for each line of stderr that looks
+// like an error of the shell or a conch builtin, in order. Builtins write
+// errors as "conch: <builtin>: <message>", such as "conch: jq: parse
+// error: ...", giving the builtin's name as Command. Other lines, such as
+// those of commands with no fixed error format, are skipped.
+//
+// This is a best-effort helper for stderr from elsewhere, such as a saved
+// log: anything a script writes to stderr can look like an error. Prefer
+// Result.Diagnostics, which the library reports.
+func ParseDiagnostics(stderr []byte) []Diagnostic {
+	var diags []Diagnostic
+	for _, line := range bytes.Split(stderr, []byte{'\n'}) {
+		text := strings.TrimSpace(string(line))
+		if diagShellPrefix.MatchString(text) {
+			diags = append(diags, *newDiagnostic(diagShellPrefix.ReplaceAllString(text, "")))
+		}
+	}
+	return diags
+}
+
+// Diagnostics returns the errors the shell and its builtins reported while
+// running the script, in order.
+func (r *Result) Diagnostics() []Diagnostic {
+	return r.diagnostics
+}
+
+// Err returns the diagnostic as an error, or nil if d is nil. Errors of
+// category CategoryPatternTooComplex that the library reported, such as
+// Result.Error, match ErrPatternTooComplex with errors.Is.
+func (d *Diagnostic) Err() error {
+	if d == nil {
+		return nil
+	}
+	return &diagnosticError{d}
+}
+
+// diagnosticError is the error returned by Diagnostic.Err.
+type diagnosticError struct {
+	d *Diagnostic
+}
+
+func (e *diagnosticError) Error() string {
+	if e.d.Command == "" {
+		return e.d.Message
+	}
+	return e.d.Command + ": " + e.d.Message
+}
+
+func (e *diagnosticError) Is(target error) bool {
+	return target == ErrPatternTooComplex && e.d.reported && e.d.Category == CategoryPatternTooComplex
+}
+
+// newDiagnostic parses a single error line with any shell prefix removed,
+// such as "line 3: foo: command not found".
+func newDiagnostic(text string) *Diagnostic {
+	d := &Diagnostic{Category: CategoryCommandFailed}
+	if m := diagLinePrefix.FindStringSubmatch(text); m != nil {
+		d.Line, _ = strconv.Atoi(m[1])
+		text = text[len(m[0]):]
+	}
+	// "cmd: message", where cmd is a single word; syntax errors and other
+	// shell messages have spaces before their first colon.
+	if cmd, msg, ok := strings.Cut(text, ": "); ok && cmd != "" && !strings.ContainsAny(cmd, " \t") {
+		d.Command, text = cmd, msg
+	}
+	d.Message = text
+
+	if d.Line == 0 {
+		if m := diagLine.FindStringSubmatch(text); m != nil {
+			d.Line, _ = strconv.Atoi(m[1])
+		}
+	}
+	if m := diagColumn.FindStringSubmatch(text); m != nil {
+		d.Column, _ = strconv.Atoi(m[1])
+	}
+	for _, c := range diagCategories {
+		if strings.Contains(text, c.fragment) {
+			d.Category = c.category
+			break
+		}
+	}
+	return d
+}
+
+// diagnose returns the diagnostic for a result, the last one reported, or
+// nil if it succeeded or none was.
+func diagnose(exitCode int, diags []Diagnostic) *Diagnostic {
+	if exitCode == 0 || len(diags) == 0 {
+		return nil
+	}
+	return &diags[len(diags)-1]
+}
+
+// conchDiagnostic mirrors ConchDiagnostic.
+type conchDiagnostic struct {
+	category uintptr // *c_char
+	command  uintptr // *c_char
+	message  uintptr // *c_char
+	line     uint32
+	column   uint32
+}
+
+// appendDiagnostics appends the diagnostics of cResult to diags.
+func appendDiagnostics(diags []Diagnostic, cResult *ConchResult) []Diagnostic {
+	if cResult.Diagnostics == 0 {
+		return diags
+	}
+	native := unsafe.Slice((*conchDiagnostic)(unsafe.Pointer(cResult.Diagnostics)), cResult.DiagnosticsLen)
+	for _, d := range native {
+		diags = append(diags, Diagnostic{
+			Category: goString(d.category),
+			Command:  goString(d.command),
+			Message:  goString(d.message),
+			Line:     int(d.line),
+			Column:   int(d.column),
+			reported: true,
+		})
+	}
+	return diags
+}
@@ -0,0 +1,114 @@
+package conch
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewDiagnostic(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want *Diagnostic
+	}{
+		{
+			"command not found with line",
+			"line 3: frobnicate: command not found",
+			&Diagnostic{Line: 3, Command: "frobnicate", Category: CategoryCommandNotFound, Message: "command not found"},
+		},
+		{
+			"syntax error with position",
+			"syntax error near unexpected token `fi' (line 2 col 1)",
+			&Diagnostic{Line: 2, Column: 1, Category: CategorySyntax, Message: "syntax error near unexpected token `fi' (line 2 col 1)"},
+		},
+		{
+			"unbound variable",
+			"line 1: name: unbound variable",
+			&Diagnostic{Line: 1, Command: "name", Category: CategoryUnboundVariable, Message: "unbound variable"},
+		},
+		{
+			"free text",
+			"something went wrong",
+			&Diagnostic{Category: CategoryCommandFailed, Message: "something went wrong"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newDiagnostic(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newDiagnostic(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	diags := []Diagnostic{
+		{Command: "jq", Category: CategoryCommandFailed, Message: "parse error"},
+		{Command: "frobnicate", Category: CategoryCommandNotFound, Message: "command not found"},
+	}
+	if d := diagnose(0, diags); d != nil {
+		t.Errorf("diagnose(0) = %+v, want nil", d)
+	}
+	if d := diagnose(1, nil); d != nil {
+		t.Errorf("diagnose(1, nil) = %+v, want nil", d)
+	}
+	if d := diagnose(127, diags); d != &diags[1] {
+		t.Errorf("diagnose(127) = %+v, want the last diagnostic", d)
+	}
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	stderr := []byte("progress: 10%\n" +
+		"conch: jq: parse error: expected value\n" +
+		"conch: grep: /data/missing.log: No such file or directory\n" +
+		"brush: line 4: frobnicate: command not found\n")
+	want := []Diagnostic{
+		{Command: "jq", Category: CategoryCommandFailed, Message: "parse error: expected value"},
+		{Command: "grep", Category: CategoryNoSuchFile, Message: "/data/missing.log: No such file or directory"},
+		{Line: 4, Command: "frobnicate", Category: CategoryCommandNotFound, Message: "command not found"},
+	}
+	if got := ParseDiagnostics(stderr); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseDiagnostics() = %+v, want %+v", got, want)
+	}
+	if got := ParseDiagnostics([]byte("plain output\n")); got != nil {
+		t.Errorf("ParseDiagnostics() without shell errors = %+v, want nil", got)
+	}
+}
+
+func TestResultError(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.Execute("echo ok\nfrobnicate --now")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.Error == nil {
+		t.Fatalf("Error = nil, stderr %q", result.Stderr)
+	}
+	if result.Error.Category != CategoryCommandNotFound || result.Error.Command != "frobnicate" || result.Error.Line != 2 {
+		t.Errorf("Error = %+v, stderr %q", result.Error, result.Stderr)
+	}
+
+	// Text a script writes to stderr is not taken for a diagnostic.
+	result, err = exec.Execute("echo 'conch: grep: pattern too complex' >&2; exit 2")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.Error != nil || result.Diagnostics() != nil {
+		t.Errorf("Error = %+v, Diagnostics() = %+v for a faked diagnostic", result.Error, result.Diagnostics())
+	}
+
+	result, err = exec.Execute("echo fine")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.Error != nil {
+		t.Errorf("Error = %+v for a successful script", result.Error)
+	}
+}
@@ -0,0 +1,52 @@
+package conch
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidUTF8 is wrapped by an *EncodingError.
+var ErrInvalidUTF8 = errors.New("script is not valid UTF-8")
+
+// EncodingError reports the first malformed UTF-8 sequence in a script.
+type EncodingError struct {
+	// Offset is the byte offset of the sequence in the script.
+	Offset int
+	// Line and Column locate it, counting from 1. Column counts
+	// characters, not bytes.
+	Line   int
+	Column int
+}
+
+func (e *EncodingError) Error() string {
+	return fmt.Sprintf("%v: malformed sequence at line %d, column %d (byte %d)",
+		ErrInvalidUTF8, e.Line, e.Column, e.Offset)
+}
+
+func (e *EncodingError) Unwrap() error { return ErrInvalidUTF8 }
+
+// validateUTF8 returns an *EncodingError locating the first malformed
+// sequence in script, or nil if there is none. Every execution checks its
+// script with it: the library takes scripts as UTF-8 strings and rejects
+// anything else with a less useful error.
+func validateUTF8(script string) error {
+	if utf8.ValidString(script) {
+		return nil
+	}
+	offset := 0
+	for offset < len(script) {
+		r, size := utf8.DecodeRuneInString(script[offset:])
+		if r == utf8.RuneError && size == 1 {
+			break
+		}
+		offset += size
+	}
+	lineStart := strings.LastIndexByte(script[:offset], '\n') + 1
+	return &EncodingError{
+		Offset: offset,
+		Line:   1 + strings.Count(script[:offset], "\n"),
+		Column: 1 + utf8.RuneCountInString(script[lineStart:offset]),
+	}
+}
@@ -0,0 +1,63 @@
+package conch
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateUTF8(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   *EncodingError
+	}{
+		{"ascii", "echo hi", nil},
+		{"multibyte", "echo héllo ✓", nil},
+		{"empty", "", nil},
+		{"invalid byte", "echo \xff", &EncodingError{Offset: 5, Line: 1, Column: 6}},
+		{"later line", "echo ok\necho é\xe2\x82", &EncodingError{Offset: 15, Line: 2, Column: 7}},
+		{"surrogate", "echo \xed\xa0\x80", &EncodingError{Offset: 5, Line: 1, Column: 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateUTF8(tt.script)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("validateUTF8() error = %v", err)
+				}
+				return
+			}
+			var got *EncodingError
+			if !errors.As(err, &got) {
+				t.Fatalf("validateUTF8() error = %v, want *EncodingError", err)
+			}
+			if *got != *tt.want {
+				t.Errorf("validateUTF8() = %+v, want %+v", *got, *tt.want)
+			}
+			if !errors.Is(err, ErrInvalidUTF8) {
+				t.Errorf("error %v does not wrap ErrInvalidUTF8", err)
+			}
+		})
+	}
+}
+
+func TestEncodingErrorMessage(t *testing.T) {
+	err := &EncodingError{Offset: 15, Line: 2, Column: 7}
+	want := "script is not valid UTF-8: malformed sequence at line 2, column 7 (byte 15)"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestExecuteInvalidUTF8(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer exec.Close()
+	if _, err := exec.Execute("echo ok # \xff"); !errors.Is(err, ErrInvalidUTF8) {
+		t.Errorf("Execute() error = %v, want ErrInvalidUTF8", err)
+	}
+}
@@ -0,0 +1,244 @@
+package conch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// estimateLoopIterations is how many times Estimate assumes a loop runs.
+	estimateLoopIterations = 10
+	// maxEstimateLoopDepth caps the loop nesting Estimate weighs, keeping
+	// Fuel from overflowing.
+	maxEstimateLoopDepth = 12
+	// pipeBufferBytes is the memory Estimate allows for each pipe.
+	pipeBufferBytes = 64 << 10
+)
+
+// Estimate is a rough prediction of what a script costs to run, for
+// placing it on an executor or rejecting it before it runs.
+//
+// The static fields are read from the script's text without running it, so
+// they cannot see what depends on its input: every loop is assumed to run
+// ten times and every branch to be taken.
+type Estimate struct {
+	// Commands counts the simple commands in the script, including those in
+	// functions and command substitutions.
+	Commands int
+	// Loops counts the for, while, until and select loops, and LoopDepth is
+	// the deepest nesting of them.
+	Loops, LoopDepth int
+	// Fuel is the number of commands the script is expected to run: each
+	// command counted once for every iteration of the loops around it.
+	Fuel uint64
+	// MemoryBytes is the memory expected beyond the shell's own: the
+	// script's text and a buffer for every pipe.
+	MemoryBytes uint64
+	// Unbounded describes constructs that look like they never finish, such
+	// as "while true" without a break. A script with any is unlikely to end
+	// before its limits stop it.
+	Unbounded []string
+
+	// Trial is set by EstimateByTrial with what a run of the script used.
+	Trial *TrialRun
+}
+
+// TrialRun is what a capped run of a script used.
+type TrialRun struct {
+	// CPU is the time spent running the script, Timings.Execute plus
+	// Timings.Compile.
+	CPU time.Duration
+	// OutputBytes is the stdout and stderr written, including any beyond
+	// MaxOutputBytes.
+	OutputBytes int
+	// Finished is true if the script ran to completion within the trial's
+	// limits; otherwise CPU and OutputBytes are only a lower bound.
+	Finished bool
+}
+
+// Estimate predicts what script costs to run from its text alone, without
+// running it. It fails if the executor would refuse the script for its
+// size or encoding, or if the script is not valid shell.
+func (e *Executor) Estimate(script string) (Estimate, error) {
+	if err := checkScriptSize(script, e.Limits()); err != nil {
+		return Estimate{}, err
+	}
+	if err := validateUTF8(script); err != nil {
+		return Estimate{}, err
+	}
+	return estimateScript(script)
+}
+
+// EstimateByTrial is Estimate, also running script once under limits,
+// which should be tighter than the executor's own, to measure its Trial.
+// The trial is a real execution: it runs host commands and writes mounted
+// files like any other. A trial stopped by its limits is not an error.
+func (e *Executor) EstimateByTrial(ctx context.Context, script string, limits ResourceLimits) (Estimate, error) {
+	est, err := e.Estimate(script)
+	if err != nil {
+		return Estimate{}, err
+	}
+	result, err := e.ExecuteWithOptions(ctx, script, ExecOptions{Limits: &limits})
+	if ctx.Err() != nil {
+		return Estimate{}, ctx.Err()
+	}
+	trial := &TrialRun{Finished: err == nil && result != nil && !result.Truncated}
+	if result != nil {
+		trial.CPU = result.Timings.Execute + result.Timings.Compile
+		trial.OutputBytes = result.StdoutTotalLen + result.StderrTotalLen
+	}
+	est.Trial = trial
+	return est, nil
+}
+
+// estimateScript computes the static fields of an Estimate from script's
+// syntax tree.
+func estimateScript(script string) (Estimate, error) {
+	tree, err := parseScript(script)
+	if err != nil {
+		return Estimate{}, err
+	}
+	w := estimateWalk{src: script}
+	w.walk(tree, 0, nil)
+	w.est.MemoryBytes = uint64(len(script)) + uint64(w.pipes)*pipeBufferBytes
+	return w.est, nil
+}
+
+// estimateWalk holds the state of estimateScript.
+type estimateWalk struct {
+	src   string
+	est   Estimate
+	pipes int
+}
+
+// estimateFunc is a function whose body is being walked.
+type estimateFunc struct {
+	name  string
+	calls int
+}
+
+// walk adds n and what is under it to the estimate. depth is the number of
+// loop bodies around n and fn the function it is in, if any.
+func (w *estimateWalk) walk(n *syntaxNode, depth int, fn *estimateFunc) {
+	switch n.Kind {
+	case "command", "declaration_command", "unset_command", "test_command":
+		w.est.Commands++
+		w.est.Fuel += loopWeight(depth)
+		if fn != nil && w.commandName(n) == fn.name {
+			fn.calls++
+		}
+	case "pipeline":
+		for _, c := range n.Children {
+			if c.Kind == "|" || c.Kind == "|&" {
+				w.pipes++
+			}
+		}
+	case "while_statement", "until_statement", "for_statement", "c_style_for_statement":
+		w.loop(n, depth, fn)
+		return
+	case "function_definition":
+		w.function(n, depth)
+		return
+	}
+	for i := range n.Children {
+		w.walk(&n.Children[i], depth, fn)
+	}
+}
+
+// loop adds the loop n, whose body runs estimateLoopIterations times.
+func (w *estimateWalk) loop(n *syntaxNode, depth int, fn *estimateFunc) {
+	w.est.Loops++
+	for i := range n.Children {
+		c := &n.Children[i]
+		if c.Kind == "do_group" || c.Kind == "compound_statement" {
+			w.est.LoopDepth = max(w.est.LoopDepth, depth+1)
+			w.walk(c, depth+1, fn)
+		} else {
+			w.walk(c, depth, fn)
+		}
+	}
+
+	keyword := n.Children[0].Kind
+	if w.forever(n, keyword) && !w.escapes(n) {
+		w.est.Unbounded = append(w.est.Unbounded,
+			fmt.Sprintf("line %d: %s loop without break, exit or return", lineOf(w.src, n.Start), keyword))
+	}
+}
+
+// forever reports whether n, a loop starting with keyword, has a constant
+// condition that keeps it running: while true, while : or until false.
+func (w *estimateWalk) forever(n *syntaxNode, keyword string) bool {
+	for i := 1; i < len(n.Children); i++ {
+		c := &n.Children[i]
+		if !c.Named {
+			continue
+		}
+		if c.Kind != "command" {
+			return false
+		}
+		name := w.commandName(c)
+		return (keyword == "while" && (name == "true" || name == ":")) ||
+			(keyword == "until" && name == "false")
+	}
+	return false
+}
+
+// escapes reports whether n has a break, exit or return anywhere in it.
+func (w *estimateWalk) escapes(n *syntaxNode) bool {
+	if n.Kind == "command" {
+		switch w.commandName(n) {
+		case "break", "exit", "return":
+			return true
+		}
+	}
+	for i := range n.Children {
+		if w.escapes(&n.Children[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// function adds the function definition n, noting it if its body calls the
+// function more than once, which recursion multiplies.
+func (w *estimateWalk) function(n *syntaxNode, depth int) {
+	fn := &estimateFunc{}
+	for i := range n.Children {
+		c := &n.Children[i]
+		if c.Kind == "word" && fn.name == "" {
+			fn.name = c.text(w.src)
+			continue
+		}
+		w.walk(c, depth, fn)
+	}
+	if fn.calls > 1 {
+		w.est.Unbounded = append(w.est.Unbounded,
+			fmt.Sprintf("function %s calls itself %d times", fn.name, fn.calls))
+	}
+}
+
+// commandName returns the name of the command n, or "" if it has none.
+func (w *estimateWalk) commandName(n *syntaxNode) string {
+	for i := range n.Children {
+		if c := &n.Children[i]; c.Kind == "command_name" {
+			return c.text(w.src)
+		}
+	}
+	return ""
+}
+
+// loopWeight returns how many times a command inside depth loops runs.
+func loopWeight(depth int) uint64 {
+	w := uint64(1)
+	for i := 0; i < min(depth, maxEstimateLoopDepth); i++ {
+		w *= estimateLoopIterations
+	}
+	return w
+}
+
+// lineOf returns the line, counting from one, of offset in src.
+func lineOf(src string, offset int) int {
+	return 1 + strings.Count(src[:offset], "\n")
+}
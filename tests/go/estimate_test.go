@@ -0,0 +1,103 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEstimateScript(t *testing.T) {
+	skipIfNoParser(t)
+
+	tests := []struct {
+		name                       string
+		script                     string
+		commands, loops, loopDepth int
+		fuel                       uint64
+		unbounded                  int
+	}{
+		{"simple", "echo hi; X=1 grep -c x file > out 2>&1", 2, 0, 0, 2, 0},
+		{"comments and quotes", "# while true\necho 'for x in; do' \"$(date)\"", 2, 0, 0, 2, 0},
+		{"loop", "for f in a b c; do\n  wc -l \"$f\" | sort\ndone\necho end", 3, 1, 1, 21, 0},
+		{"nested loops", "while read l; do for w in $l; do echo $w; done; done", 2, 2, 2, 101, 0},
+		{"heredoc", "cat <<EOF\nwhile true; do :; done\nEOF\necho after", 2, 0, 0, 2, 0},
+		{"case", "case $x in\n  a|b) echo ab ;;\n  *) echo other ;;\nesac", 2, 0, 0, 2, 0},
+		{"function", "greet() {\n  echo \"hi $1\"\n}\ngreet you", 2, 0, 0, 2, 0},
+		{"forever", "while true; do echo y; done", 2, 1, 1, 11, 1},
+		{"forever with break", "while :; do read x || break; done", 3, 1, 1, 21, 0},
+		{"fork bomb", "b() { b | b & }; b", 3, 0, 0, 3, 1},
+		{"arithmetic shift", "((x = 1 << 2))\nwhile true; do :; done", 2, 1, 1, 11, 1},
+		{"case in substitution", "echo $(case x in a) echo a;; esac)", 2, 0, 0, 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			est, err := estimateScript(tt.script)
+			if err != nil {
+				t.Fatalf("estimateScript() error: %v", err)
+			}
+			if est.Commands != tt.commands || est.Loops != tt.loops || est.LoopDepth != tt.loopDepth || est.Fuel != tt.fuel {
+				t.Errorf("estimate = %d commands, %d loops, depth %d, fuel %d; want %d, %d, %d, %d",
+					est.Commands, est.Loops, est.LoopDepth, est.Fuel, tt.commands, tt.loops, tt.loopDepth, tt.fuel)
+			}
+			if len(est.Unbounded) != tt.unbounded {
+				t.Errorf("Unbounded = %q, want %d", est.Unbounded, tt.unbounded)
+			}
+		})
+	}
+}
+
+func TestEstimateMemory(t *testing.T) {
+	skipIfNoParser(t)
+
+	script := "cat file | sort | uniq -c"
+	est, err := estimateScript(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := uint64(len(script) + 2*pipeBufferBytes); est.MemoryBytes != want {
+		t.Errorf("MemoryBytes = %d, want %d", est.MemoryBytes, want)
+	}
+}
+
+func TestEstimateErrors(t *testing.T) {
+	e := &Executor{limits: &ResourceLimits{MaxScriptBytes: 4}}
+	if _, err := e.Estimate("echo hello"); !errors.Is(err, ErrScriptTooLarge) {
+		t.Errorf("Estimate() error = %v, want ErrScriptTooLarge", err)
+	}
+
+	skipIfNoParser(t)
+	for _, script := range []string{"echo 'open", "echo \"open", "echo $(date", "if true; then echo"} {
+		if _, err := estimateScript(script); err == nil {
+			t.Errorf("estimateScript(%q) succeeded", script)
+		}
+	}
+}
+
+func TestEstimateByTrial(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	limits := DefaultLimits()
+	limits.TimeoutMs = 200
+	est, err := exec.EstimateByTrial(context.Background(), "echo hello", limits)
+	if err != nil {
+		t.Fatalf("EstimateByTrial() error: %v", err)
+	}
+	if est.Commands != 1 || est.Trial == nil || !est.Trial.Finished || est.Trial.OutputBytes != len("hello\n") {
+		t.Errorf("estimate = %+v, trial %+v", est, est.Trial)
+	}
+
+	est, err = exec.EstimateByTrial(context.Background(), "while true; do :; done", limits)
+	if err != nil {
+		t.Fatalf("EstimateByTrial() of an endless loop error: %v", err)
+	}
+	if est.Trial.Finished || len(est.Unbounded) != 1 || !strings.Contains(est.Unbounded[0], "line 1") {
+		t.Errorf("estimate = %+v, trial %+v", est, est.Trial)
+	}
+}
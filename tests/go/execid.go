@@ -0,0 +1,96 @@
+package conch
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// ExecutionIDEnv is the environment variable holding an execution's ID inside
+// the sandbox.
+const ExecutionIDEnv = "CONCH_EXECUTION_ID"
+
+// newExecutionID returns a random ID for an execution the caller did not
+// name.
+func newExecutionID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// setExecutionID attaches id to the execution that will hold interrupt, an
+// interrupt created by l.
+func setExecutionID(l *library, interrupt uintptr, id string) error {
+	if strings.IndexByte(id, 0) >= 0 {
+		return errors.New("execution id contains a NUL byte")
+	}
+	cID, err := cString(id)
+	if err != nil {
+		return err
+	}
+	defer freeString(cID)
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	ebuf := newErrorBuffer()
+	if l.interruptSetID(interrupt, pinBytes(&pinner, cID.b), ebuf.ptr(), errorBufferSize) != 0 {
+		return fmt.Errorf("failed to set execution id: %s", ebuf)
+	}
+	return nil
+}
+
+// executionIDKey is the context key of the execution ID hooks are given.
+type executionIDKey struct{}
+
+// ExecutionIDFromContext returns the ID of the execution a hook, such as an
+// OnCommandNotFoundContext handler, was called for, or "" if ctx is not a
+// hook's or the execution had no ID.
+func ExecutionIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(executionIDKey{}).(string)
+	return id
+}
+
+var (
+	// executionContexts maps the interrupts of executions in progress to
+	// the contexts their hooks are given. They are keyed by interrupt, not
+	// ID, since callers choose IDs and executions may share one.
+	executionContextsMu sync.RWMutex
+	executionContexts   = map[uintptr]context.Context{}
+)
+
+// trackExecution hands ctx, carrying id, to the hooks the execution holding
+// interrupt calls until release is called.
+func trackExecution(ctx context.Context, interrupt uintptr, id string) (release func()) {
+	ctx = context.WithValue(ctx, executionIDKey{}, id)
+	executionContextsMu.Lock()
+	executionContexts[interrupt] = ctx
+	executionContextsMu.Unlock()
+
+	return func() {
+		executionContextsMu.Lock()
+		defer executionContextsMu.Unlock()
+		delete(executionContexts, interrupt)
+	}
+}
+
+// executionContext returns the context for a hook called by the execution
+// holding interrupt, with ID id: the one it was started with if it is in
+// progress, or a background context carrying id. Libraries that cannot say
+// which interrupt called a hook pass 0, and get the background context.
+func executionContext(interrupt uintptr, id string) context.Context {
+	executionContextsMu.RLock()
+	ctx, ok := executionContexts[interrupt]
+	executionContextsMu.RUnlock()
+	if ok && interrupt != 0 {
+		return ctx
+	}
+	if id == "" {
+		return context.Background()
+	}
+	return context.WithValue(context.Background(), executionIDKey{}, id)
+}
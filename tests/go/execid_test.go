@@ -0,0 +1,147 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewExecutionID(t *testing.T) {
+	a, b := newExecutionID(), newExecutionID()
+	if len(a) != 16 || strings.Trim(a, "0123456789abcdef") != "" {
+		t.Errorf("newExecutionID() = %q, want 16 hex digits", a)
+	}
+	if a == b {
+		t.Errorf("newExecutionID() returned %q twice", a)
+	}
+}
+
+func TestExecutionID(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	script := `echo "$` + ExecutionIDEnv + `"; export -p | grep -q ` + ExecutionIDEnv + ` && echo exported`
+	for _, id := range []string{"req-42", "it's \"quoted\" $x"} {
+		result, err := exec.ExecuteWithOptions(context.Background(), script, ExecOptions{ID: id})
+		if err != nil {
+			t.Fatalf("ExecuteWithOptions(%q) error: %v", id, err)
+		}
+		if result.ID != id {
+			t.Errorf("Result.ID = %q, want %q", result.ID, id)
+		}
+		if want := id + "\nexported\n"; string(result.Stdout) != want {
+			t.Errorf("stdout = %q, want %q (stderr %q)", result.Stdout, want, result.Stderr)
+		}
+	}
+
+	// Without an ID, one is generated and exposed the same way.
+	result, err := exec.ExecuteContext(context.Background(), "echo $"+ExecutionIDEnv)
+	if err != nil {
+		t.Fatalf("ExecuteContext() error: %v", err)
+	}
+	if result.ID == "" || string(result.Stdout) != result.ID+"\n" {
+		t.Errorf("generated ID %q, stdout %q", result.ID, result.Stdout)
+	}
+
+	if _, err := exec.ExecuteWithOptions(context.Background(), "true", ExecOptions{ID: "a\x00b"}); err == nil {
+		t.Error("ExecuteWithOptions(NUL in ID) succeeded")
+	}
+}
+
+func TestExecutionIDInTraps(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = exec.ExecuteWithOptions(ctx, `trap 'echo "term $`+ExecutionIDEnv+`"' TERM; while :; do echo tick | grep -q tick; done`,
+		ExecOptions{ID: "job-7"})
+	var trapErr *TrapError
+	if !errors.As(err, &trapErr) {
+		t.Fatalf("ExecuteWithOptions() error = %v, want *TrapError", err)
+	}
+	if trapErr.Result.ID != "job-7" || string(trapErr.Result.Stdout) != "term job-7\n" {
+		t.Errorf("trap result ID %q, stdout %q", trapErr.Result.ID, trapErr.Result.Stdout)
+	}
+}
+
+func TestTrackExecution(t *testing.T) {
+	type key struct{}
+	if ctx := executionContext(0, ""); ExecutionIDFromContext(ctx) != "" {
+		t.Errorf("executionContext(0, \"\") carries ID %q", ExecutionIDFromContext(ctx))
+	}
+	if ctx := executionContext(1, "gone"); ExecutionIDFromContext(ctx) != "gone" {
+		t.Errorf("executionContext(1, gone) carries ID %q", ExecutionIDFromContext(ctx))
+	}
+
+	// Executions sharing an ID each get their own context.
+	first := trackExecution(context.WithValue(context.Background(), key{}, 1), 1, "req")
+	second := trackExecution(context.WithValue(context.Background(), key{}, 2), 2, "req")
+	if ctx := executionContext(1, "req"); ctx.Value(key{}) != 1 || ExecutionIDFromContext(ctx) != "req" {
+		t.Errorf("executionContext(1, req) = %v", ctx)
+	}
+	if ctx := executionContext(2, "req"); ctx.Value(key{}) != 2 {
+		t.Errorf("executionContext(2, req) value = %v, want 2", ctx.Value(key{}))
+	}
+	// A library that cannot name the interrupt gets neither.
+	if ctx := executionContext(0, "req"); ctx.Value(key{}) != nil || ExecutionIDFromContext(ctx) != "req" {
+		t.Errorf("executionContext(0, req) = %v", ctx)
+	}
+	first()
+	if ctx := executionContext(2, "req"); ctx.Value(key{}) != 2 {
+		t.Errorf("after first release, value = %v, want 2", ctx.Value(key{}))
+	}
+	second()
+	if ctx := executionContext(2, "req"); ctx.Value(key{}) != nil || ExecutionIDFromContext(ctx) != "req" {
+		t.Errorf("after release, executionContext(2, req) = %v", ctx)
+	}
+}
+
+func TestCommandNotFoundContext(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	type key struct{}
+	var gotID string
+	var gotValue any
+	exec, err := New(WithEmbedded(), WithCommandNotFoundContext(
+		func(ctx context.Context, name string, _ []string) (bool, CommandResult) {
+			if name != "whoami-exec" {
+				return false, CommandResult{}
+			}
+			gotID, gotValue = ExecutionIDFromContext(ctx), ctx.Value(key{})
+			return true, CommandResult{}
+		}))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	ctx := context.WithValue(context.Background(), key{}, "caller")
+	if _, err := exec.ExecuteWithOptions(ctx, "whoami-exec", ExecOptions{ID: "req-9"}); err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+	if gotID != "req-9" || gotValue != "caller" {
+		t.Errorf("handler saw ID %q, value %v", gotID, gotValue)
+	}
+
+	_, err = New(WithEmbedded(),
+		WithCommandNotFound(func(string, []string) (bool, CommandResult) { return false, CommandResult{} }),
+		WithCommandNotFoundContext(func(context.Context, string, []string) (bool, CommandResult) {
+			return false, CommandResult{}
+		}))
+	if err == nil {
+		t.Error("New() with both command-not-found handlers succeeded")
+	}
+}
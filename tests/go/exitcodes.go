@@ -0,0 +1,113 @@
+package conch
+
+import "fmt"
+
+// Exit statuses with a conventional meaning in the shell.
+const (
+	// ExitCodeUsage is returned by builtins given invalid arguments, and by
+	// grep and similar tools when they hit an error rather than a mismatch.
+	ExitCodeUsage = 2
+	// ExitCodeBuiltinTimeout is returned by grep and jq when they run past
+	// ExecOptions.BuiltinTimeout, as timeout(1) does.
+	ExitCodeBuiltinTimeout = 124
+	// ExitCodeNotExecutable means the command was found but could not run.
+	ExitCodeNotExecutable = 126
+	// ExitCodeCommandNotFound means no command of that name exists.
+	ExitCodeCommandNotFound = 127
+	// ExitCodeSignalBase is added to a signal's number to give the status of
+	// a command it stopped, so 130 is SIGINT and 143 SIGTERM.
+	ExitCodeSignalBase = 128
+)
+
+// maxSignal is the highest signal number a status can encode.
+const maxSignal = 64
+
+// ExitReason classifies an exit status; see Result.ExitReason.
+type ExitReason int
+
+const (
+	// ExitSuccess is status 0.
+	ExitSuccess ExitReason = iota
+	// ExitFailure is any other status without a conventional meaning,
+	// usually 1.
+	ExitFailure
+	// ExitUsage is ExitCodeUsage.
+	ExitUsage
+	// ExitNotExecutable is ExitCodeNotExecutable.
+	ExitNotExecutable
+	// ExitCommandNotFound is ExitCodeCommandNotFound.
+	ExitCommandNotFound
+	// ExitSignal is a status of ExitCodeSignalBase plus a signal number; see
+	// SignalNumber.
+	ExitSignal
+)
+
+// String returns the reason name.
+func (r ExitReason) String() string {
+	switch r {
+	case ExitSuccess:
+		return "success"
+	case ExitFailure:
+		return "failure"
+	case ExitUsage:
+		return "usage"
+	case ExitNotExecutable:
+		return "not-executable"
+	case ExitCommandNotFound:
+		return "command-not-found"
+	case ExitSignal:
+		return "signal"
+	default:
+		return fmt.Sprintf("ExitReason(%d)", int(r))
+	}
+}
+
+// ExitReasonOf classifies the exit status code.
+func ExitReasonOf(code int) ExitReason {
+	switch {
+	case code == 0:
+		return ExitSuccess
+	case code == ExitCodeUsage:
+		return ExitUsage
+	case code == ExitCodeNotExecutable:
+		return ExitNotExecutable
+	case code == ExitCodeCommandNotFound:
+		return ExitCommandNotFound
+	case IsSignal(code):
+		return ExitSignal
+	default:
+		return ExitFailure
+	}
+}
+
+// ExitReason classifies the script's exit status.
+func (r *Result) ExitReason() ExitReason {
+	return ExitReasonOf(r.ExitCode)
+}
+
+// IsCommandNotFound reports whether code means a command could not be run
+// because it does not exist (127) or is not executable (126).
+func IsCommandNotFound(code int) bool {
+	return code == ExitCodeCommandNotFound || code == ExitCodeNotExecutable
+}
+
+// IsSignal reports whether code is the status of a command stopped by a
+// signal.
+func IsSignal(code int) bool {
+	return SignalNumber(code) != 0
+}
+
+// SignalNumber returns the number of the signal that stopped a command with
+// status code, or 0 if code does not encode one.
+func SignalNumber(code int) int {
+	if code <= ExitCodeSignalBase || code > ExitCodeSignalBase+maxSignal {
+		return 0
+	}
+	return code - ExitCodeSignalBase
+}
+
+// IsGrepNoMatch reports whether code is grep's status for finding no
+// matching lines, which is not an error. grep exits 2 when it fails.
+func IsGrepNoMatch(code int) bool {
+	return code == 1
+}
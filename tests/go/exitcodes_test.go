@@ -0,0 +1,76 @@
+package conch
+
+import "testing"
+
+func TestExitReasonOf(t *testing.T) {
+	tests := []struct {
+		code   int
+		reason ExitReason
+		signal int
+	}{
+		{0, ExitSuccess, 0},
+		{1, ExitFailure, 0},
+		{2, ExitUsage, 0},
+		{3, ExitFailure, 0},
+		{126, ExitNotExecutable, 0},
+		{127, ExitCommandNotFound, 0},
+		{128, ExitFailure, 0},
+		{130, ExitSignal, 2},
+		{143, ExitSignal, 15},
+		{192, ExitSignal, 64},
+		{193, ExitFailure, 0},
+		{255, ExitFailure, 0},
+	}
+	for _, tt := range tests {
+		if got := ExitReasonOf(tt.code); got != tt.reason {
+			t.Errorf("ExitReasonOf(%d) = %v, want %v", tt.code, got, tt.reason)
+		}
+		if got := SignalNumber(tt.code); got != tt.signal {
+			t.Errorf("SignalNumber(%d) = %d, want %d", tt.code, got, tt.signal)
+		}
+		if got := IsSignal(tt.code); got != (tt.signal != 0) {
+			t.Errorf("IsSignal(%d) = %v", tt.code, got)
+		}
+	}
+
+	if !IsCommandNotFound(126) || !IsCommandNotFound(127) || IsCommandNotFound(1) {
+		t.Error("IsCommandNotFound() misclassified 126, 127 or 1")
+	}
+	if !IsGrepNoMatch(1) || IsGrepNoMatch(2) || IsGrepNoMatch(0) {
+		t.Error("IsGrepNoMatch() misclassified 0, 1 or 2")
+	}
+	if got := (&Result{ExitCode: 127}).ExitReason(); got != ExitCommandNotFound {
+		t.Errorf("Result.ExitReason() = %v, want %v", got, ExitCommandNotFound)
+	}
+	if got := ExitReason(42).String(); got != "ExitReason(42)" {
+		t.Errorf("ExitReason(42).String() = %q", got)
+	}
+}
+
+func TestExitReasonFromShell(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	tests := map[string]ExitReason{
+		"true":                        ExitSuccess,
+		"false":                       ExitFailure,
+		"no-such-command-xyz":         ExitCommandNotFound,
+		"echo a | grep -q b":          ExitFailure,
+		"(exit 143)":                  ExitSignal,
+		"grep -q a /no/such/file.txt": ExitUsage,
+	}
+	for script, want := range tests {
+		result, err := exec.Execute(script)
+		if err != nil {
+			t.Fatalf("Execute(%q) error: %v", script, err)
+		}
+		if got := result.ExitReason(); got != want {
+			t.Errorf("Execute(%q) exit %d reason = %v, want %v", script, result.ExitCode, got, want)
+		}
+	}
+}
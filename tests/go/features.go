@@ -0,0 +1,63 @@
+package conch
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Feature is a shell language construct and whether the sandbox can run it.
+type Feature struct {
+	// Name is a stable identifier such as "heredocs" or
+	// "process-substitution".
+	Name string `json:"name"`
+	// Description names the construct, with an example.
+	Description string `json:"description"`
+	// Supported reports whether scripts using the feature run as they would
+	// under bash.
+	Supported bool `json:"supported"`
+	// Caveats lists known differences from bash, or why the feature is
+	// unsupported.
+	Caveats string `json:"caveats"`
+	// Probe is a script exercising the feature, and Expected the stdout it
+	// produces when the feature works.
+	Probe    string `json:"probe"`
+	Expected string `json:"expected"`
+}
+
+// SupportedFeatures reports which shell language features the sandbox
+// supports, in a stable order. A script validator can use it to reject
+// constructs the sandbox cannot run before executing anything.
+func SupportedFeatures() ([]Feature, error) {
+	l, err := acquireLibrary()
+	if err != nil {
+		return nil, err
+	}
+	defer l.release()
+	if err := l.features.load(); err != nil {
+		return nil, err
+	}
+	return parseFeatures(goString(l.supportedFeatures()))
+}
+
+// FeatureSupported reports whether the named feature is supported. Unknown
+// names return an error.
+func FeatureSupported(name string) (bool, error) {
+	features, err := SupportedFeatures()
+	if err != nil {
+		return false, err
+	}
+	for _, f := range features {
+		if f.Name == name {
+			return f.Supported, nil
+		}
+	}
+	return false, fmt.Errorf("unknown shell feature %q", name)
+}
+
+func parseFeatures(data string) ([]Feature, error) {
+	var features []Feature
+	if err := json.Unmarshal([]byte(data), &features); err != nil {
+		return nil, fmt.Errorf("failed to parse supported features: %w", err)
+	}
+	return features, nil
+}
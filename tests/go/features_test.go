@@ -0,0 +1,92 @@
+package conch
+
+import "testing"
+
+func TestParseFeatures(t *testing.T) {
+	features, err := parseFeatures(`[{"name":"heredocs","description":"Here-documents","supported":true,"caveats":"","probe":"cat <<EOF\nx\nEOF","expected":"x\n"}]`)
+	if err != nil {
+		t.Fatalf("parseFeatures() error: %v", err)
+	}
+	if len(features) != 1 || features[0].Name != "heredocs" || !features[0].Supported || features[0].Expected != "x\n" {
+		t.Errorf("parseFeatures() = %+v", features)
+	}
+
+	if _, err := parseFeatures("not json"); err == nil {
+		t.Error("parseFeatures(invalid) succeeded")
+	}
+}
+
+func TestSupportedFeatures(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	features, err := SupportedFeatures()
+	if err != nil {
+		t.Fatalf("SupportedFeatures() error: %v", err)
+	}
+
+	// Lock in the support callers rely on when validating scripts.
+	want := map[string]bool{
+		"heredocs":             true,
+		"here-strings":         true,
+		"brace-expansion":      true,
+		"indexed-arrays":       true,
+		"associative-arrays":   true,
+		"process-substitution": false,
+	}
+	for name, supported := range want {
+		got, err := FeatureSupported(name)
+		if err != nil {
+			t.Errorf("FeatureSupported(%q) error: %v", name, err)
+		} else if got != supported {
+			t.Errorf("FeatureSupported(%q) = %v, want %v", name, got, supported)
+		}
+	}
+	if _, err := FeatureSupported("teleportation"); err == nil {
+		t.Error("FeatureSupported(unknown) succeeded")
+	}
+
+	// Every report must match what the shell actually does.
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	for _, f := range features {
+		result, err := exec.Execute(f.Probe)
+		if err != nil {
+			t.Fatalf("%s: Execute() error: %v", f.Name, err)
+		}
+		works := result.ExitCode == 0 && string(result.Stdout) == f.Expected
+		if works != f.Supported {
+			t.Errorf("%s: supported = %v but probe exit %d, stdout %q, stderr %q",
+				f.Name, f.Supported, result.ExitCode, result.Stdout, result.Stderr)
+		}
+	}
+}
+
+func TestAssociativeArrays(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.Execute(`
+declare -A counts
+for w in b a b c b; do counts[$w]=$(( ${counts[$w]:-0} + 1 )); done
+for k in $(printf '%s\n' "${!counts[@]}" | sort); do echo "$k=${counts[$k]}"; done`)
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if want := "a=1\nb=3\nc=1\n"; string(result.Stdout) != want {
+		t.Errorf("stdout = %q, want %q (stderr %q)", result.Stdout, want, result.Stderr)
+	}
+
+	f, err := FeatureSupported("associative-arrays")
+	if err != nil || !f {
+		t.Errorf("FeatureSupported(associative-arrays) = %v, %v", f, err)
+	}
+}
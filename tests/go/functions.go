@@ -0,0 +1,166 @@
+package conch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"runtime"
+)
+
+// ErrInitScript is returned by New when Config.InitScript exits non-zero.
+var ErrInitScript = errors.New("init script failed")
+
+// DefineFunction registers a shell function that is callable as a command in
+// every subsequent execution on e, without resending its source each time.
+//
+// script becomes the body of name() { ... }, so it can use "$@" and return:
+//
+//	exec.DefineFunction("greet", `echo "hello, $1"`)
+//	exec.Execute("greet world") // hello, world
+//
+// Defining an existing name replaces it; an empty script removes it. A
+// function whose body fails to parse makes later executions fail with the
+// shell's diagnostics on stderr.
+func (e *Executor) DefineFunction(name, script string) error {
+	if !validFunctionName(name) {
+		return fmt.Errorf("invalid function name %q", name)
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.handle == 0 {
+		return errors.New("executor is closed")
+	}
+	if err := e.lib.functions.load(); err != nil {
+		return err
+	}
+
+	cName, err := cString(name)
+	if err != nil {
+		return err
+	}
+	defer freeString(cName)
+
+	cScript, err := cString(script)
+	if err != nil {
+		return err
+	}
+	defer freeString(cScript)
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	ebuf := newErrorBuffer()
+	if e.lib.executorDefineFunction(e.handle, pinBytes(&pinner, cName.b), pinBytes(&pinner, cScript.b),
+		ebuf.ptr(), errorBufferSize) != 0 {
+		return fmt.Errorf("failed to define function %s: %s", name, ebuf)
+	}
+	e.generation.Add(1)
+	return nil
+}
+
+// runInitScript installs script as e's init script and runs it once, so a
+// broken script is reported from the constructor rather than from the first
+// Execute.
+func (e *Executor) runInitScript(script string) error {
+	if err := e.setInitScript(script); err != nil {
+		return err
+	}
+	result, err := e.Execute("true")
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInitScript, err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("%w with exit code %d: %s",
+			ErrInitScript, result.ExitCode, bytes.TrimSpace(result.Stderr))
+	}
+	return nil
+}
+
+// setInitScript sets the script setting up every execution on e. The
+// native library runs it once and restores the state it leaves into each
+// execution's fresh shell.
+func (e *Executor) setInitScript(script string) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.handle == 0 {
+		return errors.New("executor is closed")
+	}
+	if err := e.lib.functions.load(); err != nil {
+		return err
+	}
+
+	cScript, err := cString(script)
+	if err != nil {
+		return err
+	}
+	defer freeString(cScript)
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	ebuf := newErrorBuffer()
+	if e.lib.executorSetInitScript(e.handle, pinBytes(&pinner, cScript.b), ebuf.ptr(), errorBufferSize) != 0 {
+		return fmt.Errorf("failed to set init script: %s", ebuf)
+	}
+	return nil
+}
+
+// AddFile seeds a file at name into the virtual filesystem of every
+// subsequent execution on e. name must be absolute; its top-level directory
+// is mounted read-write, and writes made by a script last only for that
+// execution. Adding an existing path replaces it.
+func (e *Executor) AddFile(name string, data []byte) error {
+	clean := path.Clean(name)
+	if !path.IsAbs(clean) || clean == "/" {
+		return fmt.Errorf("invalid file path %q: must be absolute and below /", name)
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.handle == 0 {
+		return errors.New("executor is closed")
+	}
+	if err := e.lib.functions.load(); err != nil {
+		return err
+	}
+
+	cPath, err := cString(clean)
+	if err != nil {
+		return err
+	}
+	defer freeString(cPath)
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	ebuf := newErrorBuffer()
+	if e.lib.executorAddFile(e.handle, pinBytes(&pinner, cPath.b), pinBytes(&pinner, data), uintptr(len(data)),
+		ebuf.ptr(), errorBufferSize) != 0 {
+		return fmt.Errorf("failed to add file %s: %s", clean, ebuf)
+	}
+	e.generation.Add(1)
+	return nil
+}
+
+// validFunctionName reports whether name is usable as a shell function name:
+// a letter or underscore followed by letters, digits, underscores or hyphens.
+func validFunctionName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case i > 0 && (c == '-' || c >= '0' && c <= '9'):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package conch
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidFunctionName(t *testing.T) {
+	for _, name := range []string{"deploy", "_private", "build-all", "step2"} {
+		if !validFunctionName(name) {
+			t.Errorf("validFunctionName(%q) = false, want true", name)
+		}
+	}
+	for _, name := range []string{"", "2fast", "-flag", "has space", "a;b", "f()"} {
+		if validFunctionName(name) {
+			t.Errorf("validFunctionName(%q) = true, want false", name)
+		}
+	}
+}
+
+func TestDefineFunction(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	if err := exec.DefineFunction("greet", `echo "hello, $1"`); err != nil {
+		t.Fatalf("DefineFunction() error: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err := exec.Execute("greet world")
+		if err != nil {
+			t.Fatalf("Execute() error: %v", err)
+		}
+		if got := strings.TrimSpace(string(result.Stdout)); got != "hello, world" {
+			t.Errorf("run %d: stdout = %q, want %q", i, got, "hello, world")
+		}
+	}
+
+	if err := exec.DefineFunction("greet", ""); err != nil {
+		t.Fatalf("DefineFunction() remove error: %v", err)
+	}
+	result, err := exec.Execute("greet world")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.ExitCode == 0 {
+		t.Error("removed function still callable")
+	}
+}
+
+func TestDefineFunctionInvalidName(t *testing.T) {
+	var exec Executor
+	if err := exec.DefineFunction("no spaces", "true"); err == nil {
+		t.Error("DefineFunction() with invalid name succeeded")
+	}
+}
@@ -0,0 +1,87 @@
+package conch
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// Future is the pending outcome of a script started with Submit.
+type Future struct {
+	id     string
+	done   chan struct{}
+	cancel context.CancelFunc
+	result *Result
+	err    error
+}
+
+// ID returns the execution ID, as in Result.ID.
+func (f *Future) ID() string {
+	return f.id
+}
+
+// Done returns a channel closed once the script has finished, failed or
+// been cancelled.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result waits for the script and returns its outcome, as ExecuteWithOptions
+// would have.
+func (f *Future) Result() (*Result, error) {
+	<-f.done
+	return f.result, f.err
+}
+
+// Cancel stops the script, or keeps it from starting if it is still queued.
+// Result then returns an error wrapping context.Canceled, or the script's
+// outcome if it had already finished. Cancel may be called more than once.
+func (f *Future) Cancel() {
+	f.cancel()
+}
+
+// Submit starts script in the background and returns at once. Submitted
+// scripts run on a pool of Config.SubmitWorkers workers per executor; the
+// rest wait their turn. opts.ID is generated now if empty, so the Future
+// knows it before the script starts.
+func (e *Executor) Submit(script string, opts ExecOptions) *Future {
+	return e.SubmitContext(context.Background(), script, opts)
+}
+
+// SubmitContext is Submit with the script stopped, or never started, when
+// ctx is done.
+func (e *Executor) SubmitContext(ctx context.Context, script string, opts ExecOptions) *Future {
+	if opts.ID == "" {
+		opts.ID = newExecutionID()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	f := &Future{id: opts.ID, done: make(chan struct{}), cancel: cancel}
+	slots := e.submitSlots()
+
+	go func() {
+		defer close(f.done)
+		defer cancel()
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+		case <-ctx.Done():
+			f.err = fmt.Errorf("execution interrupted: %w", ctx.Err())
+			return
+		}
+		f.result, f.err = e.ExecuteWithOptions(ctx, script, opts)
+	}()
+	return f
+}
+
+// submitSlots returns the semaphore bounding the scripts Submit runs at
+// once, creating it on first use.
+func (e *Executor) submitSlots() chan struct{} {
+	e.submitOnce.Do(func() {
+		n := e.submitWorkers
+		if n <= 0 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		e.slots = make(chan struct{}, n)
+	})
+	return e.slots
+}
@@ -0,0 +1,77 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSubmitClosedExecutor(t *testing.T) {
+	e := &Executor{}
+	f := e.Submit("echo hi", ExecOptions{})
+	if f.ID() == "" {
+		t.Error("ID() is empty")
+	}
+	select {
+	case <-f.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done() was not closed")
+	}
+	if _, err := f.Result(); err == nil {
+		t.Error("Result() on a closed executor succeeded")
+	}
+}
+
+func TestSubmitCancelQueued(t *testing.T) {
+	e := &Executor{submitWorkers: 1}
+	slots := e.submitSlots()
+	slots <- struct{}{} // occupy the only worker
+	defer func() { <-slots }()
+
+	f := e.Submit("echo hi", ExecOptions{ID: "queued"})
+	if f.ID() != "queued" {
+		t.Errorf("ID() = %q, want queued", f.ID())
+	}
+	select {
+	case <-f.Done():
+		t.Fatal("queued future finished without a free worker")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	f.Cancel()
+	f.Cancel()
+	if _, err := f.Result(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Result() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded(), WithSubmitWorkers(2))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	futures := make([]*Future, 4)
+	for i := range futures {
+		futures[i] = exec.Submit("echo done", ExecOptions{})
+	}
+	for _, f := range futures {
+		result, err := f.Result()
+		if err != nil {
+			t.Fatalf("Result() error: %v", err)
+		}
+		if string(result.Stdout) != "done\n" || result.ID != f.ID() {
+			t.Errorf("result = %q, ID %q; want done, ID %q", result.Stdout, result.ID, f.ID())
+		}
+	}
+
+	slow := exec.Submit("while true; do :; done", ExecOptions{})
+	slow.Cancel()
+	if _, err := slow.Result(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Result() after Cancel error = %v, want context.Canceled", err)
+	}
+}
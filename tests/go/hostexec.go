@@ -0,0 +1,155 @@
+package conch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Defaults of HostCommandConfig's limits.
+const (
+	defaultHostOutputBytes = 16 << 20
+	defaultHostTimeout     = 5 * time.Minute
+)
+
+// hostWaitDelay is how long a host command's output is still read after
+// it is killed, in case a child it started holds on to the pipes.
+const hostWaitDelay = time.Second
+
+// HostCommandConfig opts specific commands out of the sandbox. Every command
+// not listed keeps running inside it.
+//
+// Listed commands run as real host subprocesses with the host's environment
+// and full access to the host: only allowlist commands you would let the
+// script's author run directly.
+type HostCommandConfig struct {
+	// Allow lists the command names to run on the host, e.g. "git" or
+	// "kubectl". Names are resolved against the host's PATH. Shell builtins
+	// and commands with a sandboxed component are never affected.
+	Allow []string
+	// Dir is the working directory for host commands. Defaults to the
+	// calling process's working directory; the sandbox's virtual working
+	// directory does not exist on the host.
+	Dir string
+	// MaxOutputBytes limits what is kept of each of a command's stdout and
+	// stderr. A command writing more is killed. 0 means 16 MiB.
+	MaxOutputBytes int64
+	// Timeout kills a command still running after it, even if the
+	// execution's context allows longer. 0 means 5 minutes.
+	Timeout time.Duration
+}
+
+// validate checks that every allowlisted name is a bare command name.
+func (c HostCommandConfig) validate() error {
+	for _, name := range c.Allow {
+		if name == "" || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("invalid host command %q: must be a bare command name", name)
+		}
+	}
+	return nil
+}
+
+// allows reports whether name is allowlisted.
+func (c HostCommandConfig) allows(name string) bool {
+	for _, allowed := range c.Allow {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// handler returns a commandHandler running allowlisted commands on the host
+// and passing everything else to next, which may be nil.
+func (c HostCommandConfig) handler(next CommandNotFoundContextFunc) commandHandler {
+	allowed := make(map[string]bool, len(c.Allow))
+	for _, name := range c.Allow {
+		allowed[name] = true
+	}
+	return func(ctx context.Context, name string, args []string, stdin []byte) (bool, CommandResult) {
+		if allowed[name] {
+			return true, c.run(ctx, name, args, stdin)
+		}
+		if next != nil {
+			return next(ctx, name, args)
+		}
+		return false, CommandResult{}
+	}
+}
+
+// run executes name on the host, feeding it stdin. The process is killed if
+// ctx is done first, or when it runs past c.Timeout or writes more than
+// c.MaxOutputBytes.
+func (c HostCommandConfig) run(ctx context.Context, name string, args []string, stdin []byte) CommandResult {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultHostTimeout
+	}
+	limit := c.MaxOutputBytes
+	if limit <= 0 {
+		limit = defaultHostOutputBytes
+	}
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stdout := &hostOutput{limit: limit, exceeded: cancel}
+	stderr := &hostOutput{limit: limit, exceeded: cancel}
+	cmd := exec.CommandContext(runCtx, name, args...)
+	cmd.Dir = c.Dir
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = hostWaitDelay
+
+	err := cmd.Run()
+	result := CommandResult{Stdout: stdout.buf.Bytes(), Stderr: stderr.buf.Bytes()}
+
+	var exitErr *exec.ExitError
+	switch {
+	case stdout.over || stderr.over:
+		result.ExitCode = ExitCodeSignalBase + 9
+		result.Stderr = append(result.Stderr, fmt.Sprintf("%s: output exceeded %d bytes, killed\n", name, limit)...)
+	case ctx.Err() == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		result.ExitCode = ExitCodeBuiltinTimeout
+		result.Stderr = append(result.Stderr, fmt.Sprintf("%s: timed out after %v, killed\n", name, timeout)...)
+	case err == nil:
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+		if result.ExitCode < 0 {
+			// Killed by a signal.
+			result.ExitCode = ExitCodeSignalBase
+		}
+	case errors.Is(err, exec.ErrNotFound):
+		result.ExitCode = ExitCodeCommandNotFound
+		result.Stderr = append(result.Stderr, fmt.Sprintf("%s: host command not found\n", name)...)
+	default:
+		result.ExitCode = ExitCodeNotExecutable
+		result.Stderr = append(result.Stderr, fmt.Sprintf("%s: %v\n", name, err)...)
+	}
+	return result
+}
+
+// hostOutput keeps the first limit bytes written to it. Past them it calls
+// exceeded, to kill the command, and drops the rest.
+type hostOutput struct {
+	buf      bytes.Buffer
+	limit    int64
+	over     bool
+	exceeded func()
+}
+
+func (o *hostOutput) Write(p []byte) (int, error) {
+	if room := o.limit - int64(o.buf.Len()); int64(len(p)) > room {
+		o.buf.Write(p[:room])
+		if !o.over {
+			o.over = true
+			o.exceeded()
+		}
+		return len(p), nil
+	}
+	return o.buf.Write(p)
+}
@@ -0,0 +1,120 @@
+package conch
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func skipIfNoHostShell(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("Skipping: sh not available")
+	}
+}
+
+func TestHostCommandConfigValidate(t *testing.T) {
+	if err := (HostCommandConfig{Allow: []string{"git", "kubectl"}}).validate(); err != nil {
+		t.Errorf("validate() error: %v", err)
+	}
+	for _, name := range []string{"", "/usr/bin/git", "../git", `bin\git`} {
+		if err := (HostCommandConfig{Allow: []string{name}}).validate(); err == nil {
+			t.Errorf("validate(%q) succeeded, want error", name)
+		}
+	}
+}
+
+func TestHostCommandHandler(t *testing.T) {
+	skipIfNoHostShell(t)
+
+	h := HostCommandConfig{Allow: []string{"sh"}}.handler(nil)
+
+	handled, result := h(context.Background(), "sh", []string{"-c", "tr a-z A-Z; echo oops >&2; exit 3"}, []byte("hello\n"))
+	if !handled {
+		t.Fatal("allowlisted command not handled")
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("ExitCode = %d, want 3", result.ExitCode)
+	}
+	if got := string(result.Stdout); got != "HELLO\n" {
+		t.Errorf("Stdout = %q, want %q", got, "HELLO\n")
+	}
+	if got := string(result.Stderr); got != "oops\n" {
+		t.Errorf("Stderr = %q, want %q", got, "oops\n")
+	}
+}
+
+func TestHostCommandHandlerFallsThrough(t *testing.T) {
+	var called string
+	next := func(_ context.Context, name string, _ []string) (bool, CommandResult) {
+		called = name
+		return true, CommandResult{ExitCode: 7}
+	}
+
+	handled, result := HostCommandConfig{Allow: []string{"git"}}.handler(next)(context.Background(), "rm", []string{"-rf", "/"}, nil)
+	if !handled || result.ExitCode != 7 || called != "rm" {
+		t.Errorf("non-allowlisted command not passed to next: handled=%v result=%+v called=%q", handled, result, called)
+	}
+
+	if handled, _ := (HostCommandConfig{Allow: []string{"git"}}).handler(nil)(context.Background(), "rm", nil, nil); handled {
+		t.Error("non-allowlisted command handled without next")
+	}
+}
+
+func TestHostCommandMissingBinary(t *testing.T) {
+	name := "conch-no-such-host-command"
+	_, result := HostCommandConfig{Allow: []string{name}}.handler(nil)(context.Background(), name, nil, nil)
+	if result.ExitCode != 127 {
+		t.Errorf("ExitCode = %d, want 127", result.ExitCode)
+	}
+	if !strings.Contains(string(result.Stderr), name) {
+		t.Errorf("Stderr = %q, want command name", result.Stderr)
+	}
+}
+
+func TestNewHostCommands(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+	skipIfNoHostShell(t)
+
+	exec, err := New(Config{
+		Backend:      BackendEmbedded,
+		HostCommands: &HostCommandConfig{Allow: []string{"sh"}},
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.Execute("echo sandboxed | sh -c 'tr a-z A-Z'")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if got := strings.TrimSpace(string(result.Stdout)); got != "SANDBOXED" {
+		t.Errorf("stdout = %q, want %q", got, "SANDBOXED")
+	}
+}
+
+func TestHostCommandLimits(t *testing.T) {
+	skipIfNoHostShell(t)
+
+	h := HostCommandConfig{Allow: []string{"sh"}, MaxOutputBytes: 8, Timeout: 100 * time.Millisecond}.handler(nil)
+
+	start := time.Now()
+	_, result := h(context.Background(), "sh", []string{"-c", "while :; do echo line; done"}, nil)
+	if got := string(result.Stdout); got != "line\nlin" {
+		t.Errorf("Stdout = %q, want the first 8 bytes", got)
+	}
+	if result.ExitCode != ExitCodeSignalBase+9 || !strings.Contains(string(result.Stderr), "output exceeded 8 bytes") {
+		t.Errorf("endless output = %d, %q", result.ExitCode, result.Stderr)
+	}
+
+	_, result = h(context.Background(), "sh", []string{"-c", "sleep 10"}, nil)
+	if result.ExitCode != ExitCodeBuiltinTimeout || !strings.Contains(string(result.Stderr), "timed out") {
+		t.Errorf("sleep = %d, %q", result.ExitCode, result.Stderr)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("limited commands took %v", elapsed)
+	}
+}
@@ -0,0 +1,358 @@
+package conch
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMaxResponseBytes is HostHTTPConfig.MaxResponseBytes when unset.
+const defaultMaxResponseBytes = 16 << 20
+
+// HostHTTPConfig services curl from the host with a Go http.Client instead
+// of the sandbox's own networking, so scripts reach the network through
+// the host's proxy and trust its certificate authorities. Corporate
+// networks with a TLS-intercepting proxy usually need both.
+//
+// It understands the curl options HTTPFixtures does, and also sends -H,
+// -A, -e and -u and honours -m. curl is serviced this way only when the
+// library has no curl component of its own. HTTPFixtures, if also set,
+// takes precedence.
+type HostHTTPConfig struct {
+	// Client sends the requests. If nil, one is built from Proxy and
+	// CABundle; if set, those fields are ignored. Its Transport must be an
+	// *http.Transport, or nil, so its connections can be checked against
+	// the NetworkPolicy.
+	Client *http.Client
+	// Proxy is the URL of the proxy to send requests through, such as
+	// "http://proxy.example.com:3128". Empty uses the HTTPS_PROXY,
+	// HTTP_PROXY and NO_PROXY environment variables.
+	Proxy string
+	// CABundle is the path of a PEM file of certificates to trust in
+	// addition to the system's, such as the proxy's root.
+	CABundle string
+	// InsecureSkipVerify turns off certificate verification, as curl -k
+	// does. Use CABundle instead where possible.
+	InsecureSkipVerify bool
+	// MaxResponseBytes limits the response body read; larger bodies fail
+	// the command with curl's exit status 63. 0 means 16 MiB.
+	MaxResponseBytes int64
+}
+
+// client returns the http.Client to send requests with, asking connect
+// before every connection it opens.
+func (c *HostHTTPConfig) client(connect connectHandler) (*http.Client, error) {
+	transport, err := c.transport()
+	if err != nil {
+		return nil, err
+	}
+	if transport.DialTLSContext != nil || transport.DialTLS != nil {
+		return nil, errors.New("HostHTTPConfig.Client must not dial TLS itself, so its connections can be checked")
+	}
+	guardTransport(transport, connect)
+	client := &http.Client{}
+	if c.Client != nil {
+		*client = *c.Client
+	}
+	client.Transport = transport
+	return client, nil
+}
+
+// transport returns a copy of the transport to send requests with.
+func (c *HostHTTPConfig) transport() (*http.Transport, error) {
+	if c.Client != nil {
+		switch t := c.Client.Transport.(type) {
+		case nil:
+			return http.DefaultTransport.(*http.Transport).Clone(), nil
+		case *http.Transport:
+			return t.Clone(), nil
+		default:
+			return nil, errors.New("HostHTTPConfig.Client must use an *http.Transport so its connections can be checked")
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.Proxy != "" {
+		proxy, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTTP proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if c.CABundle != "" || c.InsecureSkipVerify {
+		tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+		if c.CABundle != "" {
+			pem, err := os.ReadFile(c.CABundle)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+			}
+			roots, err := x509.SystemCertPool()
+			if err != nil {
+				roots = x509.NewCertPool()
+			}
+			if !roots.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("CA bundle %s has no PEM certificates", c.CABundle)
+			}
+			tlsConfig.RootCAs = roots
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return transport, nil
+}
+
+// errConnectDenied is the error of connections a NetworkPolicy refused.
+var errConnectDenied = errors.New("connection refused by the network policy")
+
+// guardTransport has t ask connect before every connection it opens, and
+// about the target of every request it sends through a proxy. Connections
+// go to the address that was allowed, so a name cannot resolve to another
+// one in between. The proxies themselves are configured by the host and
+// not checked.
+func guardTransport(t *http.Transport, connect connectHandler) {
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	// proxies holds the "host:port" of the proxies requests were sent
+	// through.
+	var proxies sync.Map
+	if proxy := t.Proxy; proxy != nil {
+		t.Proxy = func(req *http.Request) (*url.URL, error) {
+			u, err := proxy(req)
+			if err != nil || u == nil {
+				return u, err
+			}
+			if err := checkProxied(req.Context(), connect, req.URL); err != nil {
+				return nil, err
+			}
+			proxies.Store(net.JoinHostPort(u.Hostname(), urlPort(u)), struct{}{})
+			return u, nil
+		}
+	}
+	t.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if _, ok := proxies.Load(address); ok {
+			return dial(ctx, network, address)
+		}
+		addrs, err := resolveAddress(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		err = errConnectDenied
+		for _, addr := range addrs {
+			if !connect(connectRequest{executionID: ExecutionIDFromContext(ctx), command: "curl", network: "tcp", addr: addr}) {
+				continue
+			}
+			var conn net.Conn
+			if conn, err = dial(ctx, network, addr.String()); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// checkProxied asks connect about target, a URL requested through a
+// proxy. If the host cannot resolve its name, which the proxy may, the
+// name is checked as a lookup instead.
+func checkProxied(ctx context.Context, connect connectHandler, target *url.URL) error {
+	c := connectRequest{executionID: ExecutionIDFromContext(ctx), command: "curl"}
+	addrs, err := resolveAddress(ctx, net.JoinHostPort(target.Hostname(), urlPort(target)))
+	if err != nil {
+		c.network = "dns"
+		c.host = target.Hostname()
+		if !connect(c) {
+			return errConnectDenied
+		}
+		return nil
+	}
+	c.network = "tcp"
+	for _, c.addr = range addrs {
+		if !connect(c) {
+			return errConnectDenied
+		}
+	}
+	return nil
+}
+
+// resolveAddress resolves the host of address, a "host:port".
+func resolveAddress(ctx context.Context, address string) ([]netip.AddrPort, error) {
+	host, portName, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := net.DefaultResolver.LookupPort(ctx, "tcp", portName)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]netip.AddrPort, len(ips))
+	for i, ip := range ips {
+		addrs[i] = netip.AddrPortFrom(ip.Unmap(), uint16(port))
+	}
+	return addrs, nil
+}
+
+// urlPort is the port of u, or its scheme's default.
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	switch u.Scheme {
+	case "https":
+		return "443"
+	case "socks5", "socks5h":
+		return "1080"
+	}
+	return "80"
+}
+
+// hostConnectHandler returns the connectHandler checking the connections
+// HostHTTP opens: those policy, which may be nil, allows, except that
+// host-local addresses must be listed in it.
+func hostConnectHandler(policy *NetworkPolicy) (connectHandler, error) {
+	if policy == nil {
+		policy = &NetworkPolicy{}
+	}
+	lists, err := policy.lists()
+	if err != nil {
+		return nil, err
+	}
+	deny := policy.DenyByDefault
+	return func(c connectRequest) bool {
+		if c.network != "dns" && hostLocal(c.addr.Addr()) {
+			return lists.allow(c)
+		}
+		return !deny || lists.allow(c)
+	}, nil
+}
+
+// hostLocal reports whether addr reaches the host itself or its link, such
+// as a loopback port or a cloud metadata service.
+func hostLocal(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsUnspecified()
+}
+
+// httpLimits bound the requests hostCurl sends.
+type httpLimits struct {
+	// request and response are the largest request and response bodies;
+	// request is unlimited if 0.
+	request, response int64
+	// timeout bounds the whole request, or is 0.
+	timeout time.Duration
+}
+
+// handler returns a commandHandler servicing curl with client and passing
+// other commands to next, which may be nil. policy, if not nil, further
+// limits the requests, and connect is asked before every connection.
+func (c *HostHTTPConfig) handler(next commandHandler, policy *NetworkPolicy, connect connectHandler) (commandHandler, error) {
+	client, err := c.client(connect)
+	if err != nil {
+		return nil, err
+	}
+	limits := httpLimits{response: c.MaxResponseBytes}
+	if limits.response <= 0 {
+		limits.response = defaultMaxResponseBytes
+	}
+	if policy != nil {
+		if policy.MaxBodyBytes > 0 {
+			limits.request = policy.MaxBodyBytes
+			limits.response = min(limits.response, policy.MaxBodyBytes)
+		}
+		limits.timeout = policy.Timeout
+	}
+	return func(ctx context.Context, name string, args []string, stdin []byte) (bool, CommandResult) {
+		if name != "curl" {
+			if next == nil {
+				return false, CommandResult{}
+			}
+			return next(ctx, name, args, stdin)
+		}
+		return true, hostCurl(ctx, client, limits, args, stdin)
+	}, nil
+}
+
+// hostCurl runs curl with args by sending the request with client, within
+// limits.
+func hostCurl(ctx context.Context, client *http.Client, limits httpLimits, args []string, stdin []byte) CommandResult {
+	req, err := parseCurl(args, stdin)
+	if err != nil {
+		return CommandResult{ExitCode: ExitCodeUsage, Stderr: []byte("curl: " + err.Error() + "\n")}
+	}
+	timeout := limits.timeout
+	if req.maxTime > 0 {
+		maxTime := time.Duration(req.maxTime * float64(time.Second))
+		if timeout == 0 || maxTime < timeout {
+			timeout = maxTime
+		}
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if limits.request > 0 && int64(len(req.body)) > limits.request {
+		return req.error(63, fmt.Sprintf("Request body larger than the maximum allowed size (%d)", limits.request))
+	}
+
+	target := req.url
+	if !strings.Contains(target, "://") {
+		// curl defaults to http for URLs without a scheme.
+		target = "http://" + target
+	}
+	var body io.Reader
+	if req.body != nil {
+		body = bytes.NewReader(req.body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, body)
+	if err != nil {
+		return req.error(3, "URL using bad/illegal format or missing URL")
+	}
+	httpReq.Header = req.header.Clone()
+	if req.body != nil && httpReq.Header.Get("Content-Type") == "" {
+		httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if req.user != "" {
+		user, password, _ := strings.Cut(req.user, ":")
+		httpReq.SetBasicAuth(user, password)
+	}
+
+	if !req.location {
+		c := *client
+		c.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+		client = &c
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return req.error(28, fmt.Sprintf("Operation timed out: %v", err))
+		}
+		return req.error(7, fmt.Sprintf("Failed to connect: %v", err))
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limits.response+1))
+	switch {
+	case err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return req.error(28, fmt.Sprintf("Operation timed out: %v", err))
+	case err != nil:
+		return req.error(56, fmt.Sprintf("Failure when receiving data from the peer: %v", err))
+	case int64(len(data)) > limits.response:
+		return req.error(63, fmt.Sprintf("Exceeded the maximum allowed file size (%d)", limits.response))
+	}
+	return req.response(resp.StatusCode, resp.Header, data)
+}
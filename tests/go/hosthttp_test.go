@@ -0,0 +1,255 @@
+package conch
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loopbackPolicy lets HostHTTP reach the test servers, which listen on
+// loopback.
+var loopbackPolicy = &NetworkPolicy{AllowCIDRs: []string{"127.0.0.0/8"}}
+
+// hostCurlHandler returns the curl handler of c under policy, failing t if
+// it cannot be built.
+func hostCurlHandler(t *testing.T, c *HostHTTPConfig, policy *NetworkPolicy) commandHandler {
+	t.Helper()
+	connect, err := hostConnectHandler(policy)
+	if err != nil {
+		t.Fatalf("hostConnectHandler() error: %v", err)
+	}
+	h, err := c.handler(nil, policy, connect)
+	if err != nil {
+		t.Fatalf("handler() error: %v", err)
+	}
+	return h
+}
+
+func TestHostHTTPCurl(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/echo":
+			body, _ := io.ReadAll(r.Body)
+			user, _, _ := r.BasicAuth()
+			w.Header().Set("X-Method", r.Method)
+			io.WriteString(w, r.Header.Get("X-Token")+" "+user+" "+string(body))
+		case "/moved":
+			http.Redirect(w, r, "/echo", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	h := hostCurlHandler(t, &HostHTTPConfig{Client: srv.Client()}, loopbackPolicy)
+
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		exitCode int
+		stdout   string
+	}{
+		{"post", []string{"-s", "-H", "X-Token: abc", "-u", "bob:pw", "-d", "@-", srv.URL + "/echo"}, "hello", 0, "abc bob hello"},
+		{"not found", []string{"-s", "-w", `%{http_code}`, srv.URL + "/missing"}, "", 0, "404"},
+		{"fail", []string{"-sf", srv.URL + "/missing"}, "", 22, ""},
+		{"redirect not followed", []string{"-s", "-w", `%{http_code}`, srv.URL + "/moved"}, "", 0, "302"},
+		{"redirect followed", []string{"-sL", srv.URL + "/moved"}, "", 0, "  "},
+		{"refused", []string{"-s", "http://127.0.0.1:1/"}, "", 7, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled, result := h(context.Background(), "curl", tt.args, []byte(tt.stdin))
+			if !handled {
+				t.Fatal("curl not handled")
+			}
+			if result.ExitCode != tt.exitCode || (tt.exitCode == 0 && !strings.HasSuffix(string(result.Stdout), tt.stdout)) {
+				t.Errorf("curl %v = %d %q (stderr %q), want %d %q", tt.args, result.ExitCode, result.Stdout, result.Stderr, tt.exitCode, tt.stdout)
+			}
+		})
+	}
+
+	if handled, _ := h(context.Background(), "wget", nil, nil); handled {
+		t.Error("handler serviced a command other than curl")
+	}
+}
+
+func TestHostHTTPMaxResponseBytes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 100))
+	}))
+	defer srv.Close()
+	h := hostCurlHandler(t, &HostHTTPConfig{Client: srv.Client(), MaxResponseBytes: 10}, loopbackPolicy)
+
+	if _, result := h(context.Background(), "curl", []string{"-s", srv.URL}, nil); result.ExitCode != 63 {
+		t.Errorf("ExitCode = %d, want 63", result.ExitCode)
+	}
+}
+
+func TestHostHTTPNetworkPolicyLimits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		io.WriteString(w, strings.Repeat("x", 100))
+	}))
+	defer srv.Close()
+	policy := &NetworkPolicy{AllowCIDRs: []string{"127.0.0.0/8"}, MaxBodyBytes: 10, Timeout: 100 * time.Millisecond}
+	h := hostCurlHandler(t, &HostHTTPConfig{Client: srv.Client()}, policy)
+
+	tests := []struct {
+		name     string
+		args     []string
+		exitCode int
+	}{
+		{"response body", []string{"-s", srv.URL}, 63},
+		{"request body", []string{"-s", "-d", strings.Repeat("y", 11), srv.URL}, 63},
+		{"timeout", []string{"-s", srv.URL + "/slow"}, 28},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, result := h(context.Background(), "curl", tt.args, nil); result.ExitCode != tt.exitCode {
+				t.Errorf("ExitCode = %d (stderr %q), want %d", result.ExitCode, result.Stderr, tt.exitCode)
+			}
+		})
+	}
+}
+
+func TestHostHTTPNetworkPolicy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "local")
+	}))
+	defer srv.Close()
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+
+	sink := &auditRecorder{}
+	connect, err := hostConnectHandler(nil)
+	if err != nil {
+		t.Fatalf("hostConnectHandler() error: %v", err)
+	}
+	h, err := (&HostHTTPConfig{Client: srv.Client()}).handler(nil, nil, auditConnectHandler(sink, nil, connect))
+	if err != nil {
+		t.Fatalf("handler() error: %v", err)
+	}
+	ctx := context.WithValue(context.Background(), executionIDKey{}, "exec-1")
+	if _, result := h(ctx, "curl", []string{"-s", srv.URL}, nil); result.ExitCode != 7 {
+		t.Errorf("curl to loopback without a policy = %d %q, want 7", result.ExitCode, result.Stdout)
+	}
+	events := sink.get()
+	if len(events) == 0 || events[0].ExecutionID != "exec-1" || events[0].Network != "tcp" || !events[0].Denied {
+		t.Errorf("events = %+v", events)
+	}
+
+	allowed := hostCurlHandler(t, &HostHTTPConfig{Client: srv.Client()}, &NetworkPolicy{AllowHosts: []string{"localhost"}, DenyByDefault: true})
+	url := fmt.Sprintf("http://localhost:%d/", port)
+	if _, result := allowed(context.Background(), "curl", []string{"-s", url}, nil); result.ExitCode != 0 || string(result.Stdout) != "local" {
+		t.Errorf("curl to an allowed host = %d %q (stderr %q)", result.ExitCode, result.Stdout, result.Stderr)
+	}
+}
+
+func TestHostHTTPNetworkPolicyProxied(t *testing.T) {
+	var proxied bool
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = true
+	}))
+	defer proxy.Close()
+	policy := &NetworkPolicy{AllowCIDRs: []string{"198.51.100.0/24"}, DenyByDefault: true}
+
+	h := hostCurlHandler(t, &HostHTTPConfig{Proxy: proxy.URL}, policy)
+	if _, result := h(context.Background(), "curl", []string{"-s", "http://169.254.169.254/latest/meta-data/"}, nil); result.ExitCode != 7 || proxied {
+		t.Errorf("curl through the proxy to a denied address = %d, proxied %v", result.ExitCode, proxied)
+	}
+	if _, result := h(context.Background(), "curl", []string{"-s", "http://198.51.100.1/"}, nil); result.ExitCode != 0 || !proxied {
+		t.Errorf("curl through the proxy to an allowed address = %d (stderr %q), proxied %v", result.ExitCode, result.Stderr, proxied)
+	}
+}
+
+func TestHostConnectHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *NetworkPolicy
+		addr   string
+		want   bool
+	}{
+		{"public", nil, "203.0.113.7:443", true},
+		{"loopback", nil, "127.0.0.1:8080", false},
+		{"ipv6 loopback", nil, "[::1]:8080", false},
+		{"metadata", nil, "169.254.169.254:80", false},
+		{"unspecified", nil, "0.0.0.0:22", false},
+		{"mapped loopback", nil, "[::ffff:127.0.0.1]:80", false},
+		{"listed loopback", &NetworkPolicy{AllowCIDRs: []string{"127.0.0.1/32"}}, "127.0.0.1:8080", true},
+		{"deny by default", &NetworkPolicy{DenyByDefault: true}, "203.0.113.7:443", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := hostConnectHandler(tt.policy)
+			if err != nil {
+				t.Fatalf("hostConnectHandler() error: %v", err)
+			}
+			if got := h(connectRequest{command: "curl", network: "tcp", addr: netip.MustParseAddrPort(tt.addr)}); got != tt.want {
+				t.Errorf("allow(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostHTTPCABundle(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secure")
+	}))
+	defer srv.Close()
+
+	if _, result := hostCurlHandler(t, &HostHTTPConfig{}, loopbackPolicy)(context.Background(), "curl", []string{"-s", srv.URL}, nil); result.ExitCode == 0 {
+		t.Fatal("curl trusted the test server's certificate without a CA bundle")
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(bundle, cert, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, result := hostCurlHandler(t, &HostHTTPConfig{CABundle: bundle}, loopbackPolicy)(context.Background(), "curl", []string{"-s", srv.URL}, nil)
+	if result.ExitCode != 0 || string(result.Stdout) != "secure" {
+		t.Errorf("curl with CA bundle = %d %q (stderr %q)", result.ExitCode, result.Stdout, result.Stderr)
+	}
+}
+
+func TestHostHTTPProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		io.WriteString(w, "via proxy")
+	}))
+	defer proxy.Close()
+	h := hostCurlHandler(t, &HostHTTPConfig{Proxy: proxy.URL}, loopbackPolicy)
+
+	_, result := h(context.Background(), "curl", []string{"-s", "http://api.example.com/items"}, nil)
+	if result.ExitCode != 0 || string(result.Stdout) != "via proxy" || proxied != "http://api.example.com/items" {
+		t.Errorf("curl = %d %q, proxy saw %q", result.ExitCode, result.Stdout, proxied)
+	}
+}
+
+func TestHostHTTPConfigErrors(t *testing.T) {
+	for name, c := range map[string]*HostHTTPConfig{
+		"bad proxy":      {Proxy: "http://[::1"},
+		"missing bundle": {CABundle: filepath.Join(t.TempDir(), "missing.pem")},
+	} {
+		if _, err := c.handler(nil, nil, func(connectRequest) bool { return true }); err == nil {
+			t.Errorf("%s: handler() error = nil", name)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package conch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HTTPFixture is a canned response served to curl by HTTPFixtures.
+type HTTPFixture struct {
+	// Status is the HTTP status code; 0 means 200.
+	Status int
+	// Header is sent with the response, shown by curl -i and -I.
+	Header http.Header
+	// Body is the response body.
+	Body []byte
+}
+
+// HTTPRequest is a request a script made with curl, as recorded by
+// HTTPFixtures.
+type HTTPRequest struct {
+	Method string
+	URL    string
+	Body   []byte
+}
+
+// HTTPFixtures answers curl from canned responses instead of the network,
+// so scripts that make HTTP calls run deterministically in tests and demos.
+// Responses are looked up by "METHOD URL", such as "POST
+// https://api.example.com/items", then by the URL alone. A request with no
+// fixture fails as curl does when it cannot connect, with exit status 7.
+//
+// Only the common curl options are understood: -X, -d and its --data
+// variants (with @- reading stdin), -H, -i, -I, -f, -s, -S, -L and
+// -w '%{http_code}'. Others that take a value, such as -o, fail the
+// command. curl is answered from fixtures only when the library has no curl
+// component of its own.
+type HTTPFixtures struct {
+	// Responses maps "METHOD URL" or "URL" to its response.
+	Responses map[string]HTTPFixture
+
+	mu       sync.Mutex
+	requests []HTTPRequest
+}
+
+// Requests returns the requests scripts have made so far, in order.
+func (f *HTTPFixtures) Requests() []HTTPRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]HTTPRequest(nil), f.requests...)
+}
+
+// lookup returns the response for method and url.
+func (f *HTTPFixtures) lookup(method, url string) (HTTPFixture, bool) {
+	if resp, ok := f.Responses[method+" "+url]; ok {
+		return resp, true
+	}
+	resp, ok := f.Responses[url]
+	return resp, ok
+}
+
+// handler returns a commandHandler servicing curl from the fixtures and
+// passing other commands to next, which may be nil.
+func (f *HTTPFixtures) handler(next commandHandler) commandHandler {
+	return func(ctx context.Context, name string, args []string, stdin []byte) (bool, CommandResult) {
+		if name != "curl" {
+			if next == nil {
+				return false, CommandResult{}
+			}
+			return next(ctx, name, args, stdin)
+		}
+		return true, f.curl(args, stdin)
+	}
+}
+
+// curlRequest is a parsed curl command line.
+type curlRequest struct {
+	method    string
+	url       string
+	body      []byte
+	include   bool
+	head      bool
+	fail      bool
+	silent    bool
+	showError bool
+	location  bool
+	writeOut  string
+	// header holds the -H, -A and -e options and user the -u option,
+	// which only HostHTTPConfig sends; the fixtures ignore them.
+	header http.Header
+	user   string
+	// maxTime is the -m option in seconds, or 0; HostHTTPConfig stops the
+	// request after it.
+	maxTime float64
+}
+
+// curlIgnoredFlags are the curl options taking a value that are accepted
+// and ignored.
+var curlIgnoredFlags = map[string]bool{
+	"--connect-timeout": true, "--retry": true,
+}
+
+// parseCurl parses args, reading a body given as @- from stdin.
+func parseCurl(args []string, stdin []byte) (*curlRequest, error) {
+	req := &curlRequest{header: http.Header{}}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := func() (string, error) {
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("option %s: requires parameter", arg)
+			}
+			i++
+			return args[i], nil
+		}
+		switch {
+		case arg == "-X" || arg == "--request":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			req.method = v
+		case arg == "-d" || arg == "--data" || arg == "--data-raw" || arg == "--data-binary":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			if v == "@-" {
+				req.body = append(req.body, stdin...)
+			} else {
+				req.body = append(req.body, v...)
+			}
+		case arg == "-w" || arg == "--write-out":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			req.writeOut = v
+		case arg == "-H" || arg == "--header":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			name, val, ok := strings.Cut(v, ":")
+			if !ok {
+				return nil, fmt.Errorf("option %s: header %q has no colon", arg, v)
+			}
+			req.header.Add(strings.TrimSpace(name), strings.TrimSpace(val))
+		case arg == "-A" || arg == "--user-agent" || arg == "-e" || arg == "--referer":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			if arg == "-A" || arg == "--user-agent" {
+				req.header.Set("User-Agent", v)
+			} else {
+				req.header.Set("Referer", v)
+			}
+		case arg == "-u" || arg == "--user":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			req.user = v
+		case arg == "-m" || arg == "--max-time":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			if req.maxTime, err = strconv.ParseFloat(v, 64); err != nil || req.maxTime < 0 {
+				return nil, fmt.Errorf("option %s: expected a proper numerical parameter", arg)
+			}
+		case curlIgnoredFlags[arg]:
+			if _, err := value(); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(arg, "--"):
+			switch arg {
+			case "--include":
+				req.include = true
+			case "--head":
+				req.head = true
+			case "--fail":
+				req.fail = true
+			case "--silent":
+				req.silent = true
+			case "--show-error":
+				req.showError = true
+			case "--location":
+				req.location = true
+			default:
+				return nil, fmt.Errorf("option %s: is not supported", arg)
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			for _, c := range arg[1:] {
+				switch c {
+				case 'i':
+					req.include = true
+				case 'I':
+					req.head = true
+				case 'f':
+					req.fail = true
+				case 's':
+					req.silent = true
+				case 'S':
+					req.showError = true
+				case 'L':
+					req.location = true
+				default:
+					return nil, fmt.Errorf("option -%c: is not supported", c)
+				}
+			}
+		case req.url == "":
+			req.url = arg
+		default:
+			return nil, fmt.Errorf("only one URL is supported, got %s and %s", req.url, arg)
+		}
+	}
+	if req.url == "" {
+		return nil, fmt.Errorf("no URL specified")
+	}
+	if req.method == "" {
+		switch {
+		case req.head:
+			req.method = http.MethodHead
+		case req.body != nil:
+			req.method = http.MethodPost
+		default:
+			req.method = http.MethodGet
+		}
+	}
+	return req, nil
+}
+
+// curl runs curl with args against the fixtures.
+func (f *HTTPFixtures) curl(args []string, stdin []byte) CommandResult {
+	req, err := parseCurl(args, stdin)
+	if err != nil {
+		return CommandResult{ExitCode: ExitCodeUsage, Stderr: []byte("curl: " + err.Error() + "\n")}
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, HTTPRequest{Method: req.method, URL: req.url, Body: req.body})
+	f.mu.Unlock()
+
+	resp, ok := f.lookup(req.method, req.url)
+	if !ok {
+		return req.error(7, fmt.Sprintf("Failed to connect: no HTTP fixture for %s %s", req.method, req.url))
+	}
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return req.response(status, resp.Header, resp.Body)
+}
+
+// error returns the result of curl failing with code, printing msg unless
+// silenced.
+func (req *curlRequest) error(code int, msg string) CommandResult {
+	result := CommandResult{ExitCode: code}
+	if !req.silent || req.showError {
+		result.Stderr = []byte(fmt.Sprintf("curl: (%d) %s\n", code, msg))
+	}
+	return result
+}
+
+// response returns the result of curl receiving a response, formatted as
+// the options ask.
+func (req *curlRequest) response(status int, header http.Header, body []byte) CommandResult {
+	if req.fail && status >= 400 {
+		return req.error(22, fmt.Sprintf("The requested URL returned error: %d", status))
+	}
+
+	var out bytes.Buffer
+	if req.include || req.head {
+		fmt.Fprintf(&out, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+		names := make([]string, 0, len(header))
+		for name := range header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, value := range header[name] {
+				fmt.Fprintf(&out, "%s: %s\r\n", name, value)
+			}
+		}
+		out.WriteString("\r\n")
+	}
+	if !req.head {
+		out.Write(body)
+	}
+	out.WriteString(strings.NewReplacer(`%{http_code}`, fmt.Sprint(status), `\n`, "\n").Replace(req.writeOut))
+	return CommandResult{Stdout: out.Bytes()}
+}
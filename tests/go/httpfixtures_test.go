@@ -0,0 +1,87 @@
+package conch
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestHTTPFixturesCurl(t *testing.T) {
+	f := &HTTPFixtures{Responses: map[string]HTTPFixture{
+		"https://api.example.com/items":      {Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`[1,2]`)},
+		"POST https://api.example.com/items": {Status: http.StatusCreated, Body: []byte(`{"id":3}`)},
+		"https://api.example.com/missing":    {Status: http.StatusNotFound, Body: []byte("not found")},
+	}}
+	h := f.handler(nil)
+
+	tests := []struct {
+		name   string
+		args   []string
+		stdin  string
+		exit   int
+		stdout string
+		stderr string
+	}{
+		{"get", []string{"-s", "https://api.example.com/items"}, "", 0, "[1,2]", ""},
+		{"include", []string{"-i", "https://api.example.com/items"}, "", 0, "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n\r\n[1,2]", ""},
+		{"head", []string{"-sSI", "https://api.example.com/items"}, "", 0, "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n\r\n", ""},
+		{"post from stdin", []string{"-d", "@-", "-w", `\n%{http_code}\n`, "https://api.example.com/items"}, `{"n":3}`, 0, "{\"id\":3}\n201\n", ""},
+		{"not found", []string{"https://api.example.com/missing"}, "", 0, "not found", ""},
+		{"fail", []string{"-sSf", "https://api.example.com/missing"}, "", 22, "", "curl: (22) The requested URL returned error: 404\n"},
+		{"no fixture", []string{"-X", "DELETE", "https://api.example.com/orders"}, "", 7, "", "curl: (7) Failed to connect: no HTTP fixture for DELETE https://api.example.com/orders\n"},
+		{"silent failure", []string{"-s", "https://other.example.com/"}, "", 7, "", ""},
+		{"unsupported", []string{"-o", "out.json", "https://api.example.com/items"}, "", ExitCodeUsage, "", "curl: option -o: is not supported\n"},
+		{"no url", []string{"-s"}, "", ExitCodeUsage, "", "curl: no URL specified\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled, result := h(context.Background(), "curl", tt.args, []byte(tt.stdin))
+			if !handled || result.ExitCode != tt.exit || string(result.Stdout) != tt.stdout || string(result.Stderr) != tt.stderr {
+				t.Errorf("curl %q = handled %v, exit %d, stdout %q, stderr %q", tt.args, handled, result.ExitCode, result.Stdout, result.Stderr)
+			}
+		})
+	}
+
+	want := []HTTPRequest{
+		{Method: "GET", URL: "https://api.example.com/items"},
+		{Method: "GET", URL: "https://api.example.com/items"},
+		{Method: "HEAD", URL: "https://api.example.com/items"},
+		{Method: "POST", URL: "https://api.example.com/items", Body: []byte(`{"n":3}`)},
+		{Method: "GET", URL: "https://api.example.com/missing"},
+		{Method: "GET", URL: "https://api.example.com/missing"},
+		{Method: "DELETE", URL: "https://api.example.com/orders"},
+		{Method: "GET", URL: "https://other.example.com/"},
+	}
+	if got := f.Requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Requests() = %+v, want %+v", got, want)
+	}
+
+	if handled, _ := h(context.Background(), "wget", nil, nil); handled {
+		t.Error("handler handled wget with no next handler")
+	}
+}
+
+func TestExecuteHTTPFixtures(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	fixtures := &HTTPFixtures{Responses: map[string]HTTPFixture{
+		"https://api.example.com/user": {Body: []byte(`{"login":"octo"}`)},
+	}}
+	exec, err := New(WithEmbedded(), WithHTTPFixtures(fixtures))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.Execute(`curl -s https://api.example.com/user | jq -r .login`)
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if string(result.Stdout) != "octo\n" {
+		t.Errorf("Stdout = %q, Stderr = %q", result.Stdout, result.Stderr)
+	}
+	if len(fixtures.Requests()) != 1 {
+		t.Errorf("Requests() = %+v, want one", fixtures.Requests())
+	}
+}
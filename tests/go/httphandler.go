@@ -0,0 +1,290 @@
+package conch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// defaultMaxRequestBytes is ExecuteHandler.MaxRequestBytes when unset.
+const defaultMaxRequestBytes = 1 << 20
+
+// maxPendingEventBytes bounds the output events ExecuteHandler has queued
+// for a client; the script of a client further behind is stopped.
+const maxPendingEventBytes = 16 << 20
+
+// ExecuteHandler is an http.Handler running the script POSTed to it as a
+// JSON object with a "script" field, and optional "stdin" and "id":
+//
+//	{"script": "grep -c error", "stdin": "...", "id": "req-42"}
+//
+// By default it replies with the whole result once the script finishes:
+//
+//	{"id": "req-42", "exit_code": 0, "stdout": "3\n", "stderr": ""}
+//
+// A request that accepts text/event-stream instead gets server-sent
+// events, as LLM frontends consume token streams: an output event for
+// each chunk the script writes, as it writes it, then a result event
+// without the output, after which the stream ends:
+//
+//	event: output
+//	data: {"stream": "stdout", "data": "3\n"}
+//
+//	event: result
+//	data: {"id": "req-42", "exit_code": 0}
+//
+// A result with an "error" field reports an execution that failed, with
+// an exit_code of -1 if the script did not run. Output is sent as JSON
+// strings, so bytes that are not UTF-8 are replaced. Events are written
+// from a goroutine of their own, so a slow client does not hold up the
+// executor; the script is stopped if the client goes away or falls 16 MiB
+// behind.
+//
+// Requests must have a Content-Type of application/json, which browsers
+// do not send across origins without asking, and pass the same Origin
+// check as WebSocketHandler, so other web pages cannot run scripts. The
+// handler does no authentication of its own: anyone who can reach it can
+// run scripts, so serve it behind authentication unless only trusted
+// clients can connect.
+type ExecuteHandler struct {
+	// Executor runs the scripts, unless Pool is set.
+	Executor *Executor
+	// Pool, if set, runs each script on an executor of its own, so
+	// concurrent requests do not share one.
+	Pool *Pool
+	// CheckOrigin reports whether a browser on the request's Origin may
+	// run scripts. If nil, only requests without an Origin, or from the
+	// same host, are accepted.
+	CheckOrigin func(r *http.Request) bool
+	// MaxRequestBytes limits the size of the request body. 0 means 1 MiB.
+	MaxRequestBytes int64
+}
+
+// executeRequest is the body of a request to ExecuteHandler.
+type executeRequest struct {
+	Script string `json:"script"`
+	Stdin  string `json:"stdin,omitempty"`
+	ID     string `json:"id,omitempty"`
+}
+
+// executeResponse is the reply of ExecuteHandler, and the data of its
+// result event without Stdout and Stderr.
+type executeResponse struct {
+	ID        string  `json:"id,omitempty"`
+	ExitCode  int     `json:"exit_code"`
+	Stdout    *string `json:"stdout,omitempty"`
+	Stderr    *string `json:"stderr,omitempty"`
+	Truncated bool    `json:"truncated,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// outputEvent is the data of an ExecuteHandler output event.
+type outputEvent struct {
+	Stream string `json:"stream"`
+	Data   string `json:"data"`
+}
+
+// ServeHTTP runs the script in r and writes its result.
+func (h *ExecuteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkOrigin(r, h.CheckOrigin) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "content type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	limit := h.MaxRequestBytes
+	if limit <= 0 {
+		limit = defaultMaxRequestBytes
+	}
+	var req executeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit)).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := ExecOptions{ID: req.ID}
+	if req.Stdin != "" {
+		opts.Stdin = []byte(req.Stdin)
+	}
+
+	if !acceptsEventStream(r) {
+		result, err := h.executor().ExecuteWithOptions(r.Context(), req.Script, opts)
+		resp := newExecuteResponse(req.ID, result, err)
+		if result != nil {
+			stdout, stderr := string(result.Stdout), string(result.Stderr)
+			resp.Stdout, resp.Stderr = &stdout, &stderr
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if result == nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	events := newEventQueue(w, cancel)
+	opts.OnOutput = func(stream Stream, chunk []byte, _ int64) {
+		events.send("output", outputEvent{Stream: stream.String(), Data: string(chunk)})
+	}
+	result, err := h.executor().ExecuteWithOptions(ctx, req.Script, opts)
+	events.finish("result", newExecuteResponse(req.ID, result, err))
+}
+
+// executor returns what runs the scripts: Pool if set, or Executor.
+func (h *ExecuteHandler) executor() optionsExecutor {
+	if h.Pool != nil {
+		return h.Pool
+	}
+	return h.Executor
+}
+
+// eventQueue writes server-sent events to a client from a goroutine of its
+// own, so the output callbacks queuing them never wait on the client.
+type eventQueue struct {
+	w       io.Writer
+	flusher http.Flusher
+	// stop stops the script, once the client falls too far behind or a
+	// write to it fails.
+	stop context.CancelFunc
+
+	mu      sync.Mutex
+	events  [][]byte
+	pending int
+	closed  bool
+	ready   chan struct{}
+	done    chan struct{}
+}
+
+// newEventQueue starts writing the events queued for w.
+func newEventQueue(w io.Writer, stop context.CancelFunc) *eventQueue {
+	q := &eventQueue{w: w, stop: stop, ready: make(chan struct{}, 1), done: make(chan struct{})}
+	q.flusher, _ = w.(http.Flusher)
+	go q.run()
+	return q
+}
+
+// send queues the event name, unless maxPendingEventBytes are queued
+// already, when it stops the script instead.
+func (q *eventQueue) send(name string, data any) {
+	q.push(name, data, maxPendingEventBytes)
+}
+
+// finish queues the last event, name, and waits until every event is
+// written.
+func (q *eventQueue) finish(name string, data any) {
+	q.push(name, data, 0)
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.wake()
+	<-q.done
+}
+
+// push queues the event name if fewer than limit bytes are queued, or
+// whatever is queued if limit is 0.
+func (q *eventQueue) push(name string, data any, limit int) {
+	var buf bytes.Buffer
+	if writeEvent(&buf, name, data) != nil {
+		return
+	}
+	q.mu.Lock()
+	if limit > 0 && q.pending+buf.Len() > limit {
+		q.mu.Unlock()
+		q.stop()
+		return
+	}
+	q.events = append(q.events, buf.Bytes())
+	q.pending += buf.Len()
+	q.mu.Unlock()
+	q.wake()
+}
+
+func (q *eventQueue) wake() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// run writes the queued events until finish, dropping them once a write
+// fails.
+func (q *eventQueue) run() {
+	defer close(q.done)
+	failed := false
+	for range q.ready {
+		q.mu.Lock()
+		events, closed := q.events, q.closed
+		q.events, q.pending = nil, 0
+		q.mu.Unlock()
+
+		for _, event := range events {
+			if failed {
+				break
+			}
+			if _, err := q.w.Write(event); err != nil {
+				failed = true
+				q.stop()
+			}
+		}
+		if !failed && len(events) > 0 && q.flusher != nil {
+			q.flusher.Flush()
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// newExecuteResponse summarizes the outcome of the execution with id,
+// without its output.
+func newExecuteResponse(id string, result *Result, err error) executeResponse {
+	resp := executeResponse{ID: id, ExitCode: -1}
+	if result != nil {
+		resp.ID, resp.ExitCode, resp.Truncated = result.ID, result.ExitCode, result.Truncated
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+// acceptsEventStream reports whether r accepts text/event-stream.
+func acceptsEventStream(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(value, ",") {
+			mediaType, _, _ := strings.Cut(mediaRange, ";")
+			if strings.EqualFold(strings.TrimSpace(mediaType), "text/event-stream") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeEvent writes a server-sent event named name with data encoded as
+// JSON, which never spans lines.
+func writeEvent(w io.Writer, name string, data any) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, encoded)
+	return err
+}
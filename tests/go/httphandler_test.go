@@ -0,0 +1,188 @@
+package conch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAcceptsEventStream(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                  false,
+		"application/json":  false,
+		"text/event-stream": true,
+		"application/json, TEXT/Event-Stream;q=0.9": true,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/execute", nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		if got := acceptsEventStream(r); got != want {
+			t.Errorf("acceptsEventStream(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestWriteEvent(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeEvent(&buf, "output", outputEvent{Stream: "stdout", Data: "a\nb\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "event: output\ndata: {\"stream\":\"stdout\",\"data\":\"a\\nb\\n\"}\n\n"; buf.String() != want {
+		t.Errorf("writeEvent() = %q, want %q", buf.String(), want)
+	}
+}
+
+// gatedWriter is a client that reads nothing until open is closed.
+type gatedWriter struct {
+	open chan struct{}
+	buf  bytes.Buffer
+}
+
+func (g *gatedWriter) Write(p []byte) (int, error) {
+	<-g.open
+	return g.buf.Write(p)
+}
+
+func TestEventQueue(t *testing.T) {
+	w := &gatedWriter{open: make(chan struct{})}
+	var stopped atomic.Bool
+	q := newEventQueue(w, func() { stopped.Store(true) })
+
+	// Sending does not wait on the client, and stops the script once it
+	// falls too far behind.
+	chunk := strings.Repeat("x", 1<<20)
+	for i := 0; i < 20; i++ {
+		q.send("output", outputEvent{Stream: "stdout", Data: chunk})
+	}
+	if !stopped.Load() {
+		t.Error("a client falling behind did not stop the script")
+	}
+	close(w.open)
+	q.finish("result", executeResponse{ExitCode: 0})
+	if !strings.HasSuffix(w.buf.String(), "event: result\ndata: {\"exit_code\":0}\n\n") {
+		t.Errorf("the result was not written last")
+	}
+	if n := strings.Count(w.buf.String(), "event: output"); n == 0 || n >= 20 {
+		t.Errorf("%d output events written, want those queued before the limit", n)
+	}
+}
+
+func TestExecuteHandlerBadRequests(t *testing.T) {
+	h := &ExecuteHandler{MaxRequestBytes: 32}
+	for _, tt := range []struct {
+		name        string
+		method      string
+		contentType string
+		origin      string
+		body        string
+		status      int
+	}{
+		{"get", http.MethodGet, "", "", "", http.StatusMethodNotAllowed},
+		{"invalid json", http.MethodPost, "application/json", "", "echo hi", http.StatusBadRequest},
+		{"too large", http.MethodPost, "application/json", "", `{"script": "` + strings.Repeat("x", 64) + `"}`, http.StatusBadRequest},
+		{"text/plain", http.MethodPost, "text/plain", "", `{"script": "id"}`, http.StatusUnsupportedMediaType},
+		{"no content type", http.MethodPost, "", "", `{"script": "id"}`, http.StatusUnsupportedMediaType},
+		{"cross origin", http.MethodPost, "application/json", "http://evil.example.org", `{"script": "id"}`, http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tt.method, "/execute", strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
+
+func TestExecuteHandlerJSON(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	w := httptest.NewRecorder()
+	body := `{"script": "cat; echo oops >&2; exit 2", "stdin": "hi\n", "id": "req-1"}`
+	r := httptest.NewRequest(http.MethodPost, "/execute", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	(&ExecuteHandler{Executor: exec}).ServeHTTP(w, r)
+
+	var resp executeResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body, err)
+	}
+	if resp.ID != "req-1" || resp.ExitCode != 2 || resp.Stdout == nil || *resp.Stdout != "hi\n" || resp.Stderr == nil || *resp.Stderr != "oops\n" {
+		t.Errorf("response = %s", w.Body)
+	}
+}
+
+func TestExecuteHandlerPool(t *testing.T) {
+	pool := NewPool(func() (ShellExecutor, error) { return daemonTestExecutor{stopped: &atomic.Int32{}}, nil }, PoolOptions{})
+	defer pool.Close()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/execute", strings.NewReader(`{"script": "cat", "stdin": "hi", "id": "req-1"}`))
+	r.Header.Set("Content-Type", "application/json")
+	(&ExecuteHandler{Pool: pool}).ServeHTTP(w, r)
+
+	var resp executeResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.ID != "req-1" || resp.Stdout == nil || *resp.Stdout != "hi" {
+		t.Errorf("response = %s, %v", w.Body, err)
+	}
+	if pool.Idle() != 1 {
+		t.Errorf("Idle() = %d, want the executor returned", pool.Idle())
+	}
+}
+
+func TestExecuteHandlerEventStream(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/execute", strings.NewReader(`{"script": "echo one; echo two >&2"}`))
+	r.Header.Set("Accept", "text/event-stream")
+	r.Header.Set("Content-Type", "application/json")
+	(&ExecuteHandler{Executor: exec}).ServeHTTP(w, r)
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var events, data []string
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		if name, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+			events = append(events, name)
+		}
+		if d, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			data = append(data, d)
+		}
+	}
+	if strings.Join(events, ",") != "output,output,result" {
+		t.Fatalf("events = %v, data = %v", events, data)
+	}
+	if data[0] != `{"stream":"stdout","data":"one\n"}` || data[1] != `{"stream":"stderr","data":"two\n"}` {
+		t.Errorf("output events = %v", data[:2])
+	}
+	var result executeResponse
+	if err := json.Unmarshal([]byte(data[2]), &result); err != nil || result.ExitCode != 0 || result.ID == "" || result.Stdout != nil {
+		t.Errorf("result event = %s, %v", data[2], err)
+	}
+}
@@ -0,0 +1,62 @@
+package conch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// JqOptions parameterizes the jq builtin for an execution, so filters can
+// take values as variables rather than having them spliced into the filter
+// text, where quoting mistakes become injection.
+type JqOptions struct {
+	// Args binds each name to its string value, as jq --arg does, so the
+	// filter can refer to $name.
+	Args map[string]string
+	// ArgsJSON binds each name to its value encoded as JSON, as jq
+	// --argjson does. Names in both maps take their value from ArgsJSON.
+	ArgsJSON map[string]any
+	// LibraryPath lists directories searched for modules the filter
+	// imports or includes, after any given to jq with -L.
+	LibraryPath []string
+}
+
+// Shell variables the jq builtin reads JqOptions from, matching
+// JQ_ARGS_VAR and JQ_LIBRARY_PATH_VAR in conch-shell. Variables given to jq
+// on the command line take precedence.
+const (
+	jqArgsVar        = "CONCH_JQ_ARGS"
+	jqLibraryPathVar = "CONCH_JQ_LIBRARY_PATH"
+)
+
+// withJqOptions returns vars with the variables for opts set, or vars
+// itself if opts is nil. The caller's map is not modified.
+func withJqOptions(vars map[string]string, opts *JqOptions) (map[string]string, error) {
+	if opts == nil {
+		return vars, nil
+	}
+	set := map[string]string{}
+	if len(opts.Args) > 0 || len(opts.ArgsJSON) > 0 {
+		args := make(map[string]any, len(opts.Args)+len(opts.ArgsJSON))
+		for name, value := range opts.Args {
+			args[name] = value
+		}
+		for name, value := range opts.ArgsJSON {
+			args[name] = value
+		}
+		data, err := json.Marshal(args)
+		if err != nil {
+			return nil, fmt.Errorf("encoding jq arguments: %w", err)
+		}
+		set[jqArgsVar] = string(data)
+	}
+	if len(opts.LibraryPath) > 0 {
+		for _, dir := range opts.LibraryPath {
+			if strings.Contains(dir, ":") {
+				return nil, fmt.Errorf("jq library path %q contains a colon", dir)
+			}
+		}
+		set[jqLibraryPathVar] = strings.Join(opts.LibraryPath, ":")
+	}
+	return overrideVars(vars, set), nil
+}
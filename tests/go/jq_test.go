@@ -0,0 +1,63 @@
+package conch
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWithJqOptions(t *testing.T) {
+	vars := map[string]string{"A": "1"}
+	got, err := withJqOptions(vars, &JqOptions{
+		Args:        map[string]string{"name": `o'brien "jr"`, "n": "shadowed"},
+		ArgsJSON:    map[string]any{"n": []int{1, 2}},
+		LibraryPath: []string{"/lib/jq", "/home/jq"},
+	})
+	if err != nil {
+		t.Fatalf("withJqOptions() error: %v", err)
+	}
+	want := map[string]string{
+		"A":              "1",
+		jqArgsVar:        `{"n":[1,2],"name":"o'brien \"jr\""}`,
+		jqLibraryPathVar: "/lib/jq:/home/jq",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("withJqOptions() = %v, want %v", got, want)
+	}
+	if len(vars) != 1 {
+		t.Error("withJqOptions() modified the caller's map")
+	}
+
+	if got, err := withJqOptions(nil, nil); got != nil || err != nil {
+		t.Errorf("withJqOptions(nil, nil) = %v, %v", got, err)
+	}
+	if _, err := withJqOptions(nil, &JqOptions{ArgsJSON: map[string]any{"f": func() {}}}); err == nil {
+		t.Error("withJqOptions() accepted a value JSON cannot encode")
+	}
+	if _, err := withJqOptions(nil, &JqOptions{LibraryPath: []string{"a:b"}}); err == nil || !strings.Contains(err.Error(), "colon") {
+		t.Errorf("withJqOptions() with a colon in a directory error = %v", err)
+	}
+}
+
+func TestExecuteJqOptions(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	opts := ExecOptions{Jq: &JqOptions{
+		Args:     map[string]string{"name": "'; rm -rf / #"},
+		ArgsJSON: map[string]any{"limit": 2},
+	}}
+	result, err := exec.ExecuteWithOptions(context.Background(), `jq -nc '[$name, $limit]'`, opts)
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+	if want := `["'; rm -rf / #",2]` + "\n"; string(result.Stdout) != want {
+		t.Errorf("Stdout = %q, want %q (stderr %q)", result.Stdout, want, result.Stderr)
+	}
+}
@@ -0,0 +1,130 @@
+package conch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// JSONError is returned by ExecuteJSON when the script fails or its stdout
+// cannot be decoded.
+type JSONError struct {
+	// Result is the execution's result.
+	Result *Result
+	// Err is why decoding failed, or nil if the script exited non-zero.
+	Err error
+	// Line and Column locate a syntax error in stdout, counting from 1, or
+	// are 0 when there is none.
+	Line   int
+	Column int
+}
+
+func (e *JSONError) Error() string {
+	if e.Err == nil {
+		msg := strings.TrimSpace(string(e.Result.Stderr))
+		if e.Result.Error != nil {
+			msg = e.Result.Error.Message
+		}
+		if msg == "" {
+			return fmt.Sprintf("script exited with status %d", e.Result.ExitCode)
+		}
+		return fmt.Sprintf("script exited with status %d: %s", e.Result.ExitCode, msg)
+	}
+	if e.Line > 0 {
+		return fmt.Sprintf("invalid JSON output at line %d, column %d: %v (output starts %s)",
+			e.Line, e.Column, e.Err, quoteStart(e.Result.Stdout))
+	}
+	return fmt.Sprintf("invalid JSON output: %v", e.Err)
+}
+
+func (e *JSONError) Unwrap() error { return e.Err }
+
+// ExecuteJSON runs script with input, encoded as JSON, as its stdin and
+// decodes its stdout as JSON into output, the common shape of jq pipelines:
+//
+//	var names []string
+//	err := exec.ExecuteJSON(`jq '[.items[].name]'`, payload, &names)
+//
+// A nil input gives the script empty stdin, and a nil output skips decoding.
+// Stdout must hold exactly one JSON value. If the script exits non-zero or
+// its output does not decode, the error is a *JSONError.
+func (e *Executor) ExecuteJSON(script string, input, output any) error {
+	return e.ExecuteJSONContext(context.Background(), script, input, output)
+}
+
+// ExecuteJSONContext is ExecuteJSON stopping the script when ctx is done.
+func (e *Executor) ExecuteJSONContext(ctx context.Context, script string, input, output any) error {
+	stdin := []byte{}
+	if input != nil {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return fmt.Errorf("encode input: %w", err)
+		}
+		stdin = append(data, '\n')
+	}
+	result, err := e.ExecuteContextWithStdin(ctx, script, stdin, e.Limits())
+	if err != nil {
+		return err
+	}
+	return decodeJSONResult(result, output)
+}
+
+// decodeJSONResult checks result and decodes its stdout into output.
+func decodeJSONResult(result *Result, output any) error {
+	if result.ExitCode != 0 {
+		return &JSONError{Result: result}
+	}
+	if output == nil {
+		return nil
+	}
+	if result.Truncated && result.StdoutTotalLen > len(result.Stdout) {
+		return &JSONError{Result: result, Err: fmt.Errorf(
+			"stdout truncated to %d of %d bytes by the output limit", len(result.Stdout), result.StdoutTotalLen)}
+	}
+	if len(bytes.TrimSpace(result.Stdout)) == 0 {
+		return &JSONError{Result: result, Err: errors.New("script wrote no output")}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(result.Stdout))
+	err := dec.Decode(output)
+	if err == nil {
+		var extra json.RawMessage
+		switch err = dec.Decode(&extra); err {
+		case io.EOF:
+			err = nil
+		case nil:
+			err = errors.New("stdout holds more than one JSON value; collect them with jq -s")
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	jerr := &JSONError{Result: result, Err: err}
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		jerr.Line, jerr.Column = position(result.Stdout, syntax.Offset)
+	}
+	return jerr
+}
+
+// position returns the line and column, counting from 1, of the byte before
+// offset in data, where encoding/json reports a syntax error.
+func position(data []byte, offset int64) (line, col int) {
+	end := min(max(int(offset)-1, 0), len(data))
+	line = 1 + bytes.Count(data[:end], []byte("\n"))
+	col = end - bytes.LastIndexByte(data[:end], '\n')
+	return line, col
+}
+
+// quoteStart quotes the beginning of data for an error message.
+func quoteStart(data []byte) string {
+	const n = 40
+	if len(data) > n {
+		return fmt.Sprintf("%q...", data[:n])
+	}
+	return fmt.Sprintf("%q", data)
+}
@@ -0,0 +1,91 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSONResult(t *testing.T) {
+	type item struct {
+		Name string `json:"name"`
+	}
+	tests := []struct {
+		name    string
+		result  Result
+		wantErr string
+		line    int
+		col     int
+	}{
+		{name: "ok", result: Result{Stdout: []byte(`{"name":"a"}` + "\n")}},
+		{name: "failed", result: Result{ExitCode: 5, Stderr: []byte("jq: error: bad filter\n")}, wantErr: "script exited with status 5: jq: error: bad filter"},
+		{name: "failed silently", result: Result{ExitCode: 1}, wantErr: "script exited with status 1"},
+		{name: "empty", result: Result{Stdout: []byte(" \n")}, wantErr: "script wrote no output"},
+		{
+			name:    "syntax",
+			result:  Result{Stdout: []byte("{\n  \"name\": oops\n}")},
+			wantErr: `invalid JSON output at line 2, column 11: invalid character 'o'`,
+			line:    2, col: 11,
+		},
+		{name: "truncated", result: Result{Stdout: []byte(`{"na`), StdoutTotalLen: 100, Truncated: true}, wantErr: "truncated to 4 of 100 bytes"},
+		{name: "type", result: Result{Stdout: []byte(`{"name": 1}`)}, wantErr: "cannot unmarshal number"},
+		{name: "two values", result: Result{Stdout: []byte(`{"name":"a"}` + "\n" + `{"name":"b"}`)}, wantErr: "more than one JSON value"},
+		{name: "trailing garbage", result: Result{Stdout: []byte(`{"name":"a"} x`)}, wantErr: "line 1, column 14", line: 1, col: 14},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got item
+			result := tt.result
+			err := decodeJSONResult(&result, &got)
+			if tt.wantErr == "" {
+				if err != nil || got.Name != "a" {
+					t.Fatalf("decodeJSONResult() = %v, decoded %+v", err, got)
+				}
+				return
+			}
+			var jerr *JSONError
+			if !errors.As(err, &jerr) {
+				t.Fatalf("decodeJSONResult() error = %v, want a *JSONError", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.wantErr)
+			}
+			if jerr.Line != tt.line || jerr.Column != tt.col {
+				t.Errorf("position = %d:%d, want %d:%d", jerr.Line, jerr.Column, tt.line, tt.col)
+			}
+		})
+	}
+
+	// A nil output only checks the exit code.
+	if err := decodeJSONResult(&Result{Stdout: []byte("not json")}, nil); err != nil {
+		t.Errorf("decodeJSONResult(nil output) error = %v", err)
+	}
+}
+
+func TestExecuteJSON(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	input := map[string]any{"items": []map[string]any{{"name": "a", "n": 1}, {"name": "b", "n": 2}}}
+	var names []string
+	if err := exec.ExecuteJSON(`jq '[.items[].name]'`, input, &names); err != nil {
+		t.Fatalf("ExecuteJSON() error: %v", err)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("names = %q", names)
+	}
+
+	var out any
+	err = exec.ExecuteJSONContext(context.Background(), `echo '{"broken": }'`, nil, &out)
+	var jerr *JSONError
+	if !errors.As(err, &jerr) || jerr.Line != 1 {
+		t.Errorf("ExecuteJSONContext(invalid output) error = %v, want a *JSONError on line 1", err)
+	}
+}
@@ -0,0 +1,114 @@
+package conch
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Labels returns a copy of the labels set with WithLabels, or nil if there
+// are none.
+func (e *Executor) Labels() map[string]string {
+	return copyLabels(e.labels)
+}
+
+// validateLabels returns an error for the first label whose name is not
+// usable. Names are letters, digits, '_', '.', '-' and '/', so they read
+// unambiguously in logs and metric systems; values may be anything without
+// a NUL byte.
+func validateLabels(labels map[string]string) error {
+	for name, value := range labels {
+		if !validLabelName(name) {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+		if strings.IndexByte(value, 0) >= 0 {
+			return fmt.Errorf("label %q contains a NUL byte", name)
+		}
+	}
+	return nil
+}
+
+// validLabelName reports whether name is a non-empty run of letters,
+// digits, '_', '.', '-' and '/'.
+func validLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '_' || c == '.' || c == '-' || c == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// formatLabels renders labels as name="value" pairs sorted by name and
+// separated by spaces, the form recorded on the native library's logs.
+func formatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(labels[name]))
+	}
+	return sb.String()
+}
+
+// copyLabels returns a copy of labels, or nil if it is empty.
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(labels))
+	for name, value := range labels {
+		c[name] = value
+	}
+	return c
+}
+
+// setLabels records labels on the native library's log of every execution
+// and keeps them for the executor's audit events and stats. It is called by
+// New, before the executor is shared.
+func (e *Executor) setLabels(labels map[string]string) error {
+	if err := validateLabels(labels); err != nil {
+		return err
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.handle == 0 {
+		return errors.New("executor is closed")
+	}
+	if err := e.lib.labels.load(); err != nil {
+		return err
+	}
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	s, err := cString(formatLabels(labels))
+	if err != nil {
+		return err
+	}
+	defer freeString(s)
+	ebuf := newErrorBuffer()
+	if e.lib.executorSetLabels(e.handle, pinBytes(&pinner, s.b), ebuf.ptr(), errorBufferSize) != 0 {
+		return fmt.Errorf("failed to set labels: %s", ebuf)
+	}
+	e.labels = copyLabels(labels)
+	return nil
+}
@@ -0,0 +1,94 @@
+package conch
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateLabels(t *testing.T) {
+	valid := map[string]string{"tenant": "acme corp", "app.kubernetes.io/team": "search-2", "x_y": ""}
+	if err := validateLabels(valid); err != nil {
+		t.Errorf("validateLabels(%v) error: %v", valid, err)
+	}
+	for _, labels := range []map[string]string{
+		{"": "x"},
+		{"has space": "x"},
+		{"a=b": "x"},
+		{"ok": "nul\x00"},
+	} {
+		if err := validateLabels(labels); err == nil {
+			t.Errorf("validateLabels(%q) succeeded", labels)
+		}
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	got := formatLabels(map[string]string{"team": "search", "tenant": `acme "corp"`})
+	if want := `team="search" tenant="acme \"corp\""`; got != want {
+		t.Errorf("formatLabels() = %s, want %s", got, want)
+	}
+	if got := formatLabels(nil); got != "" {
+		t.Errorf("formatLabels(nil) = %q", got)
+	}
+}
+
+func TestWithLabels(t *testing.T) {
+	var cfg Config
+	WithLabels(map[string]string{"team": "a", "tenant": "x"}).apply(&cfg)
+	WithLabels(map[string]string{"team": "b"}).apply(&cfg)
+	if want := map[string]string{"team": "b", "tenant": "x"}; !reflect.DeepEqual(cfg.Labels, want) {
+		t.Errorf("Labels = %v, want %v", cfg.Labels, want)
+	}
+}
+
+func TestExecutorStatsCounts(t *testing.T) {
+	e := &Executor{labels: map[string]string{"team": "search"}}
+	a := e.startAudit("", "true", nil)
+	if s := e.Stats(); s.Executions != 1 || s.Running != 1 {
+		t.Errorf("during execution, Stats() = %+v", s)
+	}
+	a.finish(&Result{ExitCode: 1}, nil)
+	e.startAudit("", "true", nil).finish(nil, errors.New("denied"))
+
+	s := e.Stats()
+	if s.Executions != 2 || s.Running != 0 || s.Failed != 1 {
+		t.Errorf("Stats() = %+v, want 2 executions, 0 running, 1 failed", s)
+	}
+	// The labels returned are a copy.
+	s.Labels["team"] = "other"
+	if e.Labels()["team"] != "search" {
+		t.Errorf("Labels() = %v after modifying Stats().Labels", e.Labels())
+	}
+}
+
+func TestLabels(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	rec := &auditRecorder{}
+	labels := map[string]string{"tenant": "acme", "team": "search"}
+	exec, err := New(WithEmbedded(), WithLabels(labels), WithAuditSink(rec))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	if got := exec.Labels(); !reflect.DeepEqual(got, labels) {
+		t.Errorf("Labels() = %v, want %v", got, labels)
+	}
+	if _, err := exec.Execute("echo hi"); err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	for _, e := range rec.get() {
+		if !reflect.DeepEqual(e.Labels, labels) {
+			t.Errorf("%s event labels = %v, want %v", e.Type, e.Labels, labels)
+		}
+	}
+	if s := exec.Stats(); s.Executions != 1 || !reflect.DeepEqual(s.Labels, labels) {
+		t.Errorf("Stats() = %+v", s)
+	}
+
+	if _, err := New(WithEmbedded(), WithLabels(map[string]string{"bad name": "x"})); err == nil {
+		t.Error("New() with an invalid label name succeeded")
+	}
+}
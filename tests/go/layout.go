@@ -0,0 +1,87 @@
+package conch
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// ErrResultLayout is wrapped by the error returned when ConchResult does not
+// match the layout of the loaded library's result struct, usually because
+// the library and these bindings come from different releases.
+var ErrResultLayout = errors.New("ConchResult layout does not match the library")
+
+// resultLayoutField is one entry of the layout reported by
+// conch_result_layout: the struct's size, then each field's offset, then
+// the size of ConchDiagnostic.
+type resultLayoutField struct {
+	name string
+	want uintptr
+}
+
+// goResultLayout returns the layout of ConchResult in the order
+// conch_result_layout reports it, named after the Rust fields.
+func goResultLayout() []resultLayoutField {
+	var r ConchResult
+	return []resultLayoutField{
+		{"size", unsafe.Sizeof(r)},
+		{"exit_code", unsafe.Offsetof(r.ExitCode)},
+		{"stdout_data", unsafe.Offsetof(r.StdoutData)},
+		{"stdout_len", unsafe.Offsetof(r.StdoutLen)},
+		{"stderr_data", unsafe.Offsetof(r.StderrData)},
+		{"stderr_len", unsafe.Offsetof(r.StderrLen)},
+		{"truncated", unsafe.Offsetof(r.Truncated)},
+		{"traps", unsafe.Offsetof(r.Traps)},
+		{"compressed", unsafe.Offsetof(r.Compressed)},
+		{"stdout_total_len", unsafe.Offsetof(r.StdoutTotalLen)},
+		{"stderr_total_len", unsafe.Offsetof(r.StderrTotalLen)},
+		{"compile_ns", unsafe.Offsetof(r.CompileNs)},
+		{"instantiate_ns", unsafe.Offsetof(r.InstantiateNs)},
+		{"execute_ns", unsafe.Offsetof(r.ExecuteNs)},
+		{"marshal_ns", unsafe.Offsetof(r.MarshalNs)},
+		{"diagnostics", unsafe.Offsetof(r.Diagnostics)},
+		{"diagnostics_len", unsafe.Offsetof(r.DiagnosticsLen)},
+		{"exceeded", unsafe.Offsetof(r.Exceeded)},
+		{"diagnostic_size", unsafe.Sizeof(conchDiagnostic{})},
+	}
+}
+
+// checkResultLayout compares ConchResult with the library's layout. A
+// library too old to report its layout predates the current ConchResult,
+// so it is rejected too.
+func (l *library) checkResultLayout() error {
+	if l.layout.load() != nil {
+		return fmt.Errorf("%w: library does not report its layout", ErrResultLayout)
+	}
+	var native [32]uintptr
+	n := l.resultLayout(uintptr(unsafe.Pointer(&native[0])), uintptr(len(native)))
+	if n > uintptr(len(native)) {
+		return fmt.Errorf("%w: library reports %d layout entries, bindings have %d", ErrResultLayout, n, len(goResultLayout()))
+	}
+	return compareResultLayout(native[:n])
+}
+
+// compareResultLayout describes every difference between native, as
+// reported by conch_result_layout, and ConchResult.
+func compareResultLayout(native []uintptr) error {
+	fields := goResultLayout()
+	if len(native) != len(fields) {
+		return fmt.Errorf("%w: library reports %d layout entries, bindings have %d", ErrResultLayout, len(native), len(fields))
+	}
+	var diffs []string
+	for i, f := range fields {
+		if native[i] == f.want {
+			continue
+		}
+		if strings.HasSuffix(f.name, "size") {
+			diffs = append(diffs, fmt.Sprintf("%s is %d bytes in the library, %d in Go", f.name, native[i], f.want))
+		} else {
+			diffs = append(diffs, fmt.Sprintf("%s is at offset %d in the library, %d in Go", f.name, native[i], f.want))
+		}
+	}
+	if diffs != nil {
+		return fmt.Errorf("%w: %s", ErrResultLayout, strings.Join(diffs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package conch
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompareResultLayout(t *testing.T) {
+	fields := goResultLayout()
+	native := make([]uintptr, len(fields))
+	for i, f := range fields {
+		native[i] = f.want
+	}
+	if err := compareResultLayout(native); err != nil {
+		t.Fatalf("compareResultLayout(matching) error: %v", err)
+	}
+
+	moved := append([]uintptr(nil), native...)
+	moved[0] += 8
+	moved[len(moved)-2] += 8
+	moved[len(moved)-1] += 8
+	err := compareResultLayout(moved)
+	if !errors.Is(err, ErrResultLayout) {
+		t.Fatalf("compareResultLayout(moved) error = %v, want ErrResultLayout", err)
+	}
+	for _, want := range []string{"size is", "exceeded is at offset", "diagnostic_size is"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	if err := compareResultLayout(native[:len(native)-1]); !errors.Is(err, ErrResultLayout) {
+		t.Errorf("compareResultLayout(short) error = %v, want ErrResultLayout", err)
+	}
+}
+
+func TestLibraryResultLayout(t *testing.T) {
+	l, err := acquireLibrary()
+	if err != nil {
+		t.Skip("Skipping: conch library not available")
+	}
+	defer l.release()
+
+	if err := l.checkResultLayout(); err != nil {
+		t.Errorf("checkResultLayout() error: %v", err)
+	}
+}
+
+func TestCheckResultLayoutUnreported(t *testing.T) {
+	l := &library{}
+	l.layout.once.Do(func() { l.layout.err = ErrUnsupportedByLibrary{Symbol: "conch_result_layout"} })
+
+	err := l.checkResultLayout()
+	if !errors.Is(err, ErrResultLayout) || !strings.Contains(err.Error(), "does not report its layout") {
+		t.Errorf("checkResultLayout() error = %v, want ErrResultLayout", err)
+	}
+}
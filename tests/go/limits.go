@@ -0,0 +1,121 @@
+package conch
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// CONCH_LIMIT_* bits of conchLimits.exceeded and ConchResult.Exceeded.
+const (
+	// limitCallDepth is set when shell functions nested deeper than
+	// MaxCallDepth.
+	limitCallDepth = 1
+	// limitTimeout is set when the execution ran past TimeoutMs.
+	limitTimeout = 2
+	// limitFS is set when a write was refused for going past MaxFSBytes or
+	// TmpConfig.MaxBytes.
+	limitFS = 4
+	// limitScript is set when the script was longer than MaxScriptBytes.
+	limitScript = 8
+)
+
+// conchLimits mirrors ConchLimits, the limits the conch_execute_*_v2
+// exports take and report the exceeded limit of a failed execution in.
+type conchLimits struct {
+	size           uintptr
+	exceeded       uint32
+	maxCPUMs       uint64
+	maxMemoryBytes uint64
+	maxOutputBytes uint64
+	timeoutMs      uint64
+	maxCallDepth   uint32
+	maxFSBytes     uint64
+	maxScriptBytes uint64
+	// builtinTimeoutMs is ExecOptions.BuiltinTimeout, which executions
+	// without options leave unset.
+	builtinTimeoutMs uint64
+	// The regex limits are ExecOptions.RegexLimits; see setRegexLimits.
+	regexMaxPatternBytes  uint64
+	regexMaxCompiledBytes uint64
+	regexMaxNesting       uint32
+}
+
+// nativeLimits returns limits as the native side takes them.
+func nativeLimits(limits ResourceLimits) *conchLimits {
+	return &conchLimits{
+		size:           unsafe.Sizeof(conchLimits{}),
+		maxCPUMs:       limits.MaxCPUMs,
+		maxMemoryBytes: limits.MaxMemoryBytes,
+		maxOutputBytes: limits.MaxOutputBytes,
+		timeoutMs:      limits.TimeoutMs,
+		maxCallDepth:   limits.MaxCallDepth,
+		maxFSBytes:     limits.MaxFSBytes,
+		maxScriptBytes: limits.MaxScriptBytes,
+	}
+}
+
+// failedError wraps msg, the native error of an execution that failed with
+// limits, as a Go error.
+//
+// Libraries without the v2 exports do not report the limit an execution
+// exceeded, so their message is matched instead.
+func (c *conchLimits) failedError(l *library, msg string) error {
+	if l.limitsV2.load() != nil {
+		return failedError(msg)
+	}
+	switch {
+	case c.exceeded&limitCallDepth != 0:
+		return fmt.Errorf("execution failed: %w", ErrDepthExceeded)
+	case c.exceeded&limitTimeout != 0:
+		return fmt.Errorf("execution failed: %w", ErrTimeout)
+	case c.exceeded&limitScript != 0:
+		return fmt.Errorf("execution failed: %w", ErrScriptTooLarge)
+	}
+	return fmt.Errorf("execution failed: %s", msg)
+}
+
+// executeWithLimitsCompat calls conch_execute_with_limits_v2, or
+// conch_execute_with_limits for libraries without it, which ignore
+// MaxCallDepth and MaxFSBytes.
+func (l *library) executeWithLimitsCompat(handle, script uintptr, limits *conchLimits, ebuf *byte, n uintptr) uintptr {
+	if l.limitsV2.load() != nil {
+		return l.executeWithLimits(handle, script, limits.maxCPUMs, limits.maxMemoryBytes, limits.maxOutputBytes, limits.timeoutMs, ebuf, n)
+	}
+	return l.executeWithLimitsV2(handle, script, limits, ebuf, n)
+}
+
+// executeInterruptibleCompat calls conch_execute_interruptible_v2, or
+// conch_execute_interruptible for libraries without it.
+func (l *library) executeInterruptibleCompat(handle, script uintptr, limits *conchLimits, interrupt uintptr, ebuf *byte, n uintptr) uintptr {
+	if l.limitsV2.load() != nil {
+		return l.executeInterruptible(handle, script, limits.maxCPUMs, limits.maxMemoryBytes, limits.maxOutputBytes, limits.timeoutMs, interrupt, ebuf, n)
+	}
+	return l.executeInterruptibleV2(handle, script, limits, interrupt, ebuf, n)
+}
+
+// executeWithStdinCompat calls conch_execute_with_stdin_v2, or
+// conch_execute_with_stdin for libraries without it.
+func (l *library) executeWithStdinCompat(handle, script, stdin, stdinLen uintptr, limits *conchLimits, interrupt uintptr, ebuf *byte, n uintptr) uintptr {
+	if l.limitsV2.load() != nil {
+		return l.executeWithStdin(handle, script, stdin, stdinLen, limits.maxCPUMs, limits.maxMemoryBytes, limits.maxOutputBytes, limits.timeoutMs, interrupt, ebuf, n)
+	}
+	return l.executeWithStdinV2(handle, script, stdin, stdinLen, limits, interrupt, ebuf, n)
+}
+
+// executeWithTerminalCompat calls conch_execute_with_terminal_v2, or
+// conch_execute_with_terminal for libraries without it.
+func (l *library) executeWithTerminalCompat(handle, script, stdin, stdinLen, terminal uintptr, limits *conchLimits, interrupt uintptr, ebuf *byte, n uintptr) uintptr {
+	if l.limitsV2.load() != nil {
+		return l.executeWithTerminal(handle, script, stdin, stdinLen, terminal, limits.maxCPUMs, limits.maxMemoryBytes, limits.maxOutputBytes, limits.timeoutMs, interrupt, ebuf, n)
+	}
+	return l.executeWithTerminalV2(handle, script, stdin, stdinLen, terminal, limits, interrupt, ebuf, n)
+}
+
+// executeStreamingCompat calls conch_execute_streaming_v2, or
+// conch_execute_streaming for libraries without it.
+func (l *library) executeStreamingCompat(handle, script, read, write, userData uintptr, limits *conchLimits, interrupt uintptr, ebuf *byte, n uintptr) uintptr {
+	if l.limitsV2.load() != nil {
+		return l.executeStreaming(handle, script, read, write, userData, limits.maxCPUMs, limits.maxMemoryBytes, limits.maxOutputBytes, limits.timeoutMs, interrupt, ebuf, n)
+	}
+	return l.executeStreamingV2(handle, script, read, write, userData, limits, interrupt, ebuf, n)
+}
@@ -0,0 +1,65 @@
+package conch
+
+import (
+	"errors"
+	"testing"
+	"unsafe"
+)
+
+func TestConchLimitsLayout(t *testing.T) {
+	// ConchLimits as the library declares it.
+	if got := unsafe.Offsetof(conchLimits{}.maxCPUMs); got != 16 {
+		t.Errorf("maxCPUMs offset = %d, want 16", got)
+	}
+	if got := unsafe.Offsetof(conchLimits{}.maxCallDepth); got != 48 {
+		t.Errorf("maxCallDepth offset = %d, want 48", got)
+	}
+	if got := unsafe.Offsetof(conchLimits{}.maxFSBytes); got != 56 {
+		t.Errorf("maxFSBytes offset = %d, want 56", got)
+	}
+	if got := unsafe.Offsetof(conchLimits{}.maxScriptBytes); got != 64 {
+		t.Errorf("maxScriptBytes offset = %d, want 64", got)
+	}
+	if got := unsafe.Offsetof(conchLimits{}.builtinTimeoutMs); got != 72 {
+		t.Errorf("builtinTimeoutMs offset = %d, want 72", got)
+	}
+	if got := unsafe.Offsetof(conchLimits{}.regexMaxPatternBytes); got != 80 {
+		t.Errorf("regexMaxPatternBytes offset = %d, want 80", got)
+	}
+	if got := unsafe.Offsetof(conchLimits{}.regexMaxNesting); got != 96 {
+		t.Errorf("regexMaxNesting offset = %d, want 96", got)
+	}
+	if got := unsafe.Sizeof(conchLimits{}); got != 104 {
+		t.Errorf("size = %d, want 104", got)
+	}
+}
+
+func TestConchLimitsFailedError(t *testing.T) {
+	l, err := acquireLibrary()
+	if err != nil {
+		t.Skip("Skipping: conch library not available")
+	}
+	defer l.release()
+	if err := l.limitsV2.load(); err != nil {
+		t.Skipf("Skipping: %v", err)
+	}
+
+	native := nativeLimits(DefaultLimits())
+	native.exceeded = limitCallDepth
+	if err := native.failedError(l, "execution failed"); !errors.Is(err, ErrDepthExceeded) {
+		t.Errorf("failedError(exceeded) = %v, want ErrDepthExceeded", err)
+	}
+	native.exceeded = limitTimeout
+	if err := native.failedError(l, "execution failed"); !errors.Is(err, ErrTimeout) {
+		t.Errorf("failedError(timeout) = %v, want ErrTimeout", err)
+	}
+	native.exceeded = limitScript
+	if err := native.failedError(l, "execution failed"); !errors.Is(err, ErrScriptTooLarge) {
+		t.Errorf("failedError(script) = %v, want ErrScriptTooLarge", err)
+	}
+	// The message alone does not decide the limit.
+	native.exceeded = 0
+	if err := native.failedError(l, ErrDepthExceeded.Error()); errors.Is(err, ErrDepthExceeded) {
+		t.Errorf("failedError(message) = %v, want no ErrDepthExceeded", err)
+	}
+}
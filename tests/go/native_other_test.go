@@ -0,0 +1,20 @@
+//go:build !unix
+
+package conch
+
+import (
+	"runtime"
+	"testing"
+)
+
+// nativeBytes returns the address of a pinned copy of b (0 for empty b).
+// Without mmap the copy lives on the Go heap, so checkptr builds will reject
+// tests that use it.
+func nativeBytes(t *testing.T, b []byte) uintptr {
+	t.Helper()
+
+	var pinner runtime.Pinner
+	t.Cleanup(pinner.Unpin)
+
+	return pinBytes(&pinner, append([]byte(nil), b...))
+}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package conch
+
+import (
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// nativeBytes copies b into memory outside the Go heap, freed when the test
+// ends, and returns its address (0 for empty b). This stands in for buffers
+// handed out by the native library so checkptr accepts the conversions.
+func nativeBytes(t *testing.T, b []byte) uintptr {
+	t.Helper()
+	if len(b) == 0 {
+		return 0
+	}
+
+	mem, err := syscall.Mmap(-1, 0, len(b), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		t.Fatalf("mmap: %v", err)
+	}
+	t.Cleanup(func() { _ = syscall.Munmap(mem) })
+
+	copy(mem, b)
+	return uintptr(unsafe.Pointer(&mem[0]))
+}
@@ -0,0 +1,445 @@
+package conch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// ErrLineTooLong is returned by ExecuteNDJSON when the script writes an
+// output line longer than the MaxOutputBytes limit.
+var ErrLineTooLong = errors.New("output line exceeds output limit")
+
+var (
+	// streamReadCallback and streamWriteCallback are the C entry points for
+	// every streaming execution; purego callbacks are never freed, so they
+	// are created once.
+	streamCallbacksOnce sync.Once
+	streamReadCallback  uintptr
+	streamWriteCallback uintptr
+
+	// ndjsonStreams maps the user_data passed to the native library to the
+	// execution it belongs to.
+	ndjsonStreamsMu sync.RWMutex
+	ndjsonStreams   = map[uintptr]*ndjsonStream{}
+	nextStreamID    uintptr
+)
+
+// ExecuteNDJSON runs script as a streaming record processor with default
+// resource limits. See ExecuteNDJSONWithLimits.
+func (e *Executor) ExecuteNDJSON(ctx context.Context, script string, in <-chan []byte, out chan<- []byte) (*Result, error) {
+	return e.ExecuteNDJSONWithLimits(ctx, script, e.Limits(), in, out)
+}
+
+// ExecuteNDJSONWithLimits runs script as a streaming record processor: each
+// record received from in is fed to the script's stdin as one line, and each
+// line the script writes to stdout is sent to out, without its newline, as
+// soon as it is produced. Records should not contain newlines; a single
+// trailing newline is accepted. A nil in gives the script empty stdin.
+//
+// Both directions apply backpressure: the script blocks reading stdin until a
+// record arrives, and blocks writing stdout once the internal buffer fills
+// while out is not being drained. The script sees EOF once in is closed.
+//
+// out is closed when the execution finishes. The returned Result carries the
+// exit code and stderr; its Stdout is always empty. limits.MaxOutputBytes
+// bounds stderr and the length of a single output line; a longer line stops
+// the stream and returns ErrLineTooLong. When ctx is done the script is
+// stopped and the returned error wraps ctx.Err().
+func (e *Executor) ExecuteNDJSONWithLimits(ctx context.Context, script string, limits ResourceLimits, in <-chan []byte, out chan<- []byte) (result *Result, err error) {
+	if out == nil {
+		return nil, errors.New("out channel is nil")
+	}
+	defer close(out)
+	return e.executeStream(ctx, script, limits, newNDJSONStream(ctx, in, out, limits.MaxOutputBytes))
+}
+
+// ExecuteNDJSONFromReader runs script as ExecuteNDJSON does, but with r
+// copied to its stdin as is, unframed, so input need not be split into
+// records. It suits a large JSON document processed as it arrives with
+// "jq -c --stream", whose events each reach out as a line. A read error
+// other than io.EOF ends the script's input and is returned, wrapped, with
+// the result. A read that blocks is not interrupted when ctx is done.
+func (e *Executor) ExecuteNDJSONFromReader(ctx context.Context, script string, r io.Reader, out chan<- []byte) (*Result, error) {
+	if out == nil {
+		return nil, errors.New("out channel is nil")
+	}
+	defer close(out)
+	if r == nil {
+		return nil, errors.New("reader is nil")
+	}
+	limits := e.Limits()
+	s := newNDJSONStream(ctx, nil, out, limits.MaxOutputBytes)
+	s.reader, s.inClosed = r, false
+	result, err := e.executeStream(ctx, script, limits, s)
+	if readErr := s.readError(); err == nil && readErr != nil {
+		err = fmt.Errorf("reading stdin: %w", readErr)
+	}
+	return result, err
+}
+
+// executeFromReader runs script with r copied to its stdin as it is read,
+// as ExecuteNDJSONFromReader does, passing both streams to onOutput as
+// they are written rather than framing stdout as lines. Neither stream is
+// kept in the Result.
+func (e *Executor) executeFromReader(ctx context.Context, script string, r io.Reader, onOutput OutputFunc) (*Result, error) {
+	limits := e.Limits()
+	s := newNDJSONStream(ctx, nil, nil, limits.MaxOutputBytes)
+	s.reader, s.inClosed = r, false
+	s.onOutput = onOutput
+	result, err := e.executeStream(ctx, script, limits, s)
+	if readErr := s.readError(); err == nil && readErr != nil {
+		err = fmt.Errorf("reading stdin: %w", readErr)
+	}
+	if result != nil {
+		result.Stderr = nil
+	}
+	return result, err
+}
+
+// executeStream runs script through the streaming entry point with s
+// supplying stdin and receiving stdout.
+func (e *Executor) executeStream(ctx context.Context, script string, limits ResourceLimits, s *ndjsonStream) (result *Result, err error) {
+	execID := newExecutionID()
+	audit := e.startAudit(execID, script, nil)
+	defer func() { audit.finish(result, err) }()
+
+	if err := e.checkReentrant(ctx); err != nil {
+		return nil, err
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.handle == 0 {
+		return nil, errors.New("executor is closed")
+	}
+	l := e.lib
+	if err := l.interrupt.load(); err != nil {
+		return nil, err
+	}
+	if err := l.streaming.load(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("execution interrupted: %w", err)
+	}
+	if err := checkScriptSize(script, limits); err != nil {
+		return nil, err
+	}
+	if err := validateUTF8(script); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	if err := e.checkAllowed(script); err != nil {
+		return nil, err
+	}
+	if err := e.checkPolicy(ctx, script); err != nil {
+		return nil, err
+	}
+
+	cScript, err := cString(script)
+	if err != nil {
+		return nil, err
+	}
+	defer freeString(cScript)
+	prepare := time.Since(start)
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	scriptPtr := pinBytes(&pinner, cScript.b)
+
+	id := registerStream(s)
+	defer releaseStream(id, s)
+
+	interrupt := l.interruptNew()
+	defer l.interruptFree(interrupt)
+
+	if err := setExecutionID(l, interrupt, execID); err != nil {
+		return nil, err
+	}
+	defer trackExecution(ctx, interrupt, execID)()
+	outputPanic := func() *CallbackPanicError { return nil }
+	if s.onOutput != nil {
+		// stdout reaches s; the callback set here sees stderr.
+		s.onOutput, outputPanic = guardOutput(l, interrupt, s.onOutput)
+		release, err := setOutput(l, interrupt, s.onOutput)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	ctx, stopWatchdog := e.startWatchdog(ctx, l, interrupt, execID)
+	defer stopWatchdog()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go watchContext(ctx, l, e.handle, interrupt, done, stopped)
+
+	ebuf := newErrorBuffer()
+
+	native := nativeLimits(limits)
+	var resultPtr uintptr
+	e.onThread(func() {
+		resultPtr = l.executeStreamingCompat(
+			e.handle,
+			scriptPtr,
+			streamReadCallback,
+			streamWriteCallback,
+			id,
+			native,
+			interrupt,
+			ebuf.ptr(),
+			errorBufferSize,
+		)
+	})
+	close(done)
+	<-stopped
+
+	if perr := outputPanic(); perr != nil {
+		if resultPtr != 0 {
+			l.freeResult(resultPtr)
+		}
+		return nil, perr
+	}
+	if resultPtr == 0 {
+		return nil, stoppedError(ctx, l, interrupt, execID, native.failedError(l, ebuf.String()))
+	}
+	result, err = takeResult(l, resultPtr, false)
+	if err != nil {
+		return nil, err
+	}
+	result.ID = execID
+	result.Timings.Prepare = prepare
+
+	if s.err != nil {
+		result.Truncated = true
+		return result, s.err
+	}
+	s.flush()
+	if err := ctx.Err(); err != nil {
+		return result, fmt.Errorf("execution interrupted: %w", err)
+	}
+	return result, nil
+}
+
+// registerStream makes s reachable from the native callbacks and returns
+// its id.
+func registerStream(s *ndjsonStream) uintptr {
+	streamCallbacksOnce.Do(func() {
+		streamReadCallback = purego.NewCallback(runStreamRead)
+		streamWriteCallback = purego.NewCallback(runStreamWrite)
+	})
+
+	ndjsonStreamsMu.Lock()
+	defer ndjsonStreamsMu.Unlock()
+	nextStreamID++
+	ndjsonStreams[nextStreamID] = s
+	return nextStreamID
+}
+
+// releaseStream forgets the stream registered under id. The native stdin
+// pump may outlive the execution, so s is also marked finished to release a
+// read waiting for input.
+func releaseStream(id uintptr, s *ndjsonStream) {
+	close(s.finished)
+	ndjsonStreamsMu.Lock()
+	delete(ndjsonStreams, id)
+	ndjsonStreamsMu.Unlock()
+}
+
+func lookupStream(id uintptr) *ndjsonStream {
+	ndjsonStreamsMu.RLock()
+	defer ndjsonStreamsMu.RUnlock()
+	return ndjsonStreams[id]
+}
+
+// runStreamRead implements ConchReadCallback.
+func runStreamRead(id, buf, capacity uintptr) uintptr {
+	s := lookupStream(id)
+	if s == nil || capacity == 0 {
+		return 0
+	}
+	return uintptr(s.read(unsafe.Slice((*byte)(unsafe.Pointer(buf)), capacity)))
+}
+
+// runStreamWrite implements ConchWriteCallback.
+//
+// A panic, such as a send on an out channel the caller closed, stops the
+// stream and becomes its error.
+func runStreamWrite(id, data, length uintptr) uintptr {
+	s := lookupStream(id)
+	if s == nil {
+		return 1
+	}
+	ok := false
+	if perr := protect("NDJSON output", func() { ok = s.write(goBytes(data, int(length))) }); perr != nil {
+		s.err = perr
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+// ndjsonStream frames records as lines in both directions. read is only
+// called from the native stdin pump and write from the stdout pump, so each
+// side's state is touched by one thread at a time.
+type ndjsonStream struct {
+	ctx      context.Context
+	in       <-chan []byte
+	out      chan<- []byte
+	maxLine  uint64
+	finished chan struct{}
+
+	// Input side: the unread rest of the current record's line.
+	pending  []byte
+	inClosed bool
+	// reader, if set, is read from as is instead of in; readErr records
+	// a failed read, and may be set after the execution finishes.
+	reader    io.Reader
+	readErrMu sync.Mutex
+	readErr   error
+
+	// Output side: bytes written since the last newline.
+	partial []byte
+	err     error
+	// onOutput, if set, receives stdout as it is written instead of out;
+	// written is how much it has received.
+	onOutput OutputFunc
+	written  int64
+}
+
+func newNDJSONStream(ctx context.Context, in <-chan []byte, out chan<- []byte, maxLine uint64) *ndjsonStream {
+	return &ndjsonStream{
+		ctx:      ctx,
+		in:       in,
+		out:      out,
+		maxLine:  maxLine,
+		finished: make(chan struct{}),
+		inClosed: in == nil,
+	}
+}
+
+// read fills buf with the next input bytes, blocking until a record
+// arrives. It returns 0 at end of input.
+func (s *ndjsonStream) read(buf []byte) int {
+	if s.reader != nil {
+		return s.readRaw(buf)
+	}
+	for len(s.pending) == 0 {
+		if s.inClosed {
+			return 0
+		}
+		select {
+		case rec, ok := <-s.in:
+			if !ok {
+				s.inClosed = true
+				return 0
+			}
+			rec = bytes.TrimSuffix(rec, []byte("\n"))
+			s.pending = append(append(make([]byte, 0, len(rec)+1), rec...), '\n')
+		case <-s.ctx.Done():
+			s.inClosed = true
+			return 0
+		case <-s.finished:
+			s.inClosed = true
+			return 0
+		}
+	}
+	n := copy(buf, s.pending)
+	s.pending = s.pending[n:]
+	return n
+}
+
+// readRaw fills buf from s.reader. It returns 0 at end of input, once the
+// reader fails, or once the execution has finished.
+func (s *ndjsonStream) readRaw(buf []byte) int {
+	for !s.inClosed {
+		select {
+		case <-s.finished:
+			s.inClosed = true
+			return 0
+		default:
+		}
+		n, err := s.reader.Read(buf)
+		if n > 0 {
+			return n
+		}
+		if err != nil {
+			s.inClosed = true
+			if err != io.EOF {
+				s.readErrMu.Lock()
+				s.readErr = err
+				s.readErrMu.Unlock()
+			}
+		}
+	}
+	return 0
+}
+
+// readError returns the error that ended reading from s.reader, if any.
+func (s *ndjsonStream) readError() error {
+	s.readErrMu.Lock()
+	defer s.readErrMu.Unlock()
+	return s.readErr
+}
+
+// write splits data into lines and sends each complete one to out, or
+// passes it to onOutput. It reports false once the stream should stop.
+func (s *ndjsonStream) write(data []byte) bool {
+	if s.err != nil {
+		return false
+	}
+	if s.onOutput != nil {
+		s.onOutput(Stdout, data, s.written)
+		s.written += int64(len(data))
+		return true
+	}
+	s.partial = append(s.partial, data...)
+	for {
+		i := bytes.IndexByte(s.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.Clone(s.partial[:i])
+		s.partial = s.partial[i+1:]
+		if !s.emit(line) {
+			return false
+		}
+	}
+	if s.maxLine > 0 && uint64(len(s.partial)) > s.maxLine {
+		s.err = ErrLineTooLong
+		return false
+	}
+	// Move the incomplete line to the front so the buffer does not grow
+	// with the total output.
+	s.partial = append(s.partial[:0:0], s.partial...)
+	return true
+}
+
+// flush sends a final line that has no trailing newline.
+func (s *ndjsonStream) flush() {
+	if len(s.partial) > 0 {
+		s.emit(s.partial)
+		s.partial = nil
+	}
+}
+
+// emit sends line to out unless ctx is done first.
+func (s *ndjsonStream) emit(line []byte) bool {
+	select {
+	case s.out <- line:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,192 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestNDJSONStreamRead(t *testing.T) {
+	in := make(chan []byte, 2)
+	in <- []byte(`{"a":1}`)
+	in <- []byte("{\"b\":2}\n")
+	close(in)
+	s := newNDJSONStream(context.Background(), in, nil, 0)
+
+	var got []byte
+	buf := make([]byte, 3)
+	for {
+		n := s.read(buf)
+		if n == 0 {
+			break
+		}
+		got = append(got, buf[:n]...)
+	}
+	if want := "{\"a\":1}\n{\"b\":2}\n"; string(got) != want {
+		t.Errorf("read = %q, want %q", got, want)
+	}
+}
+
+func TestNDJSONStreamReadStops(t *testing.T) {
+	s := newNDJSONStream(context.Background(), nil, nil, 0)
+	if n := s.read(make([]byte, 8)); n != 0 {
+		t.Errorf("read with nil in = %d, want 0", n)
+	}
+
+	s = newNDJSONStream(context.Background(), make(chan []byte), nil, 0)
+	go close(s.finished)
+	if n := s.read(make([]byte, 8)); n != 0 {
+		t.Errorf("read after finish = %d, want 0", n)
+	}
+}
+
+func TestNDJSONStreamReadRaw(t *testing.T) {
+	s := newNDJSONStream(context.Background(), nil, nil, 0)
+	s.reader, s.inClosed = iotest.OneByteReader(strings.NewReader(`{"a":`+"\n"+`[1]}`)), false
+
+	got, err := io.ReadAll(readerFunc(s.read))
+	if err != nil || string(got) != "{\"a\":\n[1]}" {
+		t.Errorf("read = %q, %v", got, err)
+	}
+	if err := s.readError(); err != nil {
+		t.Errorf("readError() = %v after EOF", err)
+	}
+
+	boom := errors.New("boom")
+	s = newNDJSONStream(context.Background(), nil, nil, 0)
+	s.reader, s.inClosed = iotest.ErrReader(boom), false
+	if n := s.read(make([]byte, 8)); n != 0 || s.readError() != boom {
+		t.Errorf("read of a failing reader = %d, readError() = %v", n, s.readError())
+	}
+}
+
+// readerFunc adapts an ndjsonStream read to io.Reader.
+type readerFunc func([]byte) int
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	if n := f(p); n > 0 {
+		return n, nil
+	}
+	return 0, io.EOF
+}
+
+func TestNDJSONStreamWrite(t *testing.T) {
+	out := make(chan []byte, 10)
+	s := newNDJSONStream(context.Background(), nil, out, 0)
+
+	for _, chunk := range []string{"one\ntw", "o\n", "", "thr", "ee\nfour"} {
+		if !s.write([]byte(chunk)) {
+			t.Fatalf("write(%q) = false", chunk)
+		}
+	}
+	s.flush()
+	close(out)
+
+	var lines []string
+	for line := range out {
+		lines = append(lines, string(line))
+	}
+	if got := fmt.Sprint(lines); got != "[one two three four]" {
+		t.Errorf("lines = %s", got)
+	}
+}
+
+func TestNDJSONStreamWriteBackpressure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan []byte)
+	s := newNDJSONStream(ctx, nil, out, 0)
+
+	wrote := make(chan bool)
+	go func() { wrote <- s.write([]byte("a\nb\n")) }()
+
+	if got := <-out; string(got) != "a" {
+		t.Fatalf("first line = %q", got)
+	}
+	select {
+	case <-wrote:
+		t.Fatal("write returned before its second line was received")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel()
+	if <-wrote {
+		t.Error("write = true after cancel, want false")
+	}
+}
+
+func TestNDJSONStreamLineTooLong(t *testing.T) {
+	s := newNDJSONStream(context.Background(), nil, make(chan []byte, 1), 4)
+	if !s.write([]byte("ok\n1234")) {
+		t.Fatal("write within limit = false")
+	}
+	if s.write([]byte("5")) {
+		t.Fatal("write past limit = true")
+	}
+	if !errors.Is(s.err, ErrLineTooLong) {
+		t.Errorf("err = %v, want ErrLineTooLong", s.err)
+	}
+}
+
+func TestExecuteNDJSON(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	in := make(chan []byte)
+	out := make(chan []byte)
+	done := make(chan error, 1)
+	go func() {
+		_, err := exec.ExecuteNDJSON(context.Background(), `while read -r line; do echo "got $line"; done`, in, out)
+		done <- err
+	}()
+
+	// Each record must come back before the next is sent.
+	for i := 0; i < 3; i++ {
+		in <- []byte(fmt.Sprintf(`{"n":%d}`, i))
+		want := fmt.Sprintf(`got {"n":%d}`, i)
+		if got := <-out; string(got) != want {
+			t.Fatalf("line %d = %q, want %q", i, got, want)
+		}
+	}
+	close(in)
+
+	if _, ok := <-out; ok {
+		t.Error("out not closed after execution")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("ExecuteNDJSON() error: %v", err)
+	}
+}
+
+func TestExecuteNDJSONFromReader(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	// A document split mid-token, as a network read would split it.
+	doc := iotest.HalfReader(strings.NewReader(`{"items": [{"id": 1}, {"id": 22}]}`))
+	out := make(chan []byte, 16)
+	if _, err := exec.ExecuteNDJSONFromReader(context.Background(), `jq -c --stream 'select(.[0][2] == "id" and length == 2) | .[1]'`, doc, out); err != nil {
+		t.Fatalf("ExecuteNDJSONFromReader() error: %v", err)
+	}
+	var got []string
+	for line := range out {
+		got = append(got, string(line))
+	}
+	if strings.Join(got, ",") != "1,22" {
+		t.Errorf("lines = %q, want [1 22]", got)
+	}
+}
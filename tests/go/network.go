@@ -0,0 +1,348 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebitengine/purego"
+)
+
+// hostLookupTimeout bounds resolving each name of NetworkPolicy.AllowHosts.
+// The names are resolved at once, so it bounds resolving all of them too.
+const hostLookupTimeout = 5 * time.Second
+
+// hostLookupTTL is how long the addresses AllowHosts resolved to are
+// reused before being resolved again.
+const hostLookupTTL = time.Minute
+
+// NetworkPolicy decides which connections the components spawned by
+// scripts, such as curl, may open, and which names they may resolve. The
+// library checks every name lookup, every TCP connect and every UDP connect
+// or send against it. The connections HostHTTP opens for curl are checked
+// too; other commands serviced in Go, such as HostCommands, are not.
+//
+// A connection is allowed if its address is in AllowCIDRs or is one of the
+// addresses a name in AllowHosts resolves to, and a lookup if the name is
+// in AllowHosts. Any other connection or lookup is allowed unless
+// DenyByDefault is set, so a policy without it only records them to the
+// AuditSink as AuditConnect events.
+//
+// HostHTTP also refuses loopback, link-local and unspecified addresses,
+// such as 127.0.0.1 and 169.254.169.254, unless they are in AllowCIDRs or
+// AllowHosts, even without DenyByDefault or a policy at all.
+//
+// MaxBodyBytes and Timeout apply to the requests curl sends through
+// HostHTTP, the only traffic the host sees; spawned components' sockets
+// are checked when they connect, not as data flows.
+type NetworkPolicy struct {
+	// AllowHosts are host names or IP addresses that may be connected to,
+	// on any port. Names are resolved when a connection is checked and
+	// reused for a minute.
+	AllowHosts []string
+	// AllowCIDRs are address ranges that may be connected to, such as
+	// "10.0.0.0/8" or "2001:db8::/32".
+	AllowCIDRs []string
+	// DenyByDefault refuses connections and lookups matching neither list;
+	// they fail in the script with a permission error.
+	DenyByDefault bool
+	// MaxBodyBytes limits the request and response bodies of requests sent
+	// through HostHTTP; larger ones fail the command. 0 means no limit
+	// beyond HostHTTPConfig.MaxResponseBytes.
+	MaxBodyBytes int64
+	// Timeout bounds each request sent through HostHTTP, including reading
+	// its response. 0 means no limit beyond curl's -m.
+	Timeout time.Duration
+}
+
+// connectRequest is a connection or name lookup a spawned command
+// attempts.
+type connectRequest struct {
+	executionID string
+	command     string
+	// network is "tcp", "udp" or, for a lookup of host, "dns".
+	network string
+	addr    netip.AddrPort
+	host    string
+}
+
+// address is the address c is to, or the name it looks up.
+func (c connectRequest) address() string {
+	if c.network == "dns" {
+		return c.host
+	}
+	return c.addr.String()
+}
+
+// connectHandler reports whether a connection may be opened.
+type connectHandler func(c connectRequest) bool
+
+// handler returns the connectHandler enforcing p, or an error if one of
+// its CIDRs is invalid.
+func (p *NetworkPolicy) handler() (connectHandler, error) {
+	lists, err := p.lists()
+	if err != nil {
+		return nil, err
+	}
+	deny := p.DenyByDefault
+	return func(c connectRequest) bool {
+		return !deny || lists.allow(c)
+	}, nil
+}
+
+// policyLists are the AllowCIDRs and AllowHosts of a NetworkPolicy.
+type policyLists struct {
+	prefixes []netip.Prefix
+	hosts    *allowedHosts
+}
+
+// lists returns the lists of p, or an error if one of its CIDRs is
+// invalid.
+func (p *NetworkPolicy) lists() (*policyLists, error) {
+	prefixes := make([]netip.Prefix, 0, len(p.AllowCIDRs))
+	for _, cidr := range p.AllowCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NetworkPolicy CIDR: %w", err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return &policyLists{prefixes: prefixes, hosts: newAllowedHosts(p.AllowHosts)}, nil
+}
+
+// allow reports whether the lists allow c.
+func (l *policyLists) allow(c connectRequest) bool {
+	if c.network == "dns" {
+		return l.hosts.named(c.host)
+	}
+	addr := c.addr.Addr().Unmap()
+	for _, prefix := range l.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return l.hosts.resolveTo(addr)
+}
+
+// allowedHosts are the AllowHosts of a NetworkPolicy, with the addresses
+// their names last resolved to.
+type allowedHosts struct {
+	ips   []netip.Addr
+	names []string
+	// lookup resolves a name; net.DefaultResolver outside tests.
+	lookup func(ctx context.Context, name string) ([]netip.Addr, error)
+
+	mu       sync.Mutex
+	resolved []netip.Addr
+	expires  time.Time
+	// refresh, while the names are being resolved again, is closed once
+	// they are.
+	refresh chan struct{}
+}
+
+// newAllowedHosts splits hosts into addresses and names.
+func newAllowedHosts(hosts []string) *allowedHosts {
+	a := &allowedHosts{lookup: func(ctx context.Context, name string) ([]netip.Addr, error) {
+		return net.DefaultResolver.LookupNetIP(ctx, "ip", name)
+	}}
+	for _, host := range hosts {
+		if ip, err := netip.ParseAddr(host); err == nil {
+			a.ips = append(a.ips, ip.Unmap())
+		} else {
+			a.names = append(a.names, normalizeHost(host))
+		}
+	}
+	return a
+}
+
+// normalizeHost lowercases name and drops a trailing dot.
+func normalizeHost(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// named reports whether name is one of the hosts. Addresses are looked up
+// as themselves, so they are allowed if they are hosts too.
+func (a *allowedHosts) named(name string) bool {
+	if ip, err := netip.ParseAddr(name); err == nil {
+		return slices.Contains(a.ips, ip.Unmap())
+	}
+	return slices.Contains(a.names, normalizeHost(name))
+}
+
+// resolveTo reports whether one of the hosts is, or resolves to, addr.
+// Names that fail to resolve match nothing.
+func (a *allowedHosts) resolveTo(addr netip.Addr) bool {
+	if slices.Contains(a.ips, addr) {
+		return true
+	}
+	if len(a.names) == 0 {
+		return false
+	}
+	return slices.Contains(a.addrs(), addr)
+}
+
+// addrs returns the addresses the names resolve to, resolving them again
+// once hostLookupTTL has passed. One caller resolves them, without holding
+// the lock, while any others wait for its result.
+func (a *allowedHosts) addrs() []netip.Addr {
+	a.mu.Lock()
+	if time.Now().Before(a.expires) {
+		defer a.mu.Unlock()
+		return a.resolved
+	}
+	if refresh := a.refresh; refresh != nil {
+		a.mu.Unlock()
+		<-refresh
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return a.resolved
+	}
+	refresh := make(chan struct{})
+	a.refresh = refresh
+	a.mu.Unlock()
+
+	resolved := a.resolve()
+	a.mu.Lock()
+	a.resolved, a.expires, a.refresh = resolved, time.Now().Add(hostLookupTTL), nil
+	a.mu.Unlock()
+	close(refresh)
+	return resolved
+}
+
+// resolve looks up every name at once, each within hostLookupTimeout.
+func (a *allowedHosts) resolve() []netip.Addr {
+	results := make([][]netip.Addr, len(a.names))
+	var wg sync.WaitGroup
+	for i, name := range a.names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), hostLookupTimeout)
+			defer cancel()
+			ips, _ := a.lookup(ctx, name)
+			for _, ip := range ips {
+				results[i] = append(results[i], ip.Unmap())
+			}
+		}(i, name)
+	}
+	wg.Wait()
+	var resolved []netip.Addr
+	for _, ips := range results {
+		resolved = append(resolved, ips...)
+	}
+	return resolved
+}
+
+// connectEntry is a registered handler.
+type connectEntry struct {
+	fn connectHandler
+}
+
+var (
+	// connectCallback is the single C entry point for every executor's
+	// connect handler; purego callbacks are never freed, so it is created
+	// once.
+	connectCallbackOnce sync.Once
+	connectCallback     uintptr
+
+	// connectHandlers maps the user_data passed to the native library to
+	// the handler it stands for.
+	connectHandlersMu sync.RWMutex
+	connectHandlers   = map[uintptr]connectEntry{}
+	nextConnectID     uintptr
+)
+
+// setConnectHandler has the library ask fn before spawned commands open a
+// connection. fn runs on a native thread while the command waits, and may
+// be called concurrently.
+func (e *Executor) setConnectHandler(fn connectHandler) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.handle == 0 {
+		return errors.New("executor is closed")
+	}
+	if err := e.lib.network.load(); err != nil {
+		return err
+	}
+
+	connectCallbackOnce.Do(func() {
+		connectCallback = purego.NewCallback(runConnectCallback)
+	})
+	connectHandlersMu.Lock()
+	nextConnectID++
+	id := nextConnectID
+	connectHandlers[id] = connectEntry{fn: fn}
+	connectHandlersMu.Unlock()
+
+	ebuf := newErrorBuffer()
+	if e.lib.executorSetConnectHandler(e.handle, connectCallback, id, ebuf.ptr(), errorBufferSize) != 0 {
+		releaseConnectHandler(id)
+		return fmt.Errorf("failed to set connect handler: %s", ebuf)
+	}
+
+	releaseConnectHandler(e.connectID)
+	e.connectID = id
+	return nil
+}
+
+// releaseConnectHandler forgets the handler registered under id.
+func releaseConnectHandler(id uintptr) {
+	if id == 0 {
+		return
+	}
+	connectHandlersMu.Lock()
+	delete(connectHandlers, id)
+	connectHandlersMu.Unlock()
+}
+
+// Kinds of connection passed to ConchConnectCallback.
+const (
+	connectTCP    = 0
+	connectUDP    = 1
+	connectLookup = 2
+)
+
+// runConnectCallback implements ConchConnectCallback. Connections and
+// lookups are denied if the handler is gone, the address cannot be parsed,
+// the kind is unknown or the handler panics.
+func runConnectCallback(id, executionID, command, addr, kind uintptr) uintptr {
+	connectHandlersMu.RLock()
+	entry := connectHandlers[id]
+	connectHandlersMu.RUnlock()
+	if entry.fn == nil {
+		return 0
+	}
+
+	c := connectRequest{executionID: goString(executionID), command: goString(command)}
+	// kind is a C uint8_t; the rest of its register is unspecified.
+	switch kind & 0xff {
+	case connectTCP:
+		c.network = "tcp"
+	case connectUDP:
+		c.network = "udp"
+	case connectLookup:
+		c.network = "dns"
+		c.host = goString(addr)
+	default:
+		return 0
+	}
+	if c.network != "dns" {
+		var err error
+		if c.addr, err = netip.ParseAddrPort(goString(addr)); err != nil {
+			return 0
+		}
+	}
+
+	var allowed bool
+	if perr := protect("connect", func() { allowed = entry.fn(c) }); perr != nil || !allowed {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,199 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNetworkPolicyHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy NetworkPolicy
+		addr   string
+		want   bool
+	}{
+		{"no lists", NetworkPolicy{}, "203.0.113.7:443", true},
+		{"deny by default", NetworkPolicy{DenyByDefault: true}, "203.0.113.7:443", false},
+		{"cidr", NetworkPolicy{AllowCIDRs: []string{"10.0.0.0/8"}, DenyByDefault: true}, "10.1.2.3:80", true},
+		{"outside cidr", NetworkPolicy{AllowCIDRs: []string{"10.0.0.0/8"}, DenyByDefault: true}, "11.0.0.1:80", false},
+		{"unmasked cidr", NetworkPolicy{AllowCIDRs: []string{"192.168.1.9/24"}, DenyByDefault: true}, "192.168.1.200:53", true},
+		{"mapped ipv4", NetworkPolicy{AllowCIDRs: []string{"10.0.0.0/8"}, DenyByDefault: true}, "[::ffff:10.0.0.1]:80", true},
+		{"ipv6 cidr", NetworkPolicy{AllowCIDRs: []string{"2001:db8::/32"}, DenyByDefault: true}, "[2001:db8::1]:443", true},
+		{"host ip", NetworkPolicy{AllowHosts: []string{"198.51.100.1"}, DenyByDefault: true}, "198.51.100.1:22", true},
+		{"other host ip", NetworkPolicy{AllowHosts: []string{"198.51.100.1"}, DenyByDefault: true}, "198.51.100.2:22", false},
+		{"host name", NetworkPolicy{AllowHosts: []string{"localhost"}, DenyByDefault: true}, "127.0.0.1:8080", true},
+		{"unresolvable host", NetworkPolicy{AllowHosts: []string{"no-such-host.invalid"}, DenyByDefault: true}, "127.0.0.1:8080", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := tt.policy.handler()
+			if err != nil {
+				t.Fatalf("handler() error: %v", err)
+			}
+			c := connectRequest{command: "curl", network: "tcp", addr: netip.MustParseAddrPort(tt.addr)}
+			if got := h(c); got != tt.want {
+				t.Errorf("allow(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetworkPolicyLookups(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy NetworkPolicy
+		host   string
+		want   bool
+	}{
+		{"no lists", NetworkPolicy{}, "secret.attacker.example", true},
+		{"deny by default", NetworkPolicy{DenyByDefault: true}, "secret.attacker.example", false},
+		{"allowed host", NetworkPolicy{AllowHosts: []string{"api.example.com"}, DenyByDefault: true}, "API.example.com.", true},
+		{"subdomain", NetworkPolicy{AllowHosts: []string{"example.com"}, DenyByDefault: true}, "secret.example.com", false},
+		{"allowed address", NetworkPolicy{AllowHosts: []string{"198.51.100.1"}, DenyByDefault: true}, "198.51.100.1", true},
+		{"cidr", NetworkPolicy{AllowCIDRs: []string{"10.0.0.0/8"}, DenyByDefault: true}, "internal.example", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := tt.policy.handler()
+			if err != nil {
+				t.Fatalf("handler() error: %v", err)
+			}
+			if got := h(connectRequest{command: "curl", network: "dns", host: tt.host}); got != tt.want {
+				t.Errorf("allow(%s) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowedHostsCachesResolution(t *testing.T) {
+	hosts := newAllowedHosts([]string{"localhost"})
+	if !hosts.resolveTo(netip.MustParseAddr("127.0.0.1")) {
+		t.Fatal("localhost does not resolve to 127.0.0.1")
+	}
+	expires := hosts.expires
+	hosts.resolveTo(netip.MustParseAddr("192.0.2.1"))
+	if hosts.expires != expires {
+		t.Error("resolveTo() resolved the hosts again within hostLookupTTL")
+	}
+}
+
+func TestAllowedHostsResolveConcurrently(t *testing.T) {
+	hosts := newAllowedHosts([]string{"a.example", "b.example", "c.example"})
+	var lookups atomic.Int32
+	release := make(chan struct{})
+	hosts.lookup = func(ctx context.Context, name string) ([]netip.Addr, error) {
+		lookups.Add(1)
+		<-release
+		if name == "b.example" {
+			return []netip.Addr{netip.MustParseAddr("192.0.2.2")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	results := make(chan bool, 4)
+	for i := 0; i < cap(results); i++ {
+		go func() { results <- hosts.resolveTo(netip.MustParseAddr("192.0.2.2")) }()
+	}
+	// Every name is looked up at once, by one caller.
+	for lookups.Load() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	for i := 0; i < cap(results); i++ {
+		if !<-results {
+			t.Error("resolveTo() = false for an address a host resolves to")
+		}
+	}
+	if n := lookups.Load(); n != 3 {
+		t.Errorf("%d lookups, want one per name", n)
+	}
+}
+
+func TestNetworkPolicyInvalidCIDR(t *testing.T) {
+	p := &NetworkPolicy{AllowCIDRs: []string{"10.0.0.0/33"}}
+	if _, err := p.handler(); err == nil {
+		t.Error("handler() accepted an invalid CIDR")
+	}
+}
+
+func TestAuditConnectHandler(t *testing.T) {
+	sink := &auditRecorder{}
+	deny := func(connectRequest) bool { return false }
+	h := auditConnectHandler(sink, map[string]string{"tenant": "a"}, deny)
+
+	c := connectRequest{executionID: "exec-1", command: "curl", network: "udp", addr: netip.MustParseAddrPort("10.0.0.1:53")}
+	if h(c) {
+		t.Fatal("audit handler allowed a connection its policy denied")
+	}
+	h(connectRequest{command: "curl", network: "dns", host: "secret.attacker.example"})
+	events := sink.get()
+	if len(events) != 2 {
+		t.Fatalf("recorded %d events, want 2", len(events))
+	}
+	e := events[0]
+	if e.Type != AuditConnect || e.ExecutionID != "exec-1" || e.Command != "curl" || e.Network != "udp" || e.Address != "10.0.0.1:53" || !e.Denied || e.Labels["tenant"] != "a" {
+		t.Errorf("event = %+v", e)
+	}
+	if e := events[1]; e.Network != "dns" || e.Address != "secret.attacker.example" || !e.Denied {
+		t.Errorf("lookup event = %+v", e)
+	}
+}
+
+func TestRunConnectCallbackUnknownHandler(t *testing.T) {
+	if got := runConnectCallback(^uintptr(0), 0, 0, 0, 0); got != 0 {
+		t.Errorf("runConnectCallback() for an unknown handler = %d, want 0", got)
+	}
+}
+
+func TestRunConnectCallbackLookup(t *testing.T) {
+	var got connectRequest
+	connectHandlersMu.Lock()
+	nextConnectID++
+	id := nextConnectID
+	connectHandlers[id] = connectEntry{fn: func(c connectRequest) bool { got = c; return true }}
+	connectHandlersMu.Unlock()
+	defer releaseConnectHandler(id)
+
+	name := nativeBytes(t, []byte("example.com\x00"))
+	if runConnectCallback(id, 0, 0, name, connectLookup) != 1 {
+		t.Fatal("runConnectCallback() denied an allowed lookup")
+	}
+	if got.network != "dns" || got.host != "example.com" {
+		t.Errorf("request = %+v", got)
+	}
+	if runConnectCallback(id, 0, 0, name, 7) != 0 {
+		t.Error("runConnectCallback() allowed an unknown kind")
+	}
+}
+
+func TestNetworkPolicyDeniesConnections(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	sink := &auditRecorder{}
+	exec, err := New(WithEmbedded(), WithNetworkPolicy(&NetworkPolicy{DenyByDefault: true}), WithAuditSink(sink))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.Execute("curl -s http://127.0.0.1:9/")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.ExitCode == 0 {
+		t.Errorf("ExitCode = 0, want the denied connection to fail")
+	}
+	for _, e := range sink.get() {
+		if e.Type == AuditConnect {
+			if !e.Denied || e.Address != "127.0.0.1:9" {
+				t.Errorf("connect event = %+v", e)
+			}
+			return
+		}
+	}
+	t.Skip("Skipping: library has no curl component")
+}
@@ -0,0 +1,84 @@
+package conch
+
+import "strings"
+
+// NormalizeScript returns script with comments removed and insignificant
+// whitespace normalized, so caching layers and allowlists can key on what a
+// script does rather than how it is laid out:
+//
+//	normalized, err := NormalizeScript(script)
+//	key := ScriptHash(normalized)
+//
+// It parses script with the library's parser and rebuilds it from its
+// tokens: blanks between tokens become one space, newlines between them
+// one newline, and comments, blank lines and backslash-newline
+// continuations are dropped. Quoted strings, here-document bodies,
+// parameter expansions and arithmetic are kept byte for byte, as is
+// anything inside double quotes. Normalizing does not change what a script
+// does, but scripts that do the same thing may still normalize
+// differently: operators are not respaced and nothing is rewritten.
+//
+// NormalizeScript fails if script is not valid shell.
+func NormalizeScript(script string) (string, error) {
+	tree, err := parseScript(script)
+	if err != nil {
+		return "", err
+	}
+	var out, gap strings.Builder
+	end := 0
+	for _, tok := range tree.tokens(verbatimKinds) {
+		if tok.Start < end {
+			continue
+		}
+		gap.WriteString(script[end:tok.Start])
+		end = tok.End
+		if tok.Kind == "comment" {
+			continue
+		}
+		if out.Len() > 0 {
+			out.WriteString(separator(gap.String(), tok.Kind))
+		}
+		gap.Reset()
+		out.WriteString(tok.text(script))
+	}
+	return out.String(), nil
+}
+
+// verbatimKinds are the kinds of syntax nodes NormalizeScript copies byte
+// for byte rather than normalizing what is inside them.
+var verbatimKinds = map[string]bool{
+	"string":               true,
+	"raw_string":           true,
+	"ansi_c_string":        true,
+	"translated_string":    true,
+	"expansion":            true,
+	"simple_expansion":     true,
+	"arithmetic_expansion": true,
+	"heredoc_body":         true,
+}
+
+// separator returns what NormalizeScript writes for gap, the text between
+// two tokens, the second of kind next: a newline if gap ends a line, a
+// space if it holds blanks, and nothing if it is empty. The gaps before a
+// here-document's body and delimiter are kept from their first newline on,
+// since leading blanks there are part of the document. A gap holding
+// anything else, which the grammar should not produce, is kept whole.
+func separator(gap, next string) string {
+	if next == "heredoc_body" || next == "heredoc_end" {
+		if i := strings.IndexByte(gap, '\n'); i >= 0 {
+			return gap[i:]
+		}
+		return gap
+	}
+	blanks := strings.NewReplacer("\\\r\n", "", "\\\n", "").Replace(gap)
+	if strings.Trim(blanks, " \t\r\n") != "" {
+		return gap
+	}
+	switch {
+	case strings.Contains(blanks, "\n"):
+		return "\n"
+	case blanks != "":
+		return " "
+	}
+	return ""
+}
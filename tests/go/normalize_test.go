@@ -0,0 +1,143 @@
+package conch
+
+import "testing"
+
+// skipIfNoParser skips tests that need the library's parser.
+func skipIfNoParser(t testing.TB) {
+	t.Helper()
+	l, err := acquireLibrary()
+	if err != nil {
+		t.Skip("Skipping: conch library not available")
+	}
+	defer l.release()
+	if err := l.parser.load(); err != nil {
+		t.Skipf("Skipping: %v", err)
+	}
+}
+
+// normalize calls NormalizeScript, failing t on an error.
+func normalize(t testing.TB, script string) string {
+	t.Helper()
+	got, err := NormalizeScript(script)
+	if err != nil {
+		t.Fatalf("NormalizeScript(%q) error: %v", script, err)
+	}
+	return got
+}
+
+func TestNormalizeScript(t *testing.T) {
+	skipIfNoParser(t)
+
+	tests := []struct {
+		name, script, want string
+	}{
+		{"blanks", "  echo   a\t\tb  ", "echo a b"},
+		{"blank lines", "echo a\n\n\n  \necho b\n", "echo a\necho b"},
+		{"comments", "#!/bin/bash\n# setup\necho a # trailing\n  # indented\necho b", "echo a\necho b"},
+		{"not comments", "echo a#b $# ${#x} \\# '#' \"#\"", "echo a#b $# ${#x} \\# '#' \"#\""},
+		{"continuation", "echo a \\\n    b", "echo a b"},
+		{"single quotes", "echo 'a   # b'", "echo 'a   # b'"},
+		{"double quotes", "echo \"a   $(echo  \"b  # c\")\"", "echo \"a   $(echo  \"b  # c\")\""},
+		{"ansi quotes", "echo $'a\\'  b'", "echo $'a\\'  b'"},
+		{"expansions", "echo ${x:-a  b} $((1  +  2))", "echo ${x:-a  b} $((1  +  2))"},
+		{"command substitution", "echo $(  echo   a # c\n  )", "echo $( echo a\n)"},
+		{"backquotes", "echo `echo   a`", "echo `echo a`"},
+		{"heredoc", "cat <<EOF\n  a   # b\n\nEOF\necho   c", "cat <<EOF\n  a   # b\n\nEOF\necho c"},
+		{"heredoc quoted delimiter", "cat << 'E F'\n x\nE F\n", "cat << 'E F'\n x\nE F"},
+		{"heredoc tabs", "cat <<-EOF\n\tx\n\tEOF\necho  y", "cat <<-EOF\n\tx\n\tEOF\necho y"},
+		{"heredoc then pipe", "cat <<EOF  |  grep a # c\n a\nEOF\n", "cat <<EOF | grep a\n a\nEOF"},
+		{"here string", "cat <<<   x   # y", "cat <<< x"},
+		{"arithmetic shift", "((x = 1 << 2))\necho   $x", "((x = 1 << 2))\necho $x"},
+		{"case in substitution", "echo $(case x in a)  echo a;; esac)", "echo $(case x in a) echo a;; esac)"},
+		{"empty", "  \n# only a comment\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := normalize(t, tt.script)
+			if got != tt.want {
+				t.Errorf("NormalizeScript(%q) = %q, want %q", tt.script, got, tt.want)
+			}
+			if again := normalize(t, got); again != got {
+				t.Errorf("NormalizeScript not idempotent: %q then %q", got, again)
+			}
+		})
+	}
+}
+
+func TestNormalizeScriptInvalid(t *testing.T) {
+	skipIfNoParser(t)
+
+	for _, script := range []string{"echo 'a   b", "if true; then echo a", "echo $(echo a"} {
+		if got, err := NormalizeScript(script); err == nil {
+			t.Errorf("NormalizeScript(%q) = %q, want an error", script, got)
+		}
+	}
+}
+
+func TestNormalizeScriptSameKey(t *testing.T) {
+	skipIfNoParser(t)
+
+	a := "# deploy\nset -e\necho  hello   world\n"
+	b := "set -e   \n\n  echo hello world # greet"
+	if ScriptHash(normalize(t, a)) != ScriptHash(normalize(t, b)) {
+		t.Errorf("%q and %q normalize differently: %q, %q", a, b, normalize(t, a), normalize(t, b))
+	}
+}
+
+func TestNormalizeScriptBehaviour(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	script := "x=3 # three\n  echo \"$x  #\" $((x  *  2))   \\\n  done\ncat <<EOF\n  $x   kept\nEOF\n"
+	for _, s := range []string{script, normalize(t, script)} {
+		result, err := exec.Execute(s)
+		if err != nil {
+			t.Fatalf("Execute(%q) error: %v", s, err)
+		}
+		if want := "3  # 6 done\n  3   kept\n"; string(result.Stdout) != want {
+			t.Errorf("Execute(%q) = %q, want %q", s, result.Stdout, want)
+		}
+	}
+}
+
+func TestSeparator(t *testing.T) {
+	tests := []struct {
+		gap, next, want string
+	}{
+		{"", "word", ""},
+		{"  \t", "word", " "},
+		{" \\\n  ", "word", " "},
+		{" \n\n  ", "word", "\n"},
+		{"  \n  ", "heredoc_body", "\n  "},
+		{"\t", "heredoc_end", "\t"},
+		{" x ", "word", " x "},
+	}
+	for _, tt := range tests {
+		if got := separator(tt.gap, tt.next); got != tt.want {
+			t.Errorf("separator(%q, %q) = %q, want %q", tt.gap, tt.next, got, tt.want)
+		}
+	}
+}
+
+func FuzzNormalizeScript(f *testing.F) {
+	skipIfNoParser(f)
+	for _, s := range []string{"echo a # b", "cat <<EOF\nx\nEOF", "echo \"$(echo 'a')\"", "a\\\nb", "$((1<<2))"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, script string) {
+		got, err := NormalizeScript(script)
+		if err != nil {
+			return
+		}
+		if len(got) > len(script) {
+			t.Errorf("NormalizeScript(%q) = %q grew", script, got)
+		}
+		if again := normalize(t, got); again != got {
+			t.Errorf("NormalizeScript not idempotent: %q then %q", got, again)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+// Package opa evaluates conch policy with an Open Policy Agent server, so
+// what scripts may run is decided by centrally managed rego rather than by
+// each service.
+//
+// The Evaluator queries OPA's data API with the conch.PolicyInput as input.
+// The rule it names may produce a boolean, or an object with "allow" and an
+// optional "reason":
+//
+//	package conch
+//
+//	default decision := {"allow": false, "reason": "not allowlisted"}
+//
+//	decision := {"allow": true} if {
+//		input.command in {"git", "kubectl"}
+//	}
+//
+//	decision := {"allow": true} if {
+//		input.script != ""
+//		count(input.script) < 10000
+//	}
+package opa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+// Evaluator is a conch.PolicyEvaluator backed by an OPA server.
+type Evaluator struct {
+	// URL is the data API document holding the decision, such as
+	// "http://localhost:8181/v1/data/conch/decision".
+	URL string
+	// Client sends the queries. Defaults to http.DefaultClient; set one
+	// with a timeout for production use.
+	Client *http.Client
+}
+
+var _ conch.PolicyEvaluator = (*Evaluator)(nil)
+
+// New returns an Evaluator querying url.
+func New(url string) *Evaluator {
+	return &Evaluator{URL: url}
+}
+
+// Evaluate queries the OPA server with in. An undefined decision, which OPA
+// returns when the rule or package does not exist, is an error so that a
+// misconfigured URL denies rather than allows.
+func (e *Evaluator) Evaluate(ctx context.Context, in conch.PolicyInput) (conch.PolicyDecision, error) {
+	body, err := json.Marshal(struct {
+		Input conch.PolicyInput `json:"input"`
+	}{in})
+	if err != nil {
+		return conch.PolicyDecision{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return conch.PolicyDecision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return conch.PolicyDecision{}, fmt.Errorf("opa query failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return conch.PolicyDecision{}, fmt.Errorf("opa query failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return conch.PolicyDecision{}, fmt.Errorf("opa query failed: %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	return parseResponse(data)
+}
+
+// parseResponse extracts the decision from a data API response.
+func parseResponse(data []byte) (conch.PolicyDecision, error) {
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return conch.PolicyDecision{}, fmt.Errorf("invalid opa response: %w", err)
+	}
+	if len(resp.Result) == 0 {
+		return conch.PolicyDecision{}, errors.New("opa decision is undefined")
+	}
+
+	var allow bool
+	if err := json.Unmarshal(resp.Result, &allow); err == nil {
+		return conch.PolicyDecision{Allow: allow}, nil
+	}
+	var decision conch.PolicyDecision
+	if err := json.Unmarshal(resp.Result, &decision); err != nil {
+		return conch.PolicyDecision{}, fmt.Errorf("invalid opa decision %s: want a boolean or {\"allow\": ...}", resp.Result)
+	}
+	return decision, nil
+}
@@ -0,0 +1,72 @@
+package opa
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+func serve(t *testing.T, status int, response string) (*Evaluator, *conch.PolicyInput) {
+	t.Helper()
+	var got conch.PolicyInput
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input conch.PolicyInput `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding query: %v", err)
+		}
+		got = req.Input
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(srv.Close)
+	return New(srv.URL + "/v1/data/conch/decision"), &got
+}
+
+func TestEvaluate(t *testing.T) {
+	for _, tc := range []struct {
+		response string
+		want     conch.PolicyDecision
+	}{
+		{`{"result": true}`, conch.PolicyDecision{Allow: true}},
+		{`{"result": false}`, conch.PolicyDecision{}},
+		{`{"result": {"allow": false, "reason": "no curl"}}`, conch.PolicyDecision{Reason: "no curl"}},
+		{`{"result": {"allow": true}}`, conch.PolicyDecision{Allow: true}},
+	} {
+		e, got := serve(t, http.StatusOK, tc.response)
+		in := conch.PolicyInput{Command: "git", Args: []string{"status"}, Paths: []string{"/repo"}}
+		decision, err := e.Evaluate(context.Background(), in)
+		if err != nil {
+			t.Errorf("Evaluate() with %s error: %v", tc.response, err)
+			continue
+		}
+		if decision != tc.want {
+			t.Errorf("Evaluate() with %s = %+v, want %+v", tc.response, decision, tc.want)
+		}
+		if got.Command != "git" || len(got.Args) != 1 || len(got.Paths) != 1 {
+			t.Errorf("server got input %+v", *got)
+		}
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	for _, tc := range []struct {
+		status   int
+		response string
+	}{
+		{http.StatusOK, `{}`},
+		{http.StatusOK, `{"result": "yes"}`},
+		{http.StatusOK, `not json`},
+		{http.StatusBadRequest, `{"code": "invalid_parameter"}`},
+	} {
+		e, _ := serve(t, tc.status, tc.response)
+		if _, err := e.Evaluate(context.Background(), conch.PolicyInput{Script: "true"}); err == nil {
+			t.Errorf("Evaluate() with %d %s succeeded, want error", tc.status, tc.response)
+		}
+	}
+}
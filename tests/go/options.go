@@ -0,0 +1,189 @@
+package conch
+
+import (
+	"io/fs"
+	"path"
+)
+
+// Option configures the executor created by New.
+//
+// A Config is itself an Option that replaces everything set before it, so
+// New(Config{...}) and New(WithEmbedded(), WithLimits(l)) are both valid, and
+// options after a Config adjust it.
+type Option interface {
+	apply(*Config)
+}
+
+// optionFunc adapts a function to Option.
+type optionFunc func(*Config)
+
+func (f optionFunc) apply(cfg *Config) { f(cfg) }
+
+func (c Config) apply(cfg *Config) { *cfg = c }
+
+// WithComponentPath loads the shell component from the file at path.
+func WithComponentPath(path string) Option {
+	return optionFunc(func(cfg *Config) {
+		cfg.Backend = BackendFile
+		cfg.ComponentPath = path
+	})
+}
+
+// WithBytes loads the shell component from data.
+func WithBytes(data []byte) Option {
+	return optionFunc(func(cfg *Config) {
+		cfg.Backend = BackendBytes
+		cfg.ComponentBytes = data
+	})
+}
+
+// WithEmbedded uses the shell compiled into the native library.
+func WithEmbedded() Option {
+	return optionFunc(func(cfg *Config) { cfg.Backend = BackendEmbedded })
+}
+
+// WithFallback tries the remaining backends in the Auto order if the chosen
+// one fails.
+func WithFallback() Option {
+	return optionFunc(func(cfg *Config) { cfg.Fallback = true })
+}
+
+// WithLimits sets the resource limits for executions that are not given
+// their own.
+func WithLimits(limits ResourceLimits) Option {
+	return optionFunc(func(cfg *Config) { cfg.Limits = &limits })
+}
+
+// WithMount seeds every file in fsys into the virtual filesystem below dir,
+// which must be absolute. Mounts accumulate.
+func WithMount(dir string, fsys fs.FS) Option {
+	return optionFunc(func(cfg *Config) {
+		cfg.Mounts = append(cfg.Mounts, Mount{Dir: dir, FS: fsys})
+	})
+}
+
+// WithInitScript sets Config.InitScript.
+func WithInitScript(script string) Option {
+	return optionFunc(func(cfg *Config) { cfg.InitScript = script })
+}
+
+// WithCommandNotFound sets Config.OnCommandNotFound.
+func WithCommandNotFound(fn CommandNotFoundFunc) Option {
+	return optionFunc(func(cfg *Config) { cfg.OnCommandNotFound = fn })
+}
+
+// WithCommandNotFoundContext sets Config.OnCommandNotFoundContext.
+func WithCommandNotFoundContext(fn CommandNotFoundContextFunc) Option {
+	return optionFunc(func(cfg *Config) { cfg.OnCommandNotFoundContext = fn })
+}
+
+// WithHostCommands sets Config.HostCommands.
+func WithHostCommands(hc *HostCommandConfig) Option {
+	return optionFunc(func(cfg *Config) { cfg.HostCommands = hc })
+}
+
+// WithHTTPFixtures sets Config.HTTPFixtures.
+func WithHTTPFixtures(f *HTTPFixtures) Option {
+	return optionFunc(func(cfg *Config) { cfg.HTTPFixtures = f })
+}
+
+// WithNetworkPolicy sets Config.NetworkPolicy.
+func WithNetworkPolicy(p *NetworkPolicy) Option {
+	return optionFunc(func(cfg *Config) { cfg.NetworkPolicy = p })
+}
+
+// WithHostHTTP sets Config.HostHTTP.
+func WithHostHTTP(c *HostHTTPConfig) Option {
+	return optionFunc(func(cfg *Config) { cfg.HostHTTP = c })
+}
+
+// WithPrompt sets Config.OnPrompt.
+func WithPrompt(fn PromptFunc) Option {
+	return optionFunc(func(cfg *Config) { cfg.OnPrompt = fn })
+}
+
+// WithPromptContext sets Config.OnPromptContext.
+func WithPromptContext(fn PromptContextFunc) Option {
+	return optionFunc(func(cfg *Config) { cfg.OnPromptContext = fn })
+}
+
+// WithPolicy sets Config.Policy.
+func WithPolicy(p PolicyEvaluator) Option {
+	return optionFunc(func(cfg *Config) { cfg.Policy = p })
+}
+
+// WithAuditSink sets Config.AuditSink.
+func WithAuditSink(sink AuditSink) Option {
+	return optionFunc(func(cfg *Config) { cfg.AuditSink = sink })
+}
+
+// WithAllowedScriptHashes sets Config.AllowedScriptHashes.
+func WithAllowedScriptHashes(hashes ...string) Option {
+	return optionFunc(func(cfg *Config) { cfg.AllowedScriptHashes = append([]string{}, hashes...) })
+}
+
+// WithAllowedCommands sets Config.AllowedCommands.
+func WithAllowedCommands(names ...string) Option {
+	return optionFunc(func(cfg *Config) { cfg.AllowedCommands = append([]string{}, names...) })
+}
+
+// WithDedicatedThread sets Config.DedicatedThread.
+func WithDedicatedThread() Option {
+	return optionFunc(func(cfg *Config) { cfg.DedicatedThread = true })
+}
+
+// WithSubmitWorkers sets Config.SubmitWorkers.
+func WithSubmitWorkers(n int) Option {
+	return optionFunc(func(cfg *Config) { cfg.SubmitWorkers = n })
+}
+
+// WithProgress sets Config.OnProgress.
+func WithProgress(fn ProgressFunc) Option {
+	return optionFunc(func(cfg *Config) { cfg.OnProgress = fn })
+}
+
+// WithWatchdog sets Config.Watchdog.
+func WithWatchdog(w Watchdog) Option {
+	return optionFunc(func(cfg *Config) { cfg.Watchdog = &w })
+}
+
+// WithLabels adds labels to Config.Labels, replacing any with the same name.
+func WithLabels(labels map[string]string) Option {
+	return optionFunc(func(cfg *Config) {
+		merged := make(map[string]string, len(cfg.Labels)+len(labels))
+		for name, value := range cfg.Labels {
+			merged[name] = value
+		}
+		for name, value := range labels {
+			merged[name] = value
+		}
+		cfg.Labels = merged
+	})
+}
+
+// WithTmp sets Config.Tmp.
+func WithTmp(tmp TmpConfig) Option {
+	return optionFunc(func(cfg *Config) { cfg.Tmp = tmp })
+}
+
+// Mount is a tree of files seeded into every execution; see WithMount.
+type Mount struct {
+	// Dir is the absolute directory the files appear under.
+	Dir string
+	// FS holds the files.
+	FS fs.FS
+}
+
+// seed adds the files of m to exec.
+func (m Mount) seed(exec *Executor) error {
+	return fs.WalkDir(m.FS, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(m.FS, name)
+		if err != nil {
+			return err
+		}
+		return exec.AddFile(path.Join(m.Dir, name), data)
+	})
+}
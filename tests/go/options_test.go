@@ -0,0 +1,84 @@
+package conch
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestOptionsApply(t *testing.T) {
+	limits := ResourceLimits{TimeoutMs: 1}
+	var cfg Config
+	for _, opt := range []Option{
+		WithComponentPath("/x/conch_shell.wasm"),
+		WithFallback(),
+		WithLimits(limits),
+		WithMount("/data", fstest.MapFS{}),
+		WithMount("/etc", fstest.MapFS{}),
+		WithInitScript("set -e"),
+		WithTmp(TmpConfig{MaxBytes: 1 << 20}),
+	} {
+		opt.apply(&cfg)
+	}
+	if cfg.Backend != BackendFile || cfg.ComponentPath != "/x/conch_shell.wasm" || !cfg.Fallback {
+		t.Errorf("backend options = %+v", cfg)
+	}
+	if cfg.Limits == nil || *cfg.Limits != limits {
+		t.Errorf("Limits = %v, want %v", cfg.Limits, limits)
+	}
+	if len(cfg.Mounts) != 2 || cfg.Mounts[1].Dir != "/etc" {
+		t.Errorf("Mounts = %+v", cfg.Mounts)
+	}
+	if cfg.InitScript != "set -e" {
+		t.Errorf("InitScript = %q", cfg.InitScript)
+	}
+	if cfg.Tmp.MaxBytes != 1<<20 {
+		t.Errorf("Tmp = %+v", cfg.Tmp)
+	}
+
+	// A Config replaces earlier options; later options adjust it.
+	cfg = Config{}
+	for _, opt := range []Option{WithFallback(), Config{InitScript: "x=1"}, WithEmbedded()} {
+		opt.apply(&cfg)
+	}
+	if cfg.Fallback || cfg.InitScript != "x=1" || cfg.Backend != BackendEmbedded {
+		t.Errorf("Config option = %+v", cfg)
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	limits := DefaultLimits()
+	limits.MaxOutputBytes = 10
+	exec, err := New(
+		WithEmbedded(),
+		WithLimits(limits),
+		WithMount("/data", fstest.MapFS{
+			"a.txt":     {Data: []byte("alpha\n")},
+			"sub/b.txt": {Data: []byte("beta\n")},
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer exec.Close()
+
+	if exec.Backend() != BackendEmbedded {
+		t.Errorf("Backend() = %v, want %v", exec.Backend(), BackendEmbedded)
+	}
+	if exec.Limits() != limits {
+		t.Errorf("Limits() = %+v, want %+v", exec.Limits(), limits)
+	}
+
+	result, err := exec.Execute("cat /data/a.txt /data/sub/b.txt")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if string(result.Stdout) != "alpha\nbeta" || !result.Truncated {
+		t.Errorf("stdout = %q, truncated %v; want the mounted files cut at 10 bytes", result.Stdout, result.Truncated)
+	}
+
+	if _, err := New(WithEmbedded(), WithMount("relative", fstest.MapFS{"f": {}})); err == nil {
+		t.Error("New() with a relative mount succeeded")
+	}
+}
@@ -0,0 +1,202 @@
+package conch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ebitengine/purego"
+)
+
+// Stream identifies one of a script's output streams.
+type Stream int
+
+const (
+	Stdout Stream = 1
+	Stderr Stream = 2
+)
+
+func (s Stream) String() string {
+	switch s {
+	case Stdout:
+		return "stdout"
+	case Stderr:
+		return "stderr"
+	}
+	return fmt.Sprintf("Stream(%d)", int(s))
+}
+
+// OutputFunc receives a script's output as it is written; see
+// ExecOptions.OnOutput. offset is the position of chunk in its stream.
+// chunk is not retained by the caller and may be kept.
+type OutputFunc func(stream Stream, chunk []byte, offset int64)
+
+var (
+	// outputCallback is the C entry point for every output function; purego
+	// callbacks are never freed, so it is created once.
+	outputCallbackOnce sync.Once
+	outputCallback     uintptr
+
+	// outputFuncs maps the user_data passed to the native library to the
+	// function it belongs to.
+	outputFuncsMu sync.RWMutex
+	outputFuncs   = map[uintptr]OutputFunc{}
+	nextOutputID  uintptr
+)
+
+// setOutput passes the output of the execution that will hold interrupt, an
+// interrupt created by l, to fn. The returned release must be called once
+// the execution has returned.
+func setOutput(l *library, interrupt uintptr, fn OutputFunc) (release func(), err error) {
+	outputCallbackOnce.Do(func() {
+		outputCallback = purego.NewCallback(runOutputCallback)
+	})
+
+	outputFuncsMu.Lock()
+	nextOutputID++
+	id := nextOutputID
+	outputFuncs[id] = fn
+	outputFuncsMu.Unlock()
+
+	release = func() {
+		outputFuncsMu.Lock()
+		delete(outputFuncs, id)
+		outputFuncsMu.Unlock()
+	}
+	ebuf := newErrorBuffer()
+	if l.interruptSetOutput(interrupt, outputCallback, id, ebuf.ptr(), errorBufferSize) != 0 {
+		release()
+		return nil, fmt.Errorf("failed to set output callback: %s", ebuf)
+	}
+	return release, nil
+}
+
+// runOutputCallback implements ConchOutputCallback.
+func runOutputCallback(id, stream, data, length, offset uintptr) {
+	outputFuncsMu.RLock()
+	fn := outputFuncs[id]
+	outputFuncsMu.RUnlock()
+	if fn == nil || length == 0 {
+		return
+	}
+	// stream is a C uint8_t; the rest of its register is unspecified.
+	fn(Stream(stream&0xff), goBytes(data, int(length)), int64(offset))
+}
+
+// CaptureMode chooses which part of a stream a Result keeps when the script
+// writes more than MaxOutputBytes to it.
+type CaptureMode int
+
+const (
+	// CaptureHead keeps the first MaxOutputBytes of each stream.
+	CaptureHead CaptureMode = iota
+	// CaptureTail keeps the last MaxOutputBytes of each stream, which is
+	// usually what explains a failure. Memory use stays bounded as with
+	// CaptureHead.
+	CaptureTail
+	// CaptureSample keeps a sample of each stream's lines, chosen by
+	// ExecOptions.Sample, for previewing enormous output. Result.Capture
+	// reports how many lines were written and kept.
+	CaptureSample
+	// CaptureHeadTail keeps the start and end of each stream, chosen by
+	// ExecOptions.HeadTail, with a marker in place of what was left out
+	// between them.
+	CaptureHeadTail
+)
+
+func (m CaptureMode) String() string {
+	switch m {
+	case CaptureHead:
+		return "head"
+	case CaptureTail:
+		return "tail"
+	case CaptureSample:
+		return "sample"
+	case CaptureHeadTail:
+		return "head-tail"
+	}
+	return fmt.Sprintf("CaptureMode(%d)", int(m))
+}
+
+// native reports whether the native library implements m. The other modes
+// are kept in Go from the output as it is written.
+func (m CaptureMode) native() bool {
+	return m == CaptureHead || m == CaptureTail
+}
+
+// setCapture sets the capture mode of the execution that will hold
+// interrupt, an interrupt created by l.
+func setCapture(l *library, interrupt uintptr, mode CaptureMode) error {
+	if !mode.native() {
+		return fmt.Errorf("unknown capture mode %v", mode)
+	}
+	ebuf := newErrorBuffer()
+	if l.interruptSetCapture(interrupt, uint8(mode), ebuf.ptr(), errorBufferSize) != 0 {
+		return fmt.Errorf("failed to set capture mode: %s", ebuf)
+	}
+	return nil
+}
+
+// ExitInfo is the outcome of ExecuteTo: a Result without the output.
+type ExitInfo struct {
+	ExitCode int
+	// StdoutLen and StderrLen are the number of bytes the script wrote to
+	// each stream.
+	StdoutLen int64
+	StderrLen int64
+	// Traps lists the script's trap handlers that ran as it ended.
+	Traps []string
+	// ID identifies the execution, as in Result.ID.
+	ID string
+}
+
+// ExecuteTo runs script with default resource limits, writing its stdout and
+// stderr to the given writers as it produces them rather than collecting
+// them in memory, for jobs that only persist the output. A nil writer
+// discards its stream. MaxOutputBytes does not apply.
+//
+// Writes happen while the script runs, which waits for each to return. If
+// one fails, the script is stopped and ExecuteTo returns the write error.
+func (e *Executor) ExecuteTo(script string, stdout, stderr io.Writer) (ExitInfo, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		info     ExitInfo
+		writeErr error
+	)
+	onOutput := func(stream Stream, chunk []byte, offset int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		w := stdout
+		if stream == Stdout {
+			info.StdoutLen = offset + int64(len(chunk))
+		} else {
+			w, info.StderrLen = stderr, offset+int64(len(chunk))
+		}
+		if w == nil || writeErr != nil {
+			return
+		}
+		if _, err := w.Write(chunk); err != nil {
+			writeErr = fmt.Errorf("writing %v: %w", stream, err)
+			cancel()
+		}
+	}
+
+	// Nothing is kept natively: every byte goes through onOutput.
+	limits := e.Limits()
+	limits.MaxOutputBytes = 0
+	result, err := e.run(ctx, script, ExecOptions{OnOutput: onOutput}, limits)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if result != nil {
+		info.ExitCode, info.Traps, info.ID = result.ExitCode, result.Traps, result.ID
+	}
+	if writeErr != nil {
+		return info, writeErr
+	}
+	return info, err
+}
@@ -0,0 +1,169 @@
+package conch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestStreamString(t *testing.T) {
+	for s, want := range map[Stream]string{Stdout: "stdout", Stderr: "stderr", 7: "Stream(7)"} {
+		if got := s.String(); got != want {
+			t.Errorf("Stream(%d).String() = %q, want %q", int(s), got, want)
+		}
+	}
+}
+
+func TestRunOutputCallback(t *testing.T) {
+	var got []string
+	outputFuncsMu.Lock()
+	nextOutputID++
+	id := nextOutputID
+	outputFuncs[id] = func(stream Stream, chunk []byte, offset int64) {
+		got = append(got, fmt.Sprintf("%v@%d:%s", stream, offset, chunk))
+	}
+	outputFuncsMu.Unlock()
+
+	data := []byte("hello")
+	ptr := uintptr(unsafe.Pointer(&data[0]))
+	runOutputCallback(id, 1, ptr, uintptr(len(data)), 0)
+	// Only the low byte of the stream argument is set by C.
+	runOutputCallback(id, 0xabcd02, ptr, 3, 12)
+	runOutputCallback(id, 2, ptr, 0, 15)
+
+	outputFuncsMu.Lock()
+	delete(outputFuncs, id)
+	outputFuncsMu.Unlock()
+	runOutputCallback(id, 1, ptr, uintptr(len(data)), 5)
+
+	want := []string{"stdout@0:hello", "stderr@12:hel"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+}
+
+func TestExecuteOnOutput(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	type chunk struct {
+		stream Stream
+		data   string
+		offset int64
+	}
+	var chunks []chunk
+	opts := ExecOptions{OnOutput: func(stream Stream, data []byte, offset int64) {
+		chunks = append(chunks, chunk{stream, string(data), offset})
+	}}
+	result, err := exec.ExecuteWithOptions(context.Background(),
+		"echo one; echo two >&2; echo three; echo four >&2", opts)
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+
+	var merged strings.Builder
+	next := map[Stream]int64{}
+	for _, c := range chunks {
+		if c.offset != next[c.stream] {
+			t.Errorf("%v chunk %q at offset %d, want %d", c.stream, c.data, c.offset, next[c.stream])
+		}
+		next[c.stream] += int64(len(c.data))
+		fmt.Fprintf(&merged, "%v:%s", c.stream, c.data)
+	}
+	want := "stdout:one\nstderr:two\nstdout:three\nstderr:four\n"
+	if merged.String() != want {
+		t.Errorf("merged output = %q, want %q", merged.String(), want)
+	}
+	if string(result.Stdout) != "one\nthree\n" || string(result.Stderr) != "two\nfour\n" {
+		t.Errorf("result stdout %q, stderr %q", result.Stdout, result.Stderr)
+	}
+}
+
+func TestExecuteTo(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	// More output than the default limit keeps.
+	var stdout, stderr bytes.Buffer
+	info, err := exec.ExecuteTo("yes | head -c 2000000; echo oops >&2; exit 3", &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("ExecuteTo() error: %v", err)
+	}
+	if info.ExitCode != 3 || info.StdoutLen != 2000000 || info.StderrLen != 5 {
+		t.Errorf("ExecuteTo() = %+v", info)
+	}
+	if stdout.Len() != 2000000 || stderr.String() != "oops\n" {
+		t.Errorf("wrote %d bytes of stdout, stderr %q", stdout.Len(), stderr.String())
+	}
+
+	// A failing writer stops the script.
+	info, err = exec.ExecuteTo("while :; do echo y; done", failingWriter{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("ExecuteTo(failing writer) error = %v, want the write error", err)
+	}
+	if info.StdoutLen == 0 {
+		t.Errorf("ExecuteTo(failing writer) = %+v", info)
+	}
+}
+
+func TestCaptureMode(t *testing.T) {
+	for m, want := range map[CaptureMode]string{CaptureHead: "head", CaptureTail: "tail", CaptureSample: "sample", CaptureHeadTail: "head-tail", 5: "CaptureMode(5)"} {
+		if got := m.String(); got != want {
+			t.Errorf("CaptureMode(%d).String() = %q, want %q", int(m), got, want)
+		}
+	}
+	if err := setCapture(nil, 0, 5); err == nil {
+		t.Error("setCapture(CaptureMode(5)) succeeded")
+	}
+}
+
+func TestExecuteCaptureTail(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	limits := DefaultLimits()
+	limits.MaxOutputBytes = 16
+	script := "for i in $(seq 1 100); do echo line $i; done; echo failed at 100 >&2; exit 1"
+	result, err := exec.ExecuteWithOptions(context.Background(), script,
+		ExecOptions{Limits: &limits, Capture: CaptureTail})
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+	if want := "line 99\nline 100\n"; string(result.Stdout) != want[len(want)-16:] {
+		t.Errorf("stdout = %q, want the last 16 bytes of %q", result.Stdout, want)
+	}
+	if string(result.Stderr) != "failed at 100\n" {
+		t.Errorf("stderr = %q", result.Stderr)
+	}
+	if !result.Truncated || result.StdoutTotalLen <= 16 {
+		t.Errorf("Truncated = %v, StdoutTotalLen = %d", result.Truncated, result.StdoutTotalLen)
+	}
+
+	// The EXIT trap's output counts towards the same tail.
+	result, err = exec.ExecuteWithOptions(context.Background(), "trap 'echo bye' EXIT; "+script,
+		ExecOptions{Limits: &limits, Capture: CaptureTail})
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions(trap) error: %v", err)
+	}
+	if want := "line 99\nline 100\nbye\n"; string(result.Stdout) != want[len(want)-16:] {
+		t.Errorf("stdout with trap = %q, want the last 16 bytes of %q", result.Stdout, want)
+	}
+}
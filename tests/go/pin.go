@@ -0,0 +1,38 @@
+package conch
+
+import (
+	"runtime"
+	"unsafe"
+)
+
+// Memory safety model
+//
+// Scripts, stdin and module bytes are Go-allocated and handed to the native
+// library as raw pointers. purego passes them as uintptr, which the garbage
+// collector does not treat as a reference, so nothing would keep the buffer
+// alive (or, should Go ever gain a moving collector, in place) while the
+// native call is reading it. Every such buffer is therefore pinned with a
+// runtime.Pinner from just before the call until the call returns.
+//
+// The native side never retains these pointers: it copies whatever it needs
+// before returning, so buffers can be reused or released as soon as the
+// wrapping Go method returns.
+
+// StdinZeroCopy reports whether stdin passed to ExecuteWithStdin is consumed
+// in place by the native side.
+//
+// It is false: the native side copies stdin once into the guest's input pipe
+// before execution starts. Peak memory for a call is therefore roughly twice
+// len(stdin) (the Go buffer plus the native copy), and the Go buffer may be
+// reused immediately after the call returns.
+const StdinZeroCopy = false
+
+// pinBytes pins the backing array of b and returns its address, or 0 if b is
+// empty. The caller must call p.Unpin once the native call has returned.
+func pinBytes(p *runtime.Pinner, b []byte) uintptr {
+	if len(b) == 0 {
+		return 0
+	}
+	p.Pin(&b[0])
+	return uintptr(unsafe.Pointer(&b[0]))
+}
@@ -0,0 +1,108 @@
+package conch
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestPinBytesEmpty(t *testing.T) {
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	if ptr := pinBytes(&pinner, nil); ptr != 0 {
+		t.Errorf("pinBytes(nil) = %#x, want 0", ptr)
+	}
+	if ptr := pinBytes(&pinner, []byte{}); ptr != 0 {
+		t.Errorf("pinBytes(empty) = %#x, want 0", ptr)
+	}
+}
+
+func TestPinBytesAddress(t *testing.T) {
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	b := []byte("abc")
+	if ptr := pinBytes(&pinner, b); ptr != uintptr(unsafe.Pointer(&b[0])) {
+		t.Errorf("pinBytes() = %#x, want address of first element", ptr)
+	}
+}
+
+func TestExecuteWithStdin(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error = %v", err)
+	}
+	defer exec.Close()
+
+	stdin := []byte("alpha\nbeta\n")
+	result, err := exec.ExecuteWithStdin("grep beta", stdin)
+	if err != nil {
+		t.Fatalf("ExecuteWithStdin() error = %v", err)
+	}
+
+	// The native side copied stdin, so the buffer is ours again.
+	for i := range stdin {
+		stdin[i] = 'x'
+	}
+
+	stdout := strings.TrimSpace(string(result.Stdout))
+	if stdout != "beta" {
+		t.Errorf("Stdout = %q, want %q", stdout, "beta")
+	}
+}
+
+func TestExecuteWithStdinEmpty(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error = %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.ExecuteWithStdin("grep anything", nil)
+	if err != nil {
+		t.Fatalf("ExecuteWithStdin() error = %v", err)
+	}
+	if result.ExitCode != 1 {
+		t.Errorf("ExitCode = %d, want 1 (no input, no match)", result.ExitCode)
+	}
+}
+
+func TestExecuteWithStdinConcurrent(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error = %v", err)
+	}
+	defer exec.Close()
+
+	const workers = 8
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		go func(i int) {
+			want := fmt.Sprintf("line-%d", i)
+			result, err := exec.ExecuteWithStdin("grep line", []byte(want+"\n"))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if got := strings.TrimSpace(string(result.Stdout)); got != want {
+				errs <- fmt.Errorf("worker %d: Stdout = %q, want %q", i, got, want)
+				return
+			}
+			errs <- nil
+		}(i)
+	}
+	for i := 0; i < workers; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package conch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IncrementalPipeline runs a pipeline one stage at a time, keeping each
+// stage's output, so running it again after editing a stage reruns only
+// that stage and the ones after it. This speeds up iterating on a long
+// chain of jq, grep and sed over a large input:
+//
+//	p := exec.IncrementalPipeline()
+//	r, _ := p.Run(ctx, `cat /data/events.json | jq -c '.[]' | grep error`, nil)
+//	r, _ = p.Run(ctx, `cat /data/events.json | jq -c '.[]' | grep -c error`, nil) // reruns cat and grep
+//
+// The first stage always runs, since it is where a pipeline reads its
+// files and whatever else it starts from. A later stage is reused when its
+// text, its input and the executor's files and functions are unchanged, so
+// later stages must read nothing but their stdin: not files, the time, the
+// network or a host command's state. Call Reset to drop the outputs kept. Stages run one after another rather than together, and see
+// their whole input at once, so a stage that never ends, like yes, never
+// lets the next start.
+//
+// An IncrementalPipeline is safe for concurrent use, though concurrent runs
+// do not share each other's outputs.
+type IncrementalPipeline struct {
+	exec *Executor
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]*Result
+}
+
+// PipelineResult is the outcome of IncrementalPipeline.Run.
+type PipelineResult struct {
+	// Result is the last stage's result, with the stderr of every stage in
+	// order, as a shell pipeline would return it.
+	*Result
+	// Stages describe each stage, in order.
+	Stages []PipelineStage
+}
+
+// PipelineStage describes one stage of a run.
+type PipelineStage struct {
+	// Script is the stage's text.
+	Script string
+	// ExitCode is the stage's exit status.
+	ExitCode int
+	// Cached is true if the stage's output was reused rather than run.
+	Cached bool
+	// Duration is the time spent running the stage, zero if Cached.
+	Duration time.Duration
+}
+
+// IncrementalPipeline returns an IncrementalPipeline running on e.
+func (e *Executor) IncrementalPipeline() *IncrementalPipeline {
+	return &IncrementalPipeline{exec: e}
+}
+
+// Run runs script, which must be a single pipeline with stages separated
+// by |, feeding stdin to its first stage. Each stage keeps running on the
+// output of the one before whatever its exit status, and the pipeline's
+// exit status is the last stage's. An error stops the run.
+//
+// Only the outputs used by this run are kept for the next.
+func (p *IncrementalPipeline) Run(ctx context.Context, script string, stdin []byte) (*PipelineResult, error) {
+	stages, err := splitPipeline(script)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	prev := p.cache
+	p.mu.Unlock()
+
+	kept := make(map[[sha256.Size]byte]*Result, len(stages))
+	res := &PipelineResult{Stages: make([]PipelineStage, len(stages))}
+	var stderr bytes.Buffer
+	input := stdin
+	generation := p.exec.generation.Load()
+	for i, stage := range stages {
+		key := stageKey(stage, input, generation)
+		result, cached := prev[key]
+		cached = cached && i > 0
+		start := time.Now()
+		if !cached {
+			result, err = p.exec.ExecuteWithOptions(ctx, stage, ExecOptions{Stdin: input})
+			if err != nil {
+				return nil, fmt.Errorf("stage %d: %w", i+1, err)
+			}
+		}
+		if i > 0 {
+			kept[key] = result
+		}
+		res.Stages[i] = PipelineStage{Script: stage, ExitCode: result.ExitCode, Cached: cached}
+		if !cached {
+			res.Stages[i].Duration = time.Since(start)
+		}
+		stderr.Write(result.Stderr)
+		input = result.Stdout
+		res.Result = result
+	}
+
+	last := *res.Result
+	last.Stderr = stderr.Bytes()
+	res.Result = &last
+
+	p.mu.Lock()
+	p.cache = kept
+	p.mu.Unlock()
+	return res, nil
+}
+
+// Reset drops the outputs kept, so the next run runs every stage.
+func (p *IncrementalPipeline) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cache = nil
+}
+
+// stageKey identifies a stage's output by its text, its input and the
+// executor's generation.
+func stageKey(stage string, input []byte, generation uint64) [sha256.Size]byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%d\x00%s\x00", generation, len(stage), stage)
+	h.Write(input)
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+// splitPipeline returns the stages of script, a single pipeline. It fails
+// for anything else, such as commands joined by ; or &&.
+func splitPipeline(script string) ([]string, error) {
+	tree, err := parseScript(script)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline: %w", err)
+	}
+	if hasKind(tree, "heredoc_redirect") {
+		return nil, errors.New("pipeline: here-documents are not supported")
+	}
+	var statements []*syntaxNode
+	for i := range tree.Children {
+		c := &tree.Children[i]
+		if !c.Named && strings.TrimSpace(c.text(script)) == "" {
+			continue // a newline ending the pipeline
+		}
+		if !c.Named || c.Kind == "comment" {
+			return nil, fmt.Errorf("pipeline: unexpected %q; the script must be a single pipeline", c.text(script))
+		}
+		statements = append(statements, c)
+	}
+	if len(statements) != 1 {
+		return nil, errors.New("pipeline: the script must be a single pipeline")
+	}
+
+	var stages []string
+	var split func(n *syntaxNode) error
+	split = func(n *syntaxNode) error {
+		switch n.Kind {
+		case "pipeline":
+			for i := range n.Children {
+				c := &n.Children[i]
+				switch c.Kind {
+				case "|":
+				case "|&":
+					return errors.New("pipeline: |& is not supported")
+				case "comment":
+					return errors.New("pipeline: comments are not supported")
+				default:
+					if err := split(c); err != nil {
+						return err
+					}
+				}
+			}
+		case "list":
+			return fmt.Errorf("pipeline: %s joins commands rather than stages", n.Children[1].text(script))
+		default:
+			stages = append(stages, n.text(script))
+		}
+		return nil
+	}
+	if err := split(statements[0]); err != nil {
+		return nil, err
+	}
+	return stages, nil
+}
+
+// hasKind reports whether n or a node under it is of kind.
+func hasKind(n *syntaxNode, kind string) bool {
+	if n.Kind == kind {
+		return true
+	}
+	for i := range n.Children {
+		if hasKind(&n.Children[i], kind) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package conch
+
+import (
+	"context"
+	"crypto/sha256"
+	"reflect"
+	"testing"
+)
+
+func TestSplitPipeline(t *testing.T) {
+	skipIfNoParser(t)
+
+	tests := []struct {
+		script string
+		want   []string
+	}{
+		{"cat f", []string{"cat f"}},
+		{"cat f | jq -c '.[] | .id' | grep -v \"a|b\" 2>&1 | wc -l", []string{"cat f", "jq -c '.[] | .id'", "grep -v \"a|b\" 2>&1", "wc -l"}},
+		{"(echo a; echo b) | sort | tr a-z A-Z", []string{"(echo a; echo b)", "sort", "tr a-z A-Z"}},
+		{"echo $(date | cut -c1-4) |\\\n  rev", []string{"echo $(date | cut -c1-4)", "rev"}},
+		{"sort \\\n| uniq", []string{"sort", "uniq"}},
+		{"echo $(case x in a) echo a;; esac) | rev\n", []string{"echo $(case x in a) echo a;; esac)", "rev"}},
+	}
+	for _, tt := range tests {
+		got, err := splitPipeline(tt.script)
+		if err != nil {
+			t.Errorf("splitPipeline(%q) error: %v", tt.script, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitPipeline(%q) = %q, want %q", tt.script, got, tt.want)
+		}
+	}
+	for _, script := range []string{"a; b", "a && b", "a || b", "a\nb", "a |", "| b", "a & ", "cat <<EOF\nx\nEOF", "echo 'open | x", "a |& b", "a | b # c"} {
+		if got, err := splitPipeline(script); err == nil {
+			t.Errorf("splitPipeline(%q) = %q, want an error", script, got)
+		}
+	}
+}
+
+func TestStageKey(t *testing.T) {
+	key := stageKey("grep a", []byte("abc"), 1)
+	for _, other := range [][sha256.Size]byte{
+		stageKey("grep b", []byte("abc"), 1),
+		stageKey("grep a", []byte("abd"), 1),
+		stageKey("grep a", []byte("abc"), 2),
+	} {
+		if other == key {
+			t.Error("different stages share a key")
+		}
+	}
+}
+
+func TestIncrementalPipeline(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+	ctx := context.Background()
+
+	p := exec.IncrementalPipeline()
+	r, err := p.Run(ctx, "tr a-z A-Z | sort | head -n 2", []byte("c\nb\na\n"))
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if string(r.Stdout) != "A\nB\n" || len(r.Stages) != 3 {
+		t.Fatalf("Run() = %q with %d stages", r.Stdout, len(r.Stages))
+	}
+
+	r, err = p.Run(ctx, "tr a-z A-Z | sort | head -n 1", []byte("c\nb\na\n"))
+	if err != nil {
+		t.Fatalf("second Run() error: %v", err)
+	}
+	if string(r.Stdout) != "A\n" {
+		t.Errorf("second Run() = %q", r.Stdout)
+	}
+	if cached := []bool{r.Stages[0].Cached, r.Stages[1].Cached, r.Stages[2].Cached}; !reflect.DeepEqual(cached, []bool{false, true, false}) {
+		t.Errorf("cached stages = %v, want only the first and the edited last stage rerun", cached)
+	}
+
+	if err := exec.AddFile("/data/x", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	r, err = p.Run(ctx, "tr a-z A-Z | sort | head -n 1", []byte("c\nb\na\n"))
+	if err != nil {
+		t.Fatalf("third Run() error: %v", err)
+	}
+	if r.Stages[1].Cached {
+		t.Error("stage reused after the executor's files changed")
+	}
+}
@@ -0,0 +1,138 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrPolicyDenied is wrapped by the error returned when Config.Policy
+// refuses an execution.
+var ErrPolicyDenied = errors.New("denied by policy")
+
+// PolicyInput describes what is about to run, for a PolicyEvaluator to
+// judge. Scripts are evaluated before they start, with Script set; commands
+// leaving the sandbox (host commands and OnCommandNotFound) are evaluated
+// before they run, with Command and Args set.
+type PolicyInput struct {
+	// Script is the script about to execute.
+	Script string `json:"script,omitempty"`
+	// Command and Args are the command about to run outside the sandbox.
+	Command string   `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	// Env names the environment variables a host command would run with.
+	// Their values are left out, since they often hold secrets.
+	Env []string `json:"env,omitempty"`
+	// Paths are the paths a command names: its arguments that look like
+	// paths, resolved against its working directory when it runs on the
+	// host.
+	Paths []string `json:"paths,omitempty"`
+}
+
+// PolicyDecision is a PolicyEvaluator's verdict.
+type PolicyDecision struct {
+	Allow bool `json:"allow"`
+	// Reason, if set, explains a denial to the script's caller.
+	Reason string `json:"reason,omitempty"`
+}
+
+// PolicyEvaluator decides whether scripts and commands may run, so policy
+// can live in a central engine rather than in each service's allowlists. An
+// evaluation error denies the script or command.
+//
+// Commands are evaluated on native threads while their execution runs, so
+// Evaluate must be safe for concurrent use.
+type PolicyEvaluator interface {
+	Evaluate(ctx context.Context, in PolicyInput) (PolicyDecision, error)
+}
+
+// PolicyFunc adapts a function to PolicyEvaluator.
+type PolicyFunc func(ctx context.Context, in PolicyInput) (PolicyDecision, error)
+
+// Evaluate calls f.
+func (f PolicyFunc) Evaluate(ctx context.Context, in PolicyInput) (PolicyDecision, error) {
+	return f(ctx, in)
+}
+
+// evaluatePolicy asks p about in, returning an error wrapping
+// ErrPolicyDenied unless it allows it.
+func evaluatePolicy(ctx context.Context, p PolicyEvaluator, in PolicyInput) error {
+	decision, err := p.Evaluate(ctx, in)
+	switch {
+	case err != nil:
+		return fmt.Errorf("%w: %w", ErrPolicyDenied, err)
+	case !decision.Allow && decision.Reason != "":
+		return fmt.Errorf("%w: %s", ErrPolicyDenied, decision.Reason)
+	case !decision.Allow:
+		return ErrPolicyDenied
+	}
+	return nil
+}
+
+// checkPolicy evaluates script against the executor's policy, if any.
+func (e *Executor) checkPolicy(ctx context.Context, script string) error {
+	if e.policy == nil {
+		return nil
+	}
+	return evaluatePolicy(ctx, e.policy, PolicyInput{Script: script})
+}
+
+// policyCommandHandler returns a commandHandler asking p before passing
+// commands to next. Commands allowlisted by hc, which may be nil, are
+// evaluated with the names of the environment variables and the directory
+// they run with on the host. Denied commands fail with
+// ExitCodeNotExecutable.
+func policyCommandHandler(p PolicyEvaluator, hc *HostCommandConfig, next commandHandler) commandHandler {
+	return func(ctx context.Context, name string, args []string, stdin []byte) (bool, CommandResult) {
+		in := PolicyInput{Command: name, Args: args}
+		dir := ""
+		if hc != nil && hc.allows(name) {
+			in.Env = envNames(os.Environ())
+			dir = hc.Dir
+			if dir == "" {
+				dir, _ = os.Getwd()
+			}
+		}
+		in.Paths = argPaths(dir, args)
+
+		if err := evaluatePolicy(ctx, p, in); err != nil {
+			return true, CommandResult{
+				ExitCode: ExitCodeNotExecutable,
+				Stderr:   []byte(fmt.Sprintf("%s: %v\n", name, err)),
+			}
+		}
+		return next(ctx, name, args, stdin)
+	}
+}
+
+// envNames returns the names of the variables in env, a list of
+// "name=value" entries.
+func envNames(env []string) []string {
+	names := make([]string, len(env))
+	for i, kv := range env {
+		names[i], _, _ = strings.Cut(kv, "=")
+	}
+	return names
+}
+
+// argPaths returns the arguments in args that look like paths, joined to dir
+// if they are relative and dir is set. Options and URLs are skipped.
+func argPaths(dir string, args []string) []string {
+	var paths []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "-"), strings.Contains(arg, "://"):
+			continue
+		case !strings.ContainsRune(arg, '/') && arg != "." && arg != "..":
+			continue
+		}
+		if dir != "" && !filepath.IsAbs(arg) {
+			arg = filepath.Join(dir, arg)
+		}
+		paths = append(paths, filepath.Clean(arg))
+	}
+	return paths
+}
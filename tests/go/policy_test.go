@@ -0,0 +1,114 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEvaluatePolicy(t *testing.T) {
+	ctx := context.Background()
+	allow := PolicyFunc(func(context.Context, PolicyInput) (PolicyDecision, error) {
+		return PolicyDecision{Allow: true}, nil
+	})
+	if err := evaluatePolicy(ctx, allow, PolicyInput{}); err != nil {
+		t.Errorf("allowing policy error = %v", err)
+	}
+
+	for _, p := range []PolicyFunc{
+		func(context.Context, PolicyInput) (PolicyDecision, error) { return PolicyDecision{}, nil },
+		func(context.Context, PolicyInput) (PolicyDecision, error) {
+			return PolicyDecision{Reason: "no"}, nil
+		},
+		func(context.Context, PolicyInput) (PolicyDecision, error) {
+			return PolicyDecision{Allow: true}, errors.New("engine down")
+		},
+	} {
+		if err := evaluatePolicy(ctx, p, PolicyInput{}); !errors.Is(err, ErrPolicyDenied) {
+			t.Errorf("evaluatePolicy() error = %v, want ErrPolicyDenied", err)
+		}
+	}
+}
+
+func TestArgPaths(t *testing.T) {
+	got := argPaths("/work", []string{"-C", "sub/dir", "/etc/passwd", "plain", "--file=x/y", "https://example.com/a", ".."})
+	want := []string{"/work/sub/dir", "/etc/passwd", "/"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("argPaths() = %q, want %q", got, want)
+	}
+	if got := argPaths("", []string{"a/b"}); !reflect.DeepEqual(got, []string{"a/b"}) {
+		t.Errorf("argPaths(no dir) = %q", got)
+	}
+}
+
+func TestPolicyCommandHandler(t *testing.T) {
+	var inputs []PolicyInput
+	p := PolicyFunc(func(_ context.Context, in PolicyInput) (PolicyDecision, error) {
+		inputs = append(inputs, in)
+		return PolicyDecision{Allow: in.Command == "git", Reason: "only git"}, nil
+	})
+	var ran []string
+	next := func(_ context.Context, name string, _ []string, _ []byte) (bool, CommandResult) {
+		ran = append(ran, name)
+		return true, CommandResult{}
+	}
+	h := policyCommandHandler(p, &HostCommandConfig{Allow: []string{"git"}, Dir: "/repo"}, next)
+
+	if handled, result := h(context.Background(), "git", []string{"log", "src/"}, nil); !handled || result.ExitCode != 0 {
+		t.Errorf("allowed command: handled %v, exit %d", handled, result.ExitCode)
+	}
+	handled, result := h(context.Background(), "curl", []string{"http://example.com/x"}, nil)
+	if !handled || result.ExitCode != ExitCodeNotExecutable || !strings.Contains(string(result.Stderr), "only git") {
+		t.Errorf("denied command: handled %v, exit %d, stderr %q", handled, result.ExitCode, result.Stderr)
+	}
+
+	if !reflect.DeepEqual(ran, []string{"git"}) {
+		t.Errorf("ran %q, want only git", ran)
+	}
+	if len(inputs) != 2 {
+		t.Fatalf("policy asked %d times, want 2", len(inputs))
+	}
+	if inputs[0].Env == nil || !reflect.DeepEqual(inputs[0].Paths, []string{"/repo/src"}) {
+		t.Errorf("host command input = %+v", inputs[0])
+	}
+	for _, name := range inputs[0].Env {
+		if strings.Contains(name, "=") {
+			t.Errorf("host command input has the value of %q", name)
+		}
+	}
+	if inputs[1].Env != nil {
+		t.Error("command not run on the host was given the host environment")
+	}
+}
+
+func TestPolicyDeniesScript(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	p := PolicyFunc(func(_ context.Context, in PolicyInput) (PolicyDecision, error) {
+		return PolicyDecision{Allow: !strings.Contains(in.Script, "forbidden")}, nil
+	})
+	exec, err := New(WithEmbedded(), WithPolicy(p), WithInitScript("forbidden() { :; }"))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	if _, err := exec.Execute("echo ok"); err != nil {
+		t.Errorf("Execute(allowed) error: %v", err)
+	}
+	if _, err := exec.Execute("forbidden"); !errors.Is(err, ErrPolicyDenied) {
+		t.Errorf("Execute(denied) error = %v, want ErrPolicyDenied", err)
+	}
+	if _, err := exec.ExecuteContext(context.Background(), "forbidden"); !errors.Is(err, ErrPolicyDenied) {
+		t.Errorf("ExecuteContext(denied) error = %v, want ErrPolicyDenied", err)
+	}
+}
+
+func TestEnvNames(t *testing.T) {
+	got := envNames([]string{"HOME=/root", "TOKEN=a=b", "EMPTY="})
+	if want := []string{"HOME", "TOKEN", "EMPTY"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("envNames() = %q, want %q", got, want)
+	}
+}
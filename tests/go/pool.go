@@ -0,0 +1,407 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is returned by Pool.Get once the pool is closed.
+var ErrPoolClosed = errors.New("pool closed")
+
+// Priority orders executions waiting for a Pool executor or a Runner's
+// queue: higher priorities are served first, and equal ones in the order
+// they arrived. Any value may be used; the named levels are conventions.
+type Priority int
+
+const (
+	// PriorityLow is for bulk work, such as batch jobs, that may wait.
+	PriorityLow Priority = -1
+	// PriorityNormal is the default.
+	PriorityNormal Priority = 0
+	// PriorityHigh is for work a user is waiting on.
+	PriorityHigh Priority = 1
+)
+
+// EvictReason says why a Pool closed an idle executor.
+type EvictReason int
+
+const (
+	// EvictIdleTimeout means the executor sat unused for
+	// PoolOptions.IdleTimeout.
+	EvictIdleTimeout EvictReason = iota
+	// EvictMaxIdle means the executor was returned while PoolOptions.MaxIdle
+	// others were already idle.
+	EvictMaxIdle
+	// EvictPoolClosed means the pool was closed.
+	EvictPoolClosed
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictIdleTimeout:
+		return "idle-timeout"
+	case EvictMaxIdle:
+		return "max-idle"
+	case EvictPoolClosed:
+		return "pool-closed"
+	default:
+		return "unknown"
+	}
+}
+
+// PoolOptions configures a Pool.
+type PoolOptions struct {
+	// MaxIdle caps the executors kept for reuse; one returned beyond the cap
+	// is closed at once. Zero means no cap.
+	MaxIdle int
+	// IdleTimeout closes executors left unused for this long, checked in
+	// the background. Zero keeps them until the pool is closed.
+	IdleTimeout time.Duration
+	// OnEvict, if set, is called for every executor the pool closes, just
+	// before it is closed. It must not keep exec.
+	OnEvict func(exec ShellExecutor, reason EvictReason)
+	// MaxActive caps the executors handed out at once. Acquire then waits
+	// for one to be returned, serving waiters by Priority, so batch work
+	// sharing the pool with interactive work cannot take every executor
+	// ahead of it. Zero means no cap.
+	MaxActive int
+	// Quotas, if set, meters the executions of ExecuteWithOptions by their
+	// ExecOptions.QuotaKey, refusing them with an error wrapping
+	// ErrQuotaExceeded before they wait for an executor. Executions without
+	// a key, and executors taken with Get or Acquire, are not metered.
+	Quotas *QuotaManager
+}
+
+// Pool reuses executors across short-lived users, so each does not pay for
+// creating one. Idle executors are closed by MaxIdle and IdleTimeout rather
+// than kept forever, freeing their native memory after a burst.
+//
+// A Pool is itself a ShellExecutor running each script on an executor
+// acquired for the call, so it can be given to a Runner or Scheduler.
+//
+// A Pool is safe for concurrent use.
+type Pool struct {
+	newExec func() (ShellExecutor, error)
+	opts    PoolOptions
+
+	mu      sync.Mutex
+	idle    []pooledExecutor // oldest first
+	active  int              // handed out, or being created
+	waiters []*poolWaiter    // highest priority first, then oldest first
+	closed  bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+var _ ShellExecutor = (*Pool)(nil)
+
+// poolWaiter is an Acquire call waiting for MaxActive to allow it.
+type poolWaiter struct {
+	priority Priority
+	// grant receives an executor, or nil to create one, once the waiter
+	// holds a slot, or an error if the pool is closed first.
+	grant chan poolGrant
+}
+
+// poolGrant is what a waiting Acquire is handed.
+type poolGrant struct {
+	exec ShellExecutor
+	err  error
+}
+
+// pooledExecutor is an idle executor and when it was returned.
+type pooledExecutor struct {
+	exec  ShellExecutor
+	since time.Time
+}
+
+// NewPool returns a Pool creating executors with newExec as needed. Close it
+// to stop the background reaping and close the idle executors.
+func NewPool(newExec func() (ShellExecutor, error), opts PoolOptions) *Pool {
+	p := &Pool{newExec: newExec, opts: opts, done: make(chan struct{})}
+	if opts.IdleTimeout > 0 {
+		p.stop = make(chan struct{})
+		go p.reapLoop()
+	} else {
+		close(p.done)
+	}
+	return p
+}
+
+// Get returns the most recently used idle executor, or a new one if none
+// is idle. Return it with Put when done. It is Acquire at PriorityNormal,
+// waiting without a deadline when MaxActive executors are in use.
+func (p *Pool) Get() (ShellExecutor, error) {
+	return p.Acquire(context.Background(), PriorityNormal)
+}
+
+// Acquire is Get, waiting behind callers of higher priority, and until ctx
+// is done, when MaxActive executors are in use.
+func (p *Pool) Acquire(ctx context.Context, priority Priority) (ShellExecutor, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	if len(p.waiters) == 0 && (p.opts.MaxActive <= 0 || p.active < p.opts.MaxActive) {
+		p.active++
+		exec := p.popIdle()
+		p.mu.Unlock()
+		return p.create(exec)
+	}
+	w := &poolWaiter{priority: priority, grant: make(chan poolGrant, 1)}
+	i := len(p.waiters)
+	for i > 0 && p.waiters[i-1].priority < priority {
+		i--
+	}
+	p.waiters = append(p.waiters, nil)
+	copy(p.waiters[i+1:], p.waiters[i:])
+	p.waiters[i] = w
+	p.mu.Unlock()
+
+	select {
+	case g := <-w.grant:
+		if g.err != nil {
+			return nil, g.err
+		}
+		return p.create(g.exec)
+	case <-ctx.Done():
+	}
+	p.mu.Lock()
+	for i, other := range p.waiters {
+		if other == w {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			p.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+	p.mu.Unlock()
+	// The waiter was served as ctx ended; pass its slot on.
+	if g := <-w.grant; g.err == nil {
+		p.release(g.exec)
+	}
+	return nil, ctx.Err()
+}
+
+// create returns exec, or a new executor if exec is nil, giving up the
+// caller's slot if that fails.
+func (p *Pool) create(exec ShellExecutor) (ShellExecutor, error) {
+	if exec != nil {
+		return exec, nil
+	}
+	exec, err := p.newExec()
+	if err != nil {
+		p.release(nil)
+		return nil, err
+	}
+	return exec, nil
+}
+
+// Put returns exec to the pool for reuse, handing it straight to the first
+// waiting Acquire if there is one. Executors returned to a closed or full
+// pool are closed.
+func (p *Pool) Put(exec ShellExecutor) {
+	p.release(exec)
+}
+
+// release gives up a slot, with the executor that held it or nil if none
+// was created.
+func (p *Pool) release(exec ShellExecutor) {
+	p.mu.Lock()
+	if len(p.waiters) > 0 {
+		w := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		if exec == nil {
+			exec = p.popIdle()
+		}
+		w.grant <- poolGrant{exec: exec}
+		p.mu.Unlock()
+		return
+	}
+	if p.active > 0 {
+		p.active--
+	}
+	if exec == nil {
+		p.mu.Unlock()
+		return
+	}
+	if p.closed {
+		p.mu.Unlock()
+		p.evict(EvictPoolClosed, exec)
+		return
+	}
+	p.idle = append(p.idle, pooledExecutor{exec: exec, since: time.Now()})
+	var evicted []ShellExecutor
+	if p.opts.MaxIdle > 0 && len(p.idle) > p.opts.MaxIdle {
+		evicted = p.take(len(p.idle) - p.opts.MaxIdle)
+	}
+	p.mu.Unlock()
+	p.evict(EvictMaxIdle, evicted...)
+}
+
+// popIdle removes and returns the most recently used idle executor, or nil
+// if none is idle. The caller holds p.mu.
+func (p *Pool) popIdle() ShellExecutor {
+	n := len(p.idle)
+	if n == 0 {
+		return nil
+	}
+	exec := p.idle[n-1].exec
+	p.idle[n-1] = pooledExecutor{}
+	p.idle = p.idle[:n-1]
+	return exec
+}
+
+// Idle returns the number of executors waiting for reuse.
+func (p *Pool) Idle() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+// Close closes the idle executors and stops reaping, returning the errors
+// closing them. Executors still in use are closed when they are returned,
+// and waiting Acquire calls fail with ErrPoolClosed.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.done
+		return nil
+	}
+	p.closed = true
+	for _, w := range p.waiters {
+		w.grant <- poolGrant{err: ErrPoolClosed}
+	}
+	p.waiters = nil
+	evicted := p.take(len(p.idle))
+	if p.stop != nil {
+		close(p.stop)
+	}
+	p.mu.Unlock()
+
+	<-p.done
+	return p.evict(EvictPoolClosed, evicted...)
+}
+
+// reapLoop closes executors idle for longer than IdleTimeout until the pool
+// is closed.
+func (p *Pool) reapLoop() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(max(p.opts.IdleTimeout/2, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			p.reap(now)
+		}
+	}
+}
+
+// reap closes executors returned before now minus IdleTimeout.
+func (p *Pool) reap(now time.Time) {
+	p.mu.Lock()
+	n := 0
+	for n < len(p.idle) && now.Sub(p.idle[n].since) >= p.opts.IdleTimeout {
+		n++
+	}
+	evicted := p.take(n)
+	p.mu.Unlock()
+	p.evict(EvictIdleTimeout, evicted...)
+}
+
+// take removes the n oldest idle executors. The caller holds p.mu.
+func (p *Pool) take(n int) []ShellExecutor {
+	if n == 0 {
+		return nil
+	}
+	taken := make([]ShellExecutor, n)
+	for i := range taken {
+		taken[i] = p.idle[i].exec
+	}
+	rest := copy(p.idle, p.idle[n:])
+	clear(p.idle[rest:])
+	p.idle = p.idle[:rest]
+	return taken
+}
+
+// evict reports and closes execs, returning the errors closing them.
+func (p *Pool) evict(reason EvictReason, execs ...ShellExecutor) error {
+	var errs []error
+	for _, exec := range execs {
+		if p.opts.OnEvict != nil {
+			p.opts.OnEvict(exec, reason)
+		}
+		if err := exec.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Execute implements ShellExecutor with ExecuteWithOptions.
+func (p *Pool) Execute(script string) (*Result, error) {
+	return p.ExecuteWithOptions(context.Background(), script, ExecOptions{})
+}
+
+// ExecuteContext implements ShellExecutor with ExecuteWithOptions.
+func (p *Pool) ExecuteContext(ctx context.Context, script string) (*Result, error) {
+	return p.ExecuteWithOptions(ctx, script, ExecOptions{})
+}
+
+// ExecuteWithStdin implements ShellExecutor with ExecuteWithOptions.
+func (p *Pool) ExecuteWithStdin(script string, stdin []byte) (*Result, error) {
+	return p.ExecuteWithOptions(context.Background(), script, ExecOptions{Stdin: stdin})
+}
+
+// optionsExecutor is implemented by executors taking ExecOptions.
+type optionsExecutor interface {
+	ExecuteWithOptions(ctx context.Context, script string, opts ExecOptions) (*Result, error)
+}
+
+var _ optionsExecutor = (*Executor)(nil)
+
+// ExecuteWithOptions acquires an executor at opts.Priority, runs script on
+// it and returns it to the pool. An executor other than Executor, without
+// ExecuteWithOptions, is given only opts.Stdin and opts.Limits.
+func (p *Pool) ExecuteWithOptions(ctx context.Context, script string, opts ExecOptions) (*Result, error) {
+	quotas := p.opts.Quotas
+	if opts.QuotaKey == "" {
+		quotas = nil
+	}
+	uncharge := func() {}
+	if quotas != nil {
+		cancel, err := quotas.admit(opts.QuotaKey)
+		if err != nil {
+			return nil, err
+		}
+		uncharge = cancel
+	}
+	exec, err := p.Acquire(ctx, opts.Priority)
+	if err != nil {
+		// An execution that never started is not charged.
+		uncharge()
+		return nil, err
+	}
+	defer p.Put(exec)
+	if err := ctx.Err(); err != nil {
+		uncharge()
+		return nil, err
+	}
+
+	var result *Result
+	if e, ok := exec.(optionsExecutor); ok {
+		result, err = e.ExecuteWithOptions(ctx, script, opts)
+	} else {
+		result, err = runTask(ctx, exec, &Task{Script: script, Stdin: opts.Stdin, Limits: opts.Limits})
+	}
+	if quotas != nil {
+		quotas.Record(opts.QuotaKey, result)
+	}
+	return result, err
+}
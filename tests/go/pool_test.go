@@ -0,0 +1,305 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pooledTestExecutor is a ShellExecutor that records being closed.
+type pooledTestExecutor struct {
+	id     int
+	closed atomic.Bool
+}
+
+func (p *pooledTestExecutor) Execute(string) (*Result, error) { return &Result{}, nil }
+
+func (p *pooledTestExecutor) ExecuteContext(context.Context, string) (*Result, error) {
+	return &Result{}, nil
+}
+
+func (p *pooledTestExecutor) ExecuteWithStdin(string, []byte) (*Result, error) {
+	return &Result{}, nil
+}
+
+func (p *pooledTestExecutor) Close() error {
+	p.closed.Store(true)
+	return nil
+}
+
+// evictions records a Pool's OnEvict calls.
+type evictions struct {
+	mu      sync.Mutex
+	reasons map[int]EvictReason
+}
+
+func (e *evictions) record(exec ShellExecutor, reason EvictReason) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if exec.(*pooledTestExecutor).closed.Load() {
+		panic("OnEvict called after Close")
+	}
+	e.reasons[exec.(*pooledTestExecutor).id] = reason
+}
+
+func (e *evictions) get() map[int]EvictReason {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	got := make(map[int]EvictReason, len(e.reasons))
+	for k, v := range e.reasons {
+		got[k] = v
+	}
+	return got
+}
+
+func newTestPool(opts PoolOptions) (*Pool, *evictions) {
+	ev := &evictions{reasons: map[int]EvictReason{}}
+	opts.OnEvict = ev.record
+	var next int
+	var mu sync.Mutex
+	return NewPool(func() (ShellExecutor, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		next++
+		return &pooledTestExecutor{id: next}, nil
+	}, opts), ev
+}
+
+func TestPoolReuse(t *testing.T) {
+	p, _ := newTestPool(PoolOptions{})
+	defer p.Close()
+
+	a, _ := p.Get()
+	b, _ := p.Get()
+	if a == b {
+		t.Fatal("Get() returned the same executor twice")
+	}
+	p.Put(a)
+	p.Put(b)
+	if p.Idle() != 2 {
+		t.Errorf("Idle() = %d, want 2", p.Idle())
+	}
+	if got, _ := p.Get(); got != b {
+		t.Error("Get() did not return the most recently used executor")
+	}
+}
+
+func TestPoolMaxIdle(t *testing.T) {
+	p, ev := newTestPool(PoolOptions{MaxIdle: 1})
+	defer p.Close()
+
+	a, _ := p.Get()
+	b, _ := p.Get()
+	p.Put(a)
+	p.Put(b)
+
+	if p.Idle() != 1 {
+		t.Errorf("Idle() = %d, want 1", p.Idle())
+	}
+	if !a.(*pooledTestExecutor).closed.Load() {
+		t.Error("oldest executor beyond MaxIdle was not closed")
+	}
+	if got := ev.get(); len(got) != 1 || got[1] != EvictMaxIdle {
+		t.Errorf("evictions = %v, want executor 1 for max-idle", got)
+	}
+}
+
+func TestPoolIdleTimeout(t *testing.T) {
+	p, ev := newTestPool(PoolOptions{IdleTimeout: 20 * time.Millisecond})
+	defer p.Close()
+
+	a, _ := p.Get()
+	p.Put(a)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Idle() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if p.Idle() != 0 {
+		t.Fatal("idle executor was not reaped")
+	}
+	if !a.(*pooledTestExecutor).closed.Load() {
+		t.Error("reaped executor was not closed")
+	}
+	if got := ev.get(); got[1] != EvictIdleTimeout {
+		t.Errorf("evictions = %v, want executor 1 for idle-timeout", got)
+	}
+}
+
+func TestPoolReapKeepsRecent(t *testing.T) {
+	p, _ := newTestPool(PoolOptions{})
+	defer p.Close()
+	p.opts.IdleTimeout = time.Minute
+
+	a, _ := p.Get()
+	b, _ := p.Get()
+	p.Put(a)
+	p.Put(b)
+	p.mu.Lock()
+	p.idle[0].since = time.Now().Add(-2 * time.Minute)
+	p.mu.Unlock()
+
+	p.reap(time.Now())
+	if p.Idle() != 1 {
+		t.Fatalf("Idle() = %d after reap, want 1", p.Idle())
+	}
+	if got, _ := p.Get(); got != b {
+		t.Error("reap closed the recently returned executor")
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	p, ev := newTestPool(PoolOptions{IdleTimeout: time.Minute})
+
+	a, _ := p.Get()
+	b, _ := p.Get()
+	p.Put(a)
+	p.Close()
+	p.Close()
+
+	if _, err := p.Get(); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Get() after Close error = %v, want ErrPoolClosed", err)
+	}
+	p.Put(b)
+	if !a.(*pooledTestExecutor).closed.Load() || !b.(*pooledTestExecutor).closed.Load() {
+		t.Error("executors were not closed with the pool")
+	}
+	if got := ev.get(); got[1] != EvictPoolClosed || got[2] != EvictPoolClosed {
+		t.Errorf("evictions = %v, want both for pool-closed", got)
+	}
+}
+
+// closeErrExecutor fails to close.
+type closeErrExecutor struct{ pooledTestExecutor }
+
+func (c *closeErrExecutor) Close() error { return errors.New("close failed") }
+
+func TestPoolCloseError(t *testing.T) {
+	p := NewPool(func() (ShellExecutor, error) { return &closeErrExecutor{}, nil }, PoolOptions{})
+	exec, _ := p.Get()
+	p.Put(exec)
+	if err := p.Close(); err == nil || err.Error() != "close failed" {
+		t.Errorf("Close() = %v, want the executor's error", err)
+	}
+}
+
+func TestEvictReasonString(t *testing.T) {
+	if got := EvictIdleTimeout.String(); got != "idle-timeout" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := EvictReason(99).String(); got != "unknown" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+// waitForWaiters waits until n Acquire calls are waiting on p.
+func waitForWaiters(t *testing.T, p *Pool, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		p.mu.Lock()
+		got := len(p.waiters)
+		p.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d Acquire calls did not start waiting", n)
+}
+
+func TestPoolMaxActivePriority(t *testing.T) {
+	p, _ := newTestPool(PoolOptions{MaxActive: 1})
+	defer p.Close()
+
+	a, _ := p.Get()
+	order := make(chan Priority, 3)
+	acquire := func(priority Priority) {
+		exec, err := p.Acquire(context.Background(), priority)
+		if err != nil {
+			t.Errorf("Acquire(%d) error: %v", priority, err)
+			return
+		}
+		order <- priority
+		p.Put(exec)
+	}
+	go acquire(PriorityLow)
+	waitForWaiters(t, p, 1)
+	go acquire(PriorityNormal)
+	waitForWaiters(t, p, 2)
+	go acquire(PriorityHigh)
+	waitForWaiters(t, p, 3)
+
+	p.Put(a)
+	for _, want := range []Priority{PriorityHigh, PriorityNormal, PriorityLow} {
+		if got := <-order; got != want {
+			t.Errorf("served priority %d, want %d", got, want)
+		}
+	}
+	if p.Idle() != 1 {
+		t.Errorf("Idle() = %d, want the one executor", p.Idle())
+	}
+}
+
+func TestPoolAcquireCanceled(t *testing.T) {
+	p, _ := newTestPool(PoolOptions{MaxActive: 1})
+	a, _ := p.Get()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.Acquire(ctx, PriorityHigh); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire() error = %v, want deadline exceeded", err)
+	}
+	waitForWaiters(t, p, 0)
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := p.Acquire(context.Background(), PriorityNormal)
+		errc <- err
+	}()
+	waitForWaiters(t, p, 1)
+	p.Close()
+	if err := <-errc; !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("waiting Acquire() error = %v, want ErrPoolClosed", err)
+	}
+	p.Put(a)
+}
+
+func TestPoolCreateErrorFreesSlot(t *testing.T) {
+	fail := true
+	p := NewPool(func() (ShellExecutor, error) {
+		if fail {
+			return nil, errors.New("no memory")
+		}
+		return &pooledTestExecutor{}, nil
+	}, PoolOptions{MaxActive: 1})
+	defer p.Close()
+
+	if _, err := p.Get(); err == nil {
+		t.Fatal("Get() succeeded")
+	}
+	fail = false
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := p.Acquire(ctx, PriorityNormal); err != nil {
+		t.Errorf("Acquire() after a failed create error: %v", err)
+	}
+}
+
+func TestPoolExecute(t *testing.T) {
+	p, _ := newTestPool(PoolOptions{MaxActive: 1})
+	defer p.Close()
+
+	if _, err := p.ExecuteWithOptions(context.Background(), "true", ExecOptions{Priority: PriorityHigh}); err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+	if _, err := p.ExecuteWithStdin("cat", []byte("x")); err != nil {
+		t.Fatalf("ExecuteWithStdin() error: %v", err)
+	}
+	if p.Idle() != 1 {
+		t.Errorf("Idle() = %d, want the executor returned", p.Idle())
+	}
+}
@@ -0,0 +1,99 @@
+package conch
+
+// Profile is a named preset of sandbox settings, so callers can pick a
+// level of restriction instead of assembling limits by hand. Select one with
+// WithProfile; options after it adjust the preset.
+//
+// A profile covers what the bindings can restrict: resource limits, the
+// filesystem quota, the size of /tmp, the network and the commands scripts
+// may run. Host commands are never enabled by a profile; they stay opt-in
+// through WithHostCommands.
+type Profile struct {
+	// Name identifies the profile, such as "strict".
+	Name string
+	// Limits are the resource limits for executions not given their own.
+	Limits ResourceLimits
+	// Tmp configures the /tmp of every execution.
+	Tmp TmpConfig
+	// NetworkPolicy, if set, decides which connections spawned commands
+	// may open; see Config.NetworkPolicy.
+	NetworkPolicy *NetworkPolicy
+	// AllowedCommands, if not nil, lists the only commands scripts may
+	// run; see Config.AllowedCommands.
+	AllowedCommands []string
+}
+
+var (
+	// ProfileStrict suits untrusted scripts: short runs, little memory and
+	// output, a few megabytes of files, no network, and only the commands
+	// that work on text and files. Calling host tools with tool is not
+	// allowed.
+	ProfileStrict = Profile{
+		Name: "strict",
+		Limits: ResourceLimits{
+			MaxCPUMs:       1000,             // 1 second CPU
+			MaxMemoryBytes: 32 * 1024 * 1024, // 32 MB
+			MaxOutputBytes: 256 * 1024,       // 256 KB output
+			TimeoutMs:      5000,             // 5 second timeout
+			MaxCallDepth:   50,               // 50 nested function calls
+			MaxFSBytes:     8 * 1024 * 1024,  // 8 MB of files
+			MaxScriptBytes: 256 * 1024,       // 256 KB script
+		},
+		Tmp:           TmpConfig{MaxBytes: 4 * 1024 * 1024}, // 4 MB in /tmp
+		NetworkPolicy: &NetworkPolicy{DenyByDefault: true},
+		AllowedCommands: []string{
+			"cat", "cp", "csv", "grep", "head", "jq", "json2yaml", "ls",
+			"mkdir", "mv", "printf", "rm", "sort", "tail", "toml2json",
+			"touch", "wc", "yaml2json",
+		},
+	}
+
+	// ProfileStandard is DefaultLimits with a filesystem quota and a script
+	// size limit, for scripts that are reviewed but not fully trusted.
+	ProfileStandard = Profile{
+		Name: "standard",
+		Limits: ResourceLimits{
+			MaxCPUMs:       5000,             // 5 seconds CPU
+			MaxMemoryBytes: 64 * 1024 * 1024, // 64 MB
+			MaxOutputBytes: 1024 * 1024,      // 1 MB output
+			TimeoutMs:      30000,            // 30 second timeout
+			MaxCallDepth:   100,              // 100 nested function calls
+			MaxFSBytes:     64 * 1024 * 1024, // 64 MB of files
+			MaxScriptBytes: 1024 * 1024,      // 1 MB script
+		},
+		Tmp: TmpConfig{MaxBytes: 32 * 1024 * 1024}, // 32 MB in /tmp
+	}
+
+	// ProfilePermissive suits trusted, long-running scripts: generous
+	// limits and no filesystem quota.
+	ProfilePermissive = Profile{
+		Name: "permissive",
+		Limits: ResourceLimits{
+			MaxCPUMs:       60000,             // 1 minute CPU
+			MaxMemoryBytes: 512 * 1024 * 1024, // 512 MB
+			MaxOutputBytes: 16 * 1024 * 1024,  // 16 MB output
+			TimeoutMs:      300000,            // 5 minute timeout
+			MaxCallDepth:   1000,              // 1000 nested function calls
+			MaxScriptBytes: 16 * 1024 * 1024,  // 16 MB script
+		},
+	}
+)
+
+// WithProfile applies the limits, /tmp settings, network policy and allowed
+// commands of p, replacing any set before it.
+func WithProfile(p Profile) Option {
+	return optionFunc(func(cfg *Config) {
+		limits := p.Limits
+		cfg.Limits = &limits
+		cfg.Tmp = p.Tmp
+		cfg.NetworkPolicy = nil
+		if p.NetworkPolicy != nil {
+			policy := *p.NetworkPolicy
+			cfg.NetworkPolicy = &policy
+		}
+		cfg.AllowedCommands = nil
+		if p.AllowedCommands != nil {
+			cfg.AllowedCommands = append([]string{}, p.AllowedCommands...)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package conch
+
+import "testing"
+
+func TestWithProfile(t *testing.T) {
+	var cfg Config
+	for _, opt := range []Option{WithTmp(TmpConfig{RetainDir: "/x"}), WithProfile(ProfileStrict)} {
+		opt.apply(&cfg)
+	}
+	if cfg.Limits == nil || *cfg.Limits != ProfileStrict.Limits {
+		t.Errorf("Limits = %v, want %v", cfg.Limits, ProfileStrict.Limits)
+	}
+	if cfg.Tmp != ProfileStrict.Tmp {
+		t.Errorf("Tmp = %+v, want %+v", cfg.Tmp, ProfileStrict.Tmp)
+	}
+	if cfg.NetworkPolicy == nil || !cfg.NetworkPolicy.DenyByDefault {
+		t.Errorf("NetworkPolicy = %+v, want DenyByDefault", cfg.NetworkPolicy)
+	}
+	if len(cfg.AllowedCommands) == 0 {
+		t.Error("AllowedCommands is empty")
+	}
+	// The preset is copied, not shared.
+	cfg.AllowedCommands[0] = "tool"
+	if ProfileStrict.AllowedCommands[0] == "tool" {
+		t.Error("changing the Config changed ProfileStrict")
+	}
+	WithProfile(ProfileStandard).apply(&cfg)
+	if cfg.NetworkPolicy != nil || cfg.AllowedCommands != nil {
+		t.Error("ProfileStandard kept the network policy and commands of ProfileStrict")
+	}
+
+	// Options after a profile adjust it.
+	WithLimits(DefaultLimits()).apply(&cfg)
+	if *cfg.Limits != DefaultLimits() {
+		t.Errorf("Limits = %v after WithLimits", cfg.Limits)
+	}
+}
+
+func TestProfilesOrdered(t *testing.T) {
+	profiles := []Profile{ProfileStrict, ProfileStandard, ProfilePermissive}
+	for i := 1; i < len(profiles); i++ {
+		tighter, looser := profiles[i-1].Limits, profiles[i].Limits
+		if tighter.MaxCPUMs > looser.MaxCPUMs || tighter.MaxMemoryBytes > looser.MaxMemoryBytes ||
+			tighter.MaxOutputBytes > looser.MaxOutputBytes || tighter.TimeoutMs > looser.TimeoutMs {
+			t.Errorf("%s is looser than %s", profiles[i-1].Name, profiles[i].Name)
+		}
+	}
+	if ProfileStandard.Limits.MaxCPUMs != DefaultLimits().MaxCPUMs {
+		t.Error("ProfileStandard does not start from DefaultLimits")
+	}
+}
+
+func TestProfileStrict(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded(), WithProfile(ProfileStrict))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	if exec.Limits() != ProfileStrict.Limits {
+		t.Errorf("Limits() = %+v, want %+v", exec.Limits(), ProfileStrict.Limits)
+	}
+	result, err := exec.Execute("head -c 5000000 /dev/zero > /tmp/big && echo ok")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if string(result.Stdout) == "ok\n" {
+		t.Error("strict profile allowed 5 MB in /tmp")
+	}
+
+	result, err = exec.Execute(`echo '{"a":1}' | jq -c .a; tool list; echo "tool=$?"`)
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if got, want := string(result.Stdout), "1\ntool=127\n"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,139 @@
+package conch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ProgramSource is the source of a Program: shell functions, some of which
+// are entrypoints that Call runs. Each value is a function body, as for
+// DefineFunction, so it reads its arguments from "$@".
+type ProgramSource struct {
+	// Entrypoints are the functions Call may run, keyed by name.
+	Entrypoints map[string]string
+	// Functions are helpers the entrypoints and each other can call, keyed
+	// by name. Call does not run them directly.
+	Functions map[string]string
+}
+
+// Program is a library of shell functions loaded once onto an executor and
+// called by name, so each call sends only the entrypoint and its arguments
+// rather than the library's full source:
+//
+//	prog, err := exec.LoadProgram(conch.ProgramSource{
+//		Entrypoints: map[string]string{"deploy": `check "$1" && echo "deploying $1"`},
+//		Functions:   map[string]string{"check": `test -n "$1"`},
+//	})
+//	result, err := prog.Call(ctx, "deploy", "web")
+//
+// The functions are defined on the executor with DefineFunction, so scripts
+// run on it, including through a Session, can call them too. Loading a
+// function with the name of one already defined replaces it.
+type Program struct {
+	exec        *Executor
+	entrypoints []string
+	functions   []string
+}
+
+// LoadProgram defines src's functions on e and runs an empty script once, so
+// a function that does not parse is reported here rather than by the first
+// call. On error no function of src is left defined.
+func (e *Executor) LoadProgram(src ProgramSource) (_ *Program, err error) {
+	if len(src.Entrypoints) == 0 {
+		return nil, errors.New("program has no entrypoints")
+	}
+	p := &Program{exec: e}
+	for name := range src.Entrypoints {
+		if _, ok := src.Functions[name]; ok {
+			return nil, fmt.Errorf("%s is both an entrypoint and a function", name)
+		}
+		p.entrypoints = append(p.entrypoints, name)
+	}
+	for name := range src.Functions {
+		p.functions = append(p.functions, name)
+	}
+	sort.Strings(p.entrypoints)
+	sort.Strings(p.functions)
+
+	defer func() {
+		if err != nil {
+			_ = p.Unload()
+		}
+	}()
+	for _, name := range p.functions {
+		if err := e.DefineFunction(name, src.Functions[name]); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range p.entrypoints {
+		if err := e.DefineFunction(name, src.Entrypoints[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := e.Execute("true")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load program: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("failed to load program: exit code %d: %s",
+			result.ExitCode, bytes.TrimSpace(result.Stderr))
+	}
+	return p, nil
+}
+
+// Entrypoints returns the names Call accepts, sorted.
+func (p *Program) Entrypoints() []string {
+	return append([]string(nil), p.entrypoints...)
+}
+
+// Call runs the entrypoint name with args as its arguments, stopping it
+// when ctx is done.
+func (p *Program) Call(ctx context.Context, name string, args ...string) (*Result, error) {
+	return p.CallWithOptions(ctx, name, args, ExecOptions{})
+}
+
+// CallWithOptions is Call with the options of ExecuteWithOptions. The
+// script executed is the quoted call, so an executor restricted by
+// AllowedScriptHashes must allow each call's script.
+func (p *Program) CallWithOptions(ctx context.Context, name string, args []string, opts ExecOptions) (*Result, error) {
+	script, err := p.script(name, args)
+	if err != nil {
+		return nil, err
+	}
+	return p.exec.ExecuteWithOptions(ctx, script, opts)
+}
+
+// script returns the script calling the entrypoint name with args.
+func (p *Program) script(name string, args []string) (string, error) {
+	i := sort.SearchStrings(p.entrypoints, name)
+	if i == len(p.entrypoints) || p.entrypoints[i] != name {
+		return "", fmt.Errorf("program has no entrypoint %q", name)
+	}
+	var sb strings.Builder
+	sb.WriteString(name)
+	for _, arg := range args {
+		sb.WriteByte(' ')
+		sb.WriteString(shellQuote(arg))
+	}
+	return sb.String(), nil
+}
+
+// Unload removes the program's functions from its executor. Calls fail
+// afterwards.
+func (p *Program) Unload() error {
+	var errs []error
+	for _, names := range [][]string{p.entrypoints, p.functions} {
+		for _, name := range names {
+			if err := p.exec.DefineFunction(name, ""); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	p.entrypoints, p.functions = nil, nil
+	return errors.Join(errs...)
+}
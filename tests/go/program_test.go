@@ -0,0 +1,117 @@
+package conch
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProgramScript(t *testing.T) {
+	p := &Program{entrypoints: []string{"deploy", "status"}}
+	script, err := p.script("deploy", []string{"web", "it's"})
+	if err != nil {
+		t.Fatalf("script() error: %v", err)
+	}
+	if want := `deploy 'web' 'it'\''s'`; script != want {
+		t.Errorf("script() = %s, want %s", script, want)
+	}
+	if script, _ := p.script("status", nil); script != "status" {
+		t.Errorf("script() = %s, want status", script)
+	}
+	for _, name := range []string{"helper", "", "zzz"} {
+		if _, err := p.script(name, nil); err == nil {
+			t.Errorf("script(%q) succeeded", name)
+		}
+	}
+}
+
+func TestLoadProgramInvalid(t *testing.T) {
+	e := &Executor{}
+	if _, err := e.LoadProgram(ProgramSource{Functions: map[string]string{"f": "true"}}); err == nil {
+		t.Error("LoadProgram() without entrypoints succeeded")
+	}
+	_, err := e.LoadProgram(ProgramSource{
+		Entrypoints: map[string]string{"f": "true"},
+		Functions:   map[string]string{"f": "false"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "both") {
+		t.Errorf("LoadProgram() with a duplicate name error = %v", err)
+	}
+}
+
+func TestProgram(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	prog, err := exec.LoadProgram(ProgramSource{
+		Entrypoints: map[string]string{
+			"greet": `echo "$(salutation) $1, you said: $2"`,
+			"count": `echo $#`,
+		},
+		Functions: map[string]string{"salutation": `echo hello`},
+	})
+	if err != nil {
+		t.Fatalf("LoadProgram() error: %v", err)
+	}
+	if got := prog.Entrypoints(); !reflect.DeepEqual(got, []string{"count", "greet"}) {
+		t.Errorf("Entrypoints() = %q", got)
+	}
+
+	ctx := context.Background()
+	result, err := prog.Call(ctx, "greet", "world", `it's "$x"`)
+	if err != nil {
+		t.Fatalf("Call() error: %v", err)
+	}
+	if want := "hello world, you said: it's \"$x\"\n"; string(result.Stdout) != want {
+		t.Errorf("stdout = %q, want %q", result.Stdout, want)
+	}
+	if result, _ := prog.Call(ctx, "count", "a b", ""); string(result.Stdout) != "2\n" {
+		t.Errorf("count stdout = %q, want 2", result.Stdout)
+	}
+	if _, err := prog.Call(ctx, "salutation"); err == nil {
+		t.Error("Call() of a helper function succeeded")
+	}
+
+	// Plain scripts on the executor see the program's functions.
+	if result, _ := exec.Execute("salutation"); string(result.Stdout) != "hello\n" {
+		t.Errorf("salutation stdout = %q", result.Stdout)
+	}
+
+	if err := prog.Unload(); err != nil {
+		t.Fatalf("Unload() error: %v", err)
+	}
+	if _, err := prog.Call(ctx, "greet"); err == nil {
+		t.Error("Call() after Unload succeeded")
+	}
+	if result, _ := exec.Execute("salutation"); result.ExitCode == 0 {
+		t.Error("salutation still defined after Unload")
+	}
+}
+
+func TestLoadProgramParseError(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	_, err = exec.LoadProgram(ProgramSource{
+		Entrypoints: map[string]string{"broken": `if then fi (`},
+		Functions:   map[string]string{"fine": `true`},
+	})
+	if err == nil {
+		t.Fatal("LoadProgram() of a broken function succeeded")
+	}
+	// Nothing is left defined, so the executor still works.
+	if result, err := exec.Execute("echo ok"); err != nil || string(result.Stdout) != "ok\n" {
+		t.Errorf("Execute() after failed load = %v, %v", result, err)
+	}
+}
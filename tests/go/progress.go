@@ -0,0 +1,58 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ProgressCommand is the command scripts run to report progress when
+// Config.OnProgress is set:
+//
+//	conch-progress 42 loading rows
+//
+// reports 42 percent with the message "loading rows". The percentage may
+// carry a trailing "%" and is clamped to 0-100.
+const ProgressCommand = "conch-progress"
+
+// ProgressFunc receives progress reported by a script, as a percentage from
+// 0 to 100 and a free-form message. It is called on a native thread while
+// the script waits, so it should return quickly.
+type ProgressFunc func(percent float64, message string)
+
+// progressCommandHandler returns a commandHandler servicing ProgressCommand
+// with fn and passing other commands to next, which may be nil.
+func progressCommandHandler(fn ProgressFunc, next commandHandler) commandHandler {
+	return func(ctx context.Context, name string, args []string, stdin []byte) (bool, CommandResult) {
+		if name != ProgressCommand {
+			if next == nil {
+				return false, CommandResult{}
+			}
+			return next(ctx, name, args, stdin)
+		}
+		percent, message, err := parseProgress(args)
+		if err != nil {
+			return true, CommandResult{
+				ExitCode: ExitCodeUsage,
+				Stderr:   []byte(fmt.Sprintf("%s: %v\nusage: %s PERCENT [MESSAGE...]\n", name, err, name)),
+			}
+		}
+		fn(percent, message)
+		return true, CommandResult{}
+	}
+}
+
+// parseProgress parses ProgressCommand's arguments.
+func parseProgress(args []string) (float64, string, error) {
+	if len(args) == 0 {
+		return 0, "", errors.New("missing percentage")
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(args[0], "%"), 64)
+	if err != nil || math.IsNaN(percent) {
+		return 0, "", fmt.Errorf("invalid percentage %q", args[0])
+	}
+	return min(max(percent, 0), 100), strings.Join(args[1:], " "), nil
+}
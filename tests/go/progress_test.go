@@ -0,0 +1,94 @@
+package conch
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseProgress(t *testing.T) {
+	tests := []struct {
+		args    []string
+		percent float64
+		message string
+	}{
+		{[]string{"42"}, 42, ""},
+		{[]string{"12.5%", "loading", "rows"}, 12.5, "loading rows"},
+		{[]string{"-3"}, 0, ""},
+		{[]string{"250"}, 100, ""},
+	}
+	for _, tt := range tests {
+		percent, message, err := parseProgress(tt.args)
+		if err != nil || percent != tt.percent || message != tt.message {
+			t.Errorf("parseProgress(%q) = %v, %q, %v; want %v, %q", tt.args, percent, message, err, tt.percent, tt.message)
+		}
+	}
+	for _, args := range [][]string{nil, {"half"}, {"NaN"}} {
+		if _, _, err := parseProgress(args); err == nil {
+			t.Errorf("parseProgress(%q) succeeded, want error", args)
+		}
+	}
+}
+
+func TestProgressCommandHandler(t *testing.T) {
+	var got []string
+	h := progressCommandHandler(func(_ float64, message string) {
+		got = append(got, message)
+	}, nil)
+
+	if handled, _ := h(context.Background(), "other", nil, nil); handled {
+		t.Error("handled a command other than conch-progress")
+	}
+	if handled, result := h(context.Background(), ProgressCommand, []string{"50", "halfway"}, nil); !handled || result.ExitCode != 0 {
+		t.Errorf("conch-progress = %v, %+v", handled, result)
+	}
+	if _, result := h(context.Background(), ProgressCommand, nil, nil); result.ExitCode != ExitCodeUsage || !strings.Contains(string(result.Stderr), "usage") {
+		t.Errorf("conch-progress without arguments = %+v", result)
+	}
+	if len(got) != 1 || got[0] != "halfway" {
+		t.Errorf("progress = %q, want [halfway]", got)
+	}
+
+	next := progressCommandHandler(func(float64, string) {}, func(_ context.Context, name string, _ []string, _ []byte) (bool, CommandResult) {
+		return name == "known", CommandResult{ExitCode: 7}
+	})
+	if handled, result := next(context.Background(), "known", nil, nil); !handled || result.ExitCode != 7 {
+		t.Errorf("next handler = %v, %+v", handled, result)
+	}
+}
+
+func TestOnProgress(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	type report struct {
+		percent float64
+		message string
+	}
+	var (
+		mu      sync.Mutex
+		reports []report
+	)
+	exec, err := New(WithEmbedded(), WithProgress(func(percent float64, message string) {
+		mu.Lock()
+		defer mu.Unlock()
+		reports = append(reports, report{percent, message})
+	}))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.Execute("conch-progress 10 start; conch-progress 100% done; echo ok")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if string(result.Stdout) != "ok\n" {
+		t.Errorf("Stdout = %q, Stderr = %q", result.Stdout, result.Stderr)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reports) != 2 || reports[0] != (report{10, "start"}) || reports[1] != (report{100, "done"}) {
+		t.Errorf("reports = %+v", reports)
+	}
+}
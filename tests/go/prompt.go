@@ -0,0 +1,169 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/ebitengine/purego"
+)
+
+// PromptFunc supplies a line of input to a script that reads stdin. prompt
+// is the text the script wrote to stderr since its last newline, such as
+// the argument to read -p, or "" if there was none. The returned line need
+// not end in a newline. Returning an error, typically io.EOF, ends the
+// script's input: read fails as it would at end of file. If the execution is
+// cancelled while it runs, the input ends without waiting for it to return.
+// Handlers needing the execution's context, with its request-scoped values,
+// are PromptContextFuncs, set with WithPromptContext.
+type PromptFunc func(prompt string) (string, error)
+
+// PromptContextFunc is a PromptFunc that is also given the context of the
+// execution reading stdin, as for CommandNotFoundContextFunc. It should
+// return once ctx is done; the script's input ends then either way.
+type PromptContextFunc func(ctx context.Context, prompt string) (string, error)
+
+// promptEntry is a registered handler and the executor it serves, and
+// that executor's library.
+type promptEntry struct {
+	fn    PromptContextFunc
+	lib   *library
+	owner *Executor
+}
+
+var (
+	// promptCallback is the single C entry point for every executor's
+	// prompt handler; purego callbacks are never freed, so it is created
+	// once.
+	promptCallbackOnce sync.Once
+	promptCallback     uintptr
+
+	// promptHandlers maps the user_data passed to the native library to
+	// the handler it stands for.
+	promptHandlersMu sync.RWMutex
+	promptHandlers   = map[uintptr]promptEntry{}
+	nextPromptID     uintptr
+)
+
+// setPromptHandler makes executions without stdin of their own ask fn for
+// input, or removes the handler if fn is nil. fn runs on a native thread
+// while the script waits, and may be called concurrently.
+func (e *Executor) setPromptHandler(fn PromptContextFunc) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.handle == 0 {
+		return errors.New("executor is closed")
+	}
+	if err := e.lib.prompt.load(); err != nil {
+		return err
+	}
+
+	var id, callback uintptr
+	if fn != nil {
+		promptCallbackOnce.Do(func() {
+			promptCallback = purego.NewCallback(runPromptCallback)
+		})
+		callback = promptCallback
+
+		promptHandlersMu.Lock()
+		nextPromptID++
+		id = nextPromptID
+		promptHandlers[id] = promptEntry{fn: fn, lib: e.lib, owner: e}
+		promptHandlersMu.Unlock()
+	}
+
+	ebuf := newErrorBuffer()
+	if e.lib.executorSetPromptHandler(e.handle, callback, id, ebuf.ptr(), errorBufferSize) != 0 {
+		if id != 0 {
+			releasePromptHandler(id)
+		}
+		return fmt.Errorf("failed to set prompt handler: %s", ebuf)
+	}
+
+	releasePromptHandler(e.promptID)
+	e.promptID = id
+	return nil
+}
+
+// releasePromptHandler forgets the handler registered under id.
+func releasePromptHandler(id uintptr) {
+	if id == 0 {
+		return
+	}
+	promptHandlersMu.Lock()
+	delete(promptHandlers, id)
+	promptHandlersMu.Unlock()
+}
+
+// runPromptCallback implements ConchPromptCallback.
+func runPromptCallback(id, promptPtr, answer uintptr) uintptr {
+	promptHandlersMu.RLock()
+	entry := promptHandlers[id]
+	promptHandlersMu.RUnlock()
+	if entry.fn == nil {
+		return 0
+	}
+
+	ctx := context.Background()
+	if entry.lib != nil && entry.lib.promptContext.load() == nil {
+		var interrupt uintptr
+		if entry.lib.hookInterrupts.load() == nil {
+			interrupt = entry.lib.promptInterrupt(answer)
+		}
+		ctx = executionContext(interrupt, goString(entry.lib.promptExecutionID(answer)))
+	}
+	ctx = withCallback(ctx, entry.owner)
+
+	line, ok := callPromptHandler(ctx, entry.fn, goString(promptPtr))
+	if !ok {
+		return 0
+	}
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	entry.lib.promptAnswerSet(answer, pinBytes(&pinner, []byte(line)), uintptr(len(line)))
+	return 1
+}
+
+// callPromptHandler runs fn, reporting ok=false if it returned an error or
+// panicked, so a panic cannot unwind through the native library.
+//
+// The script waits in a native call that an interrupt cannot reach, so fn
+// is raced against ctx: once ctx is done the script's input ends, letting
+// the interrupt land, and fn is left to return in its own time.
+func callPromptHandler(ctx context.Context, fn PromptContextFunc, prompt string) (line string, ok bool) {
+	call := func() (string, bool) {
+		var line string
+		var err error
+		if perr := protect("prompt", func() { line, err = fn(ctx, prompt) }); perr != nil {
+			return "", false
+		}
+		return line, err == nil
+	}
+	if ctx.Done() == nil {
+		return call()
+	}
+	if ctx.Err() != nil {
+		return "", false
+	}
+
+	type answer struct {
+		line string
+		ok   bool
+	}
+	answered := make(chan answer, 1)
+	go func() {
+		line, ok := call()
+		answered <- answer{line, ok}
+	}()
+	select {
+	case a := <-answered:
+		return a.line, a.ok
+	case <-ctx.Done():
+		return "", false
+	}
+}
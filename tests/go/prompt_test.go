@@ -0,0 +1,159 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCallPromptHandler(t *testing.T) {
+	ctx := context.Background()
+	line, ok := callPromptHandler(ctx, func(_ context.Context, prompt string) (string, error) {
+		return "answer to " + prompt, nil
+	}, "q? ")
+	if !ok || line != "answer to q? " {
+		t.Errorf("callPromptHandler() = %q, %v", line, ok)
+	}
+
+	if _, ok := callPromptHandler(ctx, func(context.Context, string) (string, error) { return "", io.EOF }, ""); ok {
+		t.Error("handler returning io.EOF: ok = true, want false")
+	}
+	if _, ok := callPromptHandler(ctx, func(context.Context, string) (string, error) { panic("boom") }, ""); ok {
+		t.Error("panicking handler: ok = true, want false")
+	}
+}
+
+func TestCallPromptHandlerCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	defer close(release)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	// A handler ignoring ctx is abandoned once ctx is done.
+	line, ok := callPromptHandler(ctx, func(context.Context, string) (string, error) {
+		<-release
+		return "late", nil
+	}, "")
+	if ok || line != "" {
+		t.Errorf("callPromptHandler() = %q, %v; want no input after cancellation", line, ok)
+	}
+}
+
+func TestRunPromptCallbackUnknownID(t *testing.T) {
+	if got := runPromptCallback(^uintptr(0), 0, 0); got != 0 {
+		t.Errorf("runPromptCallback(unknown) = %d, want 0", got)
+	}
+}
+
+func TestOnPrompt(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	var (
+		mu      sync.Mutex
+		prompts []string
+	)
+	answers := []string{"Ada", "42"}
+	exec, err := New(Config{
+		Backend: BackendEmbedded,
+		OnPrompt: func(prompt string) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			prompts = append(prompts, prompt)
+			if len(answers) == 0 {
+				return "", io.EOF
+			}
+			line := answers[0]
+			answers = answers[1:]
+			return line, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.Execute(`read -r -p "Name: " name; read -r -p "Age: " age; echo "$name is $age"; read -r more || echo done`)
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if got := string(result.Stdout); got != "Ada is 42\ndone\n" {
+		t.Errorf("stdout = %q, want %q", got, "Ada is 42\ndone\n")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(prompts) != 3 || prompts[0] != "Name: " || prompts[1] != "Age: " || prompts[2] != "" {
+		t.Errorf("prompts = %q, want [Name:  Age:  \"\"]", prompts)
+	}
+}
+
+func TestOnPromptExplicitStdin(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(Config{
+		Backend: BackendEmbedded,
+		OnPrompt: func(string) (string, error) {
+			return "", errors.New("prompt should not be called")
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	// Stdin supplied with the execution takes precedence over the prompt.
+	result, err := exec.ExecuteWithStdin(`read -r line; echo "got $line"`, []byte("piped\n"))
+	if err != nil {
+		t.Fatalf("ExecuteWithStdin() error: %v", err)
+	}
+	if got := string(result.Stdout); got != "got piped\n" {
+		t.Errorf("stdout = %q, want %q", got, "got piped\n")
+	}
+}
+
+func TestOnPromptContextCancelled(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	asked := make(chan struct{}, 1)
+	exec, err := New(WithEmbedded(), WithPromptContext(func(ctx context.Context, _ string) (string, error) {
+		asked <- struct{}{}
+		<-ctx.Done()
+		return "", ctx.Err()
+	}))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-asked
+		cancel()
+	}()
+	start := time.Now()
+	_, err = exec.ExecuteContext(ctx, `read -r line; while :; do :; done`)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ExecuteContext() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ExecuteContext() took %v to stop a script waiting for input", elapsed)
+	}
+}
+
+func TestNewBothPromptHandlers(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	_, err := New(WithEmbedded(),
+		WithPrompt(func(string) (string, error) { return "", io.EOF }),
+		WithPromptContext(func(context.Context, string) (string, error) { return "", io.EOF }))
+	if err == nil || !strings.Contains(err.Error(), "OnPromptContext") {
+		t.Errorf("New() error = %v, want both handlers rejected", err)
+	}
+}
@@ -0,0 +1,210 @@
+package conch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded is wrapped by the error returned for an execution a
+// QuotaManager refused.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota caps what one key, such as a tenant, may use within a sliding
+// window. Zero limits are not enforced.
+type Quota struct {
+	// Window is the period usage is counted over, ending now. Zero means
+	// one minute.
+	Window time.Duration
+	// Executions caps the executions started in the window.
+	Executions int
+	// CPU caps the time spent running scripts, Timings.Execute plus
+	// Timings.Compile, in the window. The library does not report CPU
+	// time, so this is wall time: it also counts time a script spent
+	// waiting, such as on sleep, stdin or host commands.
+	CPU time.Duration
+	// OutputBytes caps the stdout and stderr written in the window,
+	// including output beyond MaxOutputBytes that the Result dropped.
+	OutputBytes int64
+}
+
+// QuotaUsage is what a key used within its quota's window.
+type QuotaUsage struct {
+	Executions  int
+	CPU         time.Duration
+	OutputBytes int64
+}
+
+// QuotaManager meters executions per key against a Quota, refusing new
+// ones once a key has used up any of its limits in the window. CPU and
+// output are known only once an execution finishes, so the execution that
+// crosses a limit completes and the following ones are refused until the
+// window moves past it.
+//
+// Give one to PoolOptions.Quotas to enforce it on the pool's executions, or
+// call Admit and Record around executions run some other way. Keys whose
+// usage has left the window are forgotten, so unused keys take no memory.
+// A QuotaManager is safe for concurrent use.
+type QuotaManager struct {
+	quota Quota
+
+	mu      sync.Mutex
+	quotas  map[string]Quota
+	usage   map[string][]quotaEntry // oldest first
+	now     func() time.Time
+	swept   time.Time
+	charges uint64
+}
+
+// quotaEntry is usage charged to a key at a time.
+type quotaEntry struct {
+	at time.Time
+	// charge identifies an admitted execution's entry, to cancel it.
+	charge uint64
+	QuotaUsage
+}
+
+// quotaSweepInterval is how often a QuotaManager forgets the usage of keys
+// that are no longer used.
+const quotaSweepInterval = time.Minute
+
+// NewQuotaManager returns a QuotaManager applying quota to every key
+// without one set by SetQuota.
+func NewQuotaManager(quota Quota) *QuotaManager {
+	return &QuotaManager{
+		quota:  quota,
+		quotas: map[string]Quota{},
+		usage:  map[string][]quotaEntry{},
+		now:    time.Now,
+	}
+}
+
+// SetQuota sets the quota of key, replacing the default given to
+// NewQuotaManager.
+func (m *QuotaManager) SetQuota(key string, quota Quota) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.quotas[key] = quota
+}
+
+// Admit counts an execution for key, or returns an error wrapping
+// ErrQuotaExceeded if key has used up any limit of its quota. Record the
+// execution's outcome with Record.
+func (m *QuotaManager) Admit(key string) error {
+	_, err := m.admit(key)
+	return err
+}
+
+// admit is Admit, also returning a function uncounting the execution, for
+// one that did not start after all.
+func (m *QuotaManager) admit(key string) (cancel func(), err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep()
+	quota := m.quotaOf(key)
+	used := m.used(key, quota)
+	switch {
+	case quota.Executions > 0 && used.Executions >= quota.Executions:
+		return nil, fmt.Errorf("%w: %s: %d executions in %v", ErrQuotaExceeded, key, used.Executions, quota.window())
+	case quota.CPU > 0 && used.CPU >= quota.CPU:
+		return nil, fmt.Errorf("%w: %s: %v of CPU in %v", ErrQuotaExceeded, key, used.CPU, quota.window())
+	case quota.OutputBytes > 0 && used.OutputBytes >= quota.OutputBytes:
+		return nil, fmt.Errorf("%w: %s: %d output bytes in %v", ErrQuotaExceeded, key, used.OutputBytes, quota.window())
+	}
+	m.charges++
+	charge := m.charges
+	m.usage[key] = append(m.usage[key], quotaEntry{at: m.now(), charge: charge, QuotaUsage: QuotaUsage{Executions: 1}})
+
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		entries := m.usage[key]
+		for i, e := range entries {
+			if e.charge == charge {
+				m.usage[key] = append(entries[:i:i], entries[i+1:]...)
+				break
+			}
+		}
+		if len(m.usage[key]) == 0 {
+			delete(m.usage, key)
+		}
+	}, nil
+}
+
+// Record charges the CPU and output of an admitted execution's result to
+// key. A nil result charges nothing.
+func (m *QuotaManager) Record(key string, result *Result) {
+	if result == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep()
+	m.usage[key] = append(m.usage[key], quotaEntry{at: m.now(), QuotaUsage: QuotaUsage{
+		CPU:         result.Timings.Execute + result.Timings.Compile,
+		OutputBytes: int64(result.StdoutTotalLen + result.StderrTotalLen),
+	}})
+}
+
+// Usage returns what key used within its quota's window.
+func (m *QuotaManager) Usage(key string) QuotaUsage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.used(key, m.quotaOf(key))
+}
+
+// quotaOf returns the quota of key. The caller holds m.mu.
+func (m *QuotaManager) quotaOf(key string) Quota {
+	if quota, ok := m.quotas[key]; ok {
+		return quota
+	}
+	return m.quota
+}
+
+// sweep forgets the keys whose usage has all left their quota's window, at
+// most once per quotaSweepInterval. The caller holds m.mu.
+func (m *QuotaManager) sweep() {
+	now := m.now()
+	if now.Sub(m.swept) < quotaSweepInterval {
+		return
+	}
+	m.swept = now
+	for key := range m.usage {
+		m.used(key, m.quotaOf(key))
+	}
+}
+
+// used drops key's usage older than quota's window and sums the rest. The
+// caller holds m.mu.
+func (m *QuotaManager) used(key string, quota Quota) QuotaUsage {
+	entries := m.usage[key]
+	cutoff := m.now().Add(-quota.window())
+	n := 0
+	for n < len(entries) && !entries[n].at.After(cutoff) {
+		n++
+	}
+	if n == len(entries) {
+		delete(m.usage, key)
+		return QuotaUsage{}
+	}
+	entries = entries[n:]
+	m.usage[key] = entries
+
+	var used QuotaUsage
+	for _, e := range entries {
+		used.Executions += e.Executions
+		used.CPU += e.CPU
+		used.OutputBytes += e.OutputBytes
+	}
+	return used
+}
+
+// window returns the quota's window, defaulting to a minute.
+func (q Quota) window() time.Duration {
+	if q.Window > 0 {
+		return q.Window
+	}
+	return time.Minute
+}
@@ -0,0 +1,133 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQuotaManagerExecutions(t *testing.T) {
+	now := time.Unix(1000, 0)
+	m := NewQuotaManager(Quota{Window: time.Minute, Executions: 2})
+	m.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if err := m.Admit("acme"); err != nil {
+			t.Fatalf("Admit() %d error: %v", i, err)
+		}
+	}
+	if err := m.Admit("acme"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("third Admit() error = %v, want ErrQuotaExceeded", err)
+	}
+	if err := m.Admit("other"); err != nil {
+		t.Errorf("Admit() for another key error: %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if err := m.Admit("acme"); err != nil {
+		t.Errorf("Admit() once the window moved error: %v", err)
+	}
+	if got := m.Usage("acme"); got.Executions != 1 {
+		t.Errorf("Usage() = %+v, want 1 execution", got)
+	}
+}
+
+func TestQuotaManagerCPUAndOutput(t *testing.T) {
+	now := time.Unix(1000, 0)
+	m := NewQuotaManager(Quota{})
+	m.SetQuota("acme", Quota{Window: time.Hour, CPU: time.Second, OutputBytes: 100})
+	m.now = func() time.Time { return now }
+
+	if err := m.Admit("acme"); err != nil {
+		t.Fatalf("Admit() error: %v", err)
+	}
+	m.Record("acme", &Result{StdoutTotalLen: 60, StderrTotalLen: 40})
+	if err := m.Admit("acme"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Admit() over the output quota error = %v", err)
+	}
+
+	m.SetQuota("acme", Quota{Window: time.Hour, CPU: time.Second})
+	m.Record("acme", &Result{Timings: Timings{Execute: 800 * time.Millisecond, Compile: 200 * time.Millisecond}})
+	m.Record("acme", nil)
+	if err := m.Admit("acme"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Admit() over the CPU quota error = %v", err)
+	}
+	want := QuotaUsage{Executions: 1, CPU: time.Second, OutputBytes: 100}
+	if got := m.Usage("acme"); got != want {
+		t.Errorf("Usage() = %+v, want %+v", got, want)
+	}
+
+	// The default quota has no limits.
+	for i := 0; i < 10; i++ {
+		if err := m.Admit("other"); err != nil {
+			t.Fatalf("Admit() without limits error: %v", err)
+		}
+	}
+}
+
+func TestPoolQuotas(t *testing.T) {
+	quotas := NewQuotaManager(Quota{Executions: 1})
+	p, _ := newTestPool(PoolOptions{Quotas: quotas})
+	defer p.Close()
+
+	ctx := context.Background()
+	if _, err := p.ExecuteWithOptions(ctx, "true", ExecOptions{QuotaKey: "acme"}); err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+	if _, err := p.ExecuteWithOptions(ctx, "true", ExecOptions{QuotaKey: "acme"}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("ExecuteWithOptions() over quota error = %v, want ErrQuotaExceeded", err)
+	}
+	if _, err := p.ExecuteWithOptions(ctx, "true", ExecOptions{}); err != nil {
+		t.Errorf("ExecuteWithOptions() without a key error: %v", err)
+	}
+	if got := quotas.Usage("acme").Executions; got != 1 {
+		t.Errorf("Usage().Executions = %d, want 1", got)
+	}
+}
+
+func TestQuotaManagerCancel(t *testing.T) {
+	m := NewQuotaManager(Quota{Executions: 1})
+	cancel, err := m.admit("acme")
+	if err != nil {
+		t.Fatalf("admit() error: %v", err)
+	}
+	cancel()
+	if err := m.Admit("acme"); err != nil {
+		t.Errorf("Admit() after cancel error: %v", err)
+	}
+	if got := m.Usage("acme").Executions; got != 1 {
+		t.Errorf("Usage().Executions = %d, want 1", got)
+	}
+}
+
+func TestQuotaManagerSweep(t *testing.T) {
+	now := time.Unix(0, 0)
+	m := NewQuotaManager(Quota{Window: time.Second})
+	m.now = func() time.Time { return now }
+	for _, key := range []string{"a", "b", "c"} {
+		if err := m.Admit(key); err != nil {
+			t.Fatalf("Admit(%s) error: %v", key, err)
+		}
+	}
+	now = now.Add(quotaSweepInterval)
+	if err := m.Admit("d"); err != nil {
+		t.Fatalf("Admit(d) error: %v", err)
+	}
+	if len(m.usage) != 1 {
+		t.Errorf("usage holds %d keys after a sweep, want 1", len(m.usage))
+	}
+}
+
+func TestPoolQuotasUncharged(t *testing.T) {
+	quotas := NewQuotaManager(Quota{Executions: 1})
+	p, _ := newTestPool(PoolOptions{Quotas: quotas})
+	p.Close()
+
+	if _, err := p.ExecuteWithOptions(context.Background(), "true", ExecOptions{QuotaKey: "acme"}); !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("ExecuteWithOptions() on a closed pool error = %v, want ErrPoolClosed", err)
+	}
+	if got := quotas.Usage("acme").Executions; got != 0 {
+		t.Errorf("Usage().Executions = %d, want 0", got)
+	}
+}
@@ -0,0 +1,36 @@
+package conch
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrPatternTooComplex matches the Err of a Diagnostic the library reported
+// for a regex that exceeds ExecOptions.RegexLimits.
+var ErrPatternTooComplex = errors.New("pattern too complex")
+
+// RegexLimits bounds the regexes grep compiles, for patterns that come from
+// untrusted input. Matching already runs in time linear in the input, as
+// with RE2, so these bound the cost of compiling a pattern. A zero field
+// leaves the engine's default. The library holds them, so the script
+// cannot change them.
+type RegexLimits struct {
+	// MaxPatternBytes is the longest pattern accepted.
+	MaxPatternBytes int
+	// MaxCompiledBytes caps the memory of the compiled pattern, which
+	// counted repetitions such as [a-z]{1000} multiply.
+	MaxCompiledBytes int
+	// MaxNesting caps how deeply groups and repetitions may nest.
+	MaxNesting int
+}
+
+// setRegexLimits sets the regex limits of c from limits, leaving them
+// unset if limits is nil.
+func (c *conchLimits) setRegexLimits(limits *RegexLimits) {
+	if limits == nil {
+		return
+	}
+	c.regexMaxPatternBytes = uint64(max(limits.MaxPatternBytes, 0))
+	c.regexMaxCompiledBytes = uint64(max(limits.MaxCompiledBytes, 0))
+	c.regexMaxNesting = uint32(min(max(limits.MaxNesting, 0), math.MaxUint32))
+}
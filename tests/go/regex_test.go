@@ -0,0 +1,62 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSetRegexLimits(t *testing.T) {
+	native := nativeLimits(DefaultLimits())
+	native.setRegexLimits(nil)
+	if native.regexMaxPatternBytes != 0 || native.regexMaxCompiledBytes != 0 || native.regexMaxNesting != 0 {
+		t.Errorf("setRegexLimits(nil) = %+v, want no limits", native)
+	}
+	native.setRegexLimits(&RegexLimits{MaxPatternBytes: 256, MaxCompiledBytes: -1, MaxNesting: 8})
+	if native.regexMaxPatternBytes != 256 || native.regexMaxCompiledBytes != 0 || native.regexMaxNesting != 8 {
+		t.Errorf("setRegexLimits() = %+v, want 256/0/8", native)
+	}
+}
+
+func TestDiagnosticErr(t *testing.T) {
+	d := &Diagnostic{Command: "grep", Category: CategoryPatternTooComplex, Message: "pattern too complex: 300 bytes exceeds the limit of 256", reported: true}
+	err := d.Err()
+	if !errors.Is(err, ErrPatternTooComplex) {
+		t.Errorf("Err() = %v, want ErrPatternTooComplex", err)
+	}
+	if want := "grep: pattern too complex: 300 bytes exceeds the limit of 256"; err.Error() != want {
+		t.Errorf("Err().Error() = %q, want %q", err, want)
+	}
+	// Text a script wrote is not taken for the library's diagnostic.
+	if err := newDiagnostic("grep: pattern too complex: 300 bytes").Err(); errors.Is(err, ErrPatternTooComplex) {
+		t.Errorf("Err() of parsed text = %v matches ErrPatternTooComplex", err)
+	}
+	if err := newDiagnostic("grep: invalid regex: unclosed group").Err(); errors.Is(err, ErrPatternTooComplex) {
+		t.Errorf("Err() = %v matches ErrPatternTooComplex", err)
+	}
+	if err := (*Diagnostic)(nil).Err(); err != nil {
+		t.Errorf("nil Err() = %v", err)
+	}
+}
+
+func TestExecuteRegexLimits(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	opts := ExecOptions{RegexLimits: &RegexLimits{MaxPatternBytes: 8}}
+	result, err := exec.ExecuteWithOptions(context.Background(), "echo abc | grep 'a[b-z]*c'; CONCH_REGEX_MAX_PATTERN=0; echo abc | grep 'abcdefghij'", opts)
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+	if string(result.Stdout) != "abc\n" || result.ExitCode != ExitCodeUsage {
+		t.Fatalf("Stdout = %q, ExitCode = %d", result.Stdout, result.ExitCode)
+	}
+	if !errors.Is(result.Error.Err(), ErrPatternTooComplex) {
+		t.Errorf("Error = %+v, want a pattern too complex diagnostic", result.Error)
+	}
+}
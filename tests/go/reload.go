@@ -0,0 +1,78 @@
+package conch
+
+import (
+	"fmt"
+
+	"github.com/ebitengine/purego"
+)
+
+// Reload loads the conch library at path and creates every later executor
+// from it, so a long-running process can pick up a new libconch without
+// restarting. Executors already open keep using the library they were
+// created from; it is unloaded once the last of them is closed.
+//
+// The dynamic loader hands back the library it already has for a file it has
+// loaded before, so install the new version under a new name (for example
+// libconch-0.3.0.so) rather than over the old file. Reload fails if path
+// resolves to the library in use. If the new library cannot be loaded, the
+// current one stays in use.
+func Reload(path string) error {
+	if err := Init(); err != nil {
+		return err
+	}
+
+	l, err := openLibrary(path, purego.RTLD_NOW|purego.RTLD_LOCAL)
+	if err != nil {
+		return err
+	}
+
+	libMu.Lock()
+	old := current
+	if l.handle == old.handle {
+		libMu.Unlock()
+		// Drop the reference dlopen added to the library in use.
+		_ = purego.Dlclose(l.handle)
+		return fmt.Errorf("library %s is already loaded; install the new version under a different file name", path)
+	}
+	current = l
+	libMu.Unlock()
+
+	old.retire()
+	return nil
+}
+
+// acquire takes a reference to l, keeping it loaded until release.
+func (l *library) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refs++
+}
+
+// release drops a reference taken by acquire, unloading l if it has been
+// retired and this was the last one.
+func (l *library) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refs--
+	l.closeIfUnused()
+}
+
+// retire marks l as replaced by Reload, unloading it once unreferenced.
+func (l *library) retire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.retired = true
+	l.closeIfUnused()
+}
+
+// closeIfUnused unloads a retired library with no references. The caller
+// holds l.mu.
+func (l *library) closeIfUnused() {
+	if !l.retired || l.refs > 0 || l.closed {
+		return
+	}
+	l.closed = true
+	// The process-wide loader may keep the file mapped; nothing here uses
+	// l again either way.
+	_ = purego.Dlclose(l.handle)
+}
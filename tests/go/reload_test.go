@@ -0,0 +1,103 @@
+package conch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReloadMissingLibrary(t *testing.T) {
+	before, err := LibraryPath()
+	if err != nil {
+		t.Skipf("Skipping: %v", err)
+	}
+
+	if err := Reload(filepath.Join(t.TempDir(), libName())); err == nil {
+		t.Fatal("Reload() of a missing file succeeded")
+	}
+	if after, _ := LibraryPath(); after != before {
+		t.Errorf("LibraryPath() = %q after failed Reload, want %q", after, before)
+	}
+}
+
+func TestReloadSameLibrary(t *testing.T) {
+	path, err := LibraryPath()
+	if err != nil {
+		t.Skipf("Skipping: %v", err)
+	}
+
+	err = Reload(path)
+	if err == nil || !strings.Contains(err.Error(), "already loaded") {
+		t.Fatalf("Reload(%q) error = %v, want already loaded", path, err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	original, err := LibraryPath()
+	if err != nil {
+		t.Fatalf("LibraryPath() error: %v", err)
+	}
+	data, err := os.ReadFile(original)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	copied := filepath.Join(t.TempDir(), libName())
+	if err := os.WriteFile(copied, data, 0o755); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+
+	before, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer before.Close()
+	old := before.lib
+
+	if err := Reload(copied); err != nil {
+		t.Fatalf("Reload() error: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := Reload(original); err != nil {
+			t.Errorf("Reload(%q) error: %v", original, err)
+		}
+	})
+	if got, _ := LibraryPath(); got != copied {
+		t.Errorf("LibraryPath() = %q, want %q", got, copied)
+	}
+
+	after, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() after Reload error: %v", err)
+	}
+	defer after.Close()
+	if after.lib == old {
+		t.Error("executor created after Reload uses the old library")
+	}
+
+	for name, exec := range map[string]*Executor{"before": before, "after": after} {
+		result, err := exec.Execute("echo hi")
+		if err != nil {
+			t.Fatalf("%s: Execute() error: %v", name, err)
+		}
+		if string(result.Stdout) != "hi\n" {
+			t.Errorf("%s: Stdout = %q, want %q", name, result.Stdout, "hi\n")
+		}
+	}
+
+	old.mu.Lock()
+	closed := old.closed
+	old.mu.Unlock()
+	if closed {
+		t.Fatal("old library closed while an executor still uses it")
+	}
+	before.Close()
+	old.mu.Lock()
+	closed = old.closed
+	old.mu.Unlock()
+	if !closed {
+		t.Error("old library still loaded after its last executor closed")
+	}
+}
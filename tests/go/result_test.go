@@ -0,0 +1,118 @@
+package conch
+
+import (
+	"strings"
+	"testing"
+)
+
+// fakeConchResult builds a ConchResult pointing at copies of stdout and
+// stderr held outside the Go heap, as the native library would.
+func fakeConchResult(t *testing.T, stdout, stderr []byte) *ConchResult {
+	c := &ConchResult{ExitCode: 3, Truncated: 1}
+	c.StdoutData = nativeBytes(t, stdout)
+	c.StdoutLen = uintptr(len(stdout))
+	c.StdoutTotalLen = uintptr(len(stdout)) + 10
+	c.StderrData = nativeBytes(t, stderr)
+	c.StderrLen = uintptr(len(stderr))
+	c.StderrTotalLen = uintptr(len(stderr))
+	return c
+}
+
+func TestFillResultReusesBuffers(t *testing.T) {
+	stdout := []byte("out")
+	stderr := []byte("err")
+
+	result := &Result{
+		Stdout: make([]byte, 0, 64),
+		Stderr: make([]byte, 0, 64),
+	}
+	stdoutBacking := &result.Stdout[:1][0]
+
+	fillResult(fakeConchResult(t, stdout, stderr), result, false)
+
+	if string(result.Stdout) != "out" || string(result.Stderr) != "err" {
+		t.Errorf("fillResult() = %q/%q, want out/err", result.Stdout, result.Stderr)
+	}
+	if &result.Stdout[0] != stdoutBacking {
+		t.Error("fillResult() reallocated Stdout despite sufficient capacity")
+	}
+	if result.ExitCode != 3 || !result.Truncated {
+		t.Errorf("fillResult() ExitCode=%d Truncated=%v, want 3/true", result.ExitCode, result.Truncated)
+	}
+	if result.StdoutTotalLen != 13 || result.StderrTotalLen != 3 {
+		t.Errorf("fillResult() StdoutTotalLen=%d StderrTotalLen=%d, want 13/3", result.StdoutTotalLen, result.StderrTotalLen)
+	}
+}
+
+func TestFillResultOverwritesPrevious(t *testing.T) {
+	result := &Result{Stdout: []byte("previous long output"), Stderr: []byte("old")}
+
+	fillResult(fakeConchResult(t, []byte("new"), nil), result, false)
+
+	if string(result.Stdout) != "new" {
+		t.Errorf("Stdout = %q, want %q", result.Stdout, "new")
+	}
+	if len(result.Stderr) != 0 {
+		t.Errorf("Stderr = %q, want empty", result.Stderr)
+	}
+}
+
+func TestFillResultGrows(t *testing.T) {
+	big := []byte(strings.Repeat("x", 1024))
+	result := &Result{}
+
+	fillResult(fakeConchResult(t, big, nil), result, false)
+
+	if len(result.Stdout) != len(big) {
+		t.Errorf("len(Stdout) = %d, want %d", len(result.Stdout), len(big))
+	}
+}
+
+func TestExecuteInto(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error = %v", err)
+	}
+	defer exec.Close()
+
+	var result Result
+	for _, word := range []string{"first", "second"} {
+		if err := exec.ExecuteInto("echo "+word, &result); err != nil {
+			t.Fatalf("ExecuteInto() error = %v", err)
+		}
+		if got := strings.TrimSpace(string(result.Stdout)); got != word {
+			t.Errorf("Stdout = %q, want %q", got, word)
+		}
+	}
+}
+
+func TestTruncatedTotals(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error = %v", err)
+	}
+	defer exec.Close()
+
+	limits := DefaultLimits()
+	limits.MaxOutputBytes = 100
+	result, err := exec.ExecuteWithLimits("printf '%1000s' ''; echo done; echo oops >&2", limits)
+	if err != nil {
+		t.Fatalf("ExecuteWithLimits() error = %v", err)
+	}
+	if !result.Truncated || len(result.Stdout) != 100 || result.StdoutTotalLen != 1005 {
+		t.Errorf("Truncated=%v len(Stdout)=%d StdoutTotalLen=%d, want true/100/1005",
+			result.Truncated, len(result.Stdout), result.StdoutTotalLen)
+	}
+	if string(result.Stderr) != "oops\n" || result.StderrTotalLen != 5 {
+		t.Errorf("Stderr=%q StderrTotalLen=%d, want oops/5", result.Stderr, result.StderrTotalLen)
+	}
+
+	// A script that keeps writing past the limit is not stopped by it.
+	if result.ExitCode != 0 {
+		t.Errorf("ExitCode = %d, want 0", result.ExitCode)
+	}
+}
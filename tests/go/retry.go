@@ -0,0 +1,69 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RetryPolicy configures Retry.
+type RetryPolicy struct {
+	// Max is the number of executions, including the first. Defaults to 3.
+	Max int
+	// Backoff returns the delay before retry n (starting at 1). Defaults to
+	// ExponentialBackoff(time.Second, time.Minute).
+	Backoff func(n int) time.Duration
+	// RetryExitCodes also retries scripts that ran to completion with a
+	// non-zero exit code. Off by default: a script that finished may have
+	// done part of its work, so only scripts known to be idempotent should
+	// opt in.
+	RetryExitCodes bool
+}
+
+// Retry runs script on exec, retrying executions stopped before they
+// finished: by a timeout, a trap, or the watchdog. Errors that would recur,
+// such as a policy denial, are returned at once, and so are scripts that
+// exited non-zero unless p.RetryExitCodes is set. It returns the last
+// attempt's outcome.
+func Retry(exec ShellExecutor, script string, p RetryPolicy) (*Result, error) {
+	return RetryContext(context.Background(), exec, script, p)
+}
+
+// RetryContext is Retry with each attempt run with ExecuteContext and the
+// retries abandoned once ctx is done.
+func RetryContext(ctx context.Context, exec ShellExecutor, script string, p RetryPolicy) (*Result, error) {
+	attempts := p.Max
+	if attempts <= 0 {
+		attempts = 3
+	}
+	backoff := p.Backoff
+	if backoff == nil {
+		backoff = ExponentialBackoff(time.Second, time.Minute)
+	}
+
+	for n := 1; ; n++ {
+		result, err := exec.ExecuteContext(ctx, script)
+		if n >= attempts || ctx.Err() != nil || !p.retryable(result, err) {
+			return result, err
+		}
+		timer := time.NewTimer(backoff(n))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return result, err
+		}
+	}
+}
+
+// retryable reports whether an attempt returning result and err should be
+// retried.
+func (p RetryPolicy) retryable(result *Result, err error) bool {
+	if err == nil {
+		return p.RetryExitCodes && result != nil && result.ExitCode != 0
+	}
+	var trapErr *TrapError
+	return errors.As(err, &trapErr) ||
+		errors.Is(err, ErrExecutionStuck) ||
+		errors.Is(err, ErrTimeout)
+}
@@ -0,0 +1,72 @@
+package conch
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// scriptedExecutor is a ShellExecutor returning canned outcomes in turn.
+type scriptedExecutor struct {
+	pooledTestExecutor
+	outcomes []func() (*Result, error)
+	calls    int
+}
+
+func (s *scriptedExecutor) ExecuteContext(context.Context, string) (*Result, error) {
+	s.calls++
+	return s.outcomes[min(s.calls, len(s.outcomes))-1]()
+}
+
+func succeed() (*Result, error) { return &Result{}, nil }
+func exitOne() (*Result, error) { return &Result{ExitCode: 1}, nil }
+func timeOut() (*Result, error) { return nil, fmt.Errorf("execution failed: %w", ErrTimeout) }
+func trapOut() (*Result, error) {
+	return nil, &TrapError{Err: fmt.Errorf("execution failed: %w", ErrTimeout), Result: &Result{ExitCode: 143}}
+}
+func denyPolicy() (*Result, error) { return nil, ErrPolicyDenied }
+
+func TestRetry(t *testing.T) {
+	noWait := func(int) time.Duration { return 0 }
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		outcomes []func() (*Result, error)
+		calls    int
+		wantErr  bool
+	}{
+		{"success", RetryPolicy{}, []func() (*Result, error){succeed}, 1, false},
+		{"timeout then success", RetryPolicy{}, []func() (*Result, error){timeOut, trapOut, succeed}, 3, false},
+		{"gives up", RetryPolicy{Max: 2}, []func() (*Result, error){timeOut}, 2, true},
+		{"exit code not retried", RetryPolicy{}, []func() (*Result, error){exitOne, succeed}, 1, false},
+		{"exit code opted in", RetryPolicy{RetryExitCodes: true}, []func() (*Result, error){exitOne, succeed}, 2, false},
+		{"permanent error", RetryPolicy{}, []func() (*Result, error){denyPolicy, succeed}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := &scriptedExecutor{outcomes: tt.outcomes}
+			tt.policy.Backoff = noWait
+			_, err := Retry(exec, "true", tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Retry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if exec.calls != tt.calls {
+				t.Errorf("attempts = %d, want %d", exec.calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestRetryContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	exec := &scriptedExecutor{outcomes: []func() (*Result, error){timeOut}}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	_, err := RetryContext(ctx, exec, "true", RetryPolicy{Max: 100, Backoff: func(int) time.Duration { return time.Hour }})
+	if err == nil || exec.calls != 1 {
+		t.Errorf("RetryContext() = %v after %d attempts, want the first attempt's error", err, exec.calls)
+	}
+}
@@ -0,0 +1,381 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Task is a unit of work consumed by a Runner.
+type Task struct {
+	// ID identifies the task to its Queue.
+	ID string
+	// Script is the shell script to run.
+	Script string
+	// Stdin, if non-nil, is fed to the script as standard input.
+	Stdin []byte
+	// Limits, if set, overrides the executor's default resource limits.
+	Limits *ResourceLimits
+	// MaxAttempts overrides Runner.MaxAttempts when positive.
+	MaxAttempts int
+	// Priority orders the task in a MemoryQueue, and its executions when the
+	// Runner's executor is a Pool.
+	Priority Priority
+	// QuotaKey charges the task's executions to a quota when the Runner's
+	// executor is a Pool with PoolOptions.Quotas set. Attempts refused by
+	// the quota fail like any other.
+	QuotaKey string
+}
+
+// TaskResult reports the outcome of a Task.
+type TaskResult struct {
+	// Attempts is the number of executions, including the last.
+	Attempts int
+	// Result is the last execution's result, or nil if it failed.
+	Result *Result
+	// Err is the last execution's error, if any.
+	Err error
+}
+
+// Succeeded reports whether the last attempt ran and exited zero.
+func (r TaskResult) Succeeded() bool {
+	return r.Err == nil && r.Result != nil && r.Result.ExitCode == 0
+}
+
+// ErrQueueClosed is returned by Queue.Next once the queue has no more tasks;
+// it makes Runner.Run return nil.
+var ErrQueueClosed = errors.New("queue closed")
+
+// Queue is the source of tasks for a Runner and the sink for their outcomes.
+// Implementations provide persistence; every method may be called
+// concurrently.
+type Queue interface {
+	// Next blocks until a task is available, ctx is done, or the queue is
+	// closed (ErrQueueClosed).
+	Next(ctx context.Context) (*Task, error)
+	// Ack records that task succeeded.
+	Ack(ctx context.Context, task *Task, result TaskResult) error
+	// Nack records that task failed on its last allowed attempt.
+	Nack(ctx context.Context, task *Task, result TaskResult) error
+}
+
+// Runner executes tasks from a Queue, retrying failures with backoff.
+//
+// Set the optional fields before calling Run.
+type Runner struct {
+	exec  ShellExecutor
+	queue Queue
+
+	// Concurrency is the number of tasks run at once. Defaults to 1.
+	Concurrency int
+	// MaxAttempts is the number of executions before a task is nacked.
+	// Defaults to 3.
+	MaxAttempts int
+	// Backoff returns the delay before retry n (starting at 1). Defaults to
+	// ExponentialBackoff(time.Second, time.Minute).
+	Backoff func(n int) time.Duration
+	// Retryable decides whether a failed attempt is retried. Defaults to
+	// retrying execution errors and non-zero exit codes.
+	Retryable func(result *Result, err error) bool
+	// OnResult, if set, is called with every task's final outcome.
+	OnResult func(task *Task, result TaskResult)
+}
+
+// NewRunner returns a Runner executing tasks from queue with exec.
+func NewRunner(exec ShellExecutor, queue Queue) *Runner {
+	return &Runner{exec: exec, queue: queue}
+}
+
+// ExponentialBackoff returns a backoff doubling from base up to limit.
+func ExponentialBackoff(base, limit time.Duration) func(n int) time.Duration {
+	return func(n int) time.Duration {
+		d := base
+		for i := 1; i < n && d < limit; i++ {
+			d *= 2
+		}
+		return min(d, limit)
+	}
+}
+
+// Run consumes tasks until ctx is done or the queue is closed, and waits for
+// in-flight tasks before returning. It returns nil once the queue is closed,
+// ctx.Err() if ctx is done, or the first error from the queue.
+func (r *Runner) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() { firstErr = err })
+		cancel()
+	}
+
+	for i := 0; i < max(r.Concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				task, err := r.queue.Next(ctx)
+				if errors.Is(err, ErrQueueClosed) {
+					return
+				}
+				if err != nil {
+					if ctx.Err() == nil {
+						fail(fmt.Errorf("queue next: %w", err))
+					}
+					return
+				}
+				if err := r.process(ctx, task); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// process runs task to completion and reports the outcome to the queue.
+func (r *Runner) process(ctx context.Context, task *Task) error {
+	attempts := r.MaxAttempts
+	if task.MaxAttempts > 0 {
+		attempts = task.MaxAttempts
+	}
+	if attempts <= 0 {
+		attempts = 3
+	}
+
+	var res TaskResult
+	for res.Attempts < attempts {
+		if res.Attempts > 0 && !r.sleep(ctx, res.Attempts) {
+			// Leave the task for the queue to redeliver.
+			return nil
+		}
+		res.Attempts++
+		res.Result, res.Err = runTask(ctx, r.exec, task)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if res.Succeeded() || !r.retryable(res.Result, res.Err) {
+			break
+		}
+	}
+
+	if r.OnResult != nil {
+		r.OnResult(task, res)
+	}
+	if res.Succeeded() {
+		if err := r.queue.Ack(ctx, task, res); err != nil {
+			return fmt.Errorf("queue ack %s: %w", task.ID, err)
+		}
+		return nil
+	}
+	if err := r.queue.Nack(ctx, task, res); err != nil {
+		return fmt.Errorf("queue nack %s: %w", task.ID, err)
+	}
+	return nil
+}
+
+func (r *Runner) retryable(result *Result, err error) bool {
+	if r.Retryable != nil {
+		return r.Retryable(result, err)
+	}
+	return true
+}
+
+// sleep waits out the backoff before retry n, reporting false if ctx ends
+// first.
+func (r *Runner) sleep(ctx context.Context, n int) bool {
+	backoff := r.Backoff
+	if backoff == nil {
+		backoff = ExponentialBackoff(time.Second, time.Minute)
+	}
+	timer := time.NewTimer(backoff(n))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// stdinContextExecutor is implemented by executors that take stdin, limits
+// and a context in one call.
+type stdinContextExecutor interface {
+	ExecuteContextWithStdin(ctx context.Context, script string, stdin []byte, limits ResourceLimits) (*Result, error)
+}
+
+// limitedExecutor is implemented by executors with their own default limits.
+type limitedExecutor interface {
+	Limits() ResourceLimits
+}
+
+var _ stdinContextExecutor = (*Executor)(nil)
+
+// runTask executes task with the richest call exec supports.
+func runTask(ctx context.Context, exec ShellExecutor, task *Task) (*Result, error) {
+	if e, ok := exec.(optionsExecutor); ok {
+		return e.ExecuteWithOptions(ctx, task.Script, ExecOptions{
+			Stdin:    task.Stdin,
+			Limits:   task.Limits,
+			Priority: task.Priority,
+			QuotaKey: task.QuotaKey,
+		})
+	}
+	if e, ok := exec.(stdinContextExecutor); ok {
+		limits := DefaultLimits()
+		if l, ok := exec.(limitedExecutor); ok {
+			limits = l.Limits()
+		}
+		if task.Limits != nil {
+			limits = *task.Limits
+		}
+		return e.ExecuteContextWithStdin(ctx, task.Script, task.Stdin, limits)
+	}
+	switch {
+	case task.Limits != nil:
+		return nil, errors.New("executor does not support per-task limits")
+	case task.Stdin != nil:
+		return exec.ExecuteWithStdin(task.Script, task.Stdin)
+	default:
+		return exec.ExecuteContext(ctx, task.Script)
+	}
+}
+
+// MemoryQueue is an in-memory Queue, useful for tests and for feeding a
+// Runner from the same process. It does not persist anything. Next returns
+// the task of highest Priority, and of those the one pushed first.
+type MemoryQueue struct {
+	size int
+
+	mu      sync.Mutex
+	pending []*Task // in the order Next returns them
+	closed  bool
+	changed chan struct{} // closed and replaced when pending or closed changes
+	acked   []*Task
+	nacked  []*Task
+}
+
+// NewMemoryQueue returns a MemoryQueue buffering up to size tasks, and at
+// least one.
+func NewMemoryQueue(size int) *MemoryQueue {
+	return &MemoryQueue{size: max(size, 1), changed: make(chan struct{})}
+}
+
+// Push enqueues task, blocking while the queue is full.
+func (q *MemoryQueue) Push(ctx context.Context, task *Task) error {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return ErrQueueClosed
+		}
+		if len(q.pending) < q.size {
+			i := len(q.pending)
+			for i > 0 && q.pending[i-1].Priority < task.Priority {
+				i--
+			}
+			q.pending = append(q.pending, nil)
+			copy(q.pending[i+1:], q.pending[i:])
+			q.pending[i] = task
+			q.notify()
+			q.mu.Unlock()
+			return nil
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close stops the queue accepting tasks. Next drains the tasks already
+// queued, then returns ErrQueueClosed.
+func (q *MemoryQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		q.notify()
+	}
+}
+
+// Next implements Queue.
+func (q *MemoryQueue) Next(ctx context.Context) (*Task, error) {
+	for {
+		q.mu.Lock()
+		if len(q.pending) > 0 {
+			task := q.pending[0]
+			q.pending[0] = nil
+			q.pending = q.pending[1:]
+			q.notify()
+			q.mu.Unlock()
+			return task, nil
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return nil, ErrQueueClosed
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// notify wakes the calls waiting for the queue to change. The caller holds
+// q.mu.
+func (q *MemoryQueue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// Ack implements Queue.
+func (q *MemoryQueue) Ack(_ context.Context, task *Task, _ TaskResult) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.acked = append(q.acked, task)
+	return nil
+}
+
+// Nack implements Queue.
+func (q *MemoryQueue) Nack(_ context.Context, task *Task, _ TaskResult) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nacked = append(q.nacked, task)
+	return nil
+}
+
+// Acked returns the tasks acknowledged so far.
+func (q *MemoryQueue) Acked() []*Task {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]*Task(nil), q.acked...)
+}
+
+// Nacked returns the tasks that failed so far.
+func (q *MemoryQueue) Nacked() []*Task {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]*Task(nil), q.nacked...)
+}
@@ -0,0 +1,192 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyExecutor fails each script's first failures executions.
+type flakyExecutor struct {
+	failures int
+
+	mu    sync.Mutex
+	calls map[string]int
+	stdin map[string][]byte
+}
+
+func newFlakyExecutor(failures int) *flakyExecutor {
+	return &flakyExecutor{failures: failures, calls: map[string]int{}, stdin: map[string][]byte{}}
+}
+
+func (f *flakyExecutor) ExecuteContextWithStdin(_ context.Context, script string, stdin []byte, _ ResourceLimits) (*Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[script]++
+	f.stdin[script] = stdin
+	if f.calls[script] <= f.failures {
+		return &Result{ExitCode: 1}, nil
+	}
+	return &Result{Stdout: []byte(script)}, nil
+}
+
+func (f *flakyExecutor) Execute(script string) (*Result, error) {
+	return f.ExecuteContextWithStdin(context.Background(), script, nil, DefaultLimits())
+}
+
+func (f *flakyExecutor) ExecuteContext(ctx context.Context, script string) (*Result, error) {
+	return f.ExecuteContextWithStdin(ctx, script, nil, DefaultLimits())
+}
+
+func (f *flakyExecutor) ExecuteWithStdin(script string, stdin []byte) (*Result, error) {
+	return f.ExecuteContextWithStdin(context.Background(), script, stdin, DefaultLimits())
+}
+
+func (f *flakyExecutor) Close() error { return nil }
+
+func runQueue(t *testing.T, r *Runner, q *MemoryQueue, tasks ...*Task) {
+	t.Helper()
+	for _, task := range tasks {
+		if err := q.Push(context.Background(), task); err != nil {
+			t.Fatalf("Push() error: %v", err)
+		}
+	}
+	q.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Run(ctx); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+}
+
+func TestRunnerRetriesThenAcks(t *testing.T) {
+	exec := newFlakyExecutor(2)
+	q := NewMemoryQueue(4)
+	r := NewRunner(exec, q)
+	r.Concurrency = 2
+	r.Backoff = func(int) time.Duration { return time.Millisecond }
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	r.OnResult = func(task *Task, res TaskResult) {
+		mu.Lock()
+		attempts[task.ID] = res.Attempts
+		mu.Unlock()
+	}
+
+	runQueue(t, r, q,
+		&Task{ID: "a", Script: "a", Stdin: []byte("in")},
+		&Task{ID: "b", Script: "b"},
+	)
+
+	if got := len(q.Acked()); got != 2 {
+		t.Errorf("acked %d tasks, want 2", got)
+	}
+	if got := len(q.Nacked()); got != 0 {
+		t.Errorf("nacked %d tasks, want 0", got)
+	}
+	if attempts["a"] != 3 || attempts["b"] != 3 {
+		t.Errorf("attempts = %v, want 3 each", attempts)
+	}
+	if got := string(exec.stdin["a"]); got != "in" {
+		t.Errorf("stdin = %q, want %q", got, "in")
+	}
+}
+
+func TestRunnerNacksAfterMaxAttempts(t *testing.T) {
+	exec := newFlakyExecutor(5)
+	q := NewMemoryQueue(1)
+	r := NewRunner(exec, q)
+	r.Backoff = func(int) time.Duration { return 0 }
+
+	runQueue(t, r, q, &Task{ID: "a", Script: "a", MaxAttempts: 2})
+
+	if got := len(q.Nacked()); got != 1 {
+		t.Errorf("nacked %d tasks, want 1", got)
+	}
+	if got := exec.calls["a"]; got != 2 {
+		t.Errorf("executed %d times, want 2", got)
+	}
+}
+
+func TestRunnerRetryable(t *testing.T) {
+	exec := newFlakyExecutor(5)
+	q := NewMemoryQueue(1)
+	r := NewRunner(exec, q)
+	r.Retryable = func(*Result, error) bool { return false }
+
+	runQueue(t, r, q, &Task{ID: "a", Script: "a"})
+
+	if got := exec.calls["a"]; got != 1 {
+		t.Errorf("executed %d times, want 1", got)
+	}
+}
+
+type errQueue struct{ *MemoryQueue }
+
+func (errQueue) Next(context.Context) (*Task, error) {
+	return nil, errors.New("broker down")
+}
+
+func TestRunnerQueueError(t *testing.T) {
+	r := NewRunner(newFlakyExecutor(0), errQueue{NewMemoryQueue(0)})
+	if err := r.Run(context.Background()); err == nil {
+		t.Error("Run() succeeded, want queue error")
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(time.Second, 5*time.Second)
+	for n, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := backoff(n); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", n, got, want)
+		}
+	}
+}
+
+func TestMemoryQueuePriority(t *testing.T) {
+	q := NewMemoryQueue(4)
+	ctx := context.Background()
+	for _, task := range []*Task{
+		{ID: "batch-1", Priority: PriorityLow},
+		{ID: "normal"},
+		{ID: "user", Priority: PriorityHigh},
+		{ID: "batch-2", Priority: PriorityLow},
+	} {
+		if err := q.Push(ctx, task); err != nil {
+			t.Fatalf("Push() error: %v", err)
+		}
+	}
+	q.Close()
+
+	var got []string
+	for {
+		task, err := q.Next(ctx)
+		if errors.Is(err, ErrQueueClosed) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		got = append(got, task.ID)
+	}
+	if want := []string{"user", "normal", "batch-1", "batch-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Next() order = %q, want %q", got, want)
+	}
+}
+
+func TestMemoryQueuePushBlocksWhenFull(t *testing.T) {
+	q := NewMemoryQueue(1)
+	if err := q.Push(context.Background(), &Task{ID: "a"}); err != nil {
+		t.Fatalf("Push() error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.Push(ctx, &Task{ID: "b"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Push() to a full queue error = %v, want deadline exceeded", err)
+	}
+}
@@ -0,0 +1,260 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OverlapPolicy decides what happens when a job comes due while its previous
+// run is still going.
+type OverlapPolicy int
+
+const (
+	// OverlapSkip drops the new run. This is the default.
+	OverlapSkip OverlapPolicy = iota
+	// OverlapAllow starts the new run alongside the previous one.
+	OverlapAllow
+	// OverlapQueue starts the new run once the previous one finishes. At
+	// most one run is queued; further due times while one is queued are
+	// skipped.
+	OverlapQueue
+)
+
+// Job is a script run by a Scheduler on a cron schedule.
+type Job struct {
+	// Name identifies the job; it must be unique within a Scheduler.
+	Name string
+	// Schedule is a cron expression; see ParseSchedule.
+	Schedule string
+	// Script is the shell script to run.
+	Script string
+	// Limits, if set, overrides the executor's default resource limits.
+	// The executor must then support ExecuteContextWithLimits, as Executor
+	// does.
+	Limits *ResourceLimits
+	// Overlap decides what happens when the job comes due while still
+	// running.
+	Overlap OverlapPolicy
+	// OnResult, if set, is called after every run, including skipped ones.
+	OnResult func(JobResult)
+}
+
+// JobResult reports one scheduled run of a Job.
+type JobResult struct {
+	// Job is the job's name.
+	Job string
+	// Scheduled is the time the run was due.
+	Scheduled time.Time
+	// Started and Finished bracket the execution. Both are zero for a
+	// skipped run.
+	Started, Finished time.Time
+	// Skipped is true if the run was dropped by the overlap policy.
+	Skipped bool
+	// Result is the script's result, or nil if it did not run or failed.
+	Result *Result
+	// Err is the execution error, if any.
+	Err error
+}
+
+// limitsExecutor is implemented by executors that accept per-call limits.
+type limitsExecutor interface {
+	ExecuteContextWithLimits(ctx context.Context, script string, limits ResourceLimits) (*Result, error)
+}
+
+var _ limitsExecutor = (*Executor)(nil)
+
+// Scheduler runs scripts on cron schedules against a ShellExecutor, such as
+// an Executor shared by every job.
+//
+// Schedules are evaluated in the local time zone. A Scheduler does nothing
+// until Run is called, and jobs may be added or removed at any time.
+type Scheduler struct {
+	exec ShellExecutor
+
+	mu      sync.Mutex
+	jobs    map[string]*scheduledJob
+	ctx     context.Context // set while Run is active
+	running sync.WaitGroup
+}
+
+// scheduledJob is a Job and its run state.
+type scheduledJob struct {
+	Job
+	schedule *Schedule
+	stop     context.CancelFunc
+
+	mu        sync.Mutex
+	active    int
+	pending   bool
+	pendingAt time.Time
+}
+
+// NewScheduler returns a Scheduler that runs jobs with exec.
+func NewScheduler(exec ShellExecutor) *Scheduler {
+	return &Scheduler{exec: exec, jobs: map[string]*scheduledJob{}}
+}
+
+// Add registers job. It fails if the schedule does not parse, the name is
+// taken, or the job needs limits the executor cannot apply.
+func (s *Scheduler) Add(job Job) error {
+	if job.Name == "" {
+		return errors.New("job name is empty")
+	}
+	schedule, err := ParseSchedule(job.Schedule)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", job.Name, err)
+	}
+	if job.Limits != nil {
+		if _, ok := s.exec.(limitsExecutor); !ok {
+			return fmt.Errorf("job %s: executor does not support per-job limits", job.Name)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[job.Name]; ok {
+		return fmt.Errorf("job %s already exists", job.Name)
+	}
+	j := &scheduledJob{Job: job, schedule: schedule}
+	s.jobs[job.Name] = j
+	if s.ctx != nil {
+		s.start(j)
+	}
+	return nil
+}
+
+// Remove unregisters the named job, reporting whether it existed. A run in
+// progress is allowed to finish.
+func (s *Scheduler) Remove(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[name]
+	if !ok {
+		return false
+	}
+	delete(s.jobs, name)
+	if j.stop != nil {
+		j.stop()
+	}
+	return true
+}
+
+// Run runs jobs until ctx is done, then waits for in-progress runs to stop
+// and returns ctx.Err(). Runs are started with ctx, so cancelling it also
+// interrupts them.
+func (s *Scheduler) Run(ctx context.Context) error {
+	s.mu.Lock()
+	if s.ctx != nil {
+		s.mu.Unlock()
+		return errors.New("scheduler is already running")
+	}
+	s.ctx = ctx
+	for _, j := range s.jobs {
+		s.start(j)
+	}
+	s.mu.Unlock()
+
+	<-ctx.Done()
+
+	s.mu.Lock()
+	s.ctx = nil
+	for _, j := range s.jobs {
+		j.stop = nil
+	}
+	s.mu.Unlock()
+
+	s.running.Wait()
+	return ctx.Err()
+}
+
+// start launches j's timer loop. s.mu must be held and s.ctx set.
+//
+// Runs use the scheduler's context rather than the loop's, so removing a job
+// does not interrupt a run already in progress.
+func (s *Scheduler) start(j *scheduledJob) {
+	runCtx := s.ctx
+	ctx, stop := context.WithCancel(runCtx)
+	j.stop = stop
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer stop()
+		for {
+			next := j.schedule.Next(time.Now())
+			if next.IsZero() {
+				return
+			}
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			s.fire(runCtx, j, next)
+		}
+	}()
+}
+
+// fire starts a run of j due at scheduled, applying its overlap policy.
+func (s *Scheduler) fire(ctx context.Context, j *scheduledJob, scheduled time.Time) {
+	j.mu.Lock()
+	if j.active > 0 {
+		switch {
+		case j.Overlap == OverlapAllow:
+		case j.Overlap == OverlapQueue && !j.pending:
+			j.pending = true
+			j.pendingAt = scheduled
+			j.mu.Unlock()
+			return
+		default:
+			j.mu.Unlock()
+			j.report(JobResult{Job: j.Name, Scheduled: scheduled, Skipped: true})
+			return
+		}
+	}
+	j.active++
+	j.mu.Unlock()
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		for {
+			j.report(s.runOnce(ctx, j, scheduled))
+
+			j.mu.Lock()
+			if !j.pending {
+				j.active--
+				j.mu.Unlock()
+				return
+			}
+			j.pending = false
+			scheduled = j.pendingAt
+			j.mu.Unlock()
+		}
+	}()
+}
+
+// runOnce executes j's script.
+func (s *Scheduler) runOnce(ctx context.Context, j *scheduledJob, scheduled time.Time) JobResult {
+	res := JobResult{Job: j.Name, Scheduled: scheduled, Started: time.Now()}
+	if j.Limits != nil {
+		res.Result, res.Err = s.exec.(limitsExecutor).ExecuteContextWithLimits(ctx, j.Script, *j.Limits)
+	} else {
+		res.Result, res.Err = s.exec.ExecuteContext(ctx, j.Script)
+	}
+	res.Finished = time.Now()
+	return res
+}
+
+// report passes res to the job's callback, if any.
+func (j *scheduledJob) report(res JobResult) {
+	if j.OnResult != nil {
+		j.OnResult(res)
+	}
+}
@@ -0,0 +1,154 @@
+package conch
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingExecutor is a ShellExecutor whose executions wait for release.
+type blockingExecutor struct {
+	release chan struct{}
+
+	mu      sync.Mutex
+	scripts []string
+}
+
+func newBlockingExecutor() *blockingExecutor {
+	return &blockingExecutor{release: make(chan struct{})}
+}
+
+func (b *blockingExecutor) Execute(script string) (*Result, error) {
+	return b.ExecuteContext(context.Background(), script)
+}
+
+func (b *blockingExecutor) ExecuteContext(ctx context.Context, script string) (*Result, error) {
+	b.mu.Lock()
+	b.scripts = append(b.scripts, script)
+	b.mu.Unlock()
+	select {
+	case <-b.release:
+		return &Result{Stdout: []byte(script)}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b *blockingExecutor) ExecuteWithStdin(script string, _ []byte) (*Result, error) {
+	return b.Execute(script)
+}
+
+func (b *blockingExecutor) Close() error { return nil }
+
+func (b *blockingExecutor) calls() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.scripts)
+}
+
+func TestSchedulerAdd(t *testing.T) {
+	s := NewScheduler(newBlockingExecutor())
+
+	if err := s.Add(Job{Name: "ok", Schedule: "@hourly", Script: "true"}); err != nil {
+		t.Fatalf("Add() error: %v", err)
+	}
+	if err := s.Add(Job{Name: "ok", Schedule: "@hourly"}); err == nil {
+		t.Error("Add() with duplicate name succeeded")
+	}
+	if err := s.Add(Job{Name: "bad", Schedule: "every minute"}); err == nil {
+		t.Error("Add() with invalid schedule succeeded")
+	}
+	if err := s.Add(Job{Schedule: "@hourly"}); err == nil {
+		t.Error("Add() without name succeeded")
+	}
+	limits := DefaultLimits()
+	if err := s.Add(Job{Name: "limited", Schedule: "@hourly", Limits: &limits}); err == nil {
+		t.Error("Add() with limits on an executor without limit support succeeded")
+	}
+	if !s.Remove("ok") || s.Remove("ok") {
+		t.Error("Remove() did not report existence correctly")
+	}
+}
+
+func TestSchedulerOverlap(t *testing.T) {
+	tests := []struct {
+		policy  OverlapPolicy
+		runs    int
+		skipped int
+	}{
+		{OverlapSkip, 1, 2},
+		{OverlapAllow, 3, 0},
+		{OverlapQueue, 2, 1},
+	}
+	for _, tt := range tests {
+		exec := newBlockingExecutor()
+		s := NewScheduler(exec)
+
+		var mu sync.Mutex
+		var results []JobResult
+		j := &scheduledJob{Job: Job{
+			Name:    "job",
+			Script:  "work",
+			Overlap: tt.policy,
+			OnResult: func(r JobResult) {
+				mu.Lock()
+				results = append(results, r)
+				mu.Unlock()
+			},
+		}}
+
+		now := time.Now()
+		s.fire(context.Background(), j, now)
+		waitFor(t, func() bool { return exec.calls() == 1 })
+		s.fire(context.Background(), j, now.Add(time.Minute))
+		s.fire(context.Background(), j, now.Add(2*time.Minute))
+		close(exec.release)
+		s.running.Wait()
+
+		var runs, skipped int
+		for _, r := range results {
+			if r.Skipped {
+				skipped++
+			} else {
+				runs++
+			}
+		}
+		if runs != tt.runs || skipped != tt.skipped {
+			t.Errorf("policy %d: runs=%d skipped=%d, want runs=%d skipped=%d",
+				tt.policy, runs, skipped, tt.runs, tt.skipped)
+		}
+	}
+}
+
+func TestSchedulerRunStops(t *testing.T) {
+	s := NewScheduler(newBlockingExecutor())
+	if err := s.Add(Job{Name: "job", Schedule: "* * * * *", Script: "true"}); err != nil {
+		t.Fatalf("Add() error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("Run() = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after cancel")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,113 @@
+// Package scripts is a library of tested shell snippets for conch,
+// addressable by name. Each snippet is the body of a shell function, so once
+// defined on an executor it runs like a command:
+//
+//	if err := scripts.Define(exec, "csv-to-json"); err != nil {
+//		return err
+//	}
+//	result, err := exec.ExecuteWithStdin("csv-to-json -c id,name", data)
+//
+// The snippets stick to shell features and builtins the sandbox supports,
+// and double as examples of them.
+package scripts
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+//go:embed snippets/*.sh
+var files embed.FS
+
+// Snippet is a named shell snippet.
+type Snippet struct {
+	// Name is the command the snippet is defined as, such as
+	// "json-flatten".
+	Name string
+	// Description is the snippet's leading comment, without the #s.
+	Description string
+	// Source is the function body.
+	Source string
+}
+
+// Definer is implemented by *conch.Executor.
+type Definer interface {
+	DefineFunction(name, script string) error
+}
+
+// All returns every snippet, sorted by name.
+func All() []Snippet {
+	entries, err := files.ReadDir("snippets")
+	if err != nil {
+		panic(err) // embedded at build time
+	}
+	snippets := make([]Snippet, 0, len(entries))
+	for _, entry := range entries {
+		snippets = append(snippets, load(strings.TrimSuffix(entry.Name(), ".sh")))
+	}
+	sort.Slice(snippets, func(i, j int) bool { return snippets[i].Name < snippets[j].Name })
+	return snippets
+}
+
+// Names returns the names of every snippet, sorted.
+func Names() []string {
+	all := All()
+	names := make([]string, len(all))
+	for i, s := range all {
+		names[i] = s.Name
+	}
+	return names
+}
+
+// Get returns the snippet called name.
+func Get(name string) (Snippet, error) {
+	for _, s := range All() {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return Snippet{}, fmt.Errorf("unknown snippet %q", name)
+}
+
+// Define defines the named snippets as functions on d, or every snippet if
+// no names are given. Scripts on an executor used by a conch.Session see
+// them too.
+func Define(d Definer, names ...string) error {
+	var snippets []Snippet
+	if len(names) == 0 {
+		snippets = All()
+	}
+	for _, name := range names {
+		s, err := Get(name)
+		if err != nil {
+			return err
+		}
+		snippets = append(snippets, s)
+	}
+	for _, s := range snippets {
+		if err := d.DefineFunction(s.Name, s.Source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load reads the snippet called name.
+func load(name string) Snippet {
+	data, err := files.ReadFile(path.Join("snippets", name+".sh"))
+	if err != nil {
+		panic(err) // embedded at build time
+	}
+	source := string(data)
+	var desc []string
+	for _, line := range strings.Split(source, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		desc = append(desc, strings.TrimSpace(strings.TrimPrefix(line, "#")))
+	}
+	return Snippet{Name: name, Description: strings.Join(desc, " "), Source: source}
+}
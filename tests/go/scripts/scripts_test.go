@@ -0,0 +1,134 @@
+package scripts
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	conch "github.com/sd2k/conch/tests/go"
+)
+
+func TestAll(t *testing.T) {
+	all := All()
+	if len(all) == 0 {
+		t.Fatal("All() returned no snippets")
+	}
+	for i, s := range all {
+		if i > 0 && all[i-1].Name >= s.Name {
+			t.Errorf("All() not sorted: %q before %q", all[i-1].Name, s.Name)
+		}
+		if s.Description == "" || strings.Contains(s.Description, "#") {
+			t.Errorf("%s: description %q", s.Name, s.Description)
+		}
+		if s.Source == "" {
+			t.Errorf("%s: empty source", s.Name)
+		}
+	}
+	if names := Names(); len(names) != len(all) || names[0] != all[0].Name {
+		t.Errorf("Names() = %q", names)
+	}
+}
+
+func TestGet(t *testing.T) {
+	s, err := Get("json-flatten")
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	if !strings.HasPrefix(s.Description, "Flatten the JSON value on stdin") {
+		t.Errorf("Description = %q", s.Description)
+	}
+	if _, err := Get("nope"); err == nil {
+		t.Error("Get(nope) succeeded")
+	}
+}
+
+type recordingDefiner map[string]string
+
+func (d recordingDefiner) DefineFunction(name, script string) error {
+	d[name] = script
+	return nil
+}
+
+func TestDefine(t *testing.T) {
+	d := recordingDefiner{}
+	if err := Define(d, "csv-to-json"); err != nil {
+		t.Fatalf("Define() error: %v", err)
+	}
+	if len(d) != 1 || !strings.Contains(d["csv-to-json"], "csv --to-json") {
+		t.Errorf("defined %v", d)
+	}
+	if err := Define(d, "nope"); err == nil {
+		t.Error("Define(nope) succeeded")
+	}
+
+	d = recordingDefiner{}
+	if err := Define(d); err != nil {
+		t.Fatalf("Define() error: %v", err)
+	}
+	if len(d) != len(All()) {
+		t.Errorf("Define() with no names defined %d snippets, want all %d", len(d), len(All()))
+	}
+}
+
+func TestSnippets(t *testing.T) {
+	if !conch.IsAvailable() || !conch.HasEmbeddedShell() {
+		t.Skip("Skipping: embedded shell not available")
+	}
+	exec, err := conch.New(conch.WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+	if err := Define(exec); err != nil {
+		t.Fatalf("Define() error: %v", err)
+	}
+
+	run := func(t *testing.T, script, stdin string) string {
+		t.Helper()
+		result, err := exec.ExecuteWithStdin(script, []byte(stdin))
+		if err != nil {
+			t.Fatalf("%s: %v", script, err)
+		}
+		if result.ExitCode != 0 {
+			t.Fatalf("%s: exit %d: %s", script, result.ExitCode, result.Stderr)
+		}
+		return string(result.Stdout)
+	}
+	decode := func(t *testing.T, out string, v any) {
+		t.Helper()
+		if err := json.Unmarshal([]byte(out), v); err != nil {
+			t.Fatalf("output %q: %v", out, err)
+		}
+	}
+
+	t.Run("json-flatten", func(t *testing.T) {
+		var got map[string]any
+		decode(t, run(t, "json-flatten", `{"a": {"b": [1, 2]}, "c": "x", "d": {}, "e": null}`), &got)
+		want := map[string]any{"a.b.0": 1.0, "a.b.1": 2.0, "c": "x", "e": nil}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("json-flatten = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("csv-to-json", func(t *testing.T) {
+		var got []map[string]string
+		decode(t, run(t, "csv-to-json -w status=failed -c id", "id,status\n1,ok\n2,failed\n3,\"failed\"\n"), &got)
+		want := []map[string]string{{"id": "2"}, {"id": "3"}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("csv-to-json = %v, want %v", got, want)
+		}
+		if out := run(t, "csv-to-json", ""); strings.TrimSpace(out) != "[]" {
+			t.Errorf("csv-to-json of nothing = %q, want []", out)
+		}
+	})
+
+	t.Run("log-summary", func(t *testing.T) {
+		log := "INFO start\nDEBUG x\nERROR one\nWARN low disk\nERROR two\nplain\nERROR three"
+		got := run(t, "log-summary 2", log)
+		want := "lines: 7\nERROR: 3\nWARN: 1\nINFO: 1\nDEBUG: 1\nother: 1\nlast errors:\nERROR two\nERROR three\n"
+		if got != want {
+			t.Errorf("log-summary = %q, want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,4 @@
+# Convert CSV with a header row on stdin to a JSON array with one object
+# per row, keyed by column name. Options are passed on to csv: -t reads TSV,
+# -c COLS keeps only the named columns and -w COND filters rows.
+csv --to-json "$@" | jq -c -s .
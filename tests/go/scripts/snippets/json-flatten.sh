@@ -0,0 +1,8 @@
+# Flatten the JSON value on stdin into one object keyed by the dotted path
+# of each scalar, so {"a": {"b": [1, 2]}} becomes {"a.b.0": 1, "a.b.1": 2}.
+# Empty objects and arrays are dropped.
+jq -c '[path(..) as $p
+    | getpath($p)
+    | select(type != "object" and type != "array")
+    | {key: ($p | map(tostring) | join(".")), value: .}]
+  | from_entries'
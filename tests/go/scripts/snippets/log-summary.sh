@@ -0,0 +1,25 @@
+# Summarize the log on stdin: the number of lines at each level, the most
+# severe of ERROR, WARN, INFO or DEBUG the line contains, and the last
+# errors, 5 unless another count is given as $1.
+local limit=${1:-5} line errors=
+local total=0 error=0 warn=0 info=0 debug=0 other=0
+while IFS= read -r line || [ -n "$line" ]; do
+    total=$((total + 1))
+    case $line in
+    *ERROR*)
+        error=$((error + 1))
+        errors="$errors$line
+"
+        ;;
+    *WARN*) warn=$((warn + 1)) ;;
+    *INFO*) info=$((info + 1)) ;;
+    *DEBUG*) debug=$((debug + 1)) ;;
+    *) other=$((other + 1)) ;;
+    esac
+done
+printf 'lines: %d\nERROR: %d\nWARN: %d\nINFO: %d\nDEBUG: %d\nother: %d\n' \
+    "$total" "$error" "$warn" "$info" "$debug" "$other"
+if [ "$error" -gt 0 ]; then
+    echo "last errors:"
+    printf '%s' "$errors" | tail -n "$limit"
+fi
@@ -0,0 +1,269 @@
+package conch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Session runs a sequence of scripts on an executor, carrying named data
+// between Go and the scripts. Each datum is a shell variable: a script reads
+// it as "$name" and hands a new value back by assigning it, with no quoting
+// on either side. Only data already in the session are read back, so set a
+// datum, if only to empty, before a script that produces it. Unsetting it in
+// a script removes it from the session.
+//
+// Executions on a session run one at a time; other state, such as files
+// written by a script, still lasts only for its execution.
+//
+// Libraries of functions can be sourced into a session with Source, and
+// every later script on it runs after them.
+type Session struct {
+	exec *Executor
+
+	mu      sync.Mutex
+	data    map[string][]byte
+	sources []sourcedScript
+}
+
+// sourcedScript is a library sourced into a session.
+type sourcedScript struct {
+	name      string
+	script    string
+	hash      string
+	functions []string
+}
+
+// NewSession returns an empty session executing on exec.
+func NewSession(exec *Executor) *Session {
+	return &Session{exec: exec, data: map[string][]byte{}}
+}
+
+// SetData sets the datum name, seen by later scripts as the variable $name.
+// name must be a shell variable name other than one the shell gives a
+// meaning to, such as PATH or IFS; see shellVar. data must be UTF-8
+// without NUL bytes, as shell variables are, so encode binary data, for
+// example as base64, first.
+func (s *Session) SetData(name string, data []byte) error {
+	if !validVarName(name) {
+		return fmt.Errorf("invalid data name %q", name)
+	}
+	if shellVar(name) {
+		return fmt.Errorf("data name %q is a variable the shell uses", name)
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return fmt.Errorf("data %q contains a NUL byte", name)
+	}
+	if !utf8.Valid(data) {
+		return fmt.Errorf("data %q is not valid UTF-8", name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[name] = bytes.Clone(data)
+	return nil
+}
+
+// GetData returns the datum name as the last script left it, and whether it
+// is set.
+func (s *Session) GetData(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.data[name]
+	return bytes.Clone(data), ok
+}
+
+// DeleteData removes the datum name.
+func (s *Session) DeleteData(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, name)
+}
+
+// DataNames returns the names of the data set, sorted.
+func (s *Session) DataNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.data))
+	for name := range s.data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Source adds script, a vetted library named name, to the session, as if
+// every later script began with ". name". Sourcing a name again replaces
+// that library in place. A library should only define functions and
+// variables: it runs before every script, and one that fails to parse
+// fails them all.
+//
+// Like the InitScript, libraries are trusted: AllowedScriptHashes, Policy
+// and MaxScriptBytes apply to each script without them. The audit event of
+// every execution lists them with the functions each defines, found by
+// their name() or function name definitions, so the functions a script
+// calls can be attributed to their library.
+func (s *Session) Source(name, script string) error {
+	if name == "" {
+		return errors.New("library name is empty")
+	}
+	if strings.IndexByte(script, 0) >= 0 {
+		return fmt.Errorf("library %s contains a NUL byte", name)
+	}
+	src := sourcedScript{
+		name:      name,
+		script:    script,
+		hash:      ScriptHash(script),
+		functions: definedFunctions(script),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.sources {
+		if s.sources[i].name == name {
+			s.sources[i] = src
+			return nil
+		}
+	}
+	s.sources = append(s.sources, src)
+	return nil
+}
+
+// Sources returns the names of the libraries sourced into the session, in
+// the order they run.
+func (s *Session) Sources() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, len(s.sources))
+	for i, src := range s.sources {
+		names[i] = src.name
+	}
+	return names
+}
+
+// Execute runs script with the session's data; see ExecuteWithOptions.
+func (s *Session) Execute(ctx context.Context, script string) (*Result, error) {
+	return s.ExecuteWithOptions(ctx, script, ExecOptions{})
+}
+
+// ExecuteWithOptions runs script with the session's data set as variables,
+// then updates the data from their values when the script finished. Data
+// is left unchanged if the execution fails or is stopped. opts.Vars and
+// opts.CaptureVars are extended with the data.
+func (s *Session) ExecuteWithOptions(ctx context.Context, script string, opts ExecOptions) (*Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vars := make(map[string]string, len(opts.Vars)+len(s.data))
+	for name, value := range opts.Vars {
+		vars[name] = value
+	}
+	names := append([]string(nil), opts.CaptureVars...)
+	for name, data := range s.data {
+		vars[name] = string(data)
+		names = append(names, name)
+	}
+	opts.Vars, opts.CaptureVars = vars, names
+	opts.sources = s.sources
+
+	result, err := s.exec.ExecuteWithOptions(ctx, script, opts)
+	if err != nil {
+		return result, err
+	}
+	for name := range s.data {
+		if value, ok := result.Vars[name]; ok {
+			s.data[name] = []byte(value)
+		} else {
+			delete(s.data, name)
+		}
+	}
+	return result, nil
+}
+
+// validVarName reports whether name is usable as a shell variable name: a
+// letter or underscore followed by letters, digits or underscores.
+func validVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case i > 0 && c >= '0' && c <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// shellVars are the variables the shell reads or sets itself, beyond those
+// with the prefixes in shellVarPrefixes.
+var shellVars = map[string]bool{
+	"CDPATH": true, "COLUMNS": true, "ENV": true, "EUID": true, "FUNCNAME": true,
+	"GLOBIGNORE": true, "GROUPS": true, "HISTFILE": true, "HOME": true,
+	"HOSTNAME": true, "IFS": true, "LANG": true, "LINENO": true, "LINES": true,
+	"OLDPWD": true, "OPTARG": true, "OPTERR": true, "OPTIND": true, "PATH": true,
+	"PIPESTATUS": true, "PPID": true, "PROMPT_COMMAND": true, "PS1": true,
+	"PS2": true, "PS4": true, "PWD": true, "RANDOM": true, "REPLY": true,
+	"SECONDS": true, "SHELL": true, "SHELLOPTS": true, "SHLVL": true,
+	"TERM": true, "TMPDIR": true, "UID": true,
+}
+
+// shellVarPrefixes start the names of families of variables the shell or
+// conch gives a meaning to.
+var shellVarPrefixes = []string{"BASH", "CONCH_", "LC_"}
+
+// shellVar reports whether name is a variable the shell or conch gives a
+// meaning to, so that setting it would change how scripts run.
+func shellVar(name string) bool {
+	if shellVars[name] {
+		return true
+	}
+	for _, prefix := range shellVarPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// functionDef matches the start of a shell function definition on a line of
+// its own: "name()" or "function name".
+var functionDef = regexp.MustCompile(`(?m)^[ \t]*(?:function[ \t]+([A-Za-z_][A-Za-z0-9_-]*)|([A-Za-z_][A-Za-z0-9_-]*)[ \t]*\(\))`)
+
+// definedFunctions returns the names of the functions script defines, in
+// order of first definition.
+func definedFunctions(script string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, m := range functionDef.FindAllStringSubmatch(script, -1) {
+		name := m[1] + m[2]
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// withSources returns script preceded by sources.
+func withSources(sources []sourcedScript, script string) string {
+	if len(sources) == 0 {
+		return script
+	}
+	var sb strings.Builder
+	for _, src := range sources {
+		sb.WriteString(src.script)
+		sb.WriteByte('\n')
+	}
+	sb.WriteString(script)
+	return sb.String()
+}
@@ -0,0 +1,166 @@
+package conch
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestSessionData(t *testing.T) {
+	s := NewSession(nil)
+	for _, name := range []string{"", "1x", "a-b", "a b", "PATH", "IFS", "BASH_ENV", "CONCH_REGEX_MAX_STATES"} {
+		if err := s.SetData(name, nil); err == nil {
+			t.Errorf("SetData(%q) succeeded", name)
+		}
+	}
+	if err := s.SetData("blob", []byte("a\x00b")); err == nil {
+		t.Error("SetData(NUL byte) succeeded")
+	}
+	if err := s.SetData("blob", []byte{0xff, 0xfe}); err == nil {
+		t.Error("SetData(invalid UTF-8) succeeded")
+	}
+
+	data := []byte("value")
+	if err := s.SetData("b", data); err != nil {
+		t.Fatalf("SetData() error: %v", err)
+	}
+	if err := s.SetData("a_1", nil); err != nil {
+		t.Fatalf("SetData() error: %v", err)
+	}
+	data[0] = 'V'
+	got, ok := s.GetData("b")
+	if !ok || string(got) != "value" {
+		t.Errorf("GetData(b) = %q, %v; want the data as set", got, ok)
+	}
+	got[0] = 'X'
+	if got, _ := s.GetData("b"); string(got) != "value" {
+		t.Errorf("GetData(b) = %q after modifying a returned copy", got)
+	}
+	if names := s.DataNames(); !reflect.DeepEqual(names, []string{"a_1", "b"}) {
+		t.Errorf("DataNames() = %q", names)
+	}
+	s.DeleteData("b")
+	if _, ok := s.GetData("b"); ok {
+		t.Error("GetData(b) found deleted data")
+	}
+}
+
+func TestSessionExecute(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	s := NewSession(exec)
+	input := "it's \"quoted\" $HOME `x`\nand multi-line\n"
+	if err := s.SetData("input", []byte(input)); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"output", "scratch"} {
+		if err := s.SetData(name, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := s.Execute(context.Background(),
+		`printf '%s' "$input"; output=$(printf '%s' "$input" | wc -l); unset scratch`)
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if string(result.Stdout) != input {
+		t.Errorf("stdout = %q, want %q", result.Stdout, input)
+	}
+	if got, _ := s.GetData("output"); string(got) != "2" {
+		t.Errorf("GetData(output) = %q, want \"2\"", got)
+	}
+	if _, ok := s.GetData("scratch"); ok {
+		t.Error("GetData(scratch) found data the script unset")
+	}
+
+	// Data carry over to the next script.
+	result, err = s.Execute(context.Background(), `echo "lines: $output"; output=$((output + 1))`)
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if string(result.Stdout) != "lines: 2\n" {
+		t.Errorf("stdout = %q", result.Stdout)
+	}
+	if got, _ := s.GetData("output"); string(got) != "3" {
+		t.Errorf("GetData(output) = %q, want \"3\"", got)
+	}
+}
+
+func TestDefinedFunctions(t *testing.T) {
+	script := `# helpers
+greet() { echo "hi $1"; }
+  function shout {
+	echo "$1!"
+}
+greet() { echo "hello $1"; }
+echo "not() a definition"
+x=1 # y()
+`
+	if got, want := definedFunctions(script), []string{"greet", "shout"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("definedFunctions() = %q, want %q", got, want)
+	}
+}
+
+func TestSessionSources(t *testing.T) {
+	s := NewSession(&Executor{})
+	for _, src := range []struct{ name, script string }{
+		{"strings.sh", "upper() { tr a-z A-Z; }"},
+		{"math.sh", "add() { echo $(($1 + $2)); }"},
+		{"strings.sh", "upper() { tr '[:lower:]' '[:upper:]'; }"},
+	} {
+		if err := s.Source(src.name, src.script); err != nil {
+			t.Fatalf("Source(%s) error: %v", src.name, err)
+		}
+	}
+	if got := s.Sources(); !reflect.DeepEqual(got, []string{"strings.sh", "math.sh"}) {
+		t.Errorf("Sources() = %q", got)
+	}
+	if got := withSources(s.sources, "upper"); got != "upper() { tr '[:lower:]' '[:upper:]'; }\nadd() { echo $(($1 + $2)); }\nupper" {
+		t.Errorf("withSources() = %q", got)
+	}
+	if err := s.Source("", "true"); err == nil {
+		t.Error("Source() with no name succeeded")
+	}
+	if err := s.Source("nul.sh", "a\x00b"); err == nil {
+		t.Error("Source() with a NUL byte succeeded")
+	}
+}
+
+func TestSessionSource(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	rec := &auditRecorder{}
+	exec, err := New(WithEmbedded(), WithAuditSink(rec), WithAllowedScriptHashes(ScriptHash("greet world")))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	s := NewSession(exec)
+	if err := s.Source("greet.sh", `greet() { echo "hello $1"; }`); err != nil {
+		t.Fatalf("Source() error: %v", err)
+	}
+	result, err := s.Execute(context.Background(), "greet world")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if string(result.Stdout) != "hello world\n" {
+		t.Errorf("stdout = %q", result.Stdout)
+	}
+
+	start := rec.get()[0]
+	if start.ScriptSHA256 != ScriptHash("greet world") {
+		t.Errorf("ScriptSHA256 = %s, want the hash of the script alone", start.ScriptSHA256)
+	}
+	want := []AuditSource{{Name: "greet.sh", SHA256: ScriptHash(`greet() { echo "hello $1"; }`), Functions: []string{"greet"}}}
+	if !reflect.DeepEqual(start.Sources, want) {
+		t.Errorf("Sources = %+v, want %+v", start.Sources, want)
+	}
+}
@@ -0,0 +1,62 @@
+package conch
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+)
+
+// ErrBadSignature is wrapped by the error returned when a script's signature
+// does not verify.
+var ErrBadSignature = errors.New("script signature does not verify")
+
+// ScriptVerifier checks that signature was made over script by a trusted
+// signer, returning an error wrapping ErrBadSignature if it was not.
+type ScriptVerifier interface {
+	VerifyScript(script string, signature []byte) error
+}
+
+// ScriptVerifierFunc adapts a function to ScriptVerifier.
+type ScriptVerifierFunc func(script string, signature []byte) error
+
+// VerifyScript calls f.
+func (f ScriptVerifierFunc) VerifyScript(script string, signature []byte) error {
+	return f(script, signature)
+}
+
+// Ed25519Verifier returns a ScriptVerifier accepting signatures made by
+// SignScript with the private key of pub.
+func Ed25519Verifier(pub ed25519.PublicKey) ScriptVerifier {
+	return ScriptVerifierFunc(func(script string, signature []byte) error {
+		if len(pub) != ed25519.PublicKeySize {
+			return fmt.Errorf("%w: invalid Ed25519 public key length %d", ErrBadSignature, len(pub))
+		}
+		if !ed25519.Verify(pub, []byte(script), signature) {
+			return ErrBadSignature
+		}
+		return nil
+	})
+}
+
+// SignScript returns the Ed25519 signature of script under key, for
+// ExecuteSigned. The signature covers the script's exact bytes.
+func SignScript(script string, key ed25519.PrivateKey) []byte {
+	return ed25519.Sign(key, []byte(script))
+}
+
+// ExecuteSigned runs script with the executor's limits, as Execute does, if
+// signature is its Ed25519 signature under pubkey, as made by SignScript.
+// Otherwise it returns an error wrapping ErrBadSignature without running
+// anything.
+func (e *Executor) ExecuteSigned(script string, signature []byte, pubkey ed25519.PublicKey) (*Result, error) {
+	return e.ExecuteVerified(script, signature, Ed25519Verifier(pubkey))
+}
+
+// ExecuteVerified is ExecuteSigned with the signature checked by v, for
+// signing schemes other than Ed25519.
+func (e *Executor) ExecuteVerified(script string, signature []byte, v ScriptVerifier) (*Result, error) {
+	if err := v.VerifyScript(script, signature); err != nil {
+		return nil, err
+	}
+	return e.Execute(script)
+}
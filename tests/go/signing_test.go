@@ -0,0 +1,68 @@
+package conch
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+)
+
+func TestEd25519Verifier(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := SignScript("echo hi", priv)
+
+	if err := Ed25519Verifier(pub).VerifyScript("echo hi", sig); err != nil {
+		t.Errorf("VerifyScript() error: %v", err)
+	}
+	for name, check := range map[string]error{
+		"modified script": Ed25519Verifier(pub).VerifyScript("echo hi ", sig),
+		"other key":       Ed25519Verifier(otherPub).VerifyScript("echo hi", sig),
+		"short key":       Ed25519Verifier(pub[:5]).VerifyScript("echo hi", sig),
+		"no signature":    Ed25519Verifier(pub).VerifyScript("echo hi", nil),
+	} {
+		if !errors.Is(check, ErrBadSignature) {
+			t.Errorf("%s: error = %v, want ErrBadSignature", name, check)
+		}
+	}
+}
+
+func TestExecuteSignedRejectsBeforeRunning(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A zero Executor would fail to run anything; the signature is checked
+	// first.
+	var e Executor
+	if _, err := e.ExecuteSigned("echo hi", []byte("forged"), pub); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("ExecuteSigned() error = %v, want ErrBadSignature", err)
+	}
+}
+
+func TestExecuteSigned(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.ExecuteSigned("echo signed", SignScript("echo signed", priv), pub)
+	if err != nil {
+		t.Fatalf("ExecuteSigned() error: %v", err)
+	}
+	if string(result.Stdout) != "signed\n" {
+		t.Errorf("Stdout = %q", result.Stdout)
+	}
+}
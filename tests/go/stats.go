@@ -0,0 +1,111 @@
+package conch
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// conchStats mirrors ConchStats in ffi.rs.
+type conchStats struct {
+	LiveExecutors     uint64
+	WasmMemoryBytes   uint64
+	CompiledCodeBytes uint64
+	Executions        uint64
+}
+
+// LibraryStats is a snapshot of the native library's resource use, for
+// capacity planning.
+type LibraryStats struct {
+	// Executors is the number of executors created and not yet closed.
+	Executors int
+	// WasmMemoryBytes is the linear memory currently allocated by running
+	// executions.
+	WasmMemoryBytes uint64
+	// CompileCacheBytes is the compiled machine code held for the shell
+	// components of open executors. Each executor compiles its own copy.
+	CompileCacheBytes uint64
+	// Executions counts the executions started since the library was
+	// loaded.
+	Executions uint64
+}
+
+// Stats reports the native library's resource use across every executor in
+// the process. The counters are read one at a time, so they may be slightly
+// out of step while executions run. After Reload it describes the library
+// new executors are created from.
+func Stats() (LibraryStats, error) {
+	l, err := acquireLibrary()
+	if err != nil {
+		return LibraryStats{}, err
+	}
+	defer l.release()
+	if err := l.statistics.load(); err != nil {
+		return LibraryStats{}, err
+	}
+
+	ebuf := newErrorBuffer()
+	var s conchStats
+	if l.stats(uintptr(unsafe.Pointer(&s)), ebuf.ptr(), errorBufferSize) != 0 {
+		return LibraryStats{}, fmt.Errorf("failed to read stats: %s", ebuf)
+	}
+	return LibraryStats{
+		Executors:         int(s.LiveExecutors),
+		WasmMemoryBytes:   s.WasmMemoryBytes,
+		CompileCacheBytes: s.CompiledCodeBytes,
+		Executions:        s.Executions,
+	}, nil
+}
+
+// ExecutorStats is a snapshot of one executor's executions, tagged with its
+// labels so a fleet of executors can be told apart.
+type ExecutorStats struct {
+	// Labels are the executor's labels; see WithLabels.
+	Labels map[string]string
+	// Executions counts the executions started on the executor, including
+	// those refused before running, such as by Policy.
+	Executions uint64
+	// Running is the number of executions in progress.
+	Running int
+	// Failed counts the executions that returned an error. A script
+	// exiting non-zero is not a failure.
+	Failed uint64
+}
+
+// executorCounts are the counters behind ExecutorStats.
+type executorCounts struct {
+	executions uint64
+	running    int
+	failed     uint64
+}
+
+// Stats reports the executor's executions. Unlike the package-level Stats,
+// it covers only this executor and is counted by the bindings, so it works
+// with any library.
+func (e *Executor) Stats() ExecutorStats {
+	e.countsMu.Lock()
+	defer e.countsMu.Unlock()
+	return ExecutorStats{
+		Labels:     copyLabels(e.labels),
+		Executions: e.counts.executions,
+		Running:    e.counts.running,
+		Failed:     e.counts.failed,
+	}
+}
+
+// countStarted counts an execution as started.
+func (e *Executor) countStarted() {
+	e.countsMu.Lock()
+	defer e.countsMu.Unlock()
+	e.counts.executions++
+	e.counts.running++
+}
+
+// countFinished counts a started execution as returning err.
+func (e *Executor) countFinished(err error) {
+	e.countsMu.Lock()
+	defer e.countsMu.Unlock()
+	e.counts.running--
+	if err != nil {
+		e.counts.failed++
+	}
+}
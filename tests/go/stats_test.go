@@ -0,0 +1,56 @@
+package conch
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestConchStatsLayout(t *testing.T) {
+	if got := unsafe.Sizeof(conchStats{}); got != 32 {
+		t.Errorf("sizeof(conchStats) = %d, want 32", got)
+	}
+}
+
+func TestStats(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	before, err := Stats()
+	if err != nil {
+		t.Fatalf("Stats() error: %v", err)
+	}
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	if _, err := exec.Execute("echo hi"); err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+
+	open, err := Stats()
+	if err != nil {
+		t.Fatalf("Stats() error: %v", err)
+	}
+	if open.Executors != before.Executors+1 {
+		t.Errorf("Executors = %d with an executor open, want %d", open.Executors, before.Executors+1)
+	}
+	if open.CompileCacheBytes <= before.CompileCacheBytes {
+		t.Errorf("CompileCacheBytes = %d, want more than %d", open.CompileCacheBytes, before.CompileCacheBytes)
+	}
+	if open.Executions <= before.Executions {
+		t.Errorf("Executions = %d, want more than %d", open.Executions, before.Executions)
+	}
+	// The execution's instance is gone once it returns.
+	if open.WasmMemoryBytes != before.WasmMemoryBytes {
+		t.Errorf("WasmMemoryBytes = %d after the execution returned, want %d", open.WasmMemoryBytes, before.WasmMemoryBytes)
+	}
+
+	exec.Close()
+	closed, err := Stats()
+	if err != nil {
+		t.Fatalf("Stats() error: %v", err)
+	}
+	if closed.Executors != before.Executors || closed.CompileCacheBytes != before.CompileCacheBytes {
+		t.Errorf("Stats() after Close = %+v, want executors and compiled code back to %+v", closed, before)
+	}
+}
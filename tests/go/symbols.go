@@ -0,0 +1,337 @@
+package conch
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/ebitengine/purego"
+)
+
+// ErrUnsupportedByLibrary is returned when a feature needs an export the
+// loaded native library lacks, usually because the library is older than
+// these bindings. Everything else keeps working.
+type ErrUnsupportedByLibrary struct {
+	// Symbol is the missing export, such as "conch_execute_streaming".
+	Symbol string
+}
+
+func (e ErrUnsupportedByLibrary) Error() string {
+	return fmt.Sprintf("conch library does not export %s; upgrade libconch to use this feature", e.Symbol)
+}
+
+// library is a loaded libconch with Go functions bound to its exports.
+// Executors keep the library they were created from, so one loaded by
+// Reload does not affect those already open.
+type library struct {
+	path   string
+	handle uintptr
+
+	mu sync.Mutex
+	// refs counts the open executors created from the library and the
+	// calls in progress on it.
+	refs int
+	// retired is set once Reload replaces the library, which is then
+	// closed when refs drops to zero.
+	retired bool
+	closed  bool
+
+	// Core exports, registered when the library is opened.
+	lastError            func() uintptr
+	resultFree           func(uintptr)
+	hasEmbeddedShell     func() uint8
+	executorNew          func(uintptr, *byte, uintptr) uintptr
+	executorNewFromBytes func(uintptr, uintptr, *byte, uintptr) uintptr
+	executorFree         func(uintptr)
+	execute              func(uintptr, uintptr, *byte, uintptr) uintptr
+	executeWithLimits    func(uintptr, uintptr, uint64, uint64, uint64, uint64, *byte, uintptr) uintptr
+
+	// Optional exports, registered with their feature on first use.
+	executorNewEmbedded        func(*byte, uintptr) uintptr
+	executorDefineFunction     func(uintptr, uintptr, uintptr, *byte, uintptr) int32
+	executorSetInitScript      func(uintptr, uintptr, *byte, uintptr) int32
+	executorAddFile            func(uintptr, uintptr, uintptr, uintptr, *byte, uintptr) int32
+	executorSetTmp             func(uintptr, uint64, uintptr, *byte, uintptr) int32
+	executorSetLabels          func(uintptr, uintptr, *byte, uintptr) int32
+	executorSetAllowedCommands func(uintptr, uintptr, uintptr, *byte, uintptr) int32
+	executorSetCommandHandler  func(uintptr, uintptr, uintptr, *byte, uintptr) int32
+	commandOutputSet           func(uintptr, int32, uintptr, uintptr, uintptr, uintptr)
+	executorSetPromptHandler   func(uintptr, uintptr, uintptr, *byte, uintptr) int32
+	promptAnswerSet            func(uintptr, uintptr, uintptr)
+	executorSetConnectHandler  func(uintptr, uintptr, uintptr, *byte, uintptr) int32
+	executeInterruptible       func(uintptr, uintptr, uint64, uint64, uint64, uint64, uintptr, *byte, uintptr) uintptr
+	executorTick               func(uintptr)
+	interruptNew               func() uintptr
+	interruptTrigger           func(uintptr)
+	interruptExpire            func(uintptr)
+	interruptTakeResult        func(uintptr) uintptr
+	interruptSetID             func(uintptr, uintptr, *byte, uintptr) int32
+	interruptFree              func(uintptr)
+	executeWithStdin           func(uintptr, uintptr, uintptr, uintptr, uint64, uint64, uint64, uint64, uintptr, *byte, uintptr) uintptr
+	executeStreaming           func(uintptr, uintptr, uintptr, uintptr, uintptr, uint64, uint64, uint64, uint64, uintptr, *byte, uintptr) uintptr
+	executeWithTerminal        func(uintptr, uintptr, uintptr, uintptr, uintptr, uint64, uint64, uint64, uint64, uintptr, *byte, uintptr) uintptr
+	terminalNew                func(uint16, uint16) uintptr
+	terminalResize             func(uintptr, uint16, uint16)
+	terminalFree               func(uintptr)
+	version                    func() uintptr
+	shellInterfaceVersion      func() uintptr
+	embeddedComponentBytes     func(uintptr) uintptr
+	supportedFeatures          func() uintptr
+	stats                      func(uintptr, *byte, uintptr) int32
+	resultLayout               func(uintptr, uintptr) uintptr
+	interruptActivity          func(uintptr) uint64
+	interruptSetOutput         func(uintptr, uintptr, uintptr, *byte, uintptr) int32
+	interruptSetCapture        func(uintptr, uint8, *byte, uintptr) int32
+	interruptSetCompression    func(uintptr, uint8, uintptr, *byte, uintptr) int32
+	interruptCaptureVar        func(uintptr, uintptr, *byte, uintptr) int32
+	interruptVar               func(uintptr, uintptr) uintptr
+	interruptSetVar            func(uintptr, uintptr, uintptr, *byte, uintptr) int32
+	lastErrorCopy              func() uintptr
+	stringFree                 func(uintptr)
+	resultFreeChecked          func(uintptr, *byte, uintptr) int32
+	commandExecutionID         func(uintptr) uintptr
+	promptExecutionID          func(uintptr) uintptr
+	commandInterrupt           func(uintptr) uintptr
+	promptInterrupt            func(uintptr) uintptr
+	executeWithLimitsV2        func(uintptr, uintptr, *conchLimits, *byte, uintptr) uintptr
+	executeInterruptibleV2     func(uintptr, uintptr, *conchLimits, uintptr, *byte, uintptr) uintptr
+	executeWithStdinV2         func(uintptr, uintptr, uintptr, uintptr, *conchLimits, uintptr, *byte, uintptr) uintptr
+	executeWithTerminalV2      func(uintptr, uintptr, uintptr, uintptr, uintptr, *conchLimits, uintptr, *byte, uintptr) uintptr
+	executeStreamingV2         func(uintptr, uintptr, uintptr, uintptr, uintptr, *conchLimits, uintptr, *byte, uintptr) uintptr
+	parseScript                func(uintptr, *byte, uintptr) uintptr
+
+	// Optional features of the library.
+	embedded, functions, tmp, commands, prompt, interrupt, stdin, streaming, terminal, versions, features, statistics, layout, watchdog, output, capture, compression, vars, assign, errorCopy, resultCheck, commandContext, promptContext, labels, network, limitsV2, allowedCommands, parser, hookInterrupts libFeature
+}
+
+// libSymbol binds a Go function variable to a native export.
+//
+// Exports named with the suffix "_err" take an error buffer and its length
+// as their last two arguments; see errorBuffer. Libraries that predate them
+// have the export without the suffix instead, which is bound in its place,
+// reading the message of a failure with conch_last_error on the same OS
+// thread.
+type libSymbol struct {
+	fptr any
+	name string
+}
+
+// libFeature is a group of exports registered together the first time the
+// feature is used, so a library missing them fails only that feature.
+type libFeature struct {
+	lib     *library
+	once    sync.Once
+	err     error
+	symbols []libSymbol
+}
+
+// openLibrary loads the library at path with dlopen mode, registers its
+// core exports and checks that ConchResult matches the library's layout.
+func openLibrary(path string, mode int) (*library, error) {
+	handle, err := purego.Dlopen(path, mode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load library %s: %w", path, err)
+	}
+	l := newLibrary(path, handle)
+	if err := l.register(); err != nil {
+		_ = purego.Dlclose(handle)
+		return nil, fmt.Errorf("failed to load library %s: %w", path, err)
+	}
+	if err := l.checkResultLayout(); err != nil {
+		_ = purego.Dlclose(handle)
+		return nil, fmt.Errorf("failed to load library %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// newLibrary describes the exports of the library loaded as handle.
+func newLibrary(path string, handle uintptr) *library {
+	l := &library{path: path, handle: handle}
+	feature := func(f *libFeature, symbols ...libSymbol) {
+		f.lib = l
+		f.symbols = symbols
+	}
+	feature(&l.embedded,
+		libSymbol{&l.executorNewEmbedded, "conch_executor_new_embedded_err"})
+	feature(&l.functions,
+		libSymbol{&l.executorDefineFunction, "conch_executor_define_function_err"},
+		libSymbol{&l.executorSetInitScript, "conch_executor_set_init_script_err"},
+		libSymbol{&l.executorAddFile, "conch_executor_add_file_err"})
+	feature(&l.tmp,
+		libSymbol{&l.executorSetTmp, "conch_executor_set_tmp_err"})
+	feature(&l.labels,
+		libSymbol{&l.executorSetLabels, "conch_executor_set_labels_err"})
+	feature(&l.allowedCommands,
+		libSymbol{&l.executorSetAllowedCommands, "conch_executor_set_allowed_commands_err"})
+	feature(&l.commands,
+		libSymbol{&l.executorSetCommandHandler, "conch_executor_set_command_handler_err"},
+		libSymbol{&l.commandOutputSet, "conch_command_output_set"})
+	feature(&l.prompt,
+		libSymbol{&l.executorSetPromptHandler, "conch_executor_set_prompt_handler_err"},
+		libSymbol{&l.promptAnswerSet, "conch_prompt_answer_set"})
+	feature(&l.network,
+		libSymbol{&l.executorSetConnectHandler, "conch_executor_set_connect_handler_err"})
+	feature(&l.interrupt,
+		libSymbol{&l.executeInterruptible, "conch_execute_interruptible_err"},
+		libSymbol{&l.executorTick, "conch_executor_tick"},
+		libSymbol{&l.interruptNew, "conch_interrupt_new"},
+		libSymbol{&l.interruptTrigger, "conch_interrupt_trigger"},
+		libSymbol{&l.interruptExpire, "conch_interrupt_expire"},
+		libSymbol{&l.interruptTakeResult, "conch_interrupt_take_result"},
+		libSymbol{&l.interruptSetID, "conch_interrupt_set_id_err"},
+		libSymbol{&l.interruptFree, "conch_interrupt_free"})
+	feature(&l.stdin,
+		libSymbol{&l.executeWithStdin, "conch_execute_with_stdin_err"})
+	feature(&l.streaming,
+		libSymbol{&l.executeStreaming, "conch_execute_streaming_err"})
+	feature(&l.terminal,
+		libSymbol{&l.executeWithTerminal, "conch_execute_with_terminal_err"},
+		libSymbol{&l.terminalNew, "conch_terminal_new"},
+		libSymbol{&l.terminalResize, "conch_terminal_resize"},
+		libSymbol{&l.terminalFree, "conch_terminal_free"})
+	feature(&l.versions,
+		libSymbol{&l.version, "conch_version"},
+		libSymbol{&l.shellInterfaceVersion, "conch_shell_interface_version"},
+		libSymbol{&l.embeddedComponentBytes, "conch_embedded_component_bytes"})
+	feature(&l.features,
+		libSymbol{&l.supportedFeatures, "conch_supported_features"})
+	feature(&l.statistics,
+		libSymbol{&l.stats, "conch_stats_err"})
+	feature(&l.layout,
+		libSymbol{&l.resultLayout, "conch_result_layout"})
+	feature(&l.watchdog,
+		libSymbol{&l.interruptActivity, "conch_interrupt_activity"})
+	feature(&l.output,
+		libSymbol{&l.interruptSetOutput, "conch_interrupt_set_output_err"})
+	feature(&l.capture,
+		libSymbol{&l.interruptSetCapture, "conch_interrupt_set_capture_err"})
+	feature(&l.compression,
+		libSymbol{&l.interruptSetCompression, "conch_interrupt_set_compression_err"})
+	feature(&l.vars,
+		libSymbol{&l.interruptCaptureVar, "conch_interrupt_capture_var_err"},
+		libSymbol{&l.interruptVar, "conch_interrupt_var"})
+	feature(&l.assign,
+		libSymbol{&l.interruptSetVar, "conch_interrupt_set_var_err"})
+	feature(&l.errorCopy,
+		libSymbol{&l.lastErrorCopy, "conch_last_error_copy"},
+		libSymbol{&l.stringFree, "conch_string_free"})
+	feature(&l.resultCheck,
+		libSymbol{&l.resultFreeChecked, "conch_result_free_checked_err"})
+	feature(&l.commandContext,
+		libSymbol{&l.commandExecutionID, "conch_command_execution_id"})
+	feature(&l.promptContext,
+		libSymbol{&l.promptExecutionID, "conch_prompt_execution_id"})
+	feature(&l.hookInterrupts,
+		libSymbol{&l.commandInterrupt, "conch_command_interrupt"},
+		libSymbol{&l.promptInterrupt, "conch_prompt_interrupt"})
+	feature(&l.limitsV2,
+		libSymbol{&l.executeWithLimitsV2, "conch_execute_with_limits_v2_err"},
+		libSymbol{&l.executeInterruptibleV2, "conch_execute_interruptible_v2_err"},
+		libSymbol{&l.executeWithStdinV2, "conch_execute_with_stdin_v2_err"},
+		libSymbol{&l.executeWithTerminalV2, "conch_execute_with_terminal_v2_err"},
+		libSymbol{&l.executeStreamingV2, "conch_execute_streaming_v2_err"})
+	feature(&l.parser,
+		libSymbol{&l.parseScript, "conch_parse_script_err"},
+		libSymbol{&l.stringFree, "conch_string_free"})
+	return l
+}
+
+// register binds the core exports, which every supported library has.
+func (l *library) register() error {
+	return l.registerSymbols([]libSymbol{
+		{&l.lastError, "conch_last_error"},
+		{&l.resultFree, "conch_result_free"},
+		{&l.hasEmbeddedShell, "conch_has_embedded_shell"},
+		{&l.executorNew, "conch_executor_new_err"},
+		{&l.executorNewFromBytes, "conch_executor_new_from_bytes_err"},
+		{&l.executorFree, "conch_executor_free"},
+		{&l.execute, "conch_execute_err"},
+		{&l.executeWithLimits, "conch_execute_with_limits_err"},
+	})
+}
+
+// lastErrorMessage returns the library's last error message for the
+// calling thread, or "".
+//
+// conch_last_error's string is only valid until the next call on the
+// thread, which another goroutine may make before goString finishes
+// reading it, so libraries that can copy the message do.
+func (l *library) lastErrorMessage() string {
+	if l.errorCopy.load() != nil {
+		return goString(l.lastError())
+	}
+	msg := l.lastErrorCopy()
+	defer l.stringFree(msg)
+	return goString(msg)
+}
+
+// load registers the feature's exports, or returns ErrUnsupportedByLibrary
+// naming the first one missing.
+func (f *libFeature) load() error {
+	f.once.Do(func() {
+		f.err = f.lib.registerSymbols(f.symbols)
+	})
+	return f.err
+}
+
+// registerSymbols binds every symbol in symbols to the library, or none if
+// any is missing.
+func (l *library) registerSymbols(symbols []libSymbol) error {
+	addrs := make([]uintptr, len(symbols))
+	legacy := make([]bool, len(symbols))
+	for i, s := range symbols {
+		addr, err := purego.Dlsym(l.handle, s.name)
+		if (err != nil || addr == 0) && strings.HasSuffix(s.name, "_err") {
+			addr, err = purego.Dlsym(l.handle, strings.TrimSuffix(s.name, "_err"))
+			legacy[i] = true
+		}
+		if err != nil || addr == 0 {
+			return ErrUnsupportedByLibrary{Symbol: s.name}
+		}
+		addrs[i] = addr
+	}
+	for i, s := range symbols {
+		if legacy[i] {
+			l.bindLegacy(s.fptr, addrs[i])
+		} else {
+			purego.RegisterFunc(s.fptr, addrs[i])
+		}
+	}
+	return nil
+}
+
+// bindLegacy binds fptr, a function variable for an "_err" export, to addr,
+// the same export without the error buffer. The call and the read of its
+// failure message are made on one locked OS thread, and the message is
+// copied into the buffer the caller passed.
+func (l *library) bindLegacy(fptr any, addr uintptr) {
+	fn := reflect.ValueOf(fptr).Elem()
+	typ := fn.Type()
+	in := make([]reflect.Type, typ.NumIn()-2)
+	for i := range in {
+		in[i] = typ.In(i)
+	}
+	native := reflect.New(reflect.FuncOf(in, []reflect.Type{typ.Out(0)}, false))
+	purego.RegisterFunc(native.Interface(), addr)
+
+	fn.Set(reflect.MakeFunc(typ, func(args []reflect.Value) []reflect.Value {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		n := len(args)
+		ret := native.Elem().Call(args[:n-2])
+		var failed bool
+		switch r := ret[0]; r.Kind() {
+		case reflect.Int32:
+			failed = r.Int() != 0
+		default:
+			failed = r.Uint() == 0
+		}
+		if failed {
+			ebuf := args[n-2].Interface().(*byte)
+			writeErrorBuffer(ebuf, uintptr(args[n-1].Uint()), l.lastErrorMessage())
+		}
+		return ret
+	}))
+}
@@ -0,0 +1,140 @@
+package conch
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/ebitengine/purego"
+)
+
+func TestErrUnsupportedByLibrary(t *testing.T) {
+	var err error = ErrUnsupportedByLibrary{Symbol: "conch_execute_streaming"}
+	if !strings.Contains(err.Error(), "conch_execute_streaming") {
+		t.Errorf("Error() = %q, want the symbol name", err)
+	}
+	var unsupported ErrUnsupportedByLibrary
+	if !errors.As(err, &unsupported) || unsupported.Symbol != "conch_execute_streaming" {
+		t.Errorf("errors.As() = %+v", unsupported)
+	}
+}
+
+func TestLibFeatureMissingSymbol(t *testing.T) {
+	l, err := acquireLibrary()
+	if err != nil {
+		t.Skip("Skipping: conch library not available")
+	}
+	defer l.release()
+
+	var present func() uintptr
+	var missing func()
+	f := &libFeature{lib: l, symbols: []libSymbol{
+		{&present, "conch_last_error"},
+		{&missing, "conch_no_such_export"},
+	}}
+	err = f.load()
+	var unsupported ErrUnsupportedByLibrary
+	if !errors.As(err, &unsupported) || unsupported.Symbol != "conch_no_such_export" {
+		t.Fatalf("load() error = %v, want ErrUnsupportedByLibrary for conch_no_such_export", err)
+	}
+	if present != nil {
+		t.Error("load() registered part of a feature it could not load")
+	}
+	if err2 := f.load(); err2 != err {
+		t.Errorf("second load() = %v, want the cached %v", err2, err)
+	}
+}
+
+func TestLibFeaturesLoad(t *testing.T) {
+	l, err := acquireLibrary()
+	if err != nil {
+		t.Skip("Skipping: conch library not available")
+	}
+	defer l.release()
+
+	// The bindings and the library in this repository must agree.
+	for _, f := range []*libFeature{
+		&l.functions, &l.tmp, &l.commands, &l.prompt, &l.interrupt, &l.stdin,
+		&l.streaming, &l.terminal, &l.versions, &l.features, &l.statistics, &l.layout,
+		&l.watchdog, &l.output, &l.capture, &l.vars,
+		&l.assign, &l.errorCopy, &l.resultCheck, &l.commandContext, &l.promptContext,
+		&l.labels, &l.network, &l.limitsV2, &l.allowedCommands, &l.parser,
+		&l.hookInterrupts,
+	} {
+		if err := f.load(); err != nil {
+			t.Errorf("load() error = %v", err)
+		}
+	}
+}
+
+func TestLastErrorCopy(t *testing.T) {
+	l, err := acquireLibrary()
+	if err != nil {
+		t.Skip("Skipping: conch library not available")
+	}
+	defer l.release()
+	if err := l.errorCopy.load(); err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+
+	// The thread-local error must be set and read on the same thread.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if h := l.executorNewFromBytes(0, 0, nil, 0); h != 0 {
+		l.executorFree(h)
+		t.Fatal("executor created from no bytes")
+	}
+	want := goString(l.lastError())
+	if want == "" {
+		t.Fatal("no error set")
+	}
+
+	msg := l.lastErrorCopy()
+	if msg == 0 || msg == l.lastError() {
+		t.Fatalf("lastErrorCopy() = %#x, want a new string", msg)
+	}
+	got := goString(msg)
+	l.stringFree(msg)
+	if got != want {
+		t.Errorf("copy = %q, want %q", got, want)
+	}
+	if got := l.lastErrorMessage(); got != want {
+		t.Errorf("lastErrorMessage() = %q, want %q", got, want)
+	}
+	l.stringFree(0)
+}
+
+func TestErrorBufferExports(t *testing.T) {
+	l, err := acquireLibrary()
+	if err != nil {
+		t.Skip("Skipping: conch library not available")
+	}
+	defer l.release()
+
+	ebuf := newErrorBuffer()
+	if h := l.executorNewFromBytes(0, 0, ebuf.ptr(), errorBufferSize); h != 0 {
+		l.executorFree(h)
+		t.Fatal("executor created from no bytes")
+	}
+	if ebuf.String() == "" {
+		t.Error("no message in the error buffer")
+	}
+
+	// Bound to the export without the buffer, as for an older library, the
+	// message is still delivered through it.
+	addr, err := purego.Dlsym(l.handle, "conch_executor_new_from_bytes")
+	if err != nil {
+		t.Fatalf("Dlsym() error = %v", err)
+	}
+	var legacy func(uintptr, uintptr, *byte, uintptr) uintptr
+	l.bindLegacy(&legacy, addr)
+	got := newErrorBuffer()
+	if h := legacy(0, 0, got.ptr(), errorBufferSize); h != 0 {
+		l.executorFree(h)
+		t.Fatal("executor created from no bytes")
+	}
+	if got.String() != ebuf.String() {
+		t.Errorf("legacy message = %q, want %q", got, ebuf)
+	}
+}
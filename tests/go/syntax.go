@@ -0,0 +1,94 @@
+package conch
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sort"
+	"unsafe"
+)
+
+// syntaxNode is a node of a script's syntax tree, as the library's parser
+// returns it. Start and End are byte offsets into the script.
+type syntaxNode struct {
+	Kind     string       `json:"kind"`
+	Named    bool         `json:"named"`
+	Start    int          `json:"start"`
+	End      int          `json:"end"`
+	Children []syntaxNode `json:"children"`
+}
+
+// parseScript parses script with the library's parser, failing if it is
+// not valid shell. The library does not need the embedded shell for it.
+func parseScript(script string) (*syntaxNode, error) {
+	l, err := acquireLibrary()
+	if err != nil {
+		return nil, err
+	}
+	defer l.release()
+	if err := l.parser.load(); err != nil {
+		return nil, err
+	}
+
+	cScript, err := cString(script)
+	if err != nil {
+		return nil, err
+	}
+	defer freeString(cScript)
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	ebuf := newErrorBuffer()
+	ptr := l.parseScript(pinBytes(&pinner, cScript.b), ebuf.ptr(), errorBufferSize)
+	if ptr == 0 {
+		return nil, fmt.Errorf("failed to parse script: %s", ebuf)
+	}
+	data := l.takeString(ptr)
+
+	var root syntaxNode
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to decode syntax tree: %w", err)
+	}
+	return &root, nil
+}
+
+// takeString copies the null-terminated string at ptr, which the library
+// returned for the caller to free, and frees it.
+func (l *library) takeString(ptr uintptr) []byte {
+	n := 0
+	for *(*byte)(unsafe.Pointer(ptr + uintptr(n))) != 0 {
+		n++
+	}
+	data := append([]byte(nil), unsafe.Slice((*byte)(unsafe.Pointer(ptr)), n)...)
+	l.stringFree(ptr)
+	return data
+}
+
+// text returns the part of src n covers.
+func (n *syntaxNode) text(src string) string {
+	return src[n.Start:n.End]
+}
+
+// tokens returns the leaves under n in the order they appear in the
+// script, treating nodes of the kinds in whole as leaves. Leaves covering
+// no text are left out.
+func (n *syntaxNode) tokens(whole map[string]bool) []*syntaxNode {
+	var out []*syntaxNode
+	var walk func(*syntaxNode)
+	walk = func(n *syntaxNode) {
+		if len(n.Children) == 0 || whole[n.Kind] {
+			if n.End > n.Start {
+				out = append(out, n)
+			}
+			return
+		}
+		for i := range n.Children {
+			walk(&n.Children[i])
+		}
+	}
+	walk(n)
+	// Here-document bodies sit in the tree with their redirection, ahead
+	// of the rest of the line that started them.
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Start < out[j].Start })
+	return out
+}
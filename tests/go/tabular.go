@@ -0,0 +1,91 @@
+package conch
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+)
+
+// TableFormat is the delimiter of tabular data exchanged with a script.
+type TableFormat rune
+
+const (
+	// CSV is comma-separated values with RFC 4180 quoting, as read and
+	// written by the sandbox's csv builtin.
+	CSV TableFormat = ','
+	// TSV is tab-separated values, matching csv -t in the sandbox.
+	TSV TableFormat = '\t'
+)
+
+// EncodeTable formats records as delimited text, one record per line.
+// Fields containing the delimiter, quotes or newlines are quoted.
+func EncodeTable(records [][]string, format TableFormat) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = rune(format)
+	if err := w.WriteAll(records); err != nil {
+		return nil, fmt.Errorf("encode table: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeTable parses delimited text into records. Records may have
+// differing numbers of fields, and stray quotes inside unquoted fields are
+// kept as is.
+func DecodeTable(data []byte, format TableFormat) ([][]string, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = rune(format)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("decode table: %w", err)
+	}
+	return records, nil
+}
+
+// Table parses the result's stdout as delimited records.
+func (r *Result) Table(format TableFormat) ([][]string, error) {
+	return DecodeTable(r.Stdout, format)
+}
+
+// TableRows parses the result's stdout as delimited records with a header
+// row, returning one map per record keyed by column name. Missing trailing
+// fields map to "".
+func (r *Result) TableRows(format TableFormat) ([]map[string]string, error) {
+	records, err := r.Table(format)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	header := records[0]
+	rows := make([]map[string]string, 0, len(records)-1)
+	for i, record := range records[1:] {
+		if len(record) > len(header) {
+			return nil, fmt.Errorf("decode table: record %d has %d fields, header has %d", i+1, len(record), len(header))
+		}
+		row := make(map[string]string, len(header))
+		for j, name := range header {
+			if j < len(record) {
+				row[name] = record[j]
+			} else {
+				row[name] = ""
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// ExecuteTable runs script with records, encoded in format, as its stdin.
+// Parse tabular output with Result.Table or Result.TableRows:
+//
+//	res, err := exec.ExecuteTable(ctx, `csv -w 'status=failed' -c id`, records, conch.CSV)
+//	ids, err := res.Table(conch.CSV)
+func (e *Executor) ExecuteTable(ctx context.Context, script string, records [][]string, format TableFormat) (*Result, error) {
+	stdin, err := EncodeTable(records, format)
+	if err != nil {
+		return nil, err
+	}
+	return e.ExecuteContextWithStdin(ctx, script, stdin, e.Limits())
+}
@@ -0,0 +1,95 @@
+package conch
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecodeTable(t *testing.T) {
+	records := [][]string{
+		{"name", "note"},
+		{"ann", "a, b"},
+		{"bob", "say \"hi\"\nbye"},
+	}
+	for _, format := range []TableFormat{CSV, TSV} {
+		data, err := EncodeTable(records, format)
+		if err != nil {
+			t.Fatalf("EncodeTable(%q) error: %v", rune(format), err)
+		}
+		got, err := DecodeTable(data, format)
+		if err != nil {
+			t.Fatalf("DecodeTable(%q) error: %v", rune(format), err)
+		}
+		if !reflect.DeepEqual(got, records) {
+			t.Errorf("round trip (%q) = %q, want %q", rune(format), got, records)
+		}
+	}
+
+	data, _ := EncodeTable([][]string{{"a", "b"}, {"1", "2"}}, TSV)
+	if string(data) != "a\tb\n1\t2\n" {
+		t.Errorf("EncodeTable(TSV) = %q", data)
+	}
+}
+
+func TestDecodeTableLenient(t *testing.T) {
+	got, err := DecodeTable([]byte("a,b,c\n1,2\n3,4\"x,5\n"), CSV)
+	if err != nil {
+		t.Fatalf("DecodeTable() error: %v", err)
+	}
+	want := [][]string{{"a", "b", "c"}, {"1", "2"}, {"3", "4\"x", "5"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeTable() = %q, want %q", got, want)
+	}
+}
+
+func TestResultTableRows(t *testing.T) {
+	res := &Result{Stdout: []byte("id,status\n1,ok\n2\n")}
+	rows, err := res.TableRows(CSV)
+	if err != nil {
+		t.Fatalf("TableRows() error: %v", err)
+	}
+	want := []map[string]string{{"id": "1", "status": "ok"}, {"id": "2", "status": ""}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("TableRows() = %v, want %v", rows, want)
+	}
+
+	if rows, err := (&Result{}).TableRows(CSV); err != nil || rows != nil {
+		t.Errorf("TableRows() on empty stdout = %v, %v", rows, err)
+	}
+	if _, err := (&Result{Stdout: []byte("a\n1,2\n")}).TableRows(CSV); err == nil {
+		t.Error("TableRows() accepted a record wider than the header")
+	}
+}
+
+func TestExecuteTable(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	records := [][]string{
+		{"id", "status", "note"},
+		{"1", "ok", ""},
+		{"2", "failed", "disk, full"},
+		{"3", "failed", ""},
+	}
+	res, err := exec.ExecuteTable(context.Background(), `csv -w status=failed -c id,note`, records, CSV)
+	if err != nil {
+		t.Fatalf("ExecuteTable() error: %v", err)
+	}
+	if res.ExitCode != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", res.ExitCode, res.Stderr)
+	}
+	got, err := res.Table(CSV)
+	if err != nil {
+		t.Fatalf("Table() error: %v", err)
+	}
+	want := [][]string{{"id", "note"}, {"2", "disk, full"}, {"3", ""}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Table() = %q, want %q", got, want)
+	}
+}
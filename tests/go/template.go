@@ -0,0 +1,461 @@
+package conch
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Script renders tmpl, a text/template, with data into a shell script. It
+// is the way to put values into generated scripts: every action that writes
+// into the script must end in one of the quoting functions, so a value can
+// never be read as shell syntax.
+//
+//	script, err := conch.Script(`grep -c {{q .Pattern}} {{qs .Files}}`, args)
+//
+// The quoting functions are:
+//
+//	q    quotes a string, []byte, fmt.Stringer, number or bool as one word.
+//	qs   quotes each element of a slice as a word, separated by spaces.
+//
+// Their quotes only protect a value where the shell would read a word, so
+// Script follows the quoting of the template's text and rejects actions
+// inside single or double quotes, backquotes, comments and here-documents,
+// or just after a backslash. Write "{{q .X}}" as {{q .X}}: the value is
+// already one word. The branches of if, range and with must end in the
+// quoting they start in, and template may only be called where a word
+// could start.
+//
+// Actions that write nothing, such as if, range and variable assignments,
+// are unrestricted, as is the template's own text. Script returns an error
+// for an unquoted action, an action out of place, a value that cannot be
+// quoted, one containing a NUL byte, or a missing map key.
+func Script(tmpl string, data any) (string, error) {
+	t, err := template.New("script").
+		Funcs(template.FuncMap{"q": quoteWord, "qs": quoteWords}).
+		Option("missingkey=error").
+		Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	for _, sub := range t.Templates() {
+		if sub.Tree == nil {
+			continue
+		}
+		c := quoteChecker{tree: sub.Tree, ctx: newShellContext()}
+		if err := c.walk(sub.Tree.Root); err != nil {
+			return "", err
+		}
+		if sub.Name() != t.Name() && !c.ctx.sameQuoting(newShellContext()) {
+			return "", fmt.Errorf("template: %s: quoting differs between the start and end of the template", sub.Name())
+		}
+	}
+
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// quoteChecker walks a template's nodes in order, following the shell
+// quoting its text leaves each action in.
+type quoteChecker struct {
+	tree *parse.Tree
+	ctx  shellContext
+}
+
+// walk returns an error for the first action under node that writes to the
+// script without quoting, or where quoting does not protect it.
+func (c *quoteChecker) walk(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := c.walk(child); err != nil {
+				return err
+			}
+		}
+	case *parse.TextNode:
+		c.ctx.scan(string(n.Text))
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) > 0 {
+			return nil
+		}
+		loc, _ := c.tree.ErrorContext(n)
+		if !quotedPipe(n.Pipe) {
+			return fmt.Errorf("template: %s: %s is not quoted; end it with | q or | qs", loc, n)
+		}
+		if where := c.ctx.unsafe(); where != "" {
+			return fmt.Errorf("template: %s: %s is inside %s, where its quotes do not make it one word", loc, n, where)
+		}
+	case *parse.TemplateNode:
+		if where := c.ctx.unsafe(); where != "" {
+			loc, _ := c.tree.ErrorContext(n)
+			return fmt.Errorf("template: %s: %s is inside %s", loc, n, where)
+		}
+	case *parse.IfNode:
+		return c.branches(n, &n.BranchNode)
+	case *parse.RangeNode:
+		return c.branches(n, &n.BranchNode)
+	case *parse.WithNode:
+		return c.branches(n, &n.BranchNode)
+	}
+	return nil
+}
+
+// branches checks the lists of n, each from the quoting it starts in,
+// which both must end in.
+func (c *quoteChecker) branches(node parse.Node, n *parse.BranchNode) error {
+	start := c.ctx.clone()
+	for _, list := range []*parse.ListNode{n.List, n.ElseList} {
+		c.ctx = start.clone()
+		if err := c.walk(list); err != nil {
+			return err
+		}
+		if !c.ctx.sameQuoting(start) {
+			loc, _ := c.tree.ErrorContext(node)
+			return fmt.Errorf("template: %s: quoting differs between the start and end of a branch", loc)
+		}
+	}
+	// Whether a # after the branch starts a comment depends on which branch
+	// ran; assume it does, which only rejects more.
+	c.ctx = start
+	c.ctx.prev = '\n'
+	return nil
+}
+
+// quotedPipe reports whether pipe's last command is a quoting function.
+func quotedPipe(pipe *parse.PipeNode) bool {
+	if len(pipe.Cmds) == 0 {
+		return false
+	}
+	last := pipe.Cmds[len(pipe.Cmds)-1]
+	id, ok := last.Args[0].(*parse.IdentifierNode)
+	return ok && (id.Ident == "q" || id.Ident == "qs")
+}
+
+// quoteWord implements q.
+func quoteWord(v any) (string, error) {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case fmt.Stringer:
+		s = v.String()
+	default:
+		switch reflect.ValueOf(v).Kind() {
+		case reflect.Bool,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			s = fmt.Sprint(v)
+		default:
+			return "", fmt.Errorf("q: cannot quote %T as a word", v)
+		}
+	}
+	if strings.IndexByte(s, 0) >= 0 {
+		return "", errors.New("q: value contains a NUL byte")
+	}
+	return shellQuote(s), nil
+}
+
+// quoteWords implements qs.
+func quoteWords(v any) (string, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return "", fmt.Errorf("qs: cannot quote %T as words; use q", v)
+	}
+	words := make([]string, rv.Len())
+	for i := range words {
+		w, err := quoteWord(rv.Index(i).Interface())
+		if err != nil {
+			return "", err
+		}
+		words[i] = w
+	}
+	return strings.Join(words, " "), nil
+}
+
+// quoteState is a kind of shellContext frame.
+type quoteState int
+
+const (
+	// inSubstitution is inside $( ), which is code like the top level.
+	inSubstitution quoteState = iota
+	inSingleQuotes
+	inDoubleQuotes
+	inBackquotes
+	inComment
+	// inDelimiter is reading a here-document's delimiter.
+	inDelimiter
+	inHeredoc
+)
+
+// quoteStateNames describe the frames an action may not be inside.
+var quoteStateNames = [...]string{
+	inSingleQuotes: "single quotes",
+	inDoubleQuotes: "double quotes",
+	inBackquotes:   "backquotes",
+	inComment:      "a comment",
+	inDelimiter:    "a here-document delimiter",
+	inHeredoc:      "a here-document",
+}
+
+// quoteFrame is a context opened in a script and not yet closed.
+type quoteFrame struct {
+	state quoteState
+	// parens counts the ( opened in a substitution, so its ) is found.
+	parens int
+}
+
+// templateHeredoc is a here-document whose body is still to come.
+type templateHeredoc struct {
+	delim     string
+	stripTabs bool
+}
+
+// shellContext follows the quoting of a script as its text is scanned, far
+// enough to tell where a single-quoted value is read as one word. It does
+// not tell the ) of a case pattern from the end of a substitution.
+type shellContext struct {
+	// stack holds the contexts open, innermost last; empty is the top level.
+	stack []quoteFrame
+	// prev is the last byte scanned, and escaped is set after a backslash
+	// whose escaped byte is still to come.
+	prev    byte
+	escaped bool
+	// lts counts the < just read at the top level, and is -1 after <<-.
+	lts       int
+	stripTabs bool
+	// delim is the here-document delimiter read so far, and heredocs the
+	// here-documents whose bodies start after the next newline.
+	delim    string
+	heredocs []templateHeredoc
+	// line is the here-document line read so far.
+	line string
+}
+
+func newShellContext() shellContext {
+	return shellContext{prev: '\n'}
+}
+
+func (s *shellContext) clone() shellContext {
+	c := *s
+	c.stack = append([]quoteFrame(nil), s.stack...)
+	c.heredocs = append([]templateHeredoc(nil), s.heredocs...)
+	return c
+}
+
+// sameQuoting reports whether s and o are in the same quoting, whatever
+// byte each last scanned.
+func (s *shellContext) sameQuoting(o shellContext) bool {
+	a, b := s.clone(), o.clone()
+	a.prev, b.prev = 0, 0
+	return reflect.DeepEqual(a, b)
+}
+
+// unsafe describes where the scanned text left off if a quoted value
+// written there would not be read as one word, or returns "".
+func (s *shellContext) unsafe() string {
+	switch {
+	case s.escaped:
+		return "a backslash escape"
+	case s.lts == 2 || s.lts < 0:
+		return quoteStateNames[inDelimiter]
+	}
+	for i := len(s.stack) - 1; i >= 0; i-- {
+		if state := s.stack[i].state; state != inSubstitution {
+			return quoteStateNames[state]
+		}
+	}
+	return ""
+}
+
+func (s *shellContext) top() quoteState {
+	if len(s.stack) == 0 {
+		return inSubstitution
+	}
+	return s.stack[len(s.stack)-1].state
+}
+
+func (s *shellContext) push(state quoteState) {
+	s.stack = append(s.stack, quoteFrame{state: state})
+}
+
+func (s *shellContext) pop() {
+	s.stack = s.stack[:len(s.stack)-1]
+}
+
+// scan follows text, the next part of the script.
+func (s *shellContext) scan(text string) {
+	for i := 0; i < len(text); i++ {
+		s.step(text[i])
+	}
+}
+
+func (s *shellContext) step(c byte) {
+	switch s.top() {
+	case inSingleQuotes:
+		if c == '\'' {
+			s.pop()
+		}
+	case inComment:
+		if c == '\n' {
+			s.pop()
+			s.newline()
+		}
+	case inHeredoc:
+		s.heredocLine(c)
+	case inDelimiter:
+		if s.delimiter(c) {
+			return
+		}
+	case inBackquotes:
+		switch {
+		case s.escaped:
+			s.escaped = false
+			c = 0
+		case c == '\\':
+			s.escaped = true
+		case c == '`':
+			s.pop()
+		}
+	case inDoubleQuotes:
+		switch {
+		case s.escaped:
+			s.escaped = false
+			c = 0
+		case c == '\\':
+			s.escaped = true
+		case c == '"':
+			s.pop()
+		case c == '`':
+			s.push(inBackquotes)
+		case c == '(' && s.prev == '$':
+			s.push(inSubstitution)
+		}
+	default:
+		c = s.code(c)
+	}
+	s.prev = c
+}
+
+// code follows c at the top level or in a substitution, returning what to
+// remember as the previous byte.
+func (s *shellContext) code(c byte) byte {
+	if s.escaped {
+		s.escaped = false
+		// An escaped byte is part of a word, whatever it is.
+		return '_'
+	}
+	if s.lts != 0 && c != '<' {
+		if s.lts == 2 && c == '-' {
+			s.lts, s.stripTabs = -1, true
+			return c
+		}
+		if s.lts == 2 || s.lts == -1 {
+			s.lts = 0
+			s.push(inDelimiter)
+			s.delimiter(c)
+			return c
+		}
+		s.lts = 0
+	}
+	switch c {
+	case '\\':
+		s.escaped = true
+	case '\'':
+		s.push(inSingleQuotes)
+	case '"':
+		s.push(inDoubleQuotes)
+	case '`':
+		s.push(inBackquotes)
+	case '(':
+		if s.prev == '$' {
+			s.push(inSubstitution)
+		} else if len(s.stack) > 0 {
+			s.stack[len(s.stack)-1].parens++
+		}
+	case ')':
+		if len(s.stack) > 0 {
+			if f := &s.stack[len(s.stack)-1]; f.parens > 0 {
+				f.parens--
+			} else {
+				s.pop()
+			}
+		}
+	case '#':
+		if isMeta(s.prev) {
+			s.push(inComment)
+		}
+	case '<':
+		s.lts++
+	case '\n':
+		s.newline()
+	}
+	return c
+}
+
+// delimiter reads c as part of a here-document's delimiter, reporting
+// whether it was. A byte ending the delimiter is left for the code around
+// it.
+func (s *shellContext) delimiter(c byte) bool {
+	switch {
+	case s.delim == "" && (c == ' ' || c == '\t'):
+	case isMeta(c):
+		s.pop()
+		s.heredocs = append(s.heredocs, templateHeredoc{delim: s.delim, stripTabs: s.stripTabs})
+		s.delim, s.stripTabs = "", false
+		s.step(c)
+		return true
+	case c == '\'' || c == '"' || c == '\\':
+	default:
+		s.delim += string(c)
+	}
+	return false
+}
+
+// newline ends a line of code, starting the body of the first pending
+// here-document.
+func (s *shellContext) newline() {
+	if len(s.heredocs) > 0 {
+		s.push(inHeredoc)
+	}
+}
+
+// heredocLine reads c as part of a here-document's body, ending it at its
+// delimiter.
+func (s *shellContext) heredocLine(c byte) {
+	if c != '\n' {
+		s.line += string(c)
+		return
+	}
+	h := s.heredocs[0]
+	line := s.line
+	if h.stripTabs {
+		line = strings.TrimLeft(line, "\t")
+	}
+	s.line = ""
+	if line == h.delim {
+		s.pop()
+		s.heredocs = s.heredocs[1:]
+		s.newline()
+	}
+}
+
+// isMeta reports whether c ends a word.
+func isMeta(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '|', '&', ';', '(', ')', '<', '>':
+		return true
+	}
+	return false
+}
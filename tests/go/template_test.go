@@ -0,0 +1,111 @@
+package conch
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScript(t *testing.T) {
+	data := map[string]any{
+		"Pattern": "it's $(rm -rf /)",
+		"Files":   []string{"a b", "c;d", ""},
+		"N":       3,
+		"Raw":     []byte("x`y`"),
+		"Wait":    2 * time.Second,
+		"Debug":   true,
+	}
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{`grep -c {{q .Pattern}} {{qs .Files}}`, `grep -c 'it'\''s $(rm -rf /)' 'a b' 'c;d' ''`},
+		{`head -n {{.N | q}} {{q .Raw}}`, `head -n '3' 'x` + "`y`'"},
+		{`sleep {{q .Wait}}`, `sleep '2s'`},
+		{`{{if .Debug}}set -x{{end}}`, `set -x`},
+		{`{{range .Files}}echo {{q .}}
+{{end}}`, "echo 'a b'\necho 'c;d'\necho ''\n"},
+		{`{{$f := index .Files 0}}cat {{q $f}}`, `cat 'a b'`},
+		{`{{define "arg"}}{{q .}}{{end}}ls {{template "arg" .Pattern}}`, `ls 'it'\''s $(rm -rf /)'`},
+		{`echo {{qs .Empty}}`, `echo `},
+		{`echo "$HOME" {{q .N}} 'a' # b`, `echo "$HOME" '3' 'a' # b`},
+		{`echo $(cat {{q .N}}) \" {{q .N}}`, `echo $(cat '3') \" '3'`},
+		{"cat <<EOF\n$x \"\nEOF\necho {{q .N}}", "cat <<EOF\n$x \"\nEOF\necho '3'"},
+		{"cat <<<{{q .N}}", "cat <<<'3'"},
+		{`{{if .Debug}}echo "a"{{else}}echo 'b'{{end}} {{q .N}}`, `echo "a" '3'`},
+	}
+	data["Empty"] = []string{}
+	for _, tt := range tests {
+		got, err := Script(tt.tmpl, data)
+		if err != nil {
+			t.Errorf("Script(%q) error: %v", tt.tmpl, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Script(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	data := map[string]any{"S": "x", "L": []string{"a"}, "M": map[string]string{}, "NUL": "a\x00b"}
+	tests := []struct {
+		tmpl    string
+		wantErr string
+	}{
+		{`echo {{.S}}`, "is not quoted"},
+		{`echo {{q .S | printf "%s"}}`, "is not quoted"},
+		{`{{if .S}}echo {{.S}}{{end}}`, "is not quoted"},
+		{`{{range .L}}{{else}}{{.}}{{end}}`, "is not quoted"},
+		{`{{define "t"}}{{.}}{{end}}echo`, "is not quoted"},
+		{`echo {{q .L}}`, "cannot quote []string"},
+		{`echo {{qs .S}}`, "cannot quote string as words"},
+		{`echo {{q .M}}`, "cannot quote map"},
+		{`echo {{q .NUL}}`, "NUL byte"},
+		{`echo {{q .Missing}}`, "Missing"},
+		{`echo {{q .S`, "unclosed action"},
+		{`echo "{{q .S}}"`, "inside double quotes"},
+		{`echo "$(echo {{q .S}})"`, "inside double quotes"},
+		{"echo `echo {{q .S}}`", "inside backquotes"},
+		{`echo '{{q .S}}'`, "inside single quotes"},
+		{`echo a # {{q .S}}`, "inside a comment"},
+		{`echo \{{q .S}}`, "inside a backslash escape"},
+		{"cat <<EOF\n{{q .S}}\nEOF", "inside a here-document"},
+		{"cat <<'EOF'\n{{q .S}}\nEOF", "inside a here-document"},
+		{"cat <<-EOF\n\tEOFX\n{{q .S}}\n\tEOF", "inside a here-document"},
+		{"cat << {{q .S}}", "inside a here-document delimiter"},
+		{`{{if .S}}echo "{{end}}{{q .S}}`, "quoting differs"},
+		{`{{define "t"}}"{{end}}{{template "t"}}{{q .S}}`, "quoting differs"},
+		{`echo "{{template "t"}}"{{define "t"}}{{end}}`, "inside double quotes"},
+	}
+	for _, tt := range tests {
+		_, err := Script(tt.tmpl, data)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Script(%q) error = %v, want it to contain %q", tt.tmpl, err, tt.wantErr)
+		}
+	}
+}
+
+func TestScriptRunsQuoted(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	args := []string{"it's", "$(echo pwned)", "`id`", "a\nb", "*", "; exit 1"}
+	script, err := Script(`printf '[%s]\n' {{qs .}}`, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := exec.Execute(script)
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	want := "[it's]\n[$(echo pwned)]\n[`id`]\n[a\nb]\n[*]\n[; exit 1]\n"
+	if string(result.Stdout) != want {
+		t.Errorf("stdout = %q, want %q", result.Stdout, want)
+	}
+}
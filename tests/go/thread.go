@@ -0,0 +1,51 @@
+package conch
+
+import "runtime"
+
+// osThread runs functions on one goroutine locked to its OS thread, so
+// thread-local state in the native library, such as the last error and the
+// signal mask, stays with the executor instead of following whichever
+// thread the Go scheduler picked for the call.
+type osThread struct {
+	calls chan func()
+}
+
+// newOSThread starts a locked thread. It runs until stop.
+func newOSThread() *osThread {
+	t := &osThread{calls: make(chan func())}
+	go func() {
+		// Never unlocked: when the goroutine returns the thread exits
+		// with it, taking any native thread-local state along.
+		runtime.LockOSThread()
+		for fn := range t.calls {
+			fn()
+		}
+	}()
+	return t
+}
+
+// do runs fn on the thread and waits for it to return.
+func (t *osThread) do(fn func()) {
+	done := make(chan struct{})
+	t.calls <- func() {
+		defer close(done)
+		fn()
+	}
+	<-done
+}
+
+// stop ends the thread once the call in progress, if any, returns.
+func (t *osThread) stop() {
+	close(t.calls)
+}
+
+// onThread runs fn on the executor's dedicated thread, or directly without
+// one. It is used for native calls whose thread-local state, like the error
+// read after a failed execution, must be read on the thread that set it.
+func (e *Executor) onThread(fn func()) {
+	if e.thread == nil {
+		fn()
+		return
+	}
+	e.thread.do(fn)
+}
@@ -0,0 +1,78 @@
+package conch
+
+import "testing"
+
+func TestOSThread(t *testing.T) {
+	th := newOSThread()
+	defer th.stop()
+
+	var ran int
+	for i := 0; i < 3; i++ {
+		th.do(func() { ran++ })
+	}
+	if ran != 3 {
+		t.Errorf("ran %d calls, want 3", ran)
+	}
+
+	var direct bool
+	(&Executor{}).onThread(func() { direct = true })
+	if !direct {
+		t.Error("onThread without a thread did not run fn")
+	}
+}
+
+func TestDedicatedThread(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded(), WithDedicatedThread(), WithInitScript("greet() { echo hi; }"))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	result, err := exec.Execute("greet")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if string(result.Stdout) != "hi\n" {
+		t.Errorf("Stdout = %q", result.Stdout)
+	}
+	exec.Close()
+	if exec.thread != nil {
+		t.Error("Close() did not stop the dedicated thread")
+	}
+}
+
+// BenchmarkOSThreadHandoff measures what DedicatedThread adds to each
+// execution: handing the native call to the locked thread and back.
+func BenchmarkOSThreadHandoff(b *testing.B) {
+	th := newOSThread()
+	defer th.stop()
+	for i := 0; i < b.N; i++ {
+		th.do(func() {})
+	}
+}
+
+func BenchmarkDedicatedThread(b *testing.B) {
+	if !IsAvailable() || !HasEmbeddedShell() {
+		b.Skip("Skipping: embedded shell not available")
+	}
+	for _, bm := range []struct {
+		name string
+		opts []Option
+	}{
+		{"shared", []Option{WithEmbedded()}},
+		{"dedicated", []Option{WithEmbedded(), WithDedicatedThread()}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			exec, err := New(bm.opts...)
+			if err != nil {
+				b.Fatalf("New() error: %v", err)
+			}
+			defer exec.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = exec.Execute("echo hello")
+			}
+		})
+	}
+}
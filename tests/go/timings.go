@@ -0,0 +1,40 @@
+package conch
+
+import "time"
+
+// Timings breaks down where the wall time of an execution went, to show
+// where per-call latency goes and whether caching helps.
+type Timings struct {
+	// Prepare is spent checking the script and handing it to the library:
+	// the allowlist, the policy and copying it. The shell parses each
+	// command as it reaches it, which counts towards Execute.
+	Prepare time.Duration
+	// Compile is spent compiling the components of commands the script
+	// spawned. The shell itself is compiled once, when the executor is
+	// created.
+	Compile time.Duration
+	// Instantiate is spent creating the shell instance and its filesystem.
+	Instantiate time.Duration
+	// Execute is spent running the script, including the init script,
+	// registered functions and trap handlers, less Compile.
+	Execute time.Duration
+	// Marshal is spent copying the output out of the library and into the
+	// Result.
+	Marshal time.Duration
+}
+
+// Total returns the sum of the phases.
+func (t Timings) Total() time.Duration {
+	return t.Prepare + t.Compile + t.Instantiate + t.Execute + t.Marshal
+}
+
+// nativeTimings returns the phases cResult reports. Prepare is timed by the
+// bindings, and Marshal only covers the library's side of it.
+func nativeTimings(cResult *ConchResult) Timings {
+	return Timings{
+		Compile:     time.Duration(cResult.CompileNs),
+		Instantiate: time.Duration(cResult.InstantiateNs),
+		Execute:     time.Duration(cResult.ExecuteNs),
+		Marshal:     time.Duration(cResult.MarshalNs),
+	}
+}
@@ -0,0 +1,49 @@
+package conch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimingsTotal(t *testing.T) {
+	tm := Timings{Prepare: 1, Compile: 2, Instantiate: 3, Execute: 4, Marshal: 5}
+	if got := tm.Total(); got != 15 {
+		t.Errorf("Total() = %v, want 15ns", got)
+	}
+}
+
+func TestFillResultTimings(t *testing.T) {
+	c := fakeConchResult(t, []byte("out"), nil)
+	c.CompileNs = 1000
+	c.InstantiateNs = 2000
+	c.ExecuteNs = 3000
+	c.MarshalNs = 4000
+
+	result := &Result{Timings: Timings{Prepare: time.Hour}}
+	fillResult(c, result, false)
+	want := Timings{Compile: time.Microsecond, Instantiate: 2 * time.Microsecond, Execute: 3 * time.Microsecond, Marshal: 4 * time.Microsecond}
+	if result.Timings != want {
+		t.Errorf("fillResult() Timings = %+v, want %+v", result.Timings, want)
+	}
+}
+
+func TestExecuteTimings(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.Execute("echo hello")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	tm := result.Timings
+	if tm.Instantiate <= 0 || tm.Execute <= 0 || tm.Marshal <= 0 {
+		t.Errorf("Timings = %+v, want instantiate, execute and marshal timed", tm)
+	}
+	if tm.Compile != 0 {
+		t.Errorf("Compile = %v for a script spawning no commands", tm.Compile)
+	}
+}
@@ -0,0 +1,60 @@
+package conch
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// TmpConfig configures the /tmp of each execution. Every execution starts
+// with an empty /tmp that is discarded when it ends, so scripts can use temp
+// files without seeing those of earlier executions.
+type TmpConfig struct {
+	// MaxBytes caps the bytes the files in /tmp may hold at once; a write
+	// beyond it fails with "no space left on device". Zero means no cap.
+	// Files added with AddFile do not count towards it.
+	MaxBytes uint64
+	// RetainDir, if set, is a host directory receiving a copy of what each
+	// execution leaves in /tmp, for debugging. Each execution gets its own
+	// subdirectory, named after its ID with characters other than letters,
+	// digits, '.', '_' and '-' replaced by '_'.
+	RetainDir string
+}
+
+// SetTmp configures the /tmp of the executor's executions, replacing any
+// earlier TmpConfig.
+func (e *Executor) SetTmp(cfg TmpConfig) error {
+	if strings.IndexByte(cfg.RetainDir, 0) >= 0 {
+		return errors.New("retain dir contains a NUL byte")
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.handle == 0 {
+		return errors.New("executor is closed")
+	}
+	if err := e.lib.tmp.load(); err != nil {
+		return err
+	}
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	var cDir uintptr
+	if cfg.RetainDir != "" {
+		s, err := cString(cfg.RetainDir)
+		if err != nil {
+			return err
+		}
+		defer freeString(s)
+		cDir = pinBytes(&pinner, s.b)
+	}
+
+	ebuf := newErrorBuffer()
+	if e.lib.executorSetTmp(e.handle, cfg.MaxBytes, cDir, ebuf.ptr(), errorBufferSize) != 0 {
+		return fmt.Errorf("failed to configure /tmp: %s", ebuf)
+	}
+	return nil
+}
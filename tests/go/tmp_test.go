@@ -0,0 +1,99 @@
+package conch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTmpIsolated(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	if _, err := exec.Execute("echo left > /tmp/state"); err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	result, err := exec.Execute("test -e /tmp/state && echo found || echo clean")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if string(result.Stdout) != "clean\n" {
+		t.Errorf("second execution saw the first's /tmp: stdout = %q", result.Stdout)
+	}
+}
+
+func TestTmpMaxBytes(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded(), WithTmp(TmpConfig{MaxBytes: 16}))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.Execute("printf 0123456789 > /tmp/a && echo ok")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if string(result.Stdout) != "ok\n" {
+		t.Errorf("write within the cap: stdout = %q, stderr = %q", result.Stdout, result.Stderr)
+	}
+
+	result, err = exec.Execute("printf 0123456789 > /tmp/a && printf 0123456789 > /tmp/b && echo ok")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.ExitCode == 0 || strings.Contains(string(result.Stdout), "ok") {
+		t.Errorf("write beyond the cap succeeded: exit %d, stdout = %q", result.ExitCode, result.Stdout)
+	}
+}
+
+func TestTmpRetainDir(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	dir := t.TempDir()
+	if err := exec.SetTmp(TmpConfig{RetainDir: dir}); err != nil {
+		t.Fatalf("SetTmp() error: %v", err)
+	}
+	script := "mkdir -p /tmp/out && echo debug > /tmp/out/log"
+	if _, err := exec.ExecuteWithOptions(context.Background(), script, ExecOptions{ID: "job/7"}); err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "job_7", "out", "log"))
+	if err != nil {
+		t.Fatalf("retained file: %v", err)
+	}
+	if string(data) != "debug\n" {
+		t.Errorf("retained file = %q, want %q", data, "debug\n")
+	}
+}
+
+func TestSetTmpErrors(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	if err := exec.SetTmp(TmpConfig{RetainDir: "a\x00b"}); err == nil {
+		t.Error("SetTmp(NUL in RetainDir) succeeded")
+	}
+	exec.Close()
+	if err := exec.SetTmp(TmpConfig{MaxBytes: 1}); err == nil {
+		t.Error("SetTmp() on a closed executor succeeded")
+	}
+}
@@ -0,0 +1,75 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Trap bits in ConchResult.Traps, matching CONCH_TRAP_* in ffi.rs.
+const (
+	trapExit = 1 << iota
+	trapInt
+	trapTerm
+)
+
+// trapOrder lists trap names in the order their handlers run.
+var trapOrder = []struct {
+	bit  uint8
+	name string
+}{
+	{trapInt, "INT"},
+	{trapTerm, "TERM"},
+	{trapExit, "EXIT"},
+}
+
+// TrapError is returned when an execution was stopped by cancellation or a
+// timeout after the script had set traps. Err is the error the execution
+// would otherwise have returned; Result holds the output of the handlers that
+// ran before teardown.
+//
+// Cancelling the context runs the INT trap; a timeout or passed deadline runs
+// the TERM trap. Either is followed by the EXIT trap. The script takes the
+// signal the next time it waits, such as on a pipeline, and the handlers run
+// in its shell. A script that does not wait within a short grace period,
+// such as a busy loop, is stopped without running them.
+type TrapError struct {
+	Err error
+	// Result.Traps lists the handlers that ran and Result.ExitCode is the
+	// status the script was given, 130 for INT or 143 for TERM.
+	Result *Result
+}
+
+func (e *TrapError) Error() string { return e.Err.Error() }
+
+func (e *TrapError) Unwrap() error { return e.Err }
+
+// trapNames decodes a ConchResult trap mask.
+func trapNames(mask uint8) []string {
+	var names []string
+	for _, t := range trapOrder {
+		if mask&t.bit != 0 {
+			names = append(names, t.name)
+		}
+	}
+	return names
+}
+
+// stoppedError builds the error for an execution that returned no result,
+// attaching the output of any trap handlers it ran while being stopped.
+// failed is the error of the native failure and id the execution's ID.
+func stoppedError(ctx context.Context, l *library, interrupt uintptr, id string, failed error) error {
+	err := failed
+	if ctx.Err() != nil {
+		err = fmt.Errorf("execution interrupted: %w", context.Cause(ctx))
+	}
+	if resultPtr := l.interruptTakeResult(interrupt); resultPtr != 0 {
+		result, terr := takeResult(l, resultPtr, false)
+		if terr != nil {
+			return errors.Join(err, terr)
+		}
+		result.ID = id
+		return &TrapError{Err: err, Result: result}
+	}
+	return err
+}
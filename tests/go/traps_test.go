@@ -0,0 +1,168 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTrapNames(t *testing.T) {
+	tests := []struct {
+		mask uint8
+		want []string
+	}{
+		{0, nil},
+		{trapExit, []string{"EXIT"}},
+		{trapExit | trapInt, []string{"INT", "EXIT"}},
+		{trapTerm | trapExit, []string{"TERM", "EXIT"}},
+	}
+	for _, tt := range tests {
+		if got := trapNames(tt.mask); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("trapNames(%d) = %q, want %q", tt.mask, got, tt.want)
+		}
+	}
+}
+
+func TestExitTrap(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.Execute(`trap 'echo "cleanup $?"' EXIT; echo work; exit 3`)
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if got := string(result.Stdout); got != "work\ncleanup 3\n" {
+		t.Errorf("stdout = %q, want %q (stderr %q)", got, "work\ncleanup 3\n", result.Stderr)
+	}
+	if result.ExitCode != 3 || !reflect.DeepEqual(result.Traps, []string{"EXIT"}) {
+		t.Errorf("ExitCode = %d, Traps = %q, want 3, [EXIT]", result.ExitCode, result.Traps)
+	}
+
+	result, err = exec.Execute("echo plain")
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.Traps != nil {
+		t.Errorf("Traps = %q without a trap set", result.Traps)
+	}
+}
+
+// stopWithTraps runs a looping script with INT, TERM and EXIT traps set under
+// ctx and returns the resulting TrapError. The loop waits on a pipeline, so
+// the script takes the signal and its handlers see its variables.
+func stopWithTraps(t *testing.T, ctx context.Context) *TrapError {
+	t.Helper()
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	_, err = exec.ExecuteContext(ctx, `
+state=partial
+trap 'echo "int $?"' INT
+trap 'echo "term $?"' TERM
+trap 'echo "$state"' EXIT
+while :; do echo tick | grep -q tick; done`)
+	var trapErr *TrapError
+	if !errors.As(err, &trapErr) {
+		t.Fatalf("ExecuteContext() error = %v, want *TrapError", err)
+	}
+	return trapErr
+}
+
+func TestTrapOnCancel(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	trapErr := stopWithTraps(t, ctx)
+	if !errors.Is(trapErr, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", trapErr)
+	}
+	result := trapErr.Result
+	if got := string(result.Stdout); got != "int 130\npartial\n" {
+		t.Errorf("stdout = %q, want %q (stderr %q)", got, "int 130\npartial\n", result.Stderr)
+	}
+	if result.ExitCode != 130 || !reflect.DeepEqual(result.Traps, []string{"INT", "EXIT"}) {
+		t.Errorf("ExitCode = %d, Traps = %q, want 130, [INT EXIT]", result.ExitCode, result.Traps)
+	}
+}
+
+func TestTrapOnDeadline(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	trapErr := stopWithTraps(t, ctx)
+	if !errors.Is(trapErr, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want context.DeadlineExceeded", trapErr)
+	}
+	result := trapErr.Result
+	if got := string(result.Stdout); got != "term 143\npartial\n" {
+		t.Errorf("stdout = %q, want %q (stderr %q)", got, "term 143\npartial\n", result.Stderr)
+	}
+	if result.ExitCode != 143 || !reflect.DeepEqual(result.Traps, []string{"TERM", "EXIT"}) {
+		t.Errorf("ExitCode = %d, Traps = %q, want 143, [TERM EXIT]", result.ExitCode, result.Traps)
+	}
+}
+
+func TestInterruptWithoutTraps(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = exec.ExecuteContext(ctx, "while :; do :; done")
+	var trapErr *TrapError
+	if errors.As(err, &trapErr) {
+		t.Errorf("ExecuteContext() error = %v, want a plain error without traps", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ExecuteContext() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestTrapsSkippedWithoutWaiting(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// A loop that never waits cannot take the signal, so it is stopped
+	// without running its handlers.
+	start := time.Now()
+	_, err = exec.ExecuteContext(ctx, "trap 'echo bye' TERM EXIT; while :; do :; done")
+	var trapErr *TrapError
+	if errors.As(err, &trapErr) {
+		t.Errorf("ExecuteContext() error = %v, want a plain error without traps", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ExecuteContext() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("ExecuteContext() took %v to stop", elapsed)
+	}
+}
@@ -0,0 +1,178 @@
+package conch
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Default terminal size used for a TTY with zero Rows or Cols.
+const (
+	DefaultTTYRows = 24
+	DefaultTTYCols = 80
+)
+
+// TTY emulates a terminal for an execution. With one attached, the script's
+// stdout and stderr report themselves as a terminal, so programs that check
+// isatty (colour output, progress bars, test -t 1) behave as they do
+// interactively. TERM, COLUMNS and LINES are set in the shell's environment.
+// Output is still captured into the Result; escape sequences are passed
+// through untouched.
+//
+// Use a *TTY rather than copying one; Resize may be called while executions
+// using it are running.
+type TTY struct {
+	// Rows and Cols are the initial size. Zero values default to
+	// DefaultTTYRows and DefaultTTYCols. Use Size to read them once the TTY
+	// is in use.
+	Rows, Cols uint16
+
+	mu       sync.Mutex
+	attached []attachedTerminal
+}
+
+// attachedTerminal is a native terminal created by lib for one execution.
+type attachedTerminal struct {
+	lib *library
+	h   uintptr
+}
+
+// Size returns the terminal's current size.
+func (t *TTY) Size() (rows, cols uint16) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size()
+}
+
+func (t *TTY) size() (rows, cols uint16) {
+	rows, cols = t.Rows, t.Cols
+	if rows == 0 {
+		rows = DefaultTTYRows
+	}
+	if cols == 0 {
+		cols = DefaultTTYCols
+	}
+	return rows, cols
+}
+
+// Resize changes the terminal size, including for executions already
+// running. A running shell cannot be notified of the change (there is no
+// SIGWINCH in the sandbox); commands it starts afterwards see the new
+// COLUMNS and LINES.
+func (t *TTY) Resize(rows, cols uint16) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Rows, t.Cols = rows, cols
+	rows, cols = t.size()
+	for _, a := range t.attached {
+		a.lib.terminalResize(a.h, rows, cols)
+	}
+}
+
+// attach creates a native terminal of the current size for one execution
+// on an executor from l.
+func (t *TTY) attach(l *library) uintptr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := l.terminalNew(t.size())
+	t.attached = append(t.attached, attachedTerminal{lib: l, h: h})
+	return h
+}
+
+// detach frees a terminal created by attach once its execution returns.
+func (t *TTY) detach(h uintptr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, a := range t.attached {
+		if a.h == h {
+			t.attached = append(t.attached[:i], t.attached[i+1:]...)
+			a.lib.terminalFree(h)
+			return
+		}
+	}
+}
+
+// ExecOptions configures a single execution; see ExecuteWithOptions.
+type ExecOptions struct {
+	// Limits overrides the default resource limits when set.
+	Limits *ResourceLimits
+	// Stdin, if non-nil, is fed to the script as standard input.
+	Stdin []byte
+	// TTY, if set, runs the script as if attached to a terminal.
+	TTY *TTY
+	// ID names the execution so it can be correlated across systems; a
+	// random one is generated when empty. It is returned in Result.ID (also
+	// for a TrapError), exported to the script and the commands it runs as
+	// $CONCH_EXECUTION_ID, and recorded on the native library's log events
+	// as the execution_id field of the conch_execute span.
+	ID string
+	// OnOutput, if set, is called with each chunk of stdout and stderr as
+	// the script writes it, in the order it was written, so the streams can
+	// be merged into one log. Calls are synchronous: the script waits for
+	// each to return. It also sees output beyond the
+	// MaxOutputBytes limit, which the Result drops, and the output of traps
+	// run after the script is stopped. If it panics, the execution is
+	// stopped and fails with a *CallbackPanicError.
+	OnOutput OutputFunc
+	// Capture chooses which output Result keeps when a stream exceeds
+	// MaxOutputBytes. The default keeps the beginning.
+	Capture CaptureMode
+	// Sample configures CaptureSample.
+	Sample SampleOptions
+	// HeadTail configures CaptureHeadTail.
+	HeadTail HeadTailOptions
+	// CompressOver, if positive, has the library gzip each stream of at
+	// least this many bytes before copying it to Go, where it is
+	// decompressed. For large, repetitive output such as logs this cuts
+	// the copy and the native memory held, at the cost of compressing.
+	CompressOver int
+	// KeepCompressed leaves the streams CompressOver compressed as gzip in
+	// Result.Stdout and Result.Stderr, setting Result.StdoutCompressed and
+	// Result.StderrCompressed, instead of decompressing them. Callers that
+	// store or forward the output save the decompression and its buffer.
+	KeepCompressed bool
+	// CaptureVars names shell variables whose final values, after the
+	// script and its EXIT trap, are returned in Result.Vars, saving a second
+	// execution to read what the script computed.
+	CaptureVars []string
+	// Vars sets shell variables before the script runs, after the init
+	// script and registered functions. They are not exported to the
+	// commands the script runs. Values may not contain NUL bytes.
+	Vars map[string]string
+	// BuiltinTimeout, if positive, stops each grep and jq the script runs
+	// once it has run this long, failing it with ExitCodeBuiltinTimeout,
+	// whatever time the script as a whole has left. The library holds the
+	// limit, so the script cannot change it. jq checks it as its loops run
+	// as well as between outputs; recursion a filter writes itself is only
+	// stopped by the script's timeout.
+	BuiltinTimeout time.Duration
+	// RegexLimits, if set, bounds the patterns grep compiles, failing
+	// those that exceed it with a diagnostic whose Err matches
+	// ErrPatternTooComplex.
+	RegexLimits *RegexLimits
+	// Jq, if set, passes variables and module directories to each jq the
+	// script runs.
+	Jq *JqOptions
+	// Priority orders the execution among others waiting for an executor of
+	// a Pool with MaxActive set. An Executor runs executions concurrently and
+	// does not wait, so it ignores it.
+	Priority Priority
+	// QuotaKey names who the execution is charged to, such as a tenant, by
+	// a Pool with PoolOptions.Quotas set. An Executor ignores it.
+	QuotaKey string
+
+	// sources are run ahead of the script; see Session.Source.
+	sources []sourcedScript
+	// into receives the outcome; see ExecuteIntoWithOptions.
+	into *Result
+}
+
+// ExecuteWithOptions runs script with the given options, stopping it when
+// ctx is done.
+func (e *Executor) ExecuteWithOptions(ctx context.Context, script string, opts ExecOptions) (*Result, error) {
+	limits := e.Limits()
+	if opts.Limits != nil {
+		limits = *opts.Limits
+	}
+	return e.run(ctx, script, opts, limits)
+}
@@ -0,0 +1,55 @@
+package conch
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTTYSizeDefaults(t *testing.T) {
+	tty := &TTY{}
+	if rows, cols := tty.Size(); rows != DefaultTTYRows || cols != DefaultTTYCols {
+		t.Errorf("Size() = %dx%d, want defaults", rows, cols)
+	}
+
+	tty = &TTY{Rows: 50}
+	if rows, cols := tty.Size(); rows != 50 || cols != DefaultTTYCols {
+		t.Errorf("Size() = %dx%d, want 50x%d", rows, cols, DefaultTTYCols)
+	}
+
+	// Resizing a TTY with no executions attached needs no native library.
+	tty.Resize(40, 120)
+	if rows, cols := tty.Size(); rows != 40 || cols != 120 {
+		t.Errorf("Size() after Resize = %dx%d, want 40x120", rows, cols)
+	}
+}
+
+func TestExecuteWithOptionsTTY(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	script := `if test -t 1; then echo tty; else echo pipe; fi; echo "$COLUMNS $LINES $TERM"`
+
+	res, err := exec.ExecuteWithOptions(context.Background(), script, ExecOptions{
+		TTY: &TTY{Rows: 30, Cols: 100},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+	if got, want := strings.TrimSpace(string(res.Stdout)), "tty\n100 30 xterm-256color"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+
+	res, err = exec.ExecuteWithOptions(context.Background(), script, ExecOptions{})
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+	if got := strings.SplitN(string(res.Stdout), "\n", 2)[0]; got != "pipe" {
+		t.Errorf("without TTY: first line = %q, want pipe", got)
+	}
+}
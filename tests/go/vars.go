@@ -0,0 +1,93 @@
+package conch
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// captureVars asks the execution that will hold interrupt, an interrupt
+// created by l, to capture the variables named.
+func captureVars(l *library, interrupt uintptr, names []string) error {
+	for _, name := range names {
+		if strings.IndexByte(name, 0) >= 0 {
+			return errors.New("variable name contains a NUL byte")
+		}
+		if err := withCString(name, func(p uintptr) error {
+			ebuf := newErrorBuffer()
+			if l.interruptCaptureVar(interrupt, p, ebuf.ptr(), errorBufferSize) != 0 {
+				return fmt.Errorf("failed to capture variable: %s", ebuf)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// overrideVars returns a copy of vars with the variables in set added,
+// replacing any of the same name, leaving the caller's map as it was.
+func overrideVars(vars, set map[string]string) map[string]string {
+	merged := make(map[string]string, len(vars)+len(set))
+	for name, value := range vars {
+		merged[name] = value
+	}
+	for name, value := range set {
+		merged[name] = value
+	}
+	return merged
+}
+
+// setVars sets the variables in vars in the execution that will hold
+// interrupt, an interrupt created by l.
+func setVars(l *library, interrupt uintptr, vars map[string]string) error {
+	for name, value := range vars {
+		if strings.IndexByte(name, 0) >= 0 || strings.IndexByte(value, 0) >= 0 {
+			return fmt.Errorf("variable %q contains a NUL byte", name)
+		}
+		err := withCString(name, func(n uintptr) error {
+			return withCString(value, func(v uintptr) error {
+				ebuf := newErrorBuffer()
+				if l.interruptSetVar(interrupt, n, v, ebuf.ptr(), errorBufferSize) != 0 {
+					return fmt.Errorf("failed to set variable: %s", ebuf)
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readVars returns the values captured for the variables named once the
+// execution holding interrupt has finished, leaving out those that were
+// unset.
+func readVars(l *library, interrupt uintptr, names []string) map[string]string {
+	vars := make(map[string]string, len(names))
+	for _, name := range names {
+		_ = withCString(name, func(p uintptr) error {
+			if v := l.interruptVar(interrupt, p); v != 0 {
+				vars[name] = goString(v)
+			}
+			return nil
+		})
+	}
+	return vars
+}
+
+// withCString calls fn with s as a pinned null-terminated string.
+func withCString(s string, fn func(uintptr) error) error {
+	cs, err := cString(s)
+	if err != nil {
+		return err
+	}
+	defer freeString(cs)
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	return fn(pinBytes(&pinner, cs.b))
+}
@@ -0,0 +1,68 @@
+package conch
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCaptureVars(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	script := `total=$((6 * 7)); name="multi
+line"; empty=; trap 'status=done' EXIT`
+	result, err := exec.ExecuteWithOptions(context.Background(), script, ExecOptions{
+		CaptureVars: []string{"total", "name", "empty", "status", "missing"},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+	want := map[string]string{"total": "42", "name": "multi\nline", "empty": "", "status": "done"}
+	if !reflect.DeepEqual(result.Vars, want) {
+		t.Errorf("Vars = %q, want %q", result.Vars, want)
+	}
+
+	result, err = exec.ExecuteWithOptions(context.Background(), "x=1", ExecOptions{})
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+	if result.Vars != nil {
+		t.Errorf("Vars = %q without CaptureVars", result.Vars)
+	}
+
+	for _, name := range []string{"", "1x", "a-b", "a b", "x\x00"} {
+		_, err := exec.ExecuteWithOptions(context.Background(), "true", ExecOptions{CaptureVars: []string{name}})
+		if err == nil {
+			t.Errorf("CaptureVars %q succeeded", name)
+		}
+	}
+}
+
+func TestSetVars(t *testing.T) {
+	if err := setVars(nil, 0, map[string]string{"x": "a\x00b"}); err == nil {
+		t.Error("setVars(NUL in value) succeeded")
+	}
+
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	result, err := exec.ExecuteWithOptions(context.Background(), `echo "$greeting"; export -p | grep -q greeting || echo unexported`,
+		ExecOptions{Vars: map[string]string{"greeting": "hello 'world'"}})
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error: %v", err)
+	}
+	if want := "hello 'world'\nunexported\n"; string(result.Stdout) != want {
+		t.Errorf("stdout = %q, want %q", result.Stdout, want)
+	}
+}
@@ -0,0 +1,215 @@
+package conch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// shellExportPrefix prefixes the shell interface export name in a component
+// binary; the interface version follows the '@'.
+const shellExportPrefix = "conch:shell/shell@"
+
+// ErrVersionMismatch is returned when a shell component exports a different
+// conch:shell interface version than the native library speaks.
+var ErrVersionMismatch = errors.New("shell component version mismatch")
+
+// LibraryVersion returns the version of the loaded native library.
+func LibraryVersion() (string, error) {
+	l, err := acquireLibrary()
+	if err != nil {
+		return "", err
+	}
+	defer l.release()
+	if err := l.versions.load(); err != nil {
+		return "", err
+	}
+	return goString(l.version()), nil
+}
+
+// ShellVersion returns the conch:shell interface version the native library
+// speaks. Components must export the same version to be loadable.
+func ShellVersion() (string, error) {
+	l, err := acquireLibrary()
+	if err != nil {
+		return "", err
+	}
+	defer l.release()
+	return l.shellVersion()
+}
+
+// ShellVersion returns the conch:shell interface version the executor's
+// native library speaks.
+func (e *Executor) ShellVersion() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.handle == 0 {
+		return ""
+	}
+	v, _ := e.lib.shellVersion()
+	return v
+}
+
+// shellVersion returns the conch:shell interface version l speaks.
+func (l *library) shellVersion() (string, error) {
+	if err := l.versions.load(); err != nil {
+		return "", err
+	}
+	return goString(l.shellInterfaceVersion()), nil
+}
+
+// ComponentVersion returns the conch:shell interface version exported by the
+// executor's shell component, or "" if it could not be determined.
+func (e *Executor) ComponentVersion() string {
+	return e.componentVersion
+}
+
+// RequireVersion checks the native library version against constraint, a
+// comma-separated list of comparisons that must all hold, e.g.
+// ">=0.2.0, <0.3.0". Supported operators are =, !=, <, <=, > and >=; a bare
+// version means =.
+func RequireVersion(constraint string) error {
+	v, err := LibraryVersion()
+	if err != nil {
+		return err
+	}
+	ok, err := satisfies(v, constraint)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("conch library version %s does not satisfy %q; install a matching libconch build", v, constraint)
+	}
+	return nil
+}
+
+// checkComponentVersion returns the shell interface version exported by the
+// component in data, failing with ErrVersionMismatch if it differs from the
+// one the library speaks. An unrecognised component yields "" and no error;
+// the native loader reports those, as does a library too old to say which
+// version it speaks.
+func checkComponentVersion(l *library, data []byte) (string, error) {
+	got := componentVersion(data)
+	want, err := l.shellVersion()
+	if err != nil {
+		return got, nil
+	}
+	if got != "" && want != "" && got != want {
+		return got, fmt.Errorf("%w: component exports conch:shell@%s but library speaks conch:shell@%s; rebuild the component (cargo build -p conch-shell --target wasm32-wasip2 --release) or use a matching libconch",
+			ErrVersionMismatch, got, want)
+	}
+	return got, nil
+}
+
+// embeddedComponentBytes returns the component embedded in l without
+// copying it, or nil if there is none. It is valid while l is loaded.
+func embeddedComponentBytes(l *library) []byte {
+	if l.versions.load() != nil {
+		return nil
+	}
+	var n uintptr
+	ptr := l.embeddedComponentBytes(uintptr(unsafe.Pointer(&n)))
+	if ptr == 0 || n == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(ptr)), n)
+}
+
+// componentVersion scans a component binary for its shell interface export
+// and returns the version, or "" if none is found.
+func componentVersion(data []byte) string {
+	i := bytes.Index(data, []byte(shellExportPrefix))
+	if i < 0 {
+		return ""
+	}
+	rest := data[i+len(shellExportPrefix):]
+	n := 0
+	for n < len(rest) && isVersionByte(rest[n]) {
+		n++
+	}
+	return string(rest[:n])
+}
+
+func isVersionByte(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' ||
+		b == '.' || b == '-' || b == '+'
+}
+
+// satisfies reports whether version v meets every comparison in constraint.
+func satisfies(v, constraint string) (bool, error) {
+	have, err := parseVersion(v)
+	if err != nil {
+		return false, err
+	}
+	terms := strings.Split(constraint, ",")
+	for _, term := range terms {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			return false, fmt.Errorf("invalid version constraint %q", constraint)
+		}
+		op := strings.TrimRight(term[:min(2, len(term))], "0123456789. v")
+		want, err := parseVersion(strings.TrimSpace(term[len(op):]))
+		if err != nil {
+			return false, err
+		}
+		c := compareVersions(have, want)
+		var ok bool
+		switch op {
+		case "", "=", "==":
+			ok = c == 0
+		case "!=":
+			ok = c != 0
+		case "<":
+			ok = c < 0
+		case "<=":
+			ok = c <= 0
+		case ">":
+			ok = c > 0
+		case ">=":
+			ok = c >= 0
+		default:
+			return false, fmt.Errorf("invalid operator %q in version constraint %q", op, constraint)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// parseVersion parses MAJOR[.MINOR[.PATCH]], ignoring a leading "v" and any
+// pre-release or build suffix.
+func parseVersion(s string) ([3]int, error) {
+	var parts [3]int
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	fields := strings.Split(s, ".")
+	if s == "" || len(fields) > 3 {
+		return parts, fmt.Errorf("invalid version %q", s)
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return parts, fmt.Errorf("invalid version %q", s)
+		}
+		parts[i] = n
+	}
+	return parts, nil
+}
+
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,110 @@
+package conch
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestComponentVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"export", "\x00asm\x17conch:shell/shell@0.2.0\x00", "0.2.0"},
+		{"prerelease", "\x10conch:shell/shell@0.3.0-rc.1\x01", "0.3.0-rc.1"},
+		{"at end", "conch:shell/shell@1.0.0", "1.0.0"},
+		{"other interface", "conch:shell/tools@0.2.0", ""},
+		{"missing", "\x00asm", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := componentVersion([]byte(tt.data)); got != tt.want {
+				t.Errorf("componentVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	tests := []struct {
+		version    string
+		constraint string
+		want       bool
+	}{
+		{"0.2.0", "0.2.0", true},
+		{"0.2.0", "=0.2.0", true},
+		{"0.2.0", "==0.2", true},
+		{"0.2.1", "0.2.0", false},
+		{"0.2.1", "!=0.2.0", true},
+		{"0.2.1", ">=0.2.0, <0.3.0", true},
+		{"0.3.0", ">=0.2.0, <0.3.0", false},
+		{"0.1.9", ">= 0.2.0", false},
+		{"1.0.0", ">0.9", true},
+		{"1.0.0", "<=v1", true},
+		{"0.2.0-dev", ">=0.2.0", true},
+	}
+	for _, tt := range tests {
+		got, err := satisfies(tt.version, tt.constraint)
+		if err != nil {
+			t.Errorf("satisfies(%q, %q) error: %v", tt.version, tt.constraint, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("satisfies(%q, %q) = %v, want %v", tt.version, tt.constraint, got, tt.want)
+		}
+	}
+}
+
+func TestSatisfiesInvalid(t *testing.T) {
+	for _, constraint := range []string{"", ">=0.2.0,", "~0.2", "=>0.2", ">=x.y", "1.2.3.4"} {
+		if _, err := satisfies("0.2.0", constraint); err == nil {
+			t.Errorf("satisfies(%q) succeeded, want error", constraint)
+		}
+	}
+}
+
+func TestRequireVersion(t *testing.T) {
+	if !IsAvailable() {
+		t.Skip("conch library not available")
+	}
+
+	v, err := LibraryVersion()
+	if err != nil {
+		t.Fatalf("LibraryVersion() error: %v", err)
+	}
+	if err := RequireVersion(v); err != nil {
+		t.Errorf("RequireVersion(%q) error: %v", v, err)
+	}
+	if err := RequireVersion("<0.0.1"); err == nil {
+		t.Error("RequireVersion(<0.0.1) succeeded, want error")
+	}
+}
+
+func TestExecutorVersions(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := NewExecutorEmbedded()
+	if err != nil {
+		t.Fatalf("NewExecutorEmbedded() error: %v", err)
+	}
+	defer exec.Close()
+
+	if exec.ShellVersion() == "" {
+		t.Error("ShellVersion() is empty")
+	}
+	if got, want := exec.ComponentVersion(), exec.ShellVersion(); got != want {
+		t.Errorf("ComponentVersion() = %q, want %q", got, want)
+	}
+}
+
+func TestNewExecutorFromBytesVersionMismatch(t *testing.T) {
+	if !IsAvailable() {
+		t.Skip("conch library not available")
+	}
+
+	_, err := NewExecutorFromBytes([]byte("\x00asm conch:shell/shell@0.0.0-mismatch\x00"))
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("NewExecutorFromBytes() error = %v, want ErrVersionMismatch", err)
+	}
+}
@@ -0,0 +1,190 @@
+package conch
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"time"
+)
+
+// Default Watcher timings.
+const (
+	defaultWatchInterval = 250 * time.Millisecond
+	defaultWatchDebounce = 100 * time.Millisecond
+)
+
+// Watcher reruns a script whenever the files mounted into it change, for
+// developing data transformations against live files:
+//
+//	w := conch.Watcher{Options: []conch.Option{conch.WithEmbedded()}}
+//	err := w.Run(ctx, `jq -c '.[]' /data/input.json`,
+//		[]conch.Mount{{Dir: "/data", FS: os.DirFS("testdata")}},
+//		func(r *conch.Result, err error) { ... })
+//
+// Mounts of directories returned by os.DirFS are watched with the operating
+// system's notifications where it has them, on Linux. Other mounts, which
+// may be any fs.FS, are polled: a change is a file added, removed, or with a
+// new size, mode or modification time.
+//
+// Set the fields before calling Run.
+type Watcher struct {
+	// Interval is how often polled mounts are checked for changes.
+	// Defaults to 250ms.
+	Interval time.Duration
+	// Debounce is how long the mounts must go unchanged after a change
+	// before the script reruns, so saving several files runs it once.
+	// Defaults to 100ms.
+	Debounce time.Duration
+	// Options configure the executor each run is given, before the mounts.
+	Options []Option
+}
+
+// Watch runs script with a Watcher using default settings and the best
+// available backend.
+func Watch(ctx context.Context, script string, mounts []Mount, onResult func(*Result, error)) error {
+	var w Watcher
+	return w.Run(ctx, script, mounts, onResult)
+}
+
+// Run executes script once and again after each change to the files of
+// mounts, until ctx is done, calling onResult with every outcome. Each run
+// gets a new executor seeded with the files as they are then, so a run
+// sees no state left by the one before. A run still going when the files
+// change again finishes first.
+//
+// Run returns ctx.Err(). Failures to create an executor or to run the
+// script are passed to onResult, and watching continues.
+func (w *Watcher) Run(ctx context.Context, script string, mounts []Mount, onResult func(*Result, error)) error {
+	return w.watch(ctx, mounts, func(ctx context.Context) {
+		opts := append([]Option(nil), w.Options...)
+		for _, m := range mounts {
+			opts = append(opts, WithMount(m.Dir, m.FS))
+		}
+		exec, err := New(opts...)
+		if err != nil {
+			onResult(nil, err)
+			return
+		}
+		defer exec.Close()
+		onResult(exec.ExecuteContext(ctx, script))
+	})
+}
+
+// watch calls run at once and after every debounced change to mounts until
+// ctx is done.
+func (w *Watcher) watch(ctx context.Context, mounts []Mount, run func(context.Context)) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	debounce := w.Debounce
+	if debounce <= 0 {
+		debounce = defaultWatchDebounce
+	}
+
+	// Watch what the system can, and poll the rest.
+	var notified <-chan struct{}
+	polled := mounts
+	if notifier, err := newDirNotifier(); err == nil {
+		defer notifier.close()
+		polled = nil
+		for _, m := range mounts {
+			if dir, ok := osDir(m.FS); !ok || notifier.add(dir) != nil {
+				polled = append(polled, m)
+			}
+		}
+		notified = notifier.events
+	}
+	var tick <-chan time.Time
+	if len(polled) > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	last := snapshotMounts(polled)
+	run(ctx)
+
+	settled := time.NewTimer(debounce)
+	stopTimer(settled)
+	defer settled.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-notified:
+			stopTimer(settled)
+			settled.Reset(debounce)
+		case <-tick:
+			if snap := snapshotMounts(polled); !snap.equal(last) {
+				last = snap
+				stopTimer(settled)
+				settled.Reset(debounce)
+			}
+		case <-settled.C:
+			run(ctx)
+		}
+	}
+}
+
+// stopTimer stops t and drains its channel, so it can be Reset.
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
+
+// osDir returns the host directory fsys reads, if os.DirFS returned it.
+func osDir(fsys fs.FS) (string, bool) {
+	v := reflect.ValueOf(fsys)
+	if !v.IsValid() || v.Kind() != reflect.String || v.Type().PkgPath() != "os" || v.Type().Name() != "dirFS" {
+		return "", false
+	}
+	return v.String(), true
+}
+
+// mountSnapshot is the state of every file of some mounts, keyed by mount
+// directory and path. A mount that cannot be read has an entry for the
+// error, so it changes when the mount recovers.
+type mountSnapshot map[string]string
+
+// snapshotMounts records the state of the files of mounts.
+func snapshotMounts(mounts []Mount) mountSnapshot {
+	snap := mountSnapshot{}
+	for _, m := range mounts {
+		err := fs.WalkDir(m.FS, ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			snap[m.Dir+"/"+name] = fmt.Sprint(info.Size(), info.Mode(), info.ModTime().UnixNano())
+			return nil
+		})
+		if err != nil {
+			snap[m.Dir] = err.Error()
+		}
+	}
+	return snap
+}
+
+func (s mountSnapshot) equal(other mountSnapshot) bool {
+	if len(s) != len(other) {
+		return false
+	}
+	for name, state := range s {
+		if o, ok := other[name]; !ok || o != state {
+			return false
+		}
+	}
+	return true
+}
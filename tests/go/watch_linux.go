@@ -0,0 +1,113 @@
+package conch
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask is the changes a dirNotifier is told of.
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// dirNotifier reports changes to the files under host directories, with
+// inotify. Directories created under them are watched as they appear.
+type dirNotifier struct {
+	// fd is f's descriptor. f.Fd would make it blocking.
+	fd int
+	f  *os.File
+	// events receives a value, without blocking, after every read of
+	// changes.
+	events chan struct{}
+	done   chan struct{}
+
+	mu  sync.Mutex
+	wds map[int32]string
+}
+
+// newDirNotifier returns a dirNotifier watching nothing yet.
+func newDirNotifier() (*dirNotifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// A non-blocking descriptor is read through the runtime's poller, so
+	// close unblocks the read.
+	n := &dirNotifier{
+		fd:     fd,
+		f:      os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		wds:    map[int32]string{},
+	}
+	go n.read()
+	return n, nil
+}
+
+// add watches root and the directories under it.
+func (n *dirNotifier) add(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		return n.watch(path)
+	})
+}
+
+// watch watches the directory path itself.
+func (n *dirNotifier) watch(path string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	wd, err := syscall.InotifyAddWatch(n.fd, path, inotifyMask)
+	if err != nil {
+		return &fs.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+	}
+	n.wds[int32(wd)] = path
+	return nil
+}
+
+// read reports changes on n.events until n is closed.
+func (n *dirNotifier) read() {
+	defer close(n.done)
+	var buf [64 * (syscall.SizeofInotifyEvent + syscall.NAME_MAX + 1)]byte
+	for {
+		count, err := n.f.Read(buf[:])
+		if errors.Is(err, os.ErrClosed) {
+			return
+		}
+		if err != nil || count < syscall.SizeofInotifyEvent {
+			continue
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= count; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+			if ev.Mask&syscall.IN_ISDIR != 0 && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				n.mu.Lock()
+				dir, ok := n.wds[ev.Wd]
+				n.mu.Unlock()
+				if ok {
+					_ = n.add(filepath.Join(dir, string(bytes.TrimRight(name, "\x00"))))
+				}
+			}
+		}
+		select {
+		case n.events <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// close stops watching.
+func (n *dirNotifier) close() {
+	n.f.Close()
+	<-n.done
+}
@@ -0,0 +1,45 @@
+package conch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDirNotifier(t *testing.T) {
+	dir := t.TempDir()
+	n, err := newDirNotifier()
+	if err != nil {
+		t.Fatalf("newDirNotifier() error: %v", err)
+	}
+	defer n.close()
+	if err := n.add(dir); err != nil {
+		t.Fatalf("add() error: %v", err)
+	}
+
+	wait := func(what string) {
+		t.Helper()
+		select {
+		case <-n.events:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event after %s", what)
+		}
+		// Let the events of the change arrive before the next.
+		time.Sleep(10 * time.Millisecond)
+		select {
+		case <-n.events:
+		default:
+		}
+	}
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	wait("creating a directory")
+	// Files in new directories are watched too.
+	if err := os.WriteFile(filepath.Join(sub, "f.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wait("writing a file in it")
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package conch
+
+import "errors"
+
+// dirNotifier would report changes to the files under host directories;
+// without inotify, Watcher polls them instead.
+type dirNotifier struct {
+	events chan struct{}
+}
+
+func newDirNotifier() (*dirNotifier, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (n *dirNotifier) add(string) error { return errors.ErrUnsupported }
+
+func (n *dirNotifier) close() {}
@@ -0,0 +1,95 @@
+package conch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestSnapshotMounts(t *testing.T) {
+	fsys := fstest.MapFS{"a.txt": {Data: []byte("a")}, "dir/b.txt": {Data: []byte("b")}}
+	mounts := []Mount{{Dir: "/data", FS: fsys}}
+	before := snapshotMounts(mounts)
+	if len(before) != 2 {
+		t.Fatalf("snapshot = %v, want 2 files", before)
+	}
+	if !before.equal(snapshotMounts(mounts)) {
+		t.Error("unchanged mounts compare unequal")
+	}
+	fsys["a.txt"] = &fstest.MapFile{Data: []byte("changed")}
+	if before.equal(snapshotMounts(mounts)) {
+		t.Error("changed file not detected")
+	}
+	delete(fsys, "dir/b.txt")
+	if len(snapshotMounts(mounts)) != 1 {
+		t.Error("removed file still in snapshot")
+	}
+}
+
+func TestWatcherReruns(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "input.txt")
+	if err := os.WriteFile(path, []byte("one"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w := Watcher{Interval: 5 * time.Millisecond, Debounce: 10 * time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	runs := make(chan struct{}, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- w.watch(ctx, []Mount{{Dir: "/data", FS: os.DirFS(dir)}}, func(context.Context) {
+			runs <- struct{}{}
+		})
+	}()
+
+	<-runs
+	if err := os.WriteFile(path, []byte("two, longer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-runs:
+	case <-ctx.Done():
+		t.Fatal("script was not rerun after the file changed")
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("watch() = %v, want context.Canceled", err)
+	}
+	if len(runs) != 0 {
+		t.Errorf("%d extra runs without changes", len(runs))
+	}
+}
+
+func TestWatch(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	fsys := fstest.MapFS{"name": {Data: []byte("world")}}
+	w := Watcher{Options: []Option{WithEmbedded()}}
+	err := w.Run(ctx, "echo hello $(cat /data/name)", []Mount{{Dir: "/data", FS: fsys}}, func(r *Result, err error) {
+		if err != nil {
+			t.Errorf("run error: %v", err)
+		} else if got := string(r.Stdout); got != "hello world\n" {
+			t.Errorf("stdout = %q", got)
+		}
+		cancel()
+	})
+	if err != context.Canceled {
+		t.Errorf("Run() = %v, want context.Canceled", err)
+	}
+}
+
+func TestOSDir(t *testing.T) {
+	if dir, ok := osDir(os.DirFS("/tmp/x")); !ok || dir != "/tmp/x" {
+		t.Errorf("osDir(os.DirFS) = %q, %v", dir, ok)
+	}
+	if _, ok := osDir(fstest.MapFS{}); ok {
+		t.Error("osDir(MapFS) = ok")
+	}
+}
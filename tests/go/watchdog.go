@@ -0,0 +1,115 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrExecutionStuck is wrapped by the error returned for an execution the
+// watchdog killed.
+var ErrExecutionStuck = errors.New("execution made no progress")
+
+// WatchdogAction is what Watchdog.OnStuck decides to do with an idle
+// execution.
+type WatchdogAction int
+
+const (
+	// WatchdogWarn lets the execution run on. OnStuck is not called again
+	// until it shows activity and then goes idle once more.
+	WatchdogWarn WatchdogAction = iota
+	// WatchdogExtend gives the execution another Idle period, after which
+	// OnStuck is called again if it is still idle.
+	WatchdogExtend
+	// WatchdogKill stops the execution as if its context was cancelled. Its
+	// error wraps ErrExecutionStuck.
+	WatchdogKill
+)
+
+// Watchdog flags executions that stop making progress without timing out,
+// such as a script blocked reading input that will never come.
+//
+// Progress is any call between the script and the host, such as writing
+// output, touching the filesystem or running a command, and any time spent
+// computing in the script itself. A script blocked in a host call, such as
+// reading input or waiting on a command, is idle.
+//
+// Only executions that can be stopped are watched: those started with a
+// context, such as ExecuteContext, ExecuteWithOptions, Submit and
+// ExecuteNDJSON. Execute is not.
+type Watchdog struct {
+	// Idle is how long an execution may go without progress before OnStuck
+	// is called.
+	Idle time.Duration
+	// OnStuck decides what happens to the execution with ID id, idle for
+	// idle. It runs on a goroutine of its own while the script carries on.
+	// A nil OnStuck kills stuck executions.
+	OnStuck func(id string, idle time.Duration) WatchdogAction
+}
+
+// startWatchdog watches the execution holding interrupt, if the executor has
+// a watchdog. It returns the context to run the execution with, cancelled if
+// the watchdog kills it, and a function to call once the execution returns,
+// before interrupt is freed.
+func (e *Executor) startWatchdog(ctx context.Context, l *library, interrupt uintptr, id string) (context.Context, func()) {
+	if e.watchdog == nil {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		e.watchdog.watch(done, id, func() uint64 {
+			// Advance the epoch so a script computing without host calls
+			// shows up as activity by the next sample.
+			n := l.interruptActivity(interrupt)
+			l.executorTick(e.handle)
+			return n
+		}, cancel)
+	}()
+	return ctx, func() {
+		close(done)
+		<-stopped
+		cancel(nil)
+	}
+}
+
+// watch samples activity until done is closed, consulting OnStuck whenever
+// it stays unchanged for Idle and calling kill if told to.
+func (w *Watchdog) watch(done <-chan struct{}, id string, activity func() uint64, kill context.CancelCauseFunc) {
+	ticker := time.NewTicker(max(w.Idle/4, time.Millisecond))
+	defer ticker.Stop()
+
+	last := activity()
+	since := time.Now()
+	warned := false
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if n := activity(); n != last {
+				last, since, warned = n, now, false
+				continue
+			}
+			idle := now.Sub(since)
+			if warned || idle < w.Idle {
+				continue
+			}
+			action := WatchdogKill
+			if w.OnStuck != nil {
+				action = w.OnStuck(id, idle)
+			}
+			switch action {
+			case WatchdogKill:
+				kill(ErrExecutionStuck)
+				return
+			case WatchdogExtend:
+				since = now
+			default:
+				warned = true
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package conch
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runWatch runs w.watch over activity until it kills or stop is called.
+func runWatch(w *Watchdog, activity func() uint64) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		w.watch(done, "exec-1", activity, cancel)
+	}()
+	return ctx, func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func TestWatchdogKill(t *testing.T) {
+	var calls atomic.Int32
+	w := &Watchdog{Idle: 20 * time.Millisecond, OnStuck: func(id string, idle time.Duration) WatchdogAction {
+		calls.Add(1)
+		if id != "exec-1" || idle < 20*time.Millisecond {
+			t.Errorf("OnStuck(%q, %v)", id, idle)
+		}
+		return WatchdogKill
+	}}
+	ctx, stop := runWatch(w, func() uint64 { return 7 })
+	defer stop()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle execution was not killed")
+	}
+	if !errors.Is(context.Cause(ctx), ErrExecutionStuck) {
+		t.Errorf("Cause = %v, want ErrExecutionStuck", context.Cause(ctx))
+	}
+	if calls.Load() != 1 {
+		t.Errorf("OnStuck called %d times, want 1", calls.Load())
+	}
+}
+
+func TestWatchdogActivityResets(t *testing.T) {
+	var n atomic.Uint64
+	w := &Watchdog{Idle: 30 * time.Millisecond}
+	ctx, stop := runWatch(w, func() uint64 { return n.Add(1) })
+
+	time.Sleep(100 * time.Millisecond)
+	stop()
+	if ctx.Err() != nil {
+		t.Error("active execution was killed")
+	}
+}
+
+func TestWatchdogWarnAndExtend(t *testing.T) {
+	for _, tt := range []struct {
+		action WatchdogAction
+		more   bool
+	}{
+		{WatchdogWarn, false},
+		{WatchdogExtend, true},
+	} {
+		var calls atomic.Int32
+		w := &Watchdog{Idle: 10 * time.Millisecond, OnStuck: func(string, time.Duration) WatchdogAction {
+			calls.Add(1)
+			return tt.action
+		}}
+		ctx, stop := runWatch(w, func() uint64 { return 0 })
+		time.Sleep(100 * time.Millisecond)
+		stop()
+
+		if ctx.Err() != nil {
+			t.Errorf("action %d killed the execution", tt.action)
+		}
+		if got := calls.Load(); (got > 1) != tt.more || got == 0 {
+			t.Errorf("action %d: OnStuck called %d times", tt.action, got)
+		}
+	}
+}
+
+func TestWatchdogKillsStuckExecution(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded(), WithWatchdog(Watchdog{Idle: 200 * time.Millisecond}))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	start := time.Now()
+	_, err = exec.ExecuteContext(context.Background(), "sleep 30")
+	if !errors.Is(err, ErrExecutionStuck) {
+		t.Errorf("ExecuteContext() error = %v, want ErrExecutionStuck", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("stuck execution took %v to be killed", elapsed)
+	}
+}
+
+func TestWatchdogSparesBusyExecution(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded(), WithWatchdog(Watchdog{Idle: 200 * time.Millisecond}))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+
+	// A loop with no host calls keeps running past Idle.
+	result, err := exec.ExecuteContext(context.Background(), "i=0; while [ $i -lt 200000 ]; do i=$((i+1)); done; echo $i")
+	if err != nil {
+		t.Fatalf("ExecuteContext() error = %v", err)
+	}
+	if string(result.Stdout) != "200000\n" {
+		t.Errorf("stdout = %q, want %q", result.Stdout, "200000\n")
+	}
+}
+
+func TestWatchdogConfig(t *testing.T) {
+	if !IsAvailable() {
+		t.Skip("Skipping: conch library not available")
+	}
+	if _, err := New(WithWatchdog(Watchdog{})); err == nil {
+		t.Error("New() with a zero idle period succeeded")
+	}
+}
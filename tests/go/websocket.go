@@ -0,0 +1,279 @@
+package conch
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// WebSocket opcodes, from RFC 6455.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// WebSocket close codes, from RFC 6455.
+const (
+	wsCloseNormal      = 1000
+	wsCloseProtocol    = 1002
+	wsCloseUnsupported = 1003
+	wsCloseTooBig      = 1009
+)
+
+// wsAcceptGUID is appended to Sec-WebSocket-Key to compute
+// Sec-WebSocket-Accept.
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// errWSClosed is returned by wsConn.readMessage once the peer has closed
+// the connection.
+var errWSClosed = errors.New("websocket closed")
+
+// wsConn is the server end of a WebSocket connection. It implements only
+// what WebSocketHandler needs: unfragmented writes, reassembly of
+// fragmented reads, and answering pings and closes. One goroutine may read
+// while others write.
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	// maxMessage bounds the size of a message read, after reassembly.
+	maxMessage int64
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+// upgradeWebSocket answers the WebSocket handshake in r, replying with an
+// HTTP error if it is not one, and takes over its connection.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, maxMessage int64) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case r.Method != http.MethodGet:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, errors.New("websocket handshake: method is not GET")
+	case !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket"):
+		http.Error(w, "expected a websocket upgrade", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket handshake: not an upgrade request")
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket handshake: unsupported version")
+	case key == "":
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("websocket handshake: missing key")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket handshake: response cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket handshake: %w", err)
+	}
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", wsAccept(key))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("websocket handshake: %w", err)
+	}
+	return &wsConn{conn: conn, r: rw.Reader, maxMessage: maxMessage}, nil
+}
+
+// wsAccept returns the Sec-WebSocket-Accept value for key.
+func wsAccept(key string) string {
+	sum := sha1.Sum([]byte(key + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHasToken reports whether the comma-separated header name lists
+// token, ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readMessage returns the next text or binary message, answering pings
+// and reassembling fragments on the way. It returns errWSClosed once the
+// peer closed the connection, after replying to its close.
+func (c *wsConn) readMessage() (opcode byte, data []byte, err error) {
+	for {
+		fin, op, payload, err := readWSFrame(c.r, c.maxMessage-int64(len(data)), true)
+		if err != nil {
+			if errors.Is(err, errWSTooBig) {
+				c.close(wsCloseTooBig, "message too big")
+			} else if !errors.Is(err, io.EOF) {
+				c.close(wsCloseProtocol, err.Error())
+			}
+			return 0, nil, err
+		}
+		switch op {
+		case wsPing:
+			if err := c.write(wsPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.close(wsCloseNormal, "")
+			return 0, nil, errWSClosed
+		case wsContinuation:
+			if opcode == 0 {
+				c.close(wsCloseProtocol, "unexpected continuation frame")
+				return 0, nil, errors.New("websocket: unexpected continuation frame")
+			}
+		case wsText, wsBinary:
+			if opcode != 0 {
+				c.close(wsCloseProtocol, "expected a continuation frame")
+				return 0, nil, errors.New("websocket: expected a continuation frame")
+			}
+			opcode = op
+		default:
+			c.close(wsCloseProtocol, "unknown opcode")
+			return 0, nil, fmt.Errorf("websocket: unknown opcode %#x", op)
+		}
+		data = append(data, payload...)
+		if fin {
+			return opcode, data, nil
+		}
+	}
+}
+
+// write sends data as a single frame with opcode.
+func (c *wsConn) write(opcode byte, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return errWSClosed
+	}
+	return writeWSFrame(c.conn, opcode, data, nil)
+}
+
+// close sends a close frame with code and reason, unless one was sent
+// already. Later writes fail.
+func (c *wsConn) close(code int, reason string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > 123 {
+		// Control frames carry at most 125 bytes.
+		reason = reason[:123]
+	}
+	writeWSFrame(c.conn, wsClose, append(payload, reason...), nil)
+}
+
+// Close closes the underlying connection, sending a normal close frame
+// first if none was sent.
+func (c *wsConn) Close() error {
+	c.close(wsCloseNormal, "")
+	return c.conn.Close()
+}
+
+// errWSTooBig is returned by readWSFrame for a frame over its limit.
+var errWSTooBig = errors.New("websocket: message too big")
+
+// readWSFrame reads one frame from r, unmasking its payload, and fails
+// with errWSTooBig if the payload is longer than limit. Frames from
+// clients must be masked, and frames from servers must not be.
+func readWSFrame(r io.Reader, limit int64, fromClient bool) (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode = header[0]&0x80 != 0, header[0]&0x0f
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, errors.New("websocket: reserved bits set")
+	}
+	masked := header[1]&0x80 != 0
+	if masked != fromClient {
+		return false, 0, nil, errors.New("websocket: frame masking is wrong for its direction")
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= wsClose && (length > 125 || !fin) {
+		return false, 0, nil, errors.New("websocket: invalid control frame")
+	}
+	if opcode < wsClose && length > uint64(limit) {
+		return false, 0, nil, errWSTooBig
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeWSFrame writes data to w as one final frame with opcode, masked
+// with mask if it is not nil, as clients must.
+func writeWSFrame(w io.Writer, opcode byte, data []byte, mask []byte) error {
+	frame := []byte{0x80 | opcode}
+	maskBit := byte(0)
+	if mask != nil {
+		maskBit = 0x80
+	}
+	switch n := len(data); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, maskBit|126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, maskBit|127), uint64(n))
+	}
+	if mask != nil {
+		frame = append(frame, mask...)
+		start := len(frame)
+		frame = append(frame, data...)
+		for i := range frame[start:] {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, data...)
+	}
+	_, err := w.Write(frame)
+	return err
+}
@@ -0,0 +1,139 @@
+package conch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testMask is the mask the test client puts on its frames.
+var testMask = []byte{1, 2, 3, 4}
+
+// dialTestWebSocket performs the WebSocket handshake with the server at
+// url, an httptest URL, with extra headers, returning the connection and
+// its reader.
+func dialTestWebSocket(t *testing.T, url string, header http.Header) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake response = %s %v", resp.Status, resp.Header)
+	}
+	return conn, r
+}
+
+func TestWSAccept(t *testing.T) {
+	// The example from RFC 6455, section 1.3.
+	if got := wsAccept("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("wsAccept() = %q", got)
+	}
+}
+
+func TestWSFrameRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 125, 126, 0xffff, 0x10000} {
+		data := bytes.Repeat([]byte{'x'}, size)
+		var buf bytes.Buffer
+		if err := writeWSFrame(&buf, wsBinary, data, testMask); err != nil {
+			t.Fatal(err)
+		}
+		fin, op, payload, err := readWSFrame(&buf, 1<<20, true)
+		if err != nil || !fin || op != wsBinary || !bytes.Equal(payload, data) {
+			t.Errorf("size %d: readWSFrame() = %v %#x %d bytes, %v", size, fin, op, len(payload), err)
+		}
+	}
+
+	var buf bytes.Buffer
+	writeWSFrame(&buf, wsText, []byte("unmasked"), nil)
+	if _, _, _, err := readWSFrame(&buf, 1<<20, true); err == nil {
+		t.Error("readWSFrame() accepted an unmasked client frame")
+	}
+	buf.Reset()
+	writeWSFrame(&buf, wsText, make([]byte, 11), testMask)
+	if _, _, _, err := readWSFrame(&buf, 10, true); !errors.Is(err, errWSTooBig) {
+		t.Errorf("readWSFrame() over the limit error = %v", err)
+	}
+}
+
+func TestWSConnReadMessage(t *testing.T) {
+	got := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgradeWebSocket(w, r, 1024)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		op, data, err := conn.readMessage()
+		if err != nil || op != wsText {
+			got <- err.Error()
+			return
+		}
+		got <- string(data)
+		if _, _, err := conn.readMessage(); !errors.Is(err, errWSClosed) {
+			got <- "second readMessage() error: " + err.Error()
+		}
+	}))
+	defer srv.Close()
+
+	conn, r := dialTestWebSocket(t, srv.URL, nil)
+	// A message in two fragments with a ping between them.
+	conn.Write([]byte{wsText, 0x80 | 3, 1, 2, 3, 4, 'h' ^ 1, 'e' ^ 2, 'l' ^ 3})
+	writeWSFrame(conn, wsPing, []byte("p"), testMask)
+	conn.Write([]byte{0x80 | wsContinuation, 0x80 | 2, 1, 2, 3, 4, 'l' ^ 1, 'o' ^ 2})
+	if msg := <-got; msg != "hello" {
+		t.Errorf("readMessage() = %q, want hello", msg)
+	}
+	if _, op, payload, err := readWSFrame(r, 125, false); err != nil || op != wsPong || string(payload) != "p" {
+		t.Errorf("reply to ping = %#x %q, %v", op, payload, err)
+	}
+
+	writeWSFrame(conn, wsClose, binary.BigEndian.AppendUint16(nil, wsCloseNormal), testMask)
+	if _, op, payload, err := readWSFrame(r, 125, false); err != nil || op != wsClose || binary.BigEndian.Uint16(payload) != wsCloseNormal {
+		t.Errorf("reply to close = %#x %v, %v", op, payload, err)
+	}
+	select {
+	case msg := <-got:
+		t.Error(msg)
+	default:
+	}
+}
+
+func TestUpgradeWebSocketRejects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgradeWebSocket(w, r, 1024)
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("plain GET status = %d, want %d", resp.StatusCode, http.StatusUpgradeRequired)
+	}
+}
@@ -0,0 +1,192 @@
+package conch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// defaultMaxWSMessageBytes is WebSocketHandler.MaxMessageBytes when unset.
+const defaultMaxWSMessageBytes = 1 << 20
+
+// WebSocketHandler is an http.Handler running one script per WebSocket
+// connection, for browser terminals driving the sandbox. Messages are JSON
+// text frames with a "type" field.
+//
+// The client opens with an execute message, then sends stdin as it is
+// typed, and an eof message to close the script's stdin:
+//
+//	{"type": "execute", "script": "read name; echo hi $name"}
+//	{"type": "stdin", "data": "world\n"}
+//	{"type": "eof"}
+//
+// Binary frames are also taken as stdin, for input that is not UTF-8. The
+// server sends whatever the script writes to stdout and stderr as it is
+// written, so prompts without a newline show at once, then the outcome,
+// and closes the connection:
+//
+//	{"type": "stdout", "data": "name: "}
+//	{"type": "stderr", "data": "..."}
+//	{"type": "exit", "id": "...", "exit_code": 0}
+//
+// A chunk may end inside a multi-byte character, which is then replaced in
+// data; binary output is not preserved. An exit message with an "error"
+// field, and an exit_code of -1 if the script did not run, reports an
+// execution that failed. Closing the connection stops the script.
+type WebSocketHandler struct {
+	// Executor runs the scripts, unless Pool is set.
+	Executor *Executor
+	// Pool, if set, supplies an executor of its own to each connection's
+	// script, so connections do not share one. It must create *Executor
+	// executors.
+	Pool *Pool
+	// CheckOrigin reports whether a browser on the request's Origin may
+	// connect. If nil, only requests without an Origin, or from the same
+	// host, are accepted.
+	CheckOrigin func(r *http.Request) bool
+	// MaxMessageBytes limits the size of a message from the client, such
+	// as the script. 0 means 1 MiB.
+	MaxMessageBytes int64
+}
+
+// wsMessage is a message of the WebSocketHandler protocol.
+type wsMessage struct {
+	Type     string `json:"type"`
+	Script   string `json:"script,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Data     string `json:"data,omitempty"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ServeHTTP upgrades r to a WebSocket and runs the script it sends.
+func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !checkOrigin(r, h.CheckOrigin) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	limit := h.MaxMessageBytes
+	if limit <= 0 {
+		limit = defaultMaxWSMessageBytes
+	}
+	conn, err := upgradeWebSocket(w, r, limit)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	op, data, err := conn.readMessage()
+	if err != nil {
+		return
+	}
+	var req wsMessage
+	if op != wsText || json.Unmarshal(data, &req) != nil || req.Type != "execute" {
+		conn.close(wsCloseProtocol, "expected an execute message")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stdin, stdinW := io.Pipe()
+	// Unblocks a stdin write the script never reads.
+	defer stdin.Close()
+	go h.readInput(conn, stdinW, cancel)
+
+	var result *Result
+	exec, release, err := h.executor(ctx)
+	if err == nil {
+		result, err = exec.executeFromReader(ctx, req.Script, stdin, func(stream Stream, chunk []byte, _ int64) {
+			conn.writeJSON(wsMessage{Type: stream.String(), Data: string(chunk)})
+		})
+		release()
+	}
+
+	exit := wsMessage{Type: "exit"}
+	if result != nil {
+		exit.ID, exit.ExitCode = result.ID, &result.ExitCode
+	} else {
+		notRun := -1
+		exit.ExitCode = &notRun
+	}
+	if err != nil {
+		exit.Error = err.Error()
+	}
+	conn.writeJSON(exit)
+}
+
+// executor returns the executor to run a script on, taken from Pool if it
+// is set, and release, to call once the script is done.
+func (h *WebSocketHandler) executor(ctx context.Context) (exec *Executor, release func(), err error) {
+	if h.Pool == nil {
+		return h.Executor, func() {}, nil
+	}
+	shell, err := h.Pool.Acquire(ctx, PriorityNormal)
+	if err != nil {
+		return nil, nil, err
+	}
+	exec, ok := shell.(*Executor)
+	if !ok {
+		h.Pool.Put(shell)
+		return nil, nil, fmt.Errorf("WebSocketHandler.Pool created a %T, not an *Executor", shell)
+	}
+	return exec, func() { h.Pool.Put(shell) }, nil
+}
+
+// readInput copies the stdin messages read from conn to stdin until the
+// client sends eof, then keeps reading until it goes away, when cancel is
+// called.
+func (h *WebSocketHandler) readInput(conn *wsConn, stdin *io.PipeWriter, cancel context.CancelFunc) {
+	defer cancel()
+	defer stdin.Close()
+	for {
+		op, data, err := conn.readMessage()
+		if err != nil {
+			return
+		}
+		if op == wsBinary {
+			stdin.Write(data)
+			continue
+		}
+		var msg wsMessage
+		if json.Unmarshal(data, &msg) != nil {
+			conn.close(wsCloseUnsupported, "invalid message")
+			return
+		}
+		switch msg.Type {
+		case "stdin":
+			stdin.Write([]byte(msg.Data))
+		case "eof":
+			stdin.Close()
+		default:
+			conn.close(wsCloseProtocol, "unexpected message type "+msg.Type)
+			return
+		}
+	}
+}
+
+// checkOrigin applies check to r, or if nil accepts requests without an
+// Origin or from the same host.
+func checkOrigin(r *http.Request, check func(*http.Request) bool) bool {
+	if check != nil {
+		return check(r)
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// writeJSON sends msg as a text frame.
+func (c *wsConn) writeJSON(msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.write(wsText, data)
+}
@@ -0,0 +1,145 @@
+package conch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	for origin, want := range map[string]bool{
+		"":                        true,
+		"http://example.com":      true,
+		"https://EXAMPLE.com":     true,
+		"http://evil.example.org": false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if got := checkOrigin(r, nil); got != want {
+			t.Errorf("checkOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/ws", nil)
+	r.Header.Set("Origin", "http://evil.example.org")
+	if !checkOrigin(r, func(*http.Request) bool { return true }) {
+		t.Error("checkOrigin() ignored its check")
+	}
+}
+
+func TestWebSocketHandlerRejectsOrigin(t *testing.T) {
+	srv := httptest.NewServer(&WebSocketHandler{})
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Origin", "http://evil.example.org")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestWebSocketHandlerExpectsExecute(t *testing.T) {
+	srv := httptest.NewServer(&WebSocketHandler{})
+	defer srv.Close()
+
+	conn, r := dialTestWebSocket(t, srv.URL, nil)
+	writeWSFrame(conn, wsText, []byte(`{"type": "stdin", "data": "x"}`), testMask)
+	_, op, payload, err := readWSFrame(r, 125, false)
+	if err != nil || op != wsClose || binary.BigEndian.Uint16(payload) != wsCloseProtocol {
+		t.Errorf("reply = %#x %q, %v, want a protocol error close", op, payload, err)
+	}
+}
+
+// readWSMessage reads the next message from r, or returns false once the
+// server closes the connection.
+func readWSMessage(t *testing.T, r *bufio.Reader) (wsMessage, bool) {
+	t.Helper()
+	_, op, payload, err := readWSFrame(r, 1<<20, false)
+	if err != nil {
+		t.Fatalf("readWSFrame() error: %v", err)
+	}
+	if op == wsClose {
+		return wsMessage{}, false
+	}
+	var msg wsMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		t.Fatalf("invalid message %q: %v", payload, err)
+	}
+	return msg, true
+}
+
+func TestWebSocketHandlerExecute(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+	srv := httptest.NewServer(&WebSocketHandler{Executor: exec})
+	defer srv.Close()
+
+	conn, r := dialTestWebSocket(t, srv.URL, nil)
+	for _, msg := range []string{
+		`{"type": "execute", "script": "while read line; do echo \"got $line\"; done; echo bye >&2; exit 3"}`,
+		`{"type": "stdin", "data": "one\ntwo\n"}`,
+		`{"type": "eof"}`,
+	} {
+		writeWSFrame(conn, wsText, []byte(msg), testMask)
+	}
+
+	output := map[string]string{}
+	var exit wsMessage
+	for {
+		msg, ok := readWSMessage(t, r)
+		if !ok {
+			break
+		}
+		if msg.Type == "exit" {
+			exit = msg
+			continue
+		}
+		output[msg.Type] += msg.Data
+	}
+	if output["stdout"] != "got one\ngot two\n" || output["stderr"] != "bye\n" {
+		t.Errorf("output = %q", output)
+	}
+	if exit.ExitCode == nil || *exit.ExitCode != 3 || exit.ID == "" {
+		t.Errorf("exit message = %+v", exit)
+	}
+}
+
+func TestWebSocketHandlerPrompt(t *testing.T) {
+	skipIfNoEmbeddedShell(t)
+
+	exec, err := New(WithEmbedded())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer exec.Close()
+	srv := httptest.NewServer(&WebSocketHandler{Executor: exec})
+	defer srv.Close()
+
+	conn, r := dialTestWebSocket(t, srv.URL, nil)
+	writeWSFrame(conn, wsText, []byte(`{"type": "execute", "script": "printf 'name: '; read name; echo \"hi $name\""}`), testMask)
+	// The prompt arrives while the script waits for input.
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if msg, ok := readWSMessage(t, r); !ok || msg.Type != "stdout" || msg.Data != "name: " {
+		t.Fatalf("first message = %+v, want the prompt", msg)
+	}
+	writeWSFrame(conn, wsText, []byte(`{"type": "stdin", "data": "world\n"}`), testMask)
+	if msg, ok := readWSMessage(t, r); !ok || msg.Data != "hi world\n" {
+		t.Errorf("reply = %+v", msg)
+	}
+}